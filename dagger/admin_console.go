@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// adminConsoleSource is the Go admin console, relative to the repository
+// root the pipeline runs from.
+const adminConsoleSource = "packages/admin-console"
+
+const adminConsolePort = 8040
+
+// adminConsoleSession is the session of the job the console's test runs.
+const adminConsoleSession = "admin-console-session"
+
+// Admin Console Container - a web UI over the graph, sessions, tools and
+// agent runs, behind the shared access control
+func buildAdminConsoleContainer(ctx context.Context, client *dagger.Client) *dagger.Container {
+	fmt.Println("🧭 Building Admin Console Container...")
+
+	binary := client.Container().
+		From("golang:1.22-alpine").
+		WithDirectory("/src/admin-console", client.Host().Directory(adminConsoleSource)).
+		WithDirectory("/src/rbac", client.Host().Directory(rbacSource)).
+		WithDirectory("/src/logging", client.Host().Directory(loggingSource)).
+		WithDirectory("/src/tracing", client.Host().Directory(tracingSource)).
+		WithWorkdir("/src/admin-console").
+		WithMountedCache("/root/.cache/go-build", client.CacheVolume("admin-console-go-build")).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithExec([]string{"go", "vet", "./..."}).
+		WithExec([]string{"go", "build", "-o", "/out/admin-console", "."}).
+		File("/out/admin-console")
+
+	return client.Container().
+		From("alpine:3.19").
+		WithFile("/usr/local/bin/admin-console", binary).
+		WithEnvVariable("CONSOLE_PORT", fmt.Sprint(adminConsolePort)).
+		WithExposedPort(adminConsolePort).
+		WithEntrypoint([]string{"/usr/local/bin/admin-console"})
+}
+
+// testAdminConsole runs the console in front of the MCP server, the
+// knowledge graph, session memory and the orchestrator, all with access
+// control on. After the issue_tracker agent runs, an admin signed in to
+// the console finds the run, the session it stored with its summary and
+// the graph's nodes, and registers and removes a tool. The console refuses
+// what it should: calls without a sign in, cookie calls that change
+// something without its header, a read-only bearer's tool changes and a
+// tenant's token reading every tenant's runs.
+func testAdminConsole(ctx context.Context, client *dagger.Client, consoleContainer, mcpServer, knowledgeGraphContainer, sessionMemoryContainer, orchestratorContainer *dagger.Container, neo4j, qdrant, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing Admin Console...")
+
+	secret := client.SetSecret("admin-console-rbac-secret", rbacTestSecret)
+	admin := mintToken(rbacTestSecret, "console-admin", "admin")
+	readOnly := mintToken(rbacTestSecret, "console-reader", "read-only")
+	tenantOperator := mintTenantToken(rbacTestSecret, "console-team-a", "operator", "team-a")
+	forged := mintToken("not-the-secret", "console-forger", "admin")
+
+	graphURL := fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)
+	memoryURL := fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)
+	orchestratorURL := fmt.Sprintf("http://orchestrator:%d", orchestratorPort)
+	graph := withRBAC(client, withGraphServices(knowledgeGraphContainer, neo4j, qdrant), secret, "").
+		WithEnvVariable("KG_PORT", fmt.Sprint(knowledgeGraphPort)).
+		WithExposedPort(knowledgeGraphPort).
+		WithExec([]string{"python3", "/app/kg_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	memory := withRBAC(client, withRedis(sessionMemoryContainer, redis), secret, mintToken(rbacTestSecret, "session-memory", "admin")).
+		WithServiceBinding("knowledge-graph", graph).
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", graphURL).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	mcp := withRBAC(client, mcpServer, secret, mintToken(rbacTestSecret, "mcp-server", "agent")).
+		WithServiceBinding("session-memory", memory).
+		WithEnvVariable("SESSION_MEMORY_URL", memoryURL).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	site := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/api/repos/fixture/repo/issues", dagger.ContainerWithNewFileOpts{Contents: agentFixtureIssues}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "-m", "http.server", "8000", "--directory", "/srv"}).
+		AsService()
+	orchestrator := orchestratorContainer.
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("knowledge-graph", graph).
+		WithServiceBinding("site", site).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", graphURL).
+		WithEnvVariable("AGENT_GITHUB_API", "http://site:8000/api").
+		WithEnvVariable("RBAC_TOKEN", mintToken(rbacTestSecret, "orchestrator", "agent")).
+		WithSecretVariable("ORCH_AGENT_TOKEN", client.SetSecret("admin-console-agent-token", mintToken(rbacTestSecret, "agents", "agent"))).
+		AsService()
+	console := withRBAC(client, consoleContainer, secret, "").
+		WithServiceBinding("knowledge-graph", graph).
+		WithServiceBinding("session-memory", memory).
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("orchestrator", orchestrator).
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", graphURL).
+		WithEnvVariable("SESSION_MEMORY_URL", memoryURL).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("ORCHESTRATOR_URL", orchestratorURL).
+		AsService()
+
+	base := fmt.Sprintf("http://admin-console:%d", adminConsolePort)
+	job := fmt.Sprintf(`{"agent_type": "issue_tracker", "target": "github:fixture/repo", "session_id": "%s"}`, adminConsoleSession)
+	// Each check prints its name and the status it got, and the checks of
+	// what an admin sees print whether they found it. The console's calls
+	// are made with the cookie it signs in with, as a browser's are.
+	script := fmt.Sprintf(`check() { name=$1; shift; echo "$name $(curl -sS -o /dev/null -w '%%{http_code}' "$@")"; }
+find() { name=$1; want=$2; shift 2; if curl -fsS -b /tmp/jar "$@" | grep -q "$want"; then echo "$name found"; else echo "$name missing"; fi; }
+json='Content-Type: application/json'
+check health %[1]s/health
+find page 'Dynamic Context Admin' %[1]s/
+check login-forged -X POST -H "$json" -d '{"token": "%[5]s"}' %[1]s/login
+check login -c /tmp/jar -X POST -H "$json" -d '{"token": "%[2]s"}' %[1]s/login
+check me -b /tmp/jar %[1]s/api/me
+check signed-out %[1]s/api/graph/stats
+
+id=$(curl -fsS -X POST -H "$json" -d '%[7]s' %[6]s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 60); do
+  state=$(curl -fsS %[6]s/jobs/$id)
+  case "$state" in *'"reported":true'*|*'"status":"failed"'*) break;; esac
+  sleep 1
+done
+for i in $(seq 30); do
+  curl -fsS -o /dev/null -b /tmp/jar %[1]s/api/memory/sessions/%[8]s && break
+  sleep 1
+done
+check summarize -b /tmp/jar -X POST -H 'X-Console: 1' %[1]s/api/memory/sessions/%[8]s/summary
+find runs issue_tracker %[1]s/api/orchestrator/runs
+find run-stats issue_tracker %[1]s/api/orchestrator/runs/stats
+find session 'Checkout times out' %[1]s/api/memory/sessions/%[8]s/export
+find summary key_points %[1]s/api/memory/sessions/%[8]s/export
+find graph 'fixture/repo#3' -G --data-urlencode 'q=Checkout times out' --data-urlencode mode=keyword %[1]s/api/graph/search
+find graphs graph_id %[1]s/api/graph/graphs
+
+check register-without-header -b /tmp/jar -X POST -H "$json" -d '{"name": "console-echo", "endpoint": "http://site:8000/"}' %[1]s/api/mcp/tools/register
+check register -b /tmp/jar -X POST -H 'X-Console: 1' -H "$json" -d '{"name": "console-echo", "endpoint": "http://site:8000/"}' %[1]s/api/mcp/tools/register
+find tools console-echo %[1]s/api/mcp/tools
+check read-only-register -X POST -H 'Authorization: Bearer %[3]s' -H "$json" -d '{"name": "console-other", "endpoint": "http://site:8000/"}' %[1]s/api/mcp/tools/register
+check read-only-remove -X DELETE -H 'Authorization: Bearer %[3]s' %[1]s/api/mcp/tools/console-echo
+check read-only-runs -H 'Authorization: Bearer %[3]s' %[1]s/api/orchestrator/runs
+check remove -b /tmp/jar -X DELETE -H 'X-Console: 1' %[1]s/api/mcp/tools/console-echo
+check removed-again -b /tmp/jar -X DELETE -H 'X-Console: 1' %[1]s/api/mcp/tools/console-echo
+check submit-job -b /tmp/jar -X POST -H 'X-Console: 1' -H "$json" -d '%[7]s' %[1]s/api/orchestrator/jobs
+check tenant-runs -H 'Authorization: Bearer %[4]s' %[1]s/api/orchestrator/runs
+check tenant-other -H 'Authorization: Bearer %[4]s' -H 'X-Tenant-ID: team-b' %[1]s/api/mcp/tools
+check logout -b /tmp/jar -c /tmp/jar -X POST %[1]s/logout
+check after-logout -b /tmp/jar %[1]s/api/graph/stats`,
+		base, admin, readOnly, tenantOperator, forged, orchestratorURL, job, adminConsoleSession)
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("admin-console", console).
+		WithServiceBinding("orchestrator", orchestrator).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	want := map[string]string{
+		"health": "200", "page": "found", "login-forged": "401", "login": "200", "me": "200", "signed-out": "401",
+		"summarize": "200", "runs": "found", "run-stats": "found", "session": "found", "summary": "found",
+		"graph": "found", "graphs": "found",
+		"register-without-header": "403", "register": "200", "tools": "found",
+		"read-only-register": "403", "read-only-remove": "403", "read-only-runs": "200",
+		"remove": "200", "removed-again": "404", "submit-job": "405",
+		"tenant-runs": "403", "tenant-other": "403", "logout": "204", "after-logout": "401",
+	}
+	got := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if check, status, ok := strings.Cut(line, " "); ok {
+			got[check] = status
+		}
+	}
+	checks := make([]string, 0, len(want))
+	for check := range want {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		if got[check] != want[check] {
+			return fmt.Errorf("%s answered %q, want %s:\n%s", check, got[check], want[check], output)
+		}
+	}
+
+	fmt.Printf("Admin console: %d checks held over the graph, session memory, the MCP server's tools and agent runs\n", len(want))
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// agentSDKSource is the agent SDK, relative to the repository root the
+// pipeline runs from.
+const agentSDKSource = "packages/agent-sdk"
+
+// testAgentSDK runs the SDK's example agent in Python and Go against the
+// MCP server, and checks that each printed its result and streamed its
+// items over HTTP, then that agents the orchestrator generates pass their
+// own tests.
+func testAgentSDK(ctx context.Context, client *dagger.Client, mcp *dagger.Service, curl *dagger.Container, orchestrator *dagger.File) error {
+	fmt.Println("🧪 Testing Agent SDK...")
+
+	goAgent := client.Container().
+		From("golang:1.22-alpine").
+		WithDirectory("/src", client.Host().Directory(agentSDKSource+"/go")).
+		WithWorkdir("/src").
+		WithMountedCache("/root/.cache/go-build", client.CacheVolume("agent-sdk-go-build")).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithExec([]string{"go", "vet", "./..."}).
+		WithExec([]string{"go", "build", "-o", "/out/line_counter", "./examples/line_counter"}).
+		File("/out/line_counter")
+
+	agents := client.Container().
+		From("python:3.11-slim").
+		WithDirectory("/sdk", client.Host().Directory(agentSDKSource+"/python")).
+		WithExec([]string{"pip", "install", "--no-cache-dir", "/sdk"}).
+		WithFile("/usr/local/bin/line_counter", goAgent).
+		WithServiceBinding("mcp-server", mcp).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("AGENT_SESSION_ID", "sdk-session")
+
+	runs := map[string][]string{
+		"sdk-python": {"python3", "/sdk/examples/line_counter.py", "/sdk"},
+		"sdk-go":     {"line_counter", "/sdk"},
+	}
+	for stream, command := range runs {
+		output, err := agents.
+			WithEnvVariable("AGENT_JOB_ID", stream).
+			WithExec(command).
+			Stdout(ctx)
+		if err != nil {
+			return fmt.Errorf("%s agent: %w", stream, err)
+		}
+		_, result, found := strings.Cut(output, "\n{")
+		var parsed struct {
+			AgentType string         `json:"agent_type"`
+			Context   map[string]any `json:"context"`
+		}
+		if !found || json.Unmarshal([]byte("{"+result), &parsed) != nil {
+			return fmt.Errorf("%s agent printed no JSON result: %s", stream, output)
+		}
+		if parsed.AgentType != "line_counter" || parsed.Context["files"] == nil {
+			return fmt.Errorf("%s agent printed an unexpected result: %s", stream, output)
+		}
+	}
+
+	status, err := agents.
+		WithExec([]string{"sh", "-c", "python3 /sdk/examples/line_counter.py /missing >/dev/null 2>&1; echo $?"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(status) != "2" {
+		return fmt.Errorf("agent given a missing directory exited with status %s, want 2", strings.TrimSpace(status))
+	}
+
+	output, err := curl.
+		WithExec([]string{"curl", "-fsS", "http://mcp-server:3000/agents/streams"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var streams struct {
+		Streams []struct {
+			StreamID string `json:"stream_id"`
+			Items    int    `json:"items"`
+			Status   string `json:"status"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(output), &streams); err != nil {
+		return fmt.Errorf("unexpected streams response %q: %w", output, err)
+	}
+	for stream := range runs {
+		ok := false
+		for _, s := range streams.Streams {
+			ok = ok || (s.StreamID == stream && s.Items > 0 && s.Status == "succeeded")
+		}
+		if !ok {
+			return fmt.Errorf("%s agent did not stream its items: %s", stream, output)
+		}
+	}
+
+	if err := testAgentScaffold(ctx, client, agents, orchestrator); err != nil {
+		return err
+	}
+
+	fmt.Printf("Agent SDK: Python and Go agents streamed %d streams\n", len(runs))
+	return nil
+}
+
+// testAgentScaffold generates a Python and a Go agent with `orchestrator
+// gen agent`, against this tree's SDK rather than the published one, and
+// runs their tests and checks their manifests.
+func testAgentScaffold(ctx context.Context, client *dagger.Client, python *dagger.Container, orchestrator *dagger.File) error {
+	_, err := python.
+		WithFile("/usr/local/bin/orchestrator", orchestrator).
+		WithWorkdir("/work").
+		WithExec([]string{"orchestrator", "gen", "agent", "scaffold_py"}).
+		WithWorkdir("/work/scaffold_py").
+		WithExec([]string{"python3", "-m", "unittest", "-v"}).
+		WithExec([]string{"orchestrator", "manifests", "validate", "agent.json"}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("generated python agent: %w", err)
+	}
+
+	_, err = client.Container().
+		From("golang:1.22-alpine").
+		WithFile("/usr/local/bin/orchestrator", orchestrator).
+		WithDirectory("/sdk", client.Host().Directory(agentSDKSource+"/go")).
+		WithWorkdir("/work").
+		WithExec([]string{"orchestrator", "gen", "agent", "scaffold-go", "--lang", "go"}).
+		WithWorkdir("/work/scaffold-go").
+		WithExec([]string{"go", "mod", "edit", "-replace", "github.com/jayp41/dynamic-context-mcp-system/packages/agent-sdk/go=/sdk",
+			"-require", "github.com/jayp41/dynamic-context-mcp-system/packages/agent-sdk/go@v0.0.0"}).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithExec([]string{"go", "test", "./..."}).
+		WithExec([]string{"orchestrator", "manifests", "validate", "agent.json"}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("generated go agent: %w", err)
+	}
+	return nil
+}
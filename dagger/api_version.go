@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// apiVersionSource is the shared API versioning, relative to the repository
+// root the pipeline runs from.
+const apiVersionSource = "packages/apiversion"
+
+// apiCompatibility are the requests each service must answer the same with
+// and without the /v1 prefix, by its base URL. None of them changes what it
+// reads, so the two answers can be compared.
+var apiCompatibility = []struct {
+	base  string
+	paths []string
+}{
+	{"http://mcp-server:3000", []string{"/agents/streams", "/agents/quarantine", "/memory/sessions/api-versions-missing"}},
+	{fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort), []string{"/stats", "/graphs", "/nodes/api-versions-missing"}},
+	{fmt.Sprintf("http://kg-go:%d", knowledgeGraphPort), []string{"/stats", "/nodes/api-versions-missing"}},
+	{fmt.Sprintf("http://session-memory:%d", sessionMemoryPort), []string{"/sessions/api-versions-missing"}},
+}
+
+// apiCompatibilityScript checks a service against the versioning every one
+// shares, printing a FAIL line for each check that does not hold and the
+// number that did last.
+const apiCompatibilityScript = `set -u
+n=0
+check() { if [ "$1" = "$2" ]; then n=$((n+1)); else echo "FAIL $3: got '$1', want '$2'"; fi; }
+header() { grep -i "^$2:" "$1.head" | head -1 | cut -d' ' -f2- | tr -d '\r'; }
+fetch() { out=$1 url=$2; shift 2; curl -sS -o "$out.body" -D "$out.head" -w '%{http_code}' "$@" "$url"; }
+
+# The same answer with and without the prefix, the latter deprecated, and
+# not deprecated when the version is asked for in the header
+compat() {
+  check "$(fetch /tmp/v1 "$1/v1$2")" "$(fetch /tmp/none "$1$2")" "$1$2 status"
+  cmp -s /tmp/none.body /tmp/v1.body; check $? 0 "$1$2 body"
+  check "$(header /tmp/none Deprecation)" "@1792108800" "$1$2 Deprecation"
+  check "$(header /tmp/none Sunset)" "Fri, 30 Apr 2027 00:00:00 GMT" "$1$2 Sunset"
+  check "$(header /tmp/none Link)" "</v1$2>; rel=\"successor-version\"" "$1$2 Link"
+  check "$(header /tmp/none API-Version)" 1 "$1$2 API-Version"
+  check "$(header /tmp/v1 Deprecation)" "" "$1/v1$2 Deprecation"
+  check "$(header /tmp/v1 API-Version)" 1 "$1/v1$2 API-Version"
+  fetch /tmp/asked "$1$2" -H 'API-Version: 1' >/dev/null
+  check "$(header /tmp/asked Deprecation)" "" "$1$2 with API-Version: 1 Deprecation"
+}
+
+# Probes are not deprecated, versions that are not served are refused and
+# /versions describes the ones that are
+negotiate() {
+  check "$(fetch /tmp/health "$1/health")" 200 "$1/health status"
+  check "$(header /tmp/health Deprecation)" "" "$1/health Deprecation"
+  check "$(fetch /tmp/health "$1/v1/health")" 200 "$1/v1/health status"
+  check "$(fetch /tmp/refused "$1/health" -H 'API-Version: 2')" 406 "$1 with API-Version: 2"
+  check "$(fetch /tmp/refused "$1/v2/health")" 406 "$1/v2/health"
+  check "$(fetch /tmp/versions "$1/versions")" 200 "$1/versions status"
+  grep -q '"current": *"1"' /tmp/versions.body; check $? 0 "$1/versions current"
+  grep -q '"sunset": *"2027-04-30T00:00:00Z"' /tmp/versions.body; check $? 0 "$1/versions sunset"
+}
+`
+
+// testAPIVersions runs the compatibility suite against the MCP server, both
+// knowledge graphs and session memory: every route answers the same under
+// /v1, the paths without a version are deprecated, and a version that is
+// not served is refused.
+func testAPIVersions(ctx context.Context, client *dagger.Client, mcpServer *dagger.Container, sessionMemory, knowledgeGraph, goKnowledgeGraph *dagger.Service) error {
+	fmt.Println("🧪 Testing API Versions...")
+
+	mcp := mcpServer.
+		WithServiceBinding("session-memory", sessionMemory).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	script := apiCompatibilityScript
+	for _, service := range apiCompatibility {
+		script += fmt.Sprintf("negotiate %s\n", service.base)
+		for _, path := range service.paths {
+			script += fmt.Sprintf("compat %s %s\n", service.base, path)
+		}
+	}
+	// Writes go through the prefix too
+	script += fmt.Sprintf(`check "$(fetch /tmp/put "http://session-memory:%d/v1/sessions/api-versions-session" -X PUT -H 'Content-Type: application/json' -d '{"tools_used": ["dagger"]}')" 200 "PUT /v1/sessions"
+echo "checked $n"
+`, sessionMemoryPort)
+
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithServiceBinding("kg-go", goKnowledgeGraph).
+		WithServiceBinding("session-memory", sessionMemory).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(output, "FAIL") {
+		return fmt.Errorf("API versions are not compatible:\n%s", output)
+	}
+
+	fmt.Printf("API Versions: %s\n", strings.TrimSpace(output))
+	return nil
+}
+
+// apiVersionPy is the Python side of packages/apiversion.
+const apiVersionPy = `#!/usr/bin/env python3
+"""API versioning shared by the services of the dynamic context system: the
+same /v<version> prefixes, negotiation and deprecation headers as
+packages/apiversion and api_version.js.
+
+/v1/... is served as the route without the prefix, so the app's routes and
+its other middleware see the same paths either way. A path without a
+version is served as the one in the API-Version header or else as CURRENT,
+the latter with Deprecation, Sunset and a Link to its successor. Every
+response names the version it was served as in API-Version, a version that
+is not supported answers 406, and GET /versions describes them."""
+import json
+import re
+from datetime import datetime, timezone
+from email.utils import format_datetime
+
+HEADER = "API-Version"
+CURRENT = "1"
+SUPPORTED = ["1"]
+
+# Unversioned paths are deprecated since the one and may stop being served
+# after the other
+UNVERSIONED_DEPRECATED = datetime(2026, 10, 16, tzinfo=timezone.utc)
+UNVERSIONED_SUNSET = datetime(2027, 4, 30, tzinfo=timezone.utc)
+
+_PREFIX = re.compile(r"^/v([0-9]+)(/.*)?$")
+
+# The routes probes call, which stay unversioned without being deprecated
+_EXEMPT = ("/health", "/metrics", "/versions")
+
+
+def split(path):
+    """A path's version and the path without it, or None and the path"""
+    match = _PREFIX.match(path)
+    if not match:
+        return None, path
+    return match.group(1), match.group(2) or "/"
+
+
+def unsupported(version):
+    return f"API version {json.dumps(version)} is not supported; supported versions are {', '.join(SUPPORTED)}"
+
+
+def describe():
+    """What GET /versions answers"""
+    return {
+        "current": CURRENT,
+        "supported": SUPPORTED,
+        "unversioned": {
+            "served_as": CURRENT,
+            "deprecated": UNVERSIONED_DEPRECATED.strftime("%Y-%m-%dT%H:%M:%SZ"),
+            "sunset": UNVERSIONED_SUNSET.strftime("%Y-%m-%dT%H:%M:%SZ"),
+        },
+    }
+
+
+def _encode(headers):
+    return [(name.lower().encode("latin-1"), value.encode("latin-1")) for name, value in headers]
+
+
+async def _send_json(send, status, body, headers):
+    payload = json.dumps(body).encode()
+    await send({"type": "http.response.start", "status": status,
+                "headers": _encode([("Content-Type", "application/json"),
+                                    ("Content-Length", str(len(payload)))] + headers)})
+    await send({"type": "http.response.body", "body": payload})
+
+
+class Versioned:
+    """ASGI middleware that versions the app behind it"""
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            return await self.app(scope, receive, send)
+        method, path = scope["method"], scope["path"]
+        version, rest = split(path)
+        prefixed = version is not None
+        if not prefixed and method == "GET" and path == "/versions":
+            return await _send_json(send, 200, describe(), [])
+
+        deprecated = False
+        if not prefixed:
+            version = dict(scope["headers"]).get(HEADER.lower().encode(), b"").decode("latin-1")
+            if not version:
+                version = CURRENT
+                deprecated = not (method in ("GET", "HEAD") and path in _EXEMPT)
+        if version not in SUPPORTED:
+            return await _send_json(send, 406, {"detail": unsupported(version), "supported": SUPPORTED},
+                                    [(HEADER, CURRENT)])
+
+        headers = [(HEADER, version)]
+        if deprecated:
+            headers += [("Deprecation", f"@{int(UNVERSIONED_DEPRECATED.timestamp())}"),
+                        ("Sunset", format_datetime(UNVERSIONED_SUNSET, usegmt=True)),
+                        ("Link", f'</v{CURRENT}{path}>; rel="successor-version"')]
+        if prefixed:
+            scope = dict(scope, path=rest, raw_path=rest.encode())
+        headers = _encode(headers)
+
+        async def send_versioned(message):
+            if message["type"] == "http.response.start":
+                message = dict(message, headers=list(message.get("headers", [])) + headers)
+            await send(message)
+
+        await self.app(scope, receive, send_versioned)
+
+
+def install(app):
+    """Versions a FastAPI app's routes. Call it after the other middleware,
+    so it comes before them and they see paths without the prefix."""
+    app.add_middleware(Versioned)
+`
+
+// apiVersionJs is the MCP server's side of packages/apiversion.
+const apiVersionJs = `// API versioning shared by the services of the dynamic context system: the
+// same /v<version> prefixes, negotiation and deprecation headers as
+// packages/apiversion and api_version.py.
+const HEADER = 'API-Version';
+const CURRENT = '1';
+const SUPPORTED = ['1'];
+
+// Unversioned paths are deprecated since the one and may stop being served
+// after the other
+const UNVERSIONED_DEPRECATED = new Date(Date.UTC(2026, 9, 16));
+const UNVERSIONED_SUNSET = new Date(Date.UTC(2027, 3, 30));
+
+const PREFIX = /^\/v([0-9]+)(\/.*)?$/;
+
+// The routes probes call, which stay unversioned without being deprecated
+const EXEMPT = ['/health', '/metrics', '/versions'];
+
+// A path's version and the path without it, or null and the path
+function split(path) {
+    const match = PREFIX.exec(path);
+    if (!match) {
+        return [null, path];
+    }
+    return [match[1], match[2] || '/'];
+}
+
+function unsupported(version) {
+    return 'API version ' + JSON.stringify(version) + ' is not supported; supported versions are ' + SUPPORTED.join(', ');
+}
+
+function timestamp(date) {
+    return date.toISOString().replace('.000Z', 'Z');
+}
+
+// What GET /versions answers
+function describe() {
+    return {
+        current: CURRENT,
+        supported: SUPPORTED,
+        unversioned: {
+            served_as: CURRENT,
+            deprecated: timestamp(UNVERSIONED_DEPRECATED),
+            sunset: timestamp(UNVERSIONED_SUNSET)
+        }
+    };
+}
+
+// Serves /v1/... as the route without the prefix, so the routes and
+// middleware after it see the same paths either way. A path without a
+// version is served as the one in the API-Version header or else as
+// CURRENT, the latter with Deprecation, Sunset and a Link to its
+// successor. Every response names the version it was served as, and a
+// version that is not supported answers 406 with errorKey holding why. Use
+// it before the other middleware.
+function middleware(errorKey = 'error') {
+    return (req, res, next) => {
+        const path = req.path;
+        let [version, rest] = split(path);
+        const prefixed = version !== null;
+        if (!prefixed && req.method === 'GET' && path === '/versions') {
+            return res.json(describe());
+        }
+
+        let deprecated = false;
+        if (!prefixed) {
+            version = req.get(HEADER);
+            if (!version) {
+                version = CURRENT;
+                deprecated = !(['GET', 'HEAD'].includes(req.method) && EXEMPT.includes(path));
+            }
+        }
+        if (!SUPPORTED.includes(version)) {
+            res.set(HEADER, CURRENT);
+            return res.status(406).json({ [errorKey]: unsupported(version), supported: SUPPORTED });
+        }
+
+        res.set(HEADER, version);
+        if (deprecated) {
+            res.set('Deprecation', '@' + Math.floor(UNVERSIONED_DEPRECATED.getTime() / 1000));
+            res.set('Sunset', UNVERSIONED_SUNSET.toUTCString());
+            res.set('Link', '</v' + CURRENT + path + '>; rel="successor-version"');
+        }
+        if (prefixed) {
+            req.url = rest + req.url.slice(path.length);
+        }
+        next();
+    };
+}
+
+module.exports = { HEADER, CURRENT, SUPPORTED, split, describe, middleware };
+`
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// backupTopology is a deployment of the MCP server, graph and session
+// memory, all watched rather than started, at the hosts they are bound to
+// with prefix.
+func backupTopology(prefix string) string {
+	return fmt.Sprintf(`{"services": [
+  {"name": "session-memory", "kind": "memory", "url": "http://%[1]s-memory:%[2]d"},
+  {"name": "graph", "kind": "graph", "url": "http://%[1]s-graph:%[3]d"},
+  {"name": "mcp-server", "kind": "mcp", "url": "http://%[1]s-mcp:3000"}
+]}`, prefix, sessionMemoryPort, knowledgeGraphPort)
+}
+
+// testBackup fills a deployment with a session, a graph node and a tool,
+// backs it up with the control plane and restores the archive to a fresh
+// deployment, which must then have all three. A tampered archive must be
+// refused before anything is restored.
+func testBackup(ctx context.Context, client *dagger.Client, controlPlane, goKnowledgeGraph, mcpServer, sessionMemory *dagger.Container, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing Backup and Restore...")
+
+	// The source keeps sessions in Redis, the fresh deployment in SQLite;
+	// DEPLOYMENT keeps the two otherwise identical services apart
+	deployment := func(name string, memory *dagger.Container) (*dagger.Service, *dagger.Service, *dagger.Service) {
+		memoryService := memory.
+			WithEnvVariable("DEPLOYMENT", name).
+			WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+			WithExposedPort(sessionMemoryPort).
+			WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+			AsService()
+		graph := goKnowledgeGraph.
+			WithEnvVariable("DEPLOYMENT", name).
+			WithEnvVariable("KG_PORT", fmt.Sprint(knowledgeGraphPort)).
+			WithExposedPort(knowledgeGraphPort).
+			AsService()
+		mcp := mcpServer.
+			WithEnvVariable("DEPLOYMENT", name).
+			WithExposedPort(3000).
+			WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+			AsService()
+		return memoryService, graph, mcp
+	}
+	sourceMemory, sourceGraph, sourceMCP := deployment("source", withRedis(sessionMemory, redis))
+	freshMemory, freshGraph, freshMCP := deployment("fresh", withSQLite(sessionMemory))
+
+	script := fmt.Sprintf(`set -e
+curl -fsS -X PUT -H 'Content-Type: application/json' -d '{"tools_used": ["dagger"]}' http://source-memory:%[1]d/sessions/backup-session >/dev/null
+curl -fsS -X POST -H 'Content-Type: application/json' -d '{"data": {"title": "backed up"}}' http://source-graph:%[2]d/nodes >/dev/null
+curl -fsS -X POST -H 'Content-Type: application/json' -d '{"name": "backup-tool", "endpoint": "http://tool:8000"}' http://source-mcp:3000/tools/register >/dev/null
+control-plane backup /app/source.json /tmp/backup.tar.gz >/dev/null
+tar tzf /tmp/backup.tar.gz | sort | tr '\n' ' '; echo
+# A part that no longer matches the manifest stops the restore up front
+mkdir /tmp/tampered && tar xzf /tmp/backup.tar.gz -C /tmp/tampered && echo '{}' > /tmp/tampered/tools.json
+(cd /tmp/tampered && tar czf /tmp/tampered.tar.gz manifest.json graph.jsonl memory.snapshot.json.gz tools.json)
+if control-plane restore /app/fresh.json /tmp/tampered.tar.gz 2>/tmp/refused; then echo ACCEPTED; else cat /tmp/refused; fi
+curl -sS -o /dev/null -w '%%{http_code}\n' http://fresh-memory:%[1]d/sessions/backup-session
+control-plane restore /app/fresh.json /tmp/backup.tar.gz >/dev/null
+curl -fsS http://fresh-memory:%[1]d/sessions/backup-session; echo
+curl -fsS 'http://fresh-graph:%[2]d/search?q=backed&mode=keyword'; echo
+curl -fsS http://fresh-mcp:3000/backup`, sessionMemoryPort, knowledgeGraphPort)
+
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithFile("/usr/local/bin/control-plane", controlPlane.File("/usr/local/bin/control-plane")).
+		WithNewFile("/app/source.json", dagger.ContainerWithNewFileOpts{Contents: backupTopology("source")}).
+		WithNewFile("/app/fresh.json", dagger.ContainerWithNewFileOpts{Contents: backupTopology("fresh")}).
+		WithServiceBinding("source-memory", sourceMemory).
+		WithServiceBinding("source-graph", sourceGraph).
+		WithServiceBinding("source-mcp", sourceMCP).
+		WithServiceBinding("fresh-memory", freshMemory).
+		WithServiceBinding("fresh-graph", freshGraph).
+		WithServiceBinding("fresh-mcp", freshMCP).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 6 {
+		return fmt.Errorf("unexpected backup test output %q", output)
+	}
+	files, refused, before, session, search, tools := lines[0], lines[1], lines[2], lines[3], lines[4], lines[5]
+	if files != "graph.jsonl manifest.json memory.snapshot.json.gz tools.json " {
+		return fmt.Errorf("backup archive holds %q", files)
+	}
+	if !strings.Contains(refused, "does not match its checksum") || before != "404" {
+		return fmt.Errorf("tampered backup was not refused before restoring: %s, session %s", refused, before)
+	}
+	if !strings.Contains(session, `"dagger"`) {
+		return fmt.Errorf("session was not restored: %s", session)
+	}
+	if !strings.Contains(search, `"backed up"`) {
+		return fmt.Errorf("graph node was not restored: %s", search)
+	}
+	if !strings.Contains(tools, `"backup-tool"`) {
+		return fmt.Errorf("tool registry was not restored: %s", tools)
+	}
+
+	fmt.Println("Backup: a session, a graph node and a tool restored to a fresh deployment")
+	return nil
+}
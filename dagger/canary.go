@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const canaryProxyPort = 3100
+
+// canaryTopology watches the MCP server with a canary that takes half its
+// invocations, judged on the first five.
+var canaryTopology = fmt.Sprintf(`{"services": [
+  {"name": "mcp-server", "kind": "mcp", "url": "http://mcp-server:3000",
+   "canary": {"url": "http://mcp-canary:3000", "proxy": "http://control-plane:%d", "weight": 50, "min_requests": 5}}
+]}`, canaryProxyPort)
+
+// testCanary puts a broken release of the MCP server, one that fails every
+// invocation, behind the control plane as a canary. It must be rolled back
+// once it has failed its first invocations, after which every invocation
+// goes to the stable release.
+func testCanary(ctx context.Context, client *dagger.Client, container, mcpServer *dagger.Container) error {
+	fmt.Println("🧪 Testing Canary Rollout...")
+
+	stable := mcpServer.
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	// Answers every POST with 501
+	broken := client.Container().
+		From("python:3.11-slim").
+		WithExposedPort(3000).
+		WithExec([]string{"python3", "-m", "http.server", "3000"}).
+		AsService()
+	controlPlane := container.
+		WithServiceBinding("mcp-server", stable).
+		WithServiceBinding("mcp-canary", broken).
+		WithNewFile("/app/topology.json", dagger.ContainerWithNewFileOpts{Contents: canaryTopology}).
+		WithEnvVariable("CONTROL_TOPOLOGY", "/app/topology.json").
+		WithEnvVariable("CONTROL_CHECK_INTERVAL", "1").
+		WithExposedPort(canaryProxyPort).
+		AsService()
+
+	// Unknown tools answer 404 from the stable release, which is not a
+	// failure, and 501 from the canary, which is
+	base := fmt.Sprintf("http://control-plane:%d", controlPlanePort)
+	script := fmt.Sprintf(`for i in $(seq 60); do
+  case "$(curl -fsS %[1]s/status)" in '{"status":"healthy"'*) break;; esac
+  sleep 1
+done
+for i in $(seq 40); do curl -sS -o /dev/null -w '%%{http_code} ' -X POST http://control-plane:%[2]d/api/no-such-tool; done; echo
+for i in $(seq 10); do curl -sS -o /dev/null -w '%%{http_code} ' -X POST http://control-plane:%[2]d/api/no-such-tool; done; echo
+curl -fsS %[1]s/canary`, base, canaryProxyPort)
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("control-plane", controlPlane).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitN(output, "\n", 3)
+	if len(lines) < 3 {
+		return fmt.Errorf("unexpected canary test output %q", output)
+	}
+	during, after, statusJSON := lines[0], lines[1], lines[2]
+	var status struct {
+		State  string `json:"state"`
+		Reason string `json:"reason"`
+		Canary struct {
+			Invocations int `json:"invocations"`
+			Errors      int `json:"errors"`
+		} `json:"canary"`
+	}
+	if err := json.Unmarshal([]byte(statusJSON), &status); err != nil {
+		return fmt.Errorf("unexpected canary response %q: %w", statusJSON, err)
+	}
+	if status.State != "rolled-back" || status.Canary.Invocations != 5 || status.Canary.Errors != 5 {
+		return fmt.Errorf("failing canary was not rolled back after its first five invocations: %s", statusJSON)
+	}
+	if !strings.Contains(during, "404") || strings.TrimSpace(strings.ReplaceAll(after, "404", "")) != "" {
+		return fmt.Errorf("invocations did not all go to the stable release once the canary was rolled back: %s / %s", during, after)
+	}
+
+	fmt.Printf("Canary: rolled back (%s)\n", status.Reason)
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// toxiproxyImage puts latency between the services in the chaos test.
+const toxiproxyImage = "ghcr.io/shopify/toxiproxy:2.9.0"
+
+// chaosPort is where the chaos proxy listens for a service's port.
+func chaosPort(port int) int {
+	return 10000 + port
+}
+
+// chaosProxies are what the chaos proxy stands in front of: session memory
+// for the MCP server, and the knowledge graph for the agents. Each is named
+// in the proxy's API as the service.
+var chaosProxies = fmt.Sprintf(`[
+  {"name": "session-memory", "listen": "0.0.0.0:%d", "upstream": "session-memory:%d", "enabled": true},
+  {"name": "knowledge-graph", "listen": "0.0.0.0:%d", "upstream": "knowledge-graph:%d", "enabled": true}
+]`, chaosPort(sessionMemoryPort), sessionMemoryPort, chaosPort(knowledgeGraphPort), knowledgeGraphPort)
+
+// chaosKills are when session memory is killed, counted from the start of
+// the traffic, and how long it stays down before it is started again.
+var chaosKills = []struct{ after, down time.Duration }{
+	{10 * time.Second, 5 * time.Second},
+	{30 * time.Second, 5 * time.Second},
+}
+
+// chaosScript runs the traffic of the chaos test while session memory is
+// killed under it: a write through the MCP server every second, and an
+// issue_tracker job every eight, all over a slowed network. Once the chaos
+// is over it waits for the system to recover and prints a line for each
+// write's status, each job, each job's session and each write read back.
+const chaosScript = `set -u
+toxic() { curl -fsS -X POST -H 'Content-Type: application/json' -d "{\"name\": \"latency\", \"type\": \"latency\", \"attributes\": {\"latency\": $2, \"jitter\": $3}}" http://chaos:8474/proxies/$1/toxics > /dev/null; }
+toxic session-memory 300 100
+toxic knowledge-graph 200 50
+
+ids=""
+for i in $(seq 45); do
+  if [ $((i %% 8)) = 1 ]; then
+    ids="$ids $(curl -fsS -X POST -H 'Content-Type: application/json' -d "{\"agent_type\": \"issue_tracker\", \"target\": \"github:fixture/repo\", \"session_id\": \"chaos-job-$i\"}" %[1]s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')"
+  fi
+  echo "write $i $(curl -sS -o /dev/null -w '%%{http_code}' --max-time 5 -X PUT -H 'Content-Type: application/json' -d "{\"seq\": $i, \"writer\": \"chaos\"}" http://mcp-server:3000/memory/sessions/chaos-write-$i)"
+  sleep 1
+done
+
+curl -fsS -X DELETE http://chaos:8474/proxies/session-memory/toxics/latency
+curl -fsS -X DELETE http://chaos:8474/proxies/knowledge-graph/toxics/latency
+# Recovered once the MCP server reaches session memory again
+for i in $(seq 60); do
+  [ "$(curl -sS -o /dev/null -w '%%{http_code}' http://mcp-server:3000/memory/sessions/chaos-probe)" = 404 ] && break
+  sleep 1
+done
+for id in $ids; do
+  for i in $(seq 120); do
+    job=$(curl -fsS %[1]s/jobs/$id)
+    case "$job" in *'"reported":true'*|*'"status":"failed"'*) break;; esac
+    sleep 1
+  done
+  echo "job $job"
+done
+for i in $(seq 45); do
+  [ $((i %% 8)) = 1 ] && echo "session chaos-job-$i $(curl -sS http://mcp-server:3000/memory/sessions/chaos-job-$i)"
+  echo "read $i $(curl -sS -o /tmp/read-$i -w '%%{http_code}' http://mcp-server:3000/memory/sessions/chaos-write-$i) $(cat /tmp/read-$i 2>/dev/null)"
+done
+echo "health $(curl -fsS http://mcp-server:3000/health)"`
+
+// testChaos kills session memory twice in the middle of end-to-end
+// traffic, with latency between the services, and checks the system
+// degrades gracefully rather than corrupting state: the MCP server answers
+// 502 or 503 while session memory is down, its circuit opens and closes
+// again, every job still succeeds and is reported into its session, and
+// every write it acknowledged reads back as written. It only runs when
+// CHAOS is set on the host, as it takes a few minutes.
+func testChaos(ctx context.Context, client *dagger.Client, orchestratorContainer, mcpServerContainer, sessionMemoryContainer *dagger.Container, knowledgeGraph, redis *dagger.Service) error {
+	if os.Getenv("CHAOS") == "" {
+		fmt.Println("⏭️ Skipping chaos test: CHAOS is not set")
+		return nil
+	}
+	fmt.Println("🧪 Testing Chaos...")
+
+	memory := withRedis(sessionMemoryContainer, redis).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	chaos := client.Container().
+		From(toxiproxyImage).
+		WithNewFile("/etc/toxiproxy.json", dagger.ContainerWithNewFileOpts{Contents: chaosProxies}).
+		WithServiceBinding("session-memory", memory).
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithExposedPort(8474).
+		WithExposedPort(chaosPort(sessionMemoryPort)).
+		WithExposedPort(chaosPort(knowledgeGraphPort)).
+		WithExec([]string{"/toxiproxy", "-host=0.0.0.0", "-config=/etc/toxiproxy.json"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	site := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/api/repos/fixture/repo/issues", dagger.ContainerWithNewFileOpts{Contents: agentFixtureIssues}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "-m", "http.server", "8000", "--directory", "/srv"}).
+		AsService()
+	// A short cooldown, so the circuit is seen to close again within the
+	// test
+	mcp := mcpServerContainer.
+		WithServiceBinding("chaos", chaos).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://chaos:%d", chaosPort(sessionMemoryPort))).
+		WithEnvVariable("MEMORY_BREAKER_THRESHOLD", "3").
+		WithEnvVariable("MEMORY_BREAKER_COOLDOWN", "3").
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	orchestrator := orchestratorContainer.
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("chaos", chaos).
+		WithServiceBinding("site", site).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", fmt.Sprintf("http://chaos:%d", chaosPort(knowledgeGraphPort))).
+		WithEnvVariable("AGENT_GITHUB_API", "http://site:8000/api").
+		AsService()
+
+	// Everything is up before the traffic starts, so the kills land in the
+	// middle of it
+	for _, service := range []*dagger.Service{memory, chaos, mcp, orchestrator} {
+		if _, err := service.Start(ctx); err != nil {
+			return fmt.Errorf("starting chaos services: %w", err)
+		}
+	}
+
+	type traffic struct {
+		output string
+		err    error
+	}
+	done := make(chan traffic, 1)
+	go func() {
+		output, err := client.Container().
+			From("curlimages/curl:8.5.0").
+			WithServiceBinding("orchestrator", orchestrator).
+			WithServiceBinding("mcp-server", mcp).
+			WithServiceBinding("chaos", chaos).
+			WithExec([]string{"sh", "-c", fmt.Sprintf(chaosScript, fmt.Sprintf("http://orchestrator:%d", orchestratorPort))}).
+			Stdout(ctx)
+		done <- traffic{output, err}
+	}()
+
+	start := time.Now()
+	for _, kill := range chaosKills {
+		time.Sleep(time.Until(start.Add(kill.after)))
+		fmt.Printf("Chaos: killing session memory for %s\n", kill.down)
+		if _, err := memory.Stop(ctx, dagger.ServiceStopOpts{Kill: true}); err != nil {
+			return fmt.Errorf("killing session memory: %w", err)
+		}
+		time.Sleep(kill.down)
+		if _, err := memory.Start(ctx); err != nil {
+			return fmt.Errorf("restarting session memory: %w", err)
+		}
+	}
+	result := <-done
+	if result.err != nil {
+		return result.err
+	}
+	return checkChaos(result.output)
+}
+
+// checkChaos checks the lines chaosScript printed.
+func checkChaos(output string) error {
+	acknowledged := map[string]bool{}
+	unavailable, jobs := 0, 0
+	var health string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		kind, rest, _ := strings.Cut(line, " ")
+		switch kind {
+		case "write":
+			seq, status, _ := strings.Cut(rest, " ")
+			switch status {
+			case "200":
+				acknowledged[seq] = true
+			case "502", "503", "000":
+				// Session memory down, the circuit open or the request
+				// timed out: all graceful
+				if status == "503" {
+					unavailable++
+				}
+			default:
+				return fmt.Errorf("MCP server answered write %s with %s while session memory was down", seq, status)
+			}
+
+		case "job":
+			jobs++
+			var job struct {
+				ID       string `json:"id"`
+				Status   string `json:"status"`
+				Reported bool   `json:"reported"`
+			}
+			if err := json.Unmarshal([]byte(rest), &job); err != nil {
+				return fmt.Errorf("unexpected job response %q: %w", rest, err)
+			}
+			if job.Status != "succeeded" || !job.Reported {
+				return fmt.Errorf("job %s did not succeed and get reported through the chaos: %s", job.ID, rest)
+			}
+
+		case "session":
+			session, body, _ := strings.Cut(rest, " ")
+			if !strings.Contains(body, "fixture/repo#3") {
+				return fmt.Errorf("session %s lost its job's result: %s", session, body)
+			}
+
+		case "read":
+			seq, rest, _ := strings.Cut(rest, " ")
+			status, body, _ := strings.Cut(rest, " ")
+			if status == "404" && !acknowledged[seq] {
+				// Never written, which the writer was told
+				continue
+			}
+			var stored struct {
+				Seq    json.Number `json:"seq"`
+				Writer string      `json:"writer"`
+			}
+			if status != "200" || json.Unmarshal([]byte(body), &stored) != nil || stored.Seq.String() != seq || stored.Writer != "chaos" {
+				return fmt.Errorf("write %s reads back as %s %s", seq, status, body)
+			}
+
+		case "health":
+			health = rest
+		}
+	}
+	if len(acknowledged) == 0 || jobs == 0 {
+		return fmt.Errorf("unexpected chaos output %q", output)
+	}
+	if unavailable == 0 {
+		return fmt.Errorf("the MCP server's session memory circuit never opened: %s", output)
+	}
+	if !strings.Contains(health, `"session_memory":"closed"`) {
+		return fmt.Errorf("the MCP server's session memory circuit did not close again: %s", health)
+	}
+
+	fmt.Printf("Chaos: %d jobs reported and %d acknowledged writes intact through %d session memory kills; %d writes refused while its circuit was open\n",
+		jobs, len(acknowledged), len(chaosKills), unavailable)
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"plugin"
+	"slices"
+	"sort"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// ComponentBuilder is a component of the pipeline: how its container is
+// built, tested and published. Its methods take only Dagger's types and
+// the standard library's, so a builder from outside this package, such as
+// a Go plugin's, implements it as well as one of its own files does.
+type ComponentBuilder interface {
+	// Name is the component's, which its container has in built and its
+	// image is published as.
+	Name() string
+	// Build builds the component's container. built has the containers
+	// built before it, such as the micro agent's, by name.
+	Build(ctx context.Context, client *dagger.Client, built map[string]*dagger.Container) *dagger.Container
+	// Test tests built[Name()], with every component built and the stores
+	// the pipeline starts, by name: neo4j, qdrant, redis, postgres and
+	// minio.
+	Test(ctx context.Context, client *dagger.Client, built map[string]*dagger.Container, services map[string]*dagger.Service) error
+	// Publish pushes the container to ref, answering the ref it has there.
+	Publish(ctx context.Context, container *dagger.Container, ref string) (string, error)
+}
+
+// builtinComponents are the components the pipeline has always had, which
+// come before the rest wherever components are listed, such as in what is
+// published.
+var builtinComponents = []string{"mcp-server", "knowledge-graph", "session-memory", "orchestrator"}
+
+// componentBuilders are the builders registered, by component. Each
+// component's file registers its own from init, and so may a file of
+// another build tag; PIPELINE_PLUGINS adds those of Go plugins.
+var componentBuilders = map[string]ComponentBuilder{}
+
+func registerComponent(builder ComponentBuilder) {
+	if _, ok := componentBuilders[builder.Name()]; ok {
+		panic("component registered twice: " + builder.Name())
+	}
+	componentBuilders[builder.Name()] = builder
+}
+
+// configurable is a builder of a component pipeline.json declares, which
+// only runs with the entry it is configured with there.
+type configurable interface {
+	configure(config componentConfig)
+}
+
+// publishContainer publishes a component's container as it is built, as
+// every component but a plugin's may.
+type publishContainer struct{}
+
+func (publishContainer) Publish(ctx context.Context, container *dagger.Container, ref string) (string, error) {
+	return container.Publish(ctx, ref)
+}
+
+// loadComponentPlugins opens the Go plugins of PIPELINE_PLUGINS, a
+// comma-separated list of .so files built with -buildmode=plugin against
+// the same Dagger SDK, and registers the ComponentBuilder each exports as
+// Builder.
+func loadComponentPlugins() error {
+	list := os.Getenv("PIPELINE_PLUGINS")
+	if list == "" {
+		return nil
+	}
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		p, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("PIPELINE_PLUGINS: %w", err)
+		}
+		symbol, err := p.Lookup("Builder")
+		if err != nil {
+			return fmt.Errorf("PIPELINE_PLUGINS: %s: %w", path, err)
+		}
+		builder, ok := symbol.(ComponentBuilder)
+		if !ok {
+			return fmt.Errorf("PIPELINE_PLUGINS: %s: Builder is a %T, not a ComponentBuilder", path, symbol)
+		}
+		if _, ok := componentBuilders[builder.Name()]; ok {
+			return fmt.Errorf("PIPELINE_PLUGINS: %s: there is a component %s already", path, builder.Name())
+		}
+		registerComponent(builder)
+		fmt.Printf("🔌 Loaded component %s from %s\n", builder.Name(), path)
+	}
+	return nil
+}
+
+// builders are the components the pipeline runs, in order: the built-in
+// ones, then the rest by name. A component of pipeline.json's builder only
+// runs when the file declares it.
+func (c pipelineConfig) builders() []ComponentBuilder {
+	builders := make([]ComponentBuilder, 0, len(componentBuilders))
+	for _, name := range builtinComponents {
+		builders = append(builders, componentBuilders[name])
+	}
+	var others []string
+	for name, builder := range componentBuilders {
+		_, configured := c.Components[name]
+		if _, ok := builder.(configurable); ok && !configured {
+			continue
+		}
+		if !slices.Contains(builtinComponents, name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		builders = append(builders, componentBuilders[name])
+	}
+	return builders
+}
+
+// buildComponents builds each component into built, a component of
+// pipeline.json with the environment its entry gives it.
+func buildComponents(ctx context.Context, client *dagger.Client, config pipelineConfig, built map[string]*dagger.Container) {
+	for _, builder := range config.builders() {
+		container := builder.Build(ctx, client, built)
+		env := config.Components[builder.Name()].Env
+		keys := make([]string, 0, len(env))
+		for key := range env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			container = container.WithEnvVariable(key, env[key])
+		}
+		built[builder.Name()] = container
+	}
+}
+
+// componentNeeds is what a builder implements to say what its
+// component's test needs besides its own container: components, by name,
+// which are built first, and the stores of pipelineStores it uses.
+type componentNeeds interface {
+	Needs() []string
+}
+
+// componentSteps build each component, as a step of its own, and run each
+// one's tests once what they need is built.
+func componentSteps(client *dagger.Client, config pipelineConfig, built map[string]*dagger.Container, services map[string]*dagger.Service) []pipelineStep {
+	var steps []pipelineStep
+	for _, builder := range config.builders() {
+		builder := builder
+		name := builder.Name()
+		var needs, stores []string
+		if dependent, ok := builder.(componentNeeds); ok {
+			needs, stores = needing(dependent.Needs())
+		}
+		steps = append(steps,
+			pipelineStep{name: "build:" + name, run: func(ctx context.Context) error {
+				if _, err := built[name].Sync(ctx); err != nil {
+					return fmt.Errorf("building %s: %w", name, err)
+				}
+				return nil
+			}},
+			pipelineStep{name: "test:" + name, needs: append(needs, "build:"+name), stores: stores, run: func(ctx context.Context) error {
+				return builder.Test(ctx, client, built, services)
+			}})
+	}
+	return steps
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// configServiceSource is the Go config service, relative to the repository
+// root the pipeline runs from.
+const configServiceSource = "packages/config-service"
+
+const configServicePort = 8050
+
+// configServiceURL is where the pipeline's services find the config service.
+var configServiceURL = fmt.Sprintf("http://config-service:%d", configServicePort)
+
+// Config Service Container - the settings of every component, served from
+// one file and changed without restarting them
+func buildConfigServiceContainer(ctx context.Context, client *dagger.Client) *dagger.Container {
+	fmt.Println("⚙️ Building Config Service Container...")
+
+	binary := client.Container().
+		From("golang:1.22-alpine").
+		WithDirectory("/src/config-service", client.Host().Directory(configServiceSource)).
+		WithDirectory("/src/logging", client.Host().Directory(loggingSource)).
+		WithDirectory("/src/tracing", client.Host().Directory(tracingSource)).
+		WithWorkdir("/src/config-service").
+		WithMountedCache("/root/.cache/go-build", client.CacheVolume("config-service-go-build")).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithExec([]string{"go", "vet", "./..."}).
+		WithExec([]string{"go", "build", "-o", "/out/config-service", "."}).
+		File("/out/config-service")
+
+	return client.Container().
+		From("alpine:3.19").
+		WithFile("/usr/local/bin/config-service", binary).
+		WithEnvVariable("CONFIG_PORT", fmt.Sprint(configServicePort)).
+		WithExposedPort(configServicePort).
+		WithEntrypoint([]string{"/usr/local/bin/config-service"})
+}
+
+// withConfigService binds the config service into a container and tells it
+// where it is.
+func withConfigService(container *dagger.Container, config *dagger.Service) *dagger.Container {
+	return container.
+		WithServiceBinding("config-service", config).
+		WithEnvVariable("CONFIG_URL", configServiceURL)
+}
+
+// configServiceSettings start the orchestrator with a job timeout and
+// session memory with a session TTL of under an hour, in a Redis database of
+// its own so the other tests' sessions are not counted, and give every
+// component a secret. Session memory summarizes any session on request,
+// but the llm_summarization flag is off for the default tenant.
+const configServiceSettings = `{
+  "*": {"env": {"FIXTURE_API_KEY": {"secret": "fixture_api_key"}}},
+  "orchestrator": {"env": {"ORCH_JOB_TIMEOUT": 42, "ORCH_JOB_RETRIES": 0}},
+  "memory": {"config": {"session_ttl": 3000, "redis": {"db": 7}, "summarization": {"size_threshold": 1, "keep_recent": 1}},
+             "flags": {"llm_summarization": {"enabled": true, "tenants": {"default": false}}}}
+}`
+
+// testConfigService starts the orchestrator and session memory on settings
+// from the config service, changes them through its API and checks that
+// both take the change up without a restart: the orchestrator's next job
+// gets the new time limit, and the next session session memory stores the
+// new TTL. It then turns a feature flag on for a tenant and checks session
+// memory compacts the tenant's session it left alone before.
+func testConfigService(ctx context.Context, client *dagger.Client, configContainer, orchestratorContainer, sessionMemoryContainer *dagger.Container, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing Config Service...")
+
+	config := configContainer.
+		WithNewFile("/etc/config-service/settings.json", dagger.ContainerWithNewFileOpts{Contents: configServiceSettings}).
+		WithMountedSecret("/run/secrets/fixture_api_key", client.SetSecret("config-fixture-api-key", "fixture-key")).
+		WithEnvVariable("CONFIG_FILE", "/etc/config-service/settings.json").
+		WithEnvVariable("CONFIG_RELOAD_INTERVAL", "1").
+		AsService()
+	orchestrator := withConfigService(orchestratorContainer, config).AsService()
+	memory := withConfigService(withRedis(sessionMemoryContainer, redis), config).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	base := fmt.Sprintf("http://orchestrator:%d", orchestratorPort)
+	memoryBase := fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)
+	job := `{"agent_type": "context_gatherer", "target": "config-target"}`
+	// One line each: the memory component's settings, the first job's
+	// limits and stats, then the same after the change. Each component is
+	// polled until its health reports the new version.
+	script := fmt.Sprintf(`limits() {
+  id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '%[1]s' %[2]s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+  for i in $(seq 30); do
+    job=$(curl -fsS %[2]s/jobs/$id)
+    case "$job" in *'"limits"'*) break;; esac
+    sleep 1
+  done
+  echo "$job" | grep -o '"limits":{[^}]*}'
+}
+curl -fsS %[3]s/config/memory
+echo
+curl -fsS -X PUT -H 'Content-Type: application/json' -d '{"note": "before"}' %[4]s/sessions/config-before > /dev/null
+limits
+curl -fsS %[4]s/stats | grep -o '"ttl_distribution":{[^}]*}'
+curl -fsS -X PATCH -H 'Content-Type: application/json' -d '{"env": {"ORCH_JOB_TIMEOUT": 77}}' %[3]s/config/orchestrator > /dev/null
+curl -fsS -X PATCH -H 'Content-Type: application/json' -d '{"config": {"session_ttl": 500000}}' %[3]s/config/memory > /dev/null
+for i in $(seq 30); do
+  curl -fsS %[2]s/health | grep -q '"config_version":[2-9]' && curl -fsS %[4]s/health | grep -q '"config_version":[3-9]' && break
+  sleep 1
+done
+curl -fsS -X PUT -H 'Content-Type: application/json' -d '{"note": "after"}' %[4]s/sessions/config-after > /dev/null
+limits
+curl -fsS %[4]s/stats | grep -o '"ttl_distribution":{[^}]*}'
+curl -fsS -X PUT -H 'Content-Type: application/json' -d '{"notes": [1, 2, 3]}' %[4]s/sessions/config-flagged > /dev/null
+curl -fsS "%[3]s/flags/memory?tenant=default"
+echo
+curl -fsS -X POST %[4]s/sessions/config-flagged/compact
+echo
+curl -fsS -X PATCH -H 'Content-Type: application/json' -d '{"flags": {"llm_summarization": {"tenants": {"default": true}}}}' %[3]s/config/memory > /dev/null
+for i in $(seq 30); do
+  curl -fsS %[4]s/health | grep -q '"config_version":[4-9]' && break
+  sleep 1
+done
+curl -fsS -X POST %[4]s/sessions/config-flagged/compact`, job, base, configServiceURL, memoryBase)
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("config-service", config).
+		WithServiceBinding("orchestrator", orchestrator).
+		WithServiceBinding("session-memory", memory).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 8 {
+		return fmt.Errorf("unexpected config service output %q", output)
+	}
+
+	var settings struct {
+		Env    map[string]string `json:"env"`
+		Config map[string]any    `json:"config"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &settings); err != nil {
+		return fmt.Errorf("unexpected settings response %q: %w", lines[0], err)
+	}
+	if settings.Env["FIXTURE_API_KEY"] != "fixture-key" || settings.Config["session_ttl"] != float64(3000) {
+		return fmt.Errorf("config service did not serve memory the shared secret and its own config: %s", lines[0])
+	}
+
+	type stats struct {
+		Limits          struct{ Timeout int } `json:"limits"`
+		TTLDistribution map[string]int        `json:"ttl_distribution"`
+	}
+	var before, after stats
+	for _, step := range []struct {
+		lines []string
+		into  *stats
+	}{{lines[1:3], &before}, {lines[3:5], &after}} {
+		for _, line := range step.lines {
+			if err := json.Unmarshal([]byte("{"+line+"}"), step.into); err != nil {
+				return fmt.Errorf("unexpected config service output %q: %w", line, err)
+			}
+		}
+	}
+	if before.Limits.Timeout != 42 || before.TTLDistribution["1h"] != 1 {
+		return fmt.Errorf("components did not start on the config service's settings: %s", output)
+	}
+	if after.Limits.Timeout != 77 || after.TTLDistribution["7d"] != 1 {
+		return fmt.Errorf("components did not take up the changed settings: %s", output)
+	}
+
+	var flags struct {
+		Flags map[string]bool `json:"flags"`
+	}
+	var dark, shipped struct {
+		Compacted *bool          `json:"compacted"`
+		Replaced  map[string]int `json:"replaced"`
+	}
+	for i, into := range []any{&flags, &dark, &shipped} {
+		if err := json.Unmarshal([]byte(lines[5+i]), into); err != nil {
+			return fmt.Errorf("unexpected config service output %q: %w", lines[5+i], err)
+		}
+	}
+	if enabled, ok := flags.Flags["llm_summarization"]; !ok || enabled {
+		return fmt.Errorf("config service did not serve the flag off for the default tenant: %s", lines[5])
+	}
+	if dark.Compacted == nil || *dark.Compacted || dark.Replaced != nil {
+		return fmt.Errorf("session memory summarized a session with llm_summarization off: %s", lines[6])
+	}
+	if shipped.Replaced["notes"] != 2 {
+		return fmt.Errorf("session memory did not summarize once llm_summarization was on: %s", lines[7])
+	}
+
+	fmt.Println("Config Service: the orchestrator and session memory took up changed settings and flags without a restart")
+	return nil
+}
+
+// configClientPy is the Python side of the config service, which the
+// knowledge graph and session memory pull their settings with.
+const configClientPy = `#!/usr/bin/env python3
+"""Pulls a component's settings from the config service at CONFIG_URL and
+waits on it for changes.
+
+The service serves {"component", "version", "env", "config", "flags"}: env
+holds the variables the component already reads, and config is laid over
+its config file. pull() puts env in os.environ, leaving alone the variables
+the process was started with, so everything that reads a setting finds it
+where it always has; it must run before they do. A Watcher applies each new
+version the same way, then calls back so the component can take up what it
+can without a restart. Flags are read as they are each time enabled() is
+called, so they need no call back.
+"""
+import copy
+import json
+import os
+import threading
+import urllib.parse
+import urllib.request
+
+_lock = threading.Lock()
+_state = {"version": None, "config": {}, "flags": {}}
+# The variables set from the service, which it may change or take away again
+_managed = set()
+
+
+def config_url():
+    return os.getenv("CONFIG_URL")
+
+
+def fetch(component, after=None, wait=30):
+    """The component's settings, once they are past version after if it is
+    given. CONFIG_COMPONENT names another section to read instead."""
+    component = os.getenv("CONFIG_COMPONENT") or component
+    url = f"{config_url().rstrip('/')}/config/{urllib.parse.quote(component)}"
+    timeout = 10
+    if after is not None:
+        url += f"?after={after}&wait={wait}"
+        timeout += wait
+    request = urllib.request.Request(url)
+    if os.getenv("CONFIG_TOKEN"):
+        request.add_header("Authorization", f"Bearer {os.environ['CONFIG_TOKEN']}")
+    with urllib.request.urlopen(request, timeout=timeout) as response:
+        return json.load(response)
+
+
+def apply(settings):
+    """Takes settings up, returning the variables they changed"""
+    changed = []
+    with _lock:
+        env = settings.get("env") or {}
+        for key, value in env.items():
+            if key in os.environ and key not in _managed:
+                continue
+            _managed.add(key)
+            if os.environ.get(key) != value:
+                os.environ[key] = value
+                changed.append(key)
+        for key in list(_managed):
+            if key not in env:
+                os.environ.pop(key, None)
+                _managed.discard(key)
+                changed.append(key)
+        _state["version"] = settings.get("version")
+        _state["config"] = settings.get("config") or {}
+        _state["flags"] = settings.get("flags") or {}
+    return sorted(changed)
+
+
+def pull(component):
+    """Takes the component's settings once; without CONFIG_URL it does nothing"""
+    if not config_url():
+        return None
+    settings = fetch(component)
+    apply(settings)
+    print(f"⚙️ Settings version {settings['version']} from the config service")
+    return settings
+
+
+def version():
+    """The version of the settings taken up, None without a config service"""
+    return _state["version"]
+
+
+def overrides():
+    """The config the service lays over the component's config file"""
+    with _lock:
+        return copy.deepcopy(_state["config"])
+
+
+def enabled(flag, tenant=None, default=False):
+    """Whether a feature flag is on for a tenant: its tenant's override, or
+    else whether it is on. default is for a flag the service does not have,
+    or when there is no config service."""
+    with _lock:
+        rule = _state["flags"].get(flag)
+    if rule is None:
+        return default
+    return bool((rule.get("tenants") or {}).get(tenant, rule.get("enabled", False)))
+
+
+class Watcher:
+    """Waits on the service for new settings on a thread, applies them and
+    calls on_change with the variables they changed. It asks again a second
+    after the service fails it, and up to 30 seconds after several."""
+
+    def __init__(self, component, on_change):
+        self.component, self.on_change = component, on_change
+        self.stopped = threading.Event()
+        self.thread = threading.Thread(target=self.loop, name="config-watcher", daemon=True)
+
+    def start(self):
+        self.thread.start()
+
+    def stop(self):
+        self.stopped.set()
+
+    def loop(self):
+        wait = 1
+        while not self.stopped.is_set():
+            try:
+                settings = fetch(self.component, after=version() or 0)
+                wait = 1
+            except (OSError, ValueError) as e:
+                print(f"⚠️ Config service: retrying in {wait}s: {e}")
+                self.stopped.wait(wait)
+                wait = min(wait * 2, 30)
+                continue
+            if self.stopped.is_set() or settings.get("version") == version():
+                continue
+            changed = apply(settings)
+            print(f"⚙️ Settings version {settings['version']} from the config service")
+            try:
+                self.on_change(changed)
+            except Exception as e:
+                print(f"⚠️ Could not take up settings version {settings['version']}: {e}")
+`
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// contractVerifierPy replays consumers' contracts against a provider:
+// each interaction's request as the consumer recorded it, checking the
+// provider answers with the status the consumer expects and a body like
+// the one it expects. It prints a line of JSON for each interaction.
+const contractVerifierPy = `import json
+import sys
+import urllib.error
+import urllib.request
+
+
+def like(expected, actual, path="body"):
+    """Pact's type matching: every field expected is there, of the same type"""
+    if isinstance(expected, dict):
+        if not isinstance(actual, dict):
+            return [f"{path} is {json.dumps(actual)}, not an object"]
+        return [problem for key, value in expected.items()
+                for problem in (like(value, actual[key], f"{path}.{key}") if key in actual
+                                else [f"{path}.{key} is missing"])]
+    if isinstance(expected, list):
+        return [] if isinstance(actual, list) else [f"{path} is {json.dumps(actual)}, not an array"]
+    if isinstance(expected, bool) or isinstance(actual, bool):
+        same = isinstance(expected, bool) and isinstance(actual, bool)
+    elif isinstance(expected, (int, float)):
+        same = isinstance(actual, (int, float))
+    else:
+        same = type(expected) is type(actual)
+    return [] if same else [f"{path} is {json.dumps(actual)}, not like {json.dumps(expected)}"]
+
+
+provider = sys.argv[1]
+for path in sys.argv[2:]:
+    with open(path) as f:
+        contract = json.load(f)
+    for interaction in contract["interactions"]:
+        request, expected = interaction["request"], interaction["response"]
+        data = json.dumps(request["body"]).encode() if request.get("body") is not None else None
+        call = urllib.request.Request(provider + request["path"], data=data, method=request["method"],
+                                      headers=request.get("headers") or {})
+        try:
+            with urllib.request.urlopen(call, timeout=10) as response:
+                status, raw = response.status, response.read()
+        except urllib.error.HTTPError as e:
+            status, raw = e.code, e.read()
+        problems = [] if status == expected["status"] else [f"status {status}, not {expected['status']}"]
+        try:
+            body = json.loads(raw or b"null")
+        except ValueError:
+            body = raw.decode(errors="replace")
+        problems += like(expected.get("body") or {}, body)
+        print(json.dumps({"consumer": contract["consumer"]["name"], "description": interaction["description"],
+                          "problems": problems}))
+`
+
+// contractConsumers are the agent SDKs, each with how it writes its
+// contract with the MCP server to stdout.
+var contractConsumers = []struct {
+	name    string
+	image   string
+	setup   []string
+	command []string
+}{
+	{"agent-sdk-go", "golang:1.22-alpine", nil, []string{"go", "run", "./cmd/agent-contract"}},
+	{"agent-sdk-python", "python:3.11-slim", []string{"pip", "install", "--no-cache-dir", "."}, []string{"python3", "-m", "mcp_agent_sdk.contract"}},
+}
+
+// testContracts has each agent SDK record its contract with the MCP
+// server, the requests its client makes and the answers it relies on, and
+// verifies them against the MCP server, so the SDKs and the server cannot
+// drift apart unnoticed however they are released. It checks the SDKs make
+// the same requests of it, too. The contracts are written to
+// dir/contracts.
+func testContracts(ctx context.Context, client *dagger.Client, mcpServerContainer *dagger.Container, dir string) error {
+	fmt.Println("🧪 Testing Agent Contracts...")
+
+	contracts := client.Directory()
+	recorded := map[string]string{}
+	for _, consumer := range contractConsumers {
+		language := strings.TrimPrefix(consumer.name, "agent-sdk-")
+		recorder := client.Container().
+			From(consumer.image).
+			WithDirectory("/sdk", client.Host().Directory(agentSDKSource+"/"+language)).
+			WithWorkdir("/sdk").
+			WithEnvVariable("CGO_ENABLED", "0")
+		if consumer.setup != nil {
+			recorder = recorder.WithExec(consumer.setup)
+		}
+		contract, err := recorder.WithExec(consumer.command).Stdout(ctx)
+		if err != nil {
+			return fmt.Errorf("%s contract: %w", consumer.name, err)
+		}
+		recorded[consumer.name] = contract
+		contracts = contracts.WithNewFile(consumer.name+".json", contract)
+	}
+	if err := compareContracts(recorded); err != nil {
+		return err
+	}
+
+	mcp := mcpServerContainer.
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	command := []string{"python3", "/verify.py", "http://mcp-server:3000"}
+	for _, consumer := range contractConsumers {
+		command = append(command, "/contracts/"+consumer.name+".json")
+	}
+	output, err := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/verify.py", dagger.ContainerWithNewFileOpts{Contents: contractVerifierPy}).
+		WithDirectory("/contracts", contracts).
+		WithServiceBinding("mcp-server", mcp).
+		WithExec(command).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := contracts.Export(ctx, dir+"/contracts"); err != nil {
+		return err
+	}
+
+	var broken []string
+	verified := 0
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		var result struct {
+			Consumer    string   `json:"consumer"`
+			Description string   `json:"description"`
+			Problems    []string `json:"problems"`
+		}
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			return fmt.Errorf("unexpected contract verifier output %q: %w", line, err)
+		}
+		if len(result.Problems) > 0 {
+			broken = append(broken, fmt.Sprintf("%s, %s: %s", result.Consumer, result.Description, strings.Join(result.Problems, ", ")))
+			continue
+		}
+		verified++
+	}
+	if len(broken) > 0 {
+		return fmt.Errorf("the MCP server breaks its contracts with the agent SDKs:\n%s", strings.Join(broken, "\n"))
+	}
+	if verified == 0 {
+		return fmt.Errorf("no contract interactions were verified: %s", output)
+	}
+
+	fmt.Printf("Agent Contracts: %d interactions of %d SDKs verified against the MCP server\n", verified, len(contractConsumers))
+	return nil
+}
+
+// compareContracts checks every SDK makes the same requests of the MCP
+// server, down to the fields of each body, so that one does not drift from
+// the other when only one of them is changed.
+func compareContracts(contracts map[string]string) error {
+	shapes := map[string]map[string]string{}
+	for consumer, contents := range contracts {
+		var contract struct {
+			Interactions []struct {
+				Description string `json:"description"`
+				Request     struct {
+					Method  string            `json:"method"`
+					Path    string            `json:"path"`
+					Headers map[string]string `json:"headers"`
+					Body    any               `json:"body"`
+				} `json:"request"`
+			} `json:"interactions"`
+		}
+		if err := json.Unmarshal([]byte(contents), &contract); err != nil {
+			return fmt.Errorf("unexpected %s contract: %w", consumer, err)
+		}
+		shapes[consumer] = map[string]string{}
+		for _, interaction := range contract.Interactions {
+			request := interaction.Request
+			var headers []string
+			for name := range request.Headers {
+				headers = append(headers, name)
+			}
+			sort.Strings(headers)
+			shapes[consumer][interaction.Description] = fmt.Sprintf("%s %s, headers %s, fields %s",
+				request.Method, request.Path, strings.Join(headers, " "), strings.Join(fieldsOf(request.Body, ""), " "))
+		}
+	}
+
+	first := contractConsumers[0].name
+	for _, consumer := range contractConsumers[1:] {
+		for description, shape := range shapes[first] {
+			other, ok := shapes[consumer.name][description]
+			if !ok {
+				return fmt.Errorf("%s has no %q interaction, which %s has", consumer.name, description, first)
+			}
+			if other != shape {
+				return fmt.Errorf("%s and %s send %s differently:\n%s\n%s", first, consumer.name, description, shape, other)
+			}
+		}
+		if len(shapes[consumer.name]) != len(shapes[first]) {
+			return fmt.Errorf("%s has interactions %s does not", consumer.name, first)
+		}
+	}
+	return nil
+}
+
+// fieldsOf lists the paths of every field in a JSON value, sorted.
+func fieldsOf(value any, prefix string) []string {
+	var fields []string
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			fields = append(fields, prefix+key)
+			fields = append(fields, fieldsOf(child, prefix+key+".")...)
+		}
+	case []any:
+		for _, child := range v {
+			fields = append(fields, fieldsOf(child, prefix+"[].")...)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// controlPlaneSource is the Go control plane, relative to the repository
+// root the pipeline runs from.
+const controlPlaneSource = "packages/control-plane"
+
+const controlPlanePort = 8060
+
+// Control Plane Container - the control plane layered onto the orchestrator
+// container with the Go knowledge graph service, so it can run both as
+// child processes
+func buildControlPlaneContainer(ctx context.Context, client *dagger.Client, orchestrator, goKnowledgeGraph *dagger.Container) *dagger.Container {
+	fmt.Println("🕹️ Building Control Plane Container...")
+
+	binary := client.Container().
+		From("golang:1.22-alpine").
+		WithDirectory("/src/control-plane", client.Host().Directory(controlPlaneSource)).
+		WithDirectory("/src/logging", client.Host().Directory(loggingSource)).
+		WithDirectory("/src/tracing", client.Host().Directory(tracingSource)).
+		WithWorkdir("/src/control-plane").
+		WithMountedCache("/root/.cache/go-build", client.CacheVolume("control-plane-go-build")).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithExec([]string{"go", "vet", "./..."}).
+		WithExec([]string{"go", "build", "-o", "/out/control-plane", "."}).
+		File("/out/control-plane")
+
+	return orchestrator.
+		WithFile("/usr/local/bin/kg-service", goKnowledgeGraph.File("/usr/local/bin/kg-service")).
+		WithFile("/usr/local/bin/control-plane", binary).
+		WithEnvVariable("CONTROL_PORT", fmt.Sprint(controlPlanePort)).
+		WithExposedPort(controlPlanePort).
+		WithEntrypoint([]string{"/usr/local/bin/control-plane"})
+}
+
+// controlPlaneTopology starts the graph and the orchestrator in the control
+// plane's container and watches the MCP server and session memory in
+// theirs.
+var controlPlaneTopology = fmt.Sprintf(`{"services": [
+  {"name": "orchestrator", "kind": "orchestrator", "url": "http://localhost:%d",
+   "command": ["/usr/local/bin/orchestrator"], "needs": ["mcp-server", "graph", "session-memory"]},
+  {"name": "graph", "kind": "graph", "url": "http://localhost:%d",
+   "command": ["kg-service"], "env": {"KG_PORT": "%d"}},
+  {"name": "mcp-server", "kind": "mcp", "url": "http://mcp-server:3000", "needs": ["session-memory"]},
+  {"name": "session-memory", "kind": "memory", "url": "http://session-memory:%d"}
+]}`, orchestratorPort, knowledgeGraphPort, knowledgeGraphPort, sessionMemoryPort)
+
+// testControlPlane brings the topology up and checks that every service
+// became healthy in order, that the orchestrator found the MCP server, and
+// that the system health check agrees, and that a restarted service comes
+// back.
+func testControlPlane(ctx context.Context, client *dagger.Client, container *dagger.Container, mcpServer *dagger.Container, sessionMemory *dagger.Service) error {
+	fmt.Println("🧪 Testing Control Plane...")
+
+	mcp := mcpServer.
+		WithServiceBinding("session-memory", sessionMemory).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	controlPlane := container.
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("session-memory", sessionMemory).
+		WithNewFile("/app/topology.json", dagger.ContainerWithNewFileOpts{Contents: controlPlaneTopology}).
+		WithEnvVariable("CONTROL_TOPOLOGY", "/app/topology.json").
+		WithEnvVariable("CONTROL_CHECK_INTERVAL", "1").
+		AsService()
+
+	base := fmt.Sprintf("http://control-plane:%d", controlPlanePort)
+	script := fmt.Sprintf(`for i in $(seq 60); do
+  status=$(curl -fsS %s/status)
+  case "$status" in '{"status":"healthy"'*) break;; esac
+  sleep 1
+done
+echo "$status"
+code=$(curl -sS -o /tmp/health -w '%%{http_code}' %s/healthz/system)
+echo "$code $(cat /tmp/health)"
+curl -fsS -o /dev/null -X POST %s/services/graph/restart
+for i in $(seq 30); do
+  graph=$(curl -fsS %s/services/graph)
+  case "$graph" in *'"state":"healthy"'*'"restarts":1'*|*'"restarts":1'*'"state":"healthy"'*) break;; esac
+  sleep 1
+done
+echo "$graph"`, base, base, base, base)
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("control-plane", controlPlane).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitN(output, "\n", 3)
+	if len(lines) < 3 {
+		return fmt.Errorf("unexpected control plane test output %q", output)
+	}
+	statusJSON, systemHealth, graph := lines[0], lines[1], lines[2]
+	var status struct {
+		Status   string `json:"status"`
+		Services []struct {
+			Name   string         `json:"name"`
+			State  string         `json:"state"`
+			Health map[string]any `json:"health"`
+		} `json:"services"`
+	}
+	if err := json.Unmarshal([]byte(statusJSON), &status); err != nil {
+		return fmt.Errorf("unexpected status response %q: %w", statusJSON, err)
+	}
+	if status.Status != "healthy" || len(status.Services) != 4 {
+		return fmt.Errorf("control plane did not bring every service up: %s", statusJSON)
+	}
+	// Started after the services it needs, and told where the MCP server is
+	if last := status.Services[3]; last.Name != "orchestrator" || last.Health["reporting"] != true {
+		return fmt.Errorf("orchestrator did not start last or does not report to the MCP server: %s", statusJSON)
+	}
+	// Checked again for the probe, and answered with 200 as every service is up
+	if !strings.HasPrefix(systemHealth, `200 {"status":"healthy"`) || !strings.Contains(systemHealth, `"unhealthy":[]`) {
+		return fmt.Errorf("system health check did not find every service healthy: %s", systemHealth)
+	}
+	if !strings.Contains(graph, `"state":"healthy"`) || !strings.Contains(graph, `"restarts":1`) {
+		return fmt.Errorf("restarted graph service did not come back: %s", graph)
+	}
+
+	fmt.Printf("Control Plane: %d services %s\n", len(status.Services), status.Status)
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// ctxctlSource is the ctxctl client CLI, relative to the repository root
+// the pipeline runs from.
+const ctxctlSource = "packages/ctxctl"
+
+// ctxctlSession is the session the job ctxctl runs stores its context in.
+const ctxctlSession = "ctxctl-session"
+
+// dashboardSession is the session of the job run under the dashboard.
+const dashboardSession = "dashboard-session"
+
+// buildCtxctl builds the ctxctl binary, with the events package its
+// dashboard reads the bus with.
+func buildCtxctl(client *dagger.Client) *dagger.File {
+	return client.Container().
+		From("golang:1.22-alpine").
+		WithDirectory("/src/events", client.Host().Directory(eventsSource)).
+		WithDirectory("/src/ctxctl", client.Host().Directory(ctxctlSource)).
+		WithWorkdir("/src/ctxctl").
+		WithMountedCache("/root/.cache/go-build", client.CacheVolume("ctxctl-go-build")).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithExec([]string{"go", "vet", "./..."}).
+		WithExec([]string{"go", "build", "-o", "/out/ctxctl", "."}).
+		File("/out/ctxctl")
+}
+
+// testCtxctl runs each ctxctl command against a deployment of the
+// orchestrator, the MCP server, session memory and the knowledge graph, as
+// an operator would: it registers a tool, runs the issue_tracker agent on
+// the fixture repository and waits for it, then finds and dumps the
+// session the job stored and searches the graph for the fixture issue.
+func testCtxctl(ctx context.Context, client *dagger.Client, orchestratorContainer, mcpServer *dagger.Container, sessionMemory, knowledgeGraph *dagger.Service) error {
+	fmt.Println("🧪 Testing ctxctl...")
+
+	site := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/api/repos/fixture/repo/issues", dagger.ContainerWithNewFileOpts{Contents: agentFixtureIssues}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "-m", "http.server", "8000", "--directory", "/srv"}).
+		AsService()
+	mcp := mcpServer.
+		WithServiceBinding("session-memory", sessionMemory).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	orchestrator := orchestratorContainer.
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithServiceBinding("site", site).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)).
+		WithEnvVariable("AGENT_GITHUB_API", "http://site:8000/api").
+		AsService()
+
+	ctxctl := client.Container().
+		From("alpine:3.19").
+		WithFile("/usr/local/bin/ctxctl", buildCtxctl(client)).
+		WithServiceBinding("orchestrator", orchestrator).
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithEnvVariable("CTXCTL_MCP_URL", "http://mcp-server:3000").
+		WithEnvVariable("CTXCTL_GRAPH_URL", fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)).
+		WithEnvVariable("CTXCTL_ORCH_URL", fmt.Sprintf("http://orchestrator:%d", orchestratorPort))
+	run := func(args ...string) (string, error) {
+		output, err := ctxctl.WithExec(append([]string{"ctxctl"}, args...)).Stdout(ctx)
+		if err != nil {
+			return "", fmt.Errorf("ctxctl %s: %w", strings.Join(args, " "), err)
+		}
+		return output, nil
+	}
+
+	registered, err := run("tools", "register", "ctxctl-echo", "http://site:8000/", "--config", `{"owner": "ctxctl"}`)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(registered, "tool ctxctl-echo registered for tenant default") {
+		return fmt.Errorf("unexpected tools register output %q", registered)
+	}
+
+	output, err := run("run", "issue_tracker", "github:fixture/repo", "--session", ctxctlSession, "--wait", "--timeout", "2m")
+	if err != nil {
+		return err
+	}
+	var job struct {
+		Status   string `json:"status"`
+		Reported bool   `json:"reported"`
+	}
+	if err := json.Unmarshal([]byte(output), &job); err != nil {
+		return fmt.Errorf("unexpected run output %q: %w", output, err)
+	}
+	if job.Status != "succeeded" || !job.Reported {
+		return fmt.Errorf("job ctxctl ran did not succeed and get reported: %s", output)
+	}
+
+	session, err := run("sessions", "show", ctxctlSession)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(session, "fixture/repo#3") {
+		return fmt.Errorf("ctxctl sessions show %s is missing the fixture issue: %s", ctxctlSession, session)
+	}
+	sessions, err := run("sessions", "list", "checkout")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(sessions, ctxctlSession) {
+		return fmt.Errorf("ctxctl sessions list did not find %s: %s", ctxctlSession, sessions)
+	}
+
+	found, err := run("search", "Checkout times out", "--mode", "keyword", "--limit", "5")
+	if err != nil {
+		return err
+	}
+	if lines := strings.Split(strings.TrimSpace(found), "\n"); len(lines) < 2 || !strings.HasPrefix(lines[0], "NODE") {
+		return fmt.Errorf("ctxctl search found nothing for the fixture issue: %s", found)
+	}
+
+	fmt.Printf("ctxctl: tool registered, job run and reported into %s, session found and searched\n", ctxctlSession)
+	return nil
+}
+
+// testDashboard runs ctxctl dashboard on the event bus while a tool is
+// registered and invoked through the MCP server's gateway and the
+// issue_tracker agent runs, then reads off its last frame that it saw the
+// job finish, the agent's nodes, the session the job stored and the tool
+// invocation.
+func testDashboard(ctx context.Context, client *dagger.Client, orchestratorContainer, mcpServer, sessionMemoryContainer *dagger.Container, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing ctxctl dashboard...")
+
+	bus := eventBusService(client)
+	memory := withEventBus(withRedis(sessionMemoryContainer, redis), bus).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	echo := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/echo.py", dagger.ContainerWithNewFileOpts{Contents: loadEchoPy}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "/srv/echo.py"}).
+		AsService()
+	mcp := withEventBus(mcpServer, bus).
+		WithServiceBinding("session-memory", memory).
+		WithServiceBinding("echo", echo).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	site := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/api/repos/fixture/repo/issues", dagger.ContainerWithNewFileOpts{Contents: agentFixtureIssues}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "-m", "http.server", "8000", "--directory", "/srv"}).
+		AsService()
+	orchestrator := withEventBus(orchestratorContainer, bus).
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("site", site).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("AGENT_GITHUB_API", "http://site:8000/api").
+		AsService()
+
+	// The session arrives in session memory over the bus, so it is waited
+	// for before the dashboard is stopped; its last frame reads the stats
+	// once more
+	script := fmt.Sprintf(`ctxctl dashboard > /tmp/frames 2>&1 &
+dashboard=$!
+sleep 3
+ctxctl tools register dashboard-echo http://echo:8000/ >&2
+wget -qO- --header 'Content-Type: application/json' --post-data '{"session_id": "%[1]s"}' http://mcp-server:3000/v1/api/dashboard-echo >&2
+ctxctl run issue_tracker github:fixture/repo --session %[1]s --wait --timeout 2m >&2
+for i in $(seq 30); do
+  wget -qO- http://mcp-server:3000/v1/memory/sessions/%[1]s > /dev/null 2>&1 && break
+  sleep 1
+done
+kill -INT $dashboard
+wait $dashboard
+cat /tmp/frames`, dashboardSession)
+	output, err := client.Container().
+		From("alpine:3.19").
+		WithFile("/usr/local/bin/ctxctl", buildCtxctl(client)).
+		WithServiceBinding("orchestrator", orchestrator).
+		WithServiceBinding("mcp-server", mcp).
+		WithEnvVariable("CTXCTL_MCP_URL", "http://mcp-server:3000").
+		WithEnvVariable("CTXCTL_ORCH_URL", fmt.Sprintf("http://orchestrator:%d", orchestratorPort)).
+		WithEnvVariable("CTXCTL_BUS_URL", eventBusURL).
+		WithServiceBinding("event-bus", bus).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	frames := strings.Split(output, "ctxctl dashboard  ")
+	last := frames[len(frames)-1]
+	if len(frames) < 3 {
+		return fmt.Errorf("ctxctl dashboard drew %d frames: %s", len(frames)-1, output)
+	}
+
+	lineWith := func(words ...string) bool {
+		for _, line := range strings.Split(last, "\n") {
+			fields := strings.Fields(line)
+			found := 0
+			for _, word := range words {
+				for _, field := range fields {
+					if field == word {
+						found++
+						break
+					}
+				}
+			}
+			if found == len(words) {
+				return true
+			}
+		}
+		return false
+	}
+	count := func(pattern string) int {
+		match := regexp.MustCompile(pattern).FindStringSubmatch(last)
+		if match == nil {
+			return 0
+		}
+		n, _ := strconv.Atoi(match[1])
+		return n
+	}
+	if !lineWith("issue_tracker", "succeeded") {
+		return fmt.Errorf("ctxctl dashboard did not show the issue_tracker job finish: %s", last)
+	}
+	nodes := count(`CONTEXT INTO THE GRAPH  (\d+) nodes`)
+	if nodes == 0 {
+		return fmt.Errorf("ctxctl dashboard did not show the agent's nodes: %s", last)
+	}
+	sessions := count(`(\d+) sessions  \d+ pinned`)
+	if sessions == 0 {
+		return fmt.Errorf("ctxctl dashboard did not show the session in session memory: %s", last)
+	}
+	if !lineWith("dashboard-echo", "200") {
+		return fmt.Errorf("ctxctl dashboard did not show the tool invocation: %s", last)
+	}
+
+	fmt.Printf("ctxctl dashboard: %d frames, the job finished, %d nodes, %d sessions in memory, the tool invoked\n", len(frames)-1, nodes, sessions)
+	return nil
+}
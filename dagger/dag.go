@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pipelineStores are what steps may say they use, besides the components
+// they need built: the stores the pipeline starts and the event bus. A
+// service the tests share is one instance while they overlap, so the steps
+// using a store take turns.
+var pipelineStores = []string{"neo4j", "qdrant", "redis", "postgres", "minio", "nats"}
+
+// pipelineStep is one thing runPipeline does, named STAGE:NAME. It runs
+// once every step it needs has succeeded, while no other step uses the
+// stores it uses, and alongside whatever else can run.
+type pipelineStep struct {
+	name   string
+	needs  []string
+	stores []string
+	run    func(ctx context.Context) error
+}
+
+func (s pipelineStep) stage() string {
+	stage, _, _ := strings.Cut(s.name, ":")
+	return stage
+}
+
+// needing splits what a component says it needs into the steps that build
+// the components among them and the stores.
+func needing(names []string) (needs, stores []string) {
+	for _, name := range names {
+		if slices.Contains(pipelineStores, name) {
+			stores = append(stores, name)
+		} else {
+			needs = append(needs, "build:"+name)
+		}
+	}
+	return needs, stores
+}
+
+// withStageHooks adds the steps running each stage's hooks with run: a
+// stage's before hooks once what its steps need from other stages has run,
+// and before any of them, and its after hooks once they all have.
+func withStageHooks(steps []pipelineStep, run func(ctx context.Context, stage, when string) error) []pipelineStep {
+	var hooked []pipelineStep
+	for _, stage := range pipelineStages {
+		stage := stage
+		before, after := stage+":before-hooks", stage+":after-hooks"
+		inStage := map[string]bool{}
+		for _, step := range steps {
+			if step.stage() == stage {
+				inStage[step.name] = true
+			}
+		}
+		var beforeNeeds, afterNeeds []string
+		for _, step := range steps {
+			if !inStage[step.name] {
+				continue
+			}
+			for _, need := range step.needs {
+				if !inStage[need] && !slices.Contains(beforeNeeds, need) {
+					beforeNeeds = append(beforeNeeds, need)
+				}
+			}
+			afterNeeds = append(afterNeeds, step.name)
+		}
+		for i, step := range steps {
+			if inStage[step.name] {
+				steps[i].needs = append(slices.Clip(step.needs), before)
+			}
+		}
+		hooked = append(hooked,
+			pipelineStep{name: before, needs: beforeNeeds, run: func(ctx context.Context) error { return run(ctx, stage, "before") }},
+			pipelineStep{name: after, needs: append(afterNeeds, before), run: func(ctx context.Context) error { return run(ctx, stage, "after") }})
+	}
+	return append(steps, hooked...)
+}
+
+// sortSteps orders steps so each comes after what it needs, finding what
+// does not exist and what needs itself before anything runs.
+func sortSteps(steps []pipelineStep) ([]pipelineStep, error) {
+	byName := map[string]pipelineStep{}
+	for _, step := range steps {
+		if _, ok := byName[step.name]; ok {
+			return nil, fmt.Errorf("pipeline step %s is there twice", step.name)
+		}
+		byName[step.name] = step
+	}
+	waiting := map[string]int{}
+	neededBy := map[string][]string{}
+	for _, step := range steps {
+		for _, store := range step.stores {
+			if !slices.Contains(pipelineStores, store) {
+				return nil, fmt.Errorf("pipeline step %s uses %s, which is not a store", step.name, store)
+			}
+		}
+		for _, need := range step.needs {
+			if _, ok := byName[need]; !ok {
+				return nil, fmt.Errorf("pipeline step %s needs %s, which is not a step", step.name, need)
+			}
+			waiting[step.name]++
+			neededBy[need] = append(neededBy[need], step.name)
+		}
+	}
+	var ready, order []string
+	for _, step := range steps {
+		if waiting[step.name] == 0 {
+			ready = append(ready, step.name)
+		}
+	}
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, next := range neededBy[name] {
+			if waiting[next]--; waiting[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+	if len(order) < len(steps) {
+		var cycle []string
+		for _, step := range steps {
+			if waiting[step.name] > 0 {
+				cycle = append(cycle, step.name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("pipeline steps need each other: %s", strings.Join(cycle, ", "))
+	}
+	sorted := make([]pipelineStep, len(order))
+	for i, name := range order {
+		sorted[i] = byName[name]
+	}
+	return sorted, nil
+}
+
+// runSteps runs every step as soon as what it needs has succeeded, each
+// with the stores it uses to itself. The first step to fail stops the
+// others, and what it answered is what runSteps does.
+func runSteps(ctx context.Context, steps []pipelineStep) error {
+	sorted, err := sortSteps(steps)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	done := map[string]chan struct{}{}
+	for _, step := range sorted {
+		done[step.name] = make(chan struct{})
+	}
+	stores := map[string]*sync.Mutex{}
+	for _, store := range pipelineStores {
+		stores[store] = &sync.Mutex{}
+	}
+
+	var wg sync.WaitGroup
+	for _, step := range sorted {
+		wg.Add(1)
+		go func(step pipelineStep) {
+			defer wg.Done()
+			defer close(done[step.name])
+			for _, need := range step.needs {
+				select {
+				case <-done[need]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			// Stores are locked in one order, so steps waiting on each
+			// other's cannot both wait forever
+			uses := slices.Clone(step.stores)
+			sort.Strings(uses)
+			for _, store := range slices.Compact(uses) {
+				stores[store].Lock()
+				defer stores[store].Unlock()
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if err := step.run(ctx); err != nil {
+				cancel(err)
+			}
+		}(step)
+	}
+	wg.Wait()
+	return context.Cause(ctx)
+}
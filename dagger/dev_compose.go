@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// dockerCLIImage checks the generated compose file.
+const dockerCLIImage = "docker:25-cli"
+
+// devNeo4jPassword is the Neo4j password in development, where nothing is
+// kept secret.
+const devNeo4jPassword = "dev-password"
+
+// devTarget fills in the addresses of the services by their compose name.
+var devTarget = infraTarget{
+	name: "dev",
+	address: func(service deployedService, host bool) string {
+		if host {
+			return service.name
+		}
+		return fmt.Sprintf("%s://%s:%d", service.scheme, service.name, service.ports[0])
+	},
+}
+
+// devReload is how a component runs in development: what it is run with so
+// it restarts when its source changes, and what of it is mounted where.
+type devReload struct {
+	command []string
+	// source is what is mounted from the host, relative to the compose
+	// file, and mount where
+	source, mount string
+	// workdir is where the command runs, when not the image's
+	workdir string
+	// cache are directories kept in named volumes between restarts
+	cache map[string]string
+}
+
+// devReloads are the components' development commands. The Python and
+// JavaScript components are mounted from their sources as the pipeline
+// builds them, as those are kept in the pipeline; the orchestrator from the
+// packages it is built from.
+var devReloads = map[string]devReload{
+	"knowledge-graph": {
+		command: []string{"uvicorn", "kg_server:app", "--app-dir", "/app", "--reload", "--reload-dir", "/app",
+			"--host", "0.0.0.0", "--port", fmt.Sprint(knowledgeGraphPort)},
+		source: "./src/knowledge-graph", mount: "/app",
+	},
+	"session-memory": {
+		command: []string{"uvicorn", "session_server:app", "--app-dir", "/app", "--reload", "--reload-dir", "/app",
+			"--host", "0.0.0.0", "--port", fmt.Sprint(sessionMemoryPort)},
+		source: "./src/session-memory", mount: "/app",
+	},
+	// Polling, as file events do not cross every bind mount
+	"mcp-server": {
+		command: []string{"nodemon", "--legacy-watch", "--watch", "/app", "--ext", "js,json", "/app/mcp_server.js"},
+		source:  "./src/mcp-server", mount: "/app",
+	},
+	"orchestrator": {
+		command: []string{"air", "--build.cmd", "go build -o /tmp/orchestrator .", "--build.bin", "/tmp/orchestrator",
+			"--build.include_ext", "go", "--build.poll", "true", "--tmp_dir", "/tmp/air"},
+		source: "../../packages", mount: "/src", workdir: "/src/orchestrator",
+		cache: map[string]string{"go-build": "/root/.cache/go-build"},
+	},
+}
+
+// devImages are the components' images with what their development
+// commands need, by name.
+func devImages(client *dagger.Client, containers map[string]*dagger.Container) map[string]*dagger.Container {
+	air := client.Container().
+		From("golang:1.22").
+		WithEnvVariable("GOBIN", "/out").
+		WithExec([]string{"go", "install", "github.com/air-verse/air@v1.52.3"}).
+		File("/out/air")
+	return map[string]*dagger.Container{
+		"knowledge-graph": containers["knowledge-graph"],
+		"session-memory":  containers["session-memory"],
+		"mcp-server":      containers["mcp-server"].WithExec([]string{"npm", "install", "-g", "nodemon@3"}),
+		"orchestrator": containers["orchestrator"].
+			WithDirectory("/usr/local/go", client.Container().From("golang:1.22").Directory("/usr/local/go")).
+			WithEnvVariable("PATH", "/usr/local/go/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin").
+			WithEnvVariable("CGO_ENABLED", "0").
+			WithFile("/usr/local/bin/air", air),
+	}
+}
+
+// devSources are the components' sources as they are built, to mount in
+// their place: everything in /app but the MCP server's packages, which the
+// compose file keeps from the image.
+func devSources(client *dagger.Client, containers map[string]*dagger.Container) *dagger.Directory {
+	sources := client.Directory()
+	for _, name := range []string{"knowledge-graph", "session-memory", "mcp-server"} {
+		sources = sources.WithDirectory(name, containers[name].Directory("/app").WithoutDirectory("node_modules"))
+	}
+	return sources
+}
+
+// devImageName is what the compose file calls a component's image, which
+// load-images.sh tags it as.
+func devImageName(service deployedService) string {
+	return "dynamic-context-dev/" + service.name
+}
+
+// renderDevCompose is the compose file of every service, each published on
+// its own ports and the components mounted from their sources.
+func renderDevCompose() string {
+	var compose strings.Builder
+	compose.WriteString(`# Generated by the pipeline: every service for development. The components
+# run from their mounted sources and restart when those change; run
+# ./load-images.sh first to load their images.
+
+services:
+`)
+	volumes := []string{}
+	for _, service := range deployedServices {
+		fmt.Fprintf(&compose, "  %s:\n", service.name)
+		if service.store() {
+			fmt.Fprintf(&compose, "    image: %s\n", service.image)
+			if len(service.args) > 0 {
+				fmt.Fprintf(&compose, "    command: %s\n", hclList(service.args))
+			}
+		} else {
+			reload := devReloads[service.name]
+			// Restarted until what it needs is up
+			fmt.Fprintf(&compose, "    image: %s\n    entrypoint: %s\n    restart: unless-stopped\n", devImageName(service), hclList(reload.command))
+			if reload.workdir != "" {
+				fmt.Fprintf(&compose, "    working_dir: %s\n", reload.workdir)
+			}
+		}
+
+		compose.WriteString("    ports:\n")
+		for _, port := range service.ports {
+			fmt.Fprintf(&compose, "      - \"%d:%d\"\n", port, port)
+		}
+
+		env := devTarget.sortedEnv(service)
+		if service.neo4jPassword != "" {
+			env = append(env, [2]string{service.neo4jPassword, devNeo4jPassword})
+		}
+		if service.neo4jAuth {
+			env = append(env, [2]string{"NEO4J_AUTH", "neo4j/" + devNeo4jPassword})
+		}
+		if len(env) > 0 {
+			compose.WriteString("    environment:\n")
+			for _, pair := range env {
+				fmt.Fprintf(&compose, "      %s: %q\n", pair[0], pair[1])
+			}
+		}
+
+		compose.WriteString("    volumes:\n")
+		if service.store() {
+			fmt.Fprintf(&compose, "      - %s-data:%s\n", service.name, service.volume)
+			volumes = append(volumes, service.name+"-data")
+		} else {
+			reload := devReloads[service.name]
+			fmt.Fprintf(&compose, "      - %s:%s\n", reload.source, reload.mount)
+			// Left out of the mount, so the image's packages show through
+			if service.name == "mcp-server" {
+				compose.WriteString("      - /app/node_modules\n")
+			}
+			for name, path := range reload.cache {
+				fmt.Fprintf(&compose, "      - %s:%s\n", name, path)
+				volumes = append(volumes, name)
+			}
+		}
+
+		needs := map[string]bool{}
+		for _, value := range service.env {
+			for _, match := range addressPattern.FindAllStringSubmatch(value, -1) {
+				needs[match[2]] = true
+			}
+		}
+		if len(needs) > 0 {
+			compose.WriteString("    depends_on:\n")
+			for _, other := range deployedServices {
+				if needs[other.name] {
+					fmt.Fprintf(&compose, "      - %s\n", other.name)
+				}
+			}
+		}
+		compose.WriteString("\n")
+	}
+	compose.WriteString("volumes:\n")
+	for _, name := range volumes {
+		fmt.Fprintf(&compose, "  %s:\n", name)
+	}
+	return compose.String()
+}
+
+// devLoadImages loads the exported images and tags them as the compose
+// file names them.
+func devLoadImages() string {
+	var script strings.Builder
+	script.WriteString(`#!/bin/sh
+# Generated by the pipeline: loads the components' development images and
+# tags them as docker-compose.yml names them.
+set -e
+cd "$(dirname "$0")"
+`)
+	for _, service := range deployedComponents() {
+		fmt.Fprintf(&script, "docker tag \"$(docker load -q -i images/%s.tar | sed -n 's/^Loaded image[^:]*: //p')\" %s\n",
+			service.name, devImageName(service))
+	}
+	return script.String()
+}
+
+// generateDevCompose writes a compose file for development to dir/dev,
+// with the components' sources to mount in dir/dev/src, their development
+// images in dir/dev/images and load-images.sh to load them, checked with
+// docker compose config. It only runs when DEV_COMPOSE is set on the host.
+func generateDevCompose(ctx context.Context, client *dagger.Client, containers map[string]*dagger.Container, dir string) error {
+	if os.Getenv("DEV_COMPOSE") == "" {
+		fmt.Println("⏭️ Skipping dev compose: DEV_COMPOSE is not set")
+		return nil
+	}
+	fmt.Println("🛠️ Generating Dev Compose...")
+
+	tree := client.Directory().
+		WithNewFile("docker-compose.yml", renderDevCompose()).
+		WithNewFile("load-images.sh", devLoadImages(), dagger.DirectoryWithNewFileOpts{Permissions: 0755}).
+		WithDirectory("src", devSources(client, containers))
+	output, err := client.Container().
+		From(dockerCLIImage).
+		WithDirectory("/dev-compose", tree).
+		WithWorkdir("/dev-compose").
+		WithExec([]string{"sh", "-c", "docker compose config --quiet && docker compose config --services"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dev compose: services %s\n", strings.Join(strings.Fields(output), ", "))
+
+	if _, err := tree.Export(ctx, dir+"/dev"); err != nil {
+		return fmt.Errorf("exporting dev compose: %w", err)
+	}
+	for name, container := range devImages(client, containers) {
+		dest := fmt.Sprintf("%s/dev/images/%s.tar", dir, name)
+		if _, err := container.Export(ctx, dest); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	fmt.Printf("Dev compose: %s/dev/docker-compose.yml\n", dir)
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// endToEndSession is the session the end-to-end job's context lands in.
+const endToEndSession = "e2e-session"
+
+// testEndToEnd runs the whole context loop against one fixture: the
+// orchestrator launches the issue_tracker agent on a fixture GitHub
+// repository, the agent indexes the open issue in the knowledge graph, the
+// MCP server stores the job's result in session memory, and a client reads
+// the session back through the MCP server. Each component passes its own
+// tests; this catches them no longer fitting together.
+func testEndToEnd(ctx context.Context, client *dagger.Client, orchestratorContainer, mcpServer *dagger.Container, sessionMemory, knowledgeGraph *dagger.Service) error {
+	fmt.Println("🧪 Testing End-to-End Context Flow...")
+
+	site := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/api/repos/fixture/repo/issues", dagger.ContainerWithNewFileOpts{Contents: agentFixtureIssues}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "-m", "http.server", "8000", "--directory", "/srv"}).
+		AsService()
+
+	mcp := mcpServer.
+		WithServiceBinding("session-memory", sessionMemory).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	// The agents the orchestrator launches inherit its environment, so they
+	// find the fixture and the graph
+	orchestrator := orchestratorContainer.
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithServiceBinding("site", site).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)).
+		WithEnvVariable("AGENT_GITHUB_API", "http://site:8000/api").
+		AsService()
+
+	base := fmt.Sprintf("http://orchestrator:%d", orchestratorPort)
+	job := fmt.Sprintf(`{"agent_type": "issue_tracker", "target": "github:fixture/repo", "session_id": %q}`, endToEndSession)
+	// One line each: the finished job, the session as the MCP server serves
+	// it, and the graph's answer to a search for the issue
+	script := fmt.Sprintf(`id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '%s' %s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 60); do
+  job=$(curl -fsS %s/jobs/$id)
+  case "$job" in *'"reported":true'*|*'"status":"failed"'*) break;; esac
+  sleep 1
+done
+echo "$job"
+curl -fsS http://mcp-server:3000/memory/sessions/%s
+echo
+curl -fsS -G --data-urlencode 'q=Checkout times out' --data-urlencode mode=keyword http://knowledge-graph:%d/search`,
+		job, base, base, endToEndSession, knowledgeGraphPort)
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("orchestrator", orchestrator).
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitN(strings.TrimSpace(output), "\n", 3)
+	if len(lines) != 3 {
+		return fmt.Errorf("unexpected end-to-end output %q", output)
+	}
+	jobJSON, session, search := lines[0], lines[1], lines[2]
+
+	var finished struct {
+		Status   string `json:"status"`
+		Reported bool   `json:"reported"`
+		Result   struct {
+			IssueCount float64 `json:"issue_count"`
+			Graph      struct {
+				Added float64 `json:"added"`
+			} `json:"graph"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(jobJSON), &finished); err != nil {
+		return fmt.Errorf("unexpected job response %q: %w", jobJSON, err)
+	}
+	if finished.Status != "succeeded" || !finished.Reported {
+		return fmt.Errorf("issue_tracker job did not succeed and get reported: %s", jobJSON)
+	}
+	if finished.Result.IssueCount != 1 || finished.Result.Graph.Added == 0 {
+		return fmt.Errorf("issue_tracker job did not gather the fixture issue into the graph: %s", jobJSON)
+	}
+
+	// The context a client gets back is the fixture's, not just any context
+	var stored map[string]any
+	if err := json.Unmarshal([]byte(session), &stored); err != nil {
+		return fmt.Errorf("unexpected session response %q: %w", session, err)
+	}
+	for _, want := range []string{"fixture/repo#3", "Checkout times out", "The payments call takes over 30s."} {
+		if !strings.Contains(session, want) {
+			return fmt.Errorf("session %s served by the MCP server is missing %q: %s", endToEndSession, want, session)
+		}
+	}
+	// The closed issue and the pull request never made it in
+	for _, unwanted := range []string{"Old fixture bug", "Add fixture feature"} {
+		if strings.Contains(session, unwanted) {
+			return fmt.Errorf("session %s has %q, which the agent should have left out: %s", endToEndSession, unwanted, session)
+		}
+	}
+
+	var found struct {
+		Results []map[string]any `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(search), &found); err != nil {
+		return fmt.Errorf("unexpected search response %q: %w", search, err)
+	}
+	if len(found.Results) == 0 || !strings.Contains(search, "fixture/repo#3") {
+		return fmt.Errorf("knowledge graph search did not find the fixture issue: %s", search)
+	}
+
+	fmt.Printf("End-to-End: fixture issue gathered, indexed and served back for session %s\n", endToEndSession)
+	return nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// eventsSource is the Go event types and NATS client, which the
+// orchestrator and the Go knowledge graph service build against.
+const eventsSource = "packages/events"
+
+const eventBusPort = 4222
+
+// eventBusURL is where the pipeline's services find the bus.
+var eventBusURL = fmt.Sprintf("nats://event-bus:%d", eventBusPort)
+
+// eventBusService is the NATS server components publish context events on
+// and the knowledge graph and session memory subscribe to.
+func eventBusService(client *dagger.Client) *dagger.Service {
+	return client.Container().
+		From("nats:2.10-alpine").
+		WithExposedPort(eventBusPort).
+		WithExposedPort(8222).
+		WithExec([]string{"nats-server", "--port", fmt.Sprint(eventBusPort), "--http_port", "8222"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+}
+
+// withEventBus binds the bus into a container and tells it where it is.
+func withEventBus(container *dagger.Container, bus *dagger.Service) *dagger.Container {
+	return container.
+		WithServiceBinding("event-bus", bus).
+		WithEnvVariable("EVENT_BUS_URL", eventBusURL)
+}
+
+// testEventBus runs the context loop over the bus rather than HTTP between
+// the components: the issue_tracker agent the orchestrator launches
+// publishes its nodes, which the knowledge graph adds, and the orchestrator
+// publishes the finished job, which session memory stores. Neither the
+// orchestrator, its agents nor the MCP server can reach the graph or
+// session memory, so what they have came over the bus.
+func testEventBus(ctx context.Context, client *dagger.Client, orchestratorContainer, mcpServer, knowledgeGraphContainer, sessionMemoryContainer *dagger.Container, neo4j, qdrant, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing Event Bus...")
+
+	bus := eventBusService(client)
+	graph := withEventBus(withGraphServices(knowledgeGraphContainer, neo4j, qdrant), bus).
+		WithEnvVariable("KG_PORT", fmt.Sprint(knowledgeGraphPort)).
+		WithExposedPort(knowledgeGraphPort).
+		WithExec([]string{"python3", "/app/kg_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	memory := withEventBus(withRedis(sessionMemoryContainer, redis), bus).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	mcp := mcpServer.
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	site := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/api/repos/fixture/repo/issues", dagger.ContainerWithNewFileOpts{Contents: agentFixtureIssues}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "-m", "http.server", "8000", "--directory", "/srv"}).
+		AsService()
+
+	orchestrator := withEventBus(orchestratorContainer, bus).
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("site", site).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("AGENT_GITHUB_API", "http://site:8000/api").
+		AsService()
+
+	base := fmt.Sprintf("http://orchestrator:%d", orchestratorPort)
+	job := `{"agent_type": "issue_tracker", "target": "github:fixture/repo", "session_id": "event-bus-session"}`
+	// The subscribers handle events as they come, so both stores are
+	// polled for them after the job is reported
+	script := fmt.Sprintf(`curl -fsS %s/health
+echo
+id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '%s' %s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 60); do
+  job=$(curl -fsS %s/jobs/$id)
+  case "$job" in *'"reported":true'*|*'"status":"failed"'*) break;; esac
+  sleep 1
+done
+echo "$job"
+for i in $(seq 30); do
+  session=$(curl -fsS http://session-memory:%d/sessions/event-bus-session) && break
+  sleep 1
+done
+echo "$session"
+for i in $(seq 30); do
+  search=$(curl -fsS -G --data-urlencode 'q=Checkout times out' --data-urlencode mode=keyword http://knowledge-graph:%d/search)
+  case "$search" in *'fixture/repo#3'*) break;; esac
+  sleep 1
+done
+echo "$search"`, base, job, base, base, sessionMemoryPort, knowledgeGraphPort)
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("orchestrator", orchestrator).
+		WithServiceBinding("session-memory", memory).
+		WithServiceBinding("knowledge-graph", graph).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitN(strings.TrimSpace(output), "\n", 4)
+	if len(lines) != 4 {
+		return fmt.Errorf("unexpected event bus output %q", output)
+	}
+	health, jobJSON, session, search := lines[0], lines[1], lines[2], lines[3]
+
+	if !strings.Contains(health, `"event_bus":true`) {
+		return fmt.Errorf("orchestrator is not publishing to the event bus: %s", health)
+	}
+	var finished struct {
+		Status   string `json:"status"`
+		Reported bool   `json:"reported"`
+	}
+	if err := json.Unmarshal([]byte(jobJSON), &finished); err != nil {
+		return fmt.Errorf("unexpected job response %q: %w", jobJSON, err)
+	}
+	if finished.Status != "succeeded" || !finished.Reported {
+		return fmt.Errorf("issue_tracker job did not succeed and get reported: %s", jobJSON)
+	}
+	if !strings.Contains(session, "Checkout times out") {
+		return fmt.Errorf("session memory did not store the job's result event: %s", session)
+	}
+	if !strings.Contains(search, "fixture/repo#3") {
+		return fmt.Errorf("knowledge graph did not add the agent's node events: %s", search)
+	}
+
+	fmt.Println("Event Bus: the job's nodes reached the graph and its result session memory")
+	return nil
+}
+
+// eventBusPy is the Python side of packages/events: the same event
+// envelope, and as much of the NATS protocol as publishing and subscribing
+// take, so no client library is needed.
+const eventBusPy = `#!/usr/bin/env python3
+"""Publishes and subscribes to events on the event bus, a NATS server at
+EVENT_BUS_URL such as nats://bus:4222.
+
+Every event is an envelope {id, type, source, time, traceparent, data}
+whose type is the subject it is published on, and whose traceparent, when
+there is one, is the span that published it, which the subscriber's span
+for the event is a child of (see tracing.py). The Go types in
+packages/events are the schema of each subject's data:
+
+  context.nodes          a knowledge graph node, as a POST /nodes body,
+                         with the graph and tenant it goes in, the default
+                         ones when there are none
+  context.invalidations  a node_id whose node no longer holds, as of at,
+                         with its graph and tenant
+  context.results        a finished job: job_id, session_id, tenant,
+                         agent_type, target, status, context, error and
+                         finished_at
+  agents.jobs            a job's change of status: job_id, session_id,
+                         tenant, agent_type, target, status, attempt and
+                         error
+  tools.invocations      a call through the MCP server's gateway: tool,
+                         tenant, status and duration_ms
+"""
+import json
+import os
+import socket
+import threading
+import uuid
+from datetime import datetime, timezone
+from urllib.parse import unquote, urlparse
+
+import tracing
+
+SUBJECT_NODES = "context.nodes"
+SUBJECT_INVALIDATIONS = "context.invalidations"
+SUBJECT_RESULTS = "context.results"
+
+
+def bus_url():
+    return os.getenv("EVENT_BUS_URL")
+
+
+def envelope(subject, source, data):
+    event = {"id": uuid.uuid4().hex, "type": subject, "source": source,
+             "time": datetime.now(timezone.utc).isoformat(), "data": data}
+    event.update(tracing.headers())
+    return event
+
+
+class Connection:
+    def __init__(self, url, name, timeout=10):
+        parsed = urlparse(url if "://" in url else f"nats://{url}")
+        self.sock = socket.create_connection((parsed.hostname, parsed.port or 4222), timeout=timeout)
+        self.file = self.sock.makefile("rb")
+        self.lock = threading.Lock()
+        info = self.file.readline()
+        if not info.startswith(b"INFO "):
+            self.sock.close()
+            raise ConnectionError(f"{parsed.netloc} is not a NATS server")
+        options = {"verbose": False, "pedantic": False, "name": name, "lang": "python", "version": "0.1.0",
+                   "protocol": 1}
+        if parsed.username:
+            options.update(user=unquote(parsed.username), **{"pass": unquote(parsed.password or "")})
+        self.send(b"CONNECT " + json.dumps(options).encode() + b"\r\n")
+        self.sock.settimeout(None)
+
+    def send(self, data):
+        with self.lock:
+            self.sock.sendall(data)
+
+    def publish(self, event):
+        payload = json.dumps(event).encode()
+        self.send(f"PUB {event['type']} {len(payload)}\r\n".encode() + payload + b"\r\n")
+
+    def flush(self, timeout=10):
+        """Waits until the server has processed everything sent before"""
+        self.sock.settimeout(timeout)
+        self.send(b"PING\r\n")
+        while True:
+            line = self.file.readline()
+            if not line:
+                raise ConnectionError("the event bus closed the connection")
+            if line.startswith(b"-ERR"):
+                raise ConnectionError(line.decode().strip())
+            if line.startswith(b"PONG"):
+                self.sock.settimeout(None)
+                return
+
+    def close(self):
+        try:
+            self.sock.close()
+        except OSError:
+            pass
+
+
+def publish(subject, source, items, url=None):
+    """Publishes each item as the data of an event on subject, and returns
+    once the server has them all. Nodes and invalidations without a tenant
+    are for the AGENT_TENANT the orchestrator gives agents, if any."""
+    tenant = os.getenv("AGENT_TENANT")
+    with tracing.span(f"{subject} publish", tracing.KIND_PRODUCER):
+        connection = Connection(url or bus_url(), source)
+        try:
+            for data in items:
+                if tenant and subject in (SUBJECT_NODES, SUBJECT_INVALIDATIONS):
+                    data = {"tenant": tenant, **data}
+                connection.publish(envelope(subject, source, data))
+            connection.flush()
+        finally:
+            connection.close()
+
+
+class Subscription:
+    """Calls handle(event) on a thread for each event on subject, and
+    connects again when the connection is lost. The subscribers in one
+    queue group share the events, each going to one of them."""
+
+    def __init__(self, subject, queue, name, handle, url=None):
+        self.subject, self.queue, self.name, self.handle = subject, queue, name, handle
+        self.url = url or bus_url()
+        self.stopped = threading.Event()
+        self.connection = None
+        self.thread = threading.Thread(target=self.loop, name=f"event-bus-{subject}", daemon=True)
+
+    def start(self):
+        self.thread.start()
+
+    def stop(self):
+        self.stopped.set()
+        if self.connection:
+            self.connection.close()
+
+    def loop(self):
+        wait = 1
+        while not self.stopped.is_set():
+            try:
+                self.listen()
+                wait = 1
+            except (OSError, ConnectionError, ValueError) as e:
+                if self.stopped.is_set():
+                    return
+                print(f"⚠️ Event bus: {self.subject} subscription lost, retrying in {wait}s: {e}")
+            self.stopped.wait(wait)
+            wait = min(wait * 2, 30)
+
+    def listen(self):
+        self.connection = connection = Connection(self.url, self.name)
+        connection.send(f"SUB {self.subject} {self.queue} 1\r\n".encode())
+        print(f"📬 Subscribed to {self.subject} on the event bus")
+        while not self.stopped.is_set():
+            line = connection.file.readline()
+            if not line:
+                raise ConnectionError("the event bus closed the connection")
+            if line.startswith(b"MSG "):
+                size = int(line.split()[-1])
+                payload = connection.file.read(size + 2)[:size]
+                self.deliver(payload)
+            elif line.startswith(b"PING"):
+                connection.send(b"PONG\r\n")
+            elif line.startswith(b"-ERR"):
+                raise ConnectionError(line.decode().strip())
+
+    def deliver(self, payload):
+        try:
+            event = json.loads(payload)
+        except ValueError:
+            print(f"⚠️ Event bus: dropping a malformed event on {self.subject}")
+            return
+        if event.get("type") != self.subject or not isinstance(event.get("data"), dict):
+            print(f"⚠️ Event bus: dropping event {event.get('id')}, which is not a {self.subject} event")
+            return
+        try:
+            with tracing.span(f"{self.subject} receive", tracing.KIND_CONSUMER, tracing.parse(event.get("traceparent")),
+                              {"messaging.message.id": str(event.get("id")), "messaging.source": str(event.get("source"))}):
+                self.handle(event)
+        except Exception as e:
+            print(f"⚠️ Event bus: {self.subject} event {event.get('id')} from {event.get('source')} failed: {e}")
+`
+
+// eventBusJs is the MCP server's side of packages/events: the same event
+// envelope, and as much of the NATS protocol as publishing takes.
+const eventBusJs = `// Publishes events on the event bus, a NATS server at EVENT_BUS_URL such
+// as nats://bus:4222, in the envelope of packages/events. Publishing never
+// waits on the bus: an event it cannot take is dropped, and the connection
+// is made again for the next one.
+const crypto = require('crypto');
+const net = require('net');
+const tracing = require('./tracing');
+
+const SUBJECT_TOOLS = 'tools.invocations';
+
+class Publisher {
+    constructor(url, source) {
+        const parsed = new URL(url.includes('://') ? url : 'nats://' + url);
+        this.host = parsed.hostname;
+        this.port = Number(parsed.port) || 4222;
+        this.options = { verbose: false, pedantic: false, name: source, lang: 'node', version: '0.1.0', protocol: 1 };
+        if (parsed.username) {
+            this.options.user = decodeURIComponent(parsed.username);
+            this.options.pass = decodeURIComponent(parsed.password);
+        }
+        this.source = source;
+        this.socket = null;
+    }
+
+    connect() {
+        const socket = net.connect(this.port, this.host);
+        socket.setNoDelay(true);
+        socket.on('data', (chunk) => {
+            // The server pings idle clients
+            if (chunk.toString().includes('PING\r\n')) {
+                socket.write('PONG\r\n');
+            }
+        });
+        socket.on('error', () => socket.destroy());
+        socket.on('close', () => {
+            if (this.socket === socket) {
+                this.socket = null;
+            }
+        });
+        // Written once the connection is up, before anything published
+        socket.write('CONNECT ' + JSON.stringify(this.options) + '\r\n');
+        this.socket = socket;
+    }
+
+    publish(subject, data) {
+        if (!this.socket) {
+            this.connect();
+        }
+        const event = { id: crypto.randomBytes(16).toString('hex'), type: subject, source: this.source,
+            time: new Date().toISOString(), ...tracing.headers(), data };
+        const payload = Buffer.from(JSON.stringify(event));
+        this.socket.write('PUB ' + subject + ' ' + payload.length + '\r\n');
+        this.socket.write(Buffer.concat([payload, Buffer.from('\r\n')]));
+    }
+}
+
+// A publisher for EVENT_BUS_URL, or null without one
+function fromEnv(source) {
+    return process.env.EVENT_BUS_URL ? new Publisher(process.env.EVENT_BUS_URL, source) : null;
+}
+
+module.exports = { Publisher, fromEnv, SUBJECT_TOOLS };
+`
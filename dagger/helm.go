@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// helmImage lints, renders and packages the generated chart.
+const helmImage = "alpine/helm:3.14.0"
+
+const helmChartName = "dynamic-context"
+
+// helmTarget fills in the addresses of the services as the chart names
+// them, after the release.
+var helmTarget = infraTarget{
+	name: "helm",
+	address: func(service deployedService, host bool) string {
+		name := "{{ .Release.Name }}-" + service.name
+		if host {
+			return name
+		}
+		return fmt.Sprintf("%s://%s:%d", service.scheme, name, service.ports[0])
+	},
+}
+
+// splitImageRef is an image reference's repository and tag, the tag
+// keeping any digest after it.
+func splitImageRef(ref string) (string, string) {
+	name := ref
+	if at := strings.Index(ref, "@"); at >= 0 {
+		name = ref[:at]
+	}
+	colon := strings.LastIndex(name, ":")
+	if colon < strings.LastIndex(name, "/") {
+		return ref, ""
+	}
+	return ref[:colon], ref[colon+1:]
+}
+
+// helmValues are the chart's values: every service's image, replicas,
+// resources and more environment, and the secrets and ingress they share.
+// A component's image is the one published for it, or else one named after
+// it and tagged the chart's app version.
+func helmValues(images map[string]string) string {
+	var values strings.Builder
+	values.WriteString(`# Generated by the pipeline from the components it builds. Each service runs
+# as a Deployment, or for a store a StatefulSet, named after the release.
+
+imagePullSecrets: []
+
+# The Kubernetes secret holding the Neo4j password, and its key
+neo4jPassword:
+  secretName: neo4j
+  key: password
+
+# More secrets for the components, such as API keys for the agents: the
+# secret and key of each by the environment variable it is given in
+secrets: {}
+
+# The MCP server's ingress; with a TLS secret, host is required
+ingress:
+  enabled: false
+  className: ""
+  annotations: {}
+  host: ""
+  tls:
+    secretName: ""
+
+# Every service keeps its state in its process or on its volume, so each
+# replica past the first has state of its own
+services:
+`)
+	for _, service := range deployedServices {
+		repository, tag := service.name, ""
+		switch {
+		case service.store():
+			repository, tag = splitImageRef(service.image)
+		case images[service.name] != "":
+			repository, tag = splitImageRef(images[service.name])
+		}
+		cpu, memory := service.kubernetesQuantities()
+		fmt.Fprintf(&values, `  %s:
+    image:
+      repository: %s
+      tag: %q
+    replicas: 1
+    resources:
+      requests:
+        cpu: %s
+        memory: %s
+      limits:
+        memory: %s
+    env: {}
+`, service.name, repository, tag, cpu, memory, memory)
+		if service.store() {
+			fmt.Fprintf(&values, "    persistence:\n      size: %dGi\n      storageClass: \"\"\n", service.volumeGB)
+		}
+	}
+	return values.String()
+}
+
+// renderHelmService is the template of a service: its Deployment, or for a
+// store its StatefulSet with a volume claim, and its Service.
+func renderHelmService(service deployedService) string {
+	var template strings.Builder
+	kind := "Deployment"
+	if service.store() {
+		kind = "StatefulSet"
+	}
+	fmt.Fprintf(&template, `{{- $s := index .Values.services %[1]q }}
+apiVersion: apps/v1
+kind: %[2]s
+metadata:
+  name: {{ .Release.Name }}-%[1]s
+  labels:
+    {{- include "dynamic-context.labels" . | nindent 4 }}
+    app.kubernetes.io/component: %[1]s
+spec:
+  replicas: {{ $s.replicas }}
+`, service.name, kind)
+	if service.store() {
+		fmt.Fprintf(&template, "  serviceName: {{ .Release.Name }}-%s\n", service.name)
+	} else {
+		template.WriteString(`  # One pod at a time: the old one stops before the new one starts
+  strategy:
+    type: Recreate
+`)
+	}
+	fmt.Fprintf(&template, `  selector:
+    matchLabels:
+      {{- include "dynamic-context.selectorLabels" . | nindent 6 }}
+      app.kubernetes.io/component: %[1]s
+  template:
+    metadata:
+      labels:
+        {{- include "dynamic-context.selectorLabels" . | nindent 8 }}
+        app.kubernetes.io/component: %[1]s
+    spec:
+      # Kubernetes would otherwise give every pod NEO4J_PORT and the like,
+      # which Neo4j takes for settings
+      enableServiceLinks: false
+      {{- with .Values.imagePullSecrets }}
+      imagePullSecrets:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      containers:
+        - name: %[1]s
+          image: "{{ $s.image.repository }}:{{ $s.image.tag | default .Chart.AppVersion }}"
+`, service.name)
+	if len(service.command) > 0 {
+		fmt.Fprintf(&template, "          command: %s\n", hclList(service.command))
+	}
+	if len(service.args) > 0 {
+		fmt.Fprintf(&template, "          args: %s\n", hclList(service.args))
+	}
+	template.WriteString("          ports:\n")
+	for _, port := range service.ports {
+		fmt.Fprintf(&template, "            - containerPort: %d\n", port)
+	}
+
+	env := helmTarget.sortedEnv(service)
+	if service.neo4jAuth {
+		env = append(env, [2]string{"NEO4J_AUTH", fmt.Sprintf("neo4j/$(%s)", service.neo4jPassword)})
+	}
+	if len(env) == 0 && service.neo4jPassword == "" {
+		template.WriteString(`          {{- with $s.env }}
+          env:
+            {{- range $name, $value := . }}
+            - name: {{ $name }}
+              value: {{ $value | quote }}
+            {{- end }}
+          {{- end }}
+`)
+	} else {
+		template.WriteString("          env:\n")
+		// The password comes first, as NEO4J_AUTH is made from it
+		if service.neo4jPassword != "" {
+			fmt.Fprintf(&template, `            - name: %s
+              valueFrom:
+                secretKeyRef:
+                  name: {{ .Values.neo4jPassword.secretName }}
+                  key: {{ .Values.neo4jPassword.key }}
+`, service.neo4jPassword)
+		}
+		for _, pair := range env {
+			fmt.Fprintf(&template, "            - name: %s\n              value: %q\n", pair[0], pair[1])
+		}
+		if !service.store() {
+			template.WriteString(`            {{- range $name, $ref := .Values.secrets }}
+            - name: {{ $name }}
+              valueFrom:
+                secretKeyRef:
+                  name: {{ $ref.name }}
+                  key: {{ $ref.key }}
+            {{- end }}
+`)
+		}
+		template.WriteString(`            {{- range $name, $value := $s.env }}
+            - name: {{ $name }}
+              value: {{ $value | quote }}
+            {{- end }}
+`)
+	}
+	template.WriteString(`          resources:
+            {{- toYaml $s.resources | nindent 12 }}
+`)
+	if service.health != "" {
+		fmt.Fprintf(&template, `          readinessProbe:
+            httpGet:
+              path: %s
+              port: %d
+`, service.health, service.ports[0])
+	}
+	if service.store() {
+		fmt.Fprintf(&template, `          volumeMounts:
+            - name: data
+              mountPath: %s
+  volumeClaimTemplates:
+    - metadata:
+        name: data
+      spec:
+        accessModes: ["ReadWriteOnce"]
+        {{- with $s.persistence.storageClass }}
+        storageClassName: {{ . }}
+        {{- end }}
+        resources:
+          requests:
+            storage: {{ $s.persistence.size }}
+`, service.volume)
+	}
+
+	fmt.Fprintf(&template, `---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}-%[1]s
+  labels:
+    {{- include "dynamic-context.labels" . | nindent 4 }}
+    app.kubernetes.io/component: %[1]s
+spec:
+  selector:
+    {{- include "dynamic-context.selectorLabels" . | nindent 4 }}
+    app.kubernetes.io/component: %[1]s
+  ports:
+`, service.name)
+	for _, port := range service.ports {
+		fmt.Fprintf(&template, "    - name: port-%d\n      port: %d\n      targetPort: %d\n", port, port, port)
+	}
+	return template.String()
+}
+
+// renderHelmIngress routes the ingress to the public service.
+func renderHelmIngress(service deployedService) string {
+	return fmt.Sprintf(`{{- if .Values.ingress.enabled }}
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: {{ .Release.Name }}
+  labels:
+    {{- include "dynamic-context.labels" . | nindent 4 }}
+  {{- with .Values.ingress.annotations }}
+  annotations:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+spec:
+  {{- with .Values.ingress.className }}
+  ingressClassName: {{ . }}
+  {{- end }}
+  {{- if .Values.ingress.tls.secretName }}
+  tls:
+    - hosts:
+        - {{ required "ingress.host is required with a TLS secret" .Values.ingress.host | quote }}
+      secretName: {{ .Values.ingress.tls.secretName }}
+  {{- end }}
+  rules:
+    - http:
+        paths:
+          - path: /
+            pathType: Prefix
+            backend:
+              service:
+                name: {{ .Release.Name }}-%s
+                port:
+                  number: %d
+      {{- with .Values.ingress.host }}
+      host: {{ . | quote }}
+      {{- end }}
+{{- end }}
+`, service.name, service.ports[0])
+}
+
+const helmHelpers = `{{- define "dynamic-context.selectorLabels" -}}
+app.kubernetes.io/name: {{ .Chart.Name }}
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end }}
+
+{{- define "dynamic-context.labels" -}}
+{{ include "dynamic-context.selectorLabels" . }}
+helm.sh/chart: {{ printf "%s-%s" .Chart.Name .Chart.Version }}
+app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end }}
+`
+
+// helmChart is the chart at version, its app version the tag the
+// components are published with.
+func helmChart(client *dagger.Client, version string, images map[string]string) *dagger.Directory {
+	chart := client.Directory().
+		WithNewFile("Chart.yaml", fmt.Sprintf(`apiVersion: v2
+name: %s
+description: The knowledge graph, session memory, MCP server and orchestrator, with the stores they keep their state in
+type: application
+version: %s
+appVersion: %q
+`, helmChartName, version, deployedTag())).
+		WithNewFile("values.yaml", helmValues(images)).
+		WithNewFile("templates/_helpers.tpl", helmHelpers)
+	for _, service := range deployedServices {
+		chart = chart.WithNewFile("templates/"+service.name+".yaml", renderHelmService(service))
+		if service.public {
+			chart = chart.WithNewFile("templates/ingress.yaml", renderHelmIngress(service))
+		}
+	}
+	return chart
+}
+
+// generateHelmChart writes a Helm chart of the services to dir/helm, with
+// the components' published images when there are any, linted and
+// rendered with and without its ingress, and packaged as HELM_CHART_VERSION
+// or 0.1.0. With HELM_REGISTRY set the package is pushed there as an OCI
+// artifact, logged in as HELM_REGISTRY_USERNAME with
+// HELM_REGISTRY_PASSWORD when they are set. It only runs when HELM_CHART
+// is set on the host.
+func generateHelmChart(ctx context.Context, client *dagger.Client, images map[string]string, dir string) error {
+	if os.Getenv("HELM_CHART") == "" {
+		fmt.Println("⏭️ Skipping Helm chart: HELM_CHART is not set")
+		return nil
+	}
+	fmt.Println("⛵ Packaging Helm Chart...")
+
+	version := os.Getenv("HELM_CHART_VERSION")
+	if version == "" {
+		version = "0.1.0"
+	}
+	script := fmt.Sprintf(`set -e
+helm lint --strict %[1]s
+helm template ci %[1]s >/dev/null
+helm template ci %[1]s --set ingress.enabled=true --set ingress.host=mcp.example.com --set ingress.tls.secretName=mcp-tls >/dev/null
+helm package %[1]s >/dev/null
+`, helmChartName)
+	packaged := client.Container().
+		From(helmImage).
+		WithDirectory("/helm/"+helmChartName, helmChart(client, version, images)).
+		WithWorkdir("/helm")
+	if registry := os.Getenv("HELM_REGISTRY"); registry != "" {
+		if user := os.Getenv("HELM_REGISTRY_USERNAME"); user != "" {
+			packaged = packaged.
+				WithEnvVariable("HELM_REGISTRY_USERNAME", user).
+				WithSecretVariable("HELM_REGISTRY_PASSWORD", client.SetSecret("helm-registry-password", os.Getenv("HELM_REGISTRY_PASSWORD")))
+			script += fmt.Sprintf("echo \"$HELM_REGISTRY_PASSWORD\" | helm registry login %q -u \"$HELM_REGISTRY_USERNAME\" --password-stdin\n", strings.SplitN(registry, "/", 2)[0])
+		}
+		script += fmt.Sprintf("helm push %s-%s.tgz %q\n", helmChartName, version, "oci://"+strings.TrimRight(registry, "/"))
+	}
+	packaged = packaged.WithExec([]string{"sh", "-c", script}, dagger.ContainerWithExecOpts{SkipEntrypoint: true})
+	output, err := packaged.Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Print(output)
+	if _, err := packaged.Directory("/helm").Export(ctx, dir+"/helm"); err != nil {
+		return fmt.Errorf("exporting chart: %w", err)
+	}
+	fmt.Printf("Helm chart: %s/helm/%s, %s/helm/%s-%s.tgz\n", dir, helmChartName, dir, helmChartName, version)
+	return nil
+}
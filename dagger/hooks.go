@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+
+	"dagger.io/dagger"
+)
+
+// pipelineStages are the stages of runPipeline that hooks run around, in
+// the order they run.
+var pipelineStages = []string{"build", "test", "integration", "verify", "artifacts", "load-test", "publish", "deploy"}
+
+var hookNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// pipelineHook is a command pipeline.json runs in a container of its own
+// before or after a stage. Env is set as it is; Secrets are set from the
+// host's variables they name, so their values stay out of the file. A hook
+// that fails fails the pipeline unless it is Optional.
+type pipelineHook struct {
+	Name     string            `json:"name"`
+	Stage    string            `json:"stage"`
+	When     string            `json:"when"`
+	Image    string            `json:"image"`
+	Command  []string          `json:"command"`
+	Env      map[string]string `json:"env,omitempty"`
+	Secrets  map[string]string `json:"secrets,omitempty"`
+	Optional bool              `json:"optional,omitempty"`
+}
+
+// checkHooks finds what is wrong with pipeline.json's hooks before
+// anything runs: a stage that is not one, a hook without an image or a
+// command, two of the same name or a secret the host does not have.
+func (c pipelineConfig) checkHooks(path string) error {
+	names := map[string]bool{}
+	for i, hook := range c.Hooks {
+		switch {
+		case !hookNamePattern.MatchString(hook.Name):
+			return fmt.Errorf("%s: hook %d: name %q must be lowercase letters, digits, _ and -", path, i, hook.Name)
+		case names[hook.Name]:
+			return fmt.Errorf("%s: hook %s is there twice", path, hook.Name)
+		case !slices.Contains(pipelineStages, hook.Stage):
+			return fmt.Errorf("%s: hook %s: stage %q is not one of %v", path, hook.Name, hook.Stage, pipelineStages)
+		case hook.When != "before" && hook.When != "after":
+			return fmt.Errorf("%s: hook %s: when is before or after, not %q", path, hook.Name, hook.When)
+		case hook.Image == "" || len(hook.Command) == 0:
+			return fmt.Errorf("%s: hook %s needs an image and a command", path, hook.Name)
+		}
+		for name, variable := range hook.Secrets {
+			if os.Getenv(variable) == "" {
+				return fmt.Errorf("%s: hook %s: secret %s needs %s set", path, hook.Name, name, variable)
+			}
+		}
+		names[hook.Name] = true
+	}
+	return nil
+}
+
+// runHooks runs the hooks of a stage that run when, before or after it, in
+// the order pipeline.json has them. Each has the repository at /src, which
+// it starts in, and what it writes to /out is exported to
+// build/hooks/NAME. PIPELINE_STAGE and PIPELINE_HOOK say where in the
+// pipeline it runs, and env, such as PIPELINE_IMAGES after publish, what
+// the stage did. PIPELINE_RUN differs each run, so a hook is never cached.
+func runHooks(ctx context.Context, client *dagger.Client, config pipelineConfig, stage, when string, env map[string]string) error {
+	for _, hook := range config.Hooks {
+		if hook.Stage != stage || hook.When != when {
+			continue
+		}
+		fmt.Printf("🪝 Running %s hook %s %s...\n", hook.Name, when, stage)
+
+		container := client.Container().
+			From(hook.Image).
+			WithDirectory("/src", client.Host().Directory(".", dagger.HostDirectoryOpts{Exclude: []string{".git", "build", "node_modules"}})).
+			WithDirectory("/out", client.Directory()).
+			WithWorkdir("/src").
+			WithEnvVariable("PIPELINE_STAGE", stage).
+			WithEnvVariable("PIPELINE_HOOK", when).
+			WithEnvVariable("PIPELINE_RUN", config.run)
+		for _, vars := range []map[string]string{env, hook.Env} {
+			keys := make([]string, 0, len(vars))
+			for key := range vars {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				container = container.WithEnvVariable(key, vars[key])
+			}
+		}
+		secrets := make([]string, 0, len(hook.Secrets))
+		for name := range hook.Secrets {
+			secrets = append(secrets, name)
+		}
+		sort.Strings(secrets)
+		for _, name := range secrets {
+			secret := client.SetSecret("hook-"+hook.Name+"-"+name, os.Getenv(hook.Secrets[name]))
+			container = container.WithSecretVariable(name, secret)
+		}
+
+		_, err := container.
+			WithExec(hook.Command, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+			Directory("/out").
+			Export(ctx, filepath.Join("build", "hooks", hook.Name))
+		if err != nil {
+			if hook.Optional {
+				fmt.Printf("⚠️ Hook %s failed, and is optional: %v\n", hook.Name, err)
+				continue
+			}
+			return fmt.Errorf("%s hook %s %s: %w", hook.Name, when, stage, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// terraformImage formats and validates the generated modules.
+const terraformImage = "hashicorp/terraform:1.7.5"
+
+// deployedService is a component as the generated infrastructure runs it:
+// one of the pipeline's images, or for a store a public one, with the
+// environment that points it at the others. Every service runs as one
+// replica, as each keeps state in its process or on its volume.
+type deployedService struct {
+	name string
+	// image is a store's public image; a component's comes from var.images
+	image   string
+	command []string
+	args    []string
+	ports   []int
+	// scheme is what a URL of the service starts with
+	scheme string
+	cpu    float64
+	memory int
+	public bool
+	health string
+	// volume is where a store keeps its data, owned by volumeOwner (uid,
+	// gid) and by default volumeGB large
+	volume      string
+	volumeOwner [2]int
+	volumeGB    int
+	// env is by name, with the address of another service written
+	// {url:name} or {host:name} and filled in for each platform
+	env map[string]string
+	// neo4jPassword is the variable the Neo4j password is given in
+	neo4jPassword string
+	// neo4jAuth sets NEO4J_AUTH from the password, for Neo4j itself
+	neo4jAuth bool
+}
+
+func (s deployedService) id() string {
+	return strings.ReplaceAll(s.name, "-", "_")
+}
+
+func (s deployedService) store() bool {
+	return s.image != ""
+}
+
+// deployedServices are what a deployment runs: the stores, then the
+// components in the order they need each other. The stores' images are the
+// ones the pipeline tests against.
+var deployedServices = []deployedService{
+	{name: "redis", image: "redis:7-alpine", args: []string{"redis-server", "--appendonly", "yes"},
+		ports: []int{6379}, scheme: "redis", cpu: 0.25, memory: 512,
+		volume: "/data", volumeOwner: [2]int{999, 1000}, volumeGB: 10},
+	{name: "neo4j", image: "neo4j:5-community", ports: []int{7687, 7474}, scheme: "bolt", cpu: 1, memory: 2048,
+		volume: "/data", volumeOwner: [2]int{7474, 7474}, volumeGB: 20,
+		env: map[string]string{
+			"NEO4J_server_memory_heap_max__size": "1g",
+			"NEO4J_server_memory_pagecache_size": "512m",
+		},
+		neo4jPassword: "DB_PASSWORD", neo4jAuth: true},
+	{name: "qdrant", image: "qdrant/qdrant:v1.7.4", ports: []int{6333}, scheme: "http", cpu: 0.5, memory: 1024,
+		volume: "/qdrant/storage", volumeGB: 10},
+	{name: "knowledge-graph", command: []string{"python3", "/app/kg_server.py"}, ports: []int{knowledgeGraphPort},
+		scheme: "http", cpu: 1, memory: 2048, health: "/health",
+		env: map[string]string{
+			"KG_PORT":         fmt.Sprint(knowledgeGraphPort),
+			"KG_BACKEND":      "neo4j",
+			"NEO4J_URI":       "{url:neo4j}",
+			"NEO4J_USER":      "neo4j",
+			"KG_VECTOR_INDEX": "qdrant",
+			"QDRANT_URL":      "{url:qdrant}",
+		},
+		neo4jPassword: "NEO4J_PASSWORD"},
+	{name: "session-memory", command: []string{"python3", "/app/session_server.py"}, ports: []int{sessionMemoryPort},
+		scheme: "http", cpu: 0.5, memory: 1024, health: "/health",
+		env: map[string]string{
+			"SESSION_MEMORY_PORT": fmt.Sprint(sessionMemoryPort),
+			"SESSION_STORE":       "redis",
+			"REDIS_HOST":          "{host:redis}",
+			"REDIS_PORT":          "6379",
+			"KNOWLEDGE_GRAPH_URL": "{url:knowledge-graph}",
+		}},
+	{name: "mcp-server", ports: []int{3000}, scheme: "http", cpu: 0.25, memory: 512, public: true, health: "/health",
+		env: map[string]string{
+			"SESSION_MEMORY_URL": "{url:session-memory}",
+		}},
+	{name: "orchestrator", ports: []int{orchestratorPort}, scheme: "http", cpu: 1, memory: 2048, health: "/health",
+		env: map[string]string{
+			"ORCH_PORT":           fmt.Sprint(orchestratorPort),
+			"ORCH_RUNTIME":        "exec",
+			"MCP_SERVER_URL":      "{url:mcp-server}",
+			"KNOWLEDGE_GRAPH_URL": "{url:knowledge-graph}",
+			"SESSION_MEMORY_URL":  "{url:session-memory}",
+		}},
+}
+
+// deployedComponents are the services run from the pipeline's images.
+func deployedComponents() []deployedService {
+	var components []deployedService
+	for _, service := range deployedServices {
+		if !service.store() {
+			components = append(components, service)
+		}
+	}
+	return components
+}
+
+// deployedServiceNamed is the deployed service of a name.
+func deployedServiceNamed(name string) deployedService {
+	for _, service := range deployedServices {
+		if service.name == name {
+			return service
+		}
+	}
+	panic("no deployed service " + name)
+}
+
+var addressPattern = regexp.MustCompile(`\{(url|host):([a-z0-9-]+)\}`)
+
+// infraTarget is a platform the pipeline writes a Terraform module for,
+// with where it runs each service.
+type infraTarget struct {
+	name string
+	// address is a service's URL, or with host its host name, as a
+	// Terraform string
+	address func(service deployedService, host bool) string
+	// runs is whether the module runs a service itself
+	runs   func(service deployedService) bool
+	render func(module *strings.Builder)
+	files  map[string]string
+}
+
+// expand fills the addresses in an environment value in for a target.
+func (t infraTarget) expand(value string) string {
+	return addressPattern.ReplaceAllStringFunc(value, func(match string) string {
+		parts := addressPattern.FindStringSubmatch(match)
+		return t.address(deployedServiceNamed(parts[2]), parts[1] == "host")
+	})
+}
+
+// sortedEnv is a service's environment in name order, with the addresses
+// filled in.
+func (t infraTarget) sortedEnv(service deployedService) [][2]string {
+	names := make([]string, 0, len(service.env))
+	for name := range service.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := make([][2]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, [2]string{name, t.expand(service.env[name])})
+	}
+	return env
+}
+
+// hclList is a list of strings in HCL.
+func hclList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// image is a service's image as a Terraform expression.
+func (s deployedService) imageExpr() string {
+	if s.store() {
+		return fmt.Sprintf("%q", s.image)
+	}
+	return fmt.Sprintf("var.images[%q]", s.name)
+}
+
+// imagesVariable is the images of the components, which every module
+// takes.
+func imagesVariable() string {
+	names := make([]string, 0)
+	for _, service := range deployedComponents() {
+		names = append(names, service.name)
+	}
+	return fmt.Sprintf(`variable "name" {
+  description = "Prefix of every resource's name"
+  type        = string
+  default     = "dynamic-context"
+}
+
+variable "images" {
+  description = "Image of each component, by name: %s. The pipeline writes images.auto.tfvars.json when it publishes them"
+  type        = map(string)
+
+  validation {
+    condition     = alltrue([for name in %s : contains(keys(var.images), name)])
+    error_message = "images needs an image for each of %s."
+  }
+}
+`, strings.Join(names, ", "), hclList(names), strings.Join(names, ", "))
+}
+
+// ecsTarget runs every service on Fargate in a VPC of its own, the stores
+// on EFS and the MCP server behind a load balancer. The services find each
+// other through a Cloud Map namespace.
+func ecsTarget() infraTarget {
+	t := infraTarget{name: "ecs", runs: func(deployedService) bool { return true }}
+	t.address = func(service deployedService, host bool) string {
+		name := service.name + ".${aws_service_discovery_private_dns_namespace.main.name}"
+		if host {
+			return name
+		}
+		return fmt.Sprintf("%s://%s:%d", service.scheme, name, service.ports[0])
+	}
+	t.render = func(module *strings.Builder) {
+		module.WriteString(ecsMain)
+		for _, service := range deployedServices {
+			renderECSService(module, t, service)
+		}
+	}
+	t.files = map[string]string{"variables.tf": imagesVariable() + ecsVariables, "outputs.tf": ecsOutputs}
+	return t
+}
+
+func renderECSService(module *strings.Builder, t infraTarget, service deployedService) {
+	id := service.id()
+	fmt.Fprintf(module, "\n# %s\n\n", service.name)
+	if service.store() {
+		fmt.Fprintf(module, `resource "aws_efs_access_point" %[1]q {
+  file_system_id = aws_efs_file_system.data.id
+
+  root_directory {
+    path = "/%[2]s"
+
+    creation_info {
+      owner_uid   = %[3]d
+      owner_gid   = %[4]d
+      permissions = "0755"
+    }
+  }
+}
+
+`, id, service.name, service.volumeOwner[0], service.volumeOwner[1])
+	}
+
+	fmt.Fprintf(module, `resource "aws_ecs_task_definition" %q {
+  family                   = "${var.name}-%s"
+  requires_compatibilities = ["FARGATE"]
+  network_mode             = "awsvpc"
+  cpu                      = %d
+  memory                   = %d
+  execution_role_arn       = aws_iam_role.execution.arn
+
+  container_definitions = jsonencode([{
+    name      = %q
+    image     = %s
+    essential = true
+`, id, service.name, int(service.cpu*1024), service.memory, service.name, service.imageExpr())
+	switch {
+	case service.neo4jAuth:
+		// NEO4J_AUTH is the password behind the user name, which ECS
+		// cannot put together from a secret
+		fmt.Fprintf(module, "    entryPoint = [\"sh\", \"-c\"]\n    command    = [%q]\n",
+			fmt.Sprintf(`NEO4J_AUTH="neo4j/$%s" exec tini -g -- /startup/docker-entrypoint.sh neo4j`, service.neo4jPassword))
+	case len(service.command) > 0:
+		fmt.Fprintf(module, "    entryPoint = %s\n", hclList(service.command))
+	case len(service.args) > 0:
+		fmt.Fprintf(module, "    command = %s\n", hclList(service.args))
+	}
+	module.WriteString("    portMappings = [\n")
+	for _, port := range service.ports {
+		fmt.Fprintf(module, "      { containerPort = %d },\n", port)
+	}
+	module.WriteString("    ]\n    environment = [\n")
+	for _, env := range t.sortedEnv(service) {
+		fmt.Fprintf(module, "      { name = %q, value = %q },\n", env[0], env[1])
+	}
+	module.WriteString("    ]\n")
+	var secrets []string
+	if service.neo4jPassword != "" {
+		secrets = append(secrets, fmt.Sprintf("{ name = %q, valueFrom = var.neo4j_password_secret }", service.neo4jPassword))
+	}
+	if !service.store() {
+		fmt.Fprintf(module, "    secrets = concat([%s], [for name, secret in var.secrets : { name = name, valueFrom = secret }])\n", strings.Join(secrets, ", "))
+	} else if len(secrets) > 0 {
+		fmt.Fprintf(module, "    secrets = [%s]\n", strings.Join(secrets, ", "))
+	}
+	if service.store() {
+		fmt.Fprintf(module, "    mountPoints = [{ sourceVolume = \"data\", containerPath = %q }]\n", service.volume)
+	}
+	fmt.Fprintf(module, `    logConfiguration = {
+      logDriver = "awslogs"
+      options = {
+        "awslogs-group"         = aws_cloudwatch_log_group.main.name
+        "awslogs-region"        = var.region
+        "awslogs-stream-prefix" = %q
+      }
+    }
+  }])
+`, service.name)
+	if service.store() {
+		fmt.Fprintf(module, `
+  volume {
+    name = "data"
+
+    efs_volume_configuration {
+      file_system_id     = aws_efs_file_system.data.id
+      transit_encryption = "ENABLED"
+
+      authorization_config {
+        access_point_id = aws_efs_access_point.%s.id
+      }
+    }
+  }
+`, id)
+	}
+	module.WriteString("}\n\n")
+
+	fmt.Fprintf(module, `resource "aws_service_discovery_service" %[1]q {
+  name = %[2]q
+
+  dns_config {
+    namespace_id = aws_service_discovery_private_dns_namespace.main.id
+
+    dns_records {
+      ttl  = 10
+      type = "A"
+    }
+  }
+}
+
+resource "aws_ecs_service" %[1]q {
+  name            = %[2]q
+  cluster         = aws_ecs_cluster.main.id
+  task_definition = aws_ecs_task_definition.%[1]s.arn
+  desired_count   = 1
+  launch_type     = "FARGATE"
+
+  # One task at a time: the old one stops before the new one starts
+  deployment_minimum_healthy_percent = 0
+  deployment_maximum_percent         = 100
+
+  network_configuration {
+    subnets         = aws_subnet.private[*].id
+    security_groups = [aws_security_group.services.id]
+  }
+
+  service_registries {
+    registry_arn = aws_service_discovery_service.%[1]s.arn
+  }
+`, id, service.name)
+	dependsOn := "aws_iam_role_policy.secrets"
+	if service.store() {
+		dependsOn = "aws_efs_mount_target.data"
+	}
+	if service.public {
+		fmt.Fprintf(module, `
+  load_balancer {
+    target_group_arn = aws_lb_target_group.%s.arn
+    container_name   = %q
+    container_port   = %d
+  }
+`, id, service.name, service.ports[0])
+		dependsOn += ", aws_lb_listener.http"
+	}
+	fmt.Fprintf(module, "\n  depends_on = [%s]\n}\n", dependsOn)
+	if service.public {
+		fmt.Fprintf(module, `
+resource "aws_lb_target_group" %q {
+  name        = "${var.name}-%s"
+  port        = %d
+  protocol    = "HTTP"
+  target_type = "ip"
+  vpc_id      = aws_vpc.main.id
+
+  health_check {
+    path = %q
+  }
+
+  # Socket.IO's polling stays with the task that opened it
+  stickiness {
+    type    = "lb_cookie"
+    enabled = true
+  }
+}
+`, id, service.name, service.ports[0], service.health)
+	}
+}
+
+const ecsMain = `# Generated by the pipeline: every service on Fargate in a VPC of its own.
+# The stores keep their data on EFS and the MCP server is behind a load
+# balancer; the services find each other under the Cloud Map namespace.
+
+terraform {
+  required_version = ">= 1.5"
+
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = ">= 5.0"
+    }
+  }
+}
+
+provider "aws" {
+  region = var.region
+}
+
+data "aws_availability_zones" "available" {
+  state = "available"
+}
+
+# Networking: public subnets for the load balancer and the NAT gateway,
+# private ones for the services
+
+resource "aws_vpc" "main" {
+  cidr_block           = var.vpc_cidr
+  enable_dns_support   = true
+  enable_dns_hostnames = true
+
+  tags = {
+    Name = var.name
+  }
+}
+
+resource "aws_internet_gateway" "main" {
+  vpc_id = aws_vpc.main.id
+}
+
+resource "aws_subnet" "public" {
+  count                   = 2
+  vpc_id                  = aws_vpc.main.id
+  cidr_block              = cidrsubnet(var.vpc_cidr, 8, count.index)
+  availability_zone       = data.aws_availability_zones.available.names[count.index]
+  map_public_ip_on_launch = true
+
+  tags = {
+    Name = "${var.name}-public-${count.index}"
+  }
+}
+
+resource "aws_subnet" "private" {
+  count             = 2
+  vpc_id            = aws_vpc.main.id
+  cidr_block        = cidrsubnet(var.vpc_cidr, 8, count.index + 10)
+  availability_zone = data.aws_availability_zones.available.names[count.index]
+
+  tags = {
+    Name = "${var.name}-private-${count.index}"
+  }
+}
+
+resource "aws_eip" "nat" {
+  domain = "vpc"
+}
+
+resource "aws_nat_gateway" "main" {
+  allocation_id = aws_eip.nat.id
+  subnet_id     = aws_subnet.public[0].id
+  depends_on    = [aws_internet_gateway.main]
+}
+
+resource "aws_route_table" "public" {
+  vpc_id = aws_vpc.main.id
+
+  route {
+    cidr_block = "0.0.0.0/0"
+    gateway_id = aws_internet_gateway.main.id
+  }
+}
+
+resource "aws_route_table_association" "public" {
+  count          = 2
+  subnet_id      = aws_subnet.public[count.index].id
+  route_table_id = aws_route_table.public.id
+}
+
+resource "aws_route_table" "private" {
+  vpc_id = aws_vpc.main.id
+
+  route {
+    cidr_block     = "0.0.0.0/0"
+    nat_gateway_id = aws_nat_gateway.main.id
+  }
+}
+
+resource "aws_route_table_association" "private" {
+  count          = 2
+  subnet_id      = aws_subnet.private[count.index].id
+  route_table_id = aws_route_table.private.id
+}
+
+# The services reach each other; only the load balancer reaches them from
+# outside, and only the MCP server
+
+resource "aws_security_group" "services" {
+  name   = "${var.name}-services"
+  vpc_id = aws_vpc.main.id
+
+  ingress {
+    from_port = 0
+    to_port   = 0
+    protocol  = "-1"
+    self      = true
+  }
+
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+resource "aws_security_group" "lb" {
+  name   = "${var.name}-lb"
+  vpc_id = aws_vpc.main.id
+
+  ingress {
+    from_port   = 80
+    to_port     = 80
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    from_port   = 443
+    to_port     = 443
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+resource "aws_security_group_rule" "lb_to_mcp_server" {
+  type                     = "ingress"
+  from_port                = 3000
+  to_port                  = 3000
+  protocol                 = "tcp"
+  security_group_id        = aws_security_group.services.id
+  source_security_group_id = aws_security_group.lb.id
+}
+
+resource "aws_security_group" "efs" {
+  name   = "${var.name}-efs"
+  vpc_id = aws_vpc.main.id
+
+  ingress {
+    from_port       = 2049
+    to_port         = 2049
+    protocol        = "tcp"
+    security_groups = [aws_security_group.services.id]
+  }
+}
+
+# Persistent volumes: one encrypted file system, a directory of it for each
+# store
+
+resource "aws_efs_file_system" "data" {
+  creation_token = "${var.name}-data"
+  encrypted      = true
+
+  tags = {
+    Name = "${var.name}-data"
+  }
+}
+
+resource "aws_efs_mount_target" "data" {
+  count           = 2
+  file_system_id  = aws_efs_file_system.data.id
+  subnet_id       = aws_subnet.private[count.index].id
+  security_groups = [aws_security_group.efs.id]
+}
+
+# The cluster, its logs and the role its tasks start with, which may read
+# the secrets they are given
+
+resource "aws_ecs_cluster" "main" {
+  name = var.name
+}
+
+resource "aws_cloudwatch_log_group" "main" {
+  name              = "/ecs/${var.name}"
+  retention_in_days = 30
+}
+
+resource "aws_service_discovery_private_dns_namespace" "main" {
+  name = "${var.name}.internal"
+  vpc  = aws_vpc.main.id
+}
+
+data "aws_iam_policy_document" "tasks" {
+  statement {
+    actions = ["sts:AssumeRole"]
+
+    principals {
+      type        = "Service"
+      identifiers = ["ecs-tasks.amazonaws.com"]
+    }
+  }
+}
+
+resource "aws_iam_role" "execution" {
+  name               = "${var.name}-execution"
+  assume_role_policy = data.aws_iam_policy_document.tasks.json
+}
+
+resource "aws_iam_role_policy_attachment" "execution" {
+  role       = aws_iam_role.execution.name
+  policy_arn = "arn:aws:iam::aws:policy/service-role/AmazonECSTaskExecutionRolePolicy"
+}
+
+data "aws_iam_policy_document" "secrets" {
+  statement {
+    actions   = ["secretsmanager:GetSecretValue"]
+    resources = concat([var.neo4j_password_secret], values(var.secrets))
+  }
+}
+
+resource "aws_iam_role_policy" "secrets" {
+  name   = "secrets"
+  role   = aws_iam_role.execution.id
+  policy = data.aws_iam_policy_document.secrets.json
+}
+
+# The MCP server's load balancer, on HTTPS too with a certificate
+
+resource "aws_lb" "main" {
+  name               = var.name
+  load_balancer_type = "application"
+  subnets            = aws_subnet.public[*].id
+  security_groups    = [aws_security_group.lb.id]
+}
+
+resource "aws_lb_listener" "http" {
+  load_balancer_arn = aws_lb.main.arn
+  port              = 80
+  protocol          = "HTTP"
+
+  default_action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.mcp_server.arn
+  }
+}
+
+resource "aws_lb_listener" "https" {
+  count             = var.certificate_arn == "" ? 0 : 1
+  load_balancer_arn = aws_lb.main.arn
+  port              = 443
+  protocol          = "HTTPS"
+  certificate_arn   = var.certificate_arn
+
+  default_action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.mcp_server.arn
+  }
+}
+`
+
+const ecsVariables = `
+variable "region" {
+  description = "AWS region to run in"
+  type        = string
+  default     = "us-east-1"
+}
+
+variable "vpc_cidr" {
+  description = "Address range of the VPC"
+  type        = string
+  default     = "10.0.0.0/16"
+}
+
+variable "neo4j_password_secret" {
+  description = "ARN of the Secrets Manager secret holding the Neo4j password"
+  type        = string
+}
+
+variable "secrets" {
+  description = "More secrets for the components, such as API keys for the agents: the ARN of each by the environment variable it is given in"
+  type        = map(string)
+  default     = {}
+}
+
+variable "certificate_arn" {
+  description = "ACM certificate for HTTPS on the load balancer; HTTP only when empty"
+  type        = string
+  default     = ""
+}
+`
+
+const ecsOutputs = `output "mcp_server_url" {
+  description = "Where the MCP server answers"
+  value       = var.certificate_arn == "" ? "http://${aws_lb.main.dns_name}" : "https://${aws_lb.main.dns_name}"
+}
+
+output "cluster" {
+  description = "The ECS cluster the services run in"
+  value       = aws_ecs_cluster.main.name
+}
+
+output "file_system" {
+  description = "The EFS file system the stores keep their data on"
+  value       = aws_efs_file_system.data.id
+}
+`
+
+// gkeTarget runs every service on an Autopilot cluster of its own, the
+// stores as stateful sets with a persistent volume claim each and the MCP
+// server behind a load balancer. The services find each other by their
+// Kubernetes service's name.
+func gkeTarget() infraTarget {
+	t := infraTarget{name: "gke", runs: func(deployedService) bool { return true }}
+	t.address = func(service deployedService, host bool) string {
+		if host {
+			return service.name
+		}
+		return fmt.Sprintf("%s://%s:%d", service.scheme, service.name, service.ports[0])
+	}
+	t.render = func(module *strings.Builder) {
+		module.WriteString(gkeMain)
+		for _, service := range deployedServices {
+			renderGKEService(module, t, service)
+		}
+	}
+	volumes := []string{}
+	for _, service := range deployedServices {
+		if service.store() {
+			volumes = append(volumes, fmt.Sprintf("    %s = %d", service.id(), service.volumeGB))
+		}
+	}
+	t.files = map[string]string{
+		"variables.tf": imagesVariable() + gkeVariables + fmt.Sprintf(`
+variable "volume_gb" {
+  description = "Size of each store's persistent volume, in GB"
+  type        = map(number)
+  default = {
+%s
+  }
+}
+`, strings.Join(volumes, "\n")),
+		"outputs.tf": gkeOutputs,
+	}
+	return t
+}
+
+// kubernetesQuantities are a service's CPU and memory as Kubernetes
+// quantities.
+func (s deployedService) kubernetesQuantities() (string, string) {
+	return fmt.Sprintf("%dm", int(s.cpu*1000)), fmt.Sprintf("%dMi", s.memory)
+}
+
+func renderGKEService(module *strings.Builder, t infraTarget, service deployedService) {
+	id := service.id()
+	kind := "kubernetes_deployment"
+	if service.store() {
+		kind = "kubernetes_stateful_set"
+	}
+	fmt.Fprintf(module, "\n# %s\n\n", service.name)
+	fmt.Fprintf(module, `resource %q %q {
+  metadata {
+    name      = %q
+    namespace = kubernetes_namespace.main.metadata[0].name
+  }
+
+  spec {
+    replicas = 1
+`, kind, id, service.name)
+	if service.store() {
+		fmt.Fprintf(module, "    service_name = %q\n", service.name)
+	} else {
+		module.WriteString(`
+    # One pod at a time: the old one stops before the new one starts
+    strategy {
+      type = "Recreate"
+    }
+`)
+	}
+	fmt.Fprintf(module, `
+    selector {
+      match_labels = {
+        app = %[1]q
+      }
+    }
+
+    template {
+      metadata {
+        labels = {
+          app = %[1]q
+        }
+      }
+
+      spec {
+        # Kubernetes would otherwise give every pod NEO4J_PORT and the like,
+        # which Neo4j takes for settings
+        enable_service_links = false
+
+        container {
+          name  = %[1]q
+          image = %[2]s
+`, service.name, service.imageExpr())
+	if len(service.command) > 0 {
+		fmt.Fprintf(module, "          command = %s\n", hclList(service.command))
+	}
+	if len(service.args) > 0 {
+		fmt.Fprintf(module, "          args = %s\n", hclList(service.args))
+	}
+	for _, port := range service.ports {
+		fmt.Fprintf(module, "\n          port {\n            container_port = %d\n          }\n", port)
+	}
+	// The password comes first, as NEO4J_AUTH is made from it
+	if service.neo4jPassword != "" {
+		fmt.Fprintf(module, `
+          env {
+            name = %q
+
+            value_from {
+              secret_key_ref {
+                name = var.neo4j_password_secret.name
+                key  = var.neo4j_password_secret.key
+              }
+            }
+          }
+`, service.neo4jPassword)
+	}
+	env := t.sortedEnv(service)
+	if service.neo4jAuth {
+		env = append(env, [2]string{"NEO4J_AUTH", fmt.Sprintf("neo4j/$(%s)", service.neo4jPassword)})
+	}
+	for _, pair := range env {
+		fmt.Fprintf(module, "\n          env {\n            name  = %q\n            value = %q\n          }\n", pair[0], pair[1])
+	}
+	if !service.store() {
+		module.WriteString(`
+          dynamic "env" {
+            for_each = var.secrets
+
+            content {
+              name = env.key
+
+              value_from {
+                secret_key_ref {
+                  name = env.value.name
+                  key  = env.value.key
+                }
+              }
+            }
+          }
+`)
+	}
+	cpu, memory := service.kubernetesQuantities()
+	fmt.Fprintf(module, `
+          resources {
+            requests = {
+              cpu    = %q
+              memory = %q
+            }
+            limits = {
+              memory = %q
+            }
+          }
+`, cpu, memory, memory)
+	if service.health != "" {
+		fmt.Fprintf(module, `
+          readiness_probe {
+            http_get {
+              path = %q
+              port = %d
+            }
+          }
+`, service.health, service.ports[0])
+	}
+	if service.store() {
+		fmt.Fprintf(module, `
+          volume_mount {
+            name       = "data"
+            mount_path = %q
+          }
+`, service.volume)
+	}
+	module.WriteString("        }\n      }\n    }\n")
+	if service.store() {
+		fmt.Fprintf(module, `
+    volume_claim_template {
+      metadata {
+        name = "data"
+      }
+
+      spec {
+        access_modes = ["ReadWriteOnce"]
+
+        resources {
+          requests = {
+            storage = "${var.volume_gb[%q]}Gi"
+          }
+        }
+      }
+    }
+`, id)
+	}
+	module.WriteString("  }\n}\n")
+
+	fmt.Fprintf(module, `
+resource "kubernetes_service" %q {
+  metadata {
+    name      = %q
+    namespace = kubernetes_namespace.main.metadata[0].name
+  }
+
+  spec {
+    selector = {
+      app = %q
+    }
+`, id, service.name, service.name)
+	if service.public {
+		module.WriteString("    type = \"LoadBalancer\"\n")
+	}
+	for _, port := range service.ports {
+		fmt.Fprintf(module, "\n    port {\n      name        = \"port-%d\"\n      port        = %d\n      target_port = %d\n    }\n", port, port, port)
+	}
+	module.WriteString("  }\n}\n")
+}
+
+const gkeMain = `# Generated by the pipeline: every service on a GKE Autopilot cluster of
+# its own. The stores are stateful sets with a persistent volume each and
+# the MCP server is behind a load balancer; the services find each other by
+# name in the namespace.
+
+terraform {
+  required_version = ">= 1.5"
+
+  required_providers {
+    google = {
+      source  = "hashicorp/google"
+      version = ">= 5.10"
+    }
+    kubernetes = {
+      source  = "hashicorp/kubernetes"
+      version = ">= 2.25"
+    }
+  }
+}
+
+provider "google" {
+  project = var.project
+  region  = var.region
+}
+
+resource "google_project_service" "apis" {
+  for_each           = toset(["compute.googleapis.com", "container.googleapis.com"])
+  service            = each.value
+  disable_on_destroy = false
+}
+
+# Networking: a VPC with ranges for the cluster's pods and services
+
+resource "google_compute_network" "main" {
+  name                    = var.name
+  auto_create_subnetworks = false
+  depends_on              = [google_project_service.apis]
+}
+
+resource "google_compute_subnetwork" "main" {
+  name          = var.name
+  region        = var.region
+  network       = google_compute_network.main.id
+  ip_cidr_range = "10.0.0.0/20"
+
+  secondary_ip_range {
+    range_name    = "pods"
+    ip_cidr_range = "10.4.0.0/14"
+  }
+
+  secondary_ip_range {
+    range_name    = "services"
+    ip_cidr_range = "10.8.0.0/20"
+  }
+}
+
+resource "google_container_cluster" "main" {
+  name                = var.name
+  location            = var.region
+  enable_autopilot    = true
+  network             = google_compute_network.main.id
+  subnetwork          = google_compute_subnetwork.main.id
+  deletion_protection = var.deletion_protection
+
+  ip_allocation_policy {
+    cluster_secondary_range_name  = "pods"
+    services_secondary_range_name = "services"
+  }
+}
+
+data "google_client_config" "default" {}
+
+provider "kubernetes" {
+  host                   = "https://${google_container_cluster.main.endpoint}"
+  token                  = data.google_client_config.default.access_token
+  cluster_ca_certificate = base64decode(google_container_cluster.main.master_auth[0].cluster_ca_certificate)
+}
+
+resource "kubernetes_namespace" "main" {
+  metadata {
+    name = var.name
+  }
+}
+`
+
+const gkeVariables = `
+variable "project" {
+  description = "Google Cloud project to run in"
+  type        = string
+}
+
+variable "region" {
+  description = "Region of the cluster"
+  type        = string
+  default     = "us-central1"
+}
+
+variable "neo4j_password_secret" {
+  description = "Kubernetes secret in the namespace holding the Neo4j password, and its key"
+  type = object({
+    name = string
+    key  = string
+  })
+  default = {
+    name = "neo4j"
+    key  = "password"
+  }
+}
+
+variable "secrets" {
+  description = "More secrets for the components, such as API keys for the agents: the Kubernetes secret and key of each by the environment variable it is given in"
+  type = map(object({
+    name = string
+    key  = string
+  }))
+  default = {}
+}
+
+variable "deletion_protection" {
+  description = "Whether Terraform may not destroy the cluster"
+  type        = bool
+  default     = true
+}
+`
+
+const gkeOutputs = `output "mcp_server_url" {
+  description = "Where the MCP server answers"
+  value       = "http://${kubernetes_service.mcp_server.status[0].load_balancer[0].ingress[0].ip}:3000"
+}
+
+output "cluster" {
+  description = "The GKE cluster the services run in"
+  value       = google_container_cluster.main.name
+}
+
+output "namespace" {
+  description = "The namespace the services run in"
+  value       = kubernetes_namespace.main.metadata[0].name
+}
+`
+
+// cloudRunTarget runs the components on Cloud Run, each as one instance
+// with egress through a VPC of its own. Cloud Run has no persistent disks,
+// so Redis is Memorystore, with RDB snapshots, and Neo4j and Qdrant are
+// ones the deployment is given, such as Neo4j Aura and Qdrant Cloud.
+func cloudRunTarget() infraTarget {
+	t := infraTarget{name: "cloudrun", runs: func(service deployedService) bool { return !service.store() }}
+	t.address = func(service deployedService, host bool) string {
+		switch service.name {
+		case "redis":
+			return "${google_redis_instance.redis.host}"
+		case "neo4j":
+			return "${var.neo4j_uri}"
+		case "qdrant":
+			return "${var.qdrant_url}"
+		}
+		return fmt.Sprintf("${google_cloud_run_v2_service.%s.uri}", service.id())
+	}
+	t.render = func(module *strings.Builder) {
+		module.WriteString(cloudRunMain)
+		for _, service := range deployedComponents() {
+			renderCloudRunService(module, t, service)
+		}
+	}
+	t.files = map[string]string{"variables.tf": imagesVariable() + cloudRunVariables, "outputs.tf": cloudRunOutputs}
+	return t
+}
+
+func renderCloudRunService(module *strings.Builder, t infraTarget, service deployedService) {
+	id := service.id()
+	ingress := "INGRESS_TRAFFIC_INTERNAL_ONLY"
+	if service.public {
+		ingress = "INGRESS_TRAFFIC_ALL"
+	}
+	fmt.Fprintf(module, "\n# %s\n\n", service.name)
+	fmt.Fprintf(module, `resource "google_cloud_run_v2_service" %q {
+  name     = "${var.name}-%s"
+  location = var.region
+  ingress  = %q
+
+  template {
+    service_account = google_service_account.services.email
+
+    scaling {
+      min_instance_count = 1
+      max_instance_count = 1
+    }
+
+    vpc_access {
+      network_interfaces {
+        network    = google_compute_network.main.id
+        subnetwork = google_compute_subnetwork.main.id
+      }
+      egress = "ALL_TRAFFIC"
+    }
+
+    containers {
+      image = %s
+`, id, service.name, ingress, service.imageExpr())
+	if len(service.command) > 0 {
+		fmt.Fprintf(module, "      command = %s\n", hclList(service.command[:1]))
+		fmt.Fprintf(module, "      args    = %s\n", hclList(service.command[1:]))
+	}
+	fmt.Fprintf(module, "\n      ports {\n        container_port = %d\n      }\n", service.ports[0])
+	for _, env := range t.sortedEnv(service) {
+		fmt.Fprintf(module, "\n      env {\n        name  = %q\n        value = %q\n      }\n", env[0], env[1])
+	}
+	if service.neo4jPassword != "" {
+		fmt.Fprintf(module, `
+      env {
+        name = %q
+
+        value_source {
+          secret_key_ref {
+            secret  = var.neo4j_password_secret
+            version = "latest"
+          }
+        }
+      }
+`, service.neo4jPassword)
+	}
+	memory := max(service.memory, 512)
+	fmt.Fprintf(module, `
+      dynamic "env" {
+        for_each = var.secrets
+
+        content {
+          name = env.key
+
+          value_source {
+            secret_key_ref {
+              secret  = env.value
+              version = "latest"
+            }
+          }
+        }
+      }
+
+      resources {
+        limits = {
+          cpu    = %q
+          memory = "%dMi"
+        }
+        # The CPU stays on between requests, for the work each does in the
+        # background
+        cpu_idle = false
+      }
+
+      startup_probe {
+        http_get {
+          path = %q
+        }
+      }
+    }
+  }
+
+  depends_on = [google_secret_manager_secret_iam_member.services]
+}
+
+# Ingress keeps the internal services to the VPC; Cloud Run's own check is
+# left off, as the components call each other without Google tokens
+resource "google_cloud_run_v2_service_iam_member" %q {
+  name     = google_cloud_run_v2_service.%s.name
+  location = var.region
+  role     = "roles/run.invoker"
+  member   = "allUsers"
+}
+`, fmt.Sprint(max(int(service.cpu), 1)), memory, service.health, id, id)
+}
+
+const cloudRunMain = `# Generated by the pipeline: the components on Cloud Run, egressing through
+# a VPC of their own. Redis is Memorystore; Cloud Run has no persistent
+# disks, so Neo4j and Qdrant are given.
+
+terraform {
+  required_version = ">= 1.5"
+
+  required_providers {
+    google = {
+      source  = "hashicorp/google"
+      version = ">= 5.10"
+    }
+  }
+}
+
+provider "google" {
+  project = var.project
+  region  = var.region
+}
+
+resource "google_project_service" "apis" {
+  for_each           = toset(["compute.googleapis.com", "run.googleapis.com", "redis.googleapis.com", "secretmanager.googleapis.com"])
+  service            = each.value
+  disable_on_destroy = false
+}
+
+# Networking: the services' egress, and NAT for what they fetch from
+# outside
+
+resource "google_compute_network" "main" {
+  name                    = var.name
+  auto_create_subnetworks = false
+  depends_on              = [google_project_service.apis]
+}
+
+resource "google_compute_subnetwork" "main" {
+  name          = var.name
+  region        = var.region
+  network       = google_compute_network.main.id
+  ip_cidr_range = "10.0.0.0/24"
+}
+
+resource "google_compute_router" "main" {
+  name    = var.name
+  region  = var.region
+  network = google_compute_network.main.id
+}
+
+resource "google_compute_router_nat" "main" {
+  name                               = var.name
+  router                             = google_compute_router.main.name
+  region                             = var.region
+  nat_ip_allocate_option             = "AUTO_ONLY"
+  source_subnetwork_ip_ranges_to_nat = "ALL_SUBNETWORKS_ALL_IP_RANGES"
+}
+
+# Session memory's Redis, snapshotted every hour
+
+resource "google_redis_instance" "redis" {
+  name               = "${var.name}-redis"
+  region             = var.region
+  tier               = "BASIC"
+  memory_size_gb     = var.redis_memory_gb
+  redis_version      = "REDIS_7_0"
+  authorized_network = google_compute_network.main.id
+
+  persistence_config {
+    persistence_mode    = "RDB"
+    rdb_snapshot_period = "ONE_HOUR"
+  }
+}
+
+# The services' identity, which may read the secrets they are given
+
+resource "google_service_account" "services" {
+  account_id   = var.name
+  display_name = "Dynamic context services"
+}
+
+resource "google_secret_manager_secret_iam_member" "services" {
+  for_each  = toset(concat([var.neo4j_password_secret], values(var.secrets)))
+  secret_id = each.value
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${google_service_account.services.email}"
+}
+`
+
+const cloudRunVariables = `
+variable "project" {
+  description = "Google Cloud project to run in"
+  type        = string
+}
+
+variable "region" {
+  description = "Region of the services"
+  type        = string
+  default     = "us-central1"
+}
+
+variable "neo4j_uri" {
+  description = "Bolt URI of the Neo4j database, such as neo4j+s://….databases.neo4j.io"
+  type        = string
+}
+
+variable "qdrant_url" {
+  description = "URL of the Qdrant instance, reachable from the VPC"
+  type        = string
+}
+
+variable "neo4j_password_secret" {
+  description = "Secret Manager secret holding the Neo4j password"
+  type        = string
+}
+
+variable "secrets" {
+  description = "More secrets for the components, such as API keys for the agents: the Secret Manager secret of each by the environment variable it is given in"
+  type        = map(string)
+  default     = {}
+}
+
+variable "redis_memory_gb" {
+  description = "Memory of the Memorystore instance, in GB"
+  type        = number
+  default     = 1
+}
+`
+
+const cloudRunOutputs = `output "mcp_server_url" {
+  description = "Where the MCP server answers"
+  value       = google_cloud_run_v2_service.mcp_server.uri
+}
+
+output "redis_host" {
+  description = "The Memorystore instance session memory keeps its sessions in"
+  value       = google_redis_instance.redis.host
+}
+`
+
+// infraTargets are the platforms there are modules for, by name.
+var infraTargets = map[string]func() infraTarget{
+	"ecs":      ecsTarget,
+	"cloudrun": cloudRunTarget,
+	"gke":      gkeTarget,
+}
+
+// terraformModules are the modules for the named targets, each in a
+// directory of its name.
+func terraformModules(client *dagger.Client, targets []string, images map[string]string) (*dagger.Directory, error) {
+	modules := client.Directory()
+	for _, name := range targets {
+		target := infraTargets[name]()
+		var main strings.Builder
+		target.render(&main)
+		modules = modules.WithNewFile(name+"/main.tf", main.String())
+		for file, contents := range target.files {
+			modules = modules.WithNewFile(name+"/"+file, contents)
+		}
+		if len(images) > 0 {
+			tfvars, err := json.MarshalIndent(map[string]any{"images": images}, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+			modules = modules.WithNewFile(name+"/images.auto.tfvars.json", string(tfvars)+"\n")
+		}
+	}
+	return modules, nil
+}
+
+// publishComponents pushes the components' images to registry, tagged
+// tag, each by its builder, and answers with each one's reference by name.
+func publishComponents(ctx context.Context, registry, tag string, builders []ComponentBuilder, containers map[string]*dagger.Container) (map[string]string, error) {
+	images := map[string]string{}
+	for _, builder := range builders {
+		name := builder.Name()
+		ref, err := builder.Publish(ctx, containers[name], fmt.Sprintf("%s/%s:%s", strings.TrimRight(registry, "/"), name, tag))
+		if err != nil {
+			return nil, fmt.Errorf("publishing %s: %w", name, err)
+		}
+		fmt.Printf("Infrastructure: published %s\n", ref)
+		images[name] = ref
+	}
+	return images, nil
+}
+
+// deployedTag is what the components' images are tagged when published:
+// INFRA_TAG, or latest.
+func deployedTag() string {
+	if tag := os.Getenv("INFRA_TAG"); tag != "" {
+		return tag
+	}
+	return "latest"
+}
+
+// publishing reports whether the pipeline publishes the components'
+// images: INFRA_REGISTRY is set, and so is something to deploy them with.
+func publishing() bool {
+	return os.Getenv("INFRA_REGISTRY") != "" &&
+		(os.Getenv("INFRA_TARGETS") != "" || os.Getenv("HELM_CHART") != "" || os.Getenv("KUSTOMIZE") != "")
+}
+
+// publishDeployment publishes the components' images for what is
+// generated to deploy them: the Terraform modules, the Helm chart and the
+// Kustomize overlays. With INFRA_REGISTRY set they are pushed there, tagged
+// deployedTag, and each one's reference is answered by name; without it,
+// or with nothing to generate, nothing is published.
+func publishDeployment(ctx context.Context, builders []ComponentBuilder, containers map[string]*dagger.Container) (map[string]string, error) {
+	if !publishing() {
+		return nil, nil
+	}
+	return publishComponents(ctx, os.Getenv("INFRA_REGISTRY"), deployedTag(), builders, containers)
+}
+
+// generateInfrastructure writes Terraform modules that run the components
+// on each platform in INFRA_TARGETS, a comma-separated list of ecs,
+// cloudrun and gke, to dir/terraform, formatted and validated with
+// Terraform. The modules are given images, the components' published
+// images by name, when there are any. It only runs when INFRA_TARGETS is
+// set on the host.
+func generateInfrastructure(ctx context.Context, client *dagger.Client, images map[string]string, dir string) error {
+	list := os.Getenv("INFRA_TARGETS")
+	if list == "" {
+		fmt.Println("⏭️ Skipping infrastructure generation: INFRA_TARGETS is not set")
+		return nil
+	}
+	fmt.Println("🏗️ Generating Infrastructure...")
+
+	var targets []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := infraTargets[name]; !ok {
+			return fmt.Errorf("INFRA_TARGETS: %q is not ecs, cloudrun or gke", name)
+		}
+		targets = append(targets, name)
+	}
+
+	modules, err := terraformModules(client, targets, images)
+	if err != nil {
+		return err
+	}
+
+	// Formatted in place, then each module checked against its providers'
+	// schemas without any credentials
+	script := "set -e\nterraform fmt -recursive >/dev/null\n"
+	for _, name := range targets {
+		script += fmt.Sprintf("terraform -chdir=%[1]s init -backend=false -input=false -no-color >/dev/null\nterraform -chdir=%[1]s validate -no-color\nrm -rf %[1]s/.terraform\n", name)
+	}
+	checked := client.Container().
+		From(terraformImage).
+		WithDirectory("/terraform", modules).
+		WithWorkdir("/terraform").
+		WithExec([]string{"sh", "-c", script}, dagger.ContainerWithExecOpts{SkipEntrypoint: true})
+	output, err := checked.Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Print(output)
+	if _, err := checked.Directory("/terraform").Export(ctx, dir+"/terraform"); err != nil {
+		return fmt.Errorf("exporting modules: %w", err)
+	}
+	for _, name := range targets {
+		fmt.Printf("Infrastructure: %s/terraform/%s\n", dir, name)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"dagger.io/dagger"
+)
+
+// goKnowledgeGraphSource is the Go knowledge graph service, relative to the
+// repository root the pipeline runs from.
+const goKnowledgeGraphSource = "packages/kg-service"
+
+const pgvectorTestPassword = "context-vectors"
+
+// Go Knowledge Graph Container - the same graph API as a static binary,
+// without a Python runtime or an in-process embedding model
+func buildGoKnowledgeGraphContainer(ctx context.Context, client *dagger.Client) *dagger.Container {
+	fmt.Println("🐹 Building Go Knowledge Graph Container...")
+
+	binary := client.Container().
+		From("golang:1.22-alpine").
+		WithDirectory("/src/kg-service", client.Host().Directory(goKnowledgeGraphSource)).
+		WithDirectory("/src/events", client.Host().Directory(eventsSource)).
+		WithDirectory("/src/logging", client.Host().Directory(loggingSource)).
+		WithDirectory("/src/rbac", client.Host().Directory(rbacSource)).
+		WithDirectory("/src/tracing", client.Host().Directory(tracingSource)).
+		WithDirectory("/src/apiversion", client.Host().Directory(apiVersionSource)).
+		WithWorkdir("/src/kg-service").
+		WithMountedCache("/root/.cache/go-build", client.CacheVolume("kg-service-go-build")).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithExec([]string{"go", "vet", "./..."}).
+		WithExec([]string{"go", "build", "-o", "/out/kg-service", "."}).
+		File("/out/kg-service")
+
+	return client.Container().
+		From("alpine:3.19").
+		WithFile("/usr/local/bin/kg-service", binary).
+		WithEntrypoint([]string{"/usr/local/bin/kg-service"})
+}
+
+// buildGoNeo4jService is a Neo4j of the Go service's own: the service
+// serves every graph, so on the Python service's Neo4j its default graph
+// would be the Python one, with hash embeddings among the
+// sentence-transformer ones. DEPLOYMENT keeps it apart from that otherwise
+// identical service.
+func buildGoNeo4jService(client *dagger.Client) *dagger.Service {
+	return client.Container().
+		From("neo4j:5-community").
+		WithEnvVariable("NEO4J_AUTH", "neo4j/"+neo4jTestPassword).
+		WithEnvVariable("NEO4J_server_memory_heap_max__size", "512m").
+		WithEnvVariable("DEPLOYMENT", "go-knowledge-graph").
+		WithExposedPort(7474).
+		WithExposedPort(7687).
+		AsService()
+}
+
+// goKnowledgeGraphService runs the Go service on its own Neo4j, with its
+// vectors in go_ collections of the shared Qdrant.
+func goKnowledgeGraphService(client *dagger.Client, container *dagger.Container, qdrant *dagger.Service) *dagger.Service {
+	return container.
+		WithServiceBinding("neo4j", buildGoNeo4jService(client)).
+		WithServiceBinding("qdrant", qdrant).
+		WithEnvVariable("KG_BACKEND", "neo4j").
+		WithEnvVariable("NEO4J_HTTP_URL", "http://neo4j:7474").
+		WithEnvVariable("NEO4J_USER", "neo4j").
+		WithEnvVariable("NEO4J_PASSWORD", neo4jTestPassword).
+		WithEnvVariable("KG_VECTOR_INDEX", "qdrant").
+		WithEnvVariable("QDRANT_URL", "http://qdrant:6333").
+		WithEnvVariable("QDRANT_COLLECTION", "go_context_nodes").
+		WithEnvVariable("KG_PORT", fmt.Sprint(knowledgeGraphPort)).
+		WithExposedPort(knowledgeGraphPort).
+		AsService()
+}
+
+// pgvector Service - Postgres with the pgvector extension, for the Go
+// service's pgvector index; the Python service has no such index
+func buildPgvectorService(client *dagger.Client) *dagger.Service {
+	fmt.Println("🐘 Building pgvector Service...")
+
+	return client.Container().
+		From("pgvector/pgvector:pg16").
+		WithEnvVariable("POSTGRES_PASSWORD", pgvectorTestPassword).
+		WithEnvVariable("POSTGRES_DB", "context_vectors").
+		WithExposedPort(5432).
+		AsService()
+}
+
+// goKnowledgeGraphPgvectorService runs the Go service on its own Neo4j
+// with its vectors in pgvector.
+func goKnowledgeGraphPgvectorService(client *dagger.Client, container *dagger.Container, pgvector *dagger.Service) *dagger.Service {
+	return container.
+		WithServiceBinding("neo4j", buildGoNeo4jService(client)).
+		WithServiceBinding("pgvector", pgvector).
+		WithEnvVariable("KG_BACKEND", "neo4j").
+		WithEnvVariable("NEO4J_HTTP_URL", "http://neo4j:7474").
+		WithEnvVariable("NEO4J_USER", "neo4j").
+		WithEnvVariable("NEO4J_PASSWORD", neo4jTestPassword).
+		WithEnvVariable("KG_VECTOR_INDEX", "pgvector").
+		WithEnvVariable("PGVECTOR_URL",
+			fmt.Sprintf("postgres://postgres:%s@pgvector:5432/context_vectors?sslmode=disable", pgvectorTestPassword)).
+		WithEnvVariable("KG_PORT", fmt.Sprint(knowledgeGraphPort)).
+		WithExposedPort(knowledgeGraphPort).
+		AsService()
+}
+
+// testGoKnowledgeGraph runs the shared API checks against the Go service,
+// in a named graph so services sharing its Neo4j keep their nodes apart.
+func testGoKnowledgeGraph(ctx context.Context, client *dagger.Client, service *dagger.Service, graphID string) error {
+	fmt.Println("🧪 Testing Go Knowledge Graph...")
+
+	root := fmt.Sprintf("http://kg-go:%d", knowledgeGraphPort)
+	base := root + "/graphs/" + graphID
+	node := `{"data": {"type": "go_service_test", "content": "Go knowledge graph service backed by Neo4j and Qdrant"}}`
+	badEdge := `{"source": "a", "target": "b", "relationship_type": "not_configured"}`
+
+	curl := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("kg-go", service).
+		WithExec([]string{"curl", "-fsS", root + "/health"}).
+		// 409 once the graph exists from an earlier run
+		WithExec([]string{"curl", "-sS", "-o", "/dev/null", "-X", "POST", "-H", "Content-Type: application/json",
+			"-d", fmt.Sprintf(`{"graph_id": %q}`, graphID), root + "/graphs"}).
+		WithExec([]string{"curl", "-fsS", "-X", "POST", "-H", "Content-Type: application/json", "-d", node, base + "/nodes"}).
+		WithExec([]string{"curl", "-fsS", "-o", "/dev/null", base + "/config"})
+
+	for _, mode := range []string{"hybrid", "vector", "keyword"} {
+		output, err := curl.
+			WithExec([]string{"curl", "-fsS", "-G", "--data-urlencode", "q=Go knowledge graph service", "--data-urlencode", "mode=" + mode, base + "/search"}).
+			Stdout(ctx)
+		if err != nil {
+			return err
+		}
+
+		var search struct {
+			Results []map[string]any `json:"results"`
+		}
+		if err := json.Unmarshal([]byte(output), &search); err != nil {
+			return fmt.Errorf("unexpected search response %q: %w", output, err)
+		}
+		if len(search.Results) == 0 {
+			return fmt.Errorf("%s search did not return the node added to the Go service", mode)
+		}
+	}
+
+	stats, err := curl.
+		WithExec([]string{"curl", "-fsS", base + "/stats"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Go Knowledge Graph Stats:\n%s\n", stats)
+
+	status, err := curl.
+		WithExec([]string{"curl", "-sS", "-o", "/dev/null", "-w", "%{http_code}", "-X", "POST", "-H", "Content-Type: application/json", "-d", badEdge, base + "/edges"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if status != "400" {
+		return fmt.Errorf("edge with an unconfigured relationship type returned HTTP %s, want 400", status)
+	}
+	return nil
+}
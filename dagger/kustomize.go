@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// kubectlImage builds every overlay to check it.
+const kubectlImage = "bitnami/kubectl:1.29"
+
+// kustomizeEnvironment is an overlay of the base: its services' CPU and
+// memory scaled from what they are defined with, and where the Neo4j
+// password comes from.
+type kustomizeEnvironment struct {
+	name        string
+	cpuScale    float64
+	memoryScale float64
+	// neo4jSecret is the Kubernetes secret, managed apart from the
+	// manifests, the password is in; without one a secret is generated
+	// with a fixed password
+	neo4jSecret string
+}
+
+// kustomizeEnvironments are the overlays there are. Neo4j's heap and page
+// cache take most of its memory, so dev only scales down the CPU.
+var kustomizeEnvironments = []kustomizeEnvironment{
+	{name: "dev", cpuScale: 0.5, memoryScale: 1},
+	{name: "staging", cpuScale: 1, memoryScale: 1, neo4jSecret: "neo4j-staging"},
+	{name: "prod", cpuScale: 2, memoryScale: 2, neo4jSecret: "neo4j-prod"},
+}
+
+// kustomizeName is a service's name in the base, as the chart names it for
+// a release of its own name.
+func kustomizeName(service deployedService) string {
+	return helmChartName + "-" + service.name
+}
+
+func kustomizeKind(service deployedService) string {
+	if service.store() {
+		return "StatefulSet"
+	}
+	return "Deployment"
+}
+
+// kustomizeOverlay is an environment's overlay, by file name.
+func kustomizeOverlay(env kustomizeEnvironment) map[string]string {
+	var kustomization strings.Builder
+	fmt.Fprintf(&kustomization, `# Generated by the pipeline: the base in a namespace of its own, with
+# %[2]s's replicas, resources and secrets.
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+namespace: %[1]s
+
+resources:
+  - ../../base
+  - namespace.yaml
+
+labels:
+  - pairs:
+      environment: %[2]s
+
+# Every service keeps its state in its process or on its volume, so each
+# replica past the first has state of its own
+replicas:
+`, helmChartName+"-"+env.name, env.name)
+	for _, service := range deployedServices {
+		fmt.Fprintf(&kustomization, "  - name: %s\n    count: 1\n", kustomizeName(service))
+	}
+	kustomization.WriteString("\npatches:\n  - path: resources.yaml\n")
+	if env.neo4jSecret != "" {
+		kustomization.WriteString("  - path: secrets.yaml\n")
+	} else {
+		// References to the base's secret are renamed to the generated one
+		kustomization.WriteString(`
+secretGenerator:
+  - name: neo4j
+    literals:
+      - password=dev-password
+`)
+	}
+
+	var resources, secrets []string
+	for _, service := range deployedServices {
+		cpu := fmt.Sprintf("%dm", int(service.cpu*env.cpuScale*1000))
+		memory := fmt.Sprintf("%dMi", int(float64(service.memory)*env.memoryScale))
+		resources = append(resources, fmt.Sprintf(`apiVersion: apps/v1
+kind: %s
+metadata:
+  name: %s
+spec:
+  template:
+    spec:
+      containers:
+        - name: %s
+          resources:
+            requests:
+              cpu: %s
+              memory: %s
+            limits:
+              memory: %s
+`, kustomizeKind(service), kustomizeName(service), service.name, cpu, memory, memory))
+		if service.neo4jPassword != "" {
+			secrets = append(secrets, fmt.Sprintf(`apiVersion: apps/v1
+kind: %s
+metadata:
+  name: %s
+spec:
+  template:
+    spec:
+      containers:
+        - name: %s
+          env:
+            - name: %s
+              valueFrom:
+                secretKeyRef:
+                  name: %s
+                  key: password
+`, kustomizeKind(service), kustomizeName(service), service.name, service.neo4jPassword, env.neo4jSecret))
+		}
+	}
+
+	files := map[string]string{
+		"kustomization.yaml": kustomization.String(),
+		"namespace.yaml":     fmt.Sprintf("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s-%s\n", helmChartName, env.name),
+		"resources.yaml":     strings.Join(resources, "---\n"),
+	}
+	if env.neo4jSecret != "" {
+		files["secrets.yaml"] = strings.Join(secrets, "---\n")
+	}
+	return files
+}
+
+const kustomizeBase = `# Generated by the pipeline from its Helm chart, rendered with the chart's
+# defaults.
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+resources:
+  - manifests.yaml
+`
+
+// generateKustomize writes a Kustomize base of the services to
+// dir/kustomize/base, the Helm chart rendered with the components'
+// published images when there are any, and an overlay of it for each
+// environment to dir/kustomize/overlays, each built with kubectl to check
+// it. It only runs when KUSTOMIZE is set on the host.
+func generateKustomize(ctx context.Context, client *dagger.Client, images map[string]string, dir string) error {
+	if os.Getenv("KUSTOMIZE") == "" {
+		fmt.Println("⏭️ Skipping Kustomize overlays: KUSTOMIZE is not set")
+		return nil
+	}
+	fmt.Println("🧩 Generating Kustomize Overlays...")
+
+	// The chart's own labels say Helm manages what it renders, which is no
+	// longer so
+	base := client.Container().
+		From(helmImage).
+		WithDirectory("/helm/"+helmChartName, helmChart(client, "0.1.0", images)).
+		WithWorkdir("/helm").
+		WithExec([]string{"sh", "-c", fmt.Sprintf(
+			`helm template %[1]s %[1]s | sed -e '/helm.sh\/chart:/d' -e '/app.kubernetes.io\/managed-by:/d' > manifests.yaml`, helmChartName)},
+			dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		File("/helm/manifests.yaml")
+
+	tree := client.Directory().
+		WithFile("base/manifests.yaml", base).
+		WithNewFile("base/kustomization.yaml", kustomizeBase)
+	script := "set -e\n"
+	for _, env := range kustomizeEnvironments {
+		for file, contents := range kustomizeOverlay(env) {
+			tree = tree.WithNewFile("overlays/"+env.name+"/"+file, contents)
+		}
+		script += fmt.Sprintf("kubectl kustomize overlays/%[1]s >/dev/null\necho \"Kustomize: overlays/%[1]s builds\"\n", env.name)
+	}
+
+	output, err := client.Container().
+		From(kubectlImage).
+		WithDirectory("/kustomize", tree).
+		WithWorkdir("/kustomize").
+		WithExec([]string{"sh", "-c", script}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Print(output)
+	if _, err := tree.Export(ctx, dir+"/kustomize"); err != nil {
+		return fmt.Errorf("exporting overlays: %w", err)
+	}
+	fmt.Printf("Kustomize: %s/kustomize\n", dir)
+	return nil
+}
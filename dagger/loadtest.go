@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// k6Image drives the load test's traffic.
+const k6Image = "grafana/k6:0.49.0"
+
+// loadSLOs are what the load test holds each scenario to: the p95 latency,
+// in milliseconds, and the share of requests that may fail. Each is set
+// from the host's environment, with the default here.
+var loadSLOs = []struct {
+	scenario, p95Env, errorEnv string
+	p95, errorRate             float64
+}{
+	{"gateway", "LOAD_GATEWAY_P95_MS", "LOAD_ERROR_RATE", 250, 0.01},
+	{"search", "LOAD_SEARCH_P95_MS", "LOAD_ERROR_RATE", 1000, 0.01},
+}
+
+// loadEchoPy is the tool the gateway's traffic invokes: it answers with
+// what it was sent, so the latency measured is the MCP server's own.
+const loadEchoPy = `from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+
+class Echo(BaseHTTPRequestHandler):
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers.get("Content-Length", 0)))
+        self.send_response(200)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+ThreadingHTTPServer(("0.0.0.0", 8000), Echo).serve_forever()
+`
+
+// loadScript is the k6 script: a constant rate of tool invocations through
+// the MCP gateway, and of graph searches in each mode, side by side. The
+// thresholds only make k6 keep each scenario's own metrics for the
+// summary; the SLOs are checked against it afterwards, so a breach is
+// reported by name rather than as k6's exit status.
+const loadScript = `import http from 'k6/http';
+import { check } from 'k6';
+
+const mcp = __ENV.MCP_URL;
+const graph = __ENV.KNOWLEDGE_GRAPH_URL;
+const json = { headers: { 'Content-Type': 'application/json' } };
+const queries = [
+  'session memory summarization',
+  'knowledge graph REST API',
+  'fixture/repo#3',
+  'containerized MCP context collection',
+  'why did the checkout deploy fail',
+  'ERR_TIMEOUT',
+];
+const modes = ['hybrid', 'hybrid', 'vector', 'keyword'];
+
+function scenario(exec) {
+  return {
+    executor: 'constant-arrival-rate',
+    rate: Number(__ENV.LOAD_RATE),
+    timeUnit: '1s',
+    duration: __ENV.LOAD_DURATION,
+    preAllocatedVUs: 10,
+    maxVUs: 50,
+    exec,
+  };
+}
+
+export const options = {
+  scenarios: { gateway: scenario('gateway'), search: scenario('search') },
+  thresholds: {
+    'http_req_duration{scenario:gateway}': ['max>=0'],
+    'http_req_failed{scenario:gateway}': ['rate>=0'],
+    'http_req_duration{scenario:search}': ['max>=0'],
+    'http_req_failed{scenario:search}': ['rate>=0'],
+  },
+  summaryTrendStats: ['avg', 'med', 'p(95)', 'p(99)', 'max'],
+};
+
+export function setup() {
+  const res = http.post(mcp + '/tools/register', JSON.stringify({ name: 'load-echo', endpoint: 'http://echo:8000/' }), json);
+  if (res.status !== 200) {
+    throw new Error('registering the load test tool: ' + res.status + ' ' + res.body);
+  }
+}
+
+export function gateway() {
+  const res = http.post(mcp + '/api/load-echo', JSON.stringify({ session_id: 'load-' + __VU, seq: __ITER, context: 'load test' }), json);
+  check(res, { 'tool answered': (r) => r.status === 200 });
+}
+
+export function search() {
+  const q = encodeURIComponent(queries[__ITER % queries.length]);
+  const mode = modes[__ITER % modes.length];
+  const res = http.get(graph + '/search?limit=10&mode=' + mode + '&q=' + q);
+  check(res, { 'search answered': (r) => r.status === 200 });
+}
+`
+
+// testLoad runs k6 against the MCP gateway and the knowledge graph's search
+// at a steady rate, and fails the pipeline if either misses its p95
+// latency or error rate SLO. It runs when LOAD_TEST is set, and before
+// images are published, so a performance regression is never published.
+// k6's summary is written to dir/load-test.json.
+func testLoad(ctx context.Context, client *dagger.Client, mcpServerContainer *dagger.Container, knowledgeGraph *dagger.Service, dir string) error {
+	if os.Getenv("LOAD_TEST") == "" && !publishing() {
+		fmt.Println("⏭️ Skipping load test: LOAD_TEST is not set")
+		return nil
+	}
+	fmt.Println("🧪 Testing Load...")
+
+	rate, duration := os.Getenv("LOAD_RATE"), os.Getenv("LOAD_DURATION")
+	if rate == "" {
+		rate = "20"
+	}
+	if duration == "" {
+		duration = "30s"
+	}
+	if n, err := strconv.Atoi(rate); err != nil || n <= 0 {
+		return fmt.Errorf("LOAD_RATE must be a positive number of requests a second, not %q", rate)
+	}
+
+	echo := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/echo.py", dagger.ContainerWithNewFileOpts{Contents: loadEchoPy}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "/srv/echo.py"}).
+		AsService()
+	mcp := mcpServerContainer.
+		WithServiceBinding("echo", echo).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	summary := client.Container().
+		From(k6Image).
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithNewFile("/scripts/load.js", dagger.ContainerWithNewFileOpts{Contents: loadScript}).
+		WithEnvVariable("MCP_URL", "http://mcp-server:3000").
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)).
+		WithEnvVariable("LOAD_RATE", rate).
+		WithEnvVariable("LOAD_DURATION", duration).
+		WithExec([]string{"k6", "run", "--quiet", "--summary-export", "/tmp/load-test.json", "/scripts/load.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		File("/tmp/load-test.json")
+	if _, err := summary.Export(ctx, dir+"/load-test.json"); err != nil {
+		return err
+	}
+	contents, err := summary.Contents(ctx)
+	if err != nil {
+		return err
+	}
+	return checkLoad(contents)
+}
+
+// checkLoad checks k6's summary against loadSLOs.
+func checkLoad(contents string) error {
+	var summary struct {
+		Metrics map[string]map[string]any `json:"metrics"`
+	}
+	if err := json.Unmarshal([]byte(contents), &summary); err != nil {
+		return fmt.Errorf("unexpected k6 summary: %w", err)
+	}
+	stat := func(metric, name string) (float64, error) {
+		value, ok := summary.Metrics[metric][name].(float64)
+		if !ok {
+			return 0, fmt.Errorf("k6 summary has no %s for %s", name, metric)
+		}
+		return value, nil
+	}
+
+	var breaches []string
+	for _, slo := range loadSLOs {
+		p95Limit, err := getenvFloat(slo.p95Env, slo.p95)
+		if err != nil {
+			return err
+		}
+		errorLimit, err := getenvFloat(slo.errorEnv, slo.errorRate)
+		if err != nil {
+			return err
+		}
+		p95, err := stat(fmt.Sprintf("http_req_duration{scenario:%s}", slo.scenario), "p(95)")
+		if err != nil {
+			return err
+		}
+		failed, err := stat(fmt.Sprintf("http_req_failed{scenario:%s}", slo.scenario), "value")
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Load: %s p95 %.0fms (SLO %.0fms), %.2f%% failed (SLO %.2f%%)\n",
+			slo.scenario, p95, p95Limit, 100*failed, 100*errorLimit)
+		if p95 > p95Limit {
+			breaches = append(breaches, fmt.Sprintf("%s p95 latency %.0fms is over %.0fms (%s)", slo.scenario, p95, p95Limit, slo.p95Env))
+		}
+		if failed > errorLimit {
+			breaches = append(breaches, fmt.Sprintf("%s error rate %.2f%% is over %.2f%% (%s)", slo.scenario, 100*failed, 100*errorLimit, slo.errorEnv))
+		}
+	}
+	if len(breaches) > 0 {
+		return fmt.Errorf("SLOs breached: %s", strings.Join(breaches, "; "))
+	}
+	return nil
+}
+
+// getenvFloat reads a number from the host's environment, or fallback
+// when it is not set.
+func getenvFloat(key string, fallback float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number, not %q", key, value)
+	}
+	return n, nil
+}
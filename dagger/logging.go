@@ -0,0 +1,580 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// loggingSource is the shared structured logging, relative to the
+// repository root the pipeline runs from.
+const loggingSource = "packages/logging"
+
+// lokiPort is where Loki takes pushed lines and answers queries.
+const lokiPort = 3100
+
+// lokiService is a single-binary Loki keeping lines in memory and on local
+// disk, as the logging test and the observability stack run it.
+func lokiService(client *dagger.Client) *dagger.Service {
+	return client.Container().
+		From("grafana/loki:2.9.8").
+		WithExposedPort(lokiPort).
+		AsService()
+}
+
+// withLogging binds Loki into a container as loki and has the service ship
+// its lines to it.
+func withLogging(container *dagger.Container, loki *dagger.Service) *dagger.Container {
+	return container.
+		WithServiceBinding("loki", loki).
+		WithEnvVariable("LOKI_URL", fmt.Sprintf("http://loki:%d", lokiPort))
+}
+
+// testLogging runs a job for a tenant's session through the orchestrator,
+// the MCP server and session memory, all shipping to Loki, and checks each
+// of them logged it as JSON lines carrying the tenant, the session and the
+// job's trace ID, which is what ties the lines of one request together.
+func testLogging(ctx context.Context, client *dagger.Client, orchestratorContainer, mcpServer, sessionMemoryContainer *dagger.Container, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing Structured logging...")
+
+	loki := lokiService(client)
+	memory := withLogging(withRedis(sessionMemoryContainer, redis), loki).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	mcp := withLogging(mcpServer, loki).
+		WithServiceBinding("session-memory", memory).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	orchestrator := withLogging(orchestratorContainer, loki).
+		WithServiceBinding("mcp-server", mcp).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		AsService()
+
+	// Lines are pushed at least every two seconds
+	services := []string{"orchestrator", "mcp-server", "session-memory"}
+	base := fmt.Sprintf("http://orchestrator:%d", orchestratorPort)
+	job := `{"agent_type": "context_gatherer", "target": "logging-target", "session_id": "logging-session", "tenant": "acme"}`
+	script := fmt.Sprintf(`set -e
+job=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '%[2]s' %[1]s/jobs)
+trace=$(echo "$job" | sed 's/.*"traceparent":"00-\([0-9a-f]*\)-.*/\1/')
+id=$(echo "$job" | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 60); do
+  state=$(curl -fsS %[1]s/jobs/$id)
+  case "$state" in *'"reported":true'*|*'"status":"failed"'*) break;; esac
+  sleep 1
+done
+case "$state" in *'"status":"succeeded"'*) ;; *) echo "job did not succeed: $state" >&2; exit 1;; esac
+echo "$trace"
+for service in %[4]s; do
+  for i in $(seq 15); do
+    sleep 2
+    lines=$(curl -fsS -G --data-urlencode "query={service=\"$service\"} |= \"$trace\"" http://loki:%[3]d/loki/api/v1/query_range)
+    case "$lines" in *'"values"'*) break;; esac
+  done
+  echo "$lines"
+done`, base, job, lokiPort, strings.Join(services, " "))
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("orchestrator", orchestrator).
+		WithServiceBinding("loki", loki).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	results := strings.Split(strings.TrimSpace(output), "\n")
+	if len(results) != len(services)+1 {
+		return fmt.Errorf("unexpected logging output: %s", output)
+	}
+	trace := results[0]
+
+	// What each service has to have logged for the job: the orchestrator its
+	// run, the MCP server the report and session memory the context stored
+	want := map[string]map[string]string{
+		"orchestrator":   {"tenant": "acme", "session_id": "logging-session", "trace_id": trace},
+		"mcp-server":     {"tenant": "acme", "trace_id": trace},
+		"session-memory": {"tenant": "acme", "session_id": "logging-session", "trace_id": trace},
+	}
+	for i, service := range services {
+		var found struct {
+			Data struct {
+				Result []struct {
+					Stream map[string]string `json:"stream"`
+					Values [][2]string       `json:"values"`
+				} `json:"result"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(results[i+1]), &found); err != nil {
+			return fmt.Errorf("reading %s's lines from Loki: %w: %s", service, err, results[i+1])
+		}
+		matched := false
+		for _, stream := range found.Data.Result {
+			for _, value := range stream.Values {
+				var line map[string]any
+				if err := json.Unmarshal([]byte(value[1]), &line); err != nil {
+					return fmt.Errorf("%s logged a line that is not JSON: %s", service, value[1])
+				}
+				if line["service"] != service || line["time"] == nil || line["level"] == nil || line["msg"] == nil {
+					return fmt.Errorf("%s logged a line without the common fields: %s", service, value[1])
+				}
+				fields := true
+				for key, value := range want[service] {
+					fields = fields && line[key] == value
+				}
+				matched = matched || fields
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s logged no line with %v: %s", service, want[service], results[i+1])
+		}
+	}
+
+	fmt.Printf("Structured logging: trace %s followed through %s in Loki\n", trace, strings.Join(services, ", "))
+	return nil
+}
+
+// loggingPy is the Python side of packages/logging. It is logs.py, since
+// logging is the standard library's.
+const loggingPy = `#!/usr/bin/env python3
+"""Structured logging, the same as packages/logging: one JSON object per line
+on standard error, with time, level, msg and service, and the tenant,
+session_id, trace_id and span_id of what is being handled. LOG_LEVEL is the
+least level written, debug, info, warn or error, and LOG_FORMAT json or, for
+reading at a terminal, text. With LOKI_URL set, lines are pushed to that Loki
+as well.
+
+A service calls setup(name) once, after which the standard library's
+loggers, uvicorn's among them, write the same lines. install(app) logs every
+request to a FastAPI app with its tenant and session. bind(tenant=...,
+session_id=...) sets the fields of the lines logged in a with block, and the
+trace is the current span's (see tracing.py).
+"""
+import atexit
+import contextlib
+import contextvars
+import datetime
+import json
+import logging
+import os
+import re
+import sys
+import threading
+import time
+import urllib.request
+
+import tracing
+
+# Lines are pushed BATCH_SIZE at once, at the latest every FLUSH_INTERVAL
+# seconds; past MAX_QUEUED waiting for Loki, new ones are dropped.
+BATCH_SIZE = 1000
+FLUSH_INTERVAL = 2
+MAX_QUEUED = 10000
+
+# The level names of packages/logging
+LEVELS = {"DEBUG": "DEBUG", "INFO": "INFO", "WARNING": "WARN", "ERROR": "ERROR", "CRITICAL": "ERROR"}
+
+# The session a request is for, from its path or query
+SESSION_PATH = re.compile(r"/sessions/([^/]+)")
+
+_fields = contextvars.ContextVar("log_fields", default={})
+_service = "unknown_service"
+
+
+@contextlib.contextmanager
+def bind(**fields):
+    """Adds fields, such as tenant and session_id, to the lines logged in the
+    with block and the threads and tasks started from it"""
+    token = _fields.set({**_fields.get(), **{key: value for key, value in fields.items() if value}})
+    try:
+        yield
+    finally:
+        _fields.reset(token)
+
+
+class Formatter(logging.Formatter):
+    """A record as the fields packages/logging writes, in JSON or as
+    key=value text"""
+
+    def __init__(self, service, text=False):
+        super().__init__()
+        self.service, self.text = service, text
+
+    def fields(self, record):
+        at = datetime.datetime.fromtimestamp(record.created, datetime.timezone.utc)
+        fields = {"time": at.isoformat(timespec="microseconds").replace("+00:00", "Z"),
+                  "level": LEVELS.get(record.levelname, record.levelname), "msg": record.getMessage(),
+                  "service": self.service}
+        if record.name not in ("root", self.service):
+            fields["logger"] = record.name
+        fields.update(getattr(record, "fields", {}))
+        fields.update(_fields.get())
+        span = tracing.current()
+        if span:
+            fields["trace_id"], fields["span_id"] = span.trace_id, span.span_id
+        if record.exc_info:
+            fields["error"] = self.formatException(record.exc_info)
+        return fields
+
+    def format(self, record):
+        fields = self.fields(record)
+        if not self.text:
+            return json.dumps(fields, default=str, separators=(",", ":"))
+        return " ".join(f"{key}={text_value(value)}" for key, value in fields.items())
+
+
+def text_value(value):
+    """value as in key=value text, quoted when it has spaces"""
+    if isinstance(value, str) and value and not any(c.isspace() for c in value):
+        return value
+    return json.dumps(value, default=str)
+
+
+class LokiHandler(logging.Handler):
+    """Pushes lines to Loki from a thread of its own, a stream per level
+    labeled with the service. Loki that cannot be reached costs those lines,
+    never the service, and its errors go to standard error rather than back
+    through logging."""
+
+    def __init__(self, service, url):
+        super().__init__()
+        self.service, self.url = service, url.rstrip("/") + "/loki/api/v1/push"
+        self.queued, self.dropped = [], 0
+        self.queue_lock, self.wake, self.stopped = threading.Lock(), threading.Event(), threading.Event()
+        self.thread = threading.Thread(target=self.loop, name="loki-shipper", daemon=True)
+        self.thread.start()
+
+    def emit(self, record):
+        try:
+            line = self.format(record)
+        except Exception:
+            self.handleError(record)
+            return
+        with self.queue_lock:
+            if len(self.queued) >= MAX_QUEUED:
+                self.dropped += 1
+                return
+            self.queued.append((time.time_ns(), LEVELS.get(record.levelname, record.levelname).lower(), line))
+            full = len(self.queued) >= BATCH_SIZE
+        if full:
+            self.wake.set()
+
+    def loop(self):
+        while not self.stopped.is_set():
+            self.wake.wait(FLUSH_INTERVAL)
+            self.wake.clear()
+            self.send()
+
+    def send(self):
+        while True:
+            with self.queue_lock:
+                batch, self.queued = self.queued[:BATCH_SIZE], self.queued[BATCH_SIZE:]
+                dropped, self.dropped = self.dropped, 0
+            if dropped:
+                print(f"logging: dropped {dropped} lines while Loki was behind", file=sys.stderr)
+            if not batch:
+                return
+            try:
+                self.push(batch)
+            except (OSError, ValueError) as e:
+                print(f"logging: could not push {len(batch)} lines to Loki: {e}", file=sys.stderr)
+
+    def push(self, batch):
+        streams = {}
+        for at, level, line in batch:
+            streams.setdefault(level, []).append([str(at), line])
+        body = {"streams": [{"stream": {"service": self.service, "level": level}, "values": values}
+                            for level, values in streams.items()]}
+        request = urllib.request.Request(self.url, data=json.dumps(body).encode(), method="POST",
+                                         headers={"Content-Type": "application/json"})
+        with urllib.request.urlopen(request, timeout=10) as response:
+            response.read()
+
+    def close(self):
+        """Pushes the lines still queued"""
+        self.stopped.set()
+        self.wake.set()
+        self.thread.join(timeout=10)
+        self.send()
+        super().close()
+
+
+def setup(service):
+    """Makes every logger write service's lines, to standard error and to
+    LOKI_URL when it is set, and returns service's own"""
+    global _service
+    _service = service
+    level = logging.getLevelName((os.getenv("LOG_LEVEL") or "info").upper())
+    if not isinstance(level, int):
+        level = logging.INFO
+    formatter = Formatter(service, text=(os.getenv("LOG_FORMAT") or "").lower() == "text")
+    handlers = [logging.StreamHandler(sys.stderr)]
+    if os.getenv("LOKI_URL"):
+        handlers.append(LokiHandler(service, os.getenv("LOKI_URL")))
+    root = logging.getLogger()
+    for handler in root.handlers[:]:
+        root.removeHandler(handler)
+    for handler in handlers:
+        handler.setFormatter(formatter)
+        root.addHandler(handler)
+    root.setLevel(level)
+    # uvicorn's own lines go through the root logger; install() logs the
+    # requests, with their fields
+    for name in ("uvicorn", "uvicorn.error", "uvicorn.access"):
+        logging.getLogger(name).handlers = []
+        logging.getLogger(name).propagate = True
+    logging.getLogger("uvicorn.access").disabled = True
+    atexit.register(logging.shutdown)
+    logger = logging.getLogger(service)
+    logger.info(f"logging {service} at {LEVELS.get(logging.getLevelName(level))} as "
+                f"{'text' if formatter.text else 'json'}" +
+                (f", shipped to {handlers[1].url}" if len(handlers) > 1 else ""))
+    return logger
+
+
+def log(level, msg, **fields):
+    """Logs msg with fields as the service setup() set up"""
+    logging.getLogger(_service).log(level, msg, extra={"fields": fields})
+
+
+def info(msg, **fields):
+    log(logging.INFO, msg, **fields)
+
+
+def warning(msg, **fields):
+    log(logging.WARNING, msg, **fields)
+
+
+def error(msg, **fields):
+    log(logging.ERROR, msg, **fields)
+
+
+def install(app):
+    """Logs every request to a FastAPI app, but GET /health and GET
+    /metrics, with the tenant rbac.install() resolved and the session in its
+    path or query. Call it before rbac.install() and tracing.install(), so
+    it runs inside them."""
+
+    @app.middleware("http")
+    async def log_requests(request, call_next):
+        path = request.url.path
+        if request.method == "GET" and path in ("/health", "/metrics"):
+            return await call_next(request)
+        session = SESSION_PATH.search(path)
+        with bind(tenant=getattr(request.state, "tenant", None),
+                  session_id=session.group(1) if session else request.query_params.get("session_id")):
+            started = time.monotonic()
+            response = await call_next(request)
+            log(logging.WARNING if response.status_code >= 500 else logging.INFO, "request",
+                method=request.method, path=path, status=response.status_code,
+                duration_ms=round((time.monotonic() - started) * 1000, 1))
+            return response
+`
+
+// loggingJs is the MCP server's side of packages/logging.
+const loggingJs = `// Structured logging shared by the services of the dynamic context system:
+// the same JSON lines as packages/logging and logs.py, with time, level, msg
+// and service, and the tenant, session_id, trace_id and span_id of what is
+// being handled. LOG_LEVEL is the least level written, LOG_FORMAT json or
+// text, and with LOKI_URL set lines are pushed to that Loki as well.
+const http = require('http');
+const https = require('https');
+const { AsyncLocalStorage } = require('async_hooks');
+const tracing = require('./tracing');
+
+const LEVELS = { debug: -4, info: 0, warn: 4, error: 8 };
+
+// Lines are pushed BATCH_SIZE at once, at the latest every FLUSH_INTERVAL
+// milliseconds; past MAX_QUEUED waiting for Loki, new ones are dropped.
+const BATCH_SIZE = 1000;
+const FLUSH_INTERVAL = 2000;
+const MAX_QUEUED = 10000;
+
+const storage = new AsyncLocalStorage();
+
+// Pushes lines to Loki, a stream per level labeled with the service. Loki
+// that cannot be reached costs those lines, never the service, and its
+// errors go to standard error rather than back through the logger.
+class Shipper {
+    constructor(service, url) {
+        this.service = service;
+        this.url = url.replace(/\/+$/, '') + '/loki/api/v1/push';
+        this.queued = [];
+        this.dropped = 0;
+        this.timer = setInterval(() => this.send(), FLUSH_INTERVAL);
+        this.timer.unref();
+    }
+
+    add(level, line) {
+        if (this.queued.length >= MAX_QUEUED) {
+            this.dropped += 1;
+            return;
+        }
+        this.queued.push([level, Date.now() + '000000', line]);
+        if (this.queued.length >= BATCH_SIZE) {
+            this.send();
+        }
+    }
+
+    send() {
+        if (this.dropped > 0) {
+            process.stderr.write('logging: dropped ' + this.dropped + ' lines while Loki was behind\n');
+            this.dropped = 0;
+        }
+        const pushes = [];
+        while (this.queued.length > 0) {
+            const batch = this.queued.splice(0, BATCH_SIZE);
+            pushes.push(this.push(batch).catch((error) => {
+                process.stderr.write('logging: could not push ' + batch.length + ' lines to Loki: ' + error.message + '\n');
+            }));
+        }
+        return Promise.all(pushes);
+    }
+
+    push(batch) {
+        const streams = new Map();
+        for (const [level, at, line] of batch) {
+            if (!streams.has(level)) {
+                streams.set(level, []);
+            }
+            streams.get(level).push([at, line]);
+        }
+        const body = JSON.stringify({ streams: [...streams].map(([level, values]) => ({
+            stream: { service: this.service, level }, values
+        })) });
+        const url = new URL(this.url);
+        const client = url.protocol === 'https:' ? https : http;
+        return new Promise((resolve, reject) => {
+            const request = client.request(url, {
+                method: 'POST',
+                timeout: 10000,
+                headers: { 'Content-Type': 'application/json', 'Content-Length': Buffer.byteLength(body) }
+            }, (response) => {
+                response.resume();
+                response.on('end', () => response.statusCode < 300 ? resolve() :
+                    reject(new Error('Loki answered ' + response.statusCode)));
+            });
+            request.on('timeout', () => request.destroy(new Error('timed out')));
+            request.on('error', reject);
+            request.end(body);
+        });
+    }
+
+    // Pushes the lines still queued
+    shutdown() {
+        clearInterval(this.timer);
+        return this.send();
+    }
+}
+
+class Logger {
+    constructor(service, level = 'info', format = 'json', lokiUrl = '') {
+        this.service = service;
+        this.level = level in LEVELS ? level : 'info';
+        this.format = format === 'text' ? 'text' : 'json';
+        this.shipper = lokiUrl ? new Shipper(service, lokiUrl) : null;
+    }
+
+    static fromEnv(service) {
+        return new Logger(service, (process.env.LOG_LEVEL || 'info').toLowerCase(),
+            (process.env.LOG_FORMAT || 'json').toLowerCase(), process.env.LOKI_URL);
+    }
+
+    // Runs fn with fields, such as tenant and session_id, added to the lines
+    // logged in it and in the async calls made from it
+    bind(fields, fn) {
+        return storage.run({ ...storage.getStore(), ...fields }, fn);
+    }
+
+    // The fields of the current context: those bound, and the current span's
+    fields() {
+        const fields = { ...storage.getStore() };
+        const span = tracing.current();
+        if (span) {
+            fields.trace_id = span.traceId;
+            fields.span_id = span.spanId;
+        }
+        return fields;
+    }
+
+    log(level, msg, extra = {}) {
+        if (LEVELS[level] < LEVELS[this.level]) {
+            return;
+        }
+        const record = { time: new Date().toISOString(), level: level.toUpperCase(), msg, service: this.service,
+            ...this.fields(), ...extra };
+        for (const key of Object.keys(record)) {
+            if (record[key] === undefined || record[key] === null || record[key] === '') {
+                delete record[key];
+            }
+        }
+        const line = this.format === 'json' ? JSON.stringify(record) : Object.entries(record)
+            .map(([key, value]) => key + '=' + (typeof value === 'string' && !/\s/.test(value) ? value : JSON.stringify(value)))
+            .join(' ');
+        process.stderr.write(line + '\n');
+        if (this.shipper) {
+            this.shipper.add(level, line);
+        }
+    }
+
+    debug(msg, extra) {
+        this.log('debug', msg, extra);
+    }
+
+    info(msg, extra) {
+        this.log('info', msg, extra);
+    }
+
+    warn(msg, extra) {
+        this.log('warn', msg, extra);
+    }
+
+    error(msg, extra) {
+        this.log('error', msg, extra);
+    }
+
+    // Express middleware logging every request, but GET /health and GET
+    // /metrics, with the tenant the access control resolved and the session
+    // in its path or query. Use it after the access control, so req.tenant
+    // is set, and after the tracer's, so the request's trace is current.
+    middleware() {
+        return (req, res, next) => {
+            if (req.method === 'GET' && (req.path === '/health' || req.path === '/metrics')) {
+                return next();
+            }
+            const session = /\/sessions\/([^/]+)/.exec(req.path);
+            this.bind({ tenant: req.tenant, session_id: session ? decodeURIComponent(session[1]) : req.query.session_id }, () => {
+                // The response finishes outside the request's context
+                const fields = this.fields();
+                const started = process.hrtime.bigint();
+                res.on('finish', () => this.log(res.statusCode >= 500 ? 'warn' : 'info', 'request', {
+                    ...fields,
+                    method: req.method,
+                    path: req.path,
+                    status: res.statusCode,
+                    duration_ms: Math.round(Number(process.hrtime.bigint() - started) / 1e5) / 10
+                }));
+                next();
+            });
+        };
+    }
+
+    // Pushes the lines still queued
+    shutdown() {
+        return this.shipper ? this.shipper.shutdown() : Promise.resolve();
+    }
+
+    toString() {
+        return 'logging ' + this.service + ' at ' + this.level.toUpperCase() + ' as ' + this.format +
+            (this.shipper ? ', shipped to ' + this.shipper.url : '');
+    }
+}
+
+module.exports = { Logger, LEVELS };
+`
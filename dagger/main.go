@@ -2,20 +2,30 @@ package main
 
 import (
 	"context"
+	"dagger.io/dagger"
+	"encoding/json"
 	"fmt"
 	"os"
-	"dagger.io/dagger"
 )
 
 func main() {
 	ctx := context.Background()
-	
+
+	// Generators need no Dagger engine
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		if err := generate(os.Args[2:], os.Stdout); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Test Dagger connection first
 	if err := testDagger(ctx); err != nil {
 		fmt.Printf("❌ Error: %v\n", err)
 		os.Exit(1)
 	}
-	
+
 	// Run the full pipeline
 	if err := runPipeline(ctx); err != nil {
 		fmt.Printf("❌ Pipeline Error: %v\n", err)
@@ -38,7 +48,7 @@ func testDagger(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	
+
 	fmt.Print(output)
 	return nil
 }
@@ -52,94 +62,407 @@ func runPipeline(ctx context.Context) error {
 
 	fmt.Println("🚀 Starting Dynamic Context MCP System Pipeline...")
 
-	// Build all components in parallel
+	if err := loadComponentPlugins(); err != nil {
+		return err
+	}
+	pipeline, err := loadPipelineConfig()
+	if err != nil {
+		return fmt.Errorf("pipeline config: %w", err)
+	}
+
+	// Build all components; nothing is built until a step needs it
 	microAgentContainer := buildMicroAgentContainer(ctx, client)
-	mcpServerContainer := buildMCPServerContainer(ctx, client)
-	knowledgeGraphContainer := buildKnowledgeGraphContainer(ctx, client)
-	sessionMemoryContainer := buildSessionMemoryContainer(ctx, client)
+	microAgentVariants := buildMicroAgentVariants(ctx, client)
+	built := map[string]*dagger.Container{"micro-agent": microAgentContainer}
+	buildComponents(ctx, client, pipeline, built)
+	mcpServerContainer := built["mcp-server"]
+	knowledgeGraphContainer := built["knowledge-graph"]
+	sessionMemoryContainer := built["session-memory"]
+	orchestratorContainer := built["orchestrator"]
+	goKnowledgeGraphContainer := buildGoKnowledgeGraphContainer(ctx, client)
+	controlPlaneContainer := buildControlPlaneContainer(ctx, client, orchestratorContainer, goKnowledgeGraphContainer)
+	configServiceContainer := buildConfigServiceContainer(ctx, client)
+	adminConsoleContainer := buildAdminConsoleContainer(ctx, client)
 
-	// Test each component
-	if err := testMicroAgent(ctx, microAgentContainer); err != nil {
-		return fmt.Errorf("micro agent test failed: %w", err)
+	// Backing services bound into component tests
+	neo4jService := buildNeo4jService(client)
+	qdrantService := buildQdrantService(client)
+	redisService := buildRedisService(client)
+	sessionPostgresService := buildSessionPostgresService(client)
+	minioService := buildMinioService(client)
+	services := map[string]*dagger.Service{
+		"neo4j":    neo4jService,
+		"qdrant":   qdrantService,
+		"redis":    redisService,
+		"postgres": sessionPostgresService,
+		"minio":    minioService,
 	}
-	
-	if err := testMCPServer(ctx, mcpServerContainer); err != nil {
-		return fmt.Errorf("MCP server test failed: %w", err)
+	knowledgeGraphAPI := knowledgeGraphService(knowledgeGraphContainer, neo4jService, qdrantService)
+	sessionMemoryAPI := sessionMemoryService(sessionMemoryContainer, redisService)
+	goKnowledgeGraphAPI := goKnowledgeGraphService(client, goKnowledgeGraphContainer, qdrantService)
+	goKnowledgeGraphPgvectorAPI := goKnowledgeGraphPgvectorService(client, goKnowledgeGraphContainer, buildPgvectorService(client))
+
+	// Each step runs once the components it needs are built and the steps
+	// it needs have passed, alongside the others, taking turns with those
+	// using the same stores. Published images are only known once the
+	// publish step has run.
+	var images map[string]string
+	steps := []pipelineStep{
+		{name: "build:micro-agent", run: func(ctx context.Context) error {
+			_, err := microAgentContainer.Sync(ctx)
+			return err
+		}},
+		{name: "build:go-knowledge-graph", run: func(ctx context.Context) error {
+			_, err := goKnowledgeGraphContainer.Sync(ctx)
+			return err
+		}},
+		{name: "build:control-plane", run: func(ctx context.Context) error {
+			_, err := controlPlaneContainer.Sync(ctx)
+			return err
+		}},
+		{name: "build:config-service", run: func(ctx context.Context) error {
+			_, err := configServiceContainer.Sync(ctx)
+			return err
+		}},
+		{name: "build:admin-console", run: func(ctx context.Context) error {
+			_, err := adminConsoleContainer.Sync(ctx)
+			return err
+		}},
+
+		// Test each component
+		{name: "test:micro-agent", needs: []string{"build:micro-agent"}, run: func(ctx context.Context) error {
+			if err := testMicroAgent(ctx, microAgentContainer); err != nil {
+				return fmt.Errorf("micro agent test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "test:micro-agent-variants", run: func(ctx context.Context) error {
+			if err := testMicroAgentVariants(ctx, client, microAgentVariants); err != nil {
+				return fmt.Errorf("micro agent variant test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "test:go-knowledge-graph", needs: []string{"build:go-knowledge-graph"}, stores: []string{"qdrant"}, run: func(ctx context.Context) error {
+			if err := testGoKnowledgeGraph(ctx, client, goKnowledgeGraphAPI, "go_service"); err != nil {
+				return fmt.Errorf("Go knowledge graph test failed: %w", err)
+			}
+			if err := testGoKnowledgeGraph(ctx, client, goKnowledgeGraphPgvectorAPI, "go_pgvector"); err != nil {
+				return fmt.Errorf("Go knowledge graph test on pgvector failed: %w", err)
+			}
+			return nil
+		}},
+
+		// Test the components together
+		{name: "integration:control-plane", needs: []string{"build:control-plane", "build:mcp-server", "build:session-memory"}, stores: []string{"redis"}, run: func(ctx context.Context) error {
+			if err := testControlPlane(ctx, client, controlPlaneContainer, mcpServerContainer, sessionMemoryAPI); err != nil {
+				return fmt.Errorf("control plane test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:canary", needs: []string{"build:control-plane", "build:mcp-server"}, run: func(ctx context.Context) error {
+			if err := testCanary(ctx, client, controlPlaneContainer, mcpServerContainer); err != nil {
+				return fmt.Errorf("canary rollout test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:backup", needs: []string{"build:control-plane", "build:go-knowledge-graph", "build:mcp-server", "build:session-memory"}, stores: []string{"redis"}, run: func(ctx context.Context) error {
+			if err := testBackup(ctx, client, controlPlaneContainer, goKnowledgeGraphContainer, mcpServerContainer, sessionMemoryContainer, redisService); err != nil {
+				return fmt.Errorf("backup and restore test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:end-to-end", needs: []string{"build:orchestrator", "build:mcp-server", "build:session-memory", "build:knowledge-graph"}, stores: []string{"redis", "neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testEndToEnd(ctx, client, orchestratorContainer, mcpServerContainer, sessionMemoryAPI, knowledgeGraphAPI); err != nil {
+				return fmt.Errorf("end-to-end context flow test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:ctxctl", needs: []string{"build:orchestrator", "build:mcp-server", "build:session-memory", "build:knowledge-graph"}, stores: []string{"redis", "neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testCtxctl(ctx, client, orchestratorContainer, mcpServerContainer, sessionMemoryAPI, knowledgeGraphAPI); err != nil {
+				return fmt.Errorf("ctxctl test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:chaos", needs: []string{"build:orchestrator", "build:mcp-server", "build:session-memory", "build:knowledge-graph"}, stores: []string{"redis", "neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testChaos(ctx, client, orchestratorContainer, mcpServerContainer, sessionMemoryContainer, knowledgeGraphAPI, redisService); err != nil {
+				return fmt.Errorf("chaos test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:event-bus", needs: []string{"build:orchestrator", "build:mcp-server", "build:knowledge-graph", "build:session-memory"}, stores: []string{"nats", "redis", "neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testEventBus(ctx, client, orchestratorContainer, mcpServerContainer, knowledgeGraphContainer, sessionMemoryContainer, neo4jService, qdrantService, redisService); err != nil {
+				return fmt.Errorf("event bus test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:dashboard", needs: []string{"build:orchestrator", "build:mcp-server", "build:session-memory"}, stores: []string{"nats", "redis"}, run: func(ctx context.Context) error {
+			if err := testDashboard(ctx, client, orchestratorContainer, mcpServerContainer, sessionMemoryContainer, redisService); err != nil {
+				return fmt.Errorf("ctxctl dashboard test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:config-service", needs: []string{"build:config-service", "build:orchestrator", "build:session-memory"}, stores: []string{"redis"}, run: func(ctx context.Context) error {
+			if err := testConfigService(ctx, client, configServiceContainer, orchestratorContainer, sessionMemoryContainer, redisService); err != nil {
+				return fmt.Errorf("config service test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:rbac", needs: []string{"build:mcp-server", "build:knowledge-graph", "build:go-knowledge-graph", "build:session-memory", "build:orchestrator"}, stores: []string{"redis", "neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testRBAC(ctx, client, mcpServerContainer, knowledgeGraphContainer, goKnowledgeGraphContainer, sessionMemoryContainer, orchestratorContainer, neo4jService, qdrantService, redisService); err != nil {
+				return fmt.Errorf("RBAC test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:admin-console", needs: []string{"build:admin-console", "build:mcp-server", "build:knowledge-graph", "build:session-memory", "build:orchestrator"}, stores: []string{"redis", "neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testAdminConsole(ctx, client, adminConsoleContainer, mcpServerContainer, knowledgeGraphContainer, sessionMemoryContainer, orchestratorContainer, neo4jService, qdrantService, redisService); err != nil {
+				return fmt.Errorf("admin console test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:tenancy", needs: []string{"build:mcp-server", "build:knowledge-graph", "build:session-memory", "build:orchestrator"}, stores: []string{"redis", "neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testTenancy(ctx, client, mcpServerContainer, knowledgeGraphContainer, sessionMemoryContainer, orchestratorContainer, neo4jService, qdrantService, redisService); err != nil {
+				return fmt.Errorf("multi-tenancy test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:tracing", needs: []string{"build:orchestrator", "build:mcp-server", "build:knowledge-graph", "build:session-memory"}, stores: []string{"nats", "redis", "neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testTracing(ctx, client, orchestratorContainer, mcpServerContainer, knowledgeGraphContainer, sessionMemoryContainer, neo4jService, qdrantService, redisService); err != nil {
+				return fmt.Errorf("distributed tracing test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:logging", needs: []string{"build:orchestrator", "build:mcp-server", "build:session-memory"}, stores: []string{"redis"}, run: func(ctx context.Context) error {
+			if err := testLogging(ctx, client, orchestratorContainer, mcpServerContainer, sessionMemoryContainer, redisService); err != nil {
+				return fmt.Errorf("structured logging test failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "integration:api-versions", needs: []string{"build:mcp-server", "build:session-memory", "build:knowledge-graph", "build:go-knowledge-graph"}, stores: []string{"redis", "neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testAPIVersions(ctx, client, mcpServerContainer, sessionMemoryAPI, knowledgeGraphAPI, goKnowledgeGraphAPI); err != nil {
+				return fmt.Errorf("API versioning test failed: %w", err)
+			}
+			return nil
+		}},
+
+		{name: "verify:session-backup", needs: []string{"build:session-memory"}, stores: []string{"redis", "minio"}, run: func(ctx context.Context) error {
+			if err := verifySessionBackup(ctx, sessionMemoryContainer, redisService, minioService, "build/session-memory-snapshot.json.gz"); err != nil {
+				return fmt.Errorf("session memory backup verification failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "verify:restore", needs: []string{"build:control-plane", "build:knowledge-graph", "build:mcp-server", "build:session-memory"}, run: func(ctx context.Context) error {
+			if err := verifyRestore(ctx, client, controlPlaneContainer, knowledgeGraphContainer, mcpServerContainer, sessionMemoryContainer); err != nil {
+				return fmt.Errorf("restore verification failed: %w", err)
+			}
+			return nil
+		}},
+
+		// Collect artifacts from the integration tests
+		{name: "artifacts:micro-agent-images", needs: []string{"test:micro-agent-variants"}, run: func(ctx context.Context) error {
+			if err := exportMicroAgentImages(ctx, microAgentVariants, "build"); err != nil {
+				return fmt.Errorf("micro agent image export failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "artifacts:knowledge-graph", needs: []string{"build:knowledge-graph"}, stores: []string{"neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := exportKnowledgeGraph(ctx, knowledgeGraphContainer, neo4jService, qdrantService, "build/knowledge-graph.jsonl"); err != nil {
+				return fmt.Errorf("knowledge graph export failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "artifacts:observability", needs: []string{"build:orchestrator", "build:session-memory"}, stores: []string{"redis"}, run: func(ctx context.Context) error {
+			if err := deployObservability(ctx, client, orchestratorContainer, sessionMemoryContainer, redisService, "build"); err != nil {
+				return fmt.Errorf("observability stack failed: %w", err)
+			}
+			return nil
+		}},
+
+		{name: "load-test:gateway", needs: []string{"build:mcp-server", "build:knowledge-graph"}, stores: []string{"neo4j", "qdrant"}, run: func(ctx context.Context) error {
+			if err := testLoad(ctx, client, mcpServerContainer, knowledgeGraphAPI, "build"); err != nil {
+				return fmt.Errorf("load test failed: %w", err)
+			}
+			return nil
+		}},
+
+		{name: "deploy:terraform", needs: []string{"publish:images"}, run: func(ctx context.Context) error {
+			if err := generateInfrastructure(ctx, client, images, "build"); err != nil {
+				return fmt.Errorf("infrastructure generation failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "deploy:helm", needs: []string{"publish:images"}, run: func(ctx context.Context) error {
+			if err := generateHelmChart(ctx, client, images, "build"); err != nil {
+				return fmt.Errorf("helm chart generation failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "deploy:kustomize", needs: []string{"publish:images"}, run: func(ctx context.Context) error {
+			if err := generateKustomize(ctx, client, images, "build"); err != nil {
+				return fmt.Errorf("kustomize generation failed: %w", err)
+			}
+			return nil
+		}},
+		{name: "deploy:dev-compose", run: func(ctx context.Context) error {
+			if err := generateDevCompose(ctx, client, built, "build"); err != nil {
+				return fmt.Errorf("dev compose generation failed: %w", err)
+			}
+			return nil
+		}},
 	}
-	
-	if err := testKnowledgeGraph(ctx, knowledgeGraphContainer); err != nil {
-		return fmt.Errorf("knowledge graph test failed: %w", err)
+	steps = append(steps, componentSteps(client, pipeline, built, services)...)
+
+	// Nothing is published until every test has passed
+	var gate []string
+	for _, step := range steps {
+		switch step.stage() {
+		case "test", "integration", "verify", "load-test":
+			gate = append(gate, step.name)
+		}
 	}
-	
-	if err := testSessionMemory(ctx, sessionMemoryContainer); err != nil {
-		return fmt.Errorf("session memory test failed: %w", err)
+	steps = append(steps, pipelineStep{name: "publish:images", needs: gate, run: func(ctx context.Context) error {
+		published, err := publishDeployment(ctx, pipeline.builders(), built)
+		if err != nil {
+			return fmt.Errorf("image publishing failed: %w", err)
+		}
+		images = published
+		return nil
+	}})
+
+	steps = withStageHooks(steps, func(ctx context.Context, stage, when string) error {
+		if stage != "publish" || when != "after" {
+			return runHooks(ctx, client, pipeline, stage, when, nil)
+		}
+		// Nothing published is told to hooks as {}
+		published, err := json.Marshal(images)
+		if images == nil {
+			published = []byte("{}")
+		}
+		if err != nil {
+			return err
+		}
+		return runHooks(ctx, client, pipeline, stage, when, map[string]string{"PIPELINE_IMAGES": string(published)})
+	})
+	if err := runSteps(ctx, steps); err != nil {
+		return err
 	}
 
 	fmt.Println("✅ All components tested successfully!")
 	return nil
 }
 
-// Micro Agent Container - Auto-deploys context gathering agents
-func buildMicroAgentContainer(ctx context.Context, client *dagger.Client) *dagger.Container {
-	fmt.Println("🤖 Building Micro Agent Container...")
-	
-	return client.Container().
-		From("python:3.11-slim").
-		WithWorkdir("/app").
-		WithExec([]string{"pip", "install", "requests", "beautifulsoup4", "aiohttp"}).
-		WithNewFile("/app/micro_agent.py", dagger.ContainerWithNewFileOpts{
-			Contents: `#!/usr/bin/env python3
-import asyncio
-import json
-import sys
-from datetime import datetime
-
-class MicroAgent:
-    def __init__(self, agent_type="context_gatherer"):
-        self.agent_type = agent_type
-        self.context_data = {}
-    
-    async def gather_context(self, target):
-        print(f"🔍 Gathering context for: {target}")
-        # Simulate context gathering
-        self.context_data = {
-            "timestamp": datetime.now().isoformat(),
-            "agent_type": self.agent_type,
-            "target": target,
-            "context": f"Dynamic context for {target}",
-            "metadata": {"source": "micro_agent", "version": "1.0"}
-        }
-        return self.context_data
-    
-    def export_context(self):
-        return json.dumps(self.context_data, indent=2)
-
-if __name__ == "__main__":
-    agent = MicroAgent()
-    target = sys.argv[1] if len(sys.argv) > 1 else "default_target"
-    context = asyncio.run(agent.gather_context(target))
-    print("✅ Context gathered successfully!")
-    print(agent.export_context())
-`,
-			Permissions: 0755,
-		}).
-		WithEntrypoint([]string{"python3", "/app/micro_agent.py"})
+// agentOutputSchema is the orchestrator's schema of agent output, which the
+// MCP server validates streamed items and submitted results against too.
+const agentOutputSchema = orchestratorSource + "/schema/agent-output.schema.json"
+
+func init() {
+	registerComponent(mcpServerComponent{})
+}
+
+// mcpServerComponent is the MCP server's builder, whose tests include the
+// agent SDKs' recorded contracts.
+type mcpServerComponent struct{ publishContainer }
+
+func (mcpServerComponent) Name() string { return "mcp-server" }
+
+func (mcpServerComponent) Build(ctx context.Context, client *dagger.Client, built map[string]*dagger.Container) *dagger.Container {
+	return buildMCPServerContainer(ctx, client)
+}
+
+func (c mcpServerComponent) Test(ctx context.Context, client *dagger.Client, built map[string]*dagger.Container, services map[string]*dagger.Service) error {
+	if err := testMCPServer(ctx, built[c.Name()]); err != nil {
+		return fmt.Errorf("MCP server test failed: %w", err)
+	}
+	if err := testContracts(ctx, client, built[c.Name()], "build"); err != nil {
+		return fmt.Errorf("agent contract test failed: %w", err)
+	}
+	return nil
 }
 
 // MCP Server Container - Universal tool/API gateway
 func buildMCPServerContainer(ctx context.Context, client *dagger.Client) *dagger.Container {
 	fmt.Println("🌐 Building MCP Server Container...")
-	
+
 	return client.Container().
 		From("node:18-alpine").
 		WithWorkdir("/app").
 		WithExec([]string{"npm", "init", "-y"}).
-		WithExec([]string{"npm", "install", "express", "socket.io", "axios"}).
+		WithExec([]string{"npm", "install", "express", "socket.io", "axios", "ajv@8"}).
+		WithFile("/app/agent-output.schema.json", client.Host().File(agentOutputSchema)).
+		WithNewFile("/app/rbac.js", dagger.ContainerWithNewFileOpts{Contents: rbacJs}).
+		WithNewFile("/app/tracing.js", dagger.ContainerWithNewFileOpts{Contents: tracingJs}).
+		WithNewFile("/app/logging.js", dagger.ContainerWithNewFileOpts{Contents: loggingJs}).
+		WithNewFile("/app/api_version.js", dagger.ContainerWithNewFileOpts{Contents: apiVersionJs}).
+		WithNewFile("/app/event_bus.js", dagger.ContainerWithNewFileOpts{Contents: eventBusJs}).
 		WithNewFile("/app/mcp_server.js", dagger.ContainerWithNewFileOpts{
 			Contents: `const express = require('express');
+const fs = require('fs');
 const http = require('http');
 const socketIo = require('socket.io');
 const axios = require('axios');
+const Ajv = require('ajv');
+const apiVersion = require('./api_version');
+const eventBus = require('./event_bus');
+const logging = require('./logging');
+const rbac = require('./rbac');
+const tracing = require('./tracing');
+
+// Stops calling a service that keeps failing: after threshold failures in a
+// row it opens and calls fail at once, until cooldown ms have passed and
+// one call is let through to try the service again
+class CircuitBreaker {
+    constructor(name, threshold, cooldown, log) {
+        this.name = name;
+        this.threshold = threshold;
+        this.cooldown = cooldown;
+        this.log = log;
+        this.failures = 0;
+        this.openedAt = null;
+        this.trying = false;
+    }
+
+    get state() {
+        if (this.openedAt === null) {
+            return 'closed';
+        }
+        return Date.now() - this.openedAt >= this.cooldown ? 'half-open' : 'open';
+    }
+
+    // Whether a call may go ahead; once the breaker is half-open, only one
+    // at a time does
+    allow() {
+        const state = this.state;
+        if (state === 'half-open' && !this.trying) {
+            this.trying = true;
+            return true;
+        }
+        return state === 'closed';
+    }
+
+    // Seconds until the breaker lets a call through again
+    retryAfter() {
+        return this.openedAt === null ? 0 : Math.max(1, Math.ceil((this.openedAt + this.cooldown - Date.now()) / 1000));
+    }
+
+    succeeded() {
+        if (this.openedAt !== null) {
+            this.log.info(this.name + ' circuit closed');
+        }
+        this.failures = 0;
+        this.openedAt = null;
+        this.trying = false;
+    }
+
+    failed() {
+        this.failures++;
+        this.trying = false;
+        if (this.openedAt !== null || this.failures >= this.threshold) {
+            if (this.openedAt === null) {
+                this.log.warn(this.name + ' circuit open', { failures: this.failures });
+            }
+            this.openedAt = Date.now();
+        }
+    }
+}
 
 class MCPServer {
     constructor(port = 3000) {
@@ -147,325 +470,620 @@ class MCPServer {
         this.server = http.createServer(this.app);
         this.io = socketIo(this.server);
         this.port = port;
+        // Each tenant's tools, by tenant
         this.tools = new Map();
         this.apis = new Map();
+        this.log = logging.Logger.fromEnv('mcp-server');
+        // Tool invocations are published on the event bus, when there is one
+        this.bus = eventBus.fromEnv('mcp-server');
+        this.memoryUrl = process.env.SESSION_MEMORY_URL;
+        this.memoryBreaker = new CircuitBreaker('session memory', parseInt(process.env.MEMORY_BREAKER_THRESHOLD || '5', 10),
+            parseFloat(process.env.MEMORY_BREAKER_COOLDOWN || '10') * 1000, this.log);
+        this.streams = new Map();
+        this.quarantine = [];
+        this.validators = this.loadSchema(process.env.AGENT_OUTPUT_SCHEMA || '/app/agent-output.schema.json');
+        this.authorizer = rbac.Authorizer.fromEnv();
+        this.quotas = this.loadQuotas(process.env.MCP_TENANT_QUOTAS);
+        this.calls = new Map();
+        this.tracer = tracing.Tracer.fromEnv('mcp-server');
         this.setupRoutes();
         this.setupSocketHandlers();
     }
 
     setupRoutes() {
-        this.app.use(express.json());
-        
+        // /v1/... is served as the route without the prefix, which is
+        // deprecated, before anything else sees the path
+        this.app.use(apiVersion.middleware());
+
+        // Every request is a span, and the calls made for it carry the trace on
+        this.app.use(this.tracer.middleware());
+
+        // Agent results and checkpoints run well past express's 100kb default
+        this.app.use(express.json({ limit: '10mb' }));
+
+        // Access control, when RBAC_SECRET and RBAC_POLICY are set. What
+        // goes on to session memory takes its rules, without the prefix.
+        // Every request is also for a tenant, from X-Tenant-ID or its token.
+        this.app.use((req, res, next) => {
+            const proxied = req.path === '/memory' || req.path.startsWith('/memory/');
+            const service = proxied ? 'memory' : 'mcp';
+            const path = proxied ? req.path.slice('/memory'.length) || '/' : req.path;
+            try {
+                req.claims = this.authorizer ? this.authorizer.authorize(service, req.method, path, req.get('authorization')) : {};
+                req.tenant = rbac.resolveTenant(req.get(rbac.TENANT_HEADER), req.claims);
+                next();
+            } catch (error) {
+                if (!(error instanceof rbac.Refused)) {
+                    return next(error);
+                }
+                if (error.status === 401) {
+                    res.set('WWW-Authenticate', 'Bearer');
+                } else if (error.status === 403) {
+                    this.log.warn('refused ' + req.method + ' ' + req.path + ' to ' + this.describe(error.claims));
+                }
+                res.status(error.status).json({ error: error.message });
+            }
+        });
+
+        // Every request is logged with its tenant and trace
+        this.app.use(this.log.middleware());
+
         // Health check
         this.app.get('/health', (req, res) => {
-            res.json({ status: 'healthy', timestamp: new Date().toISOString() });
+            res.json({ status: 'healthy', timestamp: new Date().toISOString(),
+                session_memory: this.memoryUrl ? this.memoryBreaker.state : undefined });
         });
         
-        // Tool registry
+        // Tool registry; a tenant sees only the tools it registered
         this.app.post('/tools/register', (req, res) => {
             const { name, endpoint, config } = req.body;
-            this.tools.set(name, { endpoint, config });
-            res.json({ message: 'Tool registered successfully', name });
+            this.tenantTools(req.tenant).set(name, { endpoint, config });
+            res.json({ message: 'Tool registered successfully', name, tenant: req.tenant });
         });
-        
-        // API gateway
+
+        this.app.get('/tools', (req, res) => {
+            const tools = Array.from(this.tenantTools(req.tenant), ([name, tool]) => ({ name, ...tool }));
+            res.json({ tenant: req.tenant, tools });
+        });
+
+        this.app.delete('/tools/:name', (req, res) => {
+            if (!this.tenantTools(req.tenant).delete(req.params.name)) {
+                return res.status(404).json({ error: 'Tool not found' });
+            }
+            res.json({ message: 'Tool removed', name: req.params.name, tenant: req.tenant });
+        });
+
+        // Every tenant's tools, for the control plane's backup of the
+        // deployment. As they are every tenant's, a token bound to one
+        // tenant may neither take nor restore them.
+        this.app.get('/backup', (req, res) => {
+            if (req.tenant !== rbac.DEFAULT_TENANT) {
+                return res.status(403).json({ error: "Backups hold every tenant's data" });
+            }
+            const tools = {};
+            for (const [tenant, registered] of this.tools) {
+                if (registered.size > 0) {
+                    tools[tenant] = Object.fromEntries(registered);
+                }
+            }
+            res.json({ format: 'mcp-tools', version: 1, tools });
+        });
+
+        // Restores a backup's tools over those of the same tenant and name
+        this.app.post('/backup/restore', (req, res) => {
+            if (req.tenant !== rbac.DEFAULT_TENANT) {
+                return res.status(403).json({ error: "Backups hold every tenant's data" });
+            }
+            const { format, version, tools } = req.body || {};
+            if (format !== 'mcp-tools' || version !== 1 || typeof tools !== 'object' || tools === null) {
+                return res.status(400).json({ error: 'Not a version 1 tool registry backup' });
+            }
+            const entries = Object.entries(tools).flatMap(([tenant, registered]) =>
+                Object.entries(registered || {}).map(([name, tool]) => [tenant, name, tool]));
+            if (entries.some(([, , tool]) => !tool || typeof tool.endpoint !== 'string')) {
+                return res.status(400).json({ error: 'Every tool needs an endpoint' });
+            }
+            for (const [tenant, name, { endpoint, config }] of entries) {
+                this.tenantTools(tenant).set(name, { endpoint, config });
+            }
+            this.log.info('tool registry restored', { tenants: Object.keys(tools).length, tools: entries.length });
+            res.json({ tenants: Object.keys(tools).length, tools: entries.length });
+        });
+
+        // API gateway, to the tenant's tools and then the shared APIs. The
+        // tool is told the tenant it is invoked for.
         this.app.post('/api/:service', async (req, res) => {
             const service = req.params.service;
-            const apiConfig = this.apis.get(service);
+            const apiConfig = this.tenantTools(req.tenant).get(service) || this.apis.get(service);
             
             if (!apiConfig) {
                 return res.status(404).json({ error: 'Service not found' });
             }
+            if (!this.withinQuota(req.tenant)) {
+                return res.status(429).json({ error: 'Tenant ' + req.tenant + ' is over its quota of ' + this.quotaOf(req.tenant) + ' calls a minute' });
+            }
             
+            const started = Date.now();
             try {
-                const response = await axios.post(apiConfig.endpoint, req.body);
+                const response = await axios.post(apiConfig.endpoint, req.body, { headers: tracing.headers({ [rbac.TENANT_HEADER]: req.tenant }) });
                 res.json(response.data);
             } catch (error) {
                 res.status(500).json({ error: error.message });
             }
+            this.invoked(service, req.tenant, res.statusCode, Date.now() - started);
+        });
+
+        // Session memory, proxied to the session memory service. While it
+        // keeps failing, requests are answered 503 at once, with when to try
+        // again.
+        this.app.use('/memory', async (req, res) => {
+            if (!this.memoryUrl) {
+                return res.status(503).json({ error: 'Session memory is not configured' });
+            }
+            if (!this.memoryBreaker.allow()) {
+                res.set('Retry-After', String(this.memoryBreaker.retryAfter()));
+                return res.status(503).json({ error: 'Session memory is unavailable' });
+            }
+
+            try {
+                // The caller's own token goes on, so session memory
+                // enforces the same role, with the tenant it resolved to
+                const authorization = req.get('authorization');
+                const headers = { [rbac.TENANT_HEADER]: req.tenant };
+                if (authorization) {
+                    headers.Authorization = authorization;
+                }
+                const response = await axios({
+                    method: req.method,
+                    url: this.memoryUrl + '/v1' + req.url,
+                    headers: tracing.headers(headers),
+                    data: ['GET', 'HEAD'].includes(req.method) ? undefined : req.body,
+                    timeout: 10000,
+                    validateStatus: () => true
+                });
+                if (response.status >= 500) {
+                    this.memoryBreaker.failed();
+                } else {
+                    this.memoryBreaker.succeeded();
+                }
+                res.status(response.status).json(response.data);
+            } catch (error) {
+                this.memoryBreaker.failed();
+                res.status(502).json({ error: error.message });
+            }
+        });
+
+        // Finished jobs and fan-outs from the agent orchestrator. A fan-out
+        // that partly failed still stores the results it did gather. The
+        // orchestrator validates results itself; this catches agents that
+        // submit their own. A job that names its tenant is stored for it.
+        this.app.post('/agents/results', async (req, res) => {
+            const job = req.body || {};
+            if (!job.id || !job.status) {
+                return res.status(400).json({ error: 'Job id and status are required' });
+            }
+            let tenant;
+            try {
+                tenant = rbac.resolveTenant(job.tenant || req.tenant, req.claims);
+            } catch (error) {
+                return res.status(error.status).json({ error: error.message });
+            }
+            if (!job.kind && job.result && this.rejected(res, 'result', job.result, { stream_id: job.id, session_id: job.session_id, agent_type: job.agent_type, target: job.target, tenant })) {
+                return;
+            }
+
+            this.log.info('agent ' + (job.kind || 'job') + ' ' + job.status, { job_id: job.id, tenant, session_id: job.session_id });
+            // The final result replaces what the job streamed, once that is stored
+            const stream = this.streams.get(tenant + '/' + job.id);
+            if (stream) {
+                stream.done = true;
+                await stream.saving;
+                stream.context = null;
+            }
+            const update = { session_id: job.session_id, context: job.result, job };
+            this.io.to(this.room(tenant)).emit('context_broadcast', update);
+            // A result session memory could not take is refused, so the
+            // orchestrator reports it again rather than it being lost
+            if (['succeeded', 'partial'].includes(job.status) && !(await this.rememberContext(update, tenant))) {
+                return res.status(502).json({ error: 'Could not store the result in session memory', id: job.id });
+            }
+            res.json({ message: 'Result received', id: job.id });
+        });
+
+        // The same events as the agent_item and agent_done socket messages,
+        // for agents that stream over HTTP, such as those built on the SDK
+        this.app.post('/agents/items', (req, res) => {
+            const data = req.body || {};
+            if (!data.stream_id || !data.field || !Array.isArray(data.items)) {
+                return res.status(400).json({ error: 'stream_id, field and an items array are required' });
+            }
+            if (this.rejected(res, 'item_batch', data, data, req.tenant)) {
+                return;
+            }
+            this.io.to(this.room(req.tenant)).emit('agent_item', data);
+            this.streamItems(data, req.tenant);
+            res.status(202).json({ message: 'Items received', stream_id: data.stream_id, seq: data.seq });
+        });
+
+        this.app.post('/agents/done', (req, res) => {
+            const data = req.body || {};
+            if (!data.stream_id || !data.status) {
+                return res.status(400).json({ error: 'stream_id and status are required' });
+            }
+            if (this.rejected(res, 'stream_done', data, data, req.tenant)) {
+                return;
+            }
+            this.io.to(this.room(req.tenant)).emit('agent_done', data);
+            this.finishStream(data, req.tenant);
+            res.json({ message: 'Stream finished', stream_id: data.stream_id });
+        });
+
+        // Context streamed by running agents for the tenant
+        this.app.get('/agents/streams', (req, res) => {
+            const streams = [...this.streams.values()].filter((stream) => stream.tenant === req.tenant).map((stream) => ({
+                stream_id: stream.stream_id,
+                session_id: stream.session_id,
+                agent_type: stream.agent_type,
+                target: stream.target,
+                items: stream.items,
+                batches: stream.batches,
+                status: stream.status
+            }));
+            res.json({ streams });
+        });
+
+        // The tenant's agent output that did not match the schema, newest first
+        this.app.get('/agents/quarantine', (req, res) => {
+            res.json({ quarantined: this.quarantine.filter((entry) => entry.tenant === req.tenant) });
         });
     }
 
+    tenantTools(tenant) {
+        if (!this.tools.has(tenant)) {
+            this.tools.set(tenant, new Map());
+        }
+        return this.tools.get(tenant);
+    }
+
+    // The Socket.IO room of a tenant's clients, which get only its events
+    room(tenant) {
+        return 'tenant:' + tenant;
+    }
+
+    // Tool calls a minute each tenant may make, from MCP_TENANT_QUOTAS:
+    // {"default": {"calls_per_minute": 600}, "tenants": {"team-a": {...}}}.
+    // Without it, or with a null limit, calls are unlimited.
+    loadQuotas(json) {
+        if (!json) {
+            return { default: {}, tenants: {} };
+        }
+        const quotas = JSON.parse(json);
+        for (const limits of [quotas.default || {}, ...Object.values(quotas.tenants || {})]) {
+            const limit = limits.calls_per_minute;
+            if (limit !== undefined && limit !== null && !(Number.isInteger(limit) && limit > 0)) {
+                throw new Error('MCP_TENANT_QUOTAS: calls_per_minute must be a positive integer or null');
+            }
+        }
+        this.log.info('tenant quotas ' + json);
+        return { default: quotas.default || {}, tenants: quotas.tenants || {} };
+    }
+
+    quotaOf(tenant) {
+        const limits = { ...this.quotas.default, ...(this.quotas.tenants[tenant] || {}) };
+        return limits.calls_per_minute || null;
+    }
+
+    // Publishes a tool invocation on the event bus
+    invoked(tool, tenant, status, durationMs) {
+        if (!this.bus) {
+            return;
+        }
+        try {
+            this.bus.publish(eventBus.SUBJECT_TOOLS, { tool, tenant, status, duration_ms: durationMs });
+        } catch (error) {
+            this.log.warn('publishing a tool invocation to the event bus', { tool, error: error.message });
+        }
+    }
+
+    // Counts a tool call against the tenant's quota for the current minute
+    withinQuota(tenant) {
+        const limit = this.quotaOf(tenant);
+        if (!limit) {
+            return true;
+        }
+        const minute = Math.floor(Date.now() / 60000);
+        const calls = this.calls.get(tenant);
+        if (!calls || calls.minute !== minute) {
+            this.calls.set(tenant, { minute, count: 1 });
+            return true;
+        }
+        if (calls.count >= limit) {
+            return false;
+        }
+        calls.count += 1;
+        return true;
+    }
+
+    // A bearer's role, and subject when it has one, for logs
+    describe(claims) {
+        return claims.sub ? claims.role + ' (' + claims.sub + ')' : claims.role;
+    }
+
+    // Whether a socket's bearer may write, as agents streaming context do.
+    // A refused event goes back to its sender as agent_refused.
+    mayWrite(socket, event) {
+        if (!this.authorizer || this.authorizer.policy.allows(socket.data.claims.role, 'write')) {
+            return true;
+        }
+        this.log.warn('refused ' + event + ' to ' + this.describe(socket.data.claims), { tenant: socket.data.tenant });
+        socket.emit('agent_refused', { event, error: 'role ' + socket.data.claims.role + ' may not write here' });
+        return false;
+    }
+
     setupSocketHandlers() {
+        // Connecting takes a token that may read, from the handshake's auth
+        // or its Authorization header, and is for the tenant in its auth or
+        // X-Tenant-ID header. The socket's events are traced under the
+        // traceparent in its auth or headers, such as an agent's run.
+        this.io.use((socket, next) => {
+            const handshake = socket.handshake;
+            const auth = handshake.auth || {};
+            const authorization = auth.token ? 'Bearer ' + auth.token : handshake.headers.authorization;
+            socket.data.trace = tracing.parse(auth.traceparent || handshake.headers[tracing.HEADER]);
+            try {
+                socket.data.claims = this.authorizer ? this.authorizer.authorize('mcp', 'GET', '/socket.io', authorization) : {};
+                socket.data.tenant = rbac.resolveTenant(auth.tenant || handshake.headers[rbac.TENANT_HEADER.toLowerCase()], socket.data.claims);
+                next();
+            } catch (error) {
+                next(error);
+            }
+        });
+
         this.io.on('connection', (socket) => {
-            console.log('🔗 Client connected to MCP Server');
+            this.log.info('client connected', { tenant: socket.data.tenant });
+            const tenant = socket.data.tenant;
+            socket.join(this.room(tenant));
             
-            socket.on('context_update', (data) => {
-                console.log('📊 Received context update:', data);
-                socket.broadcast.emit('context_broadcast', data);
+            this.onTraced(socket, 'context_update', (data) => {
+                if (!this.mayWrite(socket, 'context_update')) {
+                    return;
+                }
+                this.log.debug('context update', { tenant, session_id: data && data.session_id });
+                socket.to(this.room(tenant)).emit('context_broadcast', data);
+                this.rememberContext(data, tenant);
+            });
+
+            // Invalid events go back to their sender as agent_rejected
+            this.onTraced(socket, 'agent_item', (data) => {
+                if (!this.mayWrite(socket, 'agent_item')) {
+                    return;
+                }
+                const violations = this.check('item_batch', data);
+                if (violations.length > 0) {
+                    return socket.emit('agent_rejected', this.quarantined('item_batch', data, violations, data, tenant));
+                }
+                socket.to(this.room(tenant)).emit('agent_item', data);
+                this.streamItems(data, tenant);
+            });
+
+            this.onTraced(socket, 'agent_done', (data) => {
+                if (!this.mayWrite(socket, 'agent_done')) {
+                    return;
+                }
+                const violations = this.check('stream_done', data);
+                if (violations.length > 0) {
+                    return socket.emit('agent_rejected', this.quarantined('stream_done', data, violations, data, tenant));
+                }
+                socket.to(this.room(tenant)).emit('agent_done', data);
+                this.finishStream(data, tenant);
             });
             
             socket.on('disconnect', () => {
-                console.log('🔌 Client disconnected');
+                this.log.info('client disconnected', { tenant });
             });
         });
     }
 
-    start() {
-        this.server.listen(this.port, () => {
-            console.log('✅ MCP Server running on port', this.port);
+    // Handles a socket event in a span of its own
+    onTraced(socket, event, handler) {
+        socket.on(event, (data) => {
+            this.tracer.trace(event + ' receive', tracing.KIND.CONSUMER, socket.data.trace, () => handler(data))
+                .catch((error) => this.log.error('handling ' + event + ' failed', { tenant: socket.data.tenant, error: error.message }));
         });
     }
-}
 
-const server = new MCPServer();
-server.start();
-`,
-		}).
-		WithExposedPort(3000).
-		WithEntrypoint([]string{"node", "/app/mcp_server.js"})
-}
+    // The agent output schema the orchestrator validates results against,
+    // compiled once per definition. Without the file nothing is validated.
+    loadSchema(path) {
+        try {
+            const schema = JSON.parse(fs.readFileSync(path, 'utf8'));
+            const ajv = new Ajv({ allErrors: true, allowUnionTypes: true });
+            ajv.addSchema(schema);
+            const validators = {};
+            for (const definition of Object.keys(schema.definitions)) {
+                validators[definition] = ajv.compile({ $ref: schema.$id + '#/definitions/' + definition });
+            }
+            this.log.info('validating agent output against ' + path);
+            return validators;
+        } catch (error) {
+            this.log.warn('not validating agent output', { error: error.message });
+            return {};
+        }
+    }
 
-// Knowledge Graph Container - Graffiti integration for semantic organization
-func buildKnowledgeGraphContainer(ctx context.Context, client *dagger.Client) *dagger.Container {
-	fmt.Println("🕸️ Building Knowledge Graph Container...")
-	
-	return client.Container().
-		From("python:3.11-slim").
-		WithWorkdir("/app").
-		WithExec([]string{"pip", "install", "networkx", "neo4j", "sentence-transformers"}).
-		WithNewFile("/app/knowledge_graph.py", dagger.ContainerWithNewFileOpts{
-			Contents: `#!/usr/bin/env python3
-import json
-import networkx as nx
-from datetime import datetime
-import hashlib
-
-class KnowledgeGraph:
-    def __init__(self):
-        self.graph = nx.DiGraph()
-        self.embeddings = {}
-        
-    def add_context_node(self, context_data):
-        """Add context as a node in the knowledge graph"""
-        node_id = self.generate_node_id(context_data)
-        
-        self.graph.add_node(node_id, 
-                           data=context_data,
-                           timestamp=datetime.now().isoformat(),
-                           node_type="context")
-        
-        # Create semantic relationships
-        self.create_semantic_relationships(node_id, context_data)
-        
-        return node_id
-    
-    def generate_node_id(self, data):
-        """Generate unique node ID from data"""
-        content = json.dumps(data, sort_keys=True)
-        return hashlib.md5(content.encode()).hexdigest()[:12]
-    
-    def create_semantic_relationships(self, node_id, context_data):
-        """Create relationships based on semantic similarity"""
-        # Simplified semantic relationship creation
-        keywords = self.extract_keywords(context_data)
-        
-        for existing_node in self.graph.nodes():
-            if existing_node != node_id:
-                existing_data = self.graph.nodes[existing_node].get('data', {})
-                existing_keywords = self.extract_keywords(existing_data)
-                
-                similarity = self.calculate_similarity(keywords, existing_keywords)
-                if similarity > 0.3:  # Threshold for relationship
-                    self.graph.add_edge(node_id, existing_node, 
-                                      weight=similarity, 
-                                      relationship_type="semantic_similarity")
-    
-    def extract_keywords(self, data):
-        """Extract keywords from context data"""
-        text = json.dumps(data).lower()
-        # Simple keyword extraction (would use proper NLP in production)
-        words = text.split()
-        return set(word.strip('{}",.:') for word in words if len(word) > 3)
-    
-    def calculate_similarity(self, keywords1, keywords2):
-        """Calculate similarity between keyword sets"""
-        intersection = keywords1.intersection(keywords2)
-        union = keywords1.union(keywords2)
-        return len(intersection) / len(union) if union else 0
-    
-    def search_semantic(self, query):
-        """Semantic search through the knowledge graph"""
-        query_keywords = set(query.lower().split())
-        results = []
-        
-        for node_id in self.graph.nodes():
-            node_data = self.graph.nodes[node_id].get('data', {})
-            node_keywords = self.extract_keywords(node_data)
-            
-            similarity = self.calculate_similarity(query_keywords, node_keywords)
-            if similarity > 0.1:
-                results.append({
-                    'node_id': node_id,
-                    'similarity': similarity,
-                    'data': node_data
-                })
-        
-        return sorted(results, key=lambda x: x['similarity'], reverse=True)
-    
-    def get_graph_stats(self):
-        """Get knowledge graph statistics"""
+    // The ways data breaks a schema definition, as { path, message }
+    check(definition, data) {
+        const validate = this.validators[definition];
+        if (!validate || validate(data)) {
+            return [];
+        }
+        return validate.errors.slice(0, 50).map((error) => ({ path: error.instancePath, message: error.message }));
+    }
+
+    // Keeps invalid output aside, the last 100 payloads, and describes it
+    quarantined(definition, data, violations, ids = data || {}, tenant = ids.tenant || rbac.DEFAULT_TENANT) {
+        const entry = {
+            definition,
+            tenant,
+            stream_id: ids.stream_id,
+            session_id: ids.session_id,
+            agent_type: ids.agent_type,
+            target: ids.target,
+            violations,
+            payload: data,
+            quarantined_at: new Date().toISOString()
+        };
+        this.quarantine.unshift(entry);
+        this.quarantine.length = Math.min(this.quarantine.length, 100);
+        this.log.warn('quarantined ' + definition, { tenant, session_id: entry.session_id, stream_id: entry.stream_id,
+            agent_type: entry.agent_type, violations: violations.length });
         return {
-            'nodes': self.graph.number_of_nodes(),
-            'edges': self.graph.number_of_edges(),
-            'density': nx.density(self.graph),
-            'components': nx.number_weakly_connected_components(self.graph)
+            error: 'Agent ' + definition.replace('_', ' ') + ' does not match the agent output schema',
+            stream_id: entry.stream_id,
+            violations
+        };
+    }
+
+    // Answers 422 and quarantines data when it breaks the definition
+    rejected(res, definition, data, ids, tenant) {
+        const violations = this.check(definition, data);
+        if (violations.length === 0) {
+            return false;
+        }
+        res.status(422).json(this.quarantined(definition, data, violations, ids, tenant));
+        return true;
+    }
+
+    // Streamed items are added to the stream's context, which is stored in
+    // session memory after each batch until the job's final result arrives.
+    // Only the last 100 streams are tracked. Streams are kept apart by
+    // tenant, so one tenant cannot add to another's.
+    streamItems(data, tenant) {
+        if (!data || !data.stream_id || !data.field || !Array.isArray(data.items)) {
+            return;
         }
 
-if __name__ == "__main__":
-    kg = KnowledgeGraph()
-    
-    # Add sample context
-    sample_context = {
-        "type": "code_analysis",
-        "content": "Dynamic context collection system with MCP integration",
-        "tags": ["dagger", "mcp", "containerization", "automation"]
+        const key = tenant + '/' + data.stream_id;
+        let stream = this.streams.get(key);
+        if (!stream) {
+            stream = {
+                stream_id: data.stream_id,
+                tenant,
+                session_id: data.session_id,
+                agent_type: data.agent_type,
+                target: data.target,
+                context: { agent_type: data.agent_type, target: data.target, streaming: true },
+                items: 0,
+                batches: 0,
+                status: 'streaming',
+                done: false,
+                saving: Promise.resolve()
+            };
+            this.streams.set(key, stream);
+            if (this.streams.size > 100) {
+                this.streams.delete(this.streams.keys().next().value);
+            }
+        }
+        if (stream.done) {
+            return;
+        }
+        // A retried job streams again from its first batch
+        if (data.seq === 1 && stream.batches > 0) {
+            stream.context = { agent_type: data.agent_type, target: data.target, streaming: true };
+            stream.items = 0;
+            stream.batches = 0;
+            stream.status = 'streaming';
+        }
+
+        stream.context[data.field] = (stream.context[data.field] || []).concat(data.items);
+        stream.items += data.items.length;
+        stream.batches += 1;
+        if (stream.session_id) {
+            stream.saving = stream.saving.then(() => stream.done ? null :
+                this.rememberContext({ session_id: stream.session_id, context: stream.context }, tenant));
+        }
     }
-    
-    node_id = kg.add_context_node(sample_context)
-    print(f"✅ Added context node: {node_id}")
-    print("📊 Graph stats:", json.dumps(kg.get_graph_stats(), indent=2))
-`,
-			Permissions: 0755,
-		}).
-		WithEntrypoint([]string{"python3", "/app/knowledge_graph.py"})
-}
 
-// Session Memory Container - Persistent context with LLM summarization
-func buildSessionMemoryContainer(ctx context.Context, client *dagger.Client) *dagger.Container {
-	fmt.Println("🧠 Building Session Memory Container...")
-	
-	return client.Container().
-		From("redis:7-alpine").
-		WithWorkdir("/app").
-		WithNewFile("/app/memory_manager.py", dagger.ContainerWithNewFileOpts{
-			Contents: `#!/usr/bin/env python3
-import json
-import redis
-from datetime import datetime, timedelta
-import hashlib
-
-class SessionMemoryManager:
-    def __init__(self, redis_host='localhost', redis_port=6379):
-        self.redis_client = redis.Redis(host=redis_host, port=redis_port, decode_responses=True)
-        self.session_prefix = "session:"
-        self.memory_prefix = "memory:"
-        
-    def store_session_context(self, session_id, context_data):
-        """Store context for a session"""
-        key = f"{self.session_prefix}{session_id}"
-        
-        # Add timestamp
-        context_data['stored_at'] = datetime.now().isoformat()
-        
-        # Store with expiration (24 hours)
-        self.redis_client.setex(key, 86400, json.dumps(context_data))
-        
-        # Add to session index
-        self.redis_client.sadd("active_sessions", session_id)
-        
-        return True
-    
-    def get_session_context(self, session_id):
-        """Retrieve session context"""
-        key = f"{self.session_prefix}{session_id}"
-        data = self.redis_client.get(key)
-        
-        if data:
-            return json.loads(data)
-        return None
-    
-    def store_hot_memory(self, memory_key, data, ttl=3600):
-        """Store frequently accessed data in hot memory"""
-        key = f"{self.memory_prefix}{memory_key}"
-        self.redis_client.setex(key, ttl, json.dumps(data))
-        
-    def get_hot_memory(self, memory_key):
-        """Retrieve from hot memory"""
-        key = f"{self.memory_prefix}{memory_key}"
-        data = self.redis_client.get(key)
-        
-        if data:
-            return json.loads(data)
-        return None
-    
-    def summarize_session(self, session_id):
-        """Create LLM-ready summary of session"""
-        context = self.get_session_context(session_id)
-        if not context:
-            return None
-            
-        # Simplified summarization (would integrate with LLM API)
-        summary = {
-            'session_id': session_id,
-            'summary_created': datetime.now().isoformat(),
-            'key_points': self.extract_key_points(context),
-            'context_size': len(json.dumps(context)),
-            'last_activity': context.get('stored_at')
+    finishStream(data, tenant) {
+        const stream = data && this.streams.get(tenant + '/' + data.stream_id);
+        if (stream) {
+            stream.status = data.status;
+            this.log.info('agent stream ' + data.status, { tenant, session_id: stream.session_id, stream_id: data.stream_id, items: stream.items });
         }
-        
-        # Store summary for future reference
-        summary_key = f"summary:{session_id}"
-        self.redis_client.setex(summary_key, 604800, json.dumps(summary))  # 7 days
-        
-        return summary
-    
-    def extract_key_points(self, context):
-        """Extract key points from context (simplified)"""
-        # In production, this would use LLM for intelligent summarization
-        key_points = []
-        
-        if 'tools_used' in context:
-            key_points.append(f"Used tools: {', '.join(context['tools_used'])}")
-        
-        if 'apis_accessed' in context:
-            key_points.append(f"Accessed APIs: {', '.join(context['apis_accessed'])}")
-            
-        if 'context_updates' in context:
-            key_points.append(f"Context updates: {len(context['context_updates'])}")
-            
-        return key_points
-    
-    def get_memory_stats(self):
-        """Get memory system statistics"""
-        active_sessions = self.redis_client.scard("active_sessions")
-        total_keys = len(self.redis_client.keys("*"))
-        
-        return {
-            'active_sessions': active_sessions,
-            'total_keys': total_keys,
-            'memory_usage': self.redis_client.info('memory'),
-            'timestamp': datetime.now().isoformat()
+    }
+
+    // Context updates that name a session are kept in session memory, in
+    // the tenant's sessions. Session memory is tried three times, a second
+    // and then two apart, unless its circuit is open; this reports false
+    // when it could not be reached, so the context can be sent again.
+    async rememberContext(data, tenant) {
+        if (!this.memoryUrl || !data || !data.session_id) {
+            return true;
         }
 
-if __name__ == "__main__":
-    # Test session memory (would connect to Redis in production)
-    print("✅ Session Memory Manager initialized")
-    print("🧠 Ready for context storage and retrieval")
+        let error;
+        for (let attempt = 0; attempt < 3; attempt++) {
+            if (attempt > 0) {
+                await new Promise(resolve => setTimeout(resolve, 1000 * attempt));
+            }
+            if (!this.memoryBreaker.allow()) {
+                error = new Error('session memory is unavailable');
+                continue;
+            }
+            try {
+                await axios.put(this.memoryUrl + '/v1/sessions/' + encodeURIComponent(data.session_id), data.context || {},
+                    { headers: tracing.headers(rbac.headers(tenant)), timeout: 10000 });
+                this.memoryBreaker.succeeded();
+                return true;
+            } catch (e) {
+                // Session memory refusing the context is not for trying again
+                if (e.response && e.response.status < 500) {
+                    this.memoryBreaker.succeeded();
+                    this.log.warn('session memory refused context', { tenant, session_id: data.session_id, error: e.message });
+                    return true;
+                }
+                this.memoryBreaker.failed();
+                error = e;
+            }
+        }
+        this.log.warn('could not store context in session memory', { tenant, session_id: data.session_id, error: error.message });
+        return false;
+    }
+
+    start() {
+        this.server.listen(this.port, () => {
+            this.log.info('MCP server running on port ' + this.port + (this.authorizer ? ' with access control' : '') +
+                ' (' + this.tracer + ', ' + this.log + ')');
+        });
+    }
+}
+
+const server = new MCPServer();
+server.start();
 `,
-			Permissions: 0755,
 		}).
-		WithEntrypoint([]string{"python3", "/app/memory_manager.py"})
+		WithExposedPort(3000).
+		WithEntrypoint([]string{"node", "/app/mcp_server.js"})
 }
 
 // Test functions for each component
 func testMicroAgent(ctx context.Context, container *dagger.Container) error {
 	fmt.Println("🧪 Testing Micro Agent...")
-	
+
 	output, err := container.
 		WithExec([]string{"test_context"}).
 		Stdout(ctx)
 	if err != nil {
 		return err
 	}
-	
+
 	fmt.Printf("Micro Agent Output:\n%s\n", output)
 	return nil
 }
 
 func testMCPServer(ctx context.Context, container *dagger.Container) error {
 	fmt.Println("🧪 Testing MCP Server...")
-	
+
 	// Start server in background and test
 	_, err := container.
 		WithExec([]string{"timeout", "5", "node", "/app/mcp_server.js"}).
@@ -474,34 +1092,6 @@ func testMCPServer(ctx context.Context, container *dagger.Container) error {
 		// Timeout is expected, server starts successfully
 		fmt.Println("✅ MCP Server started successfully")
 	}
-	
-	return nil
-}
 
-func testKnowledgeGraph(ctx context.Context, container *dagger.Container) error {
-	fmt.Println("🧪 Testing Knowledge Graph...")
-	
-	output, err := container.
-		WithExec([]string{"python3", "/app/knowledge_graph.py"}).
-		Stdout(ctx)
-	if err != nil {
-		return err
-	}
-	
-	fmt.Printf("Knowledge Graph Output:\n%s\n", output)
-	return nil
-}
-
-func testSessionMemory(ctx context.Context, container *dagger.Container) error {
-	fmt.Println("🧪 Testing Session Memory...")
-	
-	output, err := container.
-		WithExec([]string{"python3", "/app/memory_manager.py"}).
-		Stdout(ctx)
-	if err != nil {
-		return err
-	}
-	
-	fmt.Printf("Session Memory Output:\n%s\n", output)
 	return nil
 }
@@ -0,0 +1,2556 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// microAgentVariant describes an agent container that bakes in one agent
+// type and only that type's dependencies. setup runs after they are
+// installed, as for a browser download.
+type microAgentVariant struct {
+	agentType string
+	module    string
+	apt       []string
+	pip       []string
+	setup     []string
+}
+
+var builtinAgentTypes = []microAgentVariant{
+	{agentType: "web_scraper", module: webScraperAgentPy, pip: []string{"requests", "beautifulsoup4"}},
+	{agentType: "git_analyzer", module: gitAnalyzerAgentPy, apt: []string{"git"}},
+	{agentType: "filesystem_crawler", module: filesystemCrawlerAgentPy},
+	{agentType: "rest_poller", module: restPollerAgentPy, pip: []string{"requests"}},
+	{agentType: "github_repo", module: githubRepoAgentPy, apt: []string{"git"}, pip: []string{"requests"}},
+	{agentType: "docs_crawler", module: docsCrawlerAgentPy, pip: []string{"requests", "beautifulsoup4"}},
+	{agentType: "db_introspector", module: dbIntrospectorAgentPy, pip: []string{"psycopg[binary]", "PyMySQL"}},
+	{agentType: "chat_ingester", module: chatIngesterAgentPy, pip: []string{"requests"}},
+	{agentType: "issue_tracker", module: issueTrackerAgentPy, pip: []string{"requests"}},
+	{agentType: "headless_browser", module: headlessBrowserAgentPy, pip: []string{"playwright"},
+		setup: []string{"playwright", "install", "--with-deps", "chromium"}},
+}
+
+// microAgentBase is the agent runner every micro agent container starts
+// from, with the Socket.IO client it streams context over.
+func microAgentBase(client *dagger.Client) *dagger.Container {
+	return client.Container().
+		From("python:3.11-slim").
+		WithWorkdir("/app").
+		WithExec([]string{"pip", "install", "python-socketio[client]"}).
+		WithNewFile("/app/event_bus.py", dagger.ContainerWithNewFileOpts{Contents: eventBusPy}).
+		WithNewFile("/app/rbac.py", dagger.ContainerWithNewFileOpts{Contents: rbacPy}).
+		WithNewFile("/app/tracing.py", dagger.ContainerWithNewFileOpts{Contents: tracingPy}).
+		WithNewFile("/app/micro_agent.py", dagger.ContainerWithNewFileOpts{
+			Contents:    microAgentPy,
+			Permissions: 0755,
+		})
+}
+
+func withMicroAgentVariant(container *dagger.Container, variant microAgentVariant) *dagger.Container {
+	if len(variant.apt) > 0 {
+		container = container.WithExec([]string{"sh", "-c", "apt-get update && apt-get install -y --no-install-recommends " +
+			strings.Join(variant.apt, " ") + " && rm -rf /var/lib/apt/lists/*"})
+	}
+	if len(variant.pip) > 0 {
+		container = container.WithExec(append([]string{"pip", "install"}, variant.pip...))
+	}
+	if len(variant.setup) > 0 {
+		container = container.WithExec(variant.setup)
+	}
+	return container.WithNewFile("/app/agents/"+variant.agentType+".py", dagger.ContainerWithNewFileOpts{
+		Contents:    variant.module,
+		Permissions: 0644,
+	})
+}
+
+// Micro Agent Container - Auto-deploys context gathering agents, with every
+// agent type installed
+func buildMicroAgentContainer(ctx context.Context, client *dagger.Client) *dagger.Container {
+	fmt.Println("🤖 Building Micro Agent Container...")
+
+	container := microAgentBase(client)
+	for _, variant := range builtinAgentTypes {
+		container = withMicroAgentVariant(container, variant)
+	}
+	return container.WithEntrypoint([]string{"python3", "/app/micro_agent.py"})
+}
+
+// buildMicroAgentVariants builds one slim container per agent type, as the
+// orchestrator's container runtime launches them.
+func buildMicroAgentVariants(ctx context.Context, client *dagger.Client) map[string]*dagger.Container {
+	fmt.Println("🧰 Building Micro Agent Variants...")
+
+	variants := make(map[string]*dagger.Container, len(builtinAgentTypes))
+	for _, variant := range builtinAgentTypes {
+		variants[variant.agentType] = withMicroAgentVariant(microAgentBase(client), variant).
+			WithEnvVariable("AGENT_TYPE", variant.agentType).
+			WithEntrypoint([]string{"python3", "/app/micro_agent.py"})
+	}
+	return variants
+}
+
+// agentResult is the JSON an agent prints after its progress lines.
+func agentResult(output string) (map[string]any, error) {
+	start := strings.Index(output, "\n{")
+	if start < 0 {
+		return nil, fmt.Errorf("no JSON result in agent output %q", output)
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(output[start+1:]), &result); err != nil {
+		return nil, fmt.Errorf("unexpected agent output %q: %w", output, err)
+	}
+	return result, nil
+}
+
+// testMicroAgentVariants runs each agent type in its own variant against a
+// target inside the pipeline: a fixture web site, with a page that needs
+// JavaScript and a login, a git repository made on the spot, a bare copy of
+// it standing in for GitHub, a small documentation site, a Postgres
+// database, Slack's API as static files and the agent's own /app directory.
+func testMicroAgentVariants(ctx context.Context, client *dagger.Client, variants map[string]*dagger.Container) error {
+	fmt.Println("🧪 Testing Micro Agent Variants...")
+
+	site := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/index.html", dagger.ContainerWithNewFileOpts{Contents: agentFixturePage}).
+		WithNewFile("/srv/app.html", dagger.ContainerWithNewFileOpts{Contents: agentFixtureApp}).
+		WithNewFile("/srv/login.html", dagger.ContainerWithNewFileOpts{Contents: agentFixtureLogin}).
+		WithNewFile("/srv/status.json", dagger.ContainerWithNewFileOpts{Contents: `{"status": "ok", "version": "1.2.3"}`}).
+		WithNewFile("/srv/api/repos/fixture/repo/pulls", dagger.ContainerWithNewFileOpts{Contents: agentFixturePulls}).
+		WithNewFile("/srv/api/repos/fixture/repo/issues", dagger.ContainerWithNewFileOpts{Contents: agentFixtureIssues}).
+		WithNewFile("/srv/robots.txt", dagger.ContainerWithNewFileOpts{Contents: "User-agent: *\nDisallow: /docs/private/\n"}).
+		WithNewFile("/srv/docs/index.html", dagger.ContainerWithNewFileOpts{Contents: agentFixtureDocsIndex}).
+		WithNewFile("/srv/docs/guide.html", dagger.ContainerWithNewFileOpts{Contents: agentFixtureDocsGuide}).
+		WithNewFile("/srv/docs/copy.html", dagger.ContainerWithNewFileOpts{Contents: agentFixtureDocsGuide}).
+		WithNewFile("/srv/docs/private/notes.html", dagger.ContainerWithNewFileOpts{Contents: agentFixtureDocsGuide}).
+		WithNewFile("/srv/slack/conversations.info", dagger.ContainerWithNewFileOpts{
+			Contents: `{"ok": true, "channel": {"id": "C0FIXTURE", "name": "fixture"}}`,
+		}).
+		WithNewFile("/srv/slack/users.info", dagger.ContainerWithNewFileOpts{
+			Contents: `{"ok": true, "user": {"id": "U1", "name": "fixture", "real_name": "Fixture User"}}`,
+		}).
+		WithNewFile("/srv/slack/conversations.history", dagger.ContainerWithNewFileOpts{Contents: agentFixtureSlackHistory}).
+		WithNewFile("/srv/slack/conversations.replies", dagger.ContainerWithNewFileOpts{Contents: agentFixtureSlackReplies}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "-m", "http.server", "8000", "--directory", "/srv"}).
+		AsService()
+
+	// The db_introspector agent reads the fixture schema as a read-only role,
+	// with its password mounted as a secret
+	database := client.Container().
+		From("postgres:16-alpine").
+		WithEnvVariable("POSTGRES_PASSWORD", "fixture-admin").
+		WithEnvVariable("POSTGRES_DB", "fixture").
+		WithNewFile("/docker-entrypoint-initdb.d/fixture.sql", dagger.ContainerWithNewFileOpts{Contents: agentFixtureSchema}).
+		WithExposedPort(5432).
+		AsService()
+	readerPassword := client.SetSecret("agent-db-password", "fixture-reader")
+	slackToken := client.SetSecret("agent-slack-token", "xoxb-fixture")
+
+	repo := "git init -q /tmp/repo && cd /tmp/repo && echo '# Fixture' > README.md && echo 'print(1)' > main.py && " +
+		"git add . && git -c user.name=Fixture -c user.email=fixture@example.com commit -qm 'Add fixture files'"
+	// The github_repo agent clones from a bare copy standing in for github.com
+	githubRepo := repo + " && echo '{\"name\": \"fixture\", \"dependencies\": {\"express\": \"^4\"}}' > package.json && " +
+		"git add . && git -c user.name=Fixture -c user.email=fixture@example.com commit -qm 'Add package.json' && " +
+		"git clone -q --bare /tmp/repo /tmp/github/fixture/repo.git"
+
+	checks := []struct {
+		agentType string
+		setup     string
+		target    string
+		check     func(gathered map[string]any) bool
+	}{
+		{"web_scraper", "", "http://site:8000/index.html", func(c map[string]any) bool { return c["title"] == "Fixture Page" }},
+		// The app page is empty without JavaScript and a login
+		{"headless_browser", "", "http://site:8000/app.html", func(c map[string]any) bool {
+			pages, _ := c["pages"].([]any)
+			if c["title"] != "Fixture App" || c["logged_in"] != true || len(pages) != 1 {
+				return false
+			}
+			page, _ := pages[0].(map[string]any)
+			headings, _ := page["headings"].([]any)
+			evidence, _ := page["evidence"].(map[string]any)
+			data, _ := evidence["data"].(string)
+			if len(headings) != 1 || evidence["content_type"] != "image/jpeg" || data == "" {
+				return false
+			}
+			heading, _ := headings[0].(map[string]any)
+			return heading["text"] == "Welcome fixture"
+		}},
+		{"rest_poller", "", "http://site:8000/status.json", func(c map[string]any) bool {
+			body, _ := c["body"].(map[string]any)
+			return body["version"] == "1.2.3"
+		}},
+		{"git_analyzer", repo, "/tmp/repo", func(c map[string]any) bool { return c["commit_count"] == float64(1) }},
+		{"filesystem_crawler", "", "/app", func(c map[string]any) bool {
+			files, _ := c["file_count"].(float64)
+			return files >= 2
+		}},
+		{"github_repo", githubRepo, "https://github.com/fixture/repo", func(c map[string]any) bool {
+			manifests, _ := c["manifests"].([]any)
+			pulls, _ := c["pull_requests"].([]any)
+			nodes, _ := c["nodes"].([]any)
+			if len(manifests) != 1 || len(pulls) != 1 || len(nodes) < 5 {
+				return false
+			}
+			pull, _ := pulls[0].(map[string]any)
+			return pull["title"] == "Add fixture feature"
+		}},
+		// copy.html repeats guide.html and robots.txt keeps the crawl out of private/
+		{"docs_crawler", "", "http://site:8000/docs/index.html", func(c map[string]any) bool {
+			duplicates, _ := c["duplicates"].([]any)
+			skipped, _ := c["skipped"].([]any)
+			chunks, _ := c["chunk_count"].(float64)
+			return c["page_count"] == float64(2) && len(duplicates) == 1 && len(skipped) == 1 && chunks >= 3
+		}},
+		{"db_introspector", "", "postgresql://reader@db:5432/fixture", func(c map[string]any) bool {
+			tables, _ := c["tables"].([]any)
+			for _, t := range tables {
+				table, _ := t.(map[string]any)
+				foreignKeys, _ := table["foreign_keys"].([]any)
+				if table["name"] == "orders" {
+					return table["rows"] == float64(2) && table["rows_exact"] == true && len(foreignKeys) == 1
+				}
+			}
+			return false
+		}},
+		// The join, the bot and the "thanks" are noise; the thread is one conversation
+		{"chat_ingester", "", "slack:C0FIXTURE", func(c map[string]any) bool {
+			conversations, _ := c["conversations"].([]any)
+			if len(conversations) != 2 {
+				return false
+			}
+			thread, _ := conversations[0].(map[string]any)
+			messages, _ := thread["messages"].([]any)
+			return thread["channel_name"] == "fixture" && len(messages) == 2
+		}},
+		// The pull request and the closed issue are left out; the open issue
+		// links to the repository and to its service label's service
+		{"issue_tracker", "", "github:fixture/repo", func(c map[string]any) bool {
+			nodes, _ := c["nodes"].([]any)
+			if c["issue_count"] != float64(1) || len(nodes) != 1 {
+				return false
+			}
+			node, _ := nodes[0].(map[string]any)
+			data, _ := node["data"].(map[string]any)
+			references, _ := data["references"].([]any)
+			return data["key"] == "fixture/repo#3" && len(references) == 2
+		}},
+	}
+
+	for _, check := range checks {
+		container := variants[check.agentType].
+			WithServiceBinding("site", site).
+			WithServiceBinding("db", database).
+			WithEnvVariable("AGENT_GITHUB_URL", "file:///tmp/github").
+			WithEnvVariable("AGENT_GITHUB_API", "http://site:8000/api").
+			WithMountedSecret("/run/secrets/db_password", readerPassword).
+			WithEnvVariable("AGENT_SLACK_API", "http://site:8000/slack").
+			WithSecretVariable("SLACK_BOT_TOKEN", slackToken).
+			WithEnvVariable("BROWSER_USERNAME", "fixture").
+			WithEnvVariable("AGENT_LOGIN_STEPS", `[{"goto": "http://site:8000/login.html"}, {"fill": "#user", "value": "${BROWSER_USERNAME}"}, `+
+				`{"click": "#go"}, {"wait_for_url": "**/app.html"}]`)
+		if check.setup != "" {
+			container = container.WithExec([]string{"sh", "-c", check.setup}, dagger.ContainerWithExecOpts{SkipEntrypoint: true})
+		}
+		output, err := container.WithExec([]string{check.target}).Stdout(ctx)
+		if err != nil {
+			return fmt.Errorf("%s agent: %w", check.agentType, err)
+		}
+		result, err := agentResult(output)
+		if err != nil {
+			return err
+		}
+		gathered, _ := result["context"].(map[string]any)
+		if result["agent_type"] != check.agentType || gathered == nil || !check.check(gathered) {
+			return fmt.Errorf("%s agent gathered the wrong context: %s", check.agentType, output)
+		}
+		fmt.Printf("✅ %s agent gathered context for %s\n", check.agentType, check.target)
+	}
+
+	// A variant has only its own agent type installed
+	_, err := variants["filesystem_crawler"].
+		WithExec([]string{"--type", "web_scraper", "http://site:8000/"}).
+		Stdout(ctx)
+	if err == nil {
+		return fmt.Errorf("filesystem_crawler variant ran the web_scraper agent")
+	}
+
+	fmt.Println("Micro Agent Variants: all agent types gathered context")
+	return nil
+}
+
+// exportMicroAgentImages writes each variant as an image tarball, for
+// "docker load" and the orchestrator's container runtime, which expects them
+// tagged micro-agent-<type>:latest.
+func exportMicroAgentImages(ctx context.Context, variants map[string]*dagger.Container, dir string) error {
+	if os.Getenv("MICRO_AGENT_EXPORT") == "" {
+		fmt.Println("⏭️ Skipping micro agent image export: MICRO_AGENT_EXPORT is not set")
+		return nil
+	}
+	fmt.Println("📦 Exporting Micro Agent Images...")
+
+	for _, variant := range builtinAgentTypes {
+		dest := fmt.Sprintf("%s/micro-agent-%s.tar", dir, strings.ReplaceAll(variant.agentType, "_", "-"))
+		if _, err := variants[variant.agentType].Export(ctx, dest); err != nil {
+			return fmt.Errorf("%s: %w", variant.agentType, err)
+		}
+		fmt.Printf("Micro Agent Image: %s\n", dest)
+	}
+	return nil
+}
+
+const agentFixturePage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Fixture Page</title>
+  <meta name="description" content="A page for the web scraper agent test">
+</head>
+<body>
+  <h1>Fixture Page</h1>
+  <h2>Links</h2>
+  <p>Context for the micro agents.</p>
+  <a href="/status.json">Status</a>
+  <a href="https://example.com/docs">Docs</a>
+</body>
+</html>
+`
+
+const agentFixtureDocsIndex = `<!DOCTYPE html>
+<html>
+<head><title>Fixture Docs</title></head>
+<body>
+  <nav><a href="/index.html">Home</a></nav>
+  <main>
+    <h1>Getting started</h1>
+    <p>Install the fixture, then run it.</p>
+    <h2>Guides</h2>
+    <ul>
+      <li><a href="guide.html">Guide</a></li>
+      <li><a href="copy.html">The guide again</a></li>
+      <li><a href="private/notes.html">Private notes</a></li>
+    </ul>
+  </main>
+</body>
+</html>
+`
+
+const agentFixtureDocsGuide = `<!DOCTYPE html>
+<html>
+<head><title>Guide</title></head>
+<body>
+  <main>
+    <h1>Guide</h1>
+    <p>Every step of the fixture guide.</p>
+    <h2>Details</h2>
+    <p>The details of the fixture guide.</p>
+    <a href="index.html">Back</a>
+  </main>
+</body>
+</html>
+`
+
+// agentFixtureSchema is the database the db_introspector agent describes.
+const agentFixtureSchema = `CREATE TABLE customers (
+  id serial PRIMARY KEY,
+  email text NOT NULL UNIQUE
+);
+COMMENT ON TABLE customers IS 'People who place orders';
+CREATE TABLE orders (
+  id serial PRIMARY KEY,
+  customer_id integer NOT NULL REFERENCES customers (id),
+  total numeric(10, 2) NOT NULL
+);
+COMMENT ON COLUMN orders.total IS 'Order total in euros';
+INSERT INTO customers (email) VALUES ('ada@example.com');
+INSERT INTO orders (customer_id, total) VALUES (1, 12.50), (1, 7.25);
+CREATE ROLE reader LOGIN PASSWORD 'fixture-reader';
+GRANT SELECT ON ALL TABLES IN SCHEMA public TO reader;
+`
+
+// agentFixtureSlackHistory and agentFixtureSlackReplies answer the Slack
+// API methods the chat_ingester agent calls, whatever their parameters.
+const agentFixtureSlackHistory = `{"ok": true, "has_more": false, "messages": [
+  {"type": "message", "user": "U1", "text": "The deploy fails on <#C0OPS|ops>", "ts": "1700000000.000100",
+   "thread_ts": "1700000000.000100", "reply_count": 1},
+  {"type": "message", "subtype": "channel_join", "user": "U2", "text": "<@U2> has joined", "ts": "1700000100.000100"},
+  {"type": "message", "bot_id": "B1", "text": "Build 42 passed", "ts": "1700000200.000100"},
+  {"type": "message", "user": "U2", "text": "thanks!", "ts": "1700000300.000100"},
+  {"type": "message", "user": "U2", "text": "Standup moves to 10:00 :clock10:", "ts": "1700000400.000100"}]}`
+
+const agentFixtureSlackReplies = `{"ok": true, "messages": [
+  {"type": "message", "user": "U1", "text": "The deploy fails on <#C0OPS|ops>", "ts": "1700000000.000100",
+   "thread_ts": "1700000000.000100"},
+  {"type": "message", "user": "U2", "text": "<@U1> the migration needs a lock timeout", "ts": "1700000050.000100",
+   "thread_ts": "1700000000.000100"}]}`
+
+// agentFixtureIssues answers the issue_tracker agent's issues request. The
+// file server ignores the state filter, so the closed issue comes too.
+const agentFixtureIssues = `[
+  {"number": 1, "title": "Add fixture feature", "state": "open", "pull_request": {}, "labels": [], "assignees": [],
+   "updated_at": "2024-01-01T00:00:00Z"},
+  {"number": 2, "title": "Old fixture bug", "state": "closed", "labels": [], "assignees": [],
+   "updated_at": "2024-01-02T00:00:00Z"},
+  {"number": 3, "title": "Checkout times out", "body": "The payments call takes over 30s.", "state": "open",
+   "labels": [{"name": "bug"}, {"name": "service:payments"}], "assignees": [{"login": "fixture"}],
+   "html_url": "https://github.com/fixture/repo/issues/3", "updated_at": "2024-01-03T00:00:00Z"}]`
+
+// agentFixturePulls answers the github_repo agent's open pull requests
+// request, in the shape of the GitHub API.
+const agentFixturePulls = `[{"number": 1, "title": "Add fixture feature", "user": {"login": "fixture"},
+  "html_url": "https://github.com/fixture/repo/pull/1", "created_at": "2024-01-01T00:00:00Z"}]`
+
+// agentFixtureApp only has content once its script runs, and only for a
+// user agentFixtureLogin has logged in.
+const agentFixtureApp = `<!DOCTYPE html>
+<html>
+<head><title>Log in</title></head>
+<body>
+  <script>
+    const user = localStorage.getItem("user");
+    if (user) {
+      document.title = "Fixture App";
+      document.body.insertAdjacentHTML("beforeend", "<h1>Welcome " + user + "</h1><a href='/index.html'>Home</a>");
+    }
+  </script>
+</body>
+</html>
+`
+
+const agentFixtureLogin = `<!DOCTYPE html>
+<html>
+<head><title>Log in</title></head>
+<body>
+  <input id="user">
+  <button id="go" onclick="localStorage.setItem('user', document.getElementById('user').value); location = '/app.html'">Log in</button>
+</body>
+</html>
+`
+
+const microAgentPy = `#!/usr/bin/env python3
+"""Gathers context about a target and prints it as JSON.
+
+  micro_agent.py [--type AGENT_TYPE] TARGET
+
+Each agent type is a module in /app/agents with a gather(target) function:
+
+  web_scraper         title, headings, links and text of a web page
+  git_analyzer        commits, contributors, branches and languages of a
+                      git repository, local or remote
+  filesystem_crawler  files, sizes, types and READMEs under a directory
+  rest_poller         status, latency and body of a REST endpoint, polled
+                      one or more times
+  github_repo         README, manifests, directory structure, recent commits
+                      and open pull requests of a GitHub repository, as
+                      knowledge graph nodes
+  docs_crawler        pages of a documentation site, crawled politely and
+                      split into chunks
+  db_introspector     tables, columns, keys, comments and row counts of a
+                      Postgres or MySQL database
+  chat_ingester       recent conversations in Slack or Discord channels,
+                      threaded and stripped of noise
+  issue_tracker       open issues of a Jira project, Linear team or GitHub
+                      repository, synced incrementally into the knowledge
+                      graph
+  headless_browser    title, headings, links and text of pages rendered in
+                      headless Chromium, after a login when one is
+                      configured, with screenshots as evidence
+  context_gatherer    picks one of the above from the shape of the target,
+                      or simulates context for targets none of them fit
+
+The agent container variants bake in only their own type's dependencies, so
+a type whose module or dependencies are missing fails with exit status 2.
+The type can also come from AGENT_TYPE. Progress goes to stdout ahead of the
+result, which is everything from the first line starting with "{".
+
+With MCP_SERVER_URL set, agents also stream context items to the MCP server
+over Socket.IO as they find them, so a long crawl is useful before it ends:
+an "agent_item" event {stream_id, session_id, agent_type, target, field,
+items, seq} per batch and an "agent_done" event at the end. The stream is
+AGENT_JOB_ID when the orchestrator launched the agent, and the items go to
+AGENT_SESSION_ID. If the server cannot be reached the agent carries on
+without streaming.
+
+Agents whose gather also takes a checkpoint, such as docs_crawler, save
+their progress as they go in session memory: at SESSION_MEMORY_URL, or
+through the MCP server's /memory proxy. A run of the same agent on the same
+target for the same session, such as the orchestrator's retry of a run that
+died, resumes from it. The checkpoint is dropped when a run succeeds, and
+otherwise expires after AGENT_CHECKPOINT_TTL seconds (default a day).
+
+Streamed items, and what agents such as chat_ingester send to the
+knowledge graph, are deduplicated against the last successful run on the
+same target for the same session: each is hashed, leaving out fields such
+as when it was fetched, and those whose hash that run already submitted
+are not sent again. The hashes are kept in session memory for
+AGENT_DEDUP_TTL seconds (default 30 days); AGENT_DEDUP=off sends
+everything. The final result still holds all the context, as it replaces
+what the job streamed.
+
+With EVENT_BUS_URL set, agents that write to the knowledge graph, such as
+issue_tracker and chat_ingester, publish their nodes on the event bus
+instead, for the graph to take from it (see event_bus.py).
+
+The result's "metrics" say what the run produced and used, for the
+orchestrator's run history and cost reports: the items it found, those it
+did not send again as "deduplicated", and the HTTP requests it made and
+the bytes it read, checkpoints aside.
+
+A run is traced as a "gather" span, the child of the orchestrator's span
+for it in TRACEPARENT, and its calls to the other services and events on
+the bus carry the trace on (see tracing.py).
+"""
+import asyncio
+import hashlib
+import http.client
+import importlib
+import inspect
+import json
+import os
+import sys
+import threading
+import time
+import urllib.error
+import urllib.request
+import uuid
+from datetime import datetime
+from urllib.parse import quote, urlencode
+
+import rbac
+import tracing
+
+# Item fields that change between runs over the same content
+VOLATILE_KEYS = ("timestamp", "taken_at", "fetched_at", "latency_ms", "evidence")
+
+AGENT_TYPES = ("web_scraper", "git_analyzer", "filesystem_crawler", "rest_poller", "github_repo",
+               "docs_crawler", "db_introspector", "chat_ingester",
+               "issue_tracker", "headless_browser")
+
+
+def detect_type(target):
+    """The agent type that fits target, or None"""
+    if target.endswith(".git") or target.startswith("git@") or os.path.isdir(os.path.join(target, ".git")):
+        return "git_analyzer"
+    if os.path.isdir(target):
+        return "filesystem_crawler"
+    if target.startswith("https://github.com/") and target.rstrip("/").count("/") == 4:
+        return "github_repo"
+    if target.startswith(("http://", "https://")):
+        return "web_scraper"
+    return None
+
+
+class ContextStream:
+    """Sends context items to the MCP server as an agent finds them"""
+
+    def __init__(self, url, agent_type, target):
+        self.stream_id = os.getenv("AGENT_JOB_ID") or uuid.uuid4().hex
+        self.session_id = os.getenv("AGENT_SESSION_ID") or None
+        self.agent_type, self.target = agent_type, target
+        self.client, self.seq, self.sent = None, 0, 0
+        self.submitted, self.unchanged = None, 0
+        if not url:
+            return
+        try:
+            import socketio
+            self.client = socketio.Client(reconnection=False)
+            token = os.getenv("RBAC_TOKEN")
+            self.client.connect(url, headers={**rbac.headers(), **tracing.headers()},
+                                auth={"token": token} if token else None,
+                                wait_timeout=5)
+            print(f"📡 Streaming context to {url}")
+        except Exception as e:
+            print(f"⚠️ Not streaming context to {url}: {e}")
+            self.client = None
+
+    def emit(self, field, items):
+        if self.submitted:
+            fresh = self.submitted.fresh(field, items)
+            self.unchanged += len(items) - len(fresh)
+            items = fresh
+        if not items:
+            return
+        self.seq += 1
+        self.sent += len(items)
+        if self.client:
+            self.client.emit("agent_item", {
+                "stream_id": self.stream_id, "session_id": self.session_id, "agent_type": self.agent_type,
+                "target": self.target, "field": field, "items": items, "seq": self.seq})
+
+    def close(self, status):
+        if not self.client:
+            return
+        self.client.emit("agent_done", {"stream_id": self.stream_id, "session_id": self.session_id,
+                                        "status": status, "items": self.sent, "batches": self.seq})
+        self.client.disconnect()
+
+
+class CountingReader:
+    """Wraps a response's socket file to count the bytes read from it"""
+
+    def __init__(self, fp, counter):
+        self.fp, self.counter = fp, counter
+
+    def read(self, *args):
+        return self.counter.add(self.fp.read(*args))
+
+    def read1(self, *args):
+        return self.counter.add(self.fp.read1(*args))
+
+    def readline(self, *args):
+        return self.counter.add(self.fp.readline(*args))
+
+    def readinto(self, b):
+        n = self.fp.readinto(b)
+        self.counter.total += n or 0
+        return n
+
+    def __getattr__(self, name):
+        return getattr(self.fp, name)
+
+
+# Set while the agent talks to the orchestrator's own services, such as
+# session memory, which are not external API calls.
+internal = threading.local()
+
+
+class FetchCounter:
+    """Counts every HTTP response read through http.client, which urllib
+    and requests both use, as an API call, and its bytes, headers included"""
+
+    def __init__(self):
+        self.total, self.calls = 0, 0
+        counter, init = self, http.client.HTTPResponse.__init__
+
+        def counting_init(response, sock, *args, **kwargs):
+            init(response, sock, *args, **kwargs)
+            if getattr(internal, "active", False):
+                return
+            counter.calls += 1
+            response.fp = CountingReader(response.fp, counter)
+
+        http.client.HTTPResponse.__init__ = counting_init
+
+    def add(self, data):
+        self.total += len(data)
+        return data
+
+
+class MemoryRecord:
+    """A value in session memory's hot memory, keyed by what it is, agent
+    type, target and session"""
+
+    def __init__(self, kind, url, agent_type, target, session_id):
+        self.url, self.session_id = url and url.rstrip("/"), session_id
+        scope = hashlib.sha256(f"{session_id or ''}\n{target}".encode()).hexdigest()[:16]
+        self.key = f"{kind}:{agent_type}:{scope}"
+
+    def request(self, method, body=None, **params):
+        if self.session_id:
+            params["session_id"] = self.session_id
+        url = f"{self.url}/memory/{quote(self.key)}" + (f"?{urlencode(params)}" if params else "")
+        request = urllib.request.Request(url, method=method,
+                                         headers={"Content-Type": "application/json", **rbac.headers(),
+                                                  **tracing.headers()},
+                                         data=None if body is None else json.dumps(body).encode())
+        internal.active = True
+        try:
+            with urllib.request.urlopen(request, timeout=10) as response:
+                return json.loads(response.read() or b"null")
+        finally:
+            internal.active = False
+
+
+class Checkpoint(MemoryRecord):
+    """A run's progress. Saves come at most every AGENT_CHECKPOINT_INTERVAL
+    seconds (default 10) unless forced. Without session memory, or when it
+    cannot be reached, the run goes on and only loses the ability to resume."""
+
+    def __init__(self, url, agent_type, target, session_id):
+        super().__init__("checkpoint", url, agent_type, target, session_id)
+        self.interval = float(os.getenv("AGENT_CHECKPOINT_INTERVAL", "10"))
+        self.ttl = int(os.getenv("AGENT_CHECKPOINT_TTL", "86400"))
+        self.saved_at, self.stored = 0.0, False
+
+    def load(self):
+        """The state an interrupted run saved, or None"""
+        if not self.url:
+            return None
+        try:
+            state = self.request("GET")
+        except (urllib.error.URLError, OSError, ValueError):
+            return None
+        self.stored = True
+        print("⏯️ Resuming from a checkpoint")
+        return state
+
+    def save(self, state, force=False):
+        if not self.url or (not force and time.monotonic() - self.saved_at < self.interval):
+            return
+        self.saved_at = time.monotonic()
+        try:
+            self.request("PUT", state, ttl=self.ttl)
+            self.stored = True
+        except (urllib.error.URLError, OSError) as e:
+            print(f"⚠️ Could not save a checkpoint: {e}")
+
+    def clear(self):
+        if not self.stored:
+            return
+        try:
+            self.request("DELETE")
+        except (urllib.error.URLError, OSError):
+            pass
+
+
+def content_hash(item):
+    """A hash of an item's content, leaving out what changes from run to run
+    when the content does not, such as when it was fetched"""
+    def strip(value):
+        if isinstance(value, dict):
+            return {k: strip(v) for k, v in value.items() if k not in VOLATILE_KEYS}
+        if isinstance(value, list):
+            return [strip(v) for v in value]
+        return value
+    return hashlib.sha256(json.dumps(strip(item), sort_keys=True, default=str).encode()).hexdigest()[:32]
+
+
+class Submitted(MemoryRecord):
+    """What the last successful run on the same target submitted: the hash
+    of each streamed item and graph node, by field. Items whose hash is in it
+    are not sent again, and once this run succeeds its own hashes replace
+    it, for AGENT_DEDUP_TTL seconds (default 30 days). With AGENT_DEDUP=off,
+    or without session memory, everything is sent."""
+
+    def __init__(self, url, agent_type, target, session_id):
+        enabled = os.getenv("AGENT_DEDUP", "on") != "off"
+        super().__init__("submitted", enabled and url, agent_type, target, session_id)
+        self.ttl = int(os.getenv("AGENT_DEDUP_TTL", str(30 * 86400)))
+        self.previous, self.current, self.skipped = {}, {}, 0
+        if self.url:
+            try:
+                self.previous = self.request("GET") or {}
+            except (urllib.error.URLError, OSError, ValueError):
+                pass
+
+    def fresh(self, field, items):
+        """The items that are new or changed since the last run"""
+        seen, current = set(self.previous.get(field, ())), self.current.setdefault(field, set())
+        kept = []
+        for item in items:
+            digest = content_hash(item)
+            current.add(digest)
+            if digest in seen:
+                self.skipped += 1
+            else:
+                kept.append(item)
+        return kept
+
+    def unsent(self, field):
+        """Forgets this run's items for field, as when sending them failed,
+        so the next run sends them again"""
+        self.current[field] = set(self.previous.get(field, ()))
+
+    def save(self):
+        if not self.url:
+            return
+        try:
+            self.request("PUT", {field: sorted(digests) for field, digests in self.current.items()}, ttl=self.ttl)
+        except (urllib.error.URLError, OSError) as e:
+            print(f"⚠️ Could not record what was submitted: {e}")
+
+
+def memory_url():
+    """Session memory, directly or through the MCP server"""
+    if os.getenv("SESSION_MEMORY_URL"):
+        return os.getenv("SESSION_MEMORY_URL")
+    if os.getenv("MCP_SERVER_URL"):
+        return os.getenv("MCP_SERVER_URL").rstrip("/") + "/memory"
+    return None
+
+
+class MicroAgent:
+    def __init__(self, agent_type="context_gatherer"):
+        self.agent_type = agent_type
+        self.context_data = {}
+
+    def resolve_type(self, target):
+        if self.agent_type == "context_gatherer":
+            return detect_type(target)
+        if self.agent_type not in AGENT_TYPES:
+            raise ValueError(f"Unknown agent type: {self.agent_type}")
+        return self.agent_type
+
+    async def gather_context(self, target):
+        print(f"🔍 Gathering context for: {target}")
+        implementation = self.resolve_type(target)
+        metrics = {}
+        if implementation is None:
+            # Nothing to inspect, so the context is simulated as before
+            context = f"Dynamic context for {target}"
+        else:
+            print(f"🧰 Using the {implementation} agent")
+            module = importlib.import_module(f"agents.{implementation}")
+            fetched = FetchCounter()
+            stream = ContextStream(os.getenv("MCP_SERVER_URL"), implementation, target)
+            submitted = Submitted(memory_url(), implementation, target, stream.session_id)
+            stream.submitted, extra = submitted, {}
+            parameters = inspect.signature(module.gather).parameters
+            if "checkpoint" in parameters:
+                extra["checkpoint"] = Checkpoint(memory_url(), implementation, target, stream.session_id)
+            if "submitted" in parameters:
+                extra["submitted"] = submitted
+            try:
+                context = await asyncio.to_thread(module.gather, target, stream.emit, **extra)
+            except Exception:
+                stream.close("failed")
+                raise
+            stream.close("succeeded")
+            if "checkpoint" in extra:
+                extra["checkpoint"].clear()
+            submitted.save()
+            metrics = {"bytes_fetched": fetched.total, "api_calls": fetched.calls}
+            if stream.seq or stream.unchanged:
+                metrics["items"] = stream.sent + stream.unchanged
+            if submitted.skipped:
+                metrics["deduplicated"] = submitted.skipped
+        self.context_data = {
+            "timestamp": datetime.now().isoformat(),
+            "agent_type": implementation or self.agent_type,
+            "target": target,
+            "context": context,
+            "metadata": {"source": "micro_agent", "version": "2.0"},
+            "metrics": metrics
+        }
+        return self.context_data
+
+    def export_context(self):
+        return json.dumps(self.context_data, indent=2)
+
+
+def parse_args(argv):
+    agent_type = os.getenv("AGENT_TYPE", "context_gatherer")
+    if len(argv) > 1 and argv[0] == "--type":
+        agent_type, argv = argv[1], argv[2:]
+    return agent_type, (argv[0] if argv else "default_target")
+
+
+if __name__ == "__main__":
+    agent_type, target = parse_args(sys.argv[1:])
+    agent = MicroAgent(agent_type)
+    tracing.init("micro-agent")
+    try:
+        with tracing.span(f"gather {agent_type}", parent=tracing.from_env(),
+                          attributes={"agent.type": agent_type, "agent.target": target}):
+            context = asyncio.run(agent.gather_context(target))
+    except (ValueError, ImportError) as e:
+        print(f"❌ {e}", file=sys.stderr)
+        sys.exit(2)
+    except Exception as e:
+        print(f"❌ Could not gather context for {target}: {e}", file=sys.stderr)
+        sys.exit(1)
+    print("✅ Context gathered successfully!")
+    print(agent.export_context())
+`
+
+const webScraperAgentPy = `"""Scrapes a web page: title, description, headings, links and text.
+
+AGENT_TIMEOUT bounds the request in seconds (default 15), AGENT_MAX_LINKS
+the links kept (default 50) and AGENT_MAX_TEXT the characters of text
+(default 5000). The page's URL, title and description are streamed once it
+is parsed.
+"""
+import os
+from urllib.parse import urljoin, urldefrag
+
+import requests
+from bs4 import BeautifulSoup
+
+USER_AGENT = "dynamic-context-micro-agent/2.0"
+
+
+def gather(target, emit=lambda field, items: None):
+    response = requests.get(target, timeout=float(os.getenv("AGENT_TIMEOUT", "15")),
+                            headers={"User-Agent": USER_AGENT})
+    response.raise_for_status()
+    soup = BeautifulSoup(response.text, "html.parser")
+    for tag in soup(["script", "style", "noscript"]):
+        tag.decompose()
+
+    description = soup.find("meta", attrs={"name": "description"})
+    links = []
+    for anchor in soup.find_all("a", href=True):
+        link = urldefrag(urljoin(response.url, anchor["href"]))[0]
+        if link.startswith(("http://", "https://")) and link not in links:
+            links.append(link)
+    title = soup.title.get_text(strip=True) if soup.title else None
+    emit("pages", [{"url": response.url, "title": title,
+                    "description": description.get("content") if description else None}])
+    text = " ".join(soup.get_text(" ").split())
+    max_text = int(os.getenv("AGENT_MAX_TEXT", "5000"))
+
+    return {
+        "url": response.url,
+        "status_code": response.status_code,
+        "content_type": response.headers.get("Content-Type"),
+        "title": title,
+        "description": description.get("content") if description else None,
+        "headings": [{"level": int(h.name[1]), "text": h.get_text(" ", strip=True)}
+                     for h in soup.find_all(["h1", "h2", "h3"])][:50],
+        "links": links[:int(os.getenv("AGENT_MAX_LINKS", "50"))],
+        "link_count": len(links),
+        "text": text[:max_text],
+        "truncated": len(text) > max_text,
+    }
+`
+
+const gitAnalyzerAgentPy = `"""Analyzes a git repository: recent commits, contributors, branches, tags
+and the languages of its files.
+
+A local repository is read in place. A remote one is cloned bare and
+blobless, so only history and trees are fetched, keeping the last
+AGENT_GIT_DEPTH commits (default 200). AGENT_MAX_COMMITS commits are
+reported (default 20); every commit is streamed, 100 at a time, as the log
+is read.
+"""
+import os
+import subprocess
+import tempfile
+from collections import Counter
+
+
+BATCH = 100
+LOG_FIELDS = ("sha", "author", "date", "subject")
+
+
+def git(repo, *args):
+    return subprocess.run(["git", "-C", repo, *args], check=True, capture_output=True,
+                          text=True, timeout=120).stdout
+
+
+def is_local(target):
+    return os.path.isdir(target)
+
+
+def read_log(repo, emit):
+    commits = []
+    with subprocess.Popen(["git", "-C", repo, "log", "--format=%H%x09%an%x09%aI%x09%s"],
+                          stdout=subprocess.PIPE, text=True) as log:
+        for line in log.stdout:
+            commits.append(dict(zip(LOG_FIELDS, line.rstrip("\n").split("\t", 3))))
+            if len(commits) % BATCH == 0:
+                emit("commits", commits[-BATCH:])
+    if log.returncode:
+        raise subprocess.CalledProcessError(log.returncode, "git log")
+    emit("commits", commits[len(commits) - len(commits) % BATCH:])
+    return commits
+
+
+def analyze(repo, source, emit):
+    head = git(repo, "rev-parse", "--abbrev-ref", "HEAD").strip()
+    commits = read_log(repo, emit)
+    files = git(repo, "ls-tree", "-r", "--name-only", "HEAD").splitlines()
+    extensions = Counter(os.path.splitext(path)[1].lower() or os.path.basename(path) for path in files)
+    contributors = Counter(commit["author"] for commit in commits)
+
+    return {
+        "repository": source,
+        "head": head,
+        "branches": git(repo, "for-each-ref", "--format=%(refname:short)", "refs/heads").split(),
+        "tags": git(repo, "for-each-ref", "--format=%(refname:short)", "refs/tags").split(),
+        "commit_count": len(commits),
+        "recent_commits": commits[:int(os.getenv("AGENT_MAX_COMMITS", "20"))],
+        "contributors": [{"name": name, "commits": count} for name, count in contributors.most_common(20)],
+        "file_count": len(files),
+        "file_types": dict(extensions.most_common(20)),
+        "first_commit_date": commits[-1]["date"] if commits else None,
+        "last_commit_date": commits[0]["date"] if commits else None,
+    }
+
+
+def gather(target, emit=lambda field, items: None):
+    if is_local(target):
+        return analyze(target, target, emit)
+    with tempfile.TemporaryDirectory() as workdir:
+        subprocess.run(["git", "clone", "--quiet", "--bare", "--filter=blob:none",
+                        "--depth", os.getenv("AGENT_GIT_DEPTH", "200"), target, workdir],
+                       check=True, capture_output=True, text=True, timeout=300)
+        return analyze(workdir, target, emit)
+`
+
+const filesystemCrawlerAgentPy = `"""Crawls a directory: file counts, sizes and types, the largest and most
+recently modified files, and the start of any README.
+
+Hidden entries and dependency or build directories are skipped. The crawl
+stops after AGENT_MAX_FILES files (default 10000) and AGENT_MAX_DEPTH
+levels (default 8). Files are streamed in batches of 200 as they are found.
+"""
+import os
+from collections import Counter
+from datetime import datetime, timezone
+
+SKIPPED_DIRS = {"node_modules", "__pycache__", "venv", ".venv", "dist", "build", "target"}
+BATCH = 200
+
+
+def entry(item):
+    return {"path": item[0], "size": item[1],
+            "modified": datetime.fromtimestamp(item[2], timezone.utc).isoformat()}
+
+
+def gather(target, emit=lambda field, items: None):
+    if not os.path.isdir(target):
+        raise ValueError(f"Not a directory: {target}")
+    max_files = int(os.getenv("AGENT_MAX_FILES", "10000"))
+    max_depth = int(os.getenv("AGENT_MAX_DEPTH", "8"))
+    root = os.path.abspath(target)
+
+    files, directories, truncated = [], 0, False
+    for path, dirnames, filenames in os.walk(root):
+        depth = os.path.relpath(path, root).count(os.sep) + (path != root)
+        dirnames[:] = sorted(d for d in dirnames
+                             if not d.startswith(".") and d not in SKIPPED_DIRS and depth < max_depth)
+        directories += len(dirnames)
+        for name in sorted(filenames):
+            if name.startswith("."):
+                continue
+            if len(files) >= max_files:
+                truncated = True
+                break
+            full = os.path.join(path, name)
+            try:
+                stat = os.stat(full)
+            except OSError:
+                continue
+            files.append((os.path.relpath(full, root), stat.st_size, stat.st_mtime))
+            if len(files) % BATCH == 0:
+                emit("files", [entry(item) for item in files[-BATCH:]])
+        if truncated:
+            break
+    emit("files", [entry(item) for item in files[len(files) - len(files) % BATCH:]])
+
+    readme = next((path for path, _, _ in files if os.path.dirname(path) == ""
+                   and os.path.basename(path).lower().startswith("readme")), None)
+    excerpt = None
+    if readme:
+        with open(os.path.join(root, readme), errors="replace") as f:
+            excerpt = f.read(2000)
+
+    return {
+        "root": root,
+        "file_count": len(files),
+        "directory_count": directories,
+        "total_size": sum(size for _, size, _ in files),
+        "file_types": dict(Counter(os.path.splitext(path)[1].lower() or "(none)"
+                                   for path, _, _ in files).most_common(20)),
+        "largest_files": [entry(item) for item in sorted(files, key=lambda item: -item[1])[:10]],
+        "recently_modified": [entry(item) for item in sorted(files, key=lambda item: -item[2])[:10]],
+        "readme": {"path": readme, "excerpt": excerpt} if readme else None,
+        "truncated": truncated,
+    }
+`
+
+const restPollerAgentPy = `"""Polls a REST endpoint: status, latency, content type and body.
+
+The endpoint is requested AGENT_POLL_COUNT times (default 1),
+AGENT_POLL_INTERVAL seconds apart (default 5), with the JSON object in
+AGENT_HEADERS as extra request headers. Each poll records whether the body
+changed since the one before; the last body is returned, parsed when it is
+JSON and cut to AGENT_MAX_TEXT characters (default 5000) otherwise. Each
+poll is streamed as it completes.
+"""
+import hashlib
+import json
+import os
+import time
+
+import requests
+
+
+def poll(target, headers, timeout):
+    started = time.monotonic()
+    response = requests.get(target, headers=headers, timeout=timeout)
+    latency = round((time.monotonic() - started) * 1000, 1)
+    return response, latency
+
+
+def gather(target, emit=lambda field, items: None):
+    count = max(1, int(os.getenv("AGENT_POLL_COUNT", "1")))
+    interval = float(os.getenv("AGENT_POLL_INTERVAL", "5"))
+    headers = json.loads(os.getenv("AGENT_HEADERS", "{}"))
+    timeout = float(os.getenv("AGENT_TIMEOUT", "15"))
+
+    polls, previous, response = [], None, None
+    for i in range(count):
+        if i:
+            time.sleep(interval)
+        response, latency = poll(target, headers, timeout)
+        digest = hashlib.sha256(response.content).hexdigest()
+        polls.append({"status_code": response.status_code, "latency_ms": latency,
+                      "changed": previous is not None and digest != previous})
+        emit("polls", polls[-1:])
+        previous = digest
+
+    try:
+        body = response.json()
+    except ValueError:
+        max_text = int(os.getenv("AGENT_MAX_TEXT", "5000"))
+        body = response.text[:max_text]
+
+    return {
+        "url": response.url,
+        "status_code": response.status_code,
+        "ok": response.ok,
+        "content_type": response.headers.get("Content-Type"),
+        "body": body,
+        "polls": polls,
+        "average_latency_ms": round(sum(p["latency_ms"] for p in polls) / len(polls), 1),
+        "changes": sum(p["changed"] for p in polls),
+    }
+`
+
+const githubRepoAgentPy = `"""Gathers context about a GitHub repository: its README, package
+manifests, directory structure, recent commits and open pull requests, as
+knowledge graph nodes.
+
+The target is owner/repo or a github.com URL. The repository is cloned
+shallow, AGENT_GIT_DEPTH commits deep (default 50), from AGENT_GITHUB_URL
+(default https://github.com), and its open pull requests come from the API
+at AGENT_GITHUB_API (default https://api.github.com). A token, for private
+repositories and the API's higher rate limit, is read from GITHUB_TOKEN or
+from the file AGENT_GITHUB_TOKEN_FILE (default /run/secrets/github_token,
+where Docker mounts secrets). It reaches git through its environment, not
+its arguments, so it never shows in the process list.
+
+Besides the gathered fields, "nodes" holds them as POST /nodes bodies for
+the knowledge graph service: a repository node first, then one node per
+README, manifest, commit and pull request, each derived_from the
+repository node, whose node_id is computed the way the service computes it.
+"""
+import base64
+import hashlib
+import json
+import os
+import re
+import subprocess
+import tempfile
+import tomllib
+from collections import Counter
+
+import requests
+
+MANIFESTS = ("package.json", "go.mod", "pyproject.toml", "requirements.txt", "setup.py", "Cargo.toml",
+             "pom.xml", "build.gradle", "Gemfile", "composer.json")
+LOG_FIELDS = ("sha", "author", "date", "subject")
+TARGET_PATTERN = re.compile(r"^(?:https?://github\.com/|git@github\.com:)?([\w.-]+)/([\w.-]+?)(?:\.git)?/?$")
+
+
+def parse_target(target):
+    match = TARGET_PATTERN.match(target.strip())
+    if not match:
+        raise ValueError(f"{target} is not owner/repo or a github.com repository URL")
+    return match.group(1), match.group(2)
+
+
+def read_token():
+    token = os.getenv("GITHUB_TOKEN")
+    if token:
+        return token.strip()
+    path = os.getenv("AGENT_GITHUB_TOKEN_FILE", "/run/secrets/github_token")
+    try:
+        with open(path) as f:
+            return f.read().strip() or None
+    except OSError:
+        return None
+
+
+def git(repo, *args):
+    return subprocess.run(["git", "-C", repo, *args], check=True, capture_output=True,
+                          text=True, timeout=120).stdout
+
+
+def clone(url, dest, token):
+    env = dict(os.environ, GIT_TERMINAL_PROMPT="0")
+    if token:
+        credentials = base64.b64encode(f"x-access-token:{token}".encode()).decode()
+        env.update(GIT_CONFIG_COUNT="1", GIT_CONFIG_KEY_0="http.extraHeader",
+                   GIT_CONFIG_VALUE_0=f"Authorization: Basic {credentials}")
+    result = subprocess.run(["git", "clone", "--quiet", "--depth", os.getenv("AGENT_GIT_DEPTH", "50"),
+                             "--single-branch", url, dest], env=env, capture_output=True, text=True, timeout=300)
+    if result.returncode:
+        # git's message can quote the URL, never the token, which is in a header
+        raise RuntimeError(f"git clone {url} failed: {result.stderr.strip()}")
+
+
+def read_text(path, limit):
+    with open(path, errors="replace") as f:
+        return f.read(limit)
+
+
+def parse_manifest(repo, path):
+    """The name, version and dependencies a manifest declares, where the
+    format is simple enough to read without its tooling"""
+    name = os.path.basename(path)
+    full = os.path.join(repo, path)
+    manifest = {"path": path, "kind": name}
+    try:
+        if name in ("package.json", "composer.json"):
+            data = json.loads(read_text(full, 1_000_000))
+            manifest.update(name=data.get("name"), version=data.get("version"),
+                            dependencies=sorted({**data.get("dependencies", {}), **data.get("require", {})}))
+        elif name in ("pyproject.toml", "Cargo.toml"):
+            with open(full, "rb") as f:
+                data = tomllib.load(f)
+            project = data.get("project") or data.get("package") or {}
+            dependencies = project.get("dependencies", data.get("dependencies", []))
+            manifest.update(name=project.get("name"), version=project.get("version"),
+                            dependencies=sorted(dependencies) if isinstance(dependencies, (list, dict)) else [])
+        elif name == "go.mod":
+            text = read_text(full, 1_000_000)
+            module = re.search(r"^module\s+(\S+)", text, re.M)
+            manifest.update(name=module.group(1) if module else None,
+                            dependencies=re.findall(r"^\s*(?:require\s+)?([\w.-]+\.[\w./-]+)\s+v\S+", text, re.M))
+        elif name == "requirements.txt":
+            lines = read_text(full, 1_000_000).splitlines()
+            manifest["dependencies"] = [re.split(r"[\s<>=!~;\[]", line.strip(), 1)[0] for line in lines
+                                        if line.strip() and not line.lstrip().startswith(("#", "-"))]
+    except (ValueError, tomllib.TOMLDecodeError, OSError) as e:
+        manifest["error"] = str(e)
+    return {k: v for k, v in manifest.items() if v is not None}
+
+
+def directory_structure(files, depth):
+    """Directories up to depth levels down, with how many files each holds
+    beneath it"""
+    counts = Counter()
+    for path in files:
+        parts = path.split("/")[:-1]
+        for i in range(1, min(len(parts), depth) + 1):
+            counts["/".join(parts[:i])] += 1
+    return [{"path": path, "files": count} for path, count in sorted(counts.items())]
+
+
+def open_pull_requests(api, owner, name, token, limit):
+    headers = {"Accept": "application/vnd.github+json"}
+    if token:
+        headers["Authorization"] = f"Bearer {token}"
+    response = requests.get(f"{api}/repos/{owner}/{name}/pulls", headers=headers, timeout=30,
+                            params={"state": "open", "per_page": limit})
+    response.raise_for_status()
+    return [{"number": pr.get("number"), "title": pr.get("title"), "author": (pr.get("user") or {}).get("login"),
+             "url": pr.get("html_url"), "created_at": pr.get("created_at")} for pr in response.json()[:limit]]
+
+
+def node_id(data):
+    """The ID the knowledge graph service gives a node with this data"""
+    return hashlib.md5(json.dumps(data, sort_keys=True).encode()).hexdigest()[:12]
+
+
+def context_nodes(context):
+    repository = context["repository"]
+    # Only the name, so the issue_tracker agent's links land on the same node
+    repo_node = {"type": "repository", "repository": repository, "content": f"GitHub repository {repository}"}
+    repo_id = node_id(repo_node)
+
+    def child(node_type, content, **fields):
+        return {"data": {"type": node_type, "repository": repository, "content": content, **fields,
+                         "derived_from": [repo_id], "metadata": {"source": "github_repo"}}}
+
+    nodes = [{"node_id": repo_id, "data": repo_node}]
+    if context["readme"]:
+        nodes.append(child("readme", context["readme"]["text"], path=context["readme"]["path"]))
+    for manifest in context["manifests"]:
+        summary = f"{manifest['path']} declares {manifest.get('name') or 'a package'}"
+        if manifest.get("dependencies"):
+            summary += " depending on " + ", ".join(manifest["dependencies"][:50])
+        nodes.append(child("manifest", summary, path=manifest["path"]))
+    if context["directories"]:
+        listing = ", ".join(f"{d['path']} ({d['files']} files)" for d in context["directories"][:100])
+        nodes.append(child("directory_structure", f"Directories of {repository}: {listing}"))
+    for commit in context["recent_commits"]:
+        nodes.append(child("commit", commit["subject"], sha=commit["sha"], author=commit["author"],
+                           date=commit["date"]))
+    for pr in context["pull_requests"]:
+        nodes.append(child("pull_request", pr["title"], number=pr["number"], author=pr["author"], url=pr["url"]))
+    for node in nodes[1:]:
+        node["node_id"] = node_id(node["data"])
+    return nodes
+
+
+def gather(target, emit=lambda field, items: None):
+    owner, name = parse_target(target)
+    repository = f"{owner}/{name}"
+    token = read_token()
+    base = os.getenv("AGENT_GITHUB_URL", "https://github.com").rstrip("/")
+
+    with tempfile.TemporaryDirectory() as workdir:
+        clone(f"{base}/{repository}.git", workdir, token)
+        files = git(workdir, "ls-files").splitlines()
+        readme = next((path for path in sorted(files, key=len) if "/" not in path
+                       and path.lower().startswith("readme")), None)
+        commits = [dict(zip(LOG_FIELDS, line.split("\t", 3))) for line in
+                   git(workdir, "log", "-n", os.getenv("AGENT_MAX_COMMITS", "20"),
+                       "--format=%H%x09%an%x09%aI%x09%s").splitlines()]
+        emit("commits", commits)
+        context = {
+            "repository": repository,
+            "default_branch": git(workdir, "rev-parse", "--abbrev-ref", "HEAD").strip(),
+            "readme": readme and {"path": readme, "text": read_text(os.path.join(workdir, readme),
+                                                                    int(os.getenv("AGENT_README_CHARS", "8000")))},
+            "manifests": [parse_manifest(workdir, path) for path in files
+                          if os.path.basename(path) in MANIFESTS and path.count("/") <= 2][:20],
+            "directories": directory_structure(files, int(os.getenv("AGENT_TREE_DEPTH", "2"))),
+            "file_count": len(files),
+            "recent_commits": commits,
+        }
+
+    try:
+        context["pull_requests"] = open_pull_requests(os.getenv("AGENT_GITHUB_API", "https://api.github.com").rstrip("/"),
+                                                      owner, name, token, int(os.getenv("AGENT_MAX_PRS", "20")))
+        emit("pull_requests", context["pull_requests"])
+    except (requests.RequestException, ValueError) as e:
+        # The clone alone is still worth having
+        context["pull_requests"], context["pull_requests_error"] = [], str(e)
+
+    context["nodes"] = context_nodes(context)
+    emit("nodes", context["nodes"])
+    return context
+`
+
+const docsCrawlerAgentPy = `"""Crawls a documentation site from the target page and splits its pages
+into chunks of context.
+
+The crawl follows links breadth first, staying on the target's host and
+under its directory, at most AGENT_MAX_DEPTH links from the target
+(default 2) and for at most AGENT_MAX_PAGES pages (default 50). It is
+polite: pages robots.txt disallows for our user agent are skipped, and
+requests to one host are at least AGENT_CRAWL_DELAY seconds apart (default
+1), or the robots.txt Crawl-delay when that is longer.
+
+Pages whose text hashes the same as one already crawled, as a site's
+aliases and versioned copies often do, are recorded as duplicates and not
+chunked again. A chunk holds the text of consecutive paragraphs under one
+heading, up to AGENT_CHUNK_SIZE characters (default 1500); longer
+paragraphs are split between words. Chunks are streamed page by page.
+
+The crawl checkpoints its queue, the pages it has seen and what it has
+found so far, so a crawl that dies part way resumes where it stopped and
+streams what the earlier run found again first.
+"""
+import hashlib
+import os
+import time
+from collections import deque
+from urllib.parse import urldefrag, urljoin, urlparse
+from urllib.robotparser import RobotFileParser
+
+import requests
+from bs4 import BeautifulSoup
+
+USER_AGENT = "dynamic-context-micro-agent/2.0"
+BLOCKS = ("h1", "h2", "h3", "h4", "p", "li", "pre", "blockquote", "dt", "dd", "td")
+HEADINGS = ("h1", "h2", "h3", "h4")
+
+
+class PoliteSession:
+    """Fetches pages, honouring each host's robots.txt and crawl delay"""
+
+    def __init__(self, delay, timeout):
+        self.session = requests.Session()
+        self.session.headers["User-Agent"] = USER_AGENT
+        self.delay, self.timeout = delay, timeout
+        self.robots, self.last_request = {}, {}
+
+    def rules(self, url):
+        host = urlparse(url)
+        origin = f"{host.scheme}://{host.netloc}"
+        if origin not in self.robots:
+            rules = RobotFileParser(origin + "/robots.txt")
+            try:
+                response = self.get(origin + "/robots.txt")
+                # A missing robots.txt allows everything; a forbidden one, nothing
+                rules.parse(response.text.splitlines() if response.status_code < 400 else [])
+                if response.status_code in (401, 403):
+                    rules.disallow_all = True
+            except requests.RequestException:
+                rules.parse([])
+            self.robots[origin] = rules
+        return self.robots[origin]
+
+    def allowed(self, url):
+        return self.rules(url).can_fetch(USER_AGENT, url)
+
+    def get(self, url):
+        host = urlparse(url).netloc
+        robots = self.robots.get(f"{urlparse(url).scheme}://{host}")
+        delay = max(self.delay, (robots and robots.crawl_delay(USER_AGENT)) or 0)
+        wait = self.last_request.get(host, 0) + delay - time.monotonic()
+        if wait > 0:
+            time.sleep(wait)
+        try:
+            return self.session.get(url, timeout=self.timeout)
+        finally:
+            self.last_request[host] = time.monotonic()
+
+
+def in_scope(url, root):
+    parsed, start = urlparse(url), urlparse(root)
+    return (parsed.scheme in ("http", "https") and parsed.netloc == start.netloc
+            and parsed.path.startswith(start.path.rsplit("/", 1)[0] + "/"))
+
+
+def page_blocks(soup):
+    """The (section heading, text) of each block of a page's main content"""
+    main = soup.find("main") or soup.find("article") or soup.body or soup
+    blocks, section = [], None
+    for element in main.find_all(BLOCKS):
+        if element.find_parent(BLOCKS):
+            continue
+        text = " ".join(element.get_text(" ").split())
+        if not text:
+            continue
+        if element.name in HEADINGS:
+            section = text
+        else:
+            blocks.append((section, text))
+    if not blocks:
+        text = " ".join(main.get_text(" ").split())
+        blocks = [(None, text)] if text else []
+    return blocks
+
+
+def split_words(text, size):
+    pieces, piece = [], ""
+    for word in text.split(" "):
+        if piece and len(piece) + 1 + len(word) > size:
+            pieces.append(piece)
+            piece = ""
+        piece = f"{piece} {word}" if piece else word[:size]
+    return pieces + [piece] if piece else pieces
+
+
+def chunk_blocks(blocks, size):
+    """Joins consecutive blocks under the same heading into chunks of up to
+    size characters"""
+    chunks = []
+    for section, text in blocks:
+        for piece in split_words(text, size):
+            if chunks and chunks[-1][0] == section and len(chunks[-1][1]) + 1 + len(piece) <= size:
+                chunks[-1] = (section, chunks[-1][1] + "\n" + piece)
+            else:
+                chunks.append((section, piece))
+    return chunks
+
+
+def gather(target, emit=lambda field, items: None, checkpoint=None):
+    fetcher = PoliteSession(float(os.getenv("AGENT_CRAWL_DELAY", "1")), float(os.getenv("AGENT_TIMEOUT", "15")))
+    max_depth = int(os.getenv("AGENT_MAX_DEPTH", "2"))
+    max_pages = int(os.getenv("AGENT_MAX_PAGES", "50"))
+    chunk_size = int(os.getenv("AGENT_CHUNK_SIZE", "1500"))
+
+    root = urldefrag(target)[0]
+    queue, seen = deque([(root, 0)]), {root}
+    hashes, pages, chunks, duplicates, skipped = {}, [], [], [], []
+    state = checkpoint.load() if checkpoint else None
+    if state and state.get("root") == root:
+        queue, seen = deque(tuple(entry) for entry in state["queue"]), set(state["seen"])
+        hashes, pages, chunks = state["hashes"], state["pages"], state["chunks"]
+        duplicates, skipped = state["duplicates"], state["skipped"]
+        emit("chunks", chunks)
+    resumed = len(pages)
+
+    while queue and len(pages) < max_pages:
+        if checkpoint:
+            checkpoint.save({"root": root, "queue": list(queue), "seen": sorted(seen), "hashes": hashes,
+                             "pages": pages, "chunks": chunks, "duplicates": duplicates, "skipped": skipped})
+        url, depth = queue.popleft()
+        if not fetcher.allowed(url):
+            skipped.append({"url": url, "reason": "disallowed by robots.txt"})
+            continue
+        try:
+            response = fetcher.get(url)
+            response.raise_for_status()
+        except requests.RequestException as e:
+            skipped.append({"url": url, "reason": str(e)})
+            continue
+        if "html" not in response.headers.get("Content-Type", ""):
+            skipped.append({"url": url, "reason": f"not HTML: {response.headers.get('Content-Type')}"})
+            continue
+
+        soup = BeautifulSoup(response.text, "html.parser")
+        for tag in soup(["script", "style", "noscript", "nav", "header", "footer"]):
+            tag.decompose()
+        if depth < max_depth:
+            for anchor in soup.find_all("a", href=True):
+                link = urldefrag(urljoin(response.url, anchor["href"]))[0]
+                if link not in seen and in_scope(link, root):
+                    seen.add(link)
+                    queue.append((link, depth + 1))
+
+        blocks = page_blocks(soup)
+        content_hash = hashlib.sha256("\n".join(text for _, text in blocks).encode()).hexdigest()[:16]
+        if content_hash in hashes:
+            duplicates.append({"url": response.url, "duplicate_of": hashes[content_hash]})
+            continue
+        hashes[content_hash] = response.url
+
+        title = soup.title.get_text(strip=True) if soup.title else None
+        page_chunks = [{"url": response.url, "title": title, "section": section, "index": i, "text": text,
+                        "content_hash": content_hash}
+                       for i, (section, text) in enumerate(chunk_blocks(blocks, chunk_size))]
+        pages.append({"url": response.url, "title": title, "depth": depth, "content_hash": content_hash,
+                      "chunks": len(page_chunks)})
+        chunks.extend(page_chunks)
+        emit("chunks", page_chunks)
+
+    return {
+        "root": root,
+        "pages": pages,
+        "page_count": len(pages),
+        "chunks": chunks,
+        "chunk_count": len(chunks),
+        "duplicates": duplicates,
+        "skipped": skipped,
+        "unvisited": len(queue),
+        "resumed_pages": resumed,
+    }
+`
+
+const dbIntrospectorAgentPy = `"""Introspects a Postgres or MySQL database: its tables and views, their
+columns, keys and comments, and how many rows each table holds, so
+questions about the data model can be answered from context.
+
+The target is a URL such as postgresql://reader@db:5432/app or
+mysql://reader@db:3306/app. It must not carry the password, which the
+agent prints with its result; that comes from DB_PASSWORD or from the file
+AGENT_DB_PASSWORD_FILE (default /run/secrets/db_password, where Docker
+mounts secrets). The user can come from AGENT_DB_USER instead of the URL.
+The agent only reads: its session is read-only and every statement is
+bounded by AGENT_TIMEOUT seconds (default 15), but the credentials should be
+a read-only role's all the same.
+
+Postgres tables come from every schema but the system ones, or only those
+listed in AGENT_DB_SCHEMAS; MySQL tables from the target database. Tables
+estimated to hold fewer than AGENT_EXACT_COUNT_LIMIT rows (default 100000)
+are counted exactly; the row count of larger ones is the database's
+estimate. At most AGENT_MAX_TABLES tables are described (default 200), and
+each is streamed once described.
+"""
+import os
+from urllib.parse import unquote, urlparse
+
+POSTGRES_KINDS = {"r": "table", "p": "table", "v": "view", "m": "materialized_view", "f": "foreign_table"}
+POSTGRES_RELATIONS = """
+    FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+    WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f') AND NOT c.relispartition
+      AND n.nspname NOT IN ('information_schema', 'pg_catalog') AND n.nspname NOT LIKE 'pg\\_%'
+"""
+POSTGRES_QUERIES = {
+    "version": "SHOW server_version",
+    "tables": "SELECT n.nspname, c.relname, c.relkind, obj_description(c.oid, 'pg_class'), c.reltuples::bigint"
+              + POSTGRES_RELATIONS + "ORDER BY 1, 2",
+    "columns": """
+        SELECT n.nspname, c.relname, a.attname, format_type(a.atttypid, a.atttypmod), NOT a.attnotnull,
+               pg_get_expr(d.adbin, d.adrelid), col_description(c.oid, a.attnum)
+        FROM pg_attribute a
+        JOIN pg_class c ON c.oid = a.attrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+        LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+        WHERE a.attnum > 0 AND NOT a.attisdropped AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+          AND n.nspname NOT IN ('information_schema', 'pg_catalog') AND n.nspname NOT LIKE 'pg\\_%'
+        ORDER BY 1, 2, a.attnum
+    """,
+    # One row per key column: (schema, table, key, column, referenced schema, table, column)
+    "keys": """
+        SELECT n.nspname, c.relname, CASE con.contype WHEN 'p' THEN 'PRIMARY' ELSE con.conname END,
+               a.attname, fn.nspname, fc.relname, fa.attname
+        FROM pg_constraint con
+        CROSS JOIN LATERAL unnest(con.conkey, coalesce(con.confkey, con.conkey)) WITH ORDINALITY
+            AS k(attnum, fattnum, position)
+        JOIN pg_class c ON c.oid = con.conrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+        JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+        LEFT JOIN pg_class fc ON fc.oid = con.confrelid
+        LEFT JOIN pg_namespace fn ON fn.oid = fc.relnamespace
+        LEFT JOIN pg_attribute fa ON fa.attrelid = con.confrelid AND fa.attnum = k.fattnum
+        WHERE con.contype IN ('p', 'f') AND n.nspname NOT IN ('information_schema', 'pg_catalog')
+        ORDER BY 1, 2, 3, k.position
+    """,
+}
+MYSQL_QUERIES = {
+    "version": "SELECT VERSION()",
+    "tables": """
+        SELECT table_schema, table_name, table_type, table_comment, table_rows
+        FROM information_schema.tables WHERE table_schema = DATABASE() ORDER BY table_name
+    """,
+    "columns": """
+        SELECT table_schema, table_name, column_name, column_type, is_nullable = 'YES', column_default, column_comment
+        FROM information_schema.columns WHERE table_schema = DATABASE() ORDER BY table_name, ordinal_position
+    """,
+    "keys": """
+        SELECT table_schema, table_name, constraint_name, column_name,
+               referenced_table_schema, referenced_table_name, referenced_column_name
+        FROM information_schema.key_column_usage
+        WHERE table_schema = DATABASE() AND (constraint_name = 'PRIMARY' OR referenced_table_name IS NOT NULL)
+        ORDER BY table_name, constraint_name, ordinal_position
+    """,
+}
+
+
+def read_password():
+    password = os.getenv("DB_PASSWORD")
+    if password:
+        return password
+    path = os.getenv("AGENT_DB_PASSWORD_FILE", "/run/secrets/db_password")
+    try:
+        with open(path) as f:
+            return f.read().strip() or None
+    except OSError:
+        return None
+
+
+def connect(target):
+    url = urlparse(target)
+    if url.password is not None:
+        raise ValueError("the target must not carry a password; put it in DB_PASSWORD or a secret file")
+    user = os.getenv("AGENT_DB_USER") or (url.username and unquote(url.username))
+    database = unquote(url.path.lstrip("/")) or None
+    timeout = int(float(os.getenv("AGENT_TIMEOUT", "15")))
+
+    if url.scheme in ("postgres", "postgresql"):
+        import psycopg
+        options = f"-c default_transaction_read_only=on -c statement_timeout={timeout * 1000}"
+        conn = psycopg.connect(host=url.hostname, port=url.port or 5432, user=user, password=read_password(),
+                               dbname=database, connect_timeout=timeout, options=options, autocommit=True)
+        return "postgres", conn, POSTGRES_QUERIES
+    if url.scheme == "mysql":
+        import pymysql
+        if not database:
+            raise ValueError("a MySQL target must name its database, as in mysql://reader@db/app")
+        # ANSI_QUOTES lets table names be quoted the same way as in Postgres
+        conn = pymysql.connect(host=url.hostname, port=url.port or 3306, user=user, password=read_password() or "",
+                               database=database, connect_timeout=timeout, read_timeout=timeout,
+                               init_command="SET SESSION transaction_read_only = ON, "
+                                            "SESSION sql_mode = CONCAT(@@sql_mode, ',ANSI_QUOTES'), "
+                                            f"SESSION max_execution_time = {timeout * 1000}")
+        return "mysql", conn, MYSQL_QUERIES
+    raise ValueError(f"{target} is not a postgresql:// or mysql:// URL")
+
+
+def query(conn, sql):
+    with conn.cursor() as cursor:
+        cursor.execute(sql)
+        return cursor.fetchall()
+
+
+def quote(*names):
+    return ".".join('"' + name.replace('"', '""') + '"' for name in names)
+
+
+def table_kind(engine, kind):
+    if engine == "postgres":
+        return POSTGRES_KINDS.get(kind, kind)
+    return "view" if kind == "VIEW" else "table"
+
+
+def describe(conn, engine, queries):
+    """Every table with its columns and keys, keyed by (schema, name)"""
+    schemas = {s.strip() for s in os.getenv("AGENT_DB_SCHEMAS", "").split(",") if s.strip()}
+    tables = {}
+    for schema, name, kind, comment, estimate in query(conn, queries["tables"]):
+        if schemas and schema not in schemas:
+            continue
+        kind = table_kind(engine, kind)
+        # MySQL's comment on every view is just "VIEW"
+        tables[schema, name] = {"schema": schema, "name": name, "kind": kind,
+                                "comment": None if kind == "view" and engine == "mysql" else comment or None,
+                                "estimated_rows": estimate if kind != "view" and estimate is not None
+                                and estimate >= 0 else None,
+                                "columns": [], "primary_key": [], "foreign_keys": []}
+    for schema, name, column, data_type, nullable, default, comment in query(conn, queries["columns"]):
+        if (schema, name) in tables:
+            tables[schema, name]["columns"].append({"name": column, "type": data_type, "nullable": bool(nullable),
+                                                    "default": default, "comment": comment or None})
+    foreign_keys = {}
+    for schema, name, key, column, ref_schema, ref_table, ref_column in query(conn, queries["keys"]):
+        table = tables.get((schema, name))
+        if table is None:
+            continue
+        if key == "PRIMARY":
+            table["primary_key"].append(column)
+            continue
+        if (schema, name, key) not in foreign_keys:
+            foreign_keys[schema, name, key] = {"name": key, "columns": [], "references": f"{ref_schema}.{ref_table}",
+                                               "referenced_columns": []}
+            table["foreign_keys"].append(foreign_keys[schema, name, key])
+        foreign_keys[schema, name, key]["columns"].append(column)
+        foreign_keys[schema, name, key]["referenced_columns"].append(ref_column)
+    return tables
+
+
+def count_rows(conn, table, exact_limit):
+    estimate = table.pop("estimated_rows")
+    if table["kind"] != "table" or (estimate is not None and estimate >= exact_limit):
+        table["rows"], table["rows_exact"] = estimate, False
+        return
+    try:
+        table["rows"] = query(conn, f"SELECT count(*) FROM {quote(table['schema'], table['name'])}")[0][0]
+        table["rows_exact"] = True
+    except Exception as e:
+        # A table the role cannot read still has a shape worth knowing
+        table["rows"], table["rows_exact"], table["count_error"] = estimate, False, str(e).strip()
+
+
+def gather(target, emit=lambda field, items: None):
+    engine, conn, queries = connect(target)
+    try:
+        version = query(conn, queries["version"])[0][0]
+        tables = list(describe(conn, engine, queries).values())
+        described = tables[:int(os.getenv("AGENT_MAX_TABLES", "200"))]
+        exact_limit = int(os.getenv("AGENT_EXACT_COUNT_LIMIT", "100000"))
+        for table in described:
+            count_rows(conn, table, exact_limit)
+            emit("tables", [table])
+    finally:
+        conn.close()
+
+    url = urlparse(target)
+    return {
+        "engine": engine,
+        "server_version": version,
+        "host": url.hostname,
+        "database": url.path.lstrip("/") or None,
+        "tables": described,
+        "table_count": len(tables),
+        "truncated": len(tables) > len(described),
+        "foreign_key_count": sum(len(t["foreign_keys"]) for t in described),
+    }
+`
+
+const chatIngesterAgentPy = `"""Ingests recent conversations from Slack or Discord channels.
+
+The target is the platform and its channel IDs, as slack:C0123,C0456 or
+discord:1234,5678, or the platform alone to take the channels from
+AGENT_CHAT_CHANNELS. The bot token comes from SLACK_BOT_TOKEN or
+DISCORD_BOT_TOKEN, or from /run/secrets/slack_bot_token or
+/run/secrets/discord_bot_token where Docker mounts secrets.
+
+Messages from the last AGENT_CHAT_SINCE_HOURS hours (default 24), at most
+AGENT_MAX_MESSAGES a channel (default 200), are grouped into conversations:
+a Slack thread or a Discord thread or reply chain, or a message nobody
+answered. Noise is stripped on the way: joins, leaves and other system
+messages, bots, bare acknowledgements such as "thanks" or "+1", emoji, and
+a sender repeating themselves. Mentions, channel links and URLs are
+rewritten as plain text.
+
+Each channel's conversations are streamed, so they reach the session's
+memory through the MCP server as they are read. With KNOWLEDGE_GRAPH_URL
+set they also become conversation nodes in the knowledge graph, posted to
+its /ingest endpoint and tagged with the session, so erasing the session
+erases them too. With EVENT_BUS_URL set the nodes are published on the
+event bus instead. Conversations the last run already sent, unchanged, are
+not sent again; a thread with a new reply is.
+"""
+import json
+import os
+import re
+import time
+from datetime import datetime, timedelta, timezone
+
+import requests
+
+import rbac
+import tracing
+
+PLATFORMS = {
+    "slack": ("SLACK_BOT_TOKEN", "/run/secrets/slack_bot_token", "AGENT_SLACK_API", "https://slack.com/api"),
+    "discord": ("DISCORD_BOT_TOKEN", "/run/secrets/discord_bot_token", "AGENT_DISCORD_API",
+                "https://discord.com/api/v10"),
+}
+# Slack subtypes that are people talking; the rest are system messages
+SLACK_SUBTYPES = (None, "thread_broadcast", "file_share", "me_message")
+# Discord message types: default and reply
+DISCORD_TYPES = (0, 19)
+ACKNOWLEDGEMENTS = {"ok", "okay", "k", "thanks", "thank you", "thx", "ty", "+1", "lol", "nice", "cool", "yes", "no",
+                    "yep", "nope", "done", "same", "agreed", "great", "np"}
+EMOJI = re.compile(r":[a-z0-9_+-]+:|<a?:\w+:\d+>|[\U0001F000-\U0001FAFF\u2600-\u27BF\uFE0F]")
+
+
+class ChatAPI:
+    """A platform's web API, waiting out its rate limits"""
+
+    def __init__(self, base, auth):
+        self.base, self.session = base.rstrip("/"), requests.Session()
+        self.session.headers["Authorization"] = auth
+
+    def get(self, path, **params):
+        for attempt in range(4):
+            response = self.session.get(f"{self.base}/{path}", params=params, timeout=30)
+            if response.status_code != 429 or attempt == 3:
+                break
+            time.sleep(min(float(response.headers.get("Retry-After", "1")), 60))
+        response.raise_for_status()
+        return response.json()
+
+
+def read_token(platform):
+    variable, path = PLATFORMS[platform][:2]
+    token = os.getenv(variable)
+    if token:
+        return token.strip()
+    try:
+        with open(path) as f:
+            return f.read().strip()
+    except OSError:
+        raise ValueError(f"{platform} needs a bot token in {variable} or {path}") from None
+
+
+def parse_target(target):
+    platform, _, channels = target.partition(":")
+    if platform not in PLATFORMS:
+        raise ValueError(f"{target} is not slack:CHANNELS or discord:CHANNELS")
+    channels = [c.strip() for c in (channels or os.getenv("AGENT_CHAT_CHANNELS", "")).split(",") if c.strip()]
+    if not channels:
+        raise ValueError(f"no {platform} channels in the target or AGENT_CHAT_CHANNELS")
+    return platform, channels
+
+
+def is_noise(text):
+    return not text or text.lower().strip(" .!") in ACKNOWLEDGEMENTS
+
+
+def clean(text):
+    return " ".join(EMOJI.sub("", text).split())
+
+
+class Slack:
+    def __init__(self, token):
+        self.api, self.names = ChatAPI(os.getenv(PLATFORMS["slack"][2], PLATFORMS["slack"][3]), f"Bearer {token}"), {}
+
+    def call(self, method, **params):
+        data = self.api.get(method, **params)
+        if not data.get("ok"):
+            raise requests.HTTPError(f"Slack {method}: {data.get('error', 'not ok')}")
+        return data
+
+    def user(self, user_id):
+        if user_id not in self.names:
+            try:
+                profile = self.call("users.info", user=user_id)["user"]
+                self.names[user_id] = profile.get("real_name") or profile.get("name") or user_id
+            except requests.RequestException:
+                self.names[user_id] = user_id
+        return self.names[user_id]
+
+    def text(self, text):
+        text = re.sub(r"<@(\w+)(?:\|[^>]*)?>", lambda m: "@" + self.user(m.group(1)), text)
+        text = re.sub(r"<#\w+\|([^>]*)>", r"#\1", text)
+        text = re.sub(r"<!(\w+)(?:\|[^>]*)?>", r"@\1", text)
+        text = re.sub(r"<(https?://[^|>]+)\|([^>]+)>", r"\2 (\1)", text)
+        text = re.sub(r"<(https?://[^>]+)>", r"\1", text)
+        return clean(text.replace("&lt;", "<").replace("&gt;", ">").replace("&amp;", "&"))
+
+    def message(self, raw):
+        if raw.get("subtype") not in SLACK_SUBTYPES or raw.get("bot_id"):
+            return None
+        return {"author": self.user(raw.get("user", "")), "author_id": raw.get("user"),
+                "timestamp": datetime.fromtimestamp(float(raw["ts"]), timezone.utc).isoformat(),
+                "text": self.text(raw.get("text", ""))}
+
+    def channel(self, channel_id, since, limit):
+        """The channel's name and its conversations, each a list of raw
+        messages, oldest first"""
+        try:
+            name = self.call("conversations.info", channel=channel_id)["channel"].get("name")
+        except requests.RequestException:
+            name = None
+        history, cursor = [], None
+        while len(history) < limit:
+            page = self.call("conversations.history", channel=channel_id, oldest=f"{since.timestamp():.6f}",
+                             limit=min(200, limit - len(history)), **({"cursor": cursor} if cursor else {}))
+            history.extend(page.get("messages", []))
+            cursor = (page.get("response_metadata") or {}).get("next_cursor")
+            if not page.get("has_more") or not cursor:
+                break
+        threads = []
+        for raw in sorted(history, key=lambda m: float(m["ts"])):
+            if raw.get("thread_ts") == raw["ts"] and raw.get("reply_count"):
+                replies = self.call("conversations.replies", channel=channel_id, ts=raw["ts"], limit=limit)
+                threads.append((raw["ts"], replies.get("messages", [raw])))
+            elif raw.get("thread_ts") in (None, raw["ts"]):
+                threads.append((raw["ts"], [raw]))
+        return name, threads
+
+
+class Discord:
+    def __init__(self, token):
+        self.api = ChatAPI(os.getenv(PLATFORMS["discord"][2], PLATFORMS["discord"][3]), f"Bot {token}")
+
+    def messages(self, channel_id, since, limit):
+        messages, before = [], None
+        while len(messages) < limit:
+            page = self.api.get(f"channels/{channel_id}/messages", limit=min(100, limit - len(messages)),
+                                **({"before": before} if before else {}))
+            recent = [m for m in page if datetime.fromisoformat(m["timestamp"]) >= since]
+            messages.extend(recent)
+            if len(recent) < len(page) or not page:
+                break
+            before = page[-1]["id"]
+        return sorted(messages, key=lambda m: m["timestamp"])
+
+    def message(self, raw):
+        author = raw.get("author") or {}
+        if raw.get("type", 0) not in DISCORD_TYPES or author.get("bot"):
+            return None
+        text = raw.get("content", "")
+        for mention in raw.get("mentions", []):
+            name = mention.get("global_name") or mention.get("username")
+            text = re.sub(rf"<@!?{mention['id']}>", "@" + name, text)
+        text = re.sub(r"<#(\d+)>", r"#\1", text)
+        return {"author": author.get("global_name") or author.get("username"), "author_id": author.get("id"),
+                "timestamp": raw["timestamp"], "text": clean(text)}
+
+    def channel(self, channel_id, since, limit):
+        try:
+            name = self.api.get(f"channels/{channel_id}").get("name")
+        except requests.RequestException:
+            name = None
+        threads, root = {}, {}
+        for raw in self.messages(channel_id, since, limit):
+            # A reply joins the conversation of the message it answers
+            parent = (raw.get("message_reference") or {}).get("message_id")
+            root[raw["id"]] = root.get(parent, raw["id"])
+            threads.setdefault(root[raw["id"]], []).append(raw)
+            if raw.get("thread"):
+                threads[root[raw["id"]]].extend(self.messages(raw["thread"]["id"], since, limit))
+        return name, list(threads.items())
+
+
+def conversation(client, platform, channel_id, channel_name, thread_id, raw_messages):
+    messages = []
+    for raw in raw_messages:
+        message = client.message(raw)
+        if message is None or is_noise(message["text"]):
+            continue
+        if messages and (messages[-1]["author_id"], messages[-1]["text"]) == (message["author_id"], message["text"]):
+            continue
+        messages.append(message)
+    if not messages:
+        return None
+    return {"platform": platform, "channel": channel_id, "channel_name": channel_name, "thread_id": thread_id,
+            "started_at": messages[0]["timestamp"], "last_at": messages[-1]["timestamp"],
+            "participants": sorted({m["author"] for m in messages if m["author"]}), "messages": messages}
+
+
+def graph_node(conv):
+    where = f"#{conv['channel_name'] or conv['channel']} on {conv['platform'].title()}"
+    data = {"type": "conversation", "platform": conv["platform"], "channel": conv["channel"],
+            "thread_id": conv["thread_id"], "participants": conv["participants"],
+            "content": f"Conversation in {where}:\n" + "\n".join(f"{m['author']}: {m['text']}" for m in conv["messages"]),
+            "metadata": {"source": "chat_ingester", "started_at": conv["started_at"], "last_at": conv["last_at"]}}
+    session_id = os.getenv("AGENT_SESSION_ID")
+    if session_id:
+        data["session_id"] = session_id
+    return {"data": data, "valid_from": conv["started_at"]}
+
+
+def store_in_graph(conversations, submitted=None):
+    url, bus = os.getenv("KNOWLEDGE_GRAPH_URL"), os.getenv("EVENT_BUS_URL")
+    if not url and not bus:
+        return {"skipped": "KNOWLEDGE_GRAPH_URL is not set"}
+    nodes = [graph_node(conv) for conv in conversations]
+    fresh = submitted.fresh("graph", nodes) if submitted else nodes
+    if not fresh:
+        return {"nodes": 0, "unchanged": len(nodes)}
+    if bus:
+        import event_bus
+        try:
+            event_bus.publish(event_bus.SUBJECT_NODES, "chat_ingester", fresh, bus)
+            return {"nodes": len(fresh), "unchanged": len(nodes) - len(fresh), "published": True}
+        except (OSError, ConnectionError) as e:
+            if submitted:
+                submitted.unsent("graph")
+            return {"nodes": 0, "error": str(e)}
+    body = "\n".join(json.dumps(node) for node in fresh)
+    try:
+        response = requests.post(f"{url.rstrip('/')}/ingest", data=body.encode(), timeout=30,
+                                 headers={"Content-Type": "application/x-ndjson", **rbac.headers(),
+                                          **tracing.headers()})
+        response.raise_for_status()
+        return {"nodes": len(fresh), "unchanged": len(nodes) - len(fresh), "ingest": response.json()}
+    except requests.RequestException as e:
+        # The conversations still reach session memory, and the next run
+        # sends them again
+        if submitted:
+            submitted.unsent("graph")
+        return {"nodes": 0, "error": str(e)}
+
+
+def gather(target, emit=lambda field, items: None, submitted=None):
+    platform, channels = parse_target(target)
+    client = (Slack if platform == "slack" else Discord)(read_token(platform))
+    since = datetime.now(timezone.utc) - timedelta(hours=float(os.getenv("AGENT_CHAT_SINCE_HOURS", "24")))
+    limit = int(os.getenv("AGENT_MAX_MESSAGES", "200"))
+
+    conversations, stats = [], []
+    for channel_id in channels:
+        name, threads = client.channel(channel_id, since, limit)
+        found = [c for c in (conversation(client, platform, channel_id, name, thread_id, raw)
+                             for thread_id, raw in threads) if c]
+        read = sum(len(raw) for _, raw in threads)
+        kept = sum(len(c["messages"]) for c in found)
+        stats.append({"channel": channel_id, "name": name, "messages_read": read, "messages_kept": kept,
+                      "conversations": len(found)})
+        conversations.extend(found)
+        emit("conversations", found)
+
+    return {
+        "platform": platform,
+        "since": since.isoformat(),
+        "channels": stats,
+        "conversations": conversations,
+        "conversation_count": len(conversations),
+        "graph": store_in_graph(conversations, submitted),
+    }
+`
+
+const issueTrackerAgentPy = `"""Syncs the open issues of a Jira project, a Linear team or a GitHub
+repository into the knowledge graph.
+
+The target is the tracker and what to sync from it: github:owner/repo,
+jira:PROJECT (on the site at AGENT_JIRA_URL) or linear:TEAM. Credentials
+come from GITHUB_TOKEN, JIRA_TOKEN (with JIRA_EMAIL for Jira Cloud's basic
+auth) or LINEAR_API_KEY, or from a file of the same name in lower case under
+/run/secrets, where Docker mounts secrets.
+
+Each open issue becomes an "issue" node with its title, description,
+status, labels and assignees, linked through "references" to the node of
+its repository (GitHub) and to a "service" node for each of its Jira
+components, its Linear project and its labels starting with
+AGENT_SERVICE_LABEL_PREFIX (default "service:"). Nodes go to the graph
+service at KNOWLEDGE_GRAPH_URL, or with EVENT_BUS_URL set are published on
+the event bus once the issues are read; without either they are only
+returned.
+
+Syncs are incremental. With AGENT_STATE_DIR set, as the orchestrator does
+for agent types with state, the agent keeps a cursor per target, the time
+of the last issue update it saw, and the node of each issue it synced. The
+next run asks only for issues updated since the cursor: an issue that
+changed gets a new node and its old one is invalidated, and an issue that
+was closed has its node invalidated. AGENT_UPDATED_SINCE overrides the
+cursor; a run with no cursor syncs every open issue.
+"""
+import base64
+import hashlib
+import json
+import os
+import re
+from datetime import datetime, timezone
+
+import requests
+
+import rbac
+import tracing
+
+TRACKERS = ("github", "jira", "linear")
+STATE_FILE = "issue_tracker.json"
+LINEAR_QUERY = """
+query Issues($team: String!, $since: DateTimeOrDuration, $after: String) {
+  issues(first: 100, after: $after, orderBy: updatedAt,
+         filter: {team: {key: {eq: $team}}, updatedAt: {gte: $since}}) {
+    nodes {
+      identifier title description url updatedAt
+      state { name type }
+      labels { nodes { name } }
+      assignee { name }
+      project { name }
+    }
+    pageInfo { hasNextPage endCursor }
+  }
+}
+"""
+
+
+def secret(name):
+    value = os.getenv(name)
+    if value:
+        return value.strip()
+    try:
+        with open(f"/run/secrets/{name.lower()}") as f:
+            return f.read().strip() or None
+    except OSError:
+        return None
+
+
+def parse_target(target):
+    tracker, _, scope = target.partition(":")
+    if tracker not in TRACKERS or not scope:
+        raise ValueError(f"{target} is not github:owner/repo, jira:PROJECT or linear:TEAM")
+    if tracker == "github" and not re.fullmatch(r"[\w.-]+/[\w.-]+", scope):
+        raise ValueError(f"{scope} is not a GitHub owner/repo")
+    return tracker, scope
+
+
+def utc(timestamp):
+    """An ISO 8601 time in UTC, so cursors from one tracker compare as strings"""
+    parsed = datetime.fromisoformat(timestamp.replace("Z", "+00:00"))
+    return parsed.astimezone(timezone.utc).isoformat(timespec="seconds")
+
+
+def issue(tracker, key, title, description, status, is_open, labels, assignees, url, updated_at, repository=None,
+          services=()):
+    return {"tracker": tracker, "key": key, "title": title, "description": description, "status": status,
+            "open": is_open, "labels": sorted(labels), "assignees": sorted(a for a in assignees if a), "url": url,
+            "updated_at": utc(updated_at), "repository": repository, "services": sorted(set(services))}
+
+
+def label_services(labels):
+    prefix = os.getenv("AGENT_SERVICE_LABEL_PREFIX", "service:")
+    return [label[len(prefix):].strip() for label in labels if prefix and label.startswith(prefix)]
+
+
+def github_issues(repo, since):
+    """Issues, oldest update first; with since, closed ones too"""
+    api = os.getenv("AGENT_GITHUB_API", "https://api.github.com").rstrip("/")
+    headers = {"Accept": "application/vnd.github+json"}
+    token = secret("GITHUB_TOKEN")
+    if token:
+        headers["Authorization"] = f"Bearer {token}"
+    params = {"state": "all" if since else "open", "sort": "updated", "direction": "asc", "per_page": 100}
+    if since:
+        params["since"] = since
+    url = f"{api}/repos/{repo}/issues"
+    while url:
+        response = requests.get(url, headers=headers, params=params, timeout=30)
+        response.raise_for_status()
+        for raw in response.json():
+            if "pull_request" in raw:
+                continue
+            labels = [label["name"] for label in raw.get("labels", [])]
+            yield issue("github", f"{repo}#{raw['number']}", raw["title"], raw.get("body"), raw["state"],
+                        raw["state"] == "open", labels, [a["login"] for a in raw.get("assignees", [])],
+                        raw.get("html_url"), raw["updated_at"], repository=repo, services=label_services(labels))
+        url, params = response.links.get("next", {}).get("url"), None
+
+
+def jira_issues(project, since):
+    base = os.getenv("AGENT_JIRA_URL", "").rstrip("/")
+    if not base:
+        raise ValueError("Jira targets need AGENT_JIRA_URL, such as https://example.atlassian.net")
+    token, email = secret("JIRA_TOKEN"), secret("JIRA_EMAIL")
+    headers = {"Accept": "application/json"}
+    if token and email:
+        headers["Authorization"] = "Basic " + base64.b64encode(f"{email}:{token}".encode()).decode()
+    elif token:
+        headers["Authorization"] = f"Bearer {token}"
+    jql = f'project = "{project}"'
+    if since:
+        # JQL reads absolute times in the user's time zone, so ask relatively;
+        # it counts in whole minutes, so the boundary minute is read again
+        minutes = (datetime.now(timezone.utc) - datetime.fromisoformat(since)).total_seconds() // 60 + 1
+        jql += f" AND updated >= -{int(minutes)}m"
+    else:
+        jql += " AND statusCategory != Done"
+    start = 0
+    while True:
+        response = requests.get(f"{base}/rest/api/2/search", headers=headers, timeout=30, params={
+            "jql": jql + " ORDER BY updated ASC", "startAt": start, "maxResults": 100,
+            "fields": "summary,description,status,labels,assignee,components,updated"})
+        response.raise_for_status()
+        page = response.json()
+        for raw in page.get("issues", []):
+            fields = raw["fields"]
+            status = fields.get("status") or {}
+            labels = fields.get("labels") or []
+            components = [c["name"] for c in fields.get("components") or []]
+            yield issue("jira", raw["key"], fields.get("summary"), fields.get("description"), status.get("name"),
+                        (status.get("statusCategory") or {}).get("key") != "done", labels,
+                        [(fields.get("assignee") or {}).get("displayName")], f"{base}/browse/{raw['key']}",
+                        fields.get("updated"), services=components + label_services(labels))
+        start += len(page.get("issues", []))
+        if not page.get("issues") or start >= page.get("total", 0):
+            return
+
+
+def linear_issues(team, since):
+    api = os.getenv("AGENT_LINEAR_API", "https://api.linear.app/graphql")
+    key = secret("LINEAR_API_KEY")
+    if not key:
+        raise ValueError("Linear targets need LINEAR_API_KEY")
+    after = None
+    while True:
+        response = requests.post(api, headers={"Authorization": key}, timeout=30, json={
+            "query": LINEAR_QUERY, "variables": {"team": team, "since": since or "1970-01-01T00:00:00Z",
+                                                 "after": after}})
+        response.raise_for_status()
+        body = response.json()
+        if body.get("errors"):
+            raise requests.HTTPError(f"Linear: {body['errors'][0].get('message')}")
+        issues = body["data"]["issues"]
+        for raw in issues["nodes"]:
+            state = raw.get("state") or {}
+            is_open = state.get("type") not in ("completed", "canceled")
+            if not since and not is_open:
+                continue
+            labels = [label["name"] for label in raw["labels"]["nodes"]]
+            project = (raw.get("project") or {}).get("name")
+            yield issue("linear", raw["identifier"], raw["title"], raw.get("description"), state.get("name"), is_open,
+                        labels, [(raw.get("assignee") or {}).get("name")], raw.get("url"), raw["updatedAt"],
+                        services=([project] if project else []) + label_services(labels))
+        if not issues["pageInfo"]["hasNextPage"]:
+            return
+        after = issues["pageInfo"]["endCursor"]
+
+
+def node_id(data):
+    """The ID the knowledge graph service gives a node with this data"""
+    return hashlib.md5(json.dumps(data, sort_keys=True).encode()).hexdigest()[:12]
+
+
+class Graph:
+    """The knowledge graph service, the event bus, or a stand-in that only
+    computes node IDs when there is neither. Nodes published on the bus get
+    the ID the service would give them, so one it merges into a
+    near-duplicate keeps its own ID here."""
+
+    def __init__(self, url, bus=None):
+        self.url = url and url.rstrip("/")
+        self.bus = bus
+        self.added = self.invalidated = 0
+        self.nodes, self.invalidations = [], []
+
+    def add(self, data, valid_from=None):
+        if self.bus:
+            self.nodes.append({"data": data, "valid_from": valid_from})
+            self.added += 1
+            return node_id(data)
+        if not self.url:
+            return node_id(data)
+        response = requests.post(f"{self.url}/nodes", json={"data": data, "valid_from": valid_from}, timeout=30,
+                                 headers={**rbac.headers(), **tracing.headers()})
+        response.raise_for_status()
+        self.added += 1
+        # A merged node answers with the ID of the node it joined
+        return response.json().get("node_id") or node_id(data)
+
+    def invalidate(self, old_id):
+        if self.bus:
+            self.invalidations.append({"node_id": old_id})
+            self.invalidated += 1
+            return
+        if not self.url:
+            return
+        response = requests.post(f"{self.url}/nodes/{old_id}/invalidate", json={}, timeout=30,
+                                 headers={**rbac.headers(), **tracing.headers()})
+        if response.status_code != 404:
+            response.raise_for_status()
+            self.invalidated += 1
+
+    def publish(self):
+        """Publishes the nodes added and invalidated on the bus"""
+        if not self.bus:
+            return
+        import event_bus
+        if self.nodes:
+            event_bus.publish(event_bus.SUBJECT_NODES, "issue_tracker", self.nodes, self.bus)
+        if self.invalidations:
+            event_bus.publish(event_bus.SUBJECT_INVALIDATIONS, "issue_tracker", self.invalidations, self.bus)
+
+
+def issue_node(item, references):
+    content = item["title"] or ""
+    if item["description"]:
+        content += "\n\n" + item["description"][:int(os.getenv("AGENT_MAX_DESCRIPTION", "2000"))]
+    return {"type": "issue", "tracker": item["tracker"], "key": item["key"], "title": item["title"],
+            "content": content, "status": item["status"], "labels": item["labels"], "assignees": item["assignees"],
+            "url": item["url"], "updated_at": item["updated_at"], "references": references,
+            "metadata": {"source": "issue_tracker"}}
+
+
+def load_state(path):
+    try:
+        with open(path) as f:
+            return json.load(f)
+    except (OSError, ValueError):
+        return {}
+
+
+def save_state(path, state):
+    tmp = path + ".tmp"
+    with open(tmp, "w") as f:
+        json.dump(state, f, indent=2)
+    os.replace(tmp, path)
+
+
+def gather(target, emit=lambda field, items: None):
+    tracker, scope = parse_target(target)
+    state_dir = os.getenv("AGENT_STATE_DIR")
+    state_path = state_dir and os.path.join(state_dir, STATE_FILE)
+    state = load_state(state_path) if state_path else {}
+    synced = state.get(target, {"cursor": None, "nodes": {}})
+    since = os.getenv("AGENT_UPDATED_SINCE") or synced["cursor"]
+
+    graph = Graph(os.getenv("KNOWLEDGE_GRAPH_URL"), os.getenv("EVENT_BUS_URL"))
+    linked = {}
+
+    def link(data):
+        key = json.dumps(data, sort_keys=True)
+        if key not in linked:
+            linked[key] = graph.add(data)
+        return linked[key]
+
+    fetch = {"github": github_issues, "jira": jira_issues, "linear": linear_issues}[tracker]
+    opened, updated, closed, nodes = [], 0, [], []
+    cursor = synced["cursor"]
+    for item in fetch(scope, since):
+        cursor = max(cursor or item["updated_at"], item["updated_at"])
+        old_id = synced["nodes"].pop(item["key"], None)
+        if not item["open"]:
+            if old_id:
+                graph.invalidate(old_id)
+                closed.append(item["key"])
+            continue
+        references = []
+        if item["repository"]:
+            references.append(link({"type": "repository", "repository": item["repository"],
+                                     "content": f"GitHub repository {item['repository']}"}))
+        references += [link({"type": "service", "service": name, "content": f"Service {name}"})
+                       for name in item["services"]]
+        data = issue_node(item, references)
+        new_id = graph.add(data, item["updated_at"])
+        if old_id and old_id != new_id:
+            graph.invalidate(old_id)
+            updated += 1
+        synced["nodes"][item["key"]] = new_id
+        nodes.append({"node_id": new_id, "data": data})
+        opened.append(item)
+        emit("issues", [item])
+
+    # Before the state, so a run that could not publish is done again
+    graph.publish()
+    if state_path:
+        state[target] = {"cursor": cursor, "nodes": synced["nodes"]}
+        save_state(state_path, state)
+
+    return {
+        "tracker": tracker,
+        "scope": scope,
+        "since": since,
+        "cursor": cursor,
+        "incremental": since is not None,
+        "issues": opened,
+        "issue_count": len(opened),
+        "updated": updated,
+        "closed": closed,
+        "tracked": len(synced["nodes"]),
+        "nodes": nodes,
+        "graph": {"added": graph.added, "invalidated": graph.invalidated, "published": bool(graph.bus)}
+        if graph.url or graph.bus else {"skipped": "KNOWLEDGE_GRAPH_URL is not set"},
+    }
+`
+
+const headlessBrowserAgentPy = `"""Renders web pages in headless Chromium, for sites whose content only
+appears once their JavaScript runs or that need a login first: the title,
+description, headings, links and text of each rendered page, with a
+screenshot of it as evidence.
+
+A page is captured once it has loaded as far as AGENT_WAIT_UNTIL says
+(load, domcontentloaded or networkidle, the default) and, when it is set,
+the CSS selector AGENT_WAIT_FOR has appeared. AGENT_MAX_PAGES (default 1)
+renders more pages, following links breadth first on the target's host.
+AGENT_TIMEOUT bounds each page in seconds (default 30), AGENT_MAX_LINKS the
+links kept per page (default 50) and AGENT_MAX_TEXT the characters of text
+per page (default 5000).
+
+AGENT_LOGIN_STEPS is a JSON list of steps the browser takes before it
+loads the target, each one action:
+
+  {"goto": URL}
+  {"fill": SELECTOR, "value": TEXT}
+  {"click": SELECTOR}
+  {"press": SELECTOR, "key": KEY}
+  {"wait_for": SELECTOR}
+  {"wait_for_url": GLOB}
+
+Values can name environment variables as ${NAME}, so credentials come from
+the agent's secrets, such as BROWSER_USERNAME and BROWSER_PASSWORD, rather
+than from the steps, and never reach the context.
+
+Each page is streamed as a "pages" item whose "evidence" is a JPEG
+screenshot, base64-encoded, of the viewport or, with AGENT_SCREENSHOT=full,
+of the whole page; AGENT_SCREENSHOT=off leaves screenshots out. One larger
+than AGENT_SCREENSHOT_MAX_BYTES (default 500000) is taken again at lower
+quality, and left out if it is still too large.
+"""
+import base64
+import hashlib
+import json
+import os
+import re
+from collections import deque
+from datetime import datetime, timezone
+from urllib.parse import urldefrag, urlparse
+
+from playwright.sync_api import Error as PlaywrightError
+from playwright.sync_api import sync_playwright
+
+USER_AGENT = "dynamic-context-micro-agent/2.0"
+ACTIONS = ("goto", "fill", "click", "press", "wait_for", "wait_for_url")
+VARIABLE = re.compile(r"\$\{(\w+)\}")
+
+# Runs in the page once it has rendered
+EXTRACT = """() => {
+  const description = document.querySelector('meta[name="description"]');
+  const headings = [...document.querySelectorAll('h1, h2, h3')].map(h => ({
+    level: Number(h.tagName[1]), text: h.innerText.trim()}));
+  const links = [...document.querySelectorAll('a[href]')].map(a => a.href);
+  return {title: document.title || null, description: description ? description.content : null,
+          headings, links, text: document.body ? document.body.innerText : ''};
+}"""
+
+
+def expand(value):
+    """value with each ${NAME} replaced by that environment variable"""
+    def variable(match):
+        if match.group(1) not in os.environ:
+            raise ValueError(f"AGENT_LOGIN_STEPS names {match.group(1)}, which is not set")
+        return os.environ[match.group(1)]
+    return VARIABLE.sub(variable, value)
+
+
+def login_steps():
+    raw = os.getenv("AGENT_LOGIN_STEPS")
+    if not raw:
+        return []
+    try:
+        steps = json.loads(raw)
+    except json.JSONDecodeError as e:
+        raise ValueError(f"AGENT_LOGIN_STEPS is not JSON: {e}")
+    if not isinstance(steps, list):
+        raise ValueError("AGENT_LOGIN_STEPS must be a list of steps")
+    for i, step in enumerate(steps):
+        actions = [a for a in ACTIONS if isinstance(step, dict) and a in step]
+        if len(actions) != 1:
+            raise ValueError(f"login step {i + 1} must have exactly one of {', '.join(ACTIONS)}")
+    return steps
+
+
+def log_in(page, steps, timeout):
+    """Takes the login steps, saying which one failed without its values"""
+    for i, step in enumerate(steps):
+        action = next(a for a in ACTIONS if a in step)
+        try:
+            if action == "goto":
+                page.goto(expand(step["goto"]), timeout=timeout)
+            elif action == "fill":
+                page.fill(step["fill"], expand(step.get("value", "")), timeout=timeout)
+            elif action == "click":
+                page.click(step["click"], timeout=timeout)
+            elif action == "press":
+                page.press(step["press"], step.get("key", "Enter"), timeout=timeout)
+            elif action == "wait_for":
+                page.wait_for_selector(step["wait_for"], timeout=timeout)
+            else:
+                page.wait_for_url(step["wait_for_url"], timeout=timeout)
+        except PlaywrightError as e:
+            message = str(e).splitlines()[0] if str(e) else type(e).__name__
+            raise RuntimeError(f"login step {i + 1} ({action}) failed at {page.url}: {message}")
+    print(f"🔑 Logged in after {len(steps)} steps, at {page.url}")
+
+
+def screenshot(page, mode, max_bytes):
+    """The page's screenshot as evidence, or None"""
+    if mode == "off":
+        return None
+    taken_at = datetime.now(timezone.utc).isoformat()
+    for quality in (70, 40):
+        image = page.screenshot(type="jpeg", quality=quality, full_page=mode == "full")
+        if len(image) <= max_bytes:
+            return {"content_type": "image/jpeg", "full_page": mode == "full", "taken_at": taken_at,
+                    "sha256": hashlib.sha256(image).hexdigest(), "bytes": len(image),
+                    "data": base64.b64encode(image).decode()}
+    print(f"⚠️ Screenshot of {page.url} is larger than {max_bytes} bytes; leaving it out")
+    return {"content_type": "image/jpeg", "omitted": f"larger than {max_bytes} bytes", "taken_at": taken_at}
+
+
+def gather(target, emit=lambda field, items: None):
+    if not target.startswith(("http://", "https://")):
+        raise ValueError(f"headless_browser needs an http(s) URL, got {target!r}")
+    steps = login_steps()
+    wait_until = os.getenv("AGENT_WAIT_UNTIL", "networkidle")
+    if wait_until not in ("load", "domcontentloaded", "networkidle"):
+        raise ValueError(f"AGENT_WAIT_UNTIL must be load, domcontentloaded or networkidle, got {wait_until!r}")
+    mode = os.getenv("AGENT_SCREENSHOT", "viewport")
+    if mode not in ("viewport", "full", "off"):
+        raise ValueError(f"AGENT_SCREENSHOT must be viewport, full or off, got {mode!r}")
+    timeout = float(os.getenv("AGENT_TIMEOUT", "30")) * 1000
+    wait_for = os.getenv("AGENT_WAIT_FOR")
+    max_pages = int(os.getenv("AGENT_MAX_PAGES", "1"))
+    max_links = int(os.getenv("AGENT_MAX_LINKS", "50"))
+    max_text = int(os.getenv("AGENT_MAX_TEXT", "5000"))
+    max_bytes = int(os.getenv("AGENT_SCREENSHOT_MAX_BYTES", "500000"))
+    host = urlparse(target).netloc
+
+    pages, failed = [], []
+    with sync_playwright() as playwright:
+        # /dev/shm is small in containers, so Chromium keeps to /tmp
+        browser = playwright.chromium.launch(args=["--disable-dev-shm-usage"])
+        try:
+            page = browser.new_context(user_agent=USER_AGENT, viewport={"width": 1280, "height": 800}).new_page()
+            if steps:
+                log_in(page, steps, timeout)
+            queue, seen = deque([target]), {target}
+            while queue and len(pages) < max_pages:
+                url = queue.popleft()
+                try:
+                    response = page.goto(url, wait_until=wait_until, timeout=timeout)
+                    if wait_for:
+                        page.wait_for_selector(wait_for, timeout=timeout)
+                except PlaywrightError as e:
+                    if url == target:
+                        raise RuntimeError(f"could not render {url}: {str(e).splitlines()[0]}")
+                    failed.append({"url": url, "error": str(e).splitlines()[0]})
+                    continue
+                found = page.evaluate(EXTRACT)
+                links = []
+                for link in found["links"]:
+                    link = urldefrag(link)[0]
+                    if link.startswith(("http://", "https://")) and link not in links:
+                        links.append(link)
+                text = " ".join(found["text"].split())
+                rendered = {
+                    "url": page.url,
+                    "status_code": response.status if response else None,
+                    "title": found["title"],
+                    "description": found["description"],
+                    "headings": [h for h in found["headings"] if h["text"]][:50],
+                    "links": links[:max_links],
+                    "link_count": len(links),
+                    "text": text[:max_text],
+                    "truncated": len(text) > max_text,
+                    "evidence": screenshot(page, mode, max_bytes),
+                }
+                pages.append(rendered)
+                emit("pages", [rendered])
+                print(f"🖥️ Rendered {page.url}")
+                for link in links:
+                    if urlparse(link).netloc == host and link not in seen:
+                        seen.add(link)
+                        queue.append(link)
+        finally:
+            browser.close()
+
+    first = pages[0]
+    return {
+        "url": first["url"],
+        "title": first["title"],
+        "description": first["description"],
+        "logged_in": bool(steps),
+        "pages": pages,
+        "page_count": len(pages),
+        "failed": failed,
+    }
+`
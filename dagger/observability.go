@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// prometheusPort and grafanaPort are where the observability stack answers.
+const (
+	prometheusPort = 9090
+	grafanaPort    = 3000
+)
+
+// scrapeTargets are the components with a /metrics endpoint, by the job
+// name Prometheus gives their series and the host and port they answer on,
+// the same names the pipeline binds them under.
+var scrapeTargets = []struct {
+	job    string
+	target string
+}{
+	{"orchestrator", fmt.Sprintf("orchestrator:%d", orchestratorPort)},
+	{"session-memory", fmt.Sprintf("session-memory:%d", sessionMemoryPort)},
+}
+
+// dashboardPanel is one time series panel: a PromQL query, the legend of
+// its series and the Grafana unit of its values.
+type dashboardPanel struct {
+	title  string
+	expr   string
+	legend string
+	unit   string
+}
+
+// dashboard is a Grafana dashboard provisioned into the stack.
+type dashboard struct {
+	uid    string
+	title  string
+	panels []dashboardPanel
+}
+
+// observabilityDashboards chart every metric the components expose, with
+// an overview of the series worth a first look.
+var observabilityDashboards = []dashboard{
+	{uid: "dynamic-context-overview", title: "Dynamic Context / Overview", panels: []dashboardPanel{
+		{"Components up", `up`, "{{job}}", "none"},
+		{"Agent runs per second", `sum by (status) (rate(orchestrator_agent_runs_total[5m]))`, "{{status}}", "ops"},
+		{"Queue depth", `sum by (priority) (orchestrator_queue_depth)`, "{{priority}}", "none"},
+		{"Dead letters and quarantined results", `orchestrator_dead_letters or orchestrator_quarantined`, "{{__name__}}", "none"},
+		{"Active sessions", `session_memory_active_sessions`, "sessions", "none"},
+		{"Session store up", `session_memory_store_up`, "{{backend}}", "none"},
+	}},
+	{uid: "dynamic-context-orchestrator", title: "Dynamic Context / Orchestrator", panels: []dashboardPanel{
+		{"Agent runs per second", `sum by (agent_type, status) (rate(orchestrator_agent_runs_total[5m]))`, "{{agent_type}} {{status}}", "ops"},
+		{"Agent failure rate", `sum by (agent_type) (rate(orchestrator_agent_runs_total{status="failed"}[5m])) / sum by (agent_type) (rate(orchestrator_agent_runs_total[5m]))`, "{{agent_type}}", "percentunit"},
+		{"Agent run duration, 95th percentile", `histogram_quantile(0.95, sum by (agent_type, le) (rate(orchestrator_agent_run_duration_seconds_bucket[5m])))`, "{{agent_type}}", "s"},
+		{"Agent run duration, mean", `sum by (agent_type) (rate(orchestrator_agent_run_duration_seconds_sum[5m])) / sum by (agent_type) (rate(orchestrator_agent_run_duration_seconds_count[5m]))`, "{{agent_type}}", "s"},
+		{"Retries per second", `sum by (agent_type) (rate(orchestrator_agent_retries_total[5m]))`, "{{agent_type}}", "ops"},
+		{"Items per second", `sum by (agent_type) (rate(orchestrator_agent_items_total[5m]))`, "{{agent_type}}", "none"},
+		{"Bytes fetched per second", `sum by (agent_type) (rate(orchestrator_agent_bytes_fetched_total[5m]))`, "{{agent_type}}", "Bps"},
+		{"API calls per second", `sum by (agent_type) (rate(orchestrator_agent_api_calls_total[5m]))`, "{{agent_type}}", "ops"},
+		{"LLM tokens per second", `sum by (agent_type, kind) (rate(orchestrator_agent_llm_tokens_total[5m]))`, "{{agent_type}} {{kind}}", "none"},
+		{"Cost per hour", `sum by (agent_type) (rate(orchestrator_agent_cost_total[1h])) * 3600`, "{{agent_type}}", "none"},
+		{"Jobs by status", `sum by (status) (orchestrator_jobs)`, "{{status}}", "none"},
+		{"Queue depth", `sum by (priority) (orchestrator_queue_depth)`, "{{priority}}", "none"},
+		{"Dead letters", `orchestrator_dead_letters`, "dead letters", "none"},
+		{"Quarantined results", `orchestrator_quarantined`, "quarantined", "none"},
+		{"Budgets paused", `orchestrator_budget_paused`, "{{budget}}", "none"},
+	}},
+	{uid: "dynamic-context-session-memory", title: "Dynamic Context / Session Memory", panels: []dashboardPanel{
+		{"Store up", `session_memory_store_up`, "{{backend}}", "none"},
+		{"Store latency", `session_memory_store_latency_seconds`, "latency", "s"},
+		{"Active and pinned sessions", `session_memory_active_sessions or session_memory_pinned_sessions`, "{{__name__}}", "none"},
+		{"Keys", `session_memory_keys`, "keys", "none"},
+		{"Sessions by tenant", `session_memory_tenant_sessions`, "{{tenant}}", "none"},
+		{"Bytes by tenant", `session_memory_tenant_bytes`, "{{tenant}}", "bytes"},
+		{"Sessions by time to expiry", `session_memory_sessions_by_ttl`, "{{expires_within}}", "none"},
+		{"Largest sessions", `session_memory_largest_session_bytes`, "{{tenant}} {{session_id}}", "bytes"},
+		{"Redis memory", `session_memory_redis_used_memory_bytes`, "used", "bytes"},
+		{"Redis clients", `session_memory_redis_connected_clients`, "clients", "none"},
+		{"Redis evictions per second", `rate(session_memory_redis_evicted_keys_total[5m])`, "evicted", "ops"},
+		{"Redis expiries per second", `rate(session_memory_redis_expired_keys_total[5m])`, "expired", "ops"},
+		{"Redis hit rate", `rate(session_memory_redis_keyspace_hits_total[5m]) / (rate(session_memory_redis_keyspace_hits_total[5m]) + rate(session_memory_redis_keyspace_misses_total[5m]))`, "hits", "percentunit"},
+		{"Duplicates per second", `rate(session_memory_dedup_duplicates_total[5m])`, "duplicates", "ops"},
+		{"Bytes saved by deduplication", `session_memory_dedup_bytes_saved_total`, "saved", "bytes"},
+	}},
+}
+
+// prometheusConfig scrapes every component in scrapeTargets.
+func prometheusConfig() string {
+	var config strings.Builder
+	config.WriteString("global:\n  scrape_interval: 15s\n  evaluation_interval: 15s\n\nscrape_configs:\n")
+	for _, target := range scrapeTargets {
+		fmt.Fprintf(&config, "  - job_name: %s\n    static_configs:\n      - targets: [\"%s\"]\n", target.job, target.target)
+	}
+	return config.String()
+}
+
+// grafanaDatasources points Grafana at the stack's Prometheus, under the
+// uid the dashboards' panels name, and at Loki for the components' logs.
+const grafanaDatasources = `apiVersion: 1
+datasources:
+  - name: Prometheus
+    type: prometheus
+    uid: prometheus
+    access: proxy
+    url: http://prometheus:9090
+    isDefault: true
+  - name: Loki
+    type: loki
+    uid: loki
+    access: proxy
+    url: http://loki:3100
+`
+
+// grafanaDashboardProvider loads the dashboards from the image, in their
+// own folder.
+const grafanaDashboardProvider = `apiVersion: 1
+providers:
+  - name: dynamic-context
+    folder: Dynamic Context
+    type: file
+    disableDeletion: true
+    options:
+      path: /var/lib/grafana/dashboards
+`
+
+// JSON is the dashboard in Grafana's JSON model: its panels two abreast,
+// each a time series of its query.
+func (d dashboard) JSON() (string, error) {
+	datasource := map[string]any{"type": "prometheus", "uid": "prometheus"}
+	panels := make([]map[string]any, 0, len(d.panels))
+	for i, panel := range d.panels {
+		panels = append(panels, map[string]any{
+			"id":         i + 1,
+			"type":       "timeseries",
+			"title":      panel.title,
+			"datasource": datasource,
+			"gridPos":    map[string]any{"x": i % 2 * 12, "y": i / 2 * 8, "w": 12, "h": 8},
+			"fieldConfig": map[string]any{
+				"defaults":  map[string]any{"unit": panel.unit},
+				"overrides": []any{},
+			},
+			"targets": []any{map[string]any{
+				"refId":        "A",
+				"datasource":   datasource,
+				"expr":         panel.expr,
+				"legendFormat": panel.legend,
+			}},
+		})
+	}
+	model, err := json.MarshalIndent(map[string]any{
+		"uid":           d.uid,
+		"title":         d.title,
+		"tags":          []string{"dynamic-context"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "30s",
+		"time":          map[string]any{"from": "now-6h", "to": "now"},
+		"panels":        panels,
+	}, "", "  ")
+	return string(model), err
+}
+
+// observabilityConfig is the stack's configuration as it is laid out in the
+// images, and in the directory the pipeline exports: prometheus/ and
+// grafana/provisioning/ and grafana/dashboards/.
+func observabilityConfig(client *dagger.Client) (*dagger.Directory, error) {
+	config := client.Directory().
+		WithNewFile("prometheus/prometheus.yml", prometheusConfig()).
+		WithNewFile("grafana/provisioning/datasources/prometheus.yml", grafanaDatasources).
+		WithNewFile("grafana/provisioning/dashboards/dynamic-context.yml", grafanaDashboardProvider)
+	for _, d := range observabilityDashboards {
+		model, err := d.JSON()
+		if err != nil {
+			return nil, fmt.Errorf("dashboard %s: %w", d.uid, err)
+		}
+		config = config.WithNewFile("grafana/dashboards/"+d.uid+".json", model)
+	}
+	return config, nil
+}
+
+// buildObservabilityStack is Prometheus, scraping the components, and
+// Grafana with Prometheus as its data source and the dashboards
+// provisioned, so a fresh deployment has monitoring from the start.
+func buildObservabilityStack(client *dagger.Client) (prometheus, grafana *dagger.Container, err error) {
+	fmt.Println("📈 Building Observability Stack...")
+
+	config, err := observabilityConfig(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	prometheus = client.Container().
+		From("prom/prometheus:v2.51.2").
+		WithFile("/etc/prometheus/prometheus.yml", config.File("prometheus/prometheus.yml")).
+		WithExposedPort(prometheusPort)
+	grafana = client.Container().
+		From("grafana/grafana:10.4.2").
+		WithDirectory("/etc/grafana/provisioning", config.Directory("grafana/provisioning")).
+		WithDirectory("/var/lib/grafana/dashboards", config.Directory("grafana/dashboards")).
+		WithExposedPort(grafanaPort)
+	return prometheus, grafana, nil
+}
+
+// deployObservability builds the observability stack and checks it against
+// the components: Prometheus scrapes each of them and takes every panel's
+// query, and Grafana has its dashboards and can query Prometheus and the
+// Loki the components ship their logs to. It then exports
+// the images and their configuration to dir. It only runs when
+// OBSERVABILITY_STACK is set on the host.
+func deployObservability(ctx context.Context, client *dagger.Client, orchestratorContainer, sessionMemoryContainer *dagger.Container, redis *dagger.Service, dir string) error {
+	if os.Getenv("OBSERVABILITY_STACK") == "" {
+		fmt.Println("⏭️ Skipping observability stack: OBSERVABILITY_STACK is not set")
+		return nil
+	}
+
+	prometheusContainer, grafanaContainer, err := buildObservabilityStack(client)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("🧪 Testing Observability Stack...")
+	loki := lokiService(client)
+	prometheus := prometheusContainer.
+		WithServiceBinding("orchestrator", withLogging(orchestratorContainer, loki).AsService()).
+		WithServiceBinding("session-memory", sessionMemoryService(withLogging(sessionMemoryContainer, loki), redis)).
+		AsService()
+	grafana := grafanaContainer.
+		WithServiceBinding("prometheus", prometheus).
+		WithServiceBinding("loki", loki).
+		AsService()
+
+	var queries []string
+	for _, d := range observabilityDashboards {
+		for _, panel := range d.panels {
+			queries = append(queries, panel.expr)
+		}
+	}
+	// Prometheus scrapes a target within a scrape interval of starting, and
+	// answers 400 for a query it cannot parse. Loki takes a while to be
+	// ready.
+	script := fmt.Sprintf(`set -e
+for i in $(seq 60); do
+  targets=$(curl -fsS http://prometheus:%[1]d/api/v1/targets)
+  [ "$(echo "$targets" | grep -o '"health":"up"' | wc -l)" -ge %[3]d ] && break
+  sleep 2
+done
+while read -r query; do
+  curl -fsS -G --data-urlencode "query=$query" http://prometheus:%[1]d/api/v1/query > /dev/null || { echo "query failed: $query" >&2; exit 1; }
+done < /queries
+echo "$targets"
+curl -fsS -u admin:admin http://grafana:%[2]d/api/datasources/uid/prometheus/health; echo
+for i in $(seq 30); do
+  loki=$(curl -sS -u admin:admin http://grafana:%[2]d/api/datasources/uid/loki/health)
+  case "$loki" in *'"status":"OK"'*) break;; esac
+  sleep 2
+done
+echo "$loki"
+curl -fsS -u admin:admin 'http://grafana:%[2]d/api/search?type=dash-db'`, prometheusPort, grafanaPort, len(scrapeTargets))
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("prometheus", prometheus).
+		WithServiceBinding("grafana", grafana).
+		WithNewFile("/queries", strings.Join(queries, "\n")+"\n").
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitN(output, "\n", 4)
+	if len(lines) != 4 {
+		return fmt.Errorf("unexpected observability output: %s", output)
+	}
+
+	var targets struct {
+		Data struct {
+			ActiveTargets []struct {
+				Labels    map[string]string `json:"labels"`
+				Health    string            `json:"health"`
+				LastError string            `json:"lastError"`
+			} `json:"activeTargets"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &targets); err != nil {
+		return fmt.Errorf("unexpected targets response %q: %w", lines[0], err)
+	}
+	up := map[string]bool{}
+	for _, target := range targets.Data.ActiveTargets {
+		up[target.Labels["job"]] = target.Health == "up"
+	}
+	for _, target := range scrapeTargets {
+		if !up[target.job] {
+			return fmt.Errorf("Prometheus is not scraping %s: %s", target.job, lines[0])
+		}
+	}
+
+	for i, name := range []string{"Prometheus", "Loki"} {
+		var datasource struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal([]byte(lines[1+i]), &datasource); err != nil || datasource.Status != "OK" {
+			return fmt.Errorf("Grafana cannot query %s: %s", name, lines[1+i])
+		}
+	}
+
+	var dashboards []struct {
+		UID string `json:"uid"`
+	}
+	if err := json.Unmarshal([]byte(lines[3]), &dashboards); err != nil {
+		return fmt.Errorf("unexpected dashboard search response %q: %w", lines[3], err)
+	}
+	provisioned := map[string]bool{}
+	for _, d := range dashboards {
+		provisioned[d.UID] = true
+	}
+	for _, d := range observabilityDashboards {
+		if !provisioned[d.uid] {
+			return fmt.Errorf("Grafana has no %s dashboard: %s", d.uid, lines[3])
+		}
+	}
+	fmt.Printf("Observability Stack: %d targets up, %d dashboards, %d queries\n", len(scrapeTargets), len(observabilityDashboards), len(queries))
+
+	fmt.Println("📦 Exporting Observability Stack...")
+	config, err := observabilityConfig(client)
+	if err != nil {
+		return err
+	}
+	if _, err := config.Export(ctx, dir+"/observability"); err != nil {
+		return fmt.Errorf("configuration: %w", err)
+	}
+	fmt.Printf("Observability Stack: %s/observability\n", dir)
+	for name, container := range map[string]*dagger.Container{"prometheus": prometheusContainer, "grafana": grafanaContainer} {
+		dest := fmt.Sprintf("%s/%s.tar", dir, name)
+		if _, err := container.Export(ctx, dest); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Printf("Observability Stack: %s\n", dest)
+	}
+	return nil
+}
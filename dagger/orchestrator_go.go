@@ -0,0 +1,742 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// orchestratorSource is the Go agent orchestrator, relative to the
+// repository root the pipeline runs from.
+const orchestratorSource = "packages/orchestrator"
+
+const orchestratorPort = 8070
+
+func init() {
+	registerComponent(orchestratorComponent{})
+}
+
+// orchestratorComponent is the agent orchestrator's builder. It builds on
+// the micro agent's container, and is tested running a job through the MCP
+// server into session memory.
+type orchestratorComponent struct{ publishContainer }
+
+func (orchestratorComponent) Name() string { return "orchestrator" }
+
+func (orchestratorComponent) Needs() []string {
+	return []string{"mcp-server", "session-memory", "redis"}
+}
+
+func (orchestratorComponent) Build(ctx context.Context, client *dagger.Client, built map[string]*dagger.Container) *dagger.Container {
+	return buildOrchestratorContainer(ctx, client, built["micro-agent"])
+}
+
+func (c orchestratorComponent) Test(ctx context.Context, client *dagger.Client, built map[string]*dagger.Container, services map[string]*dagger.Service) error {
+	sessionMemoryAPI := sessionMemoryService(built["session-memory"], services["redis"])
+	if err := testOrchestrator(ctx, client, built[c.Name()], built["mcp-server"], sessionMemoryAPI); err != nil {
+		return fmt.Errorf("agent orchestrator test failed: %w", err)
+	}
+	return nil
+}
+
+// Orchestrator Container - the agent orchestrator layered onto the micro
+// agent image, so it can launch agents as child processes
+func buildOrchestratorContainer(ctx context.Context, client *dagger.Client, microAgent *dagger.Container) *dagger.Container {
+	fmt.Println("🎛️ Building Agent Orchestrator Container...")
+
+	binary := client.Container().
+		From("golang:1.22-alpine").
+		WithDirectory("/src/orchestrator", client.Host().Directory(orchestratorSource)).
+		WithDirectory("/src/events", client.Host().Directory(eventsSource)).
+		WithDirectory("/src/logging", client.Host().Directory(loggingSource)).
+		WithDirectory("/src/tracing", client.Host().Directory(tracingSource)).
+		WithWorkdir("/src/orchestrator").
+		WithMountedCache("/root/.cache/go-build", client.CacheVolume("orchestrator-go-build")).
+		WithMountedCache("/go/pkg/mod", client.CacheVolume("orchestrator-go-mod")).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithExec([]string{"go", "vet", "./..."}).
+		WithExec([]string{"go", "build", "-o", "/out/orchestrator", "."}).
+		File("/out/orchestrator")
+
+	return microAgent.
+		WithFile("/usr/local/bin/orchestrator", binary).
+		WithEnvVariable("ORCH_RUNTIME", "exec").
+		WithEnvVariable("ORCH_PORT", fmt.Sprint(orchestratorPort)).
+		WithExposedPort(orchestratorPort).
+		WithEntrypoint([]string{"/usr/local/bin/orchestrator"})
+}
+
+// testOrchestrator runs a gather-context job through the orchestrator and
+// checks that the MCP server stored its result in session memory.
+func testOrchestrator(ctx context.Context, client *dagger.Client, container *dagger.Container, mcpServer *dagger.Container, sessionMemory *dagger.Service) error {
+	fmt.Println("🧪 Testing Agent Orchestrator...")
+
+	mcp := mcpServer.
+		WithServiceBinding("session-memory", sessionMemory).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	// A secret store apart from /run/secrets, where the built-in agents
+	// would read it themselves
+	orchestrator := container.
+		WithServiceBinding("mcp-server", mcp).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("ORCH_RETRY_BACKOFF", "1").
+		WithMountedSecret("/run/orchestrator-secrets/scoped_token", client.SetSecret("orchestrator-scoped-token", "fixture-scoped-token")).
+		WithEnvVariable("ORCH_SECRETS_DIR", "/run/orchestrator-secrets").
+		AsService()
+
+	base := fmt.Sprintf("http://orchestrator:%d", orchestratorPort)
+	job := `{"agent_type": "context_gatherer", "target": "orchestrator-target", "session_id": "orchestrator-session"}`
+
+	curl := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("orchestrator", orchestrator).
+		WithServiceBinding("mcp-server", mcp).
+		WithExec([]string{"curl", "-fsS", base + "/health"}).
+		WithExec([]string{"curl", "-fsS", base + "/agents/context_gatherer"})
+
+	output, err := curl.
+		WithExec([]string{"curl", "-fsS", "-X", "POST", "-H", "Content-Type: application/json", "-d", job, base + "/jobs"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var queued struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(output), &queued); err != nil || queued.ID == "" {
+		return fmt.Errorf("unexpected job response %q", output)
+	}
+
+	// Poll until the job has finished and been reported
+	poll := fmt.Sprintf(`for i in $(seq 30); do
+  job=$(curl -fsS %s/jobs/%s)
+  case "$job" in *'"reported":true'*|*'"status":"failed"'*) echo "$job"; exit 0;; esac
+  sleep 1
+done
+echo "$job"`, base, queued.ID)
+	output, err = curl.
+		WithExec([]string{"sh", "-c", poll}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var finished struct {
+		Status   string         `json:"status"`
+		Reported bool           `json:"reported"`
+		Result   map[string]any `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(output), &finished); err != nil {
+		return fmt.Errorf("unexpected job response %q: %w", output, err)
+	}
+	if finished.Status != "succeeded" || !finished.Reported {
+		return fmt.Errorf("job did not succeed and get reported: %s", output)
+	}
+	if finished.Result["target"] != "orchestrator-target" {
+		return fmt.Errorf("job result is not the agent's context: %v", finished.Result)
+	}
+
+	status, err := curl.
+		WithExec([]string{"curl", "-sS", "-o", "/dev/null", "-w", "%{http_code}", "-X", "POST", "-H", "Content-Type: application/json",
+			"-d", `{"agent_type": "missing", "target": "x"}`, base + "/jobs"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if status != "404" {
+		return fmt.Errorf("job for an unknown agent type returned HTTP %s, want 404", status)
+	}
+
+	if err := testOrchestratorSchedules(ctx, curl, base); err != nil {
+		return err
+	}
+
+	if err := testOrchestratorFanOut(ctx, curl, base); err != nil {
+		return err
+	}
+
+	if err := testOrchestratorStreaming(ctx, curl, base); err != nil {
+		return err
+	}
+
+	if err := testOrchestratorLimits(ctx, curl, base); err != nil {
+		return err
+	}
+
+	if err := testOrchestratorPipeline(ctx, curl, base); err != nil {
+		return err
+	}
+
+	if err := testOrchestratorDeadLetters(ctx, curl, base, container.WithServiceBinding("orchestrator", orchestrator)); err != nil {
+		return err
+	}
+
+	if err := testOrchestratorQuarantine(ctx, curl, base); err != nil {
+		return err
+	}
+
+	if err := testOrchestratorTelemetry(ctx, curl, base); err != nil {
+		return err
+	}
+
+	if err := testOrchestratorCosts(ctx, curl, base); err != nil {
+		return err
+	}
+
+	if err := testOrchestratorSecrets(ctx, curl, base); err != nil {
+		return err
+	}
+
+	if err := testAgentSDK(ctx, client, mcp, curl, container.File("/usr/local/bin/orchestrator")); err != nil {
+		return err
+	}
+
+	stored, err := curl.
+		WithExec([]string{"curl", "-fsS", "http://mcp-server:3000/memory/sessions/orchestrator-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var session map[string]any
+	if err := json.Unmarshal([]byte(stored), &session); err != nil {
+		return fmt.Errorf("unexpected session response %q: %w", stored, err)
+	}
+	if session["target"] != "orchestrator-target" {
+		return fmt.Errorf("agent result was not stored in session memory: %s", stored)
+	}
+
+	fmt.Printf("Agent Orchestrator Job:\n%s\n", output)
+	return nil
+}
+
+// testOrchestratorSchedules runs a schedule on a short cadence and checks
+// its last run is reported.
+func testOrchestratorSchedules(ctx context.Context, curl *dagger.Container, base string) error {
+	schedule := `{"name": "pipeline-check", "cron": "@every 2s", "agent_type": "context_gatherer", "target": "scheduled-target"}`
+
+	status, err := curl.
+		WithExec([]string{"curl", "-sS", "-o", "/dev/null", "-w", "%{http_code}", "-X", "POST", "-H", "Content-Type: application/json",
+			"-d", `{"name": "bad-cron", "cron": "every night", "target": "x"}`, base + "/schedules"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if status != "422" {
+		return fmt.Errorf("schedule with a bad cron expression returned HTTP %s, want 422", status)
+	}
+
+	poll := fmt.Sprintf(`curl -fsS -X POST -H 'Content-Type: application/json' -d '%s' %s/schedules > /dev/null
+for i in $(seq 30); do
+  schedule=$(curl -fsS %s/schedules/pipeline-check)
+  case "$schedule" in *'"last_status":"succeeded"'*|*'"last_status":"failed"'*) break;; esac
+  sleep 1
+done
+curl -fsS -X DELETE %s/schedules/pipeline-check > /dev/null
+echo "$schedule"`, schedule, base, base, base)
+	output, err := curl.
+		WithExec([]string{"sh", "-c", poll}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		LastStatus string `json:"last_status"`
+		LastJobID  string `json:"last_job_id"`
+		Runs       int    `json:"runs"`
+	}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		return fmt.Errorf("unexpected schedule response %q: %w", output, err)
+	}
+	if result.LastStatus != "succeeded" || result.Runs < 1 || result.LastJobID == "" {
+		return fmt.Errorf("scheduled run did not succeed: %s", output)
+	}
+
+	fmt.Printf("Agent Orchestrator Schedule: %d runs, last %s\n", result.Runs, result.LastStatus)
+	return nil
+}
+
+// testOrchestratorFanOut crawls several directories at once, one of them
+// missing, and checks the partial failure is reported and the rest stored.
+func testOrchestratorFanOut(ctx context.Context, curl *dagger.Container, base string) error {
+	fanOut := `{"agent_type": "filesystem_crawler", "targets": ["/app", "/app/agents", "/missing"], "concurrency": 2, "session_id": "fanout-session"}`
+
+	output, err := curl.
+		WithExec([]string{"curl", "-fsS", "-X", "POST", "-H", "Content-Type: application/json", "-d", fanOut, base + "/fanouts"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var started struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(output), &started); err != nil || started.ID == "" {
+		return fmt.Errorf("unexpected fan-out response %q", output)
+	}
+
+	poll := fmt.Sprintf(`for i in $(seq 30); do
+  fanout=$(curl -fsS %s/fanouts/%s)
+  case "$fanout" in *'"status":"running"'*) sleep 1;; *) break;; esac
+done
+sleep 1
+echo "$fanout"`, base, started.ID)
+	output, err = curl.
+		WithExec([]string{"sh", "-c", poll}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var finished struct {
+		Status    string `json:"status"`
+		Succeeded int    `json:"succeeded"`
+		Failed    int    `json:"failed"`
+		Results   []struct {
+			Target string `json:"target"`
+			Error  string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(output), &finished); err != nil {
+		return fmt.Errorf("unexpected fan-out response %q: %w", output, err)
+	}
+	if finished.Status != "partial" || finished.Succeeded != 2 || finished.Failed != 1 {
+		return fmt.Errorf("fan-out with one missing directory was not a partial success: %s", output)
+	}
+	for _, result := range finished.Results {
+		if result.Target == "/missing" && result.Error == "" {
+			return fmt.Errorf("failed fan-out target has no error: %s", output)
+		}
+	}
+
+	stored, err := curl.
+		WithExec([]string{"curl", "-fsS", "http://mcp-server:3000/memory/sessions/fanout-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var session map[string]any
+	if err := json.Unmarshal([]byte(stored), &session); err != nil {
+		return fmt.Errorf("unexpected session response %q: %w", stored, err)
+	}
+	if session["/app"] == nil || session["/app/agents"] == nil {
+		return fmt.Errorf("fan-out results were not stored in session memory: %s", stored)
+	}
+
+	fmt.Printf("Agent Orchestrator Fan-out: %d succeeded, %d failed\n", finished.Succeeded, finished.Failed)
+	return nil
+}
+
+// testOrchestratorStreaming runs a crawl and checks the agent streamed its
+// files to the MCP server before reporting the result.
+func testOrchestratorStreaming(ctx context.Context, curl *dagger.Container, base string) error {
+	job := `{"agent_type": "filesystem_crawler", "target": "/app", "session_id": "stream-session"}`
+
+	script := fmt.Sprintf(`id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '%s' %s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 30); do
+  case "$(curl -fsS %s/jobs/$id)" in *'"reported":true'*|*'"status":"failed"'*) break;; esac
+  sleep 1
+done
+echo "$id"
+curl -fsS http://mcp-server:3000/agents/streams`, job, base, base)
+	output, err := curl.
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, streamsJSON, _ := strings.Cut(output, "\n")
+	var streams struct {
+		Streams []struct {
+			StreamID string `json:"stream_id"`
+			Items    int    `json:"items"`
+			Status   string `json:"status"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(streamsJSON), &streams); err != nil {
+		return fmt.Errorf("unexpected streams response %q: %w", streamsJSON, err)
+	}
+	for _, stream := range streams.Streams {
+		if stream.StreamID == id {
+			if stream.Items == 0 || stream.Status != "succeeded" {
+				return fmt.Errorf("job %s streamed %d items and ended %q", id, stream.Items, stream.Status)
+			}
+			fmt.Printf("Agent Orchestrator Streaming: %d items streamed by job %s\n", stream.Items, id)
+			return nil
+		}
+	}
+	return fmt.Errorf("job %s did not stream to the MCP server: %s", id, streamsJSON)
+}
+
+// testOrchestratorLimits registers an agent type that outlives its
+// wall-clock limit and checks its job is killed, with the limits it ran
+// under recorded on the job.
+func testOrchestratorLimits(ctx context.Context, curl *dagger.Container, base string) error {
+	agent := `{"name": "sleeper", "command": ["sleep", "60"], "timeout": 2, "memory": "64m"}`
+
+	script := fmt.Sprintf(`curl -fsS -o /dev/null -X POST -H 'Content-Type: application/json' -d '%s' %s/agents
+id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '{"agent_type": "sleeper", "target": "x"}' %s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 20); do
+  job=$(curl -fsS %s/jobs/$id)
+  case "$job" in *'"status":"failed"'*|*'"status":"succeeded"'*) break;; esac
+  sleep 1
+done
+echo "$job"`, agent, base, base, base)
+	output, err := curl.
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var job struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Limits struct {
+			Memory  string `json:"memory"`
+			Timeout int    `json:"timeout"`
+		} `json:"limits"`
+	}
+	if err := json.Unmarshal([]byte(output), &job); err != nil {
+		return fmt.Errorf("unexpected job response %q: %w", output, err)
+	}
+	if job.Status != "failed" || !strings.Contains(job.Error, "time limit") {
+		return fmt.Errorf("agent past its time limit was not killed: %s", output)
+	}
+	if job.Limits.Memory != "64m" || job.Limits.Timeout != 2 {
+		return fmt.Errorf("job does not show the agent type's limits: %s", output)
+	}
+
+	fmt.Printf("Agent Orchestrator Limits: %s\n", job.Error)
+	return nil
+}
+
+// testOrchestratorDeadLetters runs an agent that always fails, checks it
+// was retried and dead-lettered, and requeues it with the orchestrator's
+// CLI.
+func testOrchestratorDeadLetters(ctx context.Context, curl *dagger.Container, base string, cli *dagger.Container) error {
+	agent := `{"name": "always_fails", "command": ["sh", "-c", "echo broken >&2; exit 1"]}`
+
+	script := fmt.Sprintf(`curl -fsS -o /dev/null -X POST -H 'Content-Type: application/json' -d '%s' %s/agents
+id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '{"agent_type": "always_fails", "target": "x"}' %s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 20); do
+  case "$(curl -fsS %s/jobs/$id)" in *'"status":"failed"'*|*'"status":"succeeded"'*) break;; esac
+  sleep 1
+done
+curl -fsS %s/deadletters/$id`, agent, base, base, base, base)
+	output, err := curl.
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("failed job was not dead-lettered: %w", err)
+	}
+	var letter struct {
+		JobID    string   `json:"job_id"`
+		Attempts int      `json:"attempts"`
+		Errors   []string `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(output), &letter); err != nil {
+		return fmt.Errorf("unexpected dead letter response %q: %w", output, err)
+	}
+	if letter.Attempts != 3 || len(letter.Errors) != 3 {
+		return fmt.Errorf("failed job was not tried three times: %s", output)
+	}
+
+	// The orchestrator binary is also its CLI
+	requeued, err := cli.
+		WithEnvVariable("ORCH_URL", base).
+		WithExec([]string{"deadletters", "requeue", letter.JobID}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(requeued, letter.JobID+" requeued as job ") {
+		return fmt.Errorf("unexpected requeue output %q", requeued)
+	}
+	status, err := curl.
+		WithExec([]string{"curl", "-sS", "-o", "/dev/null", "-w", "%{http_code}", base + "/deadletters/" + letter.JobID}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if status != "404" {
+		return fmt.Errorf("requeued dead letter returned HTTP %s, want 404", status)
+	}
+
+	fmt.Printf("Agent Orchestrator Dead Letters: %s", requeued)
+	return nil
+}
+
+// testOrchestratorQuarantine runs an agent whose knowledge graph node has
+// no content and checks its result was quarantined, not retried or
+// reported, and that the MCP server turns away a batch of the same node.
+func testOrchestratorQuarantine(ctx context.Context, curl *dagger.Container, base string) error {
+	agent := `{"name": "bad_nodes", "command": ["sh", "-c", "echo \"$1\"", "sh", "{\"context\": {\"nodes\": [{\"data\": {\"type\": \"note\"}}]}}"]}`
+	batch := `{"stream_id": "bad-stream", "agent_type": "bad_nodes", "target": "x", "field": "nodes", "items": [{"data": {"type": "note"}}], "seq": 1}`
+
+	script := fmt.Sprintf(`id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '{"agent_type": "bad_nodes", "target": "x"}' %s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 20); do
+  case "$(curl -fsS %s/jobs/$id)" in *'"status":"failed"'*|*'"status":"succeeded"'*) break;; esac
+  sleep 1
+done
+curl -fsS %s/quarantine/$id
+echo
+curl -sS -o /dev/null -w '%%{http_code}' -X POST -H 'Content-Type: application/json' -d '%s' http://mcp-server:3000/agents/items`, base, base, base, batch)
+	output, err := curl.
+		WithExec([]string{"curl", "-fsS", "-o", "/dev/null", "-X", "POST", "-H", "Content-Type: application/json", "-d", agent, base + "/agents"}).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("invalid result was not quarantined: %w", err)
+	}
+	quarantinedJSON, status, _ := strings.Cut(output, "\n")
+	var quarantined struct {
+		JobID      string `json:"job_id"`
+		Violations []struct {
+			Path    string `json:"path"`
+			Message string `json:"message"`
+		} `json:"violations"`
+	}
+	if err := json.Unmarshal([]byte(quarantinedJSON), &quarantined); err != nil {
+		return fmt.Errorf("unexpected quarantine response %q: %w", quarantinedJSON, err)
+	}
+	if len(quarantined.Violations) != 1 || quarantined.Violations[0].Path != "/context/nodes/0/data" {
+		return fmt.Errorf("quarantined result does not point at the node missing its content: %s", quarantinedJSON)
+	}
+	if status != "422" {
+		return fmt.Errorf("MCP server answered an invalid batch with HTTP %s, want 422", status)
+	}
+
+	job, err := curl.
+		WithExec([]string{"curl", "-fsS", base + "/jobs/" + quarantined.JobID}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(job, `"attempts":1,`) || !strings.Contains(job, "does not match the agent output schema") {
+		return fmt.Errorf("job with an invalid result was retried or not failed: %s", job)
+	}
+
+	fmt.Printf("Agent Orchestrator Quarantine: %s %s\n", quarantined.Violations[0].Path, quarantined.Violations[0].Message)
+	return nil
+}
+
+// testOrchestratorTelemetry checks the runs of the tests before it made it
+// into the run history, its per-agent stats and the Prometheus metrics.
+func testOrchestratorTelemetry(ctx context.Context, curl *dagger.Container, base string) error {
+	output, err := curl.
+		WithExec([]string{"curl", "-fsS", base + "/runs/stats"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var stats struct {
+		Agents []struct {
+			AgentType string `json:"agent_type"`
+			Runs      int    `json:"runs"`
+			Failed    int    `json:"failed"`
+			Items     int    `json:"items"`
+		} `json:"agents"`
+	}
+	if err := json.Unmarshal([]byte(output), &stats); err != nil {
+		return fmt.Errorf("unexpected run stats %q: %w", output, err)
+	}
+	found := map[string]bool{}
+	for _, agent := range stats.Agents {
+		switch {
+		case agent.AgentType == "bad_nodes" && agent.Runs == 1 && agent.Failed == 1:
+			found[agent.AgentType] = true
+		case agent.AgentType == "filesystem_crawler" && agent.Runs > 0 && agent.Failed == 0 && agent.Items > 0:
+			found[agent.AgentType] = true
+		}
+	}
+	if !found["bad_nodes"] || !found["filesystem_crawler"] {
+		return fmt.Errorf("run stats are missing the quarantined run or the pipeline's crawl: %s", output)
+	}
+
+	runs, err := curl.
+		WithExec([]string{"curl", "-fsS", base + "/runs?agent_type=bad_nodes"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(runs, `"status":"failed"`) || !strings.Contains(runs, "does not match the agent output schema") {
+		return fmt.Errorf("run history is missing the quarantined run: %s", runs)
+	}
+
+	metrics, err := curl.
+		WithExec([]string{"curl", "-fsS", base + "/metrics"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	for _, metric := range []string{`orchestrator_agent_runs_total{agent_type="bad_nodes",status="failed"} 1`,
+		`orchestrator_agent_run_duration_seconds_count{agent_type="filesystem_crawler"}`, `orchestrator_jobs{status="failed"}`} {
+		if !strings.Contains(metrics, metric) {
+			return fmt.Errorf("metrics are missing %s:\n%s", metric, metrics)
+		}
+	}
+
+	fmt.Printf("Agent Orchestrator Telemetry: %d agent types in the run history\n", len(stats.Agents))
+	return nil
+}
+
+// testOrchestratorCosts runs an agent that reports two API calls for a
+// tenant whose budget allows two, and checks the next job is refused and
+// the run shows up in the cost report.
+func testOrchestratorCosts(ctx context.Context, curl *dagger.Container, base string) error {
+	agent := `{"name": "billed", "command": ["sh", "-c", "echo '{\"context\": {}, \"metrics\": {\"api_calls\": 2, \"llm_tokens\": {\"input\": 10, \"output\": 5}}}'"]}`
+	budget := `{"name": "capped", "tenant": "capped", "period": "day", "max_api_calls": 2}`
+	job := `{"agent_type": "billed", "target": "x", "tenant": "capped"}`
+
+	script := fmt.Sprintf(`id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '%s' %s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 20); do
+  case "$(curl -fsS %s/jobs/$id)" in *'"status":"failed"'*|*'"status":"succeeded"'*) break;; esac
+  sleep 1
+done
+curl -sS -o /dev/null -w '%%{http_code}\n' -X POST -H 'Content-Type: application/json' -d '%s' %s/jobs
+curl -fsS %s/budgets
+echo
+curl -fsS %s/costs
+curl -fsS -o /dev/null -X DELETE %s/budgets/capped`, job, base, base, job, base, base, base, base)
+	output, err := curl.
+		WithExec([]string{"curl", "-fsS", "-o", "/dev/null", "-X", "POST", "-H", "Content-Type: application/json", "-d", agent, base + "/agents"}).
+		WithExec([]string{"curl", "-fsS", "-o", "/dev/null", "-X", "POST", "-H", "Content-Type: application/json", "-d", budget, base + "/budgets"}).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("budget was not enforced: %w", err)
+	}
+	status, rest, _ := strings.Cut(output, "\n")
+	budgets, report, _ := strings.Cut(rest, "\n")
+	if status != "429" {
+		return fmt.Errorf("orchestrator answered a job over budget with HTTP %s, want 429", status)
+	}
+	if !strings.Contains(report, `{"tenant":"capped","agent_type":"billed","runs":1,"api_calls":2,"input_tokens":10,"output_tokens":5`) {
+		return fmt.Errorf("cost report is missing the tenant's run: %s", report)
+	}
+	if !strings.Contains(budgets, `"paused":true`) {
+		return fmt.Errorf("used up budget is not paused: %s", budgets)
+	}
+
+	fmt.Println("Agent Orchestrator Costs: the tenant's second job was refused once its budget was used up")
+	return nil
+}
+
+// testOrchestratorPipeline runs a crawl → pick → summarize pipeline, where
+// pick's target comes from the crawl's result and summarize echoes the
+// inputs it was given, and checks the artifacts and the stored session.
+func testOrchestratorPipeline(ctx context.Context, curl *dagger.Container, base string) error {
+	agents := []string{
+		`{"name": "pick", "command": ["sh", "-c", "printf '{\"picked\": \"%s\"}' \"$0\""]}`,
+		`{"name": "echo_inputs", "command": ["sh", "-c", "cat"]}`,
+	}
+	pipeline := `{"name": "crawl_and_summarize", "steps": [
+  {"name": "crawl", "agent_type": "filesystem_crawler"},
+  {"name": "pick", "agent_type": "pick", "target": "{{crawl.context.largest_files.0.path}}", "needs": ["crawl"]},
+  {"name": "summary", "agent_type": "echo_inputs", "needs": ["crawl", "pick"]}]}`
+
+	container := curl
+	for _, agent := range agents {
+		container = container.WithExec([]string{"curl", "-fsS", "-o", "/dev/null", "-X", "POST", "-H", "Content-Type: application/json", "-d", agent, base + "/agents"})
+	}
+	container = container.WithExec([]string{"curl", "-fsS", "-o", "/dev/null", "-X", "POST", "-H", "Content-Type: application/json", "-d", pipeline, base + "/pipelines"})
+
+	script := fmt.Sprintf(`id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '{"target": "/app", "session_id": "pipeline-session"}' %s/pipelines/crawl_and_summarize/runs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 30); do
+  run=$(curl -fsS %s/pipeline-runs/$id)
+  case "$run" in *'"finished_at"'*) break;; esac
+  sleep 1
+done
+echo "$run"
+curl -fsS %s/pipeline-runs/$id/artifacts/summary`, base, base, base)
+	output, err := container.
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	runJSON, artifactJSON, _ := strings.Cut(output, "\n")
+	var run struct {
+		Status string `json:"status"`
+		Steps  []struct {
+			Name   string `json:"name"`
+			Target string `json:"target"`
+		} `json:"steps"`
+	}
+	if err := json.Unmarshal([]byte(runJSON), &run); err != nil {
+		return fmt.Errorf("unexpected pipeline run response %q: %w", runJSON, err)
+	}
+	if run.Status != "succeeded" || len(run.Steps) != 3 {
+		return fmt.Errorf("pipeline run did not succeed: %s", runJSON)
+	}
+	if run.Steps[1].Target == "" || run.Steps[1].Target == "/app" {
+		return fmt.Errorf("pick step's target did not come from the crawl: %s", runJSON)
+	}
+	var artifact struct {
+		Inputs map[string]map[string]any `json:"inputs"`
+	}
+	if err := json.Unmarshal([]byte(artifactJSON), &artifact); err != nil {
+		return fmt.Errorf("unexpected artifact %q: %w", artifactJSON, err)
+	}
+	if artifact.Inputs["crawl"] == nil || artifact.Inputs["pick"]["picked"] != run.Steps[1].Target {
+		return fmt.Errorf("summary step was not given the earlier steps' results: %s", artifactJSON)
+	}
+
+	stored, err := curl.
+		WithExec([]string{"curl", "-fsS", "http://mcp-server:3000/memory/sessions/pipeline-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var session map[string]any
+	if err := json.Unmarshal([]byte(stored), &session); err != nil {
+		return fmt.Errorf("unexpected session response %q: %w", stored, err)
+	}
+	if session["summary"] == nil {
+		return fmt.Errorf("pipeline result was not stored in session memory: %s", stored)
+	}
+
+	fmt.Printf("Agent Orchestrator Pipeline: %s, picked %s\n", run.Status, run.Steps[1].Target)
+	return nil
+}
+
+// testOrchestratorSecrets runs two agent types that print SCOPED_TOKEN, one
+// bound to the store's scoped_token, and checks that only that one saw it.
+func testOrchestratorSecrets(ctx context.Context, curl *dagger.Container, base string) error {
+	command := `"command": ["sh", "-c", "printf '{\"context\": {\"token\": \"%s\"}}' \"${SCOPED_TOKEN:-none}\"", "sh"]`
+	bound := `{"name": "scoped_bound", ` + command + `, "secrets": [{"name": "SCOPED_TOKEN", "from": "scoped_token"}]}`
+	unbound := `{"name": "scoped_unbound", ` + command + `}`
+
+	script := fmt.Sprintf(`for agent in scoped_bound scoped_unbound; do
+  id=$(curl -fsS -X POST -H 'Content-Type: application/json' -d "{\"agent_type\": \"$agent\", \"target\": \"x\"}" %s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+  for i in $(seq 20); do
+    job=$(curl -fsS %s/jobs/$id)
+    case "$job" in *'"status":"failed"'*|*'"status":"succeeded"'*) break;; esac
+    sleep 1
+  done
+  echo "$job"
+done`, base, base)
+	output, err := curl.
+		WithExec([]string{"curl", "-fsS", "-o", "/dev/null", "-X", "POST", "-H", "Content-Type: application/json", "-d", bound, base + "/agents"}).
+		WithExec([]string{"curl", "-fsS", "-o", "/dev/null", "-X", "POST", "-H", "Content-Type: application/json", "-d", unbound, base + "/agents"}).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("secret scoping jobs: %w", err)
+	}
+	boundJob, unboundJob, _ := strings.Cut(output, "\n")
+	if !strings.Contains(boundJob, `"token":"fixture-scoped-token"`) {
+		return fmt.Errorf("agent type bound to the secret did not get it: %s", boundJob)
+	}
+	if !strings.Contains(unboundJob, `"token":"none"`) {
+		return fmt.Errorf("agent type without the secret saw it or failed: %s", unboundJob)
+	}
+
+	fmt.Println("Agent Orchestrator Secrets: only the bound agent type saw its secret")
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+)
+
+// defaultPipelineConfig is the pipeline's own configuration, relative to
+// the repository root the pipeline runs from. PIPELINE_CONFIG names
+// another.
+const defaultPipelineConfig = "pipeline.json"
+
+// pipelineConfig is what pipeline.json declares: the components added
+// beside the built-in ones, by name, and the hooks run around its stages.
+type pipelineConfig struct {
+	Components map[string]componentConfig `json:"components"`
+	Hooks      []pipelineHook             `json:"hooks,omitempty"`
+
+	// run tells this run of the pipeline from others, for hooks
+	run string
+}
+
+// componentConfig is a component's entry in pipeline.json: where its
+// source is, the port it listens on and what its container's environment
+// has besides.
+type componentConfig struct {
+	Source string            `json:"source"`
+	Port   int               `json:"port"`
+	Env    map[string]string `json:"env,omitempty"`
+}
+
+// pipelineConfigPath is PIPELINE_CONFIG, or pipeline.json.
+func pipelineConfigPath() string {
+	if path := os.Getenv("PIPELINE_CONFIG"); path != "" {
+		return path
+	}
+	return defaultPipelineConfig
+}
+
+// loadPipelineConfig reads the pipeline's configuration and configures the
+// builders of the components it declares. Without the file there is
+// nothing to add, but every component it declares needs a source, a port
+// and a builder that takes them, and its hooks must make sense.
+func loadPipelineConfig() (pipelineConfig, error) {
+	config := pipelineConfig{run: time.Now().UTC().Format(time.RFC3339Nano)}
+	path := pipelineConfigPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && os.Getenv("PIPELINE_CONFIG") == "" {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, name := range config.componentNames() {
+		component := config.Components[name]
+		if component.Source == "" || component.Port <= 0 {
+			return config, fmt.Errorf("%s: component %s needs a source and a port", path, name)
+		}
+		builder, ok := componentBuilders[name].(configurable)
+		if !ok {
+			return config, fmt.Errorf("%s: component %s has no builder; go run ./dagger gen component %s writes one", path, name, name)
+		}
+		builder.configure(component)
+	}
+	return config, config.checkHooks(path)
+}
+
+func (c pipelineConfig) componentNames() []string {
+	names := make([]string, 0, len(c.Components))
+	for name := range c.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
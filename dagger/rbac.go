@@ -0,0 +1,615 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// rbacSource is the shared access control: the token format, the policy
+// and the Go middleware, relative to the repository root the pipeline runs
+// from.
+const rbacSource = "packages/rbac"
+
+// rbacPolicyPath is where a service container with access control on has
+// its policy.
+const rbacPolicyPath = "/etc/rbac/policy.json"
+
+// withRBAC turns access control on in a service with the default policy.
+// token, when there is one, is what the service calls the others with.
+func withRBAC(client *dagger.Client, container *dagger.Container, secret *dagger.Secret, token string) *dagger.Container {
+	container = container.
+		WithFile(rbacPolicyPath, client.Host().File(rbacSource+"/policy.json")).
+		WithSecretVariable("RBAC_SECRET", secret).
+		WithEnvVariable("RBAC_POLICY", rbacPolicyPath)
+	if token != "" {
+		container = container.WithEnvVariable("RBAC_TOKEN", token)
+	}
+	return container
+}
+
+// mintToken issues a token the way rbac.Mint does, for the tests.
+func mintToken(secret, subject, role string) string {
+	return mintTenantToken(secret, subject, role, "")
+}
+
+// mintTenantToken issues a token bound to a tenant, or to none when tenant
+// is empty.
+func mintTenantToken(secret, subject, role, tenant string) string {
+	fields := map[string]string{"sub": subject, "role": role}
+	if tenant != "" {
+		fields["tenant"] = tenant
+	}
+	claims, _ := json.Marshal(fields)
+	payload := "v1." + base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// rbacTestSecret signs the tokens of the access control test.
+const rbacTestSecret = "rbac-fixture-secret"
+
+// testRBAC turns access control on across the MCP server, both knowledge
+// graph services and session memory, and checks each role against the
+// same policy: health is public, a request without a token or with a forged
+// one is refused, read-only may only read, agents may read and write but
+// not administer, and admins may do anything. Requests session memory gets
+// through the MCP server are held to its rules too, and a job the
+// orchestrator runs still reaches session memory with the tokens it and its
+// agents are given.
+func testRBAC(ctx context.Context, client *dagger.Client, mcpServer, knowledgeGraphContainer, goKnowledgeGraphContainer, sessionMemoryContainer, orchestratorContainer *dagger.Container, neo4j, qdrant, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing RBAC...")
+
+	secret := client.SetSecret("rbac-secret", rbacTestSecret)
+	admin := mintToken(rbacTestSecret, "rbac-admin", "admin")
+	agent := mintToken(rbacTestSecret, "rbac-agent", "agent")
+	readOnly := mintToken(rbacTestSecret, "rbac-reader", "read-only")
+	forged := mintToken("not-the-secret", "rbac-forger", "admin")
+
+	graph := withRBAC(client, withGraphServices(knowledgeGraphContainer, neo4j, qdrant), secret, "").
+		WithEnvVariable("KG_PORT", fmt.Sprint(knowledgeGraphPort)).
+		WithExposedPort(knowledgeGraphPort).
+		WithExec([]string{"python3", "/app/kg_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	goGraph := goKnowledgeGraphService(client, withRBAC(client, goKnowledgeGraphContainer, secret, ""), qdrant)
+	// Session memory purges the graph when a user is deleted, which takes
+	// an admin
+	memory := withRBAC(client, withRedis(sessionMemoryContainer, redis), secret, mintToken(rbacTestSecret, "session-memory", "admin")).
+		WithServiceBinding("knowledge-graph", graph).
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	mcp := withRBAC(client, mcpServer, secret, mintToken(rbacTestSecret, "mcp-server", "agent")).
+		WithServiceBinding("session-memory", memory).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	orchestrator := orchestratorContainer.
+		WithServiceBinding("mcp-server", mcp).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("RBAC_TOKEN", mintToken(rbacTestSecret, "orchestrator", "agent")).
+		WithSecretVariable("ORCH_AGENT_TOKEN", client.SetSecret("rbac-agent-token", agent)).
+		AsService()
+
+	graphBase := fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)
+	goGraphBase := fmt.Sprintf("http://kg-go:%d", knowledgeGraphPort)
+	memoryBase := fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)
+	orchestratorBase := fmt.Sprintf("http://orchestrator:%d", orchestratorPort)
+	node := `{"data": {"id": "rbac-node", "content": "Access control fixture"}}`
+	job := `{"agent_type": "context_gatherer", "target": "rbac-target", "session_id": "rbac-job-session"}`
+	// Each check prints its name and the status it got
+	script := fmt.Sprintf(`check() { name=$1; shift; echo "$name $(curl -sS -o /dev/null -w '%%{http_code}' "$@")"; }
+json='Content-Type: application/json'
+for base in %[1]s %[2]s; do
+  case $base in *kg-go*) svc=go-graph;; *) svc=graph;; esac
+  check $svc-health $base/health
+  check $svc-no-token $base/stats
+  check $svc-forged -H 'Authorization: Bearer %[8]s' $base/stats
+  check $svc-read-only-read -H 'Authorization: Bearer %[7]s' $base/stats
+  check $svc-read-only-write -X POST -H "$json" -H 'Authorization: Bearer %[7]s' -d '%[9]s' $base/nodes
+  check $svc-agent-write -X POST -H "$json" -H 'Authorization: Bearer %[6]s' -d '%[9]s' $base/nodes
+  check $svc-agent-admin -X POST -H "$json" -H 'Authorization: Bearer %[6]s' -d '{"session_id": "rbac"}' $base/purge
+  check $svc-admin-admin -X POST -H "$json" -H 'Authorization: Bearer %[5]s' -d '{"session_id": "rbac"}' $base/purge
+done
+check memory-health %[3]s/health
+check memory-no-token %[3]s/sessions
+check memory-read-only-write -X PUT -H "$json" -H 'Authorization: Bearer %[7]s' -d '{"note": "x"}' %[3]s/sessions/rbac-session
+check memory-agent-write -X PUT -H "$json" -H 'Authorization: Bearer %[6]s' -d '{"note": "x"}' %[3]s/sessions/rbac-session
+check memory-read-only-read -H 'Authorization: Bearer %[7]s' %[3]s/sessions/rbac-session
+check memory-agent-admin -X DELETE -H 'Authorization: Bearer %[6]s' %[3]s/users/rbac-user
+check memory-admin-admin -X DELETE -H 'Authorization: Bearer %[5]s' %[3]s/users/rbac-user
+check mcp-health http://mcp-server:3000/health
+check mcp-no-token http://mcp-server:3000/agents/streams
+check mcp-agent-admin -X POST -H "$json" -H 'Authorization: Bearer %[6]s' -d '{"name": "rbac"}' http://mcp-server:3000/tools/register
+check mcp-admin-admin -X POST -H "$json" -H 'Authorization: Bearer %[5]s' -d '{"name": "rbac"}' http://mcp-server:3000/tools/register
+check mcp-agent-remove -X DELETE -H 'Authorization: Bearer %[6]s' http://mcp-server:3000/tools/rbac
+check mcp-admin-remove -X DELETE -H 'Authorization: Bearer %[5]s' http://mcp-server:3000/tools/rbac
+check mcp-memory-read-only-write -X PUT -H "$json" -H 'Authorization: Bearer %[7]s' -d '{"note": "x"}' http://mcp-server:3000/memory/sessions/rbac-session
+check mcp-memory-read-only-read -H 'Authorization: Bearer %[7]s' http://mcp-server:3000/memory/sessions/rbac-session
+check mcp-memory-agent-operate -X POST -H 'Authorization: Bearer %[6]s' http://mcp-server:3000/memory/sessions/rbac-session/compact
+id=$(curl -fsS -X POST -H "$json" -d '%[10]s' %[4]s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 60); do
+  state=$(curl -fsS %[4]s/jobs/$id)
+  case "$state" in *'"reported":true'*|*'"status":"failed"'*) break;; esac
+  sleep 1
+done
+case "$state" in *'"reported":true'*) echo "job-reported 200";; *) echo "job-reported $state";; esac
+for i in $(seq 30); do
+  curl -fsS -o /dev/null -H 'Authorization: Bearer %[7]s' %[3]s/sessions/rbac-job-session && break
+  sleep 1
+done
+check job-session -H 'Authorization: Bearer %[7]s' %[3]s/sessions/rbac-job-session`,
+		graphBase, goGraphBase, memoryBase, orchestratorBase, admin, agent, readOnly, forged, node, job)
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("knowledge-graph", graph).
+		WithServiceBinding("kg-go", goGraph).
+		WithServiceBinding("session-memory", memory).
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("orchestrator", orchestrator).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	want := map[string]string{
+		"memory-health": "200", "memory-no-token": "401", "memory-read-only-write": "403", "memory-agent-write": "200",
+		"memory-read-only-read": "200", "memory-agent-admin": "403", "memory-admin-admin": "200",
+		"mcp-health": "200", "mcp-no-token": "401", "mcp-agent-admin": "403", "mcp-admin-admin": "200",
+		"mcp-agent-remove": "403", "mcp-admin-remove": "200",
+		"mcp-memory-read-only-write": "403", "mcp-memory-read-only-read": "200", "mcp-memory-agent-operate": "403",
+		"job-reported": "200", "job-session": "200",
+	}
+	for _, svc := range []string{"graph", "go-graph"} {
+		for check, status := range map[string]string{"health": "200", "no-token": "401", "forged": "401",
+			"read-only-read": "200", "read-only-write": "403", "agent-write": "200", "agent-admin": "403", "admin-admin": "200"} {
+			want[svc+"-"+check] = status
+		}
+	}
+	got := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if check, status, ok := strings.Cut(line, " "); ok {
+			got[check] = status
+		}
+	}
+	checks := make([]string, 0, len(want))
+	for check := range want {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		if got[check] != want[check] {
+			return fmt.Errorf("%s answered %q, want %s:\n%s", check, got[check], want[check], output)
+		}
+	}
+
+	fmt.Printf("RBAC: %d checks held across the MCP server, both graphs and session memory\n", len(want))
+	return nil
+}
+
+// rbacPy is the Python side of packages/rbac, which the knowledge graph and
+// session memory enforce the policy with and agents send their token with.
+const rbacPy = `#!/usr/bin/env python3
+"""Access control shared by the services of the dynamic context system.
+
+A caller sends a token, "v1.<claims>.<signature>": its claims, {"sub",
+"role", "exp"}, as base64url JSON, and an HMAC-SHA256 of "v1.<claims>"
+under RBAC_SECRET. The policy file at RBAC_POLICY grants each role actions,
+read, write, delete, operate or admin, and says which each route takes: by
+its method unless a rule says otherwise. packages/rbac holds the Go side and
+the default policy.
+
+Each request is also for a tenant: its X-Tenant-ID, the tenant its token
+is bound to ("tenant" in the claims), or "default". A token bound to a
+tenant may not name another.
+
+A service turns it on with install(app, service) when both variables are
+set, and always resolves each request's tenant, tenant_of(request). A
+client sends RBAC_TOKEN, if it has one, and its tenant with headers().
+"""
+import base64
+import hashlib
+import hmac
+import json
+import os
+import re
+import time
+
+ACTIONS = ("read", "write", "delete", "operate", "admin")
+TENANT_HEADER = "X-Tenant-ID"
+DEFAULT_TENANT = "default"
+TENANT_PATTERN = re.compile(r"^[a-z0-9]+(-[a-z0-9]+)*$")
+INVALID_TENANT = "tenant IDs are 1-32 lowercase letters and digits, in words joined by single hyphens"
+
+
+class Refused(Exception):
+    """A request the policy refuses, with the HTTP status to answer"""
+
+    def __init__(self, status, detail, claims=None):
+        super().__init__(detail)
+        self.status, self.claims = status, claims or {}
+
+
+def _b64(data):
+    return base64.urlsafe_b64encode(data).rstrip(b"=").decode()
+
+
+def _unb64(text):
+    return base64.urlsafe_b64decode(text + "=" * (-len(text) % 4))
+
+
+def _sign(secret, payload):
+    return _b64(hmac.new(secret.encode(), payload.encode(), hashlib.sha256).digest())
+
+
+def mint(secret, role, sub="", ttl=None, tenant=None):
+    """Issues a token; ttl is in seconds, None for one that never expires,
+    and tenant the only one it may act for"""
+    claims = {"sub": sub, "role": role}
+    if tenant:
+        claims["tenant"] = tenant
+    if ttl:
+        claims["exp"] = int(time.time() + ttl)
+    payload = "v1." + _b64(json.dumps(claims, separators=(",", ":")).encode())
+    return f"{payload}.{_sign(secret, payload)}"
+
+
+def verify(secret, token):
+    """The claims of a valid token, or Refused with 401"""
+    parts = token.split(".")
+    if len(parts) != 3 or parts[0] != "v1" or not hmac.compare_digest(
+            _sign(secret, f"{parts[0]}.{parts[1]}"), parts[2]):
+        raise Refused(401, "invalid token")
+    try:
+        claims = json.loads(_unb64(parts[1]))
+    except ValueError:
+        raise Refused(401, "invalid token")
+    if not isinstance(claims, dict) or not claims.get("role"):
+        raise Refused(401, "invalid token")
+    if claims.get("exp") and time.time() >= claims["exp"]:
+        expired = time.strftime("%Y-%m-%dT%H:%M:%SZ", time.gmtime(claims["exp"]))
+        raise Refused(401, f"token has expired: {expired}")
+    return claims
+
+
+def _route(pattern):
+    """A "METHOD /path" pattern, where * matches within a segment and **
+    across any number, as (method, regex)"""
+    method, _, path = pattern.strip().partition(" ")
+    path = path.strip()
+    if not method or not path.startswith(("/", "**")):
+        raise ValueError(f'route {pattern!r} is not "METHOD /path"')
+    expr = re.sub(r"\\\*\\\*|\\\*", lambda m: ".*" if len(m.group()) == 4 else "[^/]*", re.escape(path))
+    return method.upper(), re.compile(f"^{expr}$")
+
+
+def _matches(route, method, path):
+    return route[0] in ("*", method) and route[1].match(path) is not None
+
+
+def valid_tenant(tenant):
+    return len(tenant) <= 32 and TENANT_PATTERN.match(tenant) is not None
+
+
+def resolve_tenant(requested, claims):
+    """The tenant a request naming requested is for, given its bearer's
+    claims, or Refused"""
+    bound = (claims or {}).get("tenant")
+    if bound:
+        if requested and requested != bound:
+            raise Refused(403, f"the token is for tenant {bound}", claims)
+        requested = bound
+    tenant = requested or DEFAULT_TENANT
+    if not valid_tenant(tenant):
+        raise Refused(400, INVALID_TENANT, claims)
+    return tenant
+
+
+class Policy:
+    def __init__(self, policy):
+        self.roles = policy.get("roles") or {}
+        if not self.roles:
+            raise ValueError("the policy has no roles")
+        for role, granted in self.roles.items():
+            unknown = [a for a in granted if a != "*" and a not in ACTIONS]
+            if unknown:
+                raise ValueError(f"role {role}: unknown action {unknown[0]!r}")
+        self.public = [_route(p) for p in policy.get("public") or ()]
+        self.rules = []
+        for i, rule in enumerate(policy.get("rules") or (), 1):
+            if rule.get("action") not in ACTIONS:
+                raise ValueError(f"rule {i}: unknown action {rule.get('action')!r}")
+            self.rules.append((rule.get("service"), _route(rule["route"]), rule["action"]))
+
+    @classmethod
+    def load(cls, path):
+        with open(path) as f:
+            return cls(json.load(f))
+
+    def is_public(self, method, path):
+        return any(_matches(route, method, path) for route in self.public)
+
+    def action(self, service, method, path):
+        for rule_service, route, action in self.rules:
+            if rule_service in (None, "", service) and _matches(route, method, path):
+                return action
+        if method in ("GET", "HEAD", "OPTIONS"):
+            return "read"
+        return "delete" if method == "DELETE" else "write"
+
+    def allows(self, role, action):
+        granted = self.roles.get(role) or ()
+        return "*" in granted or action in granted
+
+
+class Authorizer:
+    def __init__(self, service, secret, policy):
+        self.service, self.secret, self.policy = service, secret, policy
+
+    @classmethod
+    def from_env(cls, service):
+        """None when neither RBAC_SECRET nor RBAC_POLICY is set; one without
+        the other is an error, so a service is never left open by a missing
+        file"""
+        secret, path = os.getenv("RBAC_SECRET"), os.getenv("RBAC_POLICY")
+        if not secret and not path:
+            return None
+        if not secret or not path:
+            missing = "RBAC_SECRET" if not secret else "RBAC_POLICY"
+            raise RuntimeError(f"{missing} must be set with {'RBAC_POLICY' if not secret else 'RBAC_SECRET'}")
+        return cls(service, secret, Policy.load(path))
+
+    def authorize(self, method, path, authorization):
+        """The bearer's claims, {} on a public route, or Refused"""
+        if self.policy.is_public(method, path):
+            return {}
+        if not (authorization or "").startswith("Bearer ") or not authorization[7:]:
+            raise Refused(401, "a bearer token is required")
+        claims = verify(self.secret, authorization[7:])
+        action = self.policy.action(self.service, method, path)
+        if not self.policy.allows(claims["role"], action):
+            raise Refused(403, f"role {claims['role']} may not {action} here", claims)
+        return claims
+
+
+def install(app, service):
+    """Resolves each request's tenant on a FastAPI app, and enforces the
+    policy when RBAC_SECRET and RBAC_POLICY are set. Refusals answer
+    {"detail": ...} like the app's own errors; the bearer's claims are
+    request.state.claims and the tenant request.state.tenant."""
+    # Agents import this module for headers() without FastAPI installed
+    from fastapi.responses import JSONResponse
+
+    authorizer = Authorizer.from_env(service)
+
+    @app.middleware("http")
+    async def enforce(request, call_next):
+        try:
+            claims = {}
+            if authorizer is not None:
+                claims = authorizer.authorize(request.method, request.url.path,
+                                              request.headers.get("authorization"))
+            request.state.claims = claims
+            request.state.tenant = resolve_tenant(request.headers.get(TENANT_HEADER), claims)
+        except Refused as e:
+            if e.status == 403:
+                who = e.claims["role"] + (f" ({e.claims['sub']})" if e.claims.get("sub") else "")
+                print(f"🔒 Refused {request.method} {request.url.path} to {who}")
+            headers = {"WWW-Authenticate": "Bearer"} if e.status == 401 else None
+            return JSONResponse(status_code=e.status, content={"detail": str(e)}, headers=headers)
+        return await call_next(request)
+
+    if authorizer is not None:
+        print(f"🔒 Access control on for {service}")
+    return authorizer
+
+
+def tenant_of(request):
+    """The tenant install() resolved for a request"""
+    return getattr(request.state, "tenant", DEFAULT_TENANT)
+
+
+def headers(tenant=None):
+    """The headers for calls to the other services: Authorization, with
+    RBAC_TOKEN, and X-Tenant-ID, for tenant or else AGENT_TENANT, which the
+    orchestrator gives agents. Empty without either."""
+    token, tenant = os.getenv("RBAC_TOKEN"), tenant or os.getenv("AGENT_TENANT")
+    result = {"Authorization": f"Bearer {token}"} if token else {}
+    if tenant:
+        result[TENANT_HEADER] = tenant
+    return result
+`
+
+// rbacJs is the MCP server's side of packages/rbac.
+const rbacJs = `// Access control shared by the services of the dynamic context system:
+// the same tokens and policy file as packages/rbac and rbac.py.
+const crypto = require('crypto');
+const fs = require('fs');
+
+const ACTIONS = ['read', 'write', 'delete', 'operate', 'admin'];
+const TENANT_HEADER = 'X-Tenant-ID';
+const DEFAULT_TENANT = 'default';
+const TENANT_PATTERN = /^[a-z0-9]+(-[a-z0-9]+)*$/;
+const INVALID_TENANT = 'tenant IDs are 1-32 lowercase letters and digits, in words joined by single hyphens';
+
+class Refused extends Error {
+    constructor(status, message, claims) {
+        super(message);
+        this.status = status;
+        this.claims = claims || {};
+    }
+}
+
+function sign(secret, payload) {
+    return crypto.createHmac('sha256', secret).update(payload).digest('base64url');
+}
+
+// The claims of a valid token, or Refused with 401
+function verify(secret, token) {
+    const parts = token.split('.');
+    if (parts.length !== 3 || parts[0] !== 'v1') {
+        throw new Refused(401, 'invalid token');
+    }
+    const expected = Buffer.from(sign(secret, parts[0] + '.' + parts[1]));
+    const given = Buffer.from(parts[2]);
+    if (expected.length !== given.length || !crypto.timingSafeEqual(expected, given)) {
+        throw new Refused(401, 'invalid token');
+    }
+    let claims;
+    try {
+        claims = JSON.parse(Buffer.from(parts[1], 'base64url').toString());
+    } catch (error) {
+        throw new Refused(401, 'invalid token');
+    }
+    if (!claims || typeof claims !== 'object' || !claims.role) {
+        throw new Refused(401, 'invalid token');
+    }
+    if (claims.exp && Date.now() / 1000 >= claims.exp) {
+        throw new Refused(401, 'token has expired: ' + new Date(claims.exp * 1000).toISOString().replace(/\.\d+Z$/, 'Z'));
+    }
+    return claims;
+}
+
+function validTenant(tenant) {
+    return typeof tenant === 'string' && tenant.length <= 32 && TENANT_PATTERN.test(tenant);
+}
+
+// The tenant a request naming requested is for, given its bearer's claims,
+// or Refused: a token bound to a tenant may not name another
+function resolveTenant(requested, claims) {
+    const bound = (claims || {}).tenant;
+    if (bound) {
+        if (requested && requested !== bound) {
+            throw new Refused(403, 'the token is for tenant ' + bound, claims);
+        }
+        requested = bound;
+    }
+    const tenant = requested || DEFAULT_TENANT;
+    if (!validTenant(tenant)) {
+        throw new Refused(400, INVALID_TENANT, claims);
+    }
+    return tenant;
+}
+
+// A "METHOD /path" pattern, where * matches within a segment and ** across
+// any number
+function route(pattern) {
+    const [method, ...rest] = pattern.trim().split(' ');
+    const path = rest.join(' ').trim();
+    if (!method || !(path.startsWith('/') || path.startsWith('**'))) {
+        throw new Error('route ' + JSON.stringify(pattern) + ' is not "METHOD /path"');
+    }
+    const expr = path.split(/(\*\*|\*)/).map((part) =>
+        part === '**' ? '.*' : part === '*' ? '[^/]*' : part.replace(/[.+?^${}()|[\]\\]/g, '\\$&')).join('');
+    return { method: method.toUpperCase(), path: new RegExp('^' + expr + '$') };
+}
+
+function matches(r, method, path) {
+    return (r.method === '*' || r.method === method) && r.path.test(path);
+}
+
+class Policy {
+    constructor(policy) {
+        this.roles = policy.roles || {};
+        if (Object.keys(this.roles).length === 0) {
+            throw new Error('the policy has no roles');
+        }
+        for (const [role, granted] of Object.entries(this.roles)) {
+            const unknown = granted.find((action) => action !== '*' && !ACTIONS.includes(action));
+            if (unknown) {
+                throw new Error('role ' + role + ': unknown action ' + JSON.stringify(unknown));
+            }
+        }
+        this.public = (policy.public || []).map(route);
+        this.rules = (policy.rules || []).map((rule, i) => {
+            if (!ACTIONS.includes(rule.action)) {
+                throw new Error('rule ' + (i + 1) + ': unknown action ' + JSON.stringify(rule.action));
+            }
+            return { service: rule.service, route: route(rule.route), action: rule.action };
+        });
+    }
+
+    isPublic(method, path) {
+        return this.public.some((r) => matches(r, method, path));
+    }
+
+    action(service, method, path) {
+        const rule = this.rules.find((r) => (!r.service || r.service === service) && matches(r.route, method, path));
+        if (rule) {
+            return rule.action;
+        }
+        if (['GET', 'HEAD', 'OPTIONS'].includes(method)) {
+            return 'read';
+        }
+        return method === 'DELETE' ? 'delete' : 'write';
+    }
+
+    allows(role, action) {
+        const granted = this.roles[role] || [];
+        return granted.includes('*') || granted.includes(action);
+    }
+}
+
+class Authorizer {
+    constructor(secret, policy) {
+        this.secret = secret;
+        this.policy = policy;
+    }
+
+    // null when neither RBAC_SECRET nor RBAC_POLICY is set; one without the
+    // other is an error, so the server is never left open by a missing file
+    static fromEnv() {
+        const secret = process.env.RBAC_SECRET;
+        const path = process.env.RBAC_POLICY;
+        if (!secret && !path) {
+            return null;
+        }
+        if (!secret || !path) {
+            throw new Error((secret ? 'RBAC_POLICY' : 'RBAC_SECRET') + ' must be set with ' + (secret ? 'RBAC_SECRET' : 'RBAC_POLICY'));
+        }
+        return new Authorizer(secret, new Policy(JSON.parse(fs.readFileSync(path, 'utf8'))));
+    }
+
+    // The bearer's claims, {} on a public route, or Refused. A request to
+    // service, such as "memory" for what the MCP server passes on, takes
+    // that service's rules.
+    authorize(service, method, path, authorization) {
+        if (this.policy.isPublic(method, path)) {
+            return {};
+        }
+        if (!authorization || !authorization.startsWith('Bearer ') || authorization.length === 7) {
+            throw new Refused(401, 'a bearer token is required');
+        }
+        const claims = verify(this.secret, authorization.slice(7));
+        const action = this.policy.action(service, method, path);
+        if (!this.policy.allows(claims.role, action)) {
+            throw new Refused(403, 'role ' + claims.role + ' may not ' + action + ' here', claims);
+        }
+        return claims;
+    }
+}
+
+// The headers for calls to the other services: Authorization, with
+// RBAC_TOKEN, and X-Tenant-ID when a tenant is given; empty without either
+function headers(tenant) {
+    const result = process.env.RBAC_TOKEN ? { Authorization: 'Bearer ' + process.env.RBAC_TOKEN } : {};
+    if (tenant) {
+        result[TENANT_HEADER] = tenant;
+    }
+    return result;
+}
+
+module.exports = { Authorizer, Policy, Refused, headers, verify, resolveTenant, validTenant, TENANT_HEADER, DEFAULT_TENANT };
+`
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// maxSmokeSessions is how many of a backup's sessions are read back after
+// it is restored.
+const maxSmokeSessions = 5
+
+// latestBackup is the newest control plane backup archive in dir.
+func latestBackup(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	latest, latestInfo := "", os.FileInfo(nil)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tar.gz") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		if latestInfo == nil || info.ModTime().After(latestInfo.ModTime()) {
+			latest, latestInfo = filepath.Join(dir, entry.Name()), info
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no backups (*.tar.gz) in %s", dir)
+	}
+	return latest, nil
+}
+
+// smokeCheck is one query against a restored service and what its answer
+// must contain.
+type smokeCheck struct {
+	name   string
+	curl   string
+	expect string
+}
+
+// smokeChecks reads a backup archive and lists the queries that prove it
+// restored: every graph has its nodes and edges and answers for one of
+// them, session memory holds as many records and answers for a few of its
+// sessions, and the MCP server has every tool.
+func smokeChecks(archive string) ([]smokeCheck, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if files[header.Name], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+
+	graph := fmt.Sprintf("http://restore-graph:%d", knowledgeGraphPort)
+	memory := fmt.Sprintf("http://restore-memory:%d", sessionMemoryPort)
+	var checks []smokeCheck
+	if data, ok := files["graph.jsonl"]; ok {
+		graphChecks, err := graphSmokeChecks(data, graph)
+		if err != nil {
+			return nil, fmt.Errorf("graph.jsonl: %w", err)
+		}
+		checks = append(checks, graphChecks...)
+	}
+	if data, ok := files["memory.snapshot.json.gz"]; ok {
+		memoryChecks, err := memorySmokeChecks(data, memory)
+		if err != nil {
+			return nil, fmt.Errorf("memory.snapshot.json.gz: %w", err)
+		}
+		checks = append(checks, memoryChecks...)
+	}
+	if data, ok := files["tools.json"]; ok {
+		var registry struct {
+			Tools map[string]map[string]json.RawMessage `json:"tools"`
+		}
+		if err := json.Unmarshal(data, &registry); err != nil {
+			return nil, fmt.Errorf("tools.json: %w", err)
+		}
+		for tenant, tools := range registry.Tools {
+			for name := range tools {
+				checks = append(checks, smokeCheck{name: fmt.Sprintf("tool %s of tenant %s", name, tenant),
+					curl: "curl -fsS http://restore-mcp:3000/backup", expect: fmt.Sprintf(`"%s":{`, name)})
+			}
+		}
+	}
+	if len(checks) == 0 {
+		return nil, errors.New("the backup holds nothing to check")
+	}
+	sort.SliceStable(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+	return checks, nil
+}
+
+// graphSmokeChecks checks each graph's counts and that its first live node
+// can be read, as the tenant it belongs to.
+func graphSmokeChecks(data []byte, base string) ([]smokeCheck, error) {
+	type section struct {
+		id, node     string
+		nodes, edges int
+	}
+	var sections []*section
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record struct {
+			Kind  string         `json:"kind"`
+			ID    string         `json:"id"`
+			Attrs map[string]any `json:"attrs"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		switch {
+		case record.Kind == "graph":
+			sections = append(sections, &section{id: record.ID})
+		case len(sections) == 0:
+			return nil, errors.New("a node or edge before any graph")
+		case record.Kind == "node":
+			current := sections[len(sections)-1]
+			current.nodes++
+			if _, tombstoned := record.Attrs["tombstoned_at"]; current.node == "" && !tombstoned {
+				current.node = record.ID
+			}
+		case record.Kind == "edge":
+			sections[len(sections)-1].edges++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var checks []smokeCheck
+	for _, s := range sections {
+		// Stored as <tenant>__<graph>, the tenant's hyphens as underscores
+		tenant, graphID := "default", s.id
+		if before, after, found := strings.Cut(s.id, "__"); found {
+			tenant, graphID = strings.ReplaceAll(before, "_", "-"), after
+		}
+		url := fmt.Sprintf("%s/graphs/%s", base, graphID)
+		header := "-H 'X-Tenant-ID: " + tenant + "'"
+		checks = append(checks, smokeCheck{name: "graph " + s.id + " counts",
+			curl: fmt.Sprintf("curl -fsS %s %s/stats", header, url), expect: fmt.Sprintf(`"nodes":%d,"edges":%d`, s.nodes, s.edges)})
+		if s.node != "" {
+			checks = append(checks, smokeCheck{name: "graph " + s.id + " node " + s.node,
+				curl: fmt.Sprintf("curl -sS -o /dev/null -w '%%{http_code}' %s %s/nodes/%s", header, url, s.node), expect: "200"})
+		}
+	}
+	return checks, nil
+}
+
+// memorySmokeChecks checks session memory holds as many records as the
+// snapshot and, unless it was encrypted, that a few sessions can be read
+// as their tenants.
+func memorySmokeChecks(data []byte, base string) ([]smokeCheck, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	var header struct {
+		Encryption string `json:"encryption"`
+	}
+	var footer struct {
+		Records int `json:"records"`
+	}
+	if len(lines) < 2 || json.Unmarshal([]byte(lines[0]), &header) != nil || json.Unmarshal([]byte(lines[len(lines)-1]), &footer) != nil {
+		return nil, errors.New("not a session memory snapshot")
+	}
+	checks := []smokeCheck{{name: "session memory records",
+		curl: fmt.Sprintf("curl -fsS -D - -o /dev/null %s/backup | tr -d '\\r'", base), expect: fmt.Sprintf("x-backup-records: %d", footer.Records)}}
+	if header.Encryption != "" && header.Encryption != "none" {
+		return checks, nil
+	}
+	sessions := 0
+	for _, line := range lines[1 : len(lines)-1] {
+		var record struct {
+			Tier  string `json:"tier"`
+			Kind  string `json:"kind"`
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}
+		if json.Unmarshal([]byte(line), &record) != nil || record.Tier != "hot" || record.Kind != "kv" {
+			continue
+		}
+		id, ok := strings.CutPrefix(record.Key, "session:")
+		if !ok || strings.ContainsAny(id, "/ '") {
+			continue
+		}
+		var context struct {
+			Tenant string `json:"tenant"`
+		}
+		if json.Unmarshal([]byte(record.Value), &context) != nil {
+			continue
+		}
+		tenant := context.Tenant
+		if tenant == "" {
+			tenant = "default"
+		}
+		checks = append(checks, smokeCheck{name: "session " + id,
+			curl:   fmt.Sprintf("curl -sS -o /dev/null -w '%%{http_code}' -H 'X-Tenant-ID: %s' %s/sessions/%s", tenant, base, id),
+			expect: "200"})
+		if sessions++; sessions == maxSmokeSessions {
+			break
+		}
+	}
+	return checks, nil
+}
+
+// verifyRestore proves the newest backup in BACKUP_DIR restores: it
+// restores the archive with the control plane into throwaway session
+// memory, knowledge graph and MCP server containers, then runs smoke
+// queries against them. It only runs when BACKUP_DIR is set on the host.
+func verifyRestore(ctx context.Context, client *dagger.Client, controlPlane, knowledgeGraph, mcpServer, sessionMemory *dagger.Container) error {
+	dir := os.Getenv("BACKUP_DIR")
+	if dir == "" {
+		fmt.Println("⏭️ Skipping restore verification: BACKUP_DIR is not set")
+		return nil
+	}
+	fmt.Println("🧪 Verifying the Latest Backup Restores...")
+
+	archive, err := latestBackup(dir)
+	if err != nil {
+		return err
+	}
+	checks, err := smokeChecks(archive)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", archive, err)
+	}
+
+	// Fresh stores, thrown away with the containers: SQLite sessions and
+	// the graph in memory with a scanned index
+	memory := withSQLite(sessionMemory).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	graph := knowledgeGraph.
+		WithEnvVariable("KG_BACKEND", "memory").
+		WithEnvVariable("KG_VECTOR_INDEX", "scan").
+		WithEnvVariable("KG_PORT", fmt.Sprint(knowledgeGraphPort)).
+		WithExposedPort(knowledgeGraphPort).
+		WithExec([]string{"python3", "/app/kg_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	mcp := mcpServer.
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	var script strings.Builder
+	script.WriteString(`set -e
+check() { if printf '%s' "$2" | grep -qF -- "$3"; then echo "ok $1"; else echo "FAILED $1: $2"; fi; }
+control-plane restore /app/topology.json /backup/backup.tar.gz >&2
+set +e
+`)
+	for _, check := range checks {
+		fmt.Fprintf(&script, "check '%s' \"$(%s)\" '%s'\n", check.name, check.curl, check.expect)
+	}
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithFile("/usr/local/bin/control-plane", controlPlane.File("/usr/local/bin/control-plane")).
+		WithFile("/backup/backup.tar.gz", client.Host().File(archive)).
+		WithNewFile("/app/topology.json", dagger.ContainerWithNewFileOpts{Contents: backupTopology("restore")}).
+		WithServiceBinding("restore-memory", memory).
+		WithServiceBinding("restore-graph", graph).
+		WithServiceBinding("restore-mcp", mcp).
+		WithExec([]string{"sh", "-c", script.String()}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("restoring %s: %w", archive, err)
+	}
+	var failed []string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if strings.HasPrefix(line, "FAILED ") {
+			failed = append(failed, strings.TrimPrefix(line, "FAILED "))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s restored, but %d of %d smoke queries failed:\n%s", archive, len(failed), len(checks), strings.Join(failed, "\n"))
+	}
+
+	fmt.Printf("Restore Verification: %s restored and passed %d smoke queries\n", filepath.Base(archive), len(checks))
+	return nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const genUsage = `usage: go run ./dagger gen component [--port N] NAME
+
+Writes a new Go component to packages/NAME, its builder and test to
+dagger/NAME_component.go and its entry to pipeline.json, from the
+repository root.`
+
+// scaffold has the templates of a new component: the package's files under
+// package, and the pipeline's file of its builder and test. A template is
+// written without its .tmpl suffix, which keeps go.mod and *.go templates
+// out of this module's build.
+//
+//go:embed scaffold
+var scaffold embed.FS
+
+var componentNamePattern = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+
+// firstComponentPort is where generated components' ports start, above
+// those of the built-in components.
+const firstComponentPort = 8100
+
+// scaffoldComponent is what the templates are filled in with.
+type scaffoldComponent struct {
+	Name, Var, Title  string
+	EnvPrefix, Module string
+	File              string
+	Port              int
+}
+
+func newScaffoldComponent(name string, port int) scaffoldComponent {
+	words := strings.Split(name, "-")
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return scaffoldComponent{
+		Name:      name,
+		Var:       name[:len(words[0])] + strings.Join(words[1:], ""),
+		Title:     strings.Join(words, " "),
+		EnvPrefix: strings.ToUpper(strings.ReplaceAll(name, "-", "_")),
+		Module:    "github.com/jayp41/dynamic-context-mcp-system/packages/" + name,
+		// Not NAME.go: a name ending in -test or -linux would make it a
+		// test or a file built only on Linux
+		File: strings.ReplaceAll(name, "-", "_") + "_component.go",
+		Port: port,
+	}
+}
+
+// generate runs `go run ./dagger gen component NAME`, which needs no Dagger
+// engine: it writes a new component and adds it to pipeline.json, so the
+// pipeline builds and tests it without runPipeline changing.
+func generate(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "component" {
+		return fmt.Errorf("bad arguments\n%s", genUsage)
+	}
+	flags := flag.NewFlagSet("gen component", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	port := flags.Int("port", 0, "")
+	// Flags may come before or after the name
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%v\n%s", err, genUsage)
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("bad arguments\n%s", genUsage)
+	}
+	name := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[1:]); err != nil || flags.NArg() > 0 {
+		return fmt.Errorf("bad arguments\n%s", genUsage)
+	}
+	if !componentNamePattern.MatchString(name) {
+		return fmt.Errorf("component name %q must be lowercase letters and digits, words joined by -", name)
+	}
+	if _, ok := componentBuilders[name]; ok {
+		return fmt.Errorf("%s is a component of the pipeline already", name)
+	}
+	for _, service := range deployedServices {
+		if service.name == name {
+			return fmt.Errorf("%s is a service of the pipeline already", name)
+		}
+	}
+
+	configPath := pipelineConfigPath()
+	config, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	components, err := configComponents(configPath, config)
+	if err != nil {
+		return err
+	}
+	if _, ok := components[name]; ok {
+		return fmt.Errorf("%s has %s already", configPath, name)
+	}
+	taken, err := takenPorts(configPath, components)
+	if err != nil {
+		return err
+	}
+	if *port == 0 {
+		*port = firstComponentPort
+		for taken[*port] != "" {
+			*port++
+		}
+	}
+	if *port < 1 || *port > 65535 {
+		return fmt.Errorf("port %d is not between 1 and 65535", *port)
+	}
+	if other := taken[*port]; other != "" {
+		return fmt.Errorf("%s has port %d already", other, *port)
+	}
+
+	component := newScaffoldComponent(name, *port)
+	files, err := component.render()
+	if err != nil {
+		return err
+	}
+	for file := range files {
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("%s exists already", file)
+		}
+	}
+	if entries, err := os.ReadDir(filepath.Join("packages", name)); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", filepath.Join("packages", name))
+	}
+
+	entry, err := json.Marshal(componentConfig{Source: path.Join("packages", name), Port: *port})
+	if err != nil {
+		return err
+	}
+	components[name] = entry
+	if config["components"], err = json.Marshal(components); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join("packages", name), 0o755); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for file := range files {
+		names = append(names, file)
+	}
+	sort.Strings(names)
+	for _, file := range names {
+		if err := os.WriteFile(file, files[file], 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "wrote %s\n", file)
+	}
+	if err := os.WriteFile(configPath, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "added %s to %s on port %d\n", name, configPath, *port)
+	fmt.Fprintf(out, "\nThe pipeline builds and tests %s now; see %s.\n",
+		name, filepath.Join("packages", name, "README.md"))
+	return nil
+}
+
+// readConfigFile reads pipeline.json keeping what it has besides the
+// components as it is; without the file it is empty.
+func readConfigFile(path string) (map[string]json.RawMessage, error) {
+	config := map[string]json.RawMessage{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+func configComponents(path string, config map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	components := map[string]json.RawMessage{}
+	if raw, ok := config["components"]; ok {
+		if err := json.Unmarshal(raw, &components); err != nil {
+			return nil, fmt.Errorf("%s: components: %w", path, err)
+		}
+	}
+	return components, nil
+}
+
+// takenPorts are the services the pipeline deploys and the components of
+// pipeline.json by the ports they have.
+func takenPorts(path string, components map[string]json.RawMessage) (map[int]string, error) {
+	taken := map[int]string{}
+	for _, service := range deployedServices {
+		for _, port := range service.ports {
+			taken[port] = service.name
+		}
+	}
+	for name, raw := range components {
+		var component componentConfig
+		if err := json.Unmarshal(raw, &component); err != nil {
+			return nil, fmt.Errorf("%s: component %s: %w", path, name, err)
+		}
+		taken[component.Port] = name
+	}
+	return taken, nil
+}
+
+// render fills in the templates, by the paths of the files they become
+// relative to the repository root.
+func (c scaffoldComponent) render() (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := fs.WalkDir(scaffold, "scaffold", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		tmpl, err := template.ParseFS(scaffold, name)
+		if err != nil {
+			return err
+		}
+		var file bytes.Buffer
+		if err := tmpl.Execute(&file, c); err != nil {
+			return err
+		}
+		target := filepath.Join("dagger", c.File)
+		if dir, base := path.Split(strings.TrimPrefix(name, "scaffold/")); dir == "package/" {
+			target = filepath.Join("packages", c.Name, strings.TrimSuffix(base, ".tmpl"))
+		}
+		content := file.Bytes()
+		if strings.HasSuffix(target, ".go") {
+			if content, err = format.Source(content); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		files[target] = content
+		return nil
+	})
+	return files, err
+}
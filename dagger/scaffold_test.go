@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inEmptyRepo runs the test from a repository root with nothing but an
+// empty dagger directory, as generate writes relative to the root.
+func inEmptyRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "dagger"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("PIPELINE_CONFIG", "")
+	return dir
+}
+
+func TestGenerateRefusesServicePorts(t *testing.T) {
+	for _, service := range deployedServices {
+		for _, port := range service.ports {
+			t.Run(fmt.Sprintf("%s:%d", service.name, port), func(t *testing.T) {
+				dir := inEmptyRepo(t)
+				err := generate([]string{"component", "--port", fmt.Sprint(port), "billing-api"}, io.Discard)
+				if err == nil || !strings.Contains(err.Error(), service.name) {
+					t.Fatalf("generate on port %d = %v, want an error naming %s", port, err, service.name)
+				}
+				for _, file := range []string{defaultPipelineConfig, filepath.Join("packages", "billing-api")} {
+					if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+						t.Errorf("%s was written", file)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestGenerateRefusesTakenPorts(t *testing.T) {
+	inEmptyRepo(t)
+	if err := generate([]string{"component", "billing-api"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"component", "--port", fmt.Sprint(firstComponentPort), "invoice-api"},
+		{"component", "--port", "-1", "invoice-api"},
+		{"component", "--port", "65536", "invoice-api"},
+	} {
+		if err := generate(args, io.Discard); err == nil {
+			t.Errorf("generate %s succeeded", strings.Join(args, " "))
+		}
+	}
+	if err := generate([]string{"component", "invoice-api"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	config, err := readConfigFile(defaultPipelineConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	components, err := configComponents(defaultPipelineConfig, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken, err := takenPorts(defaultPipelineConfig, components)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taken[firstComponentPort] != "billing-api" || taken[firstComponentPort+1] != "invoice-api" {
+		t.Errorf("ports = %v, want billing-api on %d and invoice-api on %d", taken, firstComponentPort, firstComponentPort+1)
+	}
+}
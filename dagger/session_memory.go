@@ -0,0 +1,5845 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const (
+	sessionPostgresPassword = "session-memory"
+	minioTestUser           = "session-archive"
+	minioTestPassword       = "session-archive-secret"
+	sessionMemoryPort       = 8090
+	// base64 of "session-memory-test-key-32-bytes"
+	sessionTestKey = "c2Vzc2lvbi1tZW1vcnktdGVzdC1rZXktMzItYnl0ZXM="
+)
+
+// Redis Service - Hot storage for session context and summaries
+func buildRedisService(client *dagger.Client) *dagger.Service {
+	fmt.Println("🟥 Building Redis Service...")
+
+	return client.Container().
+		From("redis:7-alpine").
+		WithExposedPort(6379).
+		AsService()
+}
+
+// Postgres Service - Durable session history for larger deployments
+func buildSessionPostgresService(client *dagger.Client) *dagger.Service {
+	fmt.Println("🐘 Building Session Postgres Service...")
+
+	return client.Container().
+		From("postgres:16-alpine").
+		WithEnvVariable("POSTGRES_PASSWORD", sessionPostgresPassword).
+		WithEnvVariable("POSTGRES_DB", "session_memory").
+		WithExposedPort(5432).
+		AsService()
+}
+
+// MinIO Service - S3-compatible object storage for archived sessions
+func buildMinioService(client *dagger.Client) *dagger.Service {
+	fmt.Println("🪣 Building MinIO Service...")
+
+	return client.Container().
+		From("minio/minio:latest").
+		WithEnvVariable("MINIO_ROOT_USER", minioTestUser).
+		WithEnvVariable("MINIO_ROOT_PASSWORD", minioTestPassword).
+		WithExposedPort(9000).
+		WithExec([]string{"server", "/data"}).
+		AsService()
+}
+
+func init() {
+	registerComponent(sessionMemoryComponent{})
+}
+
+// sessionMemoryComponent is session memory's builder, tested on Redis,
+// Postgres and MinIO, with the knowledge graph it links sessions to and
+// the MCP server in front of it.
+type sessionMemoryComponent struct{ publishContainer }
+
+func (sessionMemoryComponent) Name() string { return "session-memory" }
+
+func (sessionMemoryComponent) Needs() []string {
+	return []string{"knowledge-graph", "mcp-server", "redis", "postgres", "minio", "neo4j", "qdrant"}
+}
+
+func (sessionMemoryComponent) Build(ctx context.Context, client *dagger.Client, built map[string]*dagger.Container) *dagger.Container {
+	return buildSessionMemoryContainer(ctx, client)
+}
+
+func (c sessionMemoryComponent) Test(ctx context.Context, client *dagger.Client, built map[string]*dagger.Container, services map[string]*dagger.Service) error {
+	container, redis, postgres, minio := built[c.Name()], services["redis"], services["postgres"], services["minio"]
+	knowledgeGraphAPI := knowledgeGraphService(built["knowledge-graph"], services["neo4j"], services["qdrant"])
+
+	if err := testSessionMemory(ctx, container, redis, postgres); err != nil {
+		return fmt.Errorf("session memory test failed: %w", err)
+	}
+
+	if err := testSessionTiering(ctx, container, redis, postgres, minio); err != nil {
+		return fmt.Errorf("session memory tiering test failed: %w", err)
+	}
+
+	if err := testSessionEncryption(ctx, container); err != nil {
+		return fmt.Errorf("session memory encryption test failed: %w", err)
+	}
+
+	if err := testSessionSummarization(ctx, container); err != nil {
+		return fmt.Errorf("session memory summarization test failed: %w", err)
+	}
+
+	if err := testSessionEventLog(ctx, container, redis); err != nil {
+		return fmt.Errorf("session memory event log test failed: %w", err)
+	}
+
+	if err := testSessionEviction(ctx, container); err != nil {
+		return fmt.Errorf("session memory eviction test failed: %w", err)
+	}
+
+	if err := testSessionRecall(ctx, container); err != nil {
+		return fmt.Errorf("session memory recall test failed: %w", err)
+	}
+
+	if err := testSessionPacking(ctx, container); err != nil {
+		return fmt.Errorf("session memory packing test failed: %w", err)
+	}
+
+	if err := testSessionConcurrency(ctx, container); err != nil {
+		return fmt.Errorf("session memory concurrency test failed: %w", err)
+	}
+
+	if err := testSessionDedup(ctx, container); err != nil {
+		return fmt.Errorf("session memory dedup test failed: %w", err)
+	}
+
+	if err := testSessionQuotas(ctx, container); err != nil {
+		return fmt.Errorf("session memory quota test failed: %w", err)
+	}
+
+	if err := testSessionLongTermMemory(ctx, container, redis); err != nil {
+		return fmt.Errorf("session long-term memory test failed: %w", err)
+	}
+
+	if err := testSessionDeletion(ctx, client, container, redis, knowledgeGraphAPI); err != nil {
+		return fmt.Errorf("session memory deletion test failed: %w", err)
+	}
+
+	if err := testSessionBundles(ctx, client, container, redis, knowledgeGraphAPI); err != nil {
+		return fmt.Errorf("session memory bundle test failed: %w", err)
+	}
+
+	sessionMemoryAPI := sessionMemoryService(container, redis)
+	if err := testSessionMemoryAPI(ctx, client, sessionMemoryAPI, built["mcp-server"]); err != nil {
+		return fmt.Errorf("session memory API test failed: %w", err)
+	}
+	return nil
+}
+
+// Session Memory Container - Persistent context with LLM summarization
+func buildSessionMemoryContainer(ctx context.Context, client *dagger.Client) *dagger.Container {
+	fmt.Println("🧠 Building Session Memory Container...")
+
+	return client.Container().
+		From("python:3.11-slim").
+		WithWorkdir("/app").
+		WithExec([]string{"pip", "install", "redis", "psycopg[binary]", "boto3", "zstandard", "fastapi", "uvicorn", "cryptography", "tiktoken", "sentence-transformers"}).
+		// Fetch the tokenizer encodings at build time, not on the first packing request
+		WithExec([]string{"python", "-c", "import tiktoken; [tiktoken.get_encoding(name) for name in ('cl100k_base', 'o200k_base')]"}).
+		WithNewFile("/app/event_bus.py", dagger.ContainerWithNewFileOpts{
+			Contents:    eventBusPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/config_client.py", dagger.ContainerWithNewFileOpts{
+			Contents:    configClientPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/rbac.py", dagger.ContainerWithNewFileOpts{
+			Contents:    rbacPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/tracing.py", dagger.ContainerWithNewFileOpts{
+			Contents:    tracingPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/api_version.py", dagger.ContainerWithNewFileOpts{
+			Contents:    apiVersionPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/logs.py", dagger.ContainerWithNewFileOpts{
+			Contents:    loggingPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_store.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionStorePy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_search.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionSearchPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_crypto.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionCryptoPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_bundle.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionBundlePy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/long_term_memory.py", dagger.ContainerWithNewFileOpts{
+			Contents:    longTermMemoryPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_quotas.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionQuotasPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_summarizer.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionSummarizerPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_packing.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionPackingPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_eviction.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionEvictionPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_events.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionEventsPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_snapshot.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionSnapshotPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_dedup.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionDedupPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_stats.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionStatsPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_live.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionLivePy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_concurrency.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionConcurrencyPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_recall.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionRecallPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_deletion.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionDeletionPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/session_tiers.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionTiersPy,
+			Permissions: 0644,
+		}).
+		WithNewFile("/app/memory_manager.py", dagger.ContainerWithNewFileOpts{
+			Contents:    memoryManagerPy,
+			Permissions: 0755,
+		}).
+		WithNewFile("/app/session_server.py", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionServerPy,
+			Permissions: 0755,
+		}).
+		WithEntrypoint([]string{"python3", "/app/memory_manager.py"})
+}
+
+// sessionMemoryService runs the session memory HTTP API on Redis.
+func sessionMemoryService(container *dagger.Container, redis *dagger.Service) *dagger.Service {
+	return withRedis(container, redis).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+}
+
+// withRedis binds the Redis service into a session memory container.
+func withRedis(container *dagger.Container, redis *dagger.Service) *dagger.Container {
+	return container.
+		WithServiceBinding("redis", redis).
+		WithEnvVariable("SESSION_STORE", "redis").
+		WithEnvVariable("REDIS_HOST", "redis").
+		WithEnvVariable("REDIS_PORT", "6379")
+}
+
+// withSQLite stores sessions in a file inside the container.
+func withSQLite(container *dagger.Container) *dagger.Container {
+	return container.
+		WithEnvVariable("SESSION_STORE", "sqlite").
+		WithEnvVariable("SESSION_SQLITE_PATH", "/data/session_memory.db")
+}
+
+// withPostgres binds the Postgres service into a session memory container.
+func withPostgres(container *dagger.Container, postgres *dagger.Service) *dagger.Container {
+	return container.
+		WithServiceBinding("postgres", postgres).
+		WithEnvVariable("SESSION_STORE", "postgres").
+		WithEnvVariable("DATABASE_URL",
+			fmt.Sprintf("postgresql://postgres:%s@postgres:5432/session_memory", sessionPostgresPassword))
+}
+
+func testSessionMemory(ctx context.Context, container *dagger.Container, redis, postgres *dagger.Service) error {
+	fmt.Println("🧪 Testing Session Memory...")
+
+	backends := []struct {
+		name      string
+		container *dagger.Container
+	}{
+		{"redis", withRedis(container, redis)},
+		{"sqlite", withSQLite(container)},
+		{"postgres", withPostgres(container, postgres)},
+	}
+	for _, backend := range backends {
+		if err := testSessionStore(ctx, backend.container); err != nil {
+			return fmt.Errorf("%s backend: %w", backend.name, err)
+		}
+	}
+	return nil
+}
+
+func testSessionMemoryAPI(ctx context.Context, client *dagger.Client, service *dagger.Service, mcpServer *dagger.Container) error {
+	fmt.Println("🧪 Testing Session Memory API...")
+
+	base := fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)
+	session := `{"tools_used": ["dagger"], "apis_accessed": ["github"]}`
+
+	curl := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("session-memory", service).
+		WithExec([]string{"curl", "-fsS", base + "/health"}).
+		WithExec([]string{"curl", "-fsS", "-X", "PUT", "-H", "Content-Type: application/json", "-d", session, base + "/sessions/api-session"})
+
+	output, err := curl.
+		WithExec([]string{"curl", "-fsS", "-X", "POST", base + "/sessions/api-session/summary"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var summary struct {
+		SessionID string   `json:"session_id"`
+		KeyPoints []string `json:"key_points"`
+	}
+	if err := json.Unmarshal([]byte(output), &summary); err != nil {
+		return fmt.Errorf("unexpected summary response %q: %w", output, err)
+	}
+	if summary.SessionID != "api-session" || len(summary.KeyPoints) != 2 {
+		return fmt.Errorf("unexpected session summary over the API: %s", output)
+	}
+
+	// Sessions can be found by what they touched, without knowing their IDs
+	output, err = curl.
+		WithExec([]string{"curl", "-fsS", "-G", "--data-urlencode", "q=sessions where we accessed the GitHub API",
+			"--data-urlencode", "attr=tools_used=dagger", base + "/sessions/search"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var search struct {
+		Results []struct {
+			SessionID string `json:"session_id"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(output), &search); err != nil {
+		return fmt.Errorf("unexpected search response %q: %w", output, err)
+	}
+	if len(search.Results) == 0 || search.Results[0].SessionID != "api-session" {
+		return fmt.Errorf("stored session was not found by search: %s", output)
+	}
+
+	// Dashboards read the breakdowns as JSON, Prometheus as text
+	output, err = curl.WithExec([]string{"curl", "-fsS", base + "/stats?top=5"}).Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var stats struct {
+		Tenants         map[string]struct{ Sessions int } `json:"tenants"`
+		LargestSessions []struct {
+			SessionID string `json:"session_id"`
+		} `json:"largest_sessions"`
+		TTLDistribution map[string]int `json:"ttl_distribution"`
+		Health          struct {
+			Status       string `json:"status"`
+			RedisVersion string `json:"redis_version"`
+		} `json:"health"`
+	}
+	if err := json.Unmarshal([]byte(output), &stats); err != nil {
+		return fmt.Errorf("unexpected stats response %q: %w", output, err)
+	}
+	if stats.Health.Status != "ok" || stats.Health.RedisVersion == "" || len(stats.LargestSessions) == 0 ||
+		len(stats.LargestSessions) > 5 || stats.Tenants["default"].Sessions == 0 || stats.TTLDistribution["1d"] == 0 {
+		return fmt.Errorf("unexpected memory stats: %s", output)
+	}
+
+	output, err = curl.WithExec([]string{"curl", "-fsS", base + "/metrics"}).Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	for _, metric := range []string{`session_memory_store_up{backend="redis"} 1`, "session_memory_tenant_sessions{", "session_memory_redis_keyspace_hits_total "} {
+		if !strings.Contains(output, metric) {
+			return fmt.Errorf("metrics are missing %s:\n%s", metric, output)
+		}
+	}
+
+	// Writes show up on a session's live stream while it is open
+	watch := fmt.Sprintf("curl -sN --max-time 5 %[1]s/sessions/live-session/stream > /tmp/stream & sleep 1; "+
+		"curl -fsS -X PUT -H 'Content-Type: application/json' -d '%[2]s' %[1]s/sessions/live-session; "+
+		"curl -fsS -X POST %[1]s/sessions/live-session/summary; wait; cat /tmp/stream", base, session)
+	output, err = curl.WithExec([]string{"sh", "-c", watch}).Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	for _, event := range []string{"event: context_stored", "event: summary_created"} {
+		if !strings.Contains(output, event) {
+			return fmt.Errorf("live stream is missing %q:\n%s", event, output)
+		}
+	}
+
+	// Unknown sessions are a 404, not an empty 200
+	status, err := curl.
+		WithExec([]string{"curl", "-sS", "-o", "/dev/null", "-w", "%{http_code}", base + "/sessions/missing-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if status != "404" {
+		return fmt.Errorf("expected 404 for an unknown session, got %s", status)
+	}
+
+	// The MCP server reaches session memory over the network too
+	mcp := mcpServer.
+		WithServiceBinding("session-memory", service).
+		WithEnvVariable("SESSION_MEMORY_URL", base).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+
+	output, err = client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("mcp-server", mcp).
+		WithExec([]string{"curl", "-fsS", "-X", "PUT", "-H", "Content-Type: application/json", "-d", session,
+			"http://mcp-server:3000/memory/sessions/mcp-session"}).
+		WithExec([]string{"curl", "-fsS", "http://mcp-server:3000/memory/sessions/mcp-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var stored map[string]any
+	if err := json.Unmarshal([]byte(output), &stored); err != nil {
+		return fmt.Errorf("unexpected response through the MCP server %q: %w", output, err)
+	}
+	if stored["stored_at"] == nil {
+		return fmt.Errorf("session stored through the MCP server was not returned: %s", output)
+	}
+
+	fmt.Printf("Session Memory API Summary:\n%s\n", summary.KeyPoints)
+	return nil
+}
+
+func testSessionEncryption(ctx context.Context, container *dagger.Container) error {
+	fmt.Println("🧪 Testing Session Memory Encryption...")
+
+	secret := "sk_live_session_memory_secret"
+	session := fmt.Sprintf(`{"tools_used": ["dagger"], "api_token": %q}`, secret)
+
+	encrypted := withSQLite(container).
+		WithNewFile("/run/secrets/session_memory_key", dagger.ContainerWithNewFileOpts{
+			Contents:    sessionTestKey,
+			Permissions: 0600,
+		}).
+		WithEnvVariable("SESSION_ENCRYPTION", "file").
+		WithExec([]string{"store", "encrypted-session", session})
+
+	output, err := encrypted.
+		WithExec([]string{"get", "encrypted-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var stored map[string]any
+	if err := json.Unmarshal([]byte(output), &stored); err != nil {
+		return fmt.Errorf("unexpected get output %q: %w", output, err)
+	}
+	if stored["api_token"] != secret {
+		return fmt.Errorf("encrypted session was not decrypted on read: %s", output)
+	}
+
+	// The secret must not appear anywhere in the database file
+	matches, err := encrypted.
+		WithExec([]string{"sh", "-c", "grep -c " + secret + " /data/session_memory.db || true"},
+			dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(matches) != "0" {
+		return fmt.Errorf("session secret was stored in plaintext")
+	}
+
+	fmt.Println("Session Memory Encryption: values encrypted at rest")
+	return nil
+}
+
+func testSessionSummarization(ctx context.Context, container *dagger.Container) error {
+	fmt.Println("🧪 Testing Session Memory Summarization...")
+
+	session := withSQLite(container).
+		WithNewFile("/app/summarization.json", dagger.ContainerWithNewFileOpts{
+			Contents: `{"keep_history": true, "summarization": {"size_threshold": 256, "keep_recent": 2}}`,
+		}).
+		WithEnvVariable("SESSION_MEMORY_CONFIG", "/app/summarization.json")
+	for i := 1; i <= 4; i++ {
+		updates := make([]string, i)
+		for j := range updates {
+			updates[j] = fmt.Sprintf("%q", fmt.Sprintf("update %d", j+1))
+		}
+		stored := fmt.Sprintf(`{"tools_used": ["dagger"], "context_updates": [%s]}`, strings.Join(updates, ", "))
+		session = session.WithExec([]string{"store", "growing-session", stored})
+	}
+
+	// One pass of the job the API server runs in the background
+	output, err := session.
+		WithExec([]string{"compact"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var compacted []struct {
+		SessionID      string         `json:"session_id"`
+		Replaced       map[string]int `json:"replaced"`
+		HistoryEntries int            `json:"history_entries"`
+	}
+	if err := json.Unmarshal([]byte(output), &compacted); err != nil {
+		return fmt.Errorf("unexpected summarization report %q: %w", output, err)
+	}
+	if len(compacted) != 1 || compacted[0].Replaced["context_updates"] != 2 || compacted[0].HistoryEntries != 2 {
+		return fmt.Errorf("large session was not compacted: %s", output)
+	}
+
+	output, err = session.
+		WithExec([]string{"compact"}).
+		WithExec([]string{"history", "growing-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var history []map[string]any
+	if err := json.Unmarshal([]byte(output), &history); err != nil {
+		return fmt.Errorf("unexpected history output %q: %w", output, err)
+	}
+	if len(history) != 3 || history[0]["summary_ref"] != "summary:growing-session" {
+		return fmt.Errorf("replaced history entries were not referenced by the summary: %s", output)
+	}
+
+	fmt.Println("Session Memory Summarization: large sessions summarized and compacted")
+	return nil
+}
+
+func testSessionEventLog(ctx context.Context, container *dagger.Container, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing Session Memory Event Log...")
+
+	logs := map[string]*dagger.Container{
+		"stream": withRedis(container, redis).WithEnvVariable("SESSION_EVENT_LOG", "stream"),
+		"file": withSQLite(container).
+			WithEnvVariable("SESSION_EVENT_LOG", "file").
+			WithEnvVariable("SESSION_EVENT_LOG_PATH", "/data/session_events.wal"),
+	}
+	for name, logged := range logs {
+		sessionID := "evented-session-" + name
+		logged = logged.
+			WithExec([]string{"store", sessionID, `{"tools_used": ["dagger"]}`}).
+			WithExec([]string{"store", sessionID, `{"tools_used": ["dagger", "git"]}`}).
+			WithExec([]string{"pin", sessionID})
+
+		output, err := logged.WithExec([]string{"events", sessionID}).Stdout(ctx)
+		if err != nil {
+			return err
+		}
+		var events []struct {
+			Type string `json:"type"`
+			At   string `json:"at"`
+		}
+		if err := json.Unmarshal([]byte(output), &events); err != nil {
+			return fmt.Errorf("unexpected events output %q: %w", output, err)
+		}
+		if len(events) != 3 || events[0].Type != "context_stored" || events[2].Type != "session_pinned" {
+			return fmt.Errorf("%s event log did not record every mutation: %s", name, output)
+		}
+
+		// Replaying up to the first event gives the context as first stored
+		output, err = logged.WithExec([]string{"rebuild", sessionID, events[0].At}).Stdout(ctx)
+		if err != nil {
+			return err
+		}
+		var state struct {
+			Context struct {
+				ToolsUsed []string `json:"tools_used"`
+			} `json:"context"`
+			Pinned bool `json:"pinned"`
+		}
+		if err := json.Unmarshal([]byte(output), &state); err != nil {
+			return fmt.Errorf("unexpected rebuild output %q: %w", output, err)
+		}
+		if len(state.Context.ToolsUsed) != 1 || state.Pinned {
+			return fmt.Errorf("%s event log did not rebuild the earlier state: %s", name, output)
+		}
+	}
+
+	fmt.Println("Session Memory Event Log: mutations recorded and replayed from streams and files")
+	return nil
+}
+
+func testSessionEviction(ctx context.Context, container *dagger.Container) error {
+	fmt.Println("🧪 Testing Session Memory Eviction...")
+
+	session := `{"topic": "payment webhook retries", "context_updates": ["Retried the failed payment webhooks", "Ordered lunch for the team", "Checked payment refunds", "Payment retries fixed"]}`
+	stored := withSQLite(container).
+		WithExec([]string{"store", "pressured-session", session})
+
+	// Measure the store, then set the watermarks so one entry has to go
+	output, err := stored.
+		WithNewFile("/app/eviction.json", dagger.ContainerWithNewFileOpts{
+			Contents: `{"eviction": {"high_watermark": 1, "low_watermark": 0, "keep_recent": 1}}`,
+		}).
+		WithEnvVariable("SESSION_MEMORY_CONFIG", "/app/eviction.json").
+		WithExec([]string{"evict", "--dry-run"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var report struct {
+		UsedBytes int `json:"used_bytes"`
+	}
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return fmt.Errorf("unexpected eviction report %q: %w", output, err)
+	}
+
+	config := fmt.Sprintf(`{"eviction": {"high_watermark": %d, "low_watermark": %d, "keep_recent": 1}}`,
+		report.UsedBytes-1, report.UsedBytes-2)
+	output, err = stored.
+		WithNewFile("/app/eviction.json", dagger.ContainerWithNewFileOpts{Contents: config}).
+		WithEnvVariable("SESSION_MEMORY_CONFIG", "/app/eviction.json").
+		WithExec([]string{"evict"}).
+		WithExec([]string{"get", "pressured-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var remaining struct {
+		Updates []string `json:"context_updates"`
+	}
+	if err := json.Unmarshal([]byte(output), &remaining); err != nil {
+		return fmt.Errorf("unexpected get output %q: %w", output, err)
+	}
+	if len(remaining.Updates) != 3 || strings.Contains(output, "lunch") {
+		return fmt.Errorf("the off-topic entry was not the one evicted: %s", output)
+	}
+
+	fmt.Println("Session Memory Eviction: least relevant entry evicted under memory pressure")
+	return nil
+}
+
+func testSessionPacking(ctx context.Context, container *dagger.Container) error {
+	fmt.Println("🧪 Testing Session Memory Packing...")
+
+	const budget = 48
+	notes := strings.Repeat("Investigated the payments webhook retries in detail. ", 40)
+	session := fmt.Sprintf(`{"user_id": "packing-user", "tools_used": ["dagger"], "apis_accessed": ["payments"], "notes": %q}`, notes)
+
+	output, err := withSQLite(container).
+		WithExec([]string{"store", "packed-session", session}).
+		WithExec([]string{"summarize", "packed-session"}).
+		WithExec([]string{"pack", "packed-session", strconv.Itoa(budget), "gpt-4o", "payments"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var packed struct {
+		Encoding string `json:"encoding"`
+		Tokens   int    `json:"tokens"`
+		Block    string `json:"block"`
+		Omitted  int    `json:"omitted"`
+	}
+	if err := json.Unmarshal([]byte(output), &packed); err != nil {
+		return fmt.Errorf("unexpected packing output %q: %w", output, err)
+	}
+	if packed.Tokens == 0 || packed.Tokens > budget {
+		return fmt.Errorf("packed block does not fit the %d token budget: %s", budget, output)
+	}
+	if !strings.Contains(packed.Block, "Accessed APIs: payments") {
+		return fmt.Errorf("packed block is missing the session summary: %s", packed.Block)
+	}
+
+	fmt.Printf("Session Memory Packing: %d of %d tokens (%s)\n", packed.Tokens, budget, packed.Encoding)
+	return nil
+}
+
+func testSessionRecall(ctx context.Context, container *dagger.Container) error {
+	fmt.Println("🧪 Testing Session Memory Semantic Recall...")
+
+	output, err := withSQLite(container).
+		WithEnvVariable("SESSION_RECALL", "1").
+		WithExec([]string{"store", "migration-session", `{"notes": ["Upgraded the PostgreSQL schema and backfilled the orders table"]}`}).
+		WithExec([]string{"store", "deploy-session", `{"notes": ["Rolled the web frontend out to the Kubernetes cluster"]}`}).
+		WithExec([]string{"summarize", "deploy-session"}).
+		WithExec([]string{"recall", "when did we change the database tables?", "3"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var recalled []struct {
+		SessionID string  `json:"session_id"`
+		Text      string  `json:"text"`
+		Score     float64 `json:"score"`
+	}
+	if err := json.Unmarshal([]byte(output), &recalled); err != nil {
+		return fmt.Errorf("unexpected recall output %q: %w", output, err)
+	}
+	if len(recalled) == 0 || recalled[0].SessionID != "migration-session" {
+		return fmt.Errorf("recall did not find the session by meaning: %s", output)
+	}
+
+	fmt.Printf("Session Memory Semantic Recall: %q (%.2f)\n", recalled[0].Text, recalled[0].Score)
+	return nil
+}
+
+func testSessionConcurrency(ctx context.Context, container *dagger.Container) error {
+	fmt.Println("🧪 Testing Session Memory Concurrent Writes...")
+
+	// Two agents both read version 1 and write back their own additions
+	versioned := withSQLite(container).
+		WithExec([]string{"store", "shared-session", `{"tools_used": ["dagger"], "notes": ["plan"]}`}).
+		WithExec([]string{"store", "shared-session", `{"tools_used": ["dagger", "git"], "notes": ["plan"], "version": 1}`})
+
+	output, err := versioned.
+		WithExec([]string{"store", "shared-session", `{"tools_used": ["dagger", "pytest"], "notes": ["plan", "tests"], "version": 1}`}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var stored struct {
+		Version int    `json:"version"`
+		Result  string `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(output), &stored); err != nil {
+		return fmt.Errorf("unexpected store output %q: %w", output, err)
+	}
+	if stored.Result != "merged" || stored.Version != 3 {
+		return fmt.Errorf("stale write was not merged as the next version: %s", output)
+	}
+
+	output, err = versioned.
+		WithExec([]string{"store", "shared-session", `{"tools_used": ["dagger", "pytest"], "notes": ["plan", "tests"], "version": 1}`}).
+		WithExec([]string{"get", "shared-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var merged struct {
+		ToolsUsed []string `json:"tools_used"`
+		Notes     []string `json:"notes"`
+	}
+	if err := json.Unmarshal([]byte(output), &merged); err != nil {
+		return fmt.Errorf("unexpected get output %q: %w", output, err)
+	}
+	if len(merged.ToolsUsed) != 3 || len(merged.Notes) != 2 {
+		return fmt.Errorf("concurrent writes lost data when merged: %s", output)
+	}
+
+	// Or the stale write is refused, and the agent re-reads
+	rejected := versioned.WithEnvVariable("SESSION_ON_CONFLICT", "reject").
+		WithExec([]string{"store", "shared-session", `{"tools_used": ["dagger"], "version": 1}`})
+	if _, err := rejected.Stdout(ctx); err == nil {
+		return fmt.Errorf("stale write was not rejected")
+	}
+
+	fmt.Println("Session Memory Concurrent Writes: versioned, merged or rejected")
+	return nil
+}
+
+func testSessionDedup(ctx context.Context, container *dagger.Container) error {
+	fmt.Println("🧪 Testing Session Memory Deduplication...")
+
+	session := `{"tools_used": ["dagger", "git"]}`
+	deduped := withSQLite(container).
+		WithEnvVariable("SESSION_DEDUP", "1").
+		WithNewFile("/app/history.json", dagger.ContainerWithNewFileOpts{
+			Contents: `{"keep_history": true}`,
+		}).
+		WithEnvVariable("SESSION_MEMORY_CONFIG", "/app/history.json").
+		WithExec([]string{"store", "deduped-session", session})
+
+	output, err := deduped.WithExec([]string{"store", "deduped-session", session}).Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var stored struct {
+		Result string `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(output), &stored); err != nil {
+		return fmt.Errorf("unexpected store output %q: %w", output, err)
+	}
+	if stored.Result != "duplicate" {
+		return fmt.Errorf("identical context was not detected as a duplicate: %s", output)
+	}
+
+	output, err = deduped.
+		WithExec([]string{"store", "deduped-session", session}).
+		WithExec([]string{"store", "deduped-session", session}).
+		WithExec([]string{"history", "deduped-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var history []map[string]any
+	if err := json.Unmarshal([]byte(output), &history); err != nil {
+		return fmt.Errorf("unexpected history output %q: %w", output, err)
+	}
+	if len(history) != 1 {
+		return fmt.Errorf("duplicate contexts were added to the history: %s", output)
+	}
+
+	output, err = deduped.
+		WithExec([]string{"store", "deduped-session", session}).
+		WithExec([]string{"dedup", "deduped-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var stats struct {
+		Refs       int `json:"refs"`
+		Duplicates int `json:"duplicates"`
+		BytesSaved int `json:"bytes_saved"`
+	}
+	if err := json.Unmarshal([]byte(output), &stats); err != nil {
+		return fmt.Errorf("unexpected dedup output %q: %w", output, err)
+	}
+	if stats.Refs != 2 || stats.Duplicates != 1 || stats.BytesSaved == 0 {
+		return fmt.Errorf("unexpected dedup stats: %s", output)
+	}
+
+	fmt.Println("Session Memory Deduplication: repeated contexts reference-counted, not stored")
+	return nil
+}
+
+func testSessionQuotas(ctx context.Context, container *dagger.Container) error {
+	fmt.Println("🧪 Testing Session Memory Quotas...")
+
+	limited := withSQLite(container).
+		WithNewFile("/app/quotas.json", dagger.ContainerWithNewFileOpts{
+			Contents: `{"quotas": {"tenants": {"small-team": {"max_sessions": 2}}}}`,
+		}).
+		WithEnvVariable("SESSION_MEMORY_CONFIG", "/app/quotas.json").
+		WithEnvVariable("SESSION_QUOTAS", "1").
+		WithExec([]string{"store", "small-team-1", `{"tenant": "small-team", "tools_used": ["dagger"]}`}).
+		WithExec([]string{"store", "small-team-2", `{"tenant": "small-team", "tools_used": ["git"]}`})
+
+	// Rejected by default once the tenant is full
+	if _, err := limited.WithExec([]string{"store", "small-team-3", `{"tenant": "small-team"}`}).Stdout(ctx); err == nil {
+		return fmt.Errorf("store over the tenant's session quota was not rejected")
+	}
+
+	// Evicting the oldest session instead makes room
+	output, err := limited.
+		WithEnvVariable("SESSION_QUOTA_MODE", "evict_oldest").
+		WithExec([]string{"store", "small-team-3", `{"tenant": "small-team"}`}).
+		WithExec([]string{"usage", "small-team"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var usage struct {
+		Sessions int `json:"sessions"`
+		Bytes    int `json:"bytes"`
+	}
+	if err := json.Unmarshal([]byte(output), &usage); err != nil {
+		return fmt.Errorf("unexpected usage report %q: %w", output, err)
+	}
+	if usage.Sessions != 2 || usage.Bytes == 0 {
+		return fmt.Errorf("tenant usage is not within its quota: %s", output)
+	}
+
+	fmt.Println("Session Memory Quotas: over-quota writes rejected or oldest sessions evicted")
+	return nil
+}
+
+func testSessionLongTermMemory(ctx context.Context, container *dagger.Container, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing Session Long-Term Memory...")
+
+	// Two sessions of one user; the second assembles its prompt with facts from both
+	output, err := withRedis(container, redis).
+		WithExec([]string{"store", "first-user-session", `{"user_id": "long-term-user", "tools_used": ["dagger"], "preferences": ["Prefers Go examples"]}`}).
+		WithExec([]string{"summarize", "first-user-session"}).
+		WithExec([]string{"store", "second-user-session", `{"user_id": "long-term-user", "tools_used": ["dagger"], "apis_accessed": ["github"]}`}).
+		WithExec([]string{"summarize", "second-user-session"}).
+		WithExec([]string{"prompt", "second-user-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var prompt struct {
+		LongTerm map[string]struct {
+			Facts []struct {
+				Fact string `json:"fact"`
+				Seen int    `json:"seen"`
+			} `json:"facts"`
+		} `json:"long_term"`
+		Prompt string `json:"prompt"`
+	}
+	if err := json.Unmarshal([]byte(output), &prompt); err != nil {
+		return fmt.Errorf("unexpected prompt context %q: %w", output, err)
+	}
+	facts := prompt.LongTerm["user"].Facts
+	if len(facts) != 3 || facts[0].Fact != "Uses dagger" || facts[0].Seen != 2 {
+		return fmt.Errorf("user facts were not distilled across sessions: %s", output)
+	}
+	if !strings.Contains(prompt.Prompt, "Prefers Go examples") {
+		return fmt.Errorf("prompt does not include facts from earlier sessions: %s", prompt.Prompt)
+	}
+
+	fmt.Println("Session Long-Term Memory: facts from earlier sessions included in prompts")
+	return nil
+}
+
+func testSessionDeletion(ctx context.Context, client *dagger.Client, container *dagger.Container, redis, knowledgeGraph *dagger.Service) error {
+	fmt.Println("🧪 Testing Session Memory Deletion...")
+
+	graphURL := fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)
+	node := `{"data": {"session_id": "erased-session", "user_id": "erased-user", "content": "Context derived from a session that will be erased"}}`
+
+	// A graph node derived from the session, for the deletion to purge
+	_, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithExec([]string{"curl", "-fsS", "-X", "POST", "-H", "Content-Type: application/json", "-d", node, graphURL + "/nodes"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	output, err := withRedis(container, redis).
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", graphURL).
+		WithExec([]string{"store", "erased-session", `{"user_id": "erased-user", "tools_used": ["dagger"]}`}).
+		WithExec([]string{"summarize", "erased-session"}).
+		WithExec([]string{"delete-user", "erased-user"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var report struct {
+		Sessions []struct {
+			SessionID string              `json:"session_id"`
+			Tiers     map[string][]string `json:"tiers"`
+			Graph     struct {
+				NodesDeleted []string `json:"nodes_deleted"`
+			} `json:"graph"`
+		} `json:"sessions"`
+	}
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return fmt.Errorf("unexpected deletion report %q: %w", output, err)
+	}
+	if len(report.Sessions) != 1 || report.Sessions[0].SessionID != "erased-session" {
+		return fmt.Errorf("user's session was not deleted: %s", output)
+	}
+	if len(report.Sessions[0].Tiers["hot"]) < 2 {
+		return fmt.Errorf("session context and summary were not both purged: %s", output)
+	}
+	if len(report.Sessions[0].Graph.NodesDeleted) != 1 {
+		return fmt.Errorf("graph node derived from the session was not purged: %s", output)
+	}
+
+	output, err = withRedis(container, redis).
+		WithExec([]string{"get", "erased-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(output) != "null" {
+		return fmt.Errorf("deleted session is still readable: %s", output)
+	}
+
+	fmt.Println("Session Memory Deletion: session, summary and graph nodes purged")
+	return nil
+}
+
+func testSessionBundles(ctx context.Context, client *dagger.Client, container *dagger.Container, redis, knowledgeGraph *dagger.Service) error {
+	fmt.Println("🧪 Testing Session Memory Bundles...")
+
+	graphURL := fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)
+	node := `{"data": {"session_id": "bundled-session", "content": "Context that travels with an exported session bundle"}}`
+
+	_, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithExec([]string{"curl", "-fsS", "-X", "POST", "-H", "Content-Type: application/json", "-d", node, graphURL + "/nodes"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Export from Redis and import into a separate SQLite deployment
+	output, err := withRedis(container, redis).
+		WithServiceBinding("knowledge-graph", knowledgeGraph).
+		WithEnvVariable("KNOWLEDGE_GRAPH_URL", graphURL).
+		WithExec([]string{"store", "bundled-session", `{"tools_used": ["dagger"], "apis_accessed": ["github"]}`}).
+		WithExec([]string{"summarize", "bundled-session"}).
+		WithExec([]string{"export", "bundled-session", "/tmp/bundled-session.zip"}).
+		WithEnvVariable("SESSION_STORE", "sqlite").
+		WithEnvVariable("SESSION_SQLITE_PATH", "/data/imported.db").
+		WithExec([]string{"import", "/tmp/bundled-session.zip", "imported-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var report struct {
+		SessionID  string   `json:"session_id"`
+		GraphNodes []string `json:"graph_nodes"`
+	}
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return fmt.Errorf("unexpected import report %q: %w", output, err)
+	}
+	if report.SessionID != "imported-session" || len(report.GraphNodes) != 1 {
+		return fmt.Errorf("session bundle was not imported with its graph node: %s", output)
+	}
+
+	fmt.Println("Session Memory Bundles: exported and imported with linked graph nodes")
+	return nil
+}
+
+// withTiers ages sessions from Redis to Postgres and then to MinIO.
+func withTiers(container *dagger.Container, redis, postgres, minio *dagger.Service) *dagger.Container {
+	return withRedis(container, redis).
+		WithServiceBinding("postgres", postgres).
+		WithServiceBinding("minio", minio).
+		WithEnvVariable("SESSION_TIERING", "1").
+		WithEnvVariable("SESSION_WARM_STORE", "postgres").
+		WithEnvVariable("DATABASE_URL",
+			fmt.Sprintf("postgresql://postgres:%s@postgres:5432/session_memory", sessionPostgresPassword)).
+		WithEnvVariable("SESSION_COLD_STORE", "s3").
+		WithEnvVariable("S3_ENDPOINT_URL", "http://minio:9000").
+		WithEnvVariable("AWS_ACCESS_KEY_ID", minioTestUser).
+		WithEnvVariable("AWS_SECRET_ACCESS_KEY", minioTestPassword).
+		WithNewFile("/app/warm.json", dagger.ContainerWithNewFileOpts{
+			Contents: `{"keep_history": true, "tiers": {"warm_after": 0}}`,
+		}).
+		WithNewFile("/app/cold.json", dagger.ContainerWithNewFileOpts{
+			Contents: `{"keep_history": true, "tiers": {"warm_after": 0, "cold_after": 0}}`,
+		})
+}
+
+func testSessionTiering(ctx context.Context, container *dagger.Container, redis, postgres, minio *dagger.Service) error {
+	fmt.Println("🧪 Testing Session Memory Tiering...")
+
+	tiered := withTiers(container, redis, postgres, minio)
+	warm := tiered.WithEnvVariable("SESSION_MEMORY_CONFIG", "/app/warm.json")
+	cold := tiered.WithEnvVariable("SESSION_MEMORY_CONFIG", "/app/cold.json")
+
+	var located struct {
+		Tier string `json:"tier"`
+	}
+	output, err := warm.
+		WithExec([]string{"store", "tiered-session", `{"tools_used": ["dagger"]}`}).
+		WithExec([]string{"age"}).
+		WithExec([]string{"locate", "tiered-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(output), &located); err != nil {
+		return fmt.Errorf("unexpected locate output %q: %w", output, err)
+	}
+	if located.Tier != "warm" {
+		return fmt.Errorf("idle session was not moved to the warm tier: %s", output)
+	}
+
+	output, err = cold.
+		WithExec([]string{"store", "tiered-session", `{"tools_used": ["dagger"]}`}).
+		WithExec([]string{"store", "tiered-session", `{"tools_used": ["dagger", "pytest"]}`}).
+		WithExec([]string{"age"}).
+		WithExec([]string{"locate", "tiered-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(output), &located); err != nil {
+		return fmt.Errorf("unexpected locate output %q: %w", output, err)
+	}
+	if located.Tier != "cold" {
+		return fmt.Errorf("idle session was not archived to the cold tier: %s", output)
+	}
+
+	// Archived sessions are still read through transparently
+	output, err = cold.
+		WithExec([]string{"store", "tiered-session", `{"tools_used": ["dagger", "pytest"]}`}).
+		WithExec([]string{"age"}).
+		WithExec([]string{"get", "tiered-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var archived struct {
+		ToolsUsed []string `json:"tools_used"`
+	}
+	if err := json.Unmarshal([]byte(output), &archived); err != nil {
+		return fmt.Errorf("unexpected get output %q: %w", output, err)
+	}
+	if len(archived.ToolsUsed) != 2 {
+		return fmt.Errorf("archived session was not read back from the cold tier: %s", output)
+	}
+
+	fmt.Println("Session Memory Tiering: hot → warm → cold with read-through")
+	return nil
+}
+
+// verifySessionBackup snapshots the Redis store to a volume and to MinIO,
+// restores each snapshot into a fresh SQLite store and checks every record
+// came back, then exports the volume snapshot to the host. It only runs when
+// SESSION_BACKUP_VERIFY is set on the host.
+func verifySessionBackup(ctx context.Context, container *dagger.Container, redis, minio *dagger.Service, dest string) error {
+	if os.Getenv("SESSION_BACKUP_VERIFY") == "" {
+		fmt.Println("⏭️ Skipping session backup verification: SESSION_BACKUP_VERIFY is not set")
+		return nil
+	}
+	fmt.Println("🧪 Verifying Session Memory Backups...")
+
+	withS3 := func(c *dagger.Container) *dagger.Container {
+		return c.
+			WithServiceBinding("minio", minio).
+			WithEnvVariable("S3_ENDPOINT_URL", "http://minio:9000").
+			WithEnvVariable("AWS_ACCESS_KEY_ID", minioTestUser).
+			WithEnvVariable("AWS_SECRET_ACCESS_KEY", minioTestPassword)
+	}
+	source := withS3(withRedis(container, redis)).
+		WithExec([]string{"store", "backed-up-session", `{"tools_used": ["dagger", "restic"]}`}).
+		WithExec([]string{"summarize", "backed-up-session"})
+
+	var snapshot struct {
+		Snapshot string `json:"snapshot"`
+		Records  int    `json:"records"`
+	}
+	snapshotted := source.WithExec([]string{"snapshot", "/backups"})
+	output, err := snapshotted.Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(output), &snapshot); err != nil {
+		return fmt.Errorf("unexpected snapshot output %q: %w", output, err)
+	}
+	if snapshot.Records == 0 {
+		return fmt.Errorf("snapshot holds no records: %s", output)
+	}
+	volumeSnapshot := snapshotted.File(snapshot.Snapshot)
+
+	if _, err := source.WithExec([]string{"snapshot", "s3://session-backups/pipeline"}).Stdout(ctx); err != nil {
+		return err
+	}
+
+	restores := map[string]*dagger.Container{
+		"/backups": withSQLite(container).WithFile(snapshot.Snapshot, volumeSnapshot),
+		// The latest snapshot under the prefix
+		"s3://session-backups/pipeline": withS3(withSQLite(container)),
+	}
+	for from, restored := range restores {
+		output, err := restored.
+			WithExec([]string{"restore", from}).
+			WithExec([]string{"verify", from}).
+			Stdout(ctx)
+		if err != nil {
+			return fmt.Errorf("snapshot from %s did not verify after restore: %w", from, err)
+		}
+		var report struct {
+			OK      bool `json:"ok"`
+			Checked int  `json:"checked"`
+		}
+		if err := json.Unmarshal([]byte(output), &report); err != nil {
+			return fmt.Errorf("unexpected verify output %q: %w", output, err)
+		}
+		if !report.OK || report.Checked == 0 {
+			return fmt.Errorf("snapshot from %s did not restore every record: %s", from, output)
+		}
+
+		output, err = restored.
+			WithExec([]string{"restore", from}).
+			WithExec([]string{"get", "backed-up-session"}).
+			Stdout(ctx)
+		if err != nil {
+			return err
+		}
+		var stored struct {
+			ToolsUsed []string `json:"tools_used"`
+		}
+		if err := json.Unmarshal([]byte(output), &stored); err != nil {
+			return fmt.Errorf("unexpected get output %q: %w", output, err)
+		}
+		if len(stored.ToolsUsed) != 2 {
+			return fmt.Errorf("session was not restored from %s: %s", from, output)
+		}
+	}
+
+	if _, err := volumeSnapshot.Export(ctx, dest); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Session memory snapshot verified and exported to %s\n", dest)
+	return nil
+}
+
+// testSessionStore runs the session memory checks against one backend.
+func testSessionStore(ctx context.Context, connected *dagger.Container) error {
+	session := `{"tools_used": ["dagger", "pytest"], "apis_accessed": ["github"], "context_updates": [1, 2, 3]}`
+
+	// Store and read back in separate processes, so the session can only be
+	// found if it went through the store. Each check stores the session first
+	// since services may be restarted between pipeline steps.
+	output, err := connected.
+		WithExec([]string{"ping"}).
+		WithExec([]string{"store", "pipeline-session", session}).
+		WithExec([]string{"get", "pipeline-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var stored map[string]any
+	if err := json.Unmarshal([]byte(output), &stored); err != nil {
+		return fmt.Errorf("unexpected get output %q: %w", output, err)
+	}
+	if stored == nil || stored["stored_at"] == nil {
+		return fmt.Errorf("stored session was not retrieved from the store: %s", output)
+	}
+
+	output, err = connected.
+		WithExec([]string{"store", "pipeline-session", session}).
+		WithExec([]string{"summarize", "pipeline-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var summary struct {
+		SessionID string   `json:"session_id"`
+		KeyPoints []string `json:"key_points"`
+	}
+	if err := json.Unmarshal([]byte(output), &summary); err != nil {
+		return fmt.Errorf("unexpected summarize output %q: %w", output, err)
+	}
+	if summary.SessionID != "pipeline-session" || len(summary.KeyPoints) != 3 {
+		return fmt.Errorf("unexpected session summary: %s", output)
+	}
+
+	output, err = connected.
+		WithExec([]string{"store", "pipeline-session", session}).
+		WithExec([]string{"pin", "pipeline-session"}).
+		WithExec([]string{"stats"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var stats struct {
+		ActiveSessions int `json:"active_sessions"`
+		PinnedSessions int `json:"pinned_sessions"`
+	}
+	if err := json.Unmarshal([]byte(output), &stats); err != nil {
+		return fmt.Errorf("unexpected stats output %q: %w", output, err)
+	}
+	if stats.ActiveSessions == 0 {
+		return fmt.Errorf("session index is empty after storing a session")
+	}
+	if stats.PinnedSessions == 0 {
+		return fmt.Errorf("pinned session was not recorded")
+	}
+
+	// With keep_history on, every stored context is kept, trimmed to the limit
+	output, err = connected.
+		WithNewFile("/app/session_config.json", dagger.ContainerWithNewFileOpts{
+			Contents: `{"keep_history": true, "history_limit": 2}`,
+		}).
+		WithEnvVariable("SESSION_MEMORY_CONFIG", "/app/session_config.json").
+		WithExec([]string{"store", "history-session", `{"step": 1}`}).
+		WithExec([]string{"store", "history-session", `{"step": 2}`}).
+		WithExec([]string{"store", "history-session", `{"step": 3}`}).
+		WithExec([]string{"history", "history-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var history []struct {
+		Step int `json:"step"`
+	}
+	if err := json.Unmarshal([]byte(output), &history); err != nil {
+		return fmt.Errorf("unexpected history output %q: %w", output, err)
+	}
+	if len(history) != 2 || history[0].Step != 2 || history[1].Step != 3 {
+		return fmt.Errorf("session history was not kept and trimmed: %s", output)
+	}
+
+	// Large documents are stored compressed and read back whole
+	document := strings.Repeat("Scraped documentation page. ", 2000)
+	large, err := json.Marshal(map[string]string{"document": document})
+	if err != nil {
+		return err
+	}
+	output, err = connected.
+		WithEnvVariable("SESSION_COMPRESSION", "zstd").
+		WithExec([]string{"store", "large-session", string(large)}).
+		WithExec([]string{"get", "large-session"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var restored struct {
+		Document string `json:"document"`
+	}
+	if err := json.Unmarshal([]byte(output), &restored); err != nil {
+		return fmt.Errorf("unexpected get output for a large session: %w", err)
+	}
+	if restored.Document != document {
+		return fmt.Errorf("large session did not survive compression (%d of %d bytes)",
+			len(restored.Document), len(document))
+	}
+
+	fmt.Printf("Session Memory Summary:\n%s\n", summary.KeyPoints)
+	return nil
+}
+
+const memoryManagerPy = `#!/usr/bin/env python3
+import json
+import sys
+from datetime import datetime
+
+from long_term_memory import LongTermMemory
+from session_concurrency import SessionVersions, VersionConflict
+from session_deletion import SESSION_MEMORY, SessionEraser
+from session_quotas import QuotaExceeded, SessionQuotas
+from session_search import SessionIndex
+from session_store import create_store, load_config, ttl_policy
+from session_tiers import SessionTiers
+
+class SessionMemoryManager:
+    def __init__(self, store=None, config=None, tiers=None):
+        self.config = config or load_config()
+        self.store = store or create_store(self.config)
+        self.tiers = tiers
+        if self.tiers is None and self.config['tiers']['enabled']:
+            self.tiers = SessionTiers(self.store, self.config)
+        hash_name = None
+        if self.config['encryption']['provider'] != 'off':
+            from session_crypto import index_hasher
+
+            hash_name = index_hasher(self.store)
+        self.index = SessionIndex(self.store, hash_name)
+        self.long_term = None
+        if self.config['long_term']['enabled']:
+            self.long_term = LongTermMemory(self.store, self.config, hash_name)
+        self.session_prefix = "session:"
+        self.memory_prefix = "memory:"
+        self.history_prefix = "history:"
+        self.summary_prefix = "summary:"
+        self.quotas = SessionQuotas(self) if self.config['quotas']['enabled'] else None
+        self.versions = SessionVersions(self)
+        self.recall = None
+        if self.config['recall']['enabled']:
+            from session_recall import SessionRecall
+
+            self.recall = SessionRecall(self)
+        self.dedup = None
+        if self.config['dedup']['enabled']:
+            from session_dedup import SessionDedup
+
+            self.dedup = SessionDedup(self)
+        self.events = None
+        if self.config['event_log']['backend'] != 'off':
+            from session_events import create_event_log
+
+            self.events = create_event_log(self.config, self.store)
+        self.live = None
+        if self.config['live_updates']['enabled']:
+            from session_live import create_publisher
+
+            self.live = create_publisher(self.config, self.store)
+
+    def record(self, event_type, session_id, data=None):
+        """Append a session mutation to the event log, if there is one, and publish it live"""
+        if self.events:
+            self.events.record(event_type, session_id, data)
+        if self.live:
+            self.live.publish(event_type, session_id, data)
+
+    def session_ttl(self, session_id, context, kind='session_ttl'):
+        """TTL for a session's context or summary; pinned sessions never expire"""
+        if session_id in self.store.members("pinned_sessions"):
+            return None
+        return ttl_policy(self.config, context)[kind]
+        
+    def store_session_context(self, session_id, context_data, expected_version=None):
+        """Store context for a session, written against expected_version (or the
+        context's own version) if given. Returns "stored", "merged" into a
+        concurrent write, or "duplicate" of the current context, which is not
+        stored again; context_data is updated to what was stored."""
+        key = f"{self.session_prefix}{session_id}"
+        version = context_data.pop('version', None)
+        if expected_version is None:
+            expected_version = version
+
+        if self.dedup:
+            current = self.dedup.check(session_id, context_data)
+            if current is not None:
+                context_data.update(stored_at=current.get('stored_at'), version=current.get('version'))
+                return "duplicate"
+
+        while True:
+            data = self.store.get(key)
+            current = json.loads(data) if data else None
+            # Raises VersionConflict if the session moved on and conflicts are rejected
+            context, merged = self.versions.resolve(session_id, context_data, current, expected_version)
+            context['version'] = (current or {}).get('version', 0) + 1
+            context['stored_at'] = datetime.now().isoformat()
+
+            # Raises QuotaExceeded if the tenant is full and nothing can be evicted
+            if self.quotas:
+                self.quotas.admit(session_id, context)
+
+            # Store with the expiration of the session's TTL policy (24 hours by default),
+            # unless another writer got in first; then resolve against what it stored
+            if self.store.compare_and_set(key, data, json.dumps(context), ttl=self.session_ttl(session_id, context)):
+                break
+        context_data.clear()
+        context_data.update(context)
+        self.versions.remember(session_id, context_data)
+        
+        # Add to session index
+        self.store.add_member("active_sessions", session_id)
+        self.index.index(session_id, context_data)
+        if self.recall:
+            self.recall.index(session_id, context_data)
+        if context_data.get('user_id') is not None:
+            self.store.add_member(SessionEraser(self).user_index(str(context_data['user_id'])), session_id)
+
+        if self.config['keep_history']:
+            self.store.append(f"{self.history_prefix}{session_id}", json.dumps(context_data),
+                              limit=self.config['history_limit'])
+        self.record("context_stored", session_id, context_data)
+        
+        return "merged" if merged else "stored"
+    
+    def get_session_context(self, session_id):
+        """Retrieve session context"""
+        key = f"{self.session_prefix}{session_id}"
+        data = self.store.get(key)
+        
+        if data:
+            context = json.loads(data)
+            if ttl_policy(self.config, context)['extend_on_access']:
+                ttl = self.session_ttl(session_id, context)
+                self.store.touch(key, ttl)
+                self.store.touch(f"{self.summary_prefix}{session_id}",
+                                 self.session_ttl(session_id, context, 'summary_ttl'))
+            return context
+        if self.tiers:
+            # Read through to the warm and cold tiers
+            _, record = self.tiers.load(session_id)
+            return record['context'] if record else None
+        return None
+
+    def get_session_history(self, session_id, limit=50):
+        """Every stored context of a session, oldest first (needs keep_history)"""
+        if self.tiers:
+            return self.tiers.history(session_id, limit)
+        return [json.loads(entry) for entry in
+                self.store.entries(f"{self.history_prefix}{session_id}", limit)]
+
+    def load_session(self, session_id):
+        """(context, summary) from whichever tier holds the session"""
+        data = self.store.get(f"{self.session_prefix}{session_id}")
+        if data is not None:
+            summary = self.store.get(f"{self.summary_prefix}{session_id}")
+            return json.loads(data), json.loads(summary) if summary else None
+        if self.tiers:
+            _, record = self.tiers.load(session_id)
+            if record:
+                return record['context'], record['summary']
+        return None, None
+
+    def search_sessions(self, query="", filters=(), limit=20, tenant=None):
+        """Find sessions by words in their context or summary and by key=value
+        attributes, only a tenant's when one is given"""
+        return self.index.search(self.load_session, query, filters, limit, tenant)
+
+    def pin_session(self, session_id):
+        """Keep a session and its summary until unpinned"""
+        context = self.store.get(f"{self.session_prefix}{session_id}")
+        if context is None:
+            return False
+        self.store.add_member("pinned_sessions", session_id)
+        self.store.touch(f"{self.session_prefix}{session_id}", None)
+        self.store.touch(f"{self.summary_prefix}{session_id}", None)
+        self.record("session_pinned", session_id)
+        return True
+
+    def unpin_session(self, session_id):
+        """Give a pinned session its policy's TTLs back, starting now"""
+        self.store.remove_member("pinned_sessions", session_id)
+        data = self.store.get(f"{self.session_prefix}{session_id}")
+        if data is None:
+            return False
+        context = json.loads(data)
+        self.store.touch(f"{self.session_prefix}{session_id}", self.session_ttl(session_id, context))
+        self.store.touch(f"{self.summary_prefix}{session_id}",
+                         self.session_ttl(session_id, context, 'summary_ttl'))
+        self.record("session_unpinned", session_id)
+        return True
+
+    def delete_session(self, session_id):
+        """Erase a session from every tier and index; returns a deletion report"""
+        return SessionEraser(self).delete_session(session_id)
+
+    def delete_user(self, user_id):
+        """Erase every session of a user; returns a deletion report"""
+        return SessionEraser(self).delete_user(user_id)
+
+    def export_session(self, session_id):
+        """(bundle, graph node documents) for a session, or (None, {})"""
+        from session_bundle import export_session
+
+        return export_session(self, session_id)
+
+    def import_session(self, data, session_id=None, overwrite=False, tenant=None):
+        """Import a bundle (JSON or zip bytes), for tenant if given; returns an import report"""
+        from session_bundle import import_session, read_bundle
+
+        bundle, nodes = read_bundle(data)
+        return import_session(self, bundle, nodes, session_id, overwrite, tenant=tenant)
+
+    def get_tenant_usage(self, tenant=None):
+        """Bytes and sessions stored per tenant, against their quotas"""
+        if not self.quotas:
+            raise RuntimeError("Quotas are disabled; set quotas.enabled or SESSION_QUOTAS=1")
+        return self.quotas.usage(tenant) if tenant is not None else self.quotas.report()
+
+    def get_dedup_stats(self, session_id=None):
+        """Duplicate submissions and bytes saved, for one session or overall"""
+        if not self.dedup:
+            raise RuntimeError("Deduplication is disabled; set dedup.enabled or SESSION_DEDUP=1")
+        return self.dedup.stats(session_id)
+
+    def compact_session(self, session_id, force=False):
+        """Summarize a large session and replace its raw entries; None if it is small"""
+        from session_summarizer import compact_session
+
+        return compact_session(self, session_id, force)
+
+    def summarize_active_sessions(self):
+        """Summarize and compact every active session over the size threshold"""
+        from session_summarizer import summarize_active
+
+        return summarize_active(self)
+
+    def evict_entries(self, force=False, dry_run=False):
+        """Evict the least recent and relevant context entries if the hot store is over its watermark"""
+        from session_eviction import SessionEvictor
+
+        if self.config['eviction']['high_watermark'] is None:
+            raise RuntimeError("Set eviction.high_watermark or SESSION_EVICTION_HIGH_WATERMARK to evict entries")
+        return SessionEvictor(self).run(force, dry_run)
+
+    def session_events(self, session_id, until=None):
+        """A session's mutations from the event log, oldest first"""
+        if not self.events:
+            raise RuntimeError("The event log is off; set event_log.backend or SESSION_EVENT_LOG")
+        return list(self.events.events(session_id, until))
+
+    def rebuild_session(self, session_id, until=None, apply=False):
+        """A session's state replayed from the event log, as of until; apply writes it back"""
+        if not self.events:
+            raise RuntimeError("The event log is off; set event_log.backend or SESSION_EVENT_LOG")
+        state = self.events.rebuild(session_id, until)
+        if apply and state['context'] is not None:
+            context = state['context']
+            if state['pinned']:
+                self.store.add_member("pinned_sessions", session_id)
+            # Written directly, so the session keeps the stored_at it had then
+            self.store.set(f"{self.session_prefix}{session_id}", json.dumps(context),
+                           ttl=self.session_ttl(session_id, context))
+            self.store.add_member("active_sessions", session_id)
+            if state['summary']:
+                self.store.set(f"{self.summary_prefix}{session_id}", json.dumps(state['summary']),
+                               ttl=self.session_ttl(session_id, context, 'summary_ttl'))
+            self.index.index(session_id, context, state['summary'])
+            self.record("context_stored", session_id, context)
+            state['applied'] = True
+        return state
+
+    def create_snapshot(self, location=None):
+        """Snapshot every record of the hot (and warm) store to a directory or S3"""
+        from session_snapshot import create_snapshot
+
+        return create_snapshot(self, location)
+
+    def restore_snapshot(self, source):
+        """Restore a snapshot, or the latest one at a location"""
+        from session_snapshot import restore_snapshot
+
+        return restore_snapshot(self, source)
+
+    def verify_snapshot(self, source):
+        """Check a snapshot is intact and matches what is stored"""
+        from session_snapshot import verify_snapshot
+
+        return verify_snapshot(self, source)
+
+    def backup(self):
+        """(gzipped snapshot, what it holds), handed over rather than stored"""
+        from session_snapshot import snapshot_data
+
+        return snapshot_data(self)
+
+    def restore_backup(self, data):
+        """Restore a snapshot taken by backup"""
+        from session_snapshot import restore_data
+
+        return restore_data(self, data)
+
+    def locate_session(self, session_id):
+        """Which tier holds a session: hot, warm, cold or None"""
+        if self.tiers:
+            return self.tiers.load(session_id)[0]
+        return "hot" if self.store.get(f"{self.session_prefix}{session_id}") else None
+
+    def age_sessions(self):
+        """Move idle sessions to the warm and cold tiers"""
+        if not self.tiers:
+            raise RuntimeError("Tiering is disabled; set tiers.enabled or SESSION_TIERING=1")
+        return self.tiers.age()
+    
+    def store_hot_memory(self, memory_key, data, ttl=None, session_id=None):
+        """Store frequently accessed data in hot memory, optionally owned by a session"""
+        key = f"{self.memory_prefix}{memory_key}"
+        self.store.set(key, json.dumps(data), ttl=ttl or self.config['hot_memory_ttl'])
+        if session_id is not None:
+            self.store.add_member(f"{SESSION_MEMORY}{session_id}", memory_key)
+        
+    def get_hot_memory(self, memory_key):
+        """Retrieve from hot memory"""
+        key = f"{self.memory_prefix}{memory_key}"
+        data = self.store.get(key)
+        
+        if data:
+            return json.loads(data)
+        return None
+
+    def delete_hot_memory(self, memory_key, session_id=None):
+        """Drop hot memory, and its place among its session's, before it expires"""
+        key = f"{self.memory_prefix}{memory_key}"
+        found = self.store.get(key) is not None
+        self.store.delete(key)
+        if session_id is not None:
+            self.store.remove_member(f"{SESSION_MEMORY}{session_id}", memory_key)
+        return found
+    
+    def summarize_session(self, session_id):
+        """Create LLM-ready summary of session"""
+        context = self.get_session_context(session_id)
+        if not context:
+            return None
+            
+        # Simplified summarization (would integrate with LLM API)
+        summary = {
+            'session_id': session_id,
+            'summary_created': datetime.now().isoformat(),
+            'key_points': self.extract_key_points(context),
+            'context_size': len(json.dumps(context)),
+            'last_activity': context.get('stored_at')
+        }
+        
+        # Store summary for future reference
+        summary_key = f"{self.summary_prefix}{session_id}"
+        self.store.set(summary_key, json.dumps(summary),
+                       ttl=self.session_ttl(session_id, context, 'summary_ttl'))  # 7 days by default
+        self.index.index(session_id, context, summary)
+        if self.recall:
+            self.recall.index(session_id, context, summary)
+        if self.long_term:
+            self.long_term.remember(session_id, context)
+        if self.quotas:
+            self.quotas.record_summary(session_id, context, summary)
+        self.record("summary_created", session_id, summary)
+        
+        return summary
+    
+    def recall_memories(self, query, limit=10, tenant=None, exclude_session=None):
+        """Entries of past sessions closest in meaning to query, best first"""
+        if not self.recall:
+            raise RuntimeError("Recall is disabled; set recall.enabled or SESSION_RECALL=1")
+        return self.recall.recall(query, limit, tenant, exclude_session)
+
+    def get_long_term_facts(self, scope, scope_id, limit=None):
+        """Durable facts about a user or project, most often seen first"""
+        if not self.long_term:
+            return []
+        return self.long_term.facts(scope, scope_id, limit)
+
+    def prompt_context(self, session_id):
+        """A session's context with what is known about its user and project, ready for a prompt"""
+        context, summary = self.load_session(session_id)
+        if context is None:
+            return None
+        long_term = {}
+        if self.long_term:
+            long_term = self.long_term.recall(context, self.config['long_term']['prompt_facts'])
+
+        key_points = summary['key_points'] if summary else self.extract_key_points(context)
+        lines = [f"Session {session_id}:"] + [f"- {point}" for point in key_points]
+        for scope, memory in long_term.items():
+            if memory['facts']:
+                lines.append(f"Known about {scope} {memory['id']}:")
+                lines.extend(f"- {fact['fact']}" for fact in memory['facts'])
+        return {
+            'session_id': session_id,
+            'context': context,
+            'summary': summary,
+            'long_term': long_term,
+            'prompt': "\n".join(lines),
+        }
+    
+    def pack_context(self, session_id, budget, model=None, query=""):
+        """The most relevant session memory that fits in a token budget, as a prompt-ready block"""
+        from session_packing import pack
+
+        return pack(self, session_id, budget, model, query)
+    
+    def extract_key_points(self, context):
+        """Extract key points from context (simplified)"""
+        # In production, this would use LLM for intelligent summarization
+        key_points = []
+        
+        if 'tools_used' in context:
+            key_points.append(f"Used tools: {', '.join(context['tools_used'])}")
+        
+        if 'apis_accessed' in context:
+            key_points.append(f"Accessed APIs: {', '.join(context['apis_accessed'])}")
+            
+        if 'context_updates' in context:
+            key_points.append(f"Context updates: {len(context['context_updates'])}")
+            
+        return key_points
+    
+    def get_memory_stats(self, top=10):
+        """Get memory system statistics, with per-tenant, size, TTL and health breakdowns"""
+        from session_stats import session_breakdown, store_health
+
+        health = store_health(self.store)
+        if health['status'] != 'ok':
+            return {'backend': self.store.name, 'health': health, 'timestamp': datetime.now().isoformat()}
+        active_sessions = self.store.count_members("active_sessions")
+        total_keys = self.store.count_keys()
+        
+        stats = {
+            'backend': self.store.name,
+            'active_sessions': active_sessions,
+            'pinned_sessions': self.store.count_members("pinned_sessions"),
+            'total_keys': total_keys,
+            'memory_usage': self.store.stats(),
+            'health': health,
+            **session_breakdown(self, top),
+            'timestamp': datetime.now().isoformat()
+        }
+        if self.tiers:
+            stats['tiers'] = self.tiers.stats()
+        if self.dedup:
+            stats['dedup'] = self.dedup.stats()
+        return stats
+
+if __name__ == "__main__":
+    manager = SessionMemoryManager()
+    command = sys.argv[1] if len(sys.argv) > 1 else "ping"
+
+    if command == "store":
+        context = json.loads(sys.argv[3])
+        try:
+            result = manager.store_session_context(sys.argv[2], context,
+                                                   int(sys.argv[4]) if len(sys.argv) > 4 else None)
+        except (QuotaExceeded, VersionConflict) as e:
+            sys.exit(str(e))
+        print(json.dumps({"stored": sys.argv[2], "version": context["version"], "result": result}))
+    elif command == "get":
+        print(json.dumps(manager.get_session_context(sys.argv[2])))
+    elif command == "history":
+        print(json.dumps(manager.get_session_history(sys.argv[2])))
+    elif command in ("pin", "unpin"):
+        pin = manager.pin_session if command == "pin" else manager.unpin_session
+        if not pin(sys.argv[2]):
+            sys.exit(f"Session not found: {sys.argv[2]}")
+        print(json.dumps({"session_id": sys.argv[2], "pinned": command == "pin"}))
+    elif command == "search":
+        query = sys.argv[2] if len(sys.argv) > 2 else ""
+        print(json.dumps(manager.search_sessions(query, sys.argv[3:])))
+    elif command == "delete":
+        print(json.dumps(manager.delete_session(sys.argv[2])))
+    elif command == "delete-user":
+        print(json.dumps(manager.delete_user(sys.argv[2])))
+    elif command == "export":
+        bundle, nodes = manager.export_session(sys.argv[2])
+        if bundle is None:
+            sys.exit(f"Session not found: {sys.argv[2]}")
+        if len(sys.argv) > 3 and sys.argv[3].endswith(".zip"):
+            from session_bundle import to_zip
+
+            with open(sys.argv[3], "wb") as f:
+                f.write(to_zip(bundle, nodes))
+            print(json.dumps({"exported": sys.argv[2], "path": sys.argv[3], "graph_nodes": len(nodes)}))
+        elif len(sys.argv) > 3:
+            with open(sys.argv[3], "w") as f:
+                json.dump(bundle, f, indent=2)
+            print(json.dumps({"exported": sys.argv[2], "path": sys.argv[3]}))
+        else:
+            print(json.dumps(bundle))
+    elif command == "import":
+        with open(sys.argv[2], "rb") as f:
+            data = f.read()
+        from session_bundle import BundleError, SessionExists
+
+        overwrite = "--overwrite" in sys.argv[3:]
+        target = next((arg for arg in sys.argv[3:] if arg != "--overwrite"), None)
+        try:
+            print(json.dumps(manager.import_session(data, target, overwrite)))
+        except BundleError as e:
+            sys.exit(str(e))
+        except SessionExists as e:
+            sys.exit(f"Session already exists: {e} (use --overwrite)")
+    elif command == "prompt":
+        prompt = manager.prompt_context(sys.argv[2])
+        if prompt is None:
+            sys.exit(f"Session not found: {sys.argv[2]}")
+        print(json.dumps(prompt))
+    elif command == "pack":
+        model = sys.argv[4] if len(sys.argv) > 4 else None
+        packed = manager.pack_context(sys.argv[2], int(sys.argv[3]), model, " ".join(sys.argv[5:]))
+        if packed is None:
+            sys.exit(f"Session not found: {sys.argv[2]}")
+        print(json.dumps(packed))
+    elif command == "recall":
+        limit = int(sys.argv[3]) if len(sys.argv) > 3 else 10
+        print(json.dumps(manager.recall_memories(sys.argv[2], limit)))
+    elif command == "facts":
+        print(json.dumps(manager.get_long_term_facts(sys.argv[2], sys.argv[3])))
+    elif command == "usage":
+        print(json.dumps(manager.get_tenant_usage(sys.argv[2] if len(sys.argv) > 2 else None)))
+    elif command == "dedup":
+        print(json.dumps(manager.get_dedup_stats(sys.argv[2] if len(sys.argv) > 2 else None)))
+    elif command == "compact":
+        if len(sys.argv) > 2:
+            print(json.dumps(manager.compact_session(sys.argv[2], force="--force" in sys.argv[3:])))
+        else:
+            print(json.dumps(manager.summarize_active_sessions()))
+    elif command == "evict":
+        print(json.dumps(manager.evict_entries(force="--force" in sys.argv[2:], dry_run="--dry-run" in sys.argv[2:])))
+    elif command == "events":
+        print(json.dumps(manager.session_events(sys.argv[2])))
+    elif command == "rebuild":
+        args = [arg for arg in sys.argv[3:] if arg != "--apply"]
+        print(json.dumps(manager.rebuild_session(sys.argv[2], args[0] if args else None, "--apply" in sys.argv[3:])))
+    elif command in ("snapshot", "restore", "verify"):
+        from session_snapshot import SnapshotError
+
+        try:
+            if command == "snapshot":
+                print(json.dumps(manager.create_snapshot(sys.argv[2] if len(sys.argv) > 2 else None)))
+            elif command == "restore":
+                print(json.dumps(manager.restore_snapshot(sys.argv[2])))
+            else:
+                report = manager.verify_snapshot(sys.argv[2])
+                print(json.dumps(report))
+                if not report["ok"]:
+                    sys.exit(1)
+        except SnapshotError as e:
+            sys.exit(str(e))
+    elif command == "locate":
+        print(json.dumps({"session_id": sys.argv[2], "tier": manager.locate_session(sys.argv[2])}))
+    elif command == "age":
+        print(json.dumps(manager.age_sessions()))
+    elif command == "summarize":
+        print(json.dumps(manager.summarize_session(sys.argv[2])))
+    elif command == "stats":
+        print(json.dumps(manager.get_memory_stats(int(sys.argv[2]) if len(sys.argv) > 2 else 10)))
+    elif command == "metrics":
+        from session_stats import prometheus
+
+        print(prometheus(manager.get_memory_stats()), end="")
+    elif command == "ping":
+        manager.store.count_keys()
+        print(f"✅ Session Memory Manager connected to its {manager.store.name} store")
+        print("🧠 Ready for context storage and retrieval")
+    else:
+        sys.exit(f"Unknown command: {command}")
+`
+
+const sessionStorePy = `#!/usr/bin/env python3
+"""Storage backends for session memory.
+
+Loaded from the JSON file named by SESSION_MEMORY_CONFIG and deep-merged
+over the defaults below; SESSION_STORE, REDIS_HOST, REDIS_PORT,
+SESSION_SQLITE_PATH and DATABASE_URL override single settings.
+
+  redis    - the hot, in-memory default; expired keys vanish on their own
+  sqlite   - a single file, for small deployments without Redis
+  postgres - durable shared storage for large deployments
+
+SESSION_TIERING=1 turns on tiering (see session_tiers.py); SESSION_WARM_STORE,
+SESSION_COLD_STORE, S3_ENDPOINT_URL, SESSION_ARCHIVE_BUCKET and
+SESSION_ARCHIVE_PATH pick where aged sessions go.
+
+Values of compression.threshold bytes or more are stored compressed
+(SESSION_COMPRESSION picks gzip, zstd or off) and decompressed on read.
+
+ttl_policies override session_ttl, summary_ttl and extend_on_access for
+sessions whose context matches on session_type and/or tenant; the first
+matching policy wins, for example
+
+  {"match": {"tenant": "acme"}, "session_ttl": 604800, "extend_on_access": true}
+
+encryption.provider "file" or "kms" (SESSION_ENCRYPTION, with SESSION_KEY_FILE
+or SESSION_KMS_KEY_ID) encrypts values at rest; see session_crypto.py.
+
+long_term keeps durable facts about users and projects across their
+sessions (see long_term_memory.py); SESSION_LONG_TERM=0 turns it off.
+
+quotas caps the bytes and sessions each tenant may keep in the hot store
+(SESSION_QUOTAS=1 turns them on, SESSION_QUOTA_MODE picks reject or
+evict_oldest); see session_quotas.py.
+
+summarization.enabled (SESSION_SUMMARIZATION=1) has the API server summarize
+and compact large sessions in the background; see session_summarizer.py.
+
+packing sets how session memory is packed into a token budget (see
+session_packing.py); SESSION_PACKING_MODEL overrides the default model.
+
+eviction.enabled (SESSION_EVICTION=1, with SESSION_EVICTION_HIGH_WATERMARK)
+has the API server evict the least recent and relevant context entries when
+the hot store grows past eviction.high_watermark bytes; see
+session_eviction.py.
+
+event_log records every session mutation to a Redis stream or a write-ahead
+log file (SESSION_EVENT_LOG=stream, file or off, SESSION_EVENT_LOG_PATH);
+see session_events.py.
+
+dedup.enabled (SESSION_DEDUP=1) skips storing a context identical to the
+session's current one, or reference-counts it (dedup.mode, SESSION_DEDUP_MODE
+skip or refcount); see session_dedup.py.
+
+recall.enabled (SESSION_RECALL=1) embeds session entries and summaries for
+recall by meaning across sessions; see session_recall.py.
+
+Every stored context carries a version; concurrency.on_conflict picks whether
+a write against an older version is merged into the current one or rejected
+(SESSION_ON_CONFLICT=merge or reject); see session_concurrency.py.
+
+live_updates streams session mutations to API clients as server-sent events
+(SESSION_LIVE_UPDATES=0 turns it off); see session_live.py.
+
+Snapshots of the whole store go to snapshots.location (SESSION_SNAPSHOT_LOCATION),
+a directory or s3://bucket/prefix; see session_snapshot.py.
+
+A null session_ttl keeps sessions until they are deleted, and keep_history
+appends every stored context to the session's history, so SQL backends can
+retain far more than the last 24 hours.
+"""
+import base64
+import copy
+import gzip
+import json
+import os
+import sqlite3
+import threading
+import time
+
+import config_client
+
+DEFAULT_CONFIG = {
+    "backend": "redis",
+    "redis": {"host": "localhost", "port": 6379, "db": 0},
+    "sqlite": {"path": "/data/session_memory.db"},
+    "postgres": {"dsn": "postgresql://postgres@localhost:5432/session_memory"},
+    "session_ttl": 86400,
+    "summary_ttl": 604800,
+    "hot_memory_ttl": 3600,
+    "extend_on_access": False,
+    "ttl_policies": [],
+    "keep_history": False,
+    "history_limit": 1000,
+    "long_term": {"enabled": True, "scopes": ["user", "project"], "max_facts": 200, "prompt_facts": 20},
+    "quotas": {
+        "enabled": False,
+        "on_exceed": "reject",
+        "default": {"max_bytes": None, "max_sessions": None},
+        "tenants": {},
+    },
+    "summarization": {"enabled": False, "interval": 300, "size_threshold": 65536, "keep_recent": 20},
+    "packing": {
+        "default_model": "gpt-4o",
+        "encodings": {"claude-*": "cl100k_base"},
+        "fallback_encoding": "cl100k_base",
+        "history_entries": 20,
+        "min_piece_tokens": 16,
+    },
+    "eviction": {
+        "enabled": False,
+        "interval": 60,
+        "high_watermark": None,
+        "low_watermark": None,
+        "recency_weight": 0.5,
+        "relevance_weight": 0.5,
+        "half_life": 86400,
+        "keep_recent": 5,
+        "embedding_model": "sentence-transformers/all-MiniLM-L6-v2",
+    },
+    "event_log": {"backend": "off", "stream": "session_events", "maxlen": None,
+                  "path": "/data/session_events.wal"},
+    "dedup": {"enabled": False, "mode": "refcount"},
+    "recall": {"enabled": False, "embedding_model": "sentence-transformers/all-MiniLM-L6-v2",
+               "max_entries": 200, "min_score": 0.2},
+    "concurrency": {"on_conflict": "merge", "keep_versions": 5, "set_fields": ["tools_used", "apis_accessed"]},
+    "live_updates": {"enabled": True, "channel": "session_updates", "heartbeat": 15, "queue_size": 100},
+    "snapshots": {"location": "/backups/session-memory", "endpoint_url": None, "region": "us-east-1"},
+    "compression": {"algorithm": "gzip", "threshold": 4096, "level": None},
+    "encryption": {
+        "provider": "off",
+        "key_file": "/run/secrets/session_memory_key",
+        "kms_key_id": None,
+        "kms_region": None,
+        "kms_endpoint_url": None,
+        "data_key_reuse": 1000,
+    },
+    "tiers": {
+        "enabled": False,
+        "warm_after": 3600,
+        "cold_after": 2592000,
+        "warm": {"backend": "postgres"},
+        "cold": {
+            "backend": "s3",
+            "bucket": "session-archive",
+            "prefix": "sessions/",
+            "endpoint_url": None,
+            "region": "us-east-1",
+            "path": "/data/session_archive",
+        },
+    },
+}
+
+BACKENDS = ("redis", "sqlite", "postgres")
+ARCHIVES = ("s3", "local")
+COMPRESSION = ("gzip", "zstd", "off")
+KEY_PROVIDERS = ("off", "file", "kms")
+QUOTA_MODES = ("reject", "evict_oldest")
+EVENT_LOGS = ("off", "stream", "file")
+DEDUP_MODES = ("skip", "refcount")
+CONFLICT_MODES = ("merge", "reject")
+QUOTA_LIMITS = ("max_bytes", "max_sessions")
+POLICY_MATCH = ("session_type", "tenant")
+
+
+class ConfigError(ValueError):
+    pass
+
+
+def deep_merge(base, override):
+    merged = copy.deepcopy(base)
+    for key, value in override.items():
+        if isinstance(value, dict) and isinstance(merged.get(key), dict):
+            merged[key] = deep_merge(merged[key], value)
+        else:
+            merged[key] = value
+    return merged
+
+
+def load_config(path=None):
+    """Load the config file (if any), the config service's config and the
+    environment over the defaults"""
+    path = path or os.environ.get("SESSION_MEMORY_CONFIG")
+    config = copy.deepcopy(DEFAULT_CONFIG)
+    if path and os.path.exists(path):
+        with open(path) as f:
+            config = deep_merge(config, json.load(f))
+    config = deep_merge(config, config_client.overrides())
+    env = os.environ
+    if env.get("SESSION_STORE"):
+        config["backend"] = env["SESSION_STORE"]
+    if env.get("REDIS_HOST"):
+        config["redis"]["host"] = env["REDIS_HOST"]
+    if env.get("REDIS_PORT"):
+        config["redis"]["port"] = int(env["REDIS_PORT"])
+    if env.get("SESSION_SQLITE_PATH"):
+        config["sqlite"]["path"] = env["SESSION_SQLITE_PATH"]
+    if env.get("DATABASE_URL"):
+        config["postgres"]["dsn"] = env["DATABASE_URL"]
+    if env.get("SESSION_LONG_TERM"):
+        config["long_term"]["enabled"] = env["SESSION_LONG_TERM"].lower() in ("1", "true", "yes")
+    if env.get("SESSION_QUOTAS"):
+        config["quotas"]["enabled"] = env["SESSION_QUOTAS"].lower() in ("1", "true", "yes")
+    if env.get("SESSION_QUOTA_MODE"):
+        config["quotas"]["on_exceed"] = env["SESSION_QUOTA_MODE"]
+    if env.get("SESSION_SUMMARIZATION"):
+        config["summarization"]["enabled"] = env["SESSION_SUMMARIZATION"].lower() in ("1", "true", "yes")
+    if env.get("SESSION_SUMMARIZE_INTERVAL"):
+        config["summarization"]["interval"] = int(env["SESSION_SUMMARIZE_INTERVAL"])
+    if env.get("SESSION_SUMMARIZE_THRESHOLD"):
+        config["summarization"]["size_threshold"] = int(env["SESSION_SUMMARIZE_THRESHOLD"])
+    if env.get("SESSION_PACKING_MODEL"):
+        config["packing"]["default_model"] = env["SESSION_PACKING_MODEL"]
+    if env.get("SESSION_EVICTION"):
+        config["eviction"]["enabled"] = env["SESSION_EVICTION"].lower() in ("1", "true", "yes")
+    if env.get("SESSION_EVICTION_HIGH_WATERMARK"):
+        config["eviction"]["high_watermark"] = int(env["SESSION_EVICTION_HIGH_WATERMARK"])
+    if env.get("SESSION_EVENT_LOG"):
+        config["event_log"]["backend"] = env["SESSION_EVENT_LOG"]
+    if env.get("SESSION_EVENT_LOG_PATH"):
+        config["event_log"]["path"] = env["SESSION_EVENT_LOG_PATH"]
+    if env.get("SESSION_RECALL"):
+        config["recall"]["enabled"] = env["SESSION_RECALL"].lower() in ("1", "true", "yes")
+    if env.get("SESSION_ON_CONFLICT"):
+        config["concurrency"]["on_conflict"] = env["SESSION_ON_CONFLICT"]
+    if env.get("SESSION_LIVE_UPDATES"):
+        config["live_updates"]["enabled"] = env["SESSION_LIVE_UPDATES"].lower() in ("1", "true", "yes")
+    if env.get("SESSION_DEDUP"):
+        config["dedup"]["enabled"] = env["SESSION_DEDUP"].lower() in ("1", "true", "yes")
+    if env.get("SESSION_DEDUP_MODE"):
+        config["dedup"]["mode"] = env["SESSION_DEDUP_MODE"]
+    if env.get("SESSION_COMPRESSION"):
+        config["compression"]["algorithm"] = env["SESSION_COMPRESSION"]
+    if env.get("SESSION_COMPRESSION_THRESHOLD"):
+        config["compression"]["threshold"] = int(env["SESSION_COMPRESSION_THRESHOLD"])
+    if env.get("SESSION_ENCRYPTION"):
+        config["encryption"]["provider"] = env["SESSION_ENCRYPTION"]
+    if env.get("SESSION_KEY_FILE"):
+        config["encryption"]["key_file"] = env["SESSION_KEY_FILE"]
+    if env.get("SESSION_KMS_KEY_ID"):
+        config["encryption"]["kms_key_id"] = env["SESSION_KMS_KEY_ID"]
+    tiers = config["tiers"]
+    if env.get("SESSION_TIERING"):
+        tiers["enabled"] = env["SESSION_TIERING"].lower() in ("1", "true", "yes")
+    if env.get("SESSION_WARM_STORE"):
+        tiers["warm"]["backend"] = env["SESSION_WARM_STORE"]
+    if env.get("SESSION_COLD_STORE"):
+        tiers["cold"]["backend"] = env["SESSION_COLD_STORE"]
+    if env.get("S3_ENDPOINT_URL"):
+        tiers["cold"]["endpoint_url"] = env["S3_ENDPOINT_URL"]
+        config["snapshots"]["endpoint_url"] = env["S3_ENDPOINT_URL"]
+    if env.get("SESSION_SNAPSHOT_LOCATION"):
+        config["snapshots"]["location"] = env["SESSION_SNAPSHOT_LOCATION"]
+    if env.get("SESSION_ARCHIVE_BUCKET"):
+        tiers["cold"]["bucket"] = env["SESSION_ARCHIVE_BUCKET"]
+    if env.get("SESSION_ARCHIVE_PATH"):
+        tiers["cold"]["path"] = env["SESSION_ARCHIVE_PATH"]
+    validate_config(config)
+    return config
+
+
+def validate_config(config):
+    if config["backend"] not in BACKENDS:
+        raise ConfigError(f"Unknown session store {config['backend']!r}, expected one of {', '.join(BACKENDS)}")
+    for policy in config["ttl_policies"]:
+        unknown = set(policy.get("match", {})) - set(POLICY_MATCH)
+        if not policy.get("match") or unknown:
+            raise ConfigError(f"ttl_policies match on {', '.join(POLICY_MATCH)}, got {policy.get('match')!r}")
+        for key in ("session_ttl", "summary_ttl"):
+            ttl = policy.get(key)
+            if ttl is not None and (not isinstance(ttl, int) or ttl <= 0):
+                raise ConfigError(f"{key} in ttl_policies must be a positive number of seconds or null")
+    long_term = config["long_term"]
+    for key in ("max_facts", "prompt_facts"):
+        if not isinstance(long_term[key], int) or long_term[key] <= 0:
+            raise ConfigError(f"long_term.{key} must be a positive number of facts")
+    quotas = config["quotas"]
+    if quotas["on_exceed"] not in QUOTA_MODES:
+        raise ConfigError(f"Unknown quotas.on_exceed {quotas['on_exceed']!r}, expected one of {', '.join(QUOTA_MODES)}")
+    for tenant, limits in [("default", quotas["default"])] + list(quotas["tenants"].items()):
+        unknown = set(limits) - set(QUOTA_LIMITS)
+        if unknown:
+            raise ConfigError(f"Unknown quota limits for tenant {tenant!r}: {', '.join(sorted(unknown))}")
+        for key, limit in limits.items():
+            if limit is not None and (not isinstance(limit, int) or limit <= 0):
+                raise ConfigError(f"{key} for tenant {tenant!r} must be a positive number or null")
+    for key in ("interval", "size_threshold", "keep_recent"):
+        if not isinstance(config["summarization"][key], int) or config["summarization"][key] <= 0:
+            raise ConfigError(f"summarization.{key} must be a positive number")
+    if config["event_log"]["backend"] not in EVENT_LOGS:
+        raise ConfigError(f"Unknown event log {config['event_log']['backend']!r}, expected one of {', '.join(EVENT_LOGS)}")
+    concurrency = config["concurrency"]
+    if concurrency["on_conflict"] not in CONFLICT_MODES:
+        raise ConfigError(f"Unknown concurrency.on_conflict {concurrency['on_conflict']!r}, "
+                          f"expected one of {', '.join(CONFLICT_MODES)}")
+    if not isinstance(concurrency["keep_versions"], int) or concurrency["keep_versions"] <= 0:
+        raise ConfigError("concurrency.keep_versions must be a positive number")
+    if config["dedup"]["mode"] not in DEDUP_MODES:
+        raise ConfigError(f"Unknown dedup.mode {config['dedup']['mode']!r}, expected one of {', '.join(DEDUP_MODES)}")
+    eviction = config["eviction"]
+    if eviction["enabled"] and not (isinstance(eviction["high_watermark"], int) and eviction["high_watermark"] > 0):
+        raise ConfigError("eviction.high_watermark must be a positive number of bytes when eviction is enabled")
+    if eviction["low_watermark"] is not None and eviction["high_watermark"] is not None \
+            and not 0 <= eviction["low_watermark"] < eviction["high_watermark"]:
+        raise ConfigError("eviction.low_watermark must be below eviction.high_watermark")
+    if min(eviction["recency_weight"], eviction["relevance_weight"]) < 0 or eviction["half_life"] <= 0:
+        raise ConfigError("eviction weights must be non-negative and half_life positive")
+    compression = config["compression"]
+    if compression["algorithm"] not in COMPRESSION:
+        raise ConfigError(f"Unknown compression {compression['algorithm']!r}, expected one of {', '.join(COMPRESSION)}")
+    if not isinstance(compression["threshold"], int) or compression["threshold"] < 0:
+        raise ConfigError("compression.threshold must be a non-negative number of bytes")
+    encryption = config["encryption"]
+    if encryption["provider"] not in KEY_PROVIDERS:
+        raise ConfigError(f"Unknown key provider {encryption['provider']!r}, expected one of {', '.join(KEY_PROVIDERS)}")
+    if encryption["provider"] == "kms" and not encryption["kms_key_id"]:
+        raise ConfigError("encryption.kms_key_id is required for the kms provider")
+    tiers = config["tiers"]
+    if not tiers["enabled"]:
+        return
+    warm = tiers["warm"]["backend"]
+    if warm not in BACKENDS:
+        raise ConfigError(f"Unknown warm store {warm!r}, expected one of {', '.join(BACKENDS)}")
+    if warm == config["backend"]:
+        raise ConfigError("The warm store must be a different backend from the hot one")
+    if tiers["cold"]["backend"] not in ARCHIVES:
+        raise ConfigError(f"Unknown cold store {tiers['cold']['backend']!r}, expected one of {', '.join(ARCHIVES)}")
+    for key in ("warm_after", "cold_after"):
+        if not isinstance(tiers[key], (int, float)) or tiers[key] < 0:
+            raise ConfigError(f"tiers.{key} must be a non-negative number of seconds")
+    # Sessions must be moved out of the hot store before they expire there
+    for ttl in [config["session_ttl"]] + [policy.get("session_ttl", config["session_ttl"])
+                                          for policy in config["ttl_policies"]]:
+        if ttl is not None and tiers["warm_after"] >= ttl:
+            raise ConfigError("tiers.warm_after must be shorter than every session_ttl")
+
+
+def ttl_policy(config, context):
+    """The TTLs that apply to a session, from the first policy its context matches"""
+    policy = {key: config[key] for key in ("session_ttl", "summary_ttl", "extend_on_access")}
+    for rule in config["ttl_policies"]:
+        if all((context or {}).get(key) == value for key, value in rule["match"].items()):
+            policy.update({key: rule[key] for key in policy if key in rule})
+            break
+    return policy
+
+
+class SessionStore:
+    """String keys with optional expiry, string sets and append-only logs"""
+
+    name = "base"
+
+    def set(self, key, value, ttl=None):
+        raise NotImplementedError
+
+    def get(self, key):
+        raise NotImplementedError
+
+    def set_default(self, key, value):
+        """Set a key that does not expire unless it exists; returns the stored value"""
+        raise NotImplementedError
+
+    def compare_and_set(self, key, expected, value, ttl=None):
+        """Set key only if it still holds expected (None: only if it is absent); False if not"""
+        raise NotImplementedError
+
+    def delete(self, key):
+        raise NotImplementedError
+
+    def touch(self, key, ttl=None):
+        """Reset a key's expiry; a None ttl makes it permanent"""
+        raise NotImplementedError
+
+    def ttl(self, key):
+        """Seconds until a key expires; None if it never does or does not exist"""
+        raise NotImplementedError
+
+    def add_member(self, name, member):
+        raise NotImplementedError
+
+    def count_members(self, name):
+        raise NotImplementedError
+
+    def members(self, name):
+        raise NotImplementedError
+
+    def remove_member(self, name, member):
+        raise NotImplementedError
+
+    def append(self, name, value, limit=None):
+        """Append to a log, keeping at most limit entries"""
+        raise NotImplementedError
+
+    def entries(self, name, limit=50):
+        """The most recent log entries, oldest first"""
+        raise NotImplementedError
+
+    def count_keys(self):
+        raise NotImplementedError
+
+    def stats(self):
+        return {}
+
+    def used_bytes(self):
+        """Bytes the store takes up, where the backend can tell"""
+        return None
+
+    def health(self):
+        """Backend server figures for health checks, where there is a server to ask"""
+        return {}
+
+    def dump(self):
+        """Every stored record, with values as stored:
+        {"kind": "kv", "key", "value", "ttl"}, {"kind": "set", "name", "members"}
+        or {"kind": "log", "name", "entries"}"""
+        raise NotImplementedError
+
+    def load(self, record):
+        """Write back a record from dump(), replacing what is stored under its name"""
+        if record["kind"] == "kv":
+            self.set(record["key"], record["value"], record["ttl"])
+        elif record["kind"] == "set":
+            for member in record["members"]:
+                self.add_member(record["name"], member)
+        elif record["kind"] == "log":
+            self.delete(record["name"])
+            for entry in record["entries"]:
+                self.append(record["name"], entry)
+
+    def close(self):
+        pass
+
+
+class RedisStore(SessionStore):
+    name = "redis"
+
+    def __init__(self, host="localhost", port=6379, db=0):
+        import redis
+
+        self.client = redis.Redis(host=host, port=port, db=db, decode_responses=True)
+
+    def set(self, key, value, ttl=None):
+        self.client.set(key, value, ex=ttl)
+
+    def get(self, key):
+        return self.client.get(key)
+
+    def set_default(self, key, value):
+        self.client.set(key, value, nx=True)
+        return self.client.get(key)
+
+    def compare_and_set(self, key, expected, value, ttl=None):
+        import redis
+
+        with self.client.pipeline() as pipe:
+            try:
+                pipe.watch(key)
+                if pipe.get(key) != expected:
+                    pipe.unwatch()
+                    return False
+                pipe.multi()
+                pipe.set(key, value, ex=ttl)
+                pipe.execute()
+                return True
+            except redis.WatchError:
+                return False
+
+    def delete(self, key):
+        self.client.delete(key)
+
+    def touch(self, key, ttl=None):
+        if ttl:
+            self.client.expire(key, ttl)
+        else:
+            self.client.persist(key)
+
+    def ttl(self, key):
+        ttl = self.client.ttl(key)
+        return ttl if ttl > 0 else None
+
+    def add_member(self, name, member):
+        self.client.sadd(name, member)
+
+    def count_members(self, name):
+        return self.client.scard(name)
+
+    def members(self, name):
+        return self.client.smembers(name)
+
+    def remove_member(self, name, member):
+        self.client.srem(name, member)
+
+    def append(self, name, value, limit=None):
+        self.client.rpush(name, value)
+        if limit:
+            self.client.ltrim(name, -limit, -1)
+
+    def entries(self, name, limit=50):
+        return self.client.lrange(name, -limit, -1)
+
+    def count_keys(self):
+        return self.client.dbsize()
+
+    def stats(self):
+        return self.client.info("memory")
+
+    def used_bytes(self):
+        return int(self.client.info("memory")["used_memory"])
+
+    def health(self):
+        info = self.client.info()
+        hits, misses = info.get("keyspace_hits", 0), info.get("keyspace_misses", 0)
+        return {
+            "redis_version": info.get("redis_version"),
+            "uptime_seconds": info.get("uptime_in_seconds"),
+            "connected_clients": info.get("connected_clients"),
+            "used_memory": info.get("used_memory"),
+            "maxmemory": info.get("maxmemory"),
+            "evicted_keys": info.get("evicted_keys", 0),
+            "expired_keys": info.get("expired_keys", 0),
+            "keyspace_hits": hits,
+            "keyspace_misses": misses,
+            "hit_ratio": round(hits / (hits + misses), 4) if hits + misses else None,
+        }
+
+    def dump(self):
+        # Streams (the event log) are not session memory and are left out
+        for key in self.client.scan_iter(count=1000):
+            kind = self.client.type(key)
+            if kind == "string":
+                ttl = self.client.ttl(key)
+                yield {"kind": "kv", "key": key, "value": self.client.get(key), "ttl": ttl if ttl > 0 else None}
+            elif kind == "set":
+                yield {"kind": "set", "name": key, "members": sorted(self.client.smembers(key))}
+            elif kind == "list":
+                yield {"kind": "log", "name": key, "entries": self.client.lrange(key, 0, -1)}
+
+    def close(self):
+        self.client.close()
+
+
+class SQLStore(SessionStore):
+    """Shared SQL for SQLite and Postgres; subclasses set the placeholder"""
+
+    placeholder = "?"
+    schema = (
+        "CREATE TABLE IF NOT EXISTS session_kv (key TEXT PRIMARY KEY, value TEXT NOT NULL, "
+        "expires_at DOUBLE PRECISION)",
+        "CREATE TABLE IF NOT EXISTS session_sets (name TEXT NOT NULL, member TEXT NOT NULL, "
+        "PRIMARY KEY (name, member))",
+        "CREATE TABLE IF NOT EXISTS session_log (name TEXT NOT NULL, seq BIGINT NOT NULL, "
+        "value TEXT NOT NULL, PRIMARY KEY (name, seq))",
+    )
+
+    def __init__(self):
+        self.lock = threading.Lock()
+        for statement in self.schema:
+            self.execute(statement)
+
+    def connection(self):
+        raise NotImplementedError
+
+    def execute(self, sql, params=(), fetch=False):
+        """Rows if fetch, else the number of rows changed"""
+        sql = sql.replace("?", self.placeholder)
+        with self.lock:
+            conn = self.connection()
+            cursor = conn.cursor()
+            try:
+                cursor.execute(sql, params)
+                rows = cursor.fetchall() if fetch else cursor.rowcount
+                conn.commit()
+                return rows
+            except Exception:
+                conn.rollback()
+                raise
+            finally:
+                cursor.close()
+
+    def set(self, key, value, ttl=None):
+        expires_at = time.time() + ttl if ttl else None
+        self.execute("INSERT INTO session_kv (key, value, expires_at) VALUES (?, ?, ?) "
+                     "ON CONFLICT (key) DO UPDATE SET value = excluded.value, "
+                     "expires_at = excluded.expires_at", (key, value, expires_at))
+
+    def get(self, key):
+        rows = self.execute("SELECT value, expires_at FROM session_kv WHERE key = ?", (key,), fetch=True)
+        if not rows:
+            return None
+        value, expires_at = rows[0]
+        if expires_at is not None and expires_at <= time.time():
+            self.delete(key)  # expired rows are purged lazily, on read
+            return None
+        return value
+
+    def set_default(self, key, value):
+        self.execute("INSERT INTO session_kv (key, value, expires_at) VALUES (?, ?, NULL) "
+                     "ON CONFLICT (key) DO NOTHING", (key, value))
+        return self.get(key)
+
+    def compare_and_set(self, key, expected, value, ttl=None):
+        expires_at = time.time() + ttl if ttl else None
+        if expected is None:
+            self.get(key)  # purges the row if it has expired
+            changed = self.execute("INSERT INTO session_kv (key, value, expires_at) VALUES (?, ?, ?) "
+                                   "ON CONFLICT (key) DO NOTHING", (key, value, expires_at))
+        else:
+            changed = self.execute("UPDATE session_kv SET value = ?, expires_at = ? WHERE key = ? AND value = ? "
+                                   "AND (expires_at IS NULL OR expires_at > ?)",
+                                   (value, expires_at, key, expected, time.time()))
+        return changed == 1
+
+    def delete(self, key):
+        self.execute("DELETE FROM session_kv WHERE key = ?", (key,))
+        self.execute("DELETE FROM session_log WHERE name = ?", (key,))
+
+    def touch(self, key, ttl=None):
+        self.execute("UPDATE session_kv SET expires_at = ? WHERE key = ?",
+                     (time.time() + ttl if ttl else None, key))
+
+    def ttl(self, key):
+        rows = self.execute("SELECT expires_at FROM session_kv WHERE key = ?", (key,), fetch=True)
+        if not rows or rows[0][0] is None:
+            return None
+        return max(int(rows[0][0] - time.time()), 0)
+
+    def add_member(self, name, member):
+        self.execute("INSERT INTO session_sets (name, member) VALUES (?, ?) "
+                     "ON CONFLICT (name, member) DO NOTHING", (name, member))
+
+    def count_members(self, name):
+        return self.execute("SELECT COUNT(*) FROM session_sets WHERE name = ?", (name,), fetch=True)[0][0]
+
+    def members(self, name):
+        return {member for (member,) in
+                self.execute("SELECT member FROM session_sets WHERE name = ?", (name,), fetch=True)}
+
+    def remove_member(self, name, member):
+        self.execute("DELETE FROM session_sets WHERE name = ? AND member = ?", (name, member))
+
+    def append(self, name, value, limit=None):
+        self.execute("INSERT INTO session_log (name, seq, value) SELECT CAST(? AS TEXT), "
+                     "COALESCE(MAX(seq), 0) + 1, CAST(? AS TEXT) FROM session_log WHERE name = ?",
+                     (name, value, name))
+        if limit:
+            self.execute("DELETE FROM session_log WHERE name = ? AND seq <= "
+                         "(SELECT MAX(seq) FROM session_log WHERE name = ?) - ?",
+                         (name, name, limit))
+
+    def entries(self, name, limit=50):
+        rows = self.execute("SELECT value FROM session_log WHERE name = ? ORDER BY seq DESC LIMIT ?",
+                            (name, limit), fetch=True)
+        return [value for (value,) in reversed(rows)]
+
+    def count_keys(self):
+        return self.execute("SELECT COUNT(*) FROM session_kv WHERE expires_at IS NULL OR expires_at > ?",
+                            (time.time(),), fetch=True)[0][0]
+
+    def stats(self):
+        rows = self.execute("SELECT (SELECT COUNT(*) FROM session_kv), (SELECT COUNT(*) FROM session_log)",
+                            fetch=True)
+        return {"stored_keys": rows[0][0], "history_entries": rows[0][1]}
+
+    def dump(self):
+        now = time.time()
+        for key, value, expires_at in self.execute("SELECT key, value, expires_at FROM session_kv", fetch=True):
+            if expires_at is None or expires_at > now:
+                yield {"kind": "kv", "key": key, "value": value,
+                       "ttl": max(int(expires_at - now), 1) if expires_at else None}
+        sets = {}
+        for name, member in self.execute("SELECT name, member FROM session_sets ORDER BY name, member", fetch=True):
+            sets.setdefault(name, []).append(member)
+        for name, members in sets.items():
+            yield {"kind": "set", "name": name, "members": members}
+        logs = {}
+        for name, value in self.execute("SELECT name, value FROM session_log ORDER BY name, seq", fetch=True):
+            logs.setdefault(name, []).append(value)
+        for name, entries in logs.items():
+            yield {"kind": "log", "name": name, "entries": entries}
+
+    def used_bytes(self):
+        # Stored value sizes rather than file or table sizes, which only shrink on VACUUM
+        rows = self.execute("SELECT COALESCE((SELECT SUM(LENGTH(value)) FROM session_kv), 0) + "
+                            "COALESCE((SELECT SUM(LENGTH(value)) FROM session_log), 0)", fetch=True)
+        return int(rows[0][0])
+
+
+class SQLiteStore(SQLStore):
+    name = "sqlite"
+
+    def __init__(self, path):
+        if path != ":memory:":
+            os.makedirs(os.path.dirname(path) or ".", exist_ok=True)
+        self.path = path
+        self.conn = sqlite3.connect(path, check_same_thread=False)
+        super().__init__()
+
+    def connection(self):
+        return self.conn
+
+    def stats(self):
+        return {**super().stats(), "path": self.path}
+    def close(self):
+        self.conn.close()
+
+
+class PostgresStore(SQLStore):
+    name = "postgres"
+    placeholder = "%s"
+
+    def __init__(self, dsn):
+        import psycopg
+
+        self.conn = psycopg.connect(dsn)
+        super().__init__()
+
+    def connection(self):
+        return self.conn
+
+    def close(self):
+        self.conn.close()
+
+
+class Compression:
+    """Compresses values at or over a size threshold.
+
+    Compressed values are stored as a marker naming the codec followed by
+    base64, since every backend stores text. Session memory only stores
+    JSON, which never starts with the marker. Values below the threshold
+    are stored as they are and reads accept either form, so changing the
+    algorithm or threshold never makes existing values unreadable.
+    """
+
+    MARKER = "~z:"
+
+    def __init__(self, algorithm="gzip", threshold=4096, level=None):
+        self.algorithm = algorithm
+        self.threshold = threshold
+        self.level = level
+        if algorithm == "zstd":
+            self.zstd()
+
+    @staticmethod
+    def zstd():
+        try:
+            import zstandard
+        except ImportError:
+            raise ConfigError("zstd compression needs the zstandard package") from None
+        return zstandard
+
+    def encode(self, value, context=""):
+        raw = value.encode()
+        if self.algorithm == "off" or len(raw) < self.threshold:
+            return value
+        if self.algorithm == "zstd":
+            compressed = self.zstd().ZstdCompressor(level=self.level or 3).compress(raw)
+        else:
+            compressed = gzip.compress(raw, compresslevel=self.level or 6)
+        return f"{self.MARKER}{self.algorithm}:{base64.b64encode(compressed).decode()}"
+
+    def decode(self, value, context=""):
+        if not value.startswith(self.MARKER):
+            return value
+        algorithm, _, payload = value[len(self.MARKER):].partition(":")
+        compressed = base64.b64decode(payload)
+        if algorithm == "zstd":
+            return self.zstd().ZstdDecompressor().decompress(compressed).decode()
+        if algorithm == "gzip":
+            return gzip.decompress(compressed).decode()
+        raise ValueError(f"Unknown compression in stored value: {algorithm}")
+
+    def stats(self):
+        return {"compression": self.algorithm, "compression_threshold": self.threshold}
+
+
+class EncodedStore(SessionStore):
+    """Wraps a store, passing values and log entries through codecs.
+
+    Codecs apply in order on the way in and in reverse on the way out; each
+    gets the key or log name as context.
+    """
+
+    def __init__(self, store, codecs):
+        self.store = store
+        self.codecs = codecs
+        self.name = store.name
+
+    def encode(self, value, context):
+        for codec in self.codecs:
+            value = codec.encode(value, context)
+        return value
+
+    def decode(self, value, context):
+        if value is None:
+            return None
+        for codec in reversed(self.codecs):
+            value = codec.decode(value, context)
+        return value
+
+    def set(self, key, value, ttl=None):
+        self.store.set(key, self.encode(value, key), ttl)
+
+    def get(self, key):
+        return self.decode(self.store.get(key), key)
+
+    def set_default(self, key, value):
+        return self.decode(self.store.set_default(key, self.encode(value, key)), key)
+
+    def compare_and_set(self, key, expected, value, ttl=None):
+        # Encrypted values differ on every write, so compare what they decode to
+        raw = self.store.get(key)
+        if self.decode(raw, key) != expected:
+            return False
+        return self.store.compare_and_set(key, raw, self.encode(value, key), ttl)
+
+    def delete(self, key):
+        self.store.delete(key)
+
+    def touch(self, key, ttl=None):
+        self.store.touch(key, ttl)
+
+    def ttl(self, key):
+        return self.store.ttl(key)
+
+    def add_member(self, name, member):
+        self.store.add_member(name, member)
+
+    def count_members(self, name):
+        return self.store.count_members(name)
+
+    def members(self, name):
+        return self.store.members(name)
+
+    def remove_member(self, name, member):
+        self.store.remove_member(name, member)
+
+    def append(self, name, value, limit=None):
+        self.store.append(name, self.encode(value, name), limit)
+
+    def entries(self, name, limit=50):
+        return [self.decode(entry, name) for entry in self.store.entries(name, limit)]
+
+    def count_keys(self):
+        return self.store.count_keys()
+
+    def stats(self):
+        stats = self.store.stats()
+        for codec in self.codecs:
+            stats.update(codec.stats())
+        return stats
+
+    def used_bytes(self):
+        return self.store.used_bytes()
+
+    def health(self):
+        return self.store.health()
+
+    # Records pass through as stored, still compressed and encrypted
+    def dump(self):
+        return self.store.dump()
+
+    def load(self, record):
+        self.store.load(record)
+
+    def close(self):
+        self.store.close()
+
+
+def create_store(config, backend=None):
+    """Create the session store selected by config["backend"], or the named one"""
+    backend = backend or config["backend"]
+    if backend == "redis":
+        store = RedisStore(**config["redis"])
+    elif backend == "sqlite":
+        store = SQLiteStore(config["sqlite"]["path"])
+    elif backend == "postgres":
+        store = PostgresStore(config["postgres"]["dsn"])
+    else:
+        raise ConfigError(f"Unknown session store: {backend}")
+    codecs = [Compression(**config["compression"])]
+    if config["encryption"]["provider"] != "off":
+        from session_crypto import create_envelope
+
+        # Compress first: ciphertext does not compress
+        codecs.append(create_envelope(config))
+    return EncodedStore(store, codecs)
+`
+
+const sessionTiersPy = `#!/usr/bin/env python3
+"""Tiered session memory: hot, warm and cold.
+
+  hot  - the primary session store (Redis by default), where sessions live
+         while they are active and expire after session_ttl
+  warm - a durable SQL store (Postgres by default) for sessions idle longer
+         than tiers.warm_after; nothing expires there
+  cold - gzipped JSON archives in S3-compatible object storage (or a local
+         directory) for sessions idle longer than tiers.cold_after, encrypted
+         like the stores when encryption is on
+
+age() moves sessions down a tier once they have been idle long enough and
+is meant to run periodically (the memory_manager.py "age" command); pinned
+sessions stay hot. A session moves as one record: its context, its summary
+and its history. load() reads a session from whichever tier holds it, so
+callers never need to know where an old session ended up.
+"""
+import gzip
+import json
+import os
+from datetime import datetime
+
+from session_store import create_store
+
+SESSION_PREFIX = "session:"
+SUMMARY_PREFIX = "summary:"
+HISTORY_PREFIX = "history:"
+WARM_INDEX = "warm_sessions"
+
+
+class LocalArchive:
+    """Archives as files in a directory, for development and single hosts"""
+
+    name = "local"
+
+    def __init__(self, path, prefix="sessions/"):
+        self.root = os.path.join(path, prefix)
+        os.makedirs(self.root, exist_ok=True)
+
+    def location(self, session_id):
+        return os.path.join(self.root, f"{session_id}.json.gz")
+
+    def put(self, session_id, data):
+        path = self.location(session_id)
+        with open(path + ".tmp", "wb") as f:
+            f.write(data)
+        os.replace(path + ".tmp", path)
+
+    def get(self, session_id):
+        try:
+            with open(self.location(session_id), "rb") as f:
+                return f.read()
+        except FileNotFoundError:
+            return None
+
+    def delete(self, session_id):
+        try:
+            os.remove(self.location(session_id))
+            return True
+        except FileNotFoundError:
+            return False
+
+    def names(self):
+        return sorted(name[:-len(".json.gz")] for name in os.listdir(self.root) if name.endswith(".json.gz"))
+
+    def count(self):
+        return len(self.names())
+
+
+class S3Archive:
+    """Archives as objects in an S3-compatible bucket (AWS, MinIO, R2, ...)"""
+
+    name = "s3"
+
+    def __init__(self, bucket, prefix="sessions/", endpoint_url=None, region="us-east-1"):
+        import boto3
+        from botocore.exceptions import ClientError
+
+        self.ClientError = ClientError
+        self.bucket = bucket
+        self.prefix = prefix
+        self.client = boto3.client("s3", endpoint_url=endpoint_url, region_name=region)
+        try:
+            self.client.head_bucket(Bucket=bucket)
+        except ClientError:
+            self.client.create_bucket(Bucket=bucket)
+
+    def location(self, session_id):
+        return f"s3://{self.bucket}/{self.prefix}{session_id}.json.gz"
+
+    def put(self, session_id, data):
+        self.client.put_object(Bucket=self.bucket, Key=f"{self.prefix}{session_id}.json.gz", Body=data,
+                               ContentType="application/octet-stream")
+
+    def get(self, session_id):
+        try:
+            response = self.client.get_object(Bucket=self.bucket, Key=f"{self.prefix}{session_id}.json.gz")
+        except self.ClientError as e:
+            if e.response["Error"]["Code"] in ("NoSuchKey", "404"):
+                return None
+            raise
+        return response["Body"].read()
+
+    def delete(self, session_id):
+        if self.get(session_id) is None:
+            return False
+        self.client.delete_object(Bucket=self.bucket, Key=f"{self.prefix}{session_id}.json.gz")
+        return True
+
+    def names(self):
+        paginator = self.client.get_paginator("list_objects_v2")
+        return sorted(item["Key"][len(self.prefix):-len(".json.gz")]
+                      for page in paginator.paginate(Bucket=self.bucket, Prefix=self.prefix)
+                      for item in page.get("Contents", []) if item["Key"].endswith(".json.gz"))
+
+    def count(self):
+        paginator = self.client.get_paginator("list_objects_v2")
+        return sum(page.get("KeyCount", 0) for page in paginator.paginate(Bucket=self.bucket, Prefix=self.prefix))
+
+
+def create_archive(config):
+    cold = config["tiers"]["cold"]
+    if cold["backend"] == "s3":
+        return S3Archive(cold["bucket"], cold["prefix"], cold["endpoint_url"], cold["region"])
+    return LocalArchive(cold["path"], cold["prefix"])
+
+
+def idle_seconds(record, now):
+    stored_at = (record.get("context") or {}).get("stored_at")
+    if not stored_at:
+        return float("inf")
+    return (now - datetime.fromisoformat(stored_at)).total_seconds()
+
+
+class SessionTiers:
+    def __init__(self, hot, config, warm=None, archive=None):
+        self.hot = hot
+        self.config = config
+        self.tiers = config["tiers"]
+        self.warm = warm or create_store(config, self.tiers["warm"]["backend"])
+        self.archive = archive or create_archive(config)
+        self.envelope = None
+        if config["encryption"]["provider"] != "off":
+            from session_crypto import create_envelope
+
+            self.envelope = create_envelope(config)
+
+    # Records: {"session_id", "context", "summary", "history"} with the
+    # context and summary decoded and history as a list of decoded entries
+
+    def read(self, store, session_id):
+        context = store.get(f"{SESSION_PREFIX}{session_id}")
+        if context is None:
+            return None
+        summary = store.get(f"{SUMMARY_PREFIX}{session_id}")
+        history = store.entries(f"{HISTORY_PREFIX}{session_id}", self.config["history_limit"])
+        return {
+            "session_id": session_id,
+            "context": json.loads(context),
+            "summary": json.loads(summary) if summary else None,
+            "history": [json.loads(entry) for entry in history],
+        }
+
+    def write_warm(self, record):
+        session_id = record["session_id"]
+        self.warm.set(f"{SESSION_PREFIX}{session_id}", json.dumps(record["context"]))
+        if record["summary"] is not None:
+            self.warm.set(f"{SUMMARY_PREFIX}{session_id}", json.dumps(record["summary"]))
+        # History is appended, since the warm store may still hold the
+        # history of an earlier stretch of the same session
+        for entry in record["history"]:
+            self.warm.append(f"{HISTORY_PREFIX}{session_id}", json.dumps(entry),
+                             limit=self.config["history_limit"])
+        self.warm.add_member(WARM_INDEX, session_id)
+
+    def read_archive(self, session_id):
+        data = self.archive.get(session_id)
+        if not data:
+            return None
+        if data.startswith(b"~e:"):  # session_crypto.Envelope.MARKER
+            if not self.envelope:
+                raise ValueError("Archived session is encrypted but encryption is off")
+            data = self.envelope.decrypt(data.decode(), session_id)
+        return json.loads(gzip.decompress(data))
+
+    def write_archive(self, record):
+        archived = self.read_archive(record["session_id"])
+        if archived:
+            history = archived["history"] + record["history"]
+            record = {**record, "history": history[-self.config["history_limit"]:]}
+        record = {**record, "archived_at": datetime.now().isoformat()}
+        data = gzip.compress(json.dumps(record).encode())
+        if self.envelope:
+            data = self.envelope.encrypt(data, record["session_id"]).encode()
+        self.archive.put(record["session_id"], data)
+
+    def drop(self, store, session_id, index):
+        for prefix in (SESSION_PREFIX, SUMMARY_PREFIX, HISTORY_PREFIX):
+            store.delete(f"{prefix}{session_id}")
+        store.remove_member(index, session_id)
+
+    def age(self, now=None):
+        """Move idle sessions down a tier; returns how many moved where"""
+        now = now or datetime.now()
+        moved = {"warm": 0, "cold": 0, "expired": 0}
+
+        pinned = self.hot.members("pinned_sessions")
+        for session_id in sorted(self.hot.members("active_sessions") - pinned):
+            record = self.read(self.hot, session_id)
+            if record is None:
+                # Expired from the hot store before it could be moved
+                self.hot.remove_member("active_sessions", session_id)
+                moved["expired"] += 1
+                continue
+            if idle_seconds(record, now) >= self.tiers["warm_after"]:
+                self.write_warm(record)
+                self.drop(self.hot, session_id, "active_sessions")
+                moved["warm"] += 1
+
+        for session_id in sorted(self.warm.members(WARM_INDEX)):
+            record = self.read(self.warm, session_id)
+            if record is None:
+                self.warm.remove_member(WARM_INDEX, session_id)
+                continue
+            if idle_seconds(record, now) >= self.tiers["cold_after"]:
+                self.write_archive(record)
+                self.drop(self.warm, session_id, WARM_INDEX)
+                moved["cold"] += 1
+
+        return moved
+
+    def load(self, session_id):
+        """(tier, record) from the first tier holding the session, or (None, None)"""
+        record = self.read(self.hot, session_id)
+        if record:
+            return "hot", record
+        record = self.read(self.warm, session_id)
+        if record:
+            return "warm", record
+        record = self.read_archive(session_id)
+        if record:
+            return "cold", record
+        return None, None
+
+    def history(self, session_id, limit=50):
+        """History across all tiers, oldest first; a resumed session's
+        earlier history stays in the tier it was aged into"""
+        archived = self.read_archive(session_id)
+        history = archived["history"] if archived else []
+        for store in (self.warm, self.hot):
+            history += [json.loads(entry) for entry in
+                        store.entries(f"{HISTORY_PREFIX}{session_id}", self.config["history_limit"])]
+        return history[-limit:]
+
+    def stats(self):
+        return {
+            "warm_sessions": self.warm.count_members(WARM_INDEX),
+            "warm_backend": self.warm.name,
+            "cold_sessions": self.archive.count(),
+            "cold_backend": self.archive.name,
+        }
+
+    def close(self):
+        self.warm.close()
+`
+
+const sessionServerPy = `#!/usr/bin/env python3
+import os
+from typing import Any, Dict, List, Optional
+
+import uvicorn
+from fastapi import Body, Depends, FastAPI, HTTPException, Query, Request
+from fastapi.responses import PlainTextResponse, Response, StreamingResponse
+
+import config_client
+
+# The config service's settings are in the environment before the modules
+# below read it
+config_client.pull("memory")
+
+from event_bus import SUBJECT_RESULTS, Subscription, bus_url
+from memory_manager import SessionMemoryManager
+from session_bundle import BundleError, SessionExists, to_zip
+from session_concurrency import VersionConflict
+from session_eviction import EvictionJob, SessionEvictor
+from session_live import LiveHub, LocalPublisher, stream
+from session_quotas import QuotaExceeded, tenant_of
+from session_snapshot import SnapshotError
+from session_stats import prometheus
+from session_store import load_config
+from session_summarizer import SummarizationJob
+import api_version
+import logs
+import rbac
+import tracing
+
+logs.setup("session-memory")
+tracing.init("session-memory")
+manager = SessionMemoryManager()
+
+app = FastAPI(title="Session Memory Service")
+logs.install(app)
+rbac.install(app, "memory")
+tracing.install(app)
+api_version.install(app)
+
+live_hub = LiveHub(manager.config["live_updates"]["queue_size"])
+if manager.config["live_updates"]["enabled"] and manager.live is None:
+    manager.live = LocalPublisher(live_hub)
+
+summarization_job = None
+if manager.config["summarization"]["enabled"]:
+    summarization_job = SummarizationJob(manager, manager.config["summarization"]["interval"])
+eviction_job = None
+if manager.config["eviction"]["enabled"]:
+    eviction_job = EvictionJob(SessionEvictor(manager), manager.config["eviction"]["interval"])
+
+
+def take_result(event):
+    """Stores the context of a finished job published on the event bus in
+    its session, as the MCP server does with the jobs reported to it"""
+    result = event["data"]
+    if result.get("status") not in ("succeeded", "partial") or not result.get("session_id"):
+        return
+    tenant = result.get("tenant") or rbac.DEFAULT_TENANT
+    context, _ = manager.load_session(result["session_id"])
+    if context is not None and tenant_of(context) != tenant:
+        logs.warning("not storing a job in another tenant's session", job_id=result.get("job_id"), tenant=tenant,
+                     session_id=result["session_id"])
+        return
+    try:
+        manager.store_session_context(result["session_id"], with_tenant(result.get("context") or {}, tenant), None)
+    except QuotaExceeded as e:
+        logs.warning("could not store a job's context", job_id=result.get("job_id"), tenant=tenant,
+                     session_id=result["session_id"], error=str(e))
+
+
+def with_tenant(context, tenant):
+    """context, stamped with the tenant it is stored for"""
+    if tenant != rbac.DEFAULT_TENANT or "tenant" in context:
+        context["tenant"] = tenant
+    return context
+
+
+def owned_session(request: Request, session_id: str):
+    """404 for a session another tenant stored, as if there were none"""
+    context, _ = manager.load_session(session_id)
+    if context is not None and tenant_of(context) != rbac.tenant_of(request):
+        raise HTTPException(status_code=404, detail="Session not found")
+
+
+def memory_key_of(request, memory_key):
+    """The key a tenant's hot memory is stored under; path segments never
+    hold "/", so no tenant's key can be another's"""
+    tenant = rbac.tenant_of(request)
+    return memory_key if tenant == rbac.DEFAULT_TENANT else f"{tenant}/{memory_key}"
+
+
+# Settings read on every request, which new settings from the config
+# service change in place; the rest are read once, at startup
+LIVE_SETTINGS = ("session_ttl", "summary_ttl", "hot_memory_ttl", "extend_on_access", "ttl_policies",
+                 "history_limit", "packing")
+
+
+def reload_settings(changed):
+    config = load_config()
+    for key in LIVE_SETTINGS:
+        if isinstance(manager.config[key], dict):
+            manager.config[key].clear()
+            manager.config[key].update(config[key])
+        else:
+            manager.config[key] = config[key]
+    restart = sorted(key for key in config if key not in LIVE_SETTINGS and config[key] != manager.config[key])
+    if restart:
+        logs.warning(f"new {', '.join(restart)} settings take effect once session memory restarts")
+
+
+config_watcher = config_client.Watcher("memory", reload_settings) if config_client.config_url() else None
+
+# Replicas of the service share the bus's result events
+results_subscription = Subscription(SUBJECT_RESULTS, "session-memory", "session-memory", take_result) if bus_url() else None
+
+
+@app.on_event("startup")
+def start_background_jobs():
+    if manager.live and not isinstance(manager.live, LocalPublisher):
+        manager.live.listen(live_hub)
+    if summarization_job:
+        summarization_job.start()
+    if eviction_job:
+        eviction_job.start()
+    if results_subscription:
+        results_subscription.start()
+    if config_watcher:
+        config_watcher.start()
+
+
+@app.on_event("shutdown")
+def close_store():
+    if config_watcher:
+        config_watcher.stop()
+    if results_subscription:
+        results_subscription.stop()
+    if manager.events:
+        manager.events.close()
+    if summarization_job:
+        summarization_job.stop()
+    if eviction_job:
+        eviction_job.stop()
+    if manager.tiers:
+        manager.tiers.close()
+    manager.store.close()
+
+
+@app.get("/health")
+def health():
+    return {"status": "healthy", "backend": manager.store.name, "tiering": manager.tiers is not None,
+            "config_version": config_client.version()}
+
+
+# Declared before /sessions/{session_id} so "search" is not taken for an ID
+@app.get("/sessions/search")
+def search_sessions(request: Request, q: str = "", attr: List[str] = Query([]),
+                    limit: int = Query(20, ge=1, le=500)):
+    try:
+        return {"results": manager.search_sessions(q, attr, limit, rbac.tenant_of(request))}
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.post("/sessions/import", status_code=201)
+async def import_session(request: Request, session_id: Optional[str] = None, overwrite: bool = False):
+    try:
+        return manager.import_session(await request.body(), session_id, overwrite, rbac.tenant_of(request))
+    except BundleError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    except SessionExists as e:
+        raise HTTPException(status_code=409, detail=f"Session already exists: {e}")
+    except QuotaExceeded as e:
+        raise HTTPException(status_code=429, detail=str(e))
+
+
+@app.put("/sessions/{session_id}")
+def store_session(request: Request, session_id: str, context: Dict[str, Any],
+                  expected_version: Optional[int] = None):
+    # Without X-Tenant-ID, the context may name its tenant itself
+    try:
+        tenant = rbac.tenant_of(request)
+        if "tenant" in context and rbac.TENANT_HEADER not in request.headers:
+            tenant = rbac.resolve_tenant(str(context["tenant"]), request.state.claims)
+    except rbac.Refused as e:
+        raise HTTPException(status_code=e.status, detail=str(e))
+    if tenant_of(context) != tenant and "tenant" in context:
+        raise HTTPException(status_code=403, detail=f"The context is for tenant {context['tenant']}, not {tenant}")
+    current, _ = manager.load_session(session_id)
+    if current is not None and tenant_of(current) != tenant:
+        raise HTTPException(status_code=403, detail=f"Session {session_id} is another tenant's")
+    try:
+        result = manager.store_session_context(session_id, with_tenant(context, tenant), expected_version)
+    except QuotaExceeded as e:
+        raise HTTPException(status_code=429, detail=str(e))
+    except VersionConflict as e:
+        raise HTTPException(status_code=409, detail=str(e))
+    return {"stored": session_id, "stored_at": context["stored_at"], "version": context["version"],
+            "duplicate": result == "duplicate", "merged": result == "merged"}
+
+
+@app.get("/sessions/{session_id}", dependencies=[Depends(owned_session)])
+def get_session(session_id: str):
+    context = manager.get_session_context(session_id)
+    if context is None:
+        raise HTTPException(status_code=404, detail="Session not found")
+    return context
+
+
+@app.delete("/sessions/{session_id}", dependencies=[Depends(owned_session)])
+def delete_session(session_id: str):
+    return manager.delete_session(session_id)
+
+
+@app.delete("/users/{user_id}")
+def delete_user(user_id: str):
+    return manager.delete_user(user_id)
+
+
+@app.get("/sessions/{session_id}/export", dependencies=[Depends(owned_session)])
+def export_session(session_id: str, format: str = Query("json", pattern="^(json|zip)$")):
+    bundle, nodes = manager.export_session(session_id)
+    if bundle is None:
+        raise HTTPException(status_code=404, detail="Session not found")
+    if format == "json":
+        return bundle
+    return Response(to_zip(bundle, nodes), media_type="application/zip",
+                    headers={"Content-Disposition": f'attachment; filename="{session_id}.zip"'})
+
+
+@app.get("/sessions/{session_id}/prompt", dependencies=[Depends(owned_session)])
+def prompt_context(session_id: str):
+    prompt = manager.prompt_context(session_id)
+    if prompt is None:
+        raise HTTPException(status_code=404, detail="Session not found")
+    return prompt
+
+
+@app.get("/sessions/{session_id}/pack", dependencies=[Depends(owned_session)])
+def pack_context(session_id: str, budget: int = Query(..., ge=1), model: Optional[str] = None, q: str = ""):
+    packed = manager.pack_context(session_id, budget, model, q)
+    if packed is None:
+        raise HTTPException(status_code=404, detail="Session not found")
+    return packed
+
+
+@app.get("/sessions/{session_id}/history", dependencies=[Depends(owned_session)])
+def session_history(session_id: str, limit: int = Query(50, ge=1)):
+    return {"session_id": session_id, "history": manager.get_session_history(session_id, limit)}
+
+
+@app.post("/sessions/{session_id}/compact", dependencies=[Depends(owned_session)])
+def compact_session(session_id: str, force: bool = False):
+    if manager.get_session_context(session_id) is None:
+        raise HTTPException(status_code=404, detail="Session not found")
+    report = manager.compact_session(session_id, force)
+    return report or {"session_id": session_id, "compacted": False}
+
+
+@app.get("/sessions/{session_id}/events", dependencies=[Depends(owned_session)])
+def session_events(session_id: str, until: Optional[str] = None):
+    try:
+        return {"session_id": session_id, "events": manager.session_events(session_id, until)}
+    except RuntimeError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.post("/sessions/{session_id}/rebuild", dependencies=[Depends(owned_session)])
+def rebuild_session(session_id: str, until: Optional[str] = None, apply: bool = False):
+    try:
+        return manager.rebuild_session(session_id, until, apply)
+    except RuntimeError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.put("/sessions/{session_id}/pin", dependencies=[Depends(owned_session)])
+def pin_session(session_id: str):
+    if not manager.pin_session(session_id):
+        raise HTTPException(status_code=404, detail="Session not found")
+    return {"session_id": session_id, "pinned": True}
+
+
+@app.delete("/sessions/{session_id}/pin", dependencies=[Depends(owned_session)])
+def unpin_session(session_id: str):
+    if not manager.unpin_session(session_id):
+        raise HTTPException(status_code=404, detail="Session not found")
+    return {"session_id": session_id, "pinned": False}
+
+
+@app.get("/sessions/{session_id}/tier", dependencies=[Depends(owned_session)])
+def session_tier(session_id: str):
+    tier = manager.locate_session(session_id)
+    if tier is None:
+        raise HTTPException(status_code=404, detail="Session not found")
+    return {"session_id": session_id, "tier": tier}
+
+
+@app.post("/sessions/{session_id}/summary", dependencies=[Depends(owned_session)])
+def summarize_session(session_id: str):
+    summary = manager.summarize_session(session_id)
+    if summary is None:
+        raise HTTPException(status_code=404, detail="Session not found")
+    return summary
+
+
+@app.get("/recall")
+def recall(request: Request, q: str, limit: int = Query(10, ge=1, le=100), exclude_session: Optional[str] = None):
+    # Only the tenant's own past sessions are recalled
+    try:
+        return {"query": q, "results": manager.recall_memories(q, limit, rbac.tenant_of(request), exclude_session)}
+    except RuntimeError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.get("/long-term/{scope}/{scope_id}")
+def long_term_facts(scope: str, scope_id: str, limit: Optional[int] = Query(None, ge=1)):
+    if scope not in manager.config["long_term"]["scopes"]:
+        raise HTTPException(status_code=404, detail=f"Unknown long-term memory scope: {scope}")
+    return {"scope": scope, "id": scope_id, "facts": manager.get_long_term_facts(scope, scope_id, limit)}
+
+
+@app.put("/memory/{memory_key}")
+def store_hot_memory(request: Request, memory_key: str, data: Any = Body(...), ttl: Optional[int] = Query(None, ge=1),
+                     session_id: Optional[str] = None):
+    if session_id is not None:
+        owned_session(request, session_id)
+    manager.store_hot_memory(memory_key_of(request, memory_key), data, ttl, session_id)
+    return {"stored": memory_key}
+
+
+@app.get("/memory/{memory_key}")
+def get_hot_memory(request: Request, memory_key: str):
+    data = manager.get_hot_memory(memory_key_of(request, memory_key))
+    if data is None:
+        raise HTTPException(status_code=404, detail="Memory not found")
+    return data
+
+
+@app.delete("/memory/{memory_key}")
+def delete_hot_memory(request: Request, memory_key: str, session_id: Optional[str] = None):
+    if not manager.delete_hot_memory(memory_key_of(request, memory_key), session_id):
+        raise HTTPException(status_code=404, detail="Memory not found")
+    return {"deleted": memory_key}
+
+
+@app.post("/summarization/run")
+def run_summarization():
+    return {"compacted": manager.summarize_active_sessions()}
+
+
+@app.post("/eviction/run")
+def run_eviction(force: bool = False, dry_run: bool = False):
+    try:
+        return manager.evict_entries(force, dry_run)
+    except RuntimeError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.post("/snapshots", status_code=201)
+def create_snapshot(location: Optional[str] = None):
+    return manager.create_snapshot(location)
+
+
+@app.post("/snapshots/restore")
+def restore_snapshot(source: str):
+    try:
+        return manager.restore_snapshot(source)
+    except SnapshotError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.post("/snapshots/verify")
+def verify_snapshot(source: str):
+    try:
+        return manager.verify_snapshot(source)
+    except SnapshotError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+def whole_store(request: Request):
+    """A backup holds every tenant's sessions, so a token bound to one tenant
+    may not take or restore it"""
+    if rbac.tenant_of(request) != rbac.DEFAULT_TENANT:
+        raise HTTPException(status_code=403, detail="Backups hold every tenant's data")
+
+
+@app.get("/backup", dependencies=[Depends(whole_store)])
+def backup():
+    data, summary = manager.backup()
+    return Response(data, media_type="application/gzip",
+                    headers={"X-Backup-Records": str(summary["records"]), "X-Backup-SHA256": summary["sha256"]})
+
+
+@app.post("/backup/restore", dependencies=[Depends(whole_store)])
+async def restore_backup(request: Request):
+    try:
+        return manager.restore_backup(await request.body())
+    except SnapshotError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.post("/tiers/age")
+def age_sessions():
+    try:
+        return manager.age_sessions()
+    except RuntimeError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+# A tenant sees only its own usage
+@app.get("/usage")
+def usage(request: Request):
+    try:
+        return {"tenants": [manager.get_tenant_usage(rbac.tenant_of(request))]}
+    except RuntimeError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.get("/usage/{tenant}")
+def tenant_usage(request: Request, tenant: str):
+    if tenant != rbac.tenant_of(request):
+        raise HTTPException(status_code=404, detail=f"No usage for tenant {tenant}")
+    try:
+        return manager.get_tenant_usage(tenant)
+    except RuntimeError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.get("/dedup")
+def dedup_stats():
+    try:
+        return manager.get_dedup_stats()
+    except RuntimeError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+@app.get("/sessions/{session_id}/dedup", dependencies=[Depends(owned_session)])
+def session_dedup_stats(session_id: str):
+    try:
+        return manager.get_dedup_stats(session_id)
+    except RuntimeError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+
+
+def live_stream(request: Request, session_id: Optional[str], types: Optional[List[str]]):
+    if not manager.config["live_updates"]["enabled"]:
+        raise HTTPException(status_code=400, detail="Live updates are off; set live_updates.enabled")
+    events = stream(live_hub, session_id, types, manager.config["live_updates"]["heartbeat"], request.is_disconnected)
+    return StreamingResponse(events, media_type="text/event-stream",
+                             headers={"Cache-Control": "no-cache", "X-Accel-Buffering": "no"})
+
+
+@app.get("/stream")
+def stream_all(request: Request, types: Optional[List[str]] = Query(None)):
+    return live_stream(request, None, types)
+
+
+@app.get("/sessions/{session_id}/stream", dependencies=[Depends(owned_session)])
+def stream_session(request: Request, session_id: str, types: Optional[List[str]] = Query(None)):
+    return live_stream(request, session_id, types)
+
+
+@app.get("/stats")
+def stats(top: int = Query(10, ge=0)):
+    return manager.get_memory_stats(top)
+
+
+@app.get("/metrics", response_class=PlainTextResponse)
+def metrics():
+    return prometheus(manager.get_memory_stats())
+
+
+if __name__ == "__main__":
+    uvicorn.run(app, host="0.0.0.0", port=int(os.environ.get("SESSION_MEMORY_PORT", "8090")), log_config=None)
+`
+
+const sessionSearchPy = `#!/usr/bin/env python3
+"""Full-text and attribute search across stored sessions.
+
+The index is kept in the session store itself, as one set of session IDs per
+term, so it works the same on every backend and needs no search engine:
+
+  search:term:<word>       words from the context's keys and values and
+                           from the summary's key points
+  search:attr:<key>=<val>  scalar values (and list items) at each key path,
+                           e.g. apis_accessed=payments or user.team=core
+
+A search intersects the sets for its terms and filters, then checks every
+candidate against the session's current context, since a session may have
+been stored again without some terms or may have expired. Entries that no
+longer match are dropped from the index as they are found. Sessions aged
+into the warm and cold tiers stay indexed and are read through. With
+encryption on, the words and values in set names are replaced by a keyed
+hash (see session_crypto.index_hasher).
+"""
+import re
+
+TERM_PREFIX = "search:term:"
+ATTR_PREFIX = "search:attr:"
+WORD_RE = re.compile(r"[a-z0-9]+")
+STOP_WORDS = {"a", "an", "and", "the", "of", "to", "in", "on", "for", "with", "we", "where", "all",
+              "sessions", "session", "touched", "used", "by", "is", "it", "at", "or"}
+MAX_ATTR_LENGTH = 100
+UNINDEXED = ("stored_at", "version")
+
+
+def stem(word):
+    # Just enough stemming for "APIs" to find "api" and "payment" to find "payments"
+    if len(word) > 3 and word.endswith("s") and not word.endswith("ss"):
+        return word[:-1]
+    return word
+
+
+def terms(text):
+    return {stem(word) for word in WORD_RE.findall(str(text).lower())
+            if len(word) > 1 and word not in STOP_WORDS}
+
+
+def attributes(value, path=""):
+    """key=value pairs for every scalar in a context, with dotted key paths"""
+    pairs = set()
+    if isinstance(value, dict):
+        for key, item in value.items():
+            pairs |= attributes(item, f"{path}.{key}" if path else str(key))
+    elif isinstance(value, list):
+        for item in value:
+            pairs |= attributes(item, path)
+    elif value is not None and path:
+        text = str(value).lower()
+        if len(text) <= MAX_ATTR_LENGTH:
+            pairs.add(f"{path.lower()}={text}")
+    return pairs
+
+
+def indexed(context):
+    """The fields of a context that are searched"""
+    return {key: value for key, value in (context or {}).items() if key not in UNINDEXED}
+
+
+def document_terms(context, summary=None):
+    found = set()
+
+    def walk(value):
+        if isinstance(value, dict):
+            for key, item in value.items():
+                found.update(terms(key))
+                walk(item)
+        elif isinstance(value, list):
+            for item in value:
+                walk(item)
+        elif value is not None:
+            found.update(terms(value))
+
+    walk(indexed(context))
+    if summary:
+        for point in summary.get("key_points", []):
+            found.update(terms(point))
+    return found
+
+
+def parse_filter(text):
+    key, sep, value = text.partition("=")
+    if not sep or not key:
+        raise ValueError(f"Attribute filters look like key=value, got {text!r}")
+    return f"{key.strip().lower()}={value.strip().lower()}"
+
+
+class SessionIndex:
+    def __init__(self, store, hash_name=None):
+        self.store = store
+        self.hash_name = hash_name or (lambda text: text)
+
+    def term_set(self, term):
+        return f"{TERM_PREFIX}{self.hash_name(term)}"
+
+    def attr_set(self, pair):
+        return f"{ATTR_PREFIX}{self.hash_name(pair)}"
+
+    def index(self, session_id, context, summary=None):
+        for term in document_terms(context, summary):
+            self.store.add_member(self.term_set(term), session_id)
+        for pair in attributes(indexed(context)):
+            self.store.add_member(self.attr_set(pair), session_id)
+
+    def candidates(self, query_terms, filters):
+        sets = [self.term_set(term) for term in query_terms] + [self.attr_set(pair) for pair in filters]
+        result = None
+        for name in sorted(sets, key=self.store.count_members):
+            members = self.store.members(name)
+            result = members if result is None else result & members
+            if not result:
+                return set()
+        return result or set()
+
+    def search(self, load, query="", filters=(), limit=20, tenant=None):
+        """Sessions matching every query word and key=value filter, only a
+        tenant's when one is given.
+
+        load(session_id) returns (context, summary) from whichever tier holds
+        the session, or (None, None).
+        """
+        from session_quotas import tenant_of
+
+        query_terms = terms(query)
+        filters = [parse_filter(f) for f in filters]
+        if not query_terms and not filters:
+            raise ValueError("Search needs a query or at least one attribute filter")
+
+        results = []
+        for session_id in self.candidates(query_terms, filters):
+            context, summary = load(session_id)
+            missing_terms = query_terms - document_terms(context, summary) if context else query_terms
+            pairs = attributes(indexed(context)) if context else set()
+            missing_filters = [pair for pair in filters if pair not in pairs]
+            if missing_terms or missing_filters:
+                for term in missing_terms:
+                    self.store.remove_member(self.term_set(term), session_id)
+                for pair in missing_filters:
+                    self.store.remove_member(self.attr_set(pair), session_id)
+                continue
+            if tenant is not None and tenant_of(context) != tenant:
+                continue
+            results.append({
+                "session_id": session_id,
+                "stored_at": context.get("stored_at"),
+                "key_points": summary.get("key_points", []) if summary else [],
+            })
+
+        results.sort(key=lambda result: result["stored_at"] or "", reverse=True)
+        return results[:limit]
+`
+
+const sessionCryptoPy = `#!/usr/bin/env python3
+"""Envelope encryption for session memory at rest.
+
+Every value is encrypted with AES-256-GCM under a data key, and the data key
+is stored next to it, wrapped by a key encryption key that never leaves its
+provider:
+
+  file - keys read from encryption.key_file: either one base64 256-bit key,
+         or {"active": "<id>", "keys": {"<id>": "<base64 key>", ...}} so old
+         keys stay readable after rotating to a new active one
+  kms  - AWS KMS (or a compatible endpoint) generates and unwraps data keys
+         under encryption.kms_key_id
+
+A data key is reused for up to encryption.data_key_reuse values, so KMS is
+not called on every write. The store key is bound to each ciphertext as
+associated data, so a value cannot be copied to another key and decrypted
+there. Values written before encryption was enabled are read as they are.
+"""
+import base64
+import hashlib
+import hmac
+import json
+import os
+import threading
+
+from session_store import ConfigError
+
+WRAP_CONTEXT = b"session-memory-data-key"
+
+
+def aesgcm(key):
+    try:
+        from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+    except ImportError:
+        raise ConfigError("Encryption needs the cryptography package") from None
+    return AESGCM(key)
+
+
+def decode_key(text, key_id):
+    key = base64.b64decode(text)
+    if len(key) != 32:
+        raise ConfigError(f"Encryption key {key_id!r} must be 32 bytes, base64-encoded")
+    return key
+
+
+class FileKeys:
+    name = "file"
+
+    def __init__(self, path):
+        try:
+            with open(path) as f:
+                text = f.read().strip()
+        except OSError as e:
+            raise ConfigError(f"Cannot read the encryption key file: {e}") from None
+        if text.startswith("{"):
+            parsed = json.loads(text)
+            self.active = parsed["active"]
+            self.keys = {key_id: decode_key(key, key_id) for key_id, key in parsed["keys"].items()}
+        else:
+            self.active = "default"
+            self.keys = {"default": decode_key(text, "default")}
+        if self.active not in self.keys:
+            raise ConfigError(f"The active encryption key {self.active!r} is not in the key file")
+        if any(":" in key_id for key_id in self.keys):
+            raise ConfigError("Encryption key IDs cannot contain ':'")
+
+    def new_data_key(self):
+        data_key, nonce = os.urandom(32), os.urandom(12)
+        wrapped = nonce + aesgcm(self.keys[self.active]).encrypt(nonce, data_key, WRAP_CONTEXT)
+        return self.active, data_key, wrapped
+
+    def unwrap(self, key_id, wrapped):
+        if key_id not in self.keys:
+            raise ValueError(f"Encryption key {key_id!r} is not in the key file")
+        return aesgcm(self.keys[key_id]).decrypt(wrapped[:12], wrapped[12:], WRAP_CONTEXT)
+
+
+class KMSKeys:
+    name = "kms"
+
+    def __init__(self, key_id, region=None, endpoint_url=None):
+        import boto3
+
+        self.key_id = key_id
+        self.client = boto3.client("kms", region_name=region, endpoint_url=endpoint_url)
+
+    def new_data_key(self):
+        response = self.client.generate_data_key(KeyId=self.key_id, KeySpec="AES_256",
+                                                 EncryptionContext={"purpose": WRAP_CONTEXT.decode()})
+        return "kms", response["Plaintext"], response["CiphertextBlob"]
+
+    def unwrap(self, key_id, wrapped):
+        return self.client.decrypt(CiphertextBlob=wrapped, KeyId=self.key_id,
+                                   EncryptionContext={"purpose": WRAP_CONTEXT.decode()})["Plaintext"]
+
+
+class Envelope:
+    """Codec that encrypts values as ~e:<key id>:<wrapped data key>:<nonce + ciphertext>"""
+
+    MARKER = "~e:"
+    CACHE_SIZE = 256
+
+    def __init__(self, keys, data_key_reuse=1000):
+        self.keys = keys
+        self.data_key_reuse = data_key_reuse
+        self.lock = threading.Lock()
+        self.current = None
+        self.uses = 0
+        self.unwrapped = {}
+
+    def data_key(self):
+        with self.lock:
+            if self.current is None or self.uses >= self.data_key_reuse:
+                self.current, self.uses = self.keys.new_data_key(), 0
+            self.uses += 1
+            return self.current
+
+    def unwrap(self, key_id, wrapped):
+        cached = self.unwrapped.get((key_id, wrapped))
+        if cached is None:
+            cached = self.keys.unwrap(key_id, wrapped)
+            if len(self.unwrapped) >= self.CACHE_SIZE:
+                self.unwrapped.clear()
+            self.unwrapped[(key_id, wrapped)] = cached
+        return cached
+
+    def encrypt(self, data, context=""):
+        key_id, data_key, wrapped = self.data_key()
+        nonce = os.urandom(12)
+        sealed = nonce + aesgcm(data_key).encrypt(nonce, data, context.encode())
+        return (f"{self.MARKER}{key_id}:{base64.b64encode(wrapped).decode()}:"
+                f"{base64.b64encode(sealed).decode()}")
+
+    def decrypt(self, value, context=""):
+        key_id, wrapped, sealed = value[len(self.MARKER):].split(":")
+        sealed = base64.b64decode(sealed)
+        data_key = self.unwrap(key_id, base64.b64decode(wrapped))
+        return aesgcm(data_key).decrypt(sealed[:12], sealed[12:], context.encode())
+
+    def encode(self, value, context=""):
+        return self.encrypt(value.encode(), context)
+
+    def decode(self, value, context=""):
+        if not value.startswith(self.MARKER):
+            return value
+        return self.decrypt(value, context).decode()
+
+    def stats(self):
+        return {"encryption": self.keys.name}
+
+
+def create_envelope(config):
+    encryption = config["encryption"]
+    if encryption["provider"] == "kms":
+        keys = KMSKeys(encryption["kms_key_id"], encryption["kms_region"], encryption["kms_endpoint_url"])
+    else:
+        keys = FileKeys(encryption["key_file"])
+    return Envelope(keys, encryption["data_key_reuse"])
+
+
+def index_hasher(store):
+    """Keyed hash for search index names, so they do not reveal stored words.
+
+    The key is random, created once per store and kept in it encrypted.
+    """
+    key = base64.b64decode(store.set_default("encryption:index_key", base64.b64encode(os.urandom(32)).decode()))
+
+    def hash_name(text):
+        return hmac.new(key, text.encode(), hashlib.sha256).hexdigest()[:32]
+
+    return hash_name
+`
+
+const sessionDeletionPy = `#!/usr/bin/env python3
+"""Erasure of a session, or of every session of a user.
+
+Deleting a session purges, in every tier:
+
+  - its context, summary and history (hot and warm stores, cold archive)
+  - hot memory stored for it (store_hot_memory with a session_id)
+  - its search index entries and its place in the session and user indexes
+  - long-term facts only it contributed to its user's and project's memory
+  - knowledge graph nodes derived from it, when KNOWLEDGE_GRAPH_URL points
+    at the graph service (its POST /purge)
+  - its events in the event log
+
+Each deletion returns a report of what was found and removed. The report is
+also appended to the deletion log, with the session and user IDs replaced by
+a hash, so a deletion can be proven later without keeping who it was about.
+"""
+import hashlib
+import json
+import os
+import urllib.error
+import urllib.request
+import uuid
+from datetime import datetime
+
+import rbac
+import tracing
+from session_search import attributes, document_terms, indexed
+
+DELETION_LOG = "deletion_log"
+USER_SESSIONS = "user_sessions:"
+SESSION_MEMORY = "session_memory:"
+
+
+def subject_hash(value):
+    return hashlib.sha256(value.encode()).hexdigest()[:16]
+
+
+def purge_graph(session_id=None, user_id=None, url=None, tenant=None):
+    """Ask the knowledge graph service to delete nodes derived from a session
+    or user, in the tenant's graph"""
+    url = url or os.environ.get("KNOWLEDGE_GRAPH_URL")
+    if not url:
+        return {"skipped": "KNOWLEDGE_GRAPH_URL is not set"}
+    body = json.dumps({"session_id": session_id, "user_id": user_id}).encode()
+    request = urllib.request.Request(f"{url.rstrip('/')}/purge", data=body, method="POST",
+                                     headers={"Content-Type": "application/json", **rbac.headers(tenant),
+                                              **tracing.headers()})
+    try:
+        with urllib.request.urlopen(request, timeout=30) as response:
+            return json.loads(response.read())
+    except (urllib.error.URLError, OSError) as e:
+        return {"error": str(e)}
+
+
+class SessionEraser:
+    def __init__(self, manager):
+        self.manager = manager
+        self.store = manager.store
+
+    def user_index(self, user_id):
+        return f"{USER_SESSIONS}{self.manager.index.hash_name(user_id)}"
+
+    def erase_tier(self, store, session_id, index):
+        """Delete a session's keys from one store; returns the kinds found"""
+        found = []
+        for kind, prefix in (("context", "session:"), ("summary", "summary:")):
+            if store.get(f"{prefix}{session_id}") is not None:
+                found.append(kind)
+            store.delete(f"{prefix}{session_id}")
+        if store.entries(f"history:{session_id}", 1):
+            found.append("history")
+        store.delete(f"history:{session_id}")
+        store.remove_member(index, session_id)
+        return found
+
+    def delete_session(self, session_id, log=True, eviction=False):
+        """Erase a session; an eviction (eviction=True) only frees the hot
+        store, keeping graph nodes, long-term facts and events"""
+        manager = self.manager
+        context, summary = manager.load_session(session_id)
+        report = {
+            "deletion_id": uuid.uuid4().hex,
+            "session_id": session_id,
+            "deleted_at": datetime.now().isoformat(),
+            "found": context is not None,
+            "tiers": {},
+        }
+
+        # Index entries are found from the content, so remove them before it goes
+        index = manager.index
+        entries = 0
+        if context is not None:
+            for term in document_terms(context, summary):
+                index.store.remove_member(index.term_set(term), session_id)
+                entries += 1
+            for pair in attributes(indexed(context)):
+                index.store.remove_member(index.attr_set(pair), session_id)
+                entries += 1
+            if context.get("user_id") is not None:
+                self.store.remove_member(self.user_index(str(context["user_id"])), session_id)
+        report["index_entries"] = entries
+        if context is not None and manager.long_term and not eviction:
+            report["long_term_facts"] = manager.long_term.forget_session(session_id, context)
+        if manager.recall and not eviction:
+            manager.recall.forget(session_id)
+        if context is not None and manager.quotas:
+            manager.quotas.release(session_id, context)
+        if manager.dedup:
+            manager.dedup.forget(session_id)
+        manager.versions.forget(session_id)
+
+        report["tiers"]["hot"] = self.erase_tier(self.store, session_id, "active_sessions")
+        self.store.remove_member("pinned_sessions", session_id)
+        if manager.tiers:
+            from session_tiers import WARM_INDEX
+
+            report["tiers"]["warm"] = self.erase_tier(manager.tiers.warm, session_id, WARM_INDEX)
+            report["tiers"]["cold"] = ["archive"] if manager.tiers.archive.delete(session_id) else []
+
+        memory_keys = sorted(self.store.members(f"{SESSION_MEMORY}{session_id}"))
+        for memory_key in memory_keys:
+            self.store.delete(f"{manager.memory_prefix}{memory_key}")
+            self.store.remove_member(f"{SESSION_MEMORY}{session_id}", memory_key)
+        report["hot_memory"] = len(memory_keys)
+
+        if not eviction:
+            report["graph"] = purge_graph(session_id=session_id, tenant=(context or {}).get("tenant"))
+            if manager.events:
+                report["events"] = manager.events.erase(session_id)
+        if log:
+            self.log(report, session_id=session_id)
+        return report
+
+    def delete_user(self, user_id):
+        session_ids = sorted(self.store.members(self.user_index(user_id)))
+        report = {
+            "deletion_id": uuid.uuid4().hex,
+            "user_id": user_id,
+            "deleted_at": datetime.now().isoformat(),
+            "sessions": [self.delete_session(session_id, log=False) for session_id in session_ids],
+            "long_term_facts": self.manager.long_term.forget("user", user_id) if self.manager.long_term else 0,
+            # Purging by user too catches nodes whose session is long gone
+            "graph": purge_graph(user_id=user_id),
+        }
+        self.log(report, user_id=user_id)
+        return report
+
+    def log(self, report, session_id=None, user_id=None):
+        entry = {
+            "deletion_id": report["deletion_id"],
+            "deleted_at": report["deleted_at"],
+            "subject": subject_hash(session_id or user_id),
+            "subject_type": "session" if session_id else "user",
+            "sessions": len(report["sessions"]) if "sessions" in report else 1,
+        }
+        self.store.append(DELETION_LOG, json.dumps(entry))
+`
+
+const sessionBundlePy = `#!/usr/bin/env python3
+"""Portable session bundles, for support escalations and environment moves.
+
+A bundle is one JSON document:
+
+  {"format": "session-memory-bundle", "version": 1, "exported_at": ...,
+   "session_id": ..., "context": ..., "summary": ..., "history": [...],
+   "hot_memory": {key: value}, "graph_nodes": [node_id, ...]}
+
+or a zip holding it as bundle.json plus graph/<node_id>.json for every linked
+graph node, so the nodes themselves can be recreated in the other
+deployment's knowledge graph. Graph nodes are found through the graph
+service at KNOWLEDGE_GRAPH_URL; without it a bundle carries no graph nodes.
+"""
+import io
+import json
+import os
+import urllib.error
+import urllib.parse
+import urllib.request
+import zipfile
+from datetime import datetime
+
+import rbac
+import tracing
+from session_deletion import SESSION_MEMORY, SessionEraser
+from session_quotas import tenant_of
+
+BUNDLE_FORMAT = "session-memory-bundle"
+BUNDLE_VERSION = 1
+
+
+class BundleError(ValueError):
+    pass
+
+
+class SessionExists(Exception):
+    pass
+
+
+def graph_request(path, body=None, url=None, tenant=None):
+    url = url or os.environ.get("KNOWLEDGE_GRAPH_URL")
+    if not url:
+        return None
+    data = json.dumps(body).encode() if body is not None else None
+    request = urllib.request.Request(f"{url.rstrip('/')}{path}", data=data,
+                                     method="POST" if body is not None else "GET",
+                                     headers={"Content-Type": "application/json", **rbac.headers(tenant),
+                                              **tracing.headers()})
+    with urllib.request.urlopen(request, timeout=30) as response:
+        return json.loads(response.read())
+
+
+def export_session(manager, session_id, graph_url=None):
+    """The bundle for a session, and the documents of its graph nodes"""
+    context, summary = manager.load_session(session_id)
+    if context is None:
+        return None, {}
+    memory_keys = sorted(manager.store.members(f"{SESSION_MEMORY}{session_id}"))
+    hot_memory = {key: manager.get_hot_memory(key) for key in memory_keys}
+
+    nodes, graph_error = {}, None
+    try:
+        tenant = context.get("tenant")
+        found = graph_request("/nodes?" + urllib.parse.urlencode({"session_id": session_id}), url=graph_url, tenant=tenant)
+        for node_id in (found or {}).get("nodes", []):
+            nodes[node_id] = graph_request(f"/nodes/{urllib.parse.quote(node_id)}", url=graph_url, tenant=tenant)
+    except (urllib.error.URLError, OSError) as e:
+        graph_error = str(e)
+
+    bundle = {
+        "format": BUNDLE_FORMAT,
+        "version": BUNDLE_VERSION,
+        "exported_at": datetime.now().isoformat(),
+        "session_id": session_id,
+        "context": context,
+        "summary": summary,
+        "history": manager.get_session_history(session_id, manager.config["history_limit"]),
+        "hot_memory": {key: value for key, value in hot_memory.items() if value is not None},
+        "graph_nodes": sorted(nodes),
+    }
+    if graph_error:
+        bundle["graph_error"] = graph_error
+    return bundle, nodes
+
+
+def to_zip(bundle, nodes):
+    buffer = io.BytesIO()
+    with zipfile.ZipFile(buffer, "w", zipfile.ZIP_DEFLATED) as archive:
+        archive.writestr("bundle.json", json.dumps(bundle, indent=2))
+        for node_id, node in nodes.items():
+            archive.writestr(f"graph/{node_id}.json", json.dumps(node, indent=2))
+    return buffer.getvalue()
+
+
+def read_bundle(data):
+    """(bundle, graph node documents) from bundle JSON or zip bytes"""
+    nodes = {}
+    if data[:2] == b"PK":
+        with zipfile.ZipFile(io.BytesIO(data)) as archive:
+            try:
+                bundle = json.loads(archive.read("bundle.json"))
+            except KeyError:
+                raise BundleError("The zip has no bundle.json") from None
+            for name in archive.namelist():
+                if name.startswith("graph/") and name.endswith(".json"):
+                    node = json.loads(archive.read(name))
+                    nodes[node.get("node_id", name[len("graph/"):-len(".json")])] = node
+    else:
+        try:
+            bundle = json.loads(data)
+        except ValueError as e:
+            raise BundleError(f"Not a session bundle: {e}") from None
+    if not isinstance(bundle, dict) or bundle.get("format") != BUNDLE_FORMAT:
+        raise BundleError("Not a session bundle")
+    if bundle.get("version") != BUNDLE_VERSION:
+        raise BundleError(f"Unsupported bundle version {bundle.get('version')}")
+    if not isinstance(bundle.get("context"), dict):
+        raise BundleError("The bundle has no session context")
+    return bundle, nodes
+
+
+def import_session(manager, bundle, nodes=None, session_id=None, overwrite=False, graph_url=None, tenant=None):
+    """Store a bundle's session (under session_id if given), for tenant if
+    given; returns an import report. Another tenant's session is never
+    overwritten."""
+    session_id = session_id or bundle["session_id"]
+    existing = manager.load_session(session_id)[0]
+    if existing is not None and (not overwrite or (tenant is not None and tenant_of(existing) != tenant)):
+        raise SessionExists(session_id)
+
+    store, config = manager.store, manager.config
+    context = bundle["context"]
+    if tenant is not None and (tenant != rbac.DEFAULT_TENANT or "tenant" in context):
+        context = {**context, "tenant": tenant}
+    if manager.quotas:
+        manager.quotas.admit(session_id, context)
+    # Written directly rather than through store_session_context, so the
+    # session keeps its original stored_at and history
+    store.set(f"{manager.session_prefix}{session_id}", json.dumps(context),
+              ttl=manager.session_ttl(session_id, context))
+    store.add_member("active_sessions", session_id)
+    if bundle.get("summary"):
+        summary = {**bundle["summary"], "session_id": session_id}
+        store.set(f"{manager.summary_prefix}{session_id}", json.dumps(summary),
+                  ttl=manager.session_ttl(session_id, context, "summary_ttl"))
+    else:
+        summary = None
+    store.delete(f"{manager.history_prefix}{session_id}")
+    for entry in bundle.get("history", []):
+        store.append(f"{manager.history_prefix}{session_id}", json.dumps(entry), limit=config["history_limit"])
+    for key, value in bundle.get("hot_memory", {}).items():
+        manager.store_hot_memory(key, value, session_id=session_id)
+    manager.index.index(session_id, context, summary)
+    manager.record("session_imported", session_id, context)
+    if context.get("user_id") is not None:
+        store.add_member(SessionEraser(manager).user_index(str(context["user_id"])), session_id)
+
+    report = {
+        "session_id": session_id,
+        "imported_from": bundle["session_id"],
+        "history": len(bundle.get("history", [])),
+        "hot_memory": len(bundle.get("hot_memory", {})),
+        "graph_nodes": [],
+    }
+    for node_id, node in (nodes or {}).items():
+        data = node.get("data")
+        if not isinstance(data, dict):
+            continue
+        if "session_id" in data:
+            data = {**data, "session_id": session_id}
+        try:
+            created = graph_request("/nodes", {"data": data, "valid_from": node.get("valid_from"),
+                                               "valid_to": node.get("valid_to")}, url=graph_url,
+                                    tenant=context.get("tenant"))
+        except (urllib.error.URLError, OSError) as e:
+            report.setdefault("graph_errors", []).append({"node_id": node_id, "error": str(e)})
+            continue
+        if created is None:
+            report["graph_skipped"] = "KNOWLEDGE_GRAPH_URL is not set"
+            break
+        report["graph_nodes"].append(created.get("node_id", node_id))
+    return report
+`
+
+const longTermMemoryPy = `#!/usr/bin/env python3
+"""Long-term memory for users and projects, kept across their sessions.
+
+Summarizing a session whose context names a user_id or project_id (the
+scopes in long_term.scopes) distills durable facts from it into that user's
+or project's memory, which outlives the session itself:
+
+  longterm:<scope>:<id>  {fact_id: {"fact", "first_seen", "last_seen",
+                                    "seen", "sessions"}}
+
+Facts are the tools and APIs a session used, and anything its context lists
+under "facts" or "preferences". A fact seen again in another session is not
+duplicated; its seen count and last_seen go up instead. Each memory keeps
+the long_term.max_facts facts seen in the most sessions, most recent first
+on ties. Long-term memory has no TTL: facts leave it when every session
+they came from is deleted, or when the user is.
+"""
+import hashlib
+import json
+import re
+from datetime import datetime
+
+LONG_TERM_PREFIX = "longterm:"
+FACT_LISTS = ("facts", "preferences")
+MAX_FACT_SESSIONS = 50
+
+
+def fact_id(fact):
+    normalized = " ".join(re.findall(r"\w+", fact.lower()))
+    return hashlib.sha256(normalized.encode()).hexdigest()[:16]
+
+
+def distill(context):
+    """The durable facts in a session's context, in the order found"""
+    facts = []
+    for tool in context.get("tools_used", []):
+        facts.append(f"Uses {tool}")
+    for api in context.get("apis_accessed", []):
+        facts.append(f"Accesses the {api} API")
+    for key in FACT_LISTS:
+        value = context.get(key, [])
+        for fact in value if isinstance(value, list) else [value]:
+            if isinstance(fact, str) and fact.strip():
+                facts.append(fact.strip())
+    return list(dict.fromkeys(facts))
+
+
+class LongTermMemory:
+    def __init__(self, store, config, hash_name=None):
+        self.store = store
+        self.scopes = config["long_term"]["scopes"]
+        self.max_facts = config["long_term"]["max_facts"]
+        self.hash_name = hash_name or (lambda text: text)
+
+    def key(self, scope, scope_id):
+        return f"{LONG_TERM_PREFIX}{scope}:{self.hash_name(str(scope_id))}"
+
+    def owners(self, context):
+        """(scope, id) for every scope a session's context belongs to"""
+        return [(scope, str(context[f"{scope}_id"])) for scope in self.scopes
+                if context.get(f"{scope}_id") is not None]
+
+    def load(self, scope, scope_id):
+        data = self.store.get(self.key(scope, scope_id))
+        return json.loads(data) if data else {}
+
+    def save(self, scope, scope_id, facts):
+        if not facts:
+            self.store.delete(self.key(scope, scope_id))
+            return
+        kept = sorted(facts.items(), key=lambda item: (item[1]["seen"], item[1]["last_seen"]),
+                      reverse=True)[:self.max_facts]
+        self.store.set(self.key(scope, scope_id), json.dumps(dict(kept)))
+
+    def remember(self, session_id, context):
+        """Add a session's facts to the memory of each scope it belongs to"""
+        found = distill(context)
+        now = datetime.now().isoformat()
+        learned = {}
+        for scope, scope_id in self.owners(context):
+            facts = self.load(scope, scope_id)
+            for fact in found:
+                entry = facts.setdefault(fact_id(fact), {"fact": fact, "first_seen": now, "seen": 0,
+                                                         "sessions": []})
+                entry["last_seen"] = now
+                # Summarizing a session again does not count its facts twice
+                if session_id not in entry["sessions"]:
+                    entry["seen"] += 1
+                    entry["sessions"] = (entry["sessions"] + [session_id])[-MAX_FACT_SESSIONS:]
+            self.save(scope, scope_id, facts)
+            learned[scope] = len(found)
+        return learned
+
+    def facts(self, scope, scope_id, limit=None):
+        ranked = sorted(self.load(scope, scope_id).values(),
+                        key=lambda entry: (entry["seen"], entry["last_seen"]), reverse=True)
+        return [{key: entry[key] for key in ("fact", "seen", "first_seen", "last_seen")}
+                for entry in ranked[:limit]]
+
+    def recall(self, context, limit=None):
+        """{scope: {"id", "facts"}} for every scope a session's context belongs to"""
+        return {scope: {"id": scope_id, "facts": self.facts(scope, scope_id, limit)}
+                for scope, scope_id in self.owners(context)}
+
+    def forget_session(self, session_id, context):
+        """Drop a deleted session from the facts it contributed; returns facts removed"""
+        removed = 0
+        for scope, scope_id in self.owners(context):
+            facts = self.load(scope, scope_id)
+            for key, entry in list(facts.items()):
+                if session_id in entry["sessions"]:
+                    entry["sessions"].remove(session_id)
+                    entry["seen"] -= 1
+                    if entry["seen"] <= 0:
+                        del facts[key]
+                        removed += 1
+            self.save(scope, scope_id, facts)
+        return removed
+
+    def forget(self, scope, scope_id):
+        """Erase a user's or project's whole memory; returns the facts removed"""
+        count = len(self.load(scope, scope_id))
+        self.store.delete(self.key(scope, scope_id))
+        return count
+`
+
+const sessionQuotasPy = `#!/usr/bin/env python3
+"""Per-tenant quotas on the hot store.
+
+A session belongs to the tenant in its context (or to "default"), and each
+tenant may store at most quotas.tenants[<tenant>].max_bytes of session
+contexts and summaries and max_sessions sessions, falling back to
+quotas.default. Usage is kept per tenant:
+
+  quota:usage:<tenant>  {session_id: {"bytes", "summary_bytes", "stored_at"}}
+
+A write that would go over a limit either fails with QuotaExceeded
+(on_exceed "reject") or first evicts the tenant's least recently stored,
+unpinned sessions until it fits ("evict_oldest"). Before either, usage is
+reconciled with the store, since sessions also leave it by expiring or
+being aged into the warm tier.
+"""
+import json
+from datetime import datetime
+
+from session_deletion import SessionEraser
+
+USAGE_PREFIX = "quota:usage:"
+TENANTS = "quota_tenants"
+DEFAULT_TENANT = "default"
+
+
+class QuotaExceeded(Exception):
+    def __init__(self, tenant, usage, limits):
+        self.tenant, self.usage, self.limits = tenant, usage, limits
+        super().__init__(f"Tenant {tenant!r} is over its quota: {usage['sessions']} sessions and "
+                         f"{usage['bytes']} bytes, limits {limits}")
+
+
+def tenant_of(context):
+    tenant = (context or {}).get("tenant")
+    return str(tenant) if tenant is not None else DEFAULT_TENANT
+
+
+class SessionQuotas:
+    def __init__(self, manager):
+        self.manager = manager
+        self.store = manager.store
+        quotas = manager.config["quotas"]
+        self.on_exceed = quotas["on_exceed"]
+        self.default = quotas["default"]
+        self.tenants = quotas["tenants"]
+
+    def limits(self, tenant):
+        return {**self.default, **self.tenants.get(tenant, {})}
+
+    def load(self, tenant):
+        data = self.store.get(f"{USAGE_PREFIX}{tenant}")
+        return json.loads(data) if data else {}
+
+    def save(self, tenant, usage):
+        self.store.set(f"{USAGE_PREFIX}{tenant}", json.dumps(usage))
+        self.store.add_member(TENANTS, tenant)
+
+    @staticmethod
+    def totals(usage):
+        return {"sessions": len(usage),
+                "bytes": sum(entry["bytes"] + entry.get("summary_bytes", 0) for entry in usage.values())}
+
+    @staticmethod
+    def fits(totals, limits):
+        return ((limits["max_sessions"] is None or totals["sessions"] <= limits["max_sessions"])
+                and (limits["max_bytes"] is None or totals["bytes"] <= limits["max_bytes"]))
+
+    def reconcile(self, usage):
+        """Drop sessions that have expired or left the hot store"""
+        prefix = self.manager.session_prefix
+        return {session_id: entry for session_id, entry in usage.items()
+                if self.store.get(f"{prefix}{session_id}") is not None}
+
+    def admit(self, session_id, context):
+        """Account for storing a session's context, evicting or raising if it does not fit"""
+        tenant = tenant_of(context)
+        limits = self.limits(tenant)
+        usage = self.load(tenant)
+        previous = usage.get(session_id, {})
+        entry = {
+            "bytes": len(json.dumps(context).encode()),
+            "summary_bytes": previous.get("summary_bytes", 0),
+            "stored_at": context.get("stored_at") or datetime.now().isoformat(),
+        }
+
+        evicted = []
+        if not self.fits(self.totals({**usage, session_id: entry}), limits):
+            usage = self.reconcile(usage)
+        if not self.fits(self.totals({**usage, session_id: entry}), limits) and self.on_exceed == "evict_oldest":
+            pinned = self.store.members("pinned_sessions")
+            oldest = sorted((stored["stored_at"], other) for other, stored in usage.items()
+                            if other != session_id and other not in pinned)
+            for _, other in oldest:
+                if self.fits(self.totals({**usage, session_id: entry}), limits):
+                    break
+                self.evict(other)
+                del usage[other]
+                evicted.append(other)
+        if not self.fits(self.totals({**usage, session_id: entry}), limits):
+            self.save(tenant, usage)
+            raise QuotaExceeded(tenant, self.totals({**usage, session_id: entry}), limits)
+
+        usage[session_id] = entry
+        self.save(tenant, usage)
+        return evicted
+
+    def evict(self, session_id):
+        SessionEraser(self.manager).delete_session(session_id, log=False, eviction=True)
+
+    def record_summary(self, session_id, context, summary):
+        """Count a session's summary towards its tenant's bytes; summaries are never rejected"""
+        tenant = tenant_of(context)
+        usage = self.load(tenant)
+        if session_id in usage:
+            usage[session_id]["summary_bytes"] = len(json.dumps(summary).encode())
+            self.save(tenant, usage)
+
+    def release(self, session_id, context):
+        tenant = tenant_of(context)
+        usage = self.load(tenant)
+        if usage.pop(session_id, None) is not None:
+            self.save(tenant, usage)
+
+    def usage(self, tenant):
+        """A tenant's reconciled usage and its limits"""
+        usage = self.reconcile(self.load(tenant))
+        self.save(tenant, usage)
+        return {"tenant": tenant, **self.totals(usage), "limits": self.limits(tenant),
+                "on_exceed": self.on_exceed}
+
+    def report(self):
+        return [self.usage(tenant) for tenant in sorted(self.store.members(TENANTS))]
+`
+
+const sessionSummarizerPy = `#!/usr/bin/env python3
+"""Background summarization of large sessions.
+
+Every summarization.interval seconds, each active session whose context and
+history together take summarization.size_threshold bytes or more is
+summarized and compacted in place:
+
+  - every list in its context longer than summarization.keep_recent keeps
+    only its most recent entries, and the context gains a "summarized"
+    reference: {"summary": "summary:<id>", "summarized_at", "replaced": {list: count}}
+  - its history keeps the most recent entries, after one reference entry
+    {"summary_ref", "replaced_entries", "from", "to"} standing in for the rest
+
+With tiering on, the replaced history is first written to the session's cold
+archive (the reference names where), so get_session_history still returns
+all of it. Without tiering, the summary is all that remains of it.
+
+The llm_summarization feature flag from the config service turns this off
+for some tenants, or on for only some; without the flag, every tenant's
+sessions are summarized.
+"""
+import json
+import threading
+from datetime import datetime
+
+import config_client
+from session_quotas import tenant_of
+
+
+def session_size(manager, session_id):
+    """(context, bytes of the stored context and history), or (None, 0)"""
+    data = manager.store.get(f"{manager.session_prefix}{session_id}")
+    if data is None:
+        return None, 0
+    history = manager.store.entries(f"{manager.history_prefix}{session_id}", manager.config["history_limit"])
+    return json.loads(data), len(data.encode()) + sum(len(entry.encode()) for entry in history)
+
+
+def compact_session(manager, session_id, force=False):
+    """Summarize a session and replace its raw entries; returns a report, or None if skipped"""
+    settings = manager.config["summarization"]
+    keep_recent = settings["keep_recent"]
+    context, size = session_size(manager, session_id)
+    if context is None or (size < settings["size_threshold"] and not force):
+        return None
+    # Sessions of tenants the llm_summarization flag is off for are only
+    # compacted when asked to
+    if not force and not config_client.enabled("llm_summarization", tenant_of(context), default=True):
+        return None
+
+    replaced = {key: len(value) - keep_recent for key, value in context.items()
+                if isinstance(value, list) and len(value) > keep_recent}
+    history_key = f"{manager.history_prefix}{session_id}"
+    history = [json.loads(entry) for entry in manager.store.entries(history_key, manager.config["history_limit"])]
+    old, recent = history[:-keep_recent], history[-keep_recent:]
+    raw = [entry for entry in old if "summary_ref" not in entry]
+    if not replaced and not raw:
+        # Already compacted; what is left is recent
+        return None
+
+    summary = manager.summarize_session(session_id)
+    summary_key = f"{manager.summary_prefix}{session_id}"
+    now = datetime.now().isoformat()
+    report = {"session_id": session_id, "bytes_before": size, "replaced": replaced}
+
+    if replaced:
+        compacted = {key: value[-keep_recent:] if key in replaced else value for key, value in context.items()}
+        earlier = context.get("summarized", {}).get("replaced", {})
+        compacted["summarized"] = {
+            "summary": summary_key,
+            "summarized_at": now,
+            "replaced": {key: earlier.get(key, 0) + replaced.get(key, 0) for key in set(earlier) | set(replaced)},
+        }
+        # Written directly, so the session keeps its stored_at and is not re-counted in its history
+        if manager.quotas:
+            manager.quotas.admit(session_id, compacted)
+        manager.store.set(f"{manager.session_prefix}{session_id}", json.dumps(compacted),
+                          ttl=manager.session_ttl(session_id, compacted))
+        manager.record("context_compacted", session_id, compacted)
+
+    if raw:
+        reference = {
+            "summary_ref": summary_key,
+            "replaced_entries": sum(entry.get("replaced_entries", 1) for entry in old),
+            "from": old[0].get("from", old[0].get("stored_at")),
+            "to": old[-1].get("to", old[-1].get("stored_at")),
+        }
+        if manager.tiers:
+            manager.tiers.write_archive({"session_id": session_id, "context": context, "summary": summary,
+                                         "history": raw})
+            reference["archive"] = manager.tiers.archive.location(session_id)
+        manager.store.delete(history_key)
+        for entry in [reference] + recent:
+            manager.store.append(history_key, json.dumps(entry), limit=manager.config["history_limit"])
+        report["history_entries"] = len(raw)
+
+    report["bytes_after"] = session_size(manager, session_id)[1]
+    return report
+
+
+def summarize_active(manager):
+    """One pass over the active sessions; returns the sessions compacted"""
+    compacted = []
+    for session_id in sorted(manager.store.members("active_sessions")):
+        report = compact_session(manager, session_id)
+        if report:
+            compacted.append(report)
+    return compacted
+
+
+class SummarizationJob:
+    """Runs summarization passes periodically on a daemon thread"""
+
+    def __init__(self, manager, interval_seconds):
+        self.manager = manager
+        self.interval_seconds = interval_seconds
+        self.stopped = threading.Event()
+        self.thread = threading.Thread(target=self.loop, name="session-summarization", daemon=True)
+
+    def start(self):
+        self.thread.start()
+
+    def stop(self):
+        self.stopped.set()
+
+    def loop(self):
+        while not self.stopped.wait(self.interval_seconds):
+            try:
+                compacted = summarize_active(self.manager)
+            except Exception as e:  # keep the job alive across transient store errors
+                print(f"⚠️ Session summarization run failed: {e}")
+                continue
+            if compacted:
+                print(f"📝 Summarized {len(compacted)} large sessions")
+`
+
+const sessionPackingPy = `#!/usr/bin/env python3
+"""Token-aware packing of session memory into a prompt-ready block.
+
+Given a token budget and a model name, pack() scores every piece of memory
+that could go in a session's prompt and keeps the best that fit, counted with
+the model's own tokenizer (tiktoken) rather than by bytes:
+
+  summary   the session's summary key points              1.0
+  fact      long-term facts about its user and project   0.9, less for rarer facts
+  context   each top-level field of its current context   0.8
+  related   summaries of other sessions matching query    0.6, in search order
+  history   earlier stored contexts, newest first          0.5, decaying with age
+
+A query raises the score of pieces that share words with it. A piece too
+large for what is left of the budget is cut to fit when at least
+packing.min_piece_tokens remain. Models tiktoken does not know are counted
+with packing.encodings (fnmatch patterns, e.g. {"claude-*": "cl100k_base"})
+or packing.fallback_encoding.
+"""
+import fnmatch
+import json
+
+from session_search import terms
+
+SECTIONS = (
+    ("summary", "Session summary"),
+    ("fact", "Known about the user and project"),
+    ("context", "Current context"),
+    ("related", "Related sessions"),
+    ("history", "Earlier in this session"),
+)
+BASE_SCORES = {"summary": 1.0, "fact": 0.9, "context": 0.8, "related": 0.6, "history": 0.5}
+QUERY_BOOST = 0.5
+
+
+class Tokenizer:
+    def __init__(self, model, config):
+        import tiktoken
+
+        packing = config["packing"]
+        self.model = model
+        try:
+            self.encoding = tiktoken.encoding_for_model(model)
+        except KeyError:
+            name = next((encoding for pattern, encoding in packing["encodings"].items()
+                         if fnmatch.fnmatch(model, pattern)), packing["fallback_encoding"])
+            self.encoding = tiktoken.get_encoding(name)
+
+    @property
+    def name(self):
+        return self.encoding.name
+
+    def count(self, text):
+        return len(self.encoding.encode(text))
+
+    def truncate(self, text, tokens):
+        return self.encoding.decode(self.encoding.encode(text)[:tokens]).rstrip() + " …"
+
+
+def pieces(manager, session_id, context, summary, query):
+    """(kind, source, text, base score) for everything that could be packed"""
+    found = []
+    for point in (summary or {}).get("key_points", []):
+        found.append(("summary", session_id, f"- {point}", BASE_SCORES["summary"]))
+
+    if manager.long_term:
+        for scope, memory in manager.long_term.recall(context).items():
+            top = max([fact["seen"] for fact in memory["facts"]] or [1])
+            for fact in memory["facts"]:
+                weight = BASE_SCORES["fact"] * (0.5 + 0.5 * fact["seen"] / top)
+                found.append(("fact", f"{scope}:{memory['id']}", f"- {fact['fact']}", weight))
+
+    for key, value in context.items():
+        if key in ("stored_at", "version", "summarized", "evicted"):
+            continue
+        text = value if isinstance(value, str) else json.dumps(value)
+        found.append(("context", key, f"- {key}: {text}", BASE_SCORES["context"]))
+
+    if query:
+        try:
+            related = manager.search_sessions(query, limit=10)
+        except ValueError:
+            related = []
+        for rank, result in enumerate(r for r in related if r["session_id"] != session_id):
+            if result["key_points"]:
+                text = f"- {result['session_id']}: {'; '.join(result['key_points'])}"
+                found.append(("related", result["session_id"], text, BASE_SCORES["related"] - 0.02 * rank))
+
+    history = manager.get_session_history(session_id, manager.config["packing"]["history_entries"])
+    for age, entry in enumerate(reversed(history[:-1] if history and history[-1] == context else history)):
+        if "summary_ref" in entry:
+            continue  # compacted away; the summary stands in for it
+        fields = {key: value for key, value in entry.items() if key not in ("stored_at", "version")}
+        text = f"- {entry.get('stored_at', 'earlier')}: {json.dumps(fields)}"
+        found.append(("history", entry.get("stored_at"), text, BASE_SCORES["history"] * 0.9 ** age))
+    return found
+
+
+def score(piece, query_terms):
+    _, _, text, base = piece
+    if not query_terms:
+        return base
+    overlap = len(query_terms & terms(text)) / len(query_terms)
+    return base + QUERY_BOOST * overlap
+
+
+def render(chosen):
+    lines = []
+    for kind, title in SECTIONS:
+        texts = [text for piece_kind, _, text, _ in chosen if piece_kind == kind]
+        if texts:
+            lines.append(f"## {title}")
+            lines.extend(texts)
+    return "\n".join(lines)
+
+
+def pack(manager, session_id, budget, model=None, query=""):
+    """The most relevant memory of a session that fits in budget tokens; None if not found"""
+    context, summary = manager.load_session(session_id)
+    if context is None:
+        return None
+    packing = manager.config["packing"]
+    tokenizer = Tokenizer(model or packing["default_model"], manager.config)
+    query_terms = terms(query) if query else set()
+
+    ranked = sorted(((kind, source, text, score((kind, source, text, base), query_terms))
+                     for kind, source, text, base in pieces(manager, session_id, context, summary, query)),
+                    key=lambda piece: piece[3], reverse=True)
+    chosen, omitted = [], 0
+    for kind, source, text, piece_score in ranked:
+        piece = (kind, source, text, piece_score)
+        if tokenizer.count(render(chosen + [piece])) > budget:
+            remaining = budget - tokenizer.count(render(chosen + [(kind, source, "", piece_score)]))
+            if remaining < packing["min_piece_tokens"]:
+                omitted += 1
+                continue
+            piece = (kind, source, tokenizer.truncate(text, remaining - 1), piece_score)
+            if tokenizer.count(render(chosen + [piece])) > budget:
+                omitted += 1
+                continue
+        chosen.append(piece)
+
+    block = render(chosen)
+    return {
+        "session_id": session_id,
+        "model": tokenizer.model,
+        "encoding": tokenizer.name,
+        "budget": budget,
+        "tokens": tokenizer.count(block),
+        "block": block,
+        "included": [{"kind": kind, "source": source, "score": round(piece_score, 3), "tokens": tokenizer.count(text)}
+                     for kind, source, text, piece_score in chosen],
+        "omitted": omitted,
+    }
+`
+
+const sessionEvictionPy = `#!/usr/bin/env python3
+"""Relevance-aware eviction under memory pressure.
+
+When the hot store grows past eviction.high_watermark bytes, entries are
+evicted until it is back under eviction.low_watermark. The entries are the
+items of the lists in active sessions' contexts (context_updates and the
+like), except each list's eviction.keep_recent most recent items, which stay
+as the session's immediate context. Every entry is scored
+
+  recency_weight * recency + relevance_weight * relevance
+
+where recency halves every eviction.half_life seconds since the session was
+last stored and is lower for items earlier in their list, and relevance is
+the cosine similarity between the item's embedding and the session's topic
+embedding (its summary key points, scalar fields and most recent items).
+Lowest scores go first, so an old item that is still on topic outlives a
+newer digression. A session's context records how many items each list lost
+under "evicted". Pinned sessions are never touched.
+"""
+import json
+import math
+import threading
+from datetime import datetime
+
+
+class Embedder:
+    """Sentence-transformers model, loaded on first use"""
+
+    def __init__(self, model_name):
+        self.model_name = model_name
+        self.model = None
+
+    def embed_many(self, texts):
+        if self.model is None:
+            from sentence_transformers import SentenceTransformer
+
+            self.model = SentenceTransformer(self.model_name)
+        return [vector.tolist() for vector in self.model.encode(list(texts), normalize_embeddings=True)]
+
+
+def cosine(a, b):
+    norm = math.sqrt(sum(x * x for x in a)) * math.sqrt(sum(y * y for y in b))
+    return sum(x * y for x, y in zip(a, b)) / norm if norm else 0.0
+
+
+def item_text(item):
+    return item if isinstance(item, str) else json.dumps(item)
+
+
+def topic_text(context, summary, keep_recent):
+    parts = list((summary or {}).get("key_points", []))
+    for key, value in context.items():
+        if key in ("stored_at", "version", "summarized", "evicted"):
+            continue
+        if isinstance(value, list):
+            parts.extend(item_text(item) for item in value[-keep_recent:])
+        elif not isinstance(value, dict):
+            parts.append(f"{key}: {value}")
+    return "\n".join(parts)
+
+
+class SessionEvictor:
+    def __init__(self, manager, embedder=None):
+        self.manager = manager
+        self.settings = manager.config["eviction"]
+        self.embedder = embedder or Embedder(self.settings["embedding_model"])
+
+    def watermarks(self):
+        high = self.settings["high_watermark"]
+        return high, self.settings["low_watermark"] or int(high * 0.8)
+
+    def candidates(self, now=None):
+        """Every evictable entry with its score, lowest first"""
+        now = now or datetime.now()
+        manager, keep_recent = self.manager, self.settings["keep_recent"]
+        pinned = manager.store.members("pinned_sessions")
+        scored = []
+        for session_id in sorted(manager.store.members("active_sessions") - pinned):
+            data = manager.store.get(f"{manager.session_prefix}{session_id}")
+            if data is None:
+                continue
+            context = json.loads(data)
+            entries = [(key, index, item, len(value)) for key, value in context.items()
+                       if isinstance(value, list) and len(value) > keep_recent
+                       for index, item in enumerate(value[:-keep_recent])]
+            if not entries:
+                continue
+            summary = manager.store.get(f"{manager.summary_prefix}{session_id}")
+            topic = topic_text(context, json.loads(summary) if summary else None, keep_recent)
+            vectors = self.embedder.embed_many([topic] + [item_text(item) for _, _, item, _ in entries])
+
+            stored_at = context.get("stored_at")
+            age = (now - datetime.fromisoformat(stored_at)).total_seconds() if stored_at else 0
+            freshness = 0.5 ** (max(age, 0) / self.settings["half_life"])
+            for (key, index, item, length), vector in zip(entries, vectors[1:]):
+                recency = freshness * (0.5 + 0.5 * (index + 1) / length)
+                relevance = max(cosine(vector, vectors[0]), 0.0)
+                scored.append({
+                    "session_id": session_id,
+                    "list": key,
+                    "index": index,
+                    "bytes": len(json.dumps(item).encode()) + 2,
+                    "recency": round(recency, 4),
+                    "relevance": round(relevance, 4),
+                    "score": round(self.settings["recency_weight"] * recency
+                                   + self.settings["relevance_weight"] * relevance, 4),
+                })
+        return sorted(scored, key=lambda entry: entry["score"])
+
+    def run(self, force=False, dry_run=False):
+        """Evict the lowest scored entries until the store is under the low watermark"""
+        used = self.manager.store.used_bytes()
+        high, low = self.watermarks()
+        report = {"used_bytes": used, "high_watermark": high, "low_watermark": low,
+                  "evicted": 0, "freed_bytes": 0, "sessions": {}, "dry_run": dry_run}
+        if used is None or (used < high and not force) or used <= low:
+            return report
+
+        doomed = {}
+        for entry in self.candidates():
+            if report["freed_bytes"] >= used - low:
+                break
+            doomed.setdefault(entry["session_id"], []).append(entry)
+            report["freed_bytes"] += entry["bytes"]
+            report["evicted"] += 1
+
+        for session_id, entries in doomed.items():
+            counts = {}
+            for entry in entries:
+                counts[entry["list"]] = counts.get(entry["list"], 0) + 1
+            report["sessions"][session_id] = counts
+            if not dry_run:
+                self.evict(session_id, entries)
+        return report
+
+    def evict(self, session_id, entries):
+        manager = self.manager
+        key = f"{manager.session_prefix}{session_id}"
+        data = manager.store.get(key)
+        if data is None:
+            return
+        context = json.loads(data)
+        drop = {(entry["list"], entry["index"]) for entry in entries}
+        evicted = context.get("evicted", {}).get("items", {})
+        for name, value in list(context.items()):
+            if isinstance(value, list):
+                kept = [item for index, item in enumerate(value) if (name, index) not in drop]
+                if len(kept) < len(value):
+                    evicted[name] = evicted.get(name, 0) + len(value) - len(kept)
+                    context[name] = kept
+        context["evicted"] = {"evicted_at": datetime.now().isoformat(), "items": evicted}
+        # Written directly, so the session keeps its stored_at and is not re-counted in its history
+        if manager.quotas:
+            manager.quotas.admit(session_id, context)
+        manager.store.set(key, json.dumps(context), ttl=manager.session_ttl(session_id, context))
+        manager.record("entries_evicted", session_id, context)
+
+
+class EvictionJob:
+    """Checks memory pressure periodically on a daemon thread"""
+
+    def __init__(self, evictor, interval_seconds):
+        self.evictor = evictor
+        self.interval_seconds = interval_seconds
+        self.stopped = threading.Event()
+        self.thread = threading.Thread(target=self.loop, name="session-eviction", daemon=True)
+
+    def start(self):
+        self.thread.start()
+
+    def stop(self):
+        self.stopped.set()
+
+    def loop(self):
+        while not self.stopped.wait(self.interval_seconds):
+            try:
+                report = self.evictor.run()
+            except Exception as e:  # keep the job alive across transient store errors
+                print(f"⚠️ Session eviction run failed: {e}")
+                continue
+            if report["evicted"]:
+                print(f"🧹 Evicted {report['evicted']} entries from {len(report['sessions'])} sessions")
+`
+
+const sessionEventsPy = `#!/usr/bin/env python3
+"""Append-only log of every session mutation.
+
+Each change to a session is recorded as an event, in order:
+
+  context_stored     data: the context as stored
+  summary_created    data: the summary
+  context_compacted  data: the context after background summarization
+  entries_evicted    data: the context after relevance eviction
+  session_imported   data: the context from the bundle
+  session_pinned, session_unpinned
+  session_deleted    under a hash of the session ID
+
+so a session's state can be audited, rebuilt as of any point, and replayed
+into the store. event_log.backend picks where events go:
+
+  stream - a Redis stream (event_log.stream), capped at event_log.maxlen
+  file   - a write-ahead log of JSON lines at event_log.path, fsynced per event
+
+Event data passes through the store's codecs, so it is compressed and
+encrypted like the values themselves. Deleting a session removes its events
+too (rewriting the file), since an erasure must not leave its contexts
+behind; only the session_deleted event remains.
+"""
+import json
+import os
+import threading
+import time
+from datetime import datetime
+
+from session_deletion import subject_hash
+
+EVENT_STREAM = "session_events"
+CONTEXT_EVENTS = ("context_stored", "context_compacted", "entries_evicted", "session_imported")
+
+
+class StreamLog:
+    name = "stream"
+
+    def __init__(self, redis_config, stream=EVENT_STREAM, maxlen=None):
+        import redis
+
+        self.client = redis.Redis(**redis_config, decode_responses=True)
+        self.stream = stream
+        self.maxlen = maxlen
+
+    def append(self, event):
+        return self.client.xadd(self.stream, event, maxlen=self.maxlen, approximate=True)
+
+    def read(self):
+        for event_id, fields in self.client.xrange(self.stream):
+            yield event_id, fields
+
+    def remove(self, event_ids):
+        if event_ids:
+            self.client.xdel(self.stream, *event_ids)
+
+    def close(self):
+        self.client.close()
+
+
+class FileLog:
+    name = "file"
+
+    def __init__(self, path):
+        os.makedirs(os.path.dirname(path) or ".", exist_ok=True)
+        self.path = path
+        self.lock = threading.Lock()
+
+    def append(self, event):
+        event_id = f"{time.time_ns()}-{os.getpid()}"
+        line = json.dumps({"id": event_id, **event}) + "\n"
+        with self.lock, open(self.path, "a") as f:
+            f.write(line)
+            f.flush()
+            os.fsync(f.fileno())
+        return event_id
+
+    def read(self):
+        if not os.path.exists(self.path):
+            return
+        with open(self.path) as f:
+            for line in f:
+                if line.strip():
+                    event = json.loads(line)
+                    yield event.pop("id"), event
+
+    def remove(self, event_ids):
+        if not event_ids:
+            return
+        doomed = set(event_ids)
+        with self.lock:
+            with open(self.path) as f:
+                kept = [line for line in f if line.strip() and json.loads(line)["id"] not in doomed]
+            with open(f"{self.path}.tmp", "w") as f:
+                f.writelines(kept)
+                f.flush()
+                os.fsync(f.fileno())
+            os.replace(f"{self.path}.tmp", self.path)
+
+    def close(self):
+        pass
+
+
+class EventLog:
+    def __init__(self, log, store):
+        self.log = log
+        self.store = store
+        self.name = log.name
+
+    def record(self, event_type, session_id, data=None):
+        event = {"type": event_type, "session_id": session_id, "at": datetime.now().isoformat()}
+        if data is not None:
+            event["data"] = self.store.encode(json.dumps(data), EVENT_STREAM)
+        return self.log.append(event)
+
+    def events(self, session_id=None, until=None):
+        """A session's events (or every event), oldest first, up to the at timestamp until"""
+        for event_id, event in self.log.read():
+            if session_id is not None and event["session_id"] != session_id:
+                continue
+            if until is not None and event["at"] > until:
+                break
+            if "data" in event:
+                event["data"] = json.loads(self.store.decode(event["data"], EVENT_STREAM))
+            yield {"id": event_id, **event}
+
+    def rebuild(self, session_id, until=None):
+        """A session's state folded from its events"""
+        state = {"session_id": session_id, "context": None, "summary": None, "pinned": False, "events": 0}
+        for event in self.events(session_id, until):
+            state["events"] += 1
+            state["as_of"] = event["at"]
+            if event["type"] in CONTEXT_EVENTS:
+                state["context"] = event["data"]
+            elif event["type"] == "summary_created":
+                state["summary"] = event["data"]
+            elif event["type"] in ("session_pinned", "session_unpinned"):
+                state["pinned"] = event["type"] == "session_pinned"
+        return state
+
+    def erase(self, session_id):
+        """Remove a session's events, leaving one hashed session_deleted event"""
+        event_ids = [event_id for event_id, event in self.log.read() if event["session_id"] == session_id]
+        self.log.remove(event_ids)
+        self.record("session_deleted", subject_hash(session_id))
+        return len(event_ids)
+
+    def close(self):
+        self.log.close()
+
+
+def create_event_log(config, store):
+    settings = config["event_log"]
+    if settings["backend"] == "stream":
+        log = StreamLog(config["redis"], settings["stream"], settings["maxlen"])
+    else:
+        log = FileLog(settings["path"])
+    return EventLog(log, store)
+`
+
+const sessionSnapshotPy = `#!/usr/bin/env python3
+"""Snapshots of the whole memory system, for backup and restore.
+
+A snapshot holds every record of the hot store (sessions, summaries,
+history, hot memory, indexes and quotas) and, with tiering on, of the warm
+store, as gzipped JSON lines:
+
+  {"format": "session-memory-snapshot", "version": 1, "created_at", "backend", ...}
+  {"tier": "hot", "kind": "kv", "key", "value", "ttl"}     one line per record
+  {"end": true, "records": n, "sha256": digest of the record lines}
+
+Values are written as stored, so a snapshot of an encrypted store stays
+encrypted and restores only where the same keys are configured. The cold
+archive is already durable object storage and is not included, nor is the
+event log.
+
+Snapshots go to snapshots.location (SESSION_SNAPSHOT_LOCATION): a directory,
+usually a mounted volume, or s3://bucket/prefix. Restoring overwrites records
+of the same name and leaves others alone; verify checks a snapshot is
+complete and that every record in it matches the store.
+
+GET /backup hands a snapshot over instead of storing it, and
+POST /backup/restore takes one back, for the control plane's backup of the
+whole deployment.
+"""
+import gzip
+import hashlib
+import json
+from datetime import datetime
+
+from session_tiers import LocalArchive, S3Archive
+
+SNAPSHOT_FORMAT = "session-memory-snapshot"
+SNAPSHOT_VERSION = 1
+
+
+class SnapshotError(ValueError):
+    pass
+
+
+def snapshot_storage(config, location=None):
+    settings = config["snapshots"]
+    location = location or settings["location"]
+    if location.startswith("s3://"):
+        bucket, _, prefix = location[len("s3://"):].partition("/")
+        if prefix and not prefix.endswith("/"):
+            prefix += "/"
+        return S3Archive(bucket, prefix, settings["endpoint_url"], settings["region"])
+    return LocalArchive(location, "")
+
+
+def split_source(source):
+    """(location, snapshot name) for a snapshot's full path or URL, or (location, None)"""
+    if source.endswith(".json.gz"):
+        location, _, name = source.rpartition("/")
+        return location or ".", name[:-len(".json.gz")]
+    return source, None
+
+
+def tiers(manager):
+    found = [("hot", manager.store)]
+    if manager.tiers:
+        found.append(("warm", manager.tiers.warm))
+    return found
+
+
+def snapshot_data(manager):
+    """(gzipped snapshot, what it holds) of the store as it is now"""
+    counts, digest, lines = {}, hashlib.sha256(), []
+    for tier, store in tiers(manager):
+        for record in store.dump():
+            line = json.dumps({"tier": tier, **record})
+            digest.update(line.encode())
+            lines.append(line)
+            counts[f"{tier}_{record['kind']}"] = counts.get(f"{tier}_{record['kind']}", 0) + 1
+    header = {"format": SNAPSHOT_FORMAT, "version": SNAPSHOT_VERSION, "created_at": datetime.now().isoformat(),
+              "backend": manager.store.name, "encryption": manager.config["encryption"]["provider"],
+              "counts": counts}
+    footer = {"end": True, "records": len(lines), "sha256": digest.hexdigest()}
+    data = "\n".join([json.dumps(header)] + lines + [json.dumps(footer)]) + "\n"
+    return gzip.compress(data.encode()), {"records": len(lines), "counts": counts, "sha256": footer["sha256"]}
+
+
+def create_snapshot(manager, location=None):
+    """Write a snapshot; returns where it went and what it holds"""
+    storage = snapshot_storage(manager.config, location)
+    name = f"snapshot-{datetime.now().strftime('%Y%m%dT%H%M%S%f')}"
+    data, summary = snapshot_data(manager)
+    storage.put(name, data)
+    return {"snapshot": storage.location(name), **summary}
+
+
+def read_snapshot(manager, source):
+    """(header, records) of a snapshot, or of the latest one at a location"""
+    location, name = split_source(source)
+    storage = snapshot_storage(manager.config, location)
+    if name is None:
+        names = [found for found in storage.names() if found.startswith("snapshot-")]
+        if not names:
+            raise SnapshotError(f"No snapshots at {location}")
+        name = names[-1]
+    data = storage.get(name)
+    if data is None:
+        raise SnapshotError(f"Snapshot not found: {source}")
+    header, records = parse_snapshot(data)
+    return {**header, "snapshot": storage.location(name)}, records
+
+
+def parse_snapshot(data):
+    """(header, records) of a gzipped snapshot, checked against its footer"""
+    try:
+        lines = gzip.decompress(data).decode().splitlines()
+    except (OSError, EOFError, UnicodeDecodeError):
+        raise SnapshotError("Not a gzipped snapshot")
+    header = json.loads(lines[0]) if lines else {}
+    if header.get("format") != SNAPSHOT_FORMAT:
+        raise SnapshotError("Not a session memory snapshot")
+    if header.get("version") != SNAPSHOT_VERSION:
+        raise SnapshotError(f"Unsupported snapshot version {header.get('version')}")
+    footer = json.loads(lines[-1]) if len(lines) > 1 else {}
+    if not footer.get("end"):
+        raise SnapshotError("Snapshot is truncated")
+    body = lines[1:-1]
+    digest = hashlib.sha256()
+    for line in body:
+        digest.update(line.encode())
+    if len(body) != footer["records"] or digest.hexdigest() != footer["sha256"]:
+        raise SnapshotError("Snapshot checksum does not match its records")
+    return header, [json.loads(line) for line in body]
+
+
+def restore_snapshot(manager, source):
+    header, records = read_snapshot(manager, source)
+    restored, skipped = load_records(manager, records)
+    return {"snapshot": header["snapshot"], "created_at": header["created_at"],
+            "restored": restored, "skipped": skipped}
+
+
+def restore_data(manager, data):
+    """Restore a gzipped snapshot handed over whole, as a backup is"""
+    header, records = parse_snapshot(data)
+    restored, skipped = load_records(manager, records)
+    return {"created_at": header["created_at"], "restored": restored, "skipped": skipped}
+
+
+def load_records(manager, records):
+    stores = dict(tiers(manager))
+    restored, skipped = 0, 0
+    for record in records:
+        store = stores.get(record.pop("tier"))
+        if store is None:
+            skipped += 1  # warm records, with tiering now off
+            continue
+        store.load(record)
+        restored += 1
+    return restored, skipped
+
+
+def verify_snapshot(manager, source):
+    """Check a snapshot is intact and the store holds every record in it"""
+    header, records = read_snapshot(manager, source)
+    current = {}
+    for tier, store in tiers(manager):
+        for record in store.dump():
+            current[(tier, record["kind"], record.get("key") or record.get("name"))] = record
+
+    missing, mismatched = [], []
+    for record in records:
+        name = record.get("key") or record.get("name")
+        found = current.get((record["tier"], record["kind"], name))
+        if found is None:
+            missing.append(name)
+        elif record["kind"] == "kv" and found["value"] != record["value"]:
+            mismatched.append(name)
+        elif record["kind"] == "set" and not set(record["members"]) <= set(found["members"]):
+            mismatched.append(name)
+        elif record["kind"] == "log" and found["entries"] != record["entries"]:
+            mismatched.append(name)
+    return {"snapshot": header["snapshot"], "created_at": header["created_at"], "checked": len(records),
+            "missing": missing, "mismatched": mismatched, "ok": not missing and not mismatched}
+`
+
+const sessionDedupPy = `#!/usr/bin/env python3
+"""Deduplication of resubmitted session contexts.
+
+Agents often store the same context again unchanged. Each incoming context is
+hashed (SHA-256 of its canonical JSON, without stored_at, version and the
+summarized and evicted references) and compared with the session's current
+context. A duplicate is not written again, re-indexed or added to the history:
+
+  skip     - it is dropped, and only counted
+  refcount - the session keeps a reference count for its current context,
+             and the resubmission renews the session's TTL as a store would
+
+Per session, dedup:<session_id> holds {"hash", "refs", "duplicates",
+"bytes_saved", "last_duplicate_at"}; dedup:stats holds the totals
+{"checked", "duplicates", "bytes_saved"} across sessions. bytes_saved counts
+the context and, with keep_history, its history entry.
+"""
+import hashlib
+import json
+from datetime import datetime
+
+DEDUP_PREFIX = "dedup:"
+DEDUP_STATS = "dedup:stats"
+UNHASHED = ("stored_at", "version", "summarized", "evicted")
+
+
+def payload_hash(context):
+    payload = {key: value for key, value in context.items() if key not in UNHASHED}
+    return hashlib.sha256(json.dumps(payload, sort_keys=True).encode()).hexdigest()
+
+
+class SessionDedup:
+    def __init__(self, manager):
+        self.manager = manager
+        self.store = manager.store
+        self.mode = manager.config["dedup"]["mode"]
+
+    def load(self, session_id):
+        data = self.store.get(f"{DEDUP_PREFIX}{session_id}")
+        return json.loads(data) if data else {"hash": None, "refs": 0, "duplicates": 0, "bytes_saved": 0}
+
+    def totals(self):
+        data = self.store.get(DEDUP_STATS)
+        return json.loads(data) if data else {"checked": 0, "duplicates": 0, "bytes_saved": 0}
+
+    def check(self, session_id, context_data):
+        """The stored context if context_data duplicates it, else None"""
+        manager = self.manager
+        digest = payload_hash(context_data)
+        data = self.store.get(f"{manager.session_prefix}{session_id}")
+        current = json.loads(data) if data else None
+        duplicate = current is not None and payload_hash(current) == digest
+
+        record, totals = self.load(session_id), self.totals()
+        totals["checked"] += 1
+        if duplicate:
+            saved = len(json.dumps({**context_data, "stored_at": current.get("stored_at")}).encode())
+            if manager.config["keep_history"]:
+                saved *= 2
+            record.update(hash=digest, duplicates=record["duplicates"] + 1,
+                          bytes_saved=record["bytes_saved"] + saved,
+                          last_duplicate_at=datetime.now().isoformat())
+            totals["duplicates"] += 1
+            totals["bytes_saved"] += saved
+            if self.mode == "refcount":
+                record["refs"] += 1
+                manager.store.touch(f"{manager.session_prefix}{session_id}",
+                                    manager.session_ttl(session_id, current))
+        else:
+            record.update(hash=digest, refs=1)
+        ttl = manager.session_ttl(session_id, current or context_data)
+        self.store.set(f"{DEDUP_PREFIX}{session_id}", json.dumps(record), ttl=ttl)
+        self.store.set(DEDUP_STATS, json.dumps(totals))
+        return current if duplicate else None
+
+    def stats(self, session_id=None):
+        if session_id is not None:
+            return {"session_id": session_id, **self.load(session_id)}
+        totals = self.totals()
+        ratio = totals["duplicates"] / totals["checked"] if totals["checked"] else 0.0
+        return {"mode": self.mode, **totals, "duplicate_ratio": round(ratio, 4)}
+
+    def forget(self, session_id):
+        self.store.delete(f"{DEDUP_PREFIX}{session_id}")
+`
+
+const sessionStatsPy = `#!/usr/bin/env python3
+"""Breakdowns behind get_memory_stats and the /stats and /metrics endpoints.
+
+Besides the store-wide counts, the stats cover every active session:
+
+  tenants            {tenant: {"sessions", "bytes"}}, by each context's tenant
+  largest_sessions   the top sessions by bytes of context, summary and history
+  ttl_distribution   how many sessions expire within an hour, a day, a week,
+                     later, or never
+  health             whether the store answers and how fast, plus the Redis
+                     server's own figures (clients, hit ratio, evictions)
+
+prometheus() renders the same stats in the Prometheus text format.
+"""
+import json
+import time
+
+from session_quotas import tenant_of
+
+TTL_BUCKETS = ((3600, "1h"), (86400, "1d"), (604800, "7d"))
+
+
+def ttl_bucket(ttl):
+    if ttl is None:
+        return "never"
+    return next((name for limit, name in TTL_BUCKETS if ttl <= limit), "later")
+
+
+def store_health(store):
+    started = time.perf_counter()
+    try:
+        store.count_keys()
+    except Exception as e:  # reported, not raised, so a dashboard can show the outage
+        return {"status": "unavailable", "error": str(e)}
+    latency = time.perf_counter() - started
+    return {"status": "ok", "latency_ms": round(latency * 1000, 3), **store.health()}
+
+
+def session_breakdown(manager, top=10):
+    """Per-tenant totals, the largest sessions and the TTL distribution"""
+    store = manager.store
+    tenants, sizes = {}, []
+    distribution = {name: 0 for name in ["never"] + [name for _, name in TTL_BUCKETS] + ["later"]}
+    for session_id in store.members("active_sessions"):
+        key = f"{manager.session_prefix}{session_id}"
+        data = store.get(key)
+        if data is None:
+            continue  # expired, or aged out of the hot store
+        summary = store.get(f"{manager.summary_prefix}{session_id}") or ""
+        history = store.entries(f"{manager.history_prefix}{session_id}", manager.config["history_limit"])
+        size = len(data.encode()) + len(summary.encode()) + sum(len(entry.encode()) for entry in history)
+
+        tenant = tenant_of(json.loads(data))
+        totals = tenants.setdefault(tenant, {"sessions": 0, "bytes": 0})
+        totals["sessions"] += 1
+        totals["bytes"] += size
+        distribution[ttl_bucket(store.ttl(key))] += 1
+        sizes.append({"session_id": session_id, "tenant": tenant, "bytes": size, "history_entries": len(history)})
+
+    largest = sorted(sizes, key=lambda session: session["bytes"], reverse=True)[:top]
+    return {"tenants": tenants, "largest_sessions": largest, "ttl_distribution": distribution}
+
+
+def labels(**values):
+    escaped = {name: str(value).replace("\\", "\\\\").replace('"', '\\"') for name, value in values.items()}
+    return "{" + ",".join(f'{name}="{value}"' for name, value in escaped.items()) + "}"
+
+
+def prometheus(stats):
+    """stats from get_memory_stats in the Prometheus text exposition format"""
+    lines = []
+
+    def metric(name, kind, help_text, samples):
+        lines.append(f"# HELP session_memory_{name} {help_text}")
+        lines.append(f"# TYPE session_memory_{name} {kind}")
+        for label_text, value in samples:
+            lines.append(f"session_memory_{name}{label_text} {value}")
+
+    health = stats["health"]
+    metric("store_up", "gauge", "Whether the session store answers", [(labels(backend=stats["backend"]),
+                                                                      int(health["status"] == "ok"))])
+    if health["status"] != "ok":
+        return "\n".join(lines) + "\n"
+    metric("store_latency_seconds", "gauge", "Time the store took to answer a key count",
+           [("", health["latency_ms"] / 1000)])
+    metric("active_sessions", "gauge", "Sessions in the hot store", [("", stats["active_sessions"])])
+    metric("pinned_sessions", "gauge", "Sessions pinned against expiry", [("", stats["pinned_sessions"])])
+    metric("keys", "gauge", "Keys in the hot store", [("", stats["total_keys"])])
+    metric("tenant_sessions", "gauge", "Active sessions per tenant",
+           [(labels(tenant=tenant), totals["sessions"]) for tenant, totals in sorted(stats["tenants"].items())])
+    metric("tenant_bytes", "gauge", "Bytes of context, summary and history per tenant",
+           [(labels(tenant=tenant), totals["bytes"]) for tenant, totals in sorted(stats["tenants"].items())])
+    metric("sessions_by_ttl", "gauge", "Active sessions by time left until they expire",
+           [(labels(expires_within=bucket), count) for bucket, count in stats["ttl_distribution"].items()])
+    metric("largest_session_bytes", "gauge", "Bytes of the largest sessions",
+           [(labels(session_id=session["session_id"], tenant=session["tenant"]), session["bytes"])
+            for session in stats["largest_sessions"]])
+    for field, name, kind, help_text in (
+        ("used_memory", "redis_used_memory_bytes", "gauge", "Memory Redis is using"),
+        ("connected_clients", "redis_connected_clients", "gauge", "Clients connected to Redis"),
+        ("evicted_keys", "redis_evicted_keys_total", "counter", "Keys Redis evicted under maxmemory"),
+        ("expired_keys", "redis_expired_keys_total", "counter", "Keys Redis expired"),
+        ("keyspace_hits", "redis_keyspace_hits_total", "counter", "Successful key lookups"),
+        ("keyspace_misses", "redis_keyspace_misses_total", "counter", "Failed key lookups"),
+    ):
+        if health.get(field) is not None:
+            metric(name, kind, help_text, [("", health[field])])
+    if "dedup" in stats:
+        metric("dedup_duplicates_total", "counter", "Resubmitted contexts found to be duplicates",
+               [("", stats["dedup"]["duplicates"])])
+        metric("dedup_bytes_saved_total", "counter", "Bytes not stored thanks to deduplication",
+               [("", stats["dedup"]["bytes_saved"])])
+    return "\n".join(lines) + "\n"
+`
+
+const sessionLivePy = `#!/usr/bin/env python3
+"""Live updates of session memory, streamed as server-sent events.
+
+Every session mutation the manager records (context_stored, summary_created,
+context_compacted, entries_evicted, session_pinned, ...) is also published as
+an update {"type", "session_id", "at", "data"}. The API server streams them
+from GET /sessions/{id}/stream, or for every session from GET /stream:
+
+  id: 7
+  event: context_stored
+  data: {"type": "context_stored", "session_id": "...", "at": "...", "data": {...}}
+
+with a ": keepalive" comment every live_updates.heartbeat seconds. With the
+Redis store, updates go over Redis pub/sub (channel
+<live_updates.channel>:<session_id>), so writes from the CLI and from other
+server replicas show up too; their data passes through the store's codecs,
+so it is encrypted like the values themselves. Other stores only stream the
+server's own writes. A subscriber that falls live_updates.queue_size updates
+behind loses the oldest.
+"""
+import asyncio
+import itertools
+import json
+import threading
+from datetime import datetime
+
+ALL_SESSIONS = "*"
+
+
+class LiveHub:
+    """Fans updates out to the server's stream subscribers"""
+
+    def __init__(self, queue_size=100):
+        self.queue_size = queue_size
+        self.lock = threading.Lock()
+        self.subscribers = {}
+        self.ids = itertools.count(1)
+
+    def subscribe(self, session_id=None):
+        queue = asyncio.Queue(self.queue_size)
+        with self.lock:
+            self.subscribers.setdefault(session_id or ALL_SESSIONS, set()).add((asyncio.get_running_loop(), queue))
+        return queue
+
+    def unsubscribe(self, session_id, queue):
+        with self.lock:
+            found = self.subscribers.get(session_id or ALL_SESSIONS, set())
+            found.difference_update({entry for entry in found if entry[1] is queue})
+
+    def deliver(self, update):
+        update = {"id": next(self.ids), **update}
+        with self.lock:
+            targets = list(self.subscribers.get(update["session_id"], ())) + list(self.subscribers.get(ALL_SESSIONS, ()))
+        for loop, queue in targets:
+            loop.call_soon_threadsafe(offer, queue, update)
+
+
+def offer(queue, update):
+    if queue.full():
+        queue.get_nowait()  # a slow subscriber loses the oldest update
+    queue.put_nowait(update)
+
+
+def make_update(event_type, session_id, data=None):
+    update = {"type": event_type, "session_id": session_id, "at": datetime.now().isoformat()}
+    if data is not None:
+        update["data"] = data
+    return update
+
+
+class LocalPublisher:
+    """Delivers straight to the hub of this process"""
+
+    def __init__(self, hub):
+        self.hub = hub
+
+    def publish(self, event_type, session_id, data=None):
+        self.hub.deliver(make_update(event_type, session_id, data))
+
+
+class RedisPublisher:
+    """Publishes over Redis pub/sub, so every server sees every process's writes"""
+
+    def __init__(self, redis_config, store, channel="session_updates"):
+        import redis
+
+        self.client = redis.Redis(**redis_config, decode_responses=True)
+        self.store = store
+        self.channel = channel
+
+    def publish(self, event_type, session_id, data=None):
+        update = make_update(event_type, session_id, data)
+        if data is not None:
+            update["data"] = self.store.encode(json.dumps(data), self.channel)
+        self.client.publish(f"{self.channel}:{session_id}", json.dumps(update))
+
+    def listen(self, hub):
+        """Feed the hub from the channels on a daemon thread"""
+        pubsub = self.client.pubsub(ignore_subscribe_messages=True)
+        pubsub.psubscribe(f"{self.channel}:*")
+
+        def loop():
+            for message in pubsub.listen():
+                update = json.loads(message["data"])
+                if "data" in update:
+                    update["data"] = json.loads(self.store.decode(update["data"], self.channel))
+                hub.deliver(update)
+
+        thread = threading.Thread(target=loop, name="session-live-updates", daemon=True)
+        thread.start()
+        return thread
+
+
+def create_publisher(config, store):
+    """The publisher for a manager; None unless the store can carry updates between processes"""
+    settings = config["live_updates"]
+    if settings["enabled"] and config["backend"] == "redis":
+        return RedisPublisher(config["redis"], store, settings["channel"])
+    return None
+
+
+def format_event(update):
+    payload = {key: value for key, value in update.items() if key != "id"}
+    return f"id: {update['id']}\nevent: {update['type']}\ndata: {json.dumps(payload)}\n\n"
+
+
+async def stream(hub, session_id, types, heartbeat, disconnected):
+    """Server-sent events for a session's updates (every session's if session_id is None)"""
+    queue = hub.subscribe(session_id)
+    try:
+        yield ": connected\n\n"
+        while not await disconnected():
+            try:
+                update = await asyncio.wait_for(queue.get(), heartbeat)
+            except asyncio.TimeoutError:
+                yield ": keepalive\n\n"
+                continue
+            if not types or update["type"] in types:
+                yield format_event(update)
+    finally:
+        hub.unsubscribe(session_id, queue)
+`
+
+const sessionConcurrencyPy = `#!/usr/bin/env python3
+"""Optimistic concurrency for session writes.
+
+Every stored context carries a version, 1 for its first write and one more
+for each write after. A writer that read version n passes it back, as
+expected_version or as the context's own "version" field; if the session
+has moved on in the meantime, concurrency.on_conflict decides:
+
+  merge  - the write is merged into the current context and stored as the
+           next version, so neither writer's additions are lost
+  reject - the write fails with VersionConflict (HTTP 409) and the writer
+           re-reads and retries
+
+Merging is three-way, against the context the writer read, found among the
+session's last concurrency.keep_versions contexts (versions:<session_id>):
+
+  lists    items the writer added are appended; lists named in
+           concurrency.set_fields (tools_used, apis_accessed) are unions,
+           so an item is never repeated
+  objects  merged key by key, the same way
+  scalars  the writer's value if it changed it, the current one otherwise
+
+If that context is too old to be found, lists gain the items the current one
+lacks and the writer's scalars win. Writes with no expected version replace
+the context as before. Either way the store is compare-and-set, so two
+writers never both get the same version.
+"""
+import json
+
+VERSIONS_PREFIX = "versions:"
+
+
+class VersionConflict(Exception):
+    def __init__(self, session_id, expected, current):
+        self.session_id, self.expected, self.current = session_id, expected, current
+        super().__init__(f"Session {session_id!r} is at version {current}, not {expected}; re-read and retry")
+
+
+def canonical(item):
+    return json.dumps(item, sort_keys=True)
+
+
+def added(items, base):
+    """Items not in base, counting repeats, in order"""
+    remaining = [canonical(item) for item in base]
+    found = []
+    for item in items:
+        if canonical(item) in remaining:
+            remaining.remove(canonical(item))
+        else:
+            found.append(item)
+    return found
+
+
+def merge(current, incoming, base=None, set_fields=()):
+    """incoming's changes since base applied on top of current"""
+    merged = dict(current)
+    for key, value in incoming.items():
+        if key in ("stored_at", "version"):
+            continue
+        ours = current.get(key)
+        before = base.get(key) if base is not None else None
+        if isinstance(value, list) and isinstance(ours, list):
+            new = added(value, before if isinstance(before, list) else ours)
+            if key in set_fields:
+                union = list(ours)
+                for item in new:
+                    if canonical(item) not in map(canonical, union):
+                        union.append(item)
+                merged[key] = union
+            else:
+                merged[key] = ours + new
+        elif isinstance(value, dict) and isinstance(ours, dict):
+            merged[key] = merge(ours, value, before if isinstance(before, dict) else None, set_fields)
+        elif base is None or key not in base or value != before:
+            merged[key] = value
+    return merged
+
+
+class SessionVersions:
+    def __init__(self, manager):
+        self.manager = manager
+        self.store = manager.store
+        self.settings = manager.config["concurrency"]
+
+    def key(self, session_id):
+        return f"{VERSIONS_PREFIX}{session_id}"
+
+    def load(self, session_id):
+        data = self.store.get(self.key(session_id))
+        return json.loads(data) if data else []
+
+    def remember(self, session_id, context):
+        # Kept as one value, so the versions expire along with the session
+        recent = (self.load(session_id) + [context])[-self.settings["keep_versions"]:]
+        self.store.set(self.key(session_id), json.dumps(recent), ttl=self.manager.session_ttl(session_id, context))
+
+    def find(self, session_id, version):
+        return next((context for context in self.load(session_id) if context.get("version") == version), None)
+
+    def resolve(self, session_id, incoming, current, expected):
+        """The context to store when incoming was written against version expected"""
+        version = current.get("version", 0) if current else 0
+        if expected is None or current is None or expected == version:
+            return dict(incoming), False
+        if self.settings["on_conflict"] == "reject":
+            raise VersionConflict(session_id, expected, version)
+        base = self.find(session_id, expected)
+        return merge(current, incoming, base, self.settings["set_fields"]), True
+
+    def forget(self, session_id):
+        self.store.delete(self.key(session_id))
+`
+
+const sessionRecallPy = `#!/usr/bin/env python3
+"""Semantic recall over session memory.
+
+Every stored context and summary is embedded, entry by entry: each item of
+its lists, each scalar field as "field: value", and each summary key point.
+Per session, recall:<session_id> holds
+
+  {"tenant", "entries": [{"kind": "context" | "summary", "field", "text", "vector"}]}
+
+(entries whose text is unchanged keep their vector rather than being embedded
+again) and lives as long as the session's summary, so memories outlast the
+context they came from. recall() embeds a query and returns the entries
+closest to it in meaning across sessions, best first, so context assembly
+can pull in what is relevant without knowing which session it was in. Only
+sessions of the same tenant are searched when a tenant is given.
+"""
+import json
+
+from session_eviction import Embedder, cosine, item_text
+from session_quotas import tenant_of
+
+RECALL_PREFIX = "recall:"
+RECALL_SESSIONS = "recall_sessions"
+UNEMBEDDED = ("stored_at", "version", "summarized", "evicted")
+
+
+def memory_texts(context, summary):
+    """(kind, field, text) for every entry worth recalling"""
+    found = []
+    for key, value in (context or {}).items():
+        if key in UNEMBEDDED or value is None:
+            continue
+        if isinstance(value, list):
+            found.extend(("context", key, item_text(item)) for item in value)
+        else:
+            found.append(("context", key, f"{key}: {item_text(value)}"))
+    for point in (summary or {}).get("key_points", []):
+        found.append(("summary", "key_points", point))
+    return found
+
+
+class SessionRecall:
+    def __init__(self, manager, embedder=None):
+        self.manager = manager
+        self.store = manager.store
+        self.settings = manager.config["recall"]
+        self.embedder = embedder or Embedder(self.settings["embedding_model"])
+
+    def key(self, session_id):
+        return f"{RECALL_PREFIX}{session_id}"
+
+    def load(self, session_id):
+        data = self.store.get(self.key(session_id))
+        return json.loads(data) if data else None
+
+    def index(self, session_id, context, summary=None):
+        """Embed a session's entries; the summary is kept from before when not given"""
+        previous = self.load(session_id) or {"entries": []}
+        if summary is None:
+            summary = {"key_points": [entry["text"] for entry in previous["entries"] if entry["kind"] == "summary"]}
+        texts = memory_texts(context, summary)[-self.settings["max_entries"]:]
+
+        known = {entry["text"]: entry["vector"] for entry in previous["entries"]}
+        missing = sorted({text for _, _, text in texts if text not in known})
+        if missing:
+            known.update(zip(missing, self.embedder.embed_many(missing)))
+        entries = [{"kind": kind, "field": field, "text": text, "vector": [round(x, 5) for x in known[text]]}
+                   for kind, field, text in texts]
+        record = {"tenant": tenant_of(context), "entries": entries}
+        self.store.set(self.key(session_id), json.dumps(record),
+                       ttl=self.manager.session_ttl(session_id, context, 'summary_ttl'))
+        self.store.add_member(RECALL_SESSIONS, session_id)
+        return len(missing)
+
+    def recall(self, query, limit=10, tenant=None, exclude_session=None):
+        """The entries closest in meaning to query, across sessions"""
+        vector = self.embedder.embed_many([query])[0]
+        found = []
+        for session_id in self.store.members(RECALL_SESSIONS):
+            if session_id == exclude_session:
+                continue
+            record = self.load(session_id)
+            if record is None:
+                self.store.remove_member(RECALL_SESSIONS, session_id)  # expired
+                continue
+            if tenant is not None and record["tenant"] != tenant:
+                continue
+            for entry in record["entries"]:
+                score = cosine(vector, entry["vector"])
+                if score >= self.settings["min_score"]:
+                    found.append({"session_id": session_id, "kind": entry["kind"], "field": entry["field"],
+                                  "text": entry["text"], "score": round(score, 4)})
+        found.sort(key=lambda result: result["score"], reverse=True)
+        return found[:limit]
+
+    def forget(self, session_id):
+        self.store.delete(self.key(session_id))
+        self.store.remove_member(RECALL_SESSIONS, session_id)
+`
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// testTenancy runs two tenants, team-a and team-b, through one deployment
+// and checks neither reaches the other's data: graph nodes, sessions, hot
+// memory and registered tools are each tenant's own, a token bound to
+// team-a may not act for team-b, and a job the orchestrator runs for
+// team-a ends up in team-a's session. team-b has quotas small enough to
+// hit: one graph node and one tool call a minute.
+func testTenancy(ctx context.Context, client *dagger.Client, mcpServer, knowledgeGraphContainer, sessionMemoryContainer, orchestratorContainer *dagger.Container, neo4j, qdrant, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing Multi-tenancy...")
+
+	secret := client.SetSecret("tenancy-secret", rbacTestSecret)
+	agent := mintToken(rbacTestSecret, "tenancy-agent", "agent")
+	teamA := mintTenantToken(rbacTestSecret, "team-a-agent", "agent", "team-a")
+
+	graph := withGraphServices(knowledgeGraphContainer, neo4j, qdrant).
+		WithNewFile("/app/kg_config.json", dagger.ContainerWithNewFileOpts{
+			Contents: `{"quotas": {"enabled": true, "tenants": {"team-b": {"max_nodes": 1}}}}`,
+		}).
+		WithEnvVariable("KG_PORT", fmt.Sprint(knowledgeGraphPort)).
+		WithExposedPort(knowledgeGraphPort).
+		WithExec([]string{"python3", "/app/kg_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	// Session memory checks tokens, so a bound one can be tried; the MCP
+	// server stores job results in it with an agent token of its own
+	memory := withRBAC(client, withRedis(sessionMemoryContainer, redis), secret, "").
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	mcp := mcpServer.
+		WithServiceBinding("knowledge-graph", graph).
+		WithServiceBinding("session-memory", memory).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithEnvVariable("RBAC_TOKEN", agent).
+		WithEnvVariable("MCP_TENANT_QUOTAS", `{"tenants": {"team-b": {"calls_per_minute": 1}}}`).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	orchestrator := orchestratorContainer.
+		WithServiceBinding("mcp-server", mcp).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		AsService()
+
+	graphBase := fmt.Sprintf("http://knowledge-graph:%d", knowledgeGraphPort)
+	memoryBase := fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)
+	orchestratorBase := fmt.Sprintf("http://orchestrator:%d", orchestratorPort)
+	// A tool both tenants can register: it answers a POST with no body, in
+	// the graph of the tenant it is invoked for
+	tool := fmt.Sprintf(`{"name": "importance", "endpoint": "%s/importance/refresh"}`, graphBase)
+	job := `{"agent_type": "context_gatherer", "target": "tenancy-target", "session_id": "tenancy-job-session", "tenant": "team-a"}`
+	// Each check prints its name and the status it got; only prints 200
+	// when the body has the first pattern and not the second
+	script := fmt.Sprintf(`check() { name=$1; shift; echo "$name $(curl -sS -o /dev/null -w '%%{http_code}' "$@")"; }
+only() {
+  name=$1; want=$2; unwanted=$3; shift 3
+  body=$(curl -sS "$@")
+  case "$body" in *"$unwanted"*) echo "$name $body";; *"$want"*) echo "$name 200";; *) echo "$name $body";; esac
+}
+json='Content-Type: application/json'
+a='X-Tenant-ID: team-a'
+b='X-Tenant-ID: team-b'
+auth='Authorization: Bearer %[5]s'
+check graph-a-add -X POST -H "$json" -H "$a" -d '{"data": {"content": "Team A fixture one"}}' %[1]s/nodes
+check graph-a-add-more -X POST -H "$json" -H "$a" -d '{"data": {"content": "Team A fixture two"}}' %[1]s/nodes
+check graph-b-add -X POST -H "$json" -H "$b" -d '{"data": {"content": "Team B fixture one"}}' %[1]s/nodes
+check graph-b-over-quota -X POST -H "$json" -H "$b" -d '{"data": {"content": "Team B fixture two"}}' %[1]s/nodes
+check graph-invalid-tenant -H 'X-Tenant-ID: Team_A' %[1]s/stats
+only graph-b-graphs '"tenant":"team-b"' 'team-a' -H "$b" %[1]s/graphs
+check memory-a-store -X PUT -H "$json" -H "$a" -H "$auth" -d '{"note": "team a"}' %[2]s/sessions/tenancy-session
+check memory-a-read -H "$a" -H "$auth" %[2]s/sessions/tenancy-session
+check memory-b-read -H "$b" -H "$auth" %[2]s/sessions/tenancy-session
+check memory-b-overwrite -X PUT -H "$json" -H "$b" -H "$auth" -d '{"note": "team b"}' %[2]s/sessions/tenancy-session
+check memory-other-tenant-in-body -X PUT -H "$json" -H "$a" -H "$auth" -d '{"tenant": "team-b"}' %[2]s/sessions/tenancy-other
+only memory-b-search '"results"' 'tenancy-session' -H "$b" -H "$auth" '%[2]s/sessions/search?q=team'
+check memory-a-hot -X PUT -H "$json" -H "$a" -H "$auth" -d '"from team a"' %[2]s/memory/shared-key
+check memory-b-hot -X PUT -H "$json" -H "$b" -H "$auth" -d '"from team b"' %[2]s/memory/shared-key
+only memory-a-hot-read 'from team a' 'from team b' -H "$a" -H "$auth" %[2]s/memory/shared-key
+check memory-bound-own -H 'Authorization: Bearer %[6]s' %[2]s/sessions/tenancy-session
+check memory-bound-other -H "$b" -H 'Authorization: Bearer %[6]s' %[2]s/sessions/tenancy-session
+check mcp-a-register -X POST -H "$json" -H "$a" -d '%[3]s' http://mcp-server:3000/tools/register
+check mcp-b-unregistered -X POST -H "$json" -H "$b" -d '{}' http://mcp-server:3000/api/importance
+check mcp-a-invoke -X POST -H "$json" -H "$a" -d '{}' http://mcp-server:3000/api/importance
+check mcp-b-register -X POST -H "$json" -H "$b" -d '%[3]s' http://mcp-server:3000/tools/register
+check mcp-b-invoke -X POST -H "$json" -H "$b" -d '{}' http://mcp-server:3000/api/importance
+check mcp-b-over-quota -X POST -H "$json" -H "$b" -d '{}' http://mcp-server:3000/api/importance
+check mcp-memory-b-read -H "$b" -H "$auth" http://mcp-server:3000/memory/sessions/tenancy-session
+check mcp-invalid-tenant -H 'X-Tenant-ID: -team' http://mcp-server:3000/agents/streams
+check job-invalid-tenant -X POST -H "$json" -d '{"target": "tenancy-target", "tenant": "Team A"}' %[4]s/jobs
+id=$(curl -fsS -X POST -H "$json" -d '%[7]s' %[4]s/jobs | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 60); do
+  state=$(curl -fsS %[4]s/jobs/$id)
+  case "$state" in *'"reported":true'*|*'"status":"failed"'*) break;; esac
+  sleep 1
+done
+case "$state" in *'"reported":true'*) echo "job-reported 200";; *) echo "job-reported $state";; esac
+for i in $(seq 30); do
+  curl -fsS -o /dev/null -H "$a" -H "$auth" %[2]s/sessions/tenancy-job-session && break
+  sleep 1
+done
+check job-session-a -H "$a" -H "$auth" %[2]s/sessions/tenancy-job-session
+check job-session-b -H "$b" -H "$auth" %[2]s/sessions/tenancy-job-session`,
+		graphBase, memoryBase, tool, orchestratorBase, agent, teamA, job)
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("knowledge-graph", graph).
+		WithServiceBinding("session-memory", memory).
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("orchestrator", orchestrator).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	want := map[string]string{
+		"graph-a-add": "200", "graph-a-add-more": "200", "graph-b-add": "200", "graph-b-over-quota": "429",
+		"graph-invalid-tenant": "400", "graph-b-graphs": "200",
+		"memory-a-store": "200", "memory-a-read": "200", "memory-b-read": "404", "memory-b-overwrite": "403",
+		"memory-other-tenant-in-body": "403", "memory-b-search": "200",
+		"memory-a-hot": "200", "memory-b-hot": "200", "memory-a-hot-read": "200",
+		"memory-bound-own": "200", "memory-bound-other": "403",
+		"mcp-a-register": "200", "mcp-b-unregistered": "404", "mcp-a-invoke": "200", "mcp-b-register": "200",
+		"mcp-b-invoke": "200", "mcp-b-over-quota": "429", "mcp-memory-b-read": "404", "mcp-invalid-tenant": "400",
+		"job-invalid-tenant": "422", "job-reported": "200", "job-session-a": "200", "job-session-b": "404",
+	}
+	got := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if check, status, ok := strings.Cut(line, " "); ok {
+			got[check] = status
+		}
+	}
+	checks := make([]string, 0, len(want))
+	for check := range want {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		if got[check] != want[check] {
+			return fmt.Errorf("%s answered %q, want %s:\n%s", check, got[check], want[check], output)
+		}
+	}
+
+	fmt.Printf("Multi-tenancy: %d checks held across the graph, session memory, the MCP server and the orchestrator\n", len(want))
+	return nil
+}
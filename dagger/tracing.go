@@ -0,0 +1,714 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// tracingSource is the shared distributed tracing, relative to the
+// repository root the pipeline runs from.
+const tracingSource = "packages/tracing"
+
+// jaegerPort is where the tracing test's Jaeger takes OTLP/HTTP spans, and
+// jaegerQueryPort where it answers for the traces it has.
+const (
+	jaegerPort      = 4318
+	jaegerQueryPort = 16686
+)
+
+// withTracing binds a collector into a container as jaeger and has the
+// service export its spans to it.
+func withTracing(container *dagger.Container, collector *dagger.Service) *dagger.Container {
+	return container.
+		WithServiceBinding("jaeger", collector).
+		WithEnvVariable("OTEL_EXPORTER_OTLP_ENDPOINT", fmt.Sprintf("http://jaeger:%d", jaegerPort))
+}
+
+// testTracing follows one job through the system in Jaeger: the request
+// that submits it and its run in the orchestrator, the issue_tracker agent
+// it launches, the items the agent streams to the MCP server, the nodes it
+// publishes, which the knowledge graph takes from the event bus, and the
+// job's result, stored in session memory by the MCP server and again from
+// the bus. All of it has to be one trace.
+func testTracing(ctx context.Context, client *dagger.Client, orchestratorContainer, mcpServer, knowledgeGraphContainer, sessionMemoryContainer *dagger.Container, neo4j, qdrant, redis *dagger.Service) error {
+	fmt.Println("🧪 Testing Distributed tracing...")
+
+	jaeger := client.Container().
+		From("jaegertracing/all-in-one:1.57").
+		WithEnvVariable("COLLECTOR_OTLP_ENABLED", "true").
+		WithExposedPort(jaegerPort).
+		WithExposedPort(jaegerQueryPort).
+		AsService()
+	bus := eventBusService(client)
+
+	graph := withTracing(withEventBus(withGraphServices(knowledgeGraphContainer, neo4j, qdrant), bus), jaeger).
+		WithEnvVariable("KG_PORT", fmt.Sprint(knowledgeGraphPort)).
+		WithExposedPort(knowledgeGraphPort).
+		WithExec([]string{"python3", "/app/kg_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	memory := withTracing(withEventBus(withRedis(sessionMemoryContainer, redis), bus), jaeger).
+		WithEnvVariable("SESSION_MEMORY_PORT", fmt.Sprint(sessionMemoryPort)).
+		WithExposedPort(sessionMemoryPort).
+		WithExec([]string{"python3", "/app/session_server.py"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	mcp := withTracing(mcpServer, jaeger).
+		WithServiceBinding("session-memory", memory).
+		WithEnvVariable("SESSION_MEMORY_URL", fmt.Sprintf("http://session-memory:%d", sessionMemoryPort)).
+		WithExposedPort(3000).
+		WithExec([]string{"node", "/app/mcp_server.js"}, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		AsService()
+	site := client.Container().
+		From("python:3.11-slim").
+		WithNewFile("/srv/api/repos/fixture/repo/issues", dagger.ContainerWithNewFileOpts{Contents: agentFixtureIssues}).
+		WithExposedPort(8000).
+		WithExec([]string{"python3", "-m", "http.server", "8000", "--directory", "/srv"}).
+		AsService()
+	orchestrator := withTracing(withEventBus(orchestratorContainer, bus), jaeger).
+		WithServiceBinding("mcp-server", mcp).
+		WithServiceBinding("site", site).
+		WithServiceBinding("knowledge-graph", graph).
+		WithEnvVariable("MCP_SERVER_URL", "http://mcp-server:3000").
+		WithEnvVariable("AGENT_GITHUB_API", "http://site:8000/api").
+		AsService()
+
+	base := fmt.Sprintf("http://orchestrator:%d", orchestratorPort)
+	job := `{"agent_type": "issue_tracker", "target": "github:fixture/repo", "session_id": "tracing-session"}`
+	// The job's traceparent names its trace. Spans are sent at least every
+	// five seconds, and the subscribers' come after the job is reported.
+	script := fmt.Sprintf(`set -e
+job=$(curl -fsS -X POST -H 'Content-Type: application/json' -d '%[2]s' %[1]s/jobs)
+trace=$(echo "$job" | sed 's/.*"traceparent":"00-\([0-9a-f]*\)-.*/\1/')
+id=$(echo "$job" | sed 's/.*"id":"\([^"]*\)".*/\1/')
+for i in $(seq 60); do
+  state=$(curl -fsS %[1]s/jobs/$id)
+  case "$state" in *'"reported":true'*|*'"status":"failed"'*) break;; esac
+  sleep 1
+done
+case "$state" in *'"status":"succeeded"'*) ;; *) echo "job did not succeed: $state" >&2; exit 1;; esac
+for i in $(seq 30); do
+  sleep 2
+  trace_json=$(curl -fsS http://jaeger:%[3]d/api/traces/$trace || true)
+  case "$trace_json" in *context.nodes*context.results*|*context.results*context.nodes*) break;; esac
+done
+echo "$trace_json"`, base, job, jaegerQueryPort)
+	output, err := client.Container().
+		From("curlimages/curl:8.5.0").
+		WithServiceBinding("orchestrator", orchestrator).
+		WithServiceBinding("jaeger", jaeger).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	var found struct {
+		Data []struct {
+			TraceID string `json:"traceID"`
+			Spans   []struct {
+				OperationName string `json:"operationName"`
+				ProcessID     string `json:"processID"`
+			} `json:"spans"`
+			Processes map[string]struct {
+				ServiceName string `json:"serviceName"`
+			} `json:"processes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(output), &found); err != nil {
+		return fmt.Errorf("reading the trace from Jaeger: %w: %s", err, output)
+	}
+	if len(found.Data) != 1 {
+		return fmt.Errorf("Jaeger has %d traces for the job, want 1: %s", len(found.Data), output)
+	}
+	trace := found.Data[0]
+	spans := map[string]bool{}
+	for _, span := range trace.Spans {
+		spans[trace.Processes[span.ProcessID].ServiceName+": "+span.OperationName] = true
+	}
+	// Each hop the job takes, as service and span name
+	want := []string{
+		"orchestrator: POST /jobs",
+		"orchestrator: job issue_tracker",
+		"orchestrator: run issue_tracker",
+		"micro-agent: gather issue_tracker",
+		"mcp-server: agent_item receive",
+		"mcp-server: POST /agents/results",
+		"session-memory: PUT /sessions/tracing-session",
+		"session-memory: context.results receive",
+		"knowledge-graph: context.nodes receive",
+	}
+	for _, hop := range want {
+		if !spans[hop] {
+			seen := make([]string, 0, len(spans))
+			for span := range spans {
+				seen = append(seen, span)
+			}
+			sort.Strings(seen)
+			return fmt.Errorf("trace %s has no %q span; it has:\n%s", trace.TraceID, hop, strings.Join(seen, "\n"))
+		}
+	}
+
+	fmt.Printf("Distributed tracing: the job is one trace of %d spans across %d services\n", len(trace.Spans), len(trace.Processes))
+	return nil
+}
+
+// tracingPy is the Python side of packages/tracing.
+const tracingPy = `#!/usr/bin/env python3
+"""Distributed tracing, the same as packages/tracing: OpenTelemetry spans,
+exported as OTLP/HTTP JSON to OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or to
+/v1/traces under OTEL_EXPORTER_OTLP_ENDPOINT, with trace context passed on as
+a W3C traceparent. With neither set nothing is exported, but trace context
+still passes through. OTEL_SERVICE_NAME overrides the service name.
+
+A service calls init(name) once, and install(app) to give every request a
+server span. The current span follows the request, and the threads and
+tasks started from it; headers() is its traceparent, for calls to the other
+services, and event_bus.py puts it in the envelope of the events it
+publishes. An agent's spans are children of TRACEPARENT, the orchestrator's
+span for its run.
+"""
+import atexit
+import contextlib
+import contextvars
+import json
+import os
+import secrets
+import threading
+import time
+import urllib.request
+
+HEADER = "traceparent"
+ENV_VAR = "TRACEPARENT"
+
+KIND_INTERNAL, KIND_SERVER, KIND_CLIENT, KIND_PRODUCER, KIND_CONSUMER = 1, 2, 3, 4, 5
+
+# Spans are sent BATCH_SIZE at once, at the latest every FLUSH_INTERVAL
+# seconds; past MAX_QUEUED waiting for the collector, new ones are dropped.
+BATCH_SIZE = 512
+FLUSH_INTERVAL = 5
+MAX_QUEUED = 4096
+
+_current = contextvars.ContextVar("tracing_span", default=None)
+
+
+class SpanContext:
+    """Identifies a span across process boundaries"""
+
+    def __init__(self, trace_id, span_id, sampled=True):
+        self.trace_id, self.span_id, self.sampled = trace_id, span_id, sampled
+
+    @property
+    def traceparent(self):
+        return f"00-{self.trace_id}-{self.span_id}-{'01' if self.sampled else '00'}"
+
+
+def parse(value):
+    """The SpanContext of a W3C traceparent, or None for a missing or
+    invalid one. Versions after 00 are read as 00, as the spec asks."""
+    parts = (value or "").strip().split("-")
+    if len(parts) < 4 or len(parts[0]) != 2 or parts[0] == "ff" or (parts[0] == "00" and len(parts) != 4):
+        return None
+    trace_id, span_id, flags = parts[1].lower(), parts[2].lower(), parts[3]
+    try:
+        int(parts[0], 16), int(trace_id, 16), int(span_id, 16)
+        sampled = bool(int(flags, 16) & 1)
+    except ValueError:
+        return None
+    if len(trace_id) != 32 or len(span_id) != 16 or len(flags) != 2 or not int(trace_id, 16) or not int(span_id, 16):
+        return None
+    return SpanContext(trace_id, span_id, sampled)
+
+
+def current():
+    """The current span's context, local or remote, or None"""
+    return _current.get()
+
+
+def from_env():
+    """TRACEPARENT, the parent of an agent's spans, or None"""
+    return parse(os.getenv(ENV_VAR))
+
+
+def headers():
+    """The traceparent header of the current span, for calls to the other
+    services; empty outside a span"""
+    context = current()
+    return {HEADER: context.traceparent} if context else {}
+
+
+class Span:
+    """One timed operation, the child of parent or the root of a new trace"""
+
+    def __init__(self, tracer, name, kind, parent):
+        self.tracer, self.name, self.kind = tracer, name, kind
+        self.context = SpanContext(parent.trace_id if parent else secrets.token_hex(16), secrets.token_hex(8),
+                                   parent.sampled if parent else True)
+        self.parent_id = parent.span_id if parent else None
+        self.start_ns, self.end_ns = time.time_ns(), None
+        self.attributes, self.error = {}, None
+
+    def set_attribute(self, key, value):
+        self.attributes[key] = value
+
+    def fail(self, message):
+        self.error = message
+
+    def end(self):
+        """Finishes the span; only the first call counts"""
+        if self.end_ns is not None:
+            return
+        self.end_ns = time.time_ns()
+        if self.context.sampled:
+            self.tracer.exporter.add(self)
+
+    def to_otlp(self):
+        span = {"traceId": self.context.trace_id, "spanId": self.context.span_id, "name": self.name,
+                "kind": self.kind, "startTimeUnixNano": str(self.start_ns), "endTimeUnixNano": str(self.end_ns),
+                "attributes": attributes(self.attributes)}
+        if self.parent_id:
+            span["parentSpanId"] = self.parent_id
+        if self.error is not None:
+            span["status"] = {"code": 2, "message": self.error}
+        return span
+
+
+def attributes(values):
+    """Attributes in OTLP's JSON encoding, where integers are strings"""
+    encoded = []
+    for key, value in values.items():
+        if isinstance(value, bool):
+            value = {"boolValue": value}
+        elif isinstance(value, int):
+            value = {"intValue": str(value)}
+        elif isinstance(value, float):
+            value = {"doubleValue": value}
+        else:
+            value = {"stringValue": str(value)}
+        encoded.append({"key": key, "value": value})
+    return encoded
+
+
+class Exporter:
+    """Posts ended spans to the collector from a thread of its own. A
+    collector that cannot be reached costs those spans, not the service."""
+
+    def __init__(self, service, url):
+        self.service, self.url = service, url
+        self.queued, self.dropped = [], 0
+        self.lock, self.wake, self.stopped = threading.Lock(), threading.Event(), threading.Event()
+        self.thread = None
+        if url:
+            self.thread = threading.Thread(target=self.loop, name="tracing-exporter", daemon=True)
+            self.thread.start()
+
+    def add(self, span):
+        if not self.url:
+            return
+        with self.lock:
+            if len(self.queued) >= MAX_QUEUED:
+                self.dropped += 1
+                return
+            self.queued.append(span)
+            full = len(self.queued) >= BATCH_SIZE
+        if full:
+            self.wake.set()
+
+    def loop(self):
+        while not self.stopped.is_set():
+            self.wake.wait(FLUSH_INTERVAL)
+            self.wake.clear()
+            self.send()
+
+    def send(self):
+        while True:
+            with self.lock:
+                batch, self.queued = self.queued[:BATCH_SIZE], self.queued[BATCH_SIZE:]
+                dropped, self.dropped = self.dropped, 0
+            if dropped:
+                print(f"⚠️ Tracing: dropped {dropped} spans while the collector was behind")
+            if not batch:
+                return
+            try:
+                self.post(batch)
+            except (OSError, ValueError) as e:
+                print(f"⚠️ Tracing: could not export {len(batch)} spans: {e}")
+
+    def post(self, spans):
+        body = {"resourceSpans": [{
+            "resource": {"attributes": attributes({"service.name": self.service})},
+            "scopeSpans": [{"scope": {"name": "dynamic-context/tracing"}, "spans": [s.to_otlp() for s in spans]}],
+        }]}
+        request = urllib.request.Request(self.url, data=json.dumps(body).encode(), method="POST",
+                                         headers={"Content-Type": "application/json"})
+        with urllib.request.urlopen(request, timeout=10) as response:
+            response.read()
+
+    def shutdown(self):
+        """Sends the spans still queued"""
+        if not self.thread:
+            return
+        self.stopped.set()
+        self.wake.set()
+        self.thread.join(timeout=10)
+        self.send()
+
+
+class Tracer:
+    """Starts spans for one service"""
+
+    def __init__(self, service, url=None):
+        self.service = service
+        self.exporter = Exporter(service, url)
+
+    @classmethod
+    def from_env(cls, service):
+        service = os.getenv("OTEL_SERVICE_NAME") or service
+        url = os.getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+        if not url and os.getenv("OTEL_EXPORTER_OTLP_ENDPOINT"):
+            url = os.getenv("OTEL_EXPORTER_OTLP_ENDPOINT").rstrip("/") + "/v1/traces"
+        return cls(service, url)
+
+    @contextlib.contextmanager
+    def span(self, name, kind=KIND_INTERNAL, parent=None, attributes=None):
+        """Runs the with block in a span, the child of parent or else of the
+        current span, as the current span. An exception fails the span."""
+        span = Span(self, name, kind, parent or current())
+        for key, value in (attributes or {}).items():
+            span.set_attribute(key, value)
+        token = _current.set(span.context)
+        try:
+            yield span
+        except BaseException as e:
+            span.fail(str(e) or type(e).__name__)
+            raise
+        finally:
+            _current.reset(token)
+            span.end()
+
+    def __str__(self):
+        if not self.exporter.url:
+            return f"tracing {self.service}, not exported"
+        return f"tracing {self.service} to {self.exporter.url}"
+
+
+_tracer = None
+
+
+def init(service):
+    """Sets up the tracer for service, whose spans are sent on exit too"""
+    global _tracer
+    _tracer = Tracer.from_env(service)
+    atexit.register(_tracer.exporter.shutdown)
+    print(f"🔭 {_tracer.service}: {_tracer}")
+    return _tracer
+
+
+def tracer():
+    """The tracer init set up, or one that exports nothing"""
+    global _tracer
+    if _tracer is None:
+        _tracer = Tracer(os.getenv("OTEL_SERVICE_NAME") or "unknown_service")
+    return _tracer
+
+
+def span(name, kind=KIND_INTERNAL, parent=None, attributes=None):
+    """A span of the tracer init set up; see Tracer.span"""
+    return tracer().span(name, kind, parent, attributes)
+
+
+def install(app):
+    """Gives every request to a FastAPI app a server span, the child of its
+    traceparent. GET /health and GET /metrics are not traced, since probes
+    would bury the traces worth reading. Call it after the other
+    middleware, so requests they refuse are traced too."""
+
+    @app.middleware("http")
+    async def trace_requests(request, call_next):
+        path = request.url.path
+        if request.method == "GET" and path in ("/health", "/metrics"):
+            return await call_next(request)
+        with span(f"{request.method} {path}", KIND_SERVER, parse(request.headers.get(HEADER)),
+                  {"http.request.method": request.method, "url.path": path}) as server:
+            response = await call_next(request)
+            server.set_attribute("http.response.status_code", response.status_code)
+            if response.status_code >= 500:
+                server.fail(f"HTTP {response.status_code}")
+            return response
+`
+
+// tracingJs is the MCP server's side of packages/tracing.
+const tracingJs = `// Distributed tracing shared by the services of the dynamic context system:
+// the same spans, exporter and W3C traceparent as packages/tracing and
+// tracing.py. The current span follows each request and socket event
+// through the async calls made for it.
+const crypto = require('crypto');
+const http = require('http');
+const https = require('https');
+const { AsyncLocalStorage } = require('async_hooks');
+
+const HEADER = 'traceparent';
+const KIND = { INTERNAL: 1, SERVER: 2, CLIENT: 3, PRODUCER: 4, CONSUMER: 5 };
+
+// Spans are sent BATCH_SIZE at once, at the latest every FLUSH_INTERVAL
+// milliseconds; past MAX_QUEUED waiting for the collector, new ones are
+// dropped.
+const BATCH_SIZE = 512;
+const FLUSH_INTERVAL = 5000;
+const MAX_QUEUED = 4096;
+
+const storage = new AsyncLocalStorage();
+
+// The { traceId, spanId, sampled } of a W3C traceparent, or null for a
+// missing or invalid one. Versions after 00 are read as 00.
+function parse(value) {
+    const parts = String(value || '').trim().split('-');
+    if (parts.length < 4 || parts[0].length !== 2 || parts[0] === 'ff' || (parts[0] === '00' && parts.length !== 4)) {
+        return null;
+    }
+    const [version, traceId, spanId, flags] = parts;
+    if (!/^[0-9a-f]{2}$/i.test(version) || !/^[0-9a-f]{32}$/i.test(traceId) || !/^[0-9a-f]{16}$/i.test(spanId) ||
+        !/^[0-9a-f]{2}$/i.test(flags) || /^0+$/.test(traceId) || /^0+$/.test(spanId)) {
+        return null;
+    }
+    return { traceId: traceId.toLowerCase(), spanId: spanId.toLowerCase(), sampled: (parseInt(flags, 16) & 1) === 1 };
+}
+
+function format(context) {
+    return '00-' + context.traceId + '-' + context.spanId + '-' + (context.sampled ? '01' : '00');
+}
+
+// The current span's context, or null
+function current() {
+    return storage.getStore() || null;
+}
+
+// headers, with the traceparent of the current span, for calls to the
+// other services
+function headers(extra = {}) {
+    const context = current();
+    return context ? { ...extra, [HEADER]: format(context) } : { ...extra };
+}
+
+// Attributes in OTLP's JSON encoding, where integers are strings
+function attributes(values) {
+    return Object.entries(values).map(([key, value]) => {
+        if (typeof value === 'boolean') {
+            return { key, value: { boolValue: value } };
+        }
+        if (Number.isInteger(value)) {
+            return { key, value: { intValue: String(value) } };
+        }
+        if (typeof value === 'number') {
+            return { key, value: { doubleValue: value } };
+        }
+        return { key, value: { stringValue: String(value) } };
+    });
+}
+
+function nowNanos() {
+    return process.hrtime.bigint() - hrtimeAtStart + epochAtStart;
+}
+const hrtimeAtStart = process.hrtime.bigint();
+const epochAtStart = BigInt(Date.now()) * 1000000n;
+
+class Span {
+    constructor(tracer, name, kind, parent) {
+        this.tracer = tracer;
+        this.name = name;
+        this.kind = kind;
+        this.context = {
+            traceId: parent ? parent.traceId : crypto.randomBytes(16).toString('hex'),
+            spanId: crypto.randomBytes(8).toString('hex'),
+            sampled: parent ? parent.sampled : true
+        };
+        this.parentId = parent ? parent.spanId : null;
+        this.start = nowNanos();
+        this.endTime = null;
+        this.attributes = {};
+        this.error = null;
+    }
+
+    setAttribute(key, value) {
+        this.attributes[key] = value;
+    }
+
+    fail(message) {
+        this.error = String(message);
+    }
+
+    // Finishes the span; only the first call counts
+    end() {
+        if (this.endTime !== null) {
+            return;
+        }
+        this.endTime = nowNanos();
+        if (this.context.sampled) {
+            this.tracer.exporter.add(this);
+        }
+    }
+
+    toOtlp() {
+        const span = {
+            traceId: this.context.traceId,
+            spanId: this.context.spanId,
+            name: this.name,
+            kind: this.kind,
+            startTimeUnixNano: String(this.start),
+            endTimeUnixNano: String(this.endTime),
+            attributes: attributes(this.attributes)
+        };
+        if (this.parentId) {
+            span.parentSpanId = this.parentId;
+        }
+        if (this.error !== null) {
+            span.status = { code: 2, message: this.error };
+        }
+        return span;
+    }
+}
+
+// Posts ended spans to the collector. A collector that cannot be reached
+// costs those spans, not the service.
+class Exporter {
+    constructor(service, url) {
+        this.service = service;
+        this.url = url;
+        this.queued = [];
+        this.dropped = 0;
+        if (url) {
+            this.timer = setInterval(() => this.send(), FLUSH_INTERVAL);
+            this.timer.unref();
+        }
+    }
+
+    add(span) {
+        if (!this.url) {
+            return;
+        }
+        if (this.queued.length >= MAX_QUEUED) {
+            this.dropped += 1;
+            return;
+        }
+        this.queued.push(span);
+        if (this.queued.length >= BATCH_SIZE) {
+            this.send();
+        }
+    }
+
+    send() {
+        if (this.dropped > 0) {
+            console.log('⚠️ Tracing: dropped', this.dropped, 'spans while the collector was behind');
+            this.dropped = 0;
+        }
+        const posts = [];
+        while (this.queued.length > 0) {
+            const batch = this.queued.splice(0, BATCH_SIZE);
+            posts.push(this.post(batch).catch((error) => {
+                console.log('⚠️ Tracing: could not export', batch.length, 'spans:', error.message);
+            }));
+        }
+        return Promise.all(posts);
+    }
+
+    post(spans) {
+        const body = JSON.stringify({ resourceSpans: [{
+            resource: { attributes: attributes({ 'service.name': this.service }) },
+            scopeSpans: [{ scope: { name: 'dynamic-context/tracing' }, spans: spans.map((span) => span.toOtlp()) }]
+        }] });
+        const url = new URL(this.url);
+        const client = url.protocol === 'https:' ? https : http;
+        return new Promise((resolve, reject) => {
+            const request = client.request(url, {
+                method: 'POST',
+                timeout: 10000,
+                headers: { 'Content-Type': 'application/json', 'Content-Length': Buffer.byteLength(body) }
+            }, (response) => {
+                response.resume();
+                response.on('end', () => response.statusCode < 300 ? resolve() :
+                    reject(new Error('the collector answered ' + response.statusCode)));
+            });
+            request.on('timeout', () => request.destroy(new Error('timed out')));
+            request.on('error', reject);
+            request.end(body);
+        });
+    }
+
+    // Sends the spans still queued
+    shutdown() {
+        if (this.timer) {
+            clearInterval(this.timer);
+        }
+        return this.send();
+    }
+}
+
+class Tracer {
+    constructor(service, url) {
+        this.service = service;
+        this.exporter = new Exporter(service, url);
+    }
+
+    // The tracer for service, which OTEL_SERVICE_NAME overrides, exporting
+    // to OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or to /v1/traces under
+    // OTEL_EXPORTER_OTLP_ENDPOINT; with neither, trace context still passes
+    // through but nothing is exported
+    static fromEnv(service) {
+        let url = process.env.OTEL_EXPORTER_OTLP_TRACES_ENDPOINT;
+        if (!url && process.env.OTEL_EXPORTER_OTLP_ENDPOINT) {
+            url = process.env.OTEL_EXPORTER_OTLP_ENDPOINT.replace(/\/+$/, '') + '/v1/traces';
+        }
+        return new Tracer(process.env.OTEL_SERVICE_NAME || service, url);
+    }
+
+    // A span, the child of parent or else of the current span
+    startSpan(name, kind = KIND.INTERNAL, parent = current()) {
+        return new Span(this, name, kind, parent);
+    }
+
+    // Runs fn in a span, as the current span, and ends it when fn's promise
+    // settles; a rejection fails the span
+    async trace(name, kind, parent, fn) {
+        const span = this.startSpan(name, kind, parent || current());
+        try {
+            return await storage.run(span.context, () => fn(span));
+        } catch (error) {
+            span.fail(error.message);
+            throw error;
+        } finally {
+            span.end();
+        }
+    }
+
+    // Express middleware giving every request a server span, the child of
+    // its traceparent, which the rest of the request runs in. GET /health
+    // and GET /metrics are not traced.
+    middleware() {
+        return (req, res, next) => {
+            if (req.method === 'GET' && (req.path === '/health' || req.path === '/metrics')) {
+                return next();
+            }
+            const span = this.startSpan(req.method + ' ' + req.path, KIND.SERVER, parse(req.get(HEADER)));
+            span.setAttribute('http.request.method', req.method);
+            span.setAttribute('url.path', req.path);
+            res.on('finish', () => {
+                span.setAttribute('http.response.status_code', res.statusCode);
+                if (res.statusCode >= 500) {
+                    span.fail('HTTP ' + res.statusCode);
+                }
+                span.end();
+            });
+            storage.run(span.context, next);
+        };
+    }
+
+    toString() {
+        return this.exporter.url ? 'tracing ' + this.service + ' to ' + this.exporter.url : 'tracing ' + this.service + ', not exported';
+    }
+}
+
+module.exports = { HEADER, KIND, Tracer, parse, format, current, headers };
+`
@@ -0,0 +1,16 @@
+module github.com/jayp41/dynamic-context-mcp-system/packages/admin-console
+
+go 1.22
+
+require (
+	github.com/jayp41/dynamic-context-mcp-system/packages/logging v0.0.0
+	github.com/jayp41/dynamic-context-mcp-system/packages/rbac v0.0.0
+)
+
+require github.com/jayp41/dynamic-context-mcp-system/packages/tracing v0.0.0 // indirect
+
+replace (
+	github.com/jayp41/dynamic-context-mcp-system/packages/logging => ../logging
+	github.com/jayp41/dynamic-context-mcp-system/packages/rbac => ../rbac
+	github.com/jayp41/dynamic-context-mcp-system/packages/tracing => ../tracing
+)
@@ -0,0 +1,100 @@
+// Command admin-console serves a web console for the people who run the
+// dynamic context system: it browses the knowledge graph, inspects sessions
+// and their summaries, manages the MCP server's tools and shows the
+// orchestrator's agent runs. Everyone signs in with a token of the shared
+// access control, which the console checks against the policy and passes on
+// to the services. See README.md.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/logging"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/rbac"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("admin-console: %v", err)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := logging.FromEnv("admin-console")
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		logger.Shutdown(shutdownCtx)
+	}()
+
+	// Unlike the services, the console never runs open: it can do whatever
+	// its users' roles can, on every service
+	authorizers := map[string]*rbac.Authorizer{}
+	for _, service := range []string{"graph", "memory", "mcp", "orchestrator"} {
+		authorizer, err := rbac.FromEnv(service)
+		if err != nil {
+			return err
+		}
+		if authorizer == nil {
+			return errors.New("the console needs access control; set RBAC_SECRET and RBAC_POLICY")
+		}
+		authorizers[service] = authorizer
+	}
+
+	upstreams := map[string]*url.URL{}
+	for service, key := range upstreamVariables {
+		raw := os.Getenv(key)
+		if raw == "" {
+			continue
+		}
+		parsed, err := url.Parse(raw)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("invalid %s: %q", key, raw)
+		}
+		upstreams[service] = parsed
+	}
+
+	s := newServer([]byte(os.Getenv("RBAC_SECRET")), authorizers, upstreams)
+	httpServer := &http.Server{
+		Addr:              ":" + getenv("CONSOLE_PORT", "8040"),
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("admin console listening on %s (%d of %d services, %s)", httpServer.Addr, len(upstreams), len(upstreamVariables), logger)
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return httpServer.Shutdown(shutdownCtx)
+	}
+	return nil
+}
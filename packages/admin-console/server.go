@@ -0,0 +1,251 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/rbac"
+)
+
+//go:embed ui
+var ui embed.FS
+
+// upstreamVariables are the services the console calls, and the variables
+// with their URLs, the same ones the control plane sets.
+var upstreamVariables = map[string]string{
+	"graph":        "KNOWLEDGE_GRAPH_URL",
+	"memory":       "SESSION_MEMORY_URL",
+	"mcp":          "MCP_SERVER_URL",
+	"orchestrator": "ORCHESTRATOR_URL",
+}
+
+// versioned are the services the console calls under /v1, so it is not
+// served the deprecated paths without a version.
+var versioned = map[string]bool{"graph": true, "memory": true, "mcp": true}
+
+// tokenCookie holds the token a browser signed in with.
+const tokenCookie = "console_token"
+
+// maxSignIn caps how long a sign in lasts, even with a token that lasts
+// longer or does not expire.
+const maxSignIn = 12 * time.Hour
+
+// consoleHeader must be on every request that changes something and is
+// signed in by the cookie. A form on another site cannot set it, so a
+// cookie the browser sends along does not let it act for the user.
+const consoleHeader = "X-Console"
+
+// server serves the console's page and passes its calls on to the services.
+type server struct {
+	secret      []byte
+	authorizers map[string]*rbac.Authorizer
+	proxies     map[string]*httputil.ReverseProxy
+	now         func() time.Time
+}
+
+func newServer(secret []byte, authorizers map[string]*rbac.Authorizer, upstreams map[string]*url.URL) *server {
+	s := &server{secret: secret, authorizers: authorizers, proxies: map[string]*httputil.ReverseProxy{}, now: time.Now}
+	for service, upstream := range upstreams {
+		prefix := strings.TrimRight(upstream.Path, "/")
+		if versioned[service] {
+			prefix += "/v1"
+		}
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(upstream)
+				r.Out.URL.Path = prefix + "/" + r.In.PathValue("path")
+				r.Out.URL.RawPath = ""
+				// The services see the bearer's own token, never the
+				// cookie it came in
+				r.Out.Header.Del("Cookie")
+				r.Out.Header.Del(consoleHeader)
+				r.Out.Header.Set("Authorization", "Bearer "+tokenOf(r.In))
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				writeError(w, http.StatusBadGateway, err.Error())
+			},
+		}
+		s.proxies[service] = proxy
+	}
+	return s
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", s.health)
+	mux.HandleFunc("POST /login", s.login)
+	mux.HandleFunc("POST /logout", s.logout)
+	mux.HandleFunc("GET /api/me", s.me)
+	mux.HandleFunc("/api/{service}/{path...}", s.proxy)
+	assets, _ := fs.Sub(ui, "ui")
+	mux.Handle("/", http.FileServerFS(assets))
+	return secureHeaders(mux)
+}
+
+// secureHeaders keeps the console's page from running scripts or loading
+// anything from elsewhere, and from being framed by another site.
+func secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]any{"detail": detail})
+}
+
+func (s *server) health(w http.ResponseWriter, r *http.Request) {
+	services := map[string]bool{}
+	for service := range upstreamVariables {
+		services[service] = s.proxies[service] != nil
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "healthy", "services": services})
+}
+
+// tokenOf is the bearer token of a request, or else the one it signed in
+// with.
+func tokenOf(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if cookie, err := r.Cookie(tokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// signedIn are the claims a browser sees of the token it signed in with.
+type signedIn struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+	Tenant  string `json:"tenant,omitempty"`
+	Expires string `json:"expires"`
+}
+
+// login checks a token and keeps it in a cookie the page's scripts cannot
+// read.
+func (s *server) login(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		writeError(w, http.StatusUnprocessableEntity, "the body must be {\"token\": ...}")
+		return
+	}
+	now := s.now()
+	claims, err := rbac.Verify(s.secret, body.Token, now)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	expires := now.Add(maxSignIn)
+	if claims.Expires != 0 && time.Unix(claims.Expires, 0).Before(expires) {
+		expires = time.Unix(claims.Expires, 0)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookie,
+		Value:    body.Token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	log.Printf("console: %s (%s) signed in", claims.Subject, claims.Role)
+	writeJSON(w, http.StatusOK, signedIn{claims.Subject, claims.Role, claims.Tenant, expires.UTC().Format(time.RFC3339)})
+}
+
+func (s *server) logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: tokenCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true,
+		SameSite: http.SameSiteStrictMode})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// me is who the browser signed in as, or 401, when the page loads.
+func (s *server) me(w http.ResponseWriter, r *http.Request) {
+	claims, err := rbac.Verify(s.secret, tokenOf(r), s.now())
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	expires := ""
+	if claims.Expires != 0 {
+		expires = time.Unix(claims.Expires, 0).UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusOK, signedIn{claims.Subject, claims.Role, claims.Tenant, expires})
+}
+
+// proxy passes a call on to a service as the signed in bearer. The console
+// checks the call against the policy first, as the service would, since
+// the service may run without access control on a private network. The
+// orchestrator has none of its own, so the console only reads its jobs and
+// runs, and only for tokens that are not bound to a tenant: they are every
+// tenant's.
+func (s *server) proxy(w http.ResponseWriter, r *http.Request) {
+	service := r.PathValue("service")
+	authorizer, known := s.authorizers[service]
+	if !known {
+		writeError(w, http.StatusNotFound, "no such service")
+		return
+	}
+	proxy := s.proxies[service]
+	if proxy == nil {
+		writeError(w, http.StatusServiceUnavailable, service+" is not configured; set "+upstreamVariables[service])
+		return
+	}
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if !safe && r.Header.Get("Authorization") == "" && r.Header.Get(consoleHeader) == "" {
+		writeError(w, http.StatusForbidden, "requests that change something must carry "+consoleHeader)
+		return
+	}
+
+	// The policy's routes are the service's own, without /api/{service}
+	check := r.Clone(r.Context())
+	check.URL.Path = "/" + r.PathValue("path")
+	check.Header.Set("Authorization", "Bearer "+tokenOf(r))
+	claims, err := authorizer.Authorize(check)
+	if err != nil {
+		var refused *rbac.Error
+		errors.As(err, &refused)
+		if refused.Status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		} else {
+			log.Printf("console: refused %s %s on %s to %s (%s)", r.Method, check.URL.Path, service, claims.Subject, claims.Role)
+		}
+		writeError(w, refused.Status, err.Error())
+		return
+	}
+	if _, err := rbac.ResolveTenant(r, claims); err != nil {
+		writeError(w, err.(*rbac.Error).Status, err.Error())
+		return
+	}
+	if service == "orchestrator" {
+		switch {
+		case !safe:
+			writeError(w, http.StatusMethodNotAllowed, "the console only reads the orchestrator's jobs and runs")
+			return
+		case claims.Tenant != "":
+			writeError(w, http.StatusForbidden, "the orchestrator's jobs and runs are every tenant's; a token bound to a tenant may not read them")
+			return
+		}
+	}
+	proxy.ServeHTTP(w, r)
+}
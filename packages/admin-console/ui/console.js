@@ -0,0 +1,316 @@
+// The admin console's page. Every call goes to the console's own
+// /api/{service}/..., which checks it against the access control policy
+// and passes it on with the token the browser signed in with.
+"use strict";
+
+const $ = id => document.getElementById(id);
+
+function showError(message) {
+  $("error").textContent = message || "";
+  $("error").hidden = !message;
+}
+
+// api calls a service through the console, as the tenant in the header,
+// and answers the JSON it gets or throws its reason
+async function api(service, path, options = {}) {
+  const headers = { "X-Console": "1" };
+  const tenant = $("tenant").value.trim();
+  if (tenant) headers["X-Tenant-ID"] = tenant;
+  if (options.body !== undefined) headers["Content-Type"] = "application/json";
+  const response = await fetch("/api/" + service + "/" + path, {
+    method: options.method || "GET",
+    headers,
+    body: options.body === undefined ? undefined : JSON.stringify(options.body),
+    credentials: "same-origin"
+  });
+  const text = await response.text();
+  let body = null;
+  try { body = text ? JSON.parse(text) : null; } catch (e) { body = text; }
+  if (response.status === 401) {
+    signedOut();
+  }
+  if (!response.ok) {
+    const reason = body && (typeof body.detail === "string" ? body.detail : body.error);
+    throw new Error(service + ": " + response.status + " " + (reason || text));
+  }
+  return body;
+}
+
+// table fills a table with a row per item, the text of each column from
+// its function; clicking a row calls onClick with the item
+function table(element, columns, items, onClick) {
+  element.replaceChildren();
+  const head = element.insertRow();
+  for (const [title] of columns) {
+    const th = document.createElement("th");
+    th.textContent = title;
+    head.appendChild(th);
+  }
+  for (const item of items) {
+    const row = element.insertRow();
+    for (const [, value] of columns) {
+      const cell = value(item);
+      if (cell instanceof Node) {
+        row.insertCell().appendChild(cell);
+      } else {
+        row.insertCell().textContent = cell === undefined || cell === null ? "" : String(cell);
+      }
+    }
+    if (onClick) {
+      row.className = "link";
+      row.addEventListener("click", () => onClick(item).catch(e => showError(e.message)));
+    }
+  }
+  if (!items.length) {
+    const cell = element.insertRow().insertCell();
+    cell.colSpan = columns.length;
+    cell.className = "muted";
+    cell.textContent = "Nothing here";
+  }
+}
+
+function pretty(value) {
+  return JSON.stringify(value, null, 2);
+}
+
+function shorten(text, n) {
+  text = String(text || "").replace(/\s+/g, " ").trim();
+  return text.length > n ? text.slice(0, n - 1) + "…" : text;
+}
+
+function time(value) {
+  return value ? new Date(value).toLocaleString() : "";
+}
+
+// Graph
+
+function graphPath(path) {
+  const graph = $("graph-id").value;
+  return graph && graph !== "default" ? "graphs/" + encodeURIComponent(graph) + "/" + path : path;
+}
+
+async function loadGraph() {
+  const listing = await api("graph", "graphs");
+  const select = $("graph-id");
+  const selected = select.value || "default";
+  select.replaceChildren();
+  for (const graph of listing.graphs) {
+    const option = document.createElement("option");
+    option.value = graph.graph_id;
+    option.textContent = graph.graph_id + " (" + graph.nodes + " nodes, " + graph.edges + " edges)";
+    option.selected = graph.graph_id === selected;
+    select.appendChild(option);
+  }
+  const stats = await api("graph", graphPath("stats"));
+  $("graph-stats").textContent = stats.nodes + " nodes, " + stats.edges + " edges, " + stats.components + " components";
+}
+
+async function searchGraph() {
+  const query = new URLSearchParams({ q: $("graph-q").value, mode: $("graph-mode").value, limit: "50" });
+  const found = await api("graph", graphPath("search?" + query));
+  table($("graph-results"), [
+    ["Node", n => n.node_id],
+    ["Type", n => n.data && n.data.type],
+    ["Score", n => (n.score ?? n.bm25 ?? "") === "" ? "" : Number(n.score ?? n.bm25).toFixed(4)],
+    ["Content", n => shorten(n.data && n.data.content, 80)]
+  ], found.results, async node => {
+    $("graph-node").textContent = pretty(await api("graph", graphPath("nodes/" + encodeURIComponent(node.node_id))));
+  });
+}
+
+// Sessions
+
+async function loadMemory() {
+  const stats = await api("memory", "stats?top=5");
+  if (stats.health && stats.health !== "healthy") {
+    $("memory-stats").textContent = "session memory is " + stats.health;
+    return;
+  }
+  $("memory-stats").textContent = stats.active_sessions + " sessions, " + stats.pinned_sessions + " pinned, " +
+    (stats.memory_usage && stats.memory_usage.used_memory_human ? stats.memory_usage.used_memory_human + " used" : "");
+}
+
+async function searchSessions() {
+  const query = new URLSearchParams({ q: $("session-q").value, limit: "50" });
+  const attr = $("session-attr").value.trim();
+  if (attr) query.append("attr", attr);
+  const found = await api("memory", "sessions/search?" + query);
+  table($("session-results"), [
+    ["Session", s => s.session_id],
+    ["Stored", s => time(s.stored_at)],
+    ["Key points", s => (s.key_points || []).length]
+  ], found.results, session => showSession(session.session_id));
+}
+
+async function showSession(sessionID) {
+  const bundle = await api("memory", "sessions/" + encodeURIComponent(sessionID) + "/export");
+  $("session-detail").hidden = false;
+  $("session-id").textContent = sessionID;
+  $("summarize").dataset.session = sessionID;
+  $("session-summary").textContent = bundle.summary ? pretty(bundle.summary) : "Not summarized yet";
+  $("session-context").textContent = pretty(bundle.context);
+  table($("session-history"), [
+    ["Stored", h => time(h.stored_at)],
+    ["Entry", h => shorten(JSON.stringify(h), 120)]
+  ], bundle.history || []);
+  $("session-nodes").textContent = (bundle.graph_nodes || []).join("\n") + (bundle.graph_error ? "\n(" + bundle.graph_error + ")" : "");
+}
+
+// Tools
+
+async function loadTools() {
+  const listing = await api("mcp", "tools");
+  table($("tool-list"), [
+    ["Tool", t => t.name],
+    ["Endpoint", t => t.endpoint],
+    ["Config", t => t.config ? JSON.stringify(t.config) : ""],
+    ["", t => {
+      const remove = document.createElement("button");
+      remove.textContent = "Remove";
+      remove.addEventListener("click", () => removeTool(t.name).catch(e => showError(e.message)));
+      return remove;
+    }]
+  ], listing.tools);
+}
+
+async function removeTool(name) {
+  if (!confirm("Remove the tool " + name + "?")) return;
+  await api("mcp", "tools/" + encodeURIComponent(name), { method: "DELETE" });
+  await loadTools();
+}
+
+async function registerTool() {
+  const body = { name: $("tool-name").value.trim(), endpoint: $("tool-endpoint").value.trim() };
+  const config = $("tool-config").value.trim();
+  if (config) {
+    try { body.config = JSON.parse(config); } catch (e) { throw new Error("The config is not JSON: " + e.message); }
+  }
+  await api("mcp", "tools/register", { method: "POST", body });
+  $("tool-form").reset();
+  await loadTools();
+}
+
+// Agent runs
+
+async function loadRuns() {
+  const stats = await api("orchestrator", "runs/stats");
+  table($("run-stats"), [
+    ["Agent", a => a.agent_type],
+    ["Runs", a => a.runs],
+    ["Failed", a => a.failed],
+    ["Failure rate", a => (100 * a.failure_rate).toFixed(1) + "%"],
+    ["p50 s", a => a.p50_duration_seconds.toFixed(2)],
+    ["p95 s", a => a.p95_duration_seconds.toFixed(2)],
+    ["Items", a => a.items],
+    ["Tokens", a => a.llm_tokens]
+  ], stats.agents);
+
+  const query = new URLSearchParams({ limit: "100" });
+  if ($("run-agent").value.trim()) query.set("agent_type", $("run-agent").value.trim());
+  if ($("run-status").value) query.set("status", $("run-status").value);
+  const runs = await api("orchestrator", "runs?" + query);
+  table($("run-list"), [
+    ["Finished", r => time(r.finished_at)],
+    ["Job", r => r.job_id],
+    ["Agent", r => r.agent_type],
+    ["Target", r => shorten(r.target, 50)],
+    ["Tenant", r => r.tenant],
+    ["Attempt", r => r.attempt],
+    ["Status", r => r.status],
+    ["Seconds", r => r.duration_seconds.toFixed(2)],
+    ["Items", r => r.items],
+    ["Error", r => shorten(r.error, 80)]
+  ], runs.runs);
+
+  const jobs = [];
+  for (const status of ["queued", "running", "retrying"]) {
+    jobs.push(...(await api("orchestrator", "jobs?status=" + status)).jobs);
+  }
+  table($("job-list"), [
+    ["Job", j => j.id],
+    ["Agent", j => j.agent_type],
+    ["Target", j => shorten(j.target, 50)],
+    ["Tenant", j => j.tenant],
+    ["Status", j => j.status],
+    ["Attempts", j => j.attempts],
+    ["Created", j => time(j.created_at)]
+  ], jobs);
+}
+
+// Tabs and signing in
+
+const loaders = { graph: loadGraph, sessions: loadMemory, tools: loadTools, runs: loadRuns };
+
+function showTab(name) {
+  showError("");
+  for (const button of document.querySelectorAll("#tabs button")) {
+    button.classList.toggle("active", button.dataset.tab === name);
+  }
+  for (const section of document.querySelectorAll("section.tab")) {
+    section.hidden = section.id !== name;
+  }
+  loaders[name]().catch(e => showError(e.message));
+}
+
+function signedIn(bearer) {
+  $("login").hidden = true;
+  $("tabs").hidden = false;
+  $("who").hidden = false;
+  $("bearer").textContent = bearer.subject + " (" + bearer.role + ")";
+  if (bearer.tenant) {
+    $("tenant").value = bearer.tenant;
+    $("tenant").disabled = true;
+  }
+  showTab("graph");
+}
+
+function signedOut() {
+  $("login").hidden = false;
+  $("tabs").hidden = true;
+  $("who").hidden = true;
+  $("tenant").disabled = false;
+  for (const section of document.querySelectorAll("section.tab")) {
+    section.hidden = true;
+  }
+}
+
+function on(id, event, handler) {
+  $(id).addEventListener(event, e => {
+    e.preventDefault();
+    showError("");
+    handler(e).catch(err => showError(err.message));
+  });
+}
+
+on("login-form", "submit", async () => {
+  const response = await fetch("/login", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token: $("token").value.trim() })
+  });
+  const body = await response.json();
+  if (!response.ok) throw new Error("Not signed in: " + body.detail);
+  $("token").value = "";
+  signedIn(body);
+});
+on("logout", "click", async () => {
+  await fetch("/logout", { method: "POST" });
+  signedOut();
+});
+on("graph-search", "submit", searchGraph);
+on("graph-id", "change", loadGraph);
+on("session-search", "submit", searchSessions);
+on("summarize", "click", async e => {
+  const session = e.target.dataset.session;
+  await api("memory", "sessions/" + encodeURIComponent(session) + "/summary", { method: "POST" });
+  await showSession(session);
+});
+on("tool-form", "submit", registerTool);
+on("run-filter", "submit", loadRuns);
+for (const button of document.querySelectorAll("#tabs button")) {
+  button.addEventListener("click", () => showTab(button.dataset.tab));
+}
+
+fetch("/api/me", { credentials: "same-origin" })
+  .then(response => response.ok ? response.json().then(signedIn) : signedOut())
+  .catch(() => signedOut());
@@ -0,0 +1,253 @@
+package agentsdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Exit statuses, which the orchestrator reports with the tail of stderr.
+const (
+	ExitOK     = 0
+	ExitFailed = 1
+	// ExitUsage is for a missing target, a bad option or a missing
+	// dependency: a problem with how the agent was run, not with the target.
+	ExitUsage = 2
+)
+
+// ErrUsage marks errors that exit with ExitUsage.
+var ErrUsage = errors.New("usage")
+
+// Emit streams a batch of items for a context field. It never fails: when
+// the MCP server cannot be reached the agent carries on without streaming.
+type Emit func(field string, items ...any)
+
+// Gather finds context about target. It can stream items as it goes, and
+// returns the context for the final result.
+type Gather func(ctx context.Context, target string, emit Emit) (map[string]any, error)
+
+// Env is what the orchestrator tells an agent through its environment.
+type Env struct {
+	// JobID is AGENT_JOB_ID, which names the stream; empty outside the
+	// orchestrator.
+	JobID string
+	// SessionID is AGENT_SESSION_ID, the session streamed items are stored
+	// under.
+	SessionID string
+	// ServerURL is MCP_SERVER_URL; empty turns streaming off.
+	ServerURL string
+	// Token is RBAC_TOKEN, which the orchestrator gives agents when the
+	// services enforce access control.
+	Token string
+	// Tenant is AGENT_TENANT, the tenant the job runs for.
+	Tenant string
+	// Traceparent is TRACEPARENT, the orchestrator's span for this run of
+	// the job.
+	Traceparent string
+	// Flags are AGENT_FLAGS, the feature flags on for the tenant.
+	Flags []string
+}
+
+// Flag reports whether a feature flag is on for the job's tenant.
+func (e Env) Flag(name string) bool {
+	return slices.Contains(e.Flags, name)
+}
+
+func EnvFromOS() Env {
+	return Env{
+		JobID:       os.Getenv("AGENT_JOB_ID"),
+		SessionID:   os.Getenv("AGENT_SESSION_ID"),
+		ServerURL:   os.Getenv("MCP_SERVER_URL"),
+		Token:       os.Getenv("RBAC_TOKEN"),
+		Tenant:      os.Getenv("AGENT_TENANT"),
+		Traceparent: os.Getenv("TRACEPARENT"),
+		Flags:       strings.FieldsFunc(os.Getenv("AGENT_FLAGS"), func(r rune) bool { return r == ',' }),
+	}
+}
+
+// Logf prints a progress line. Progress goes to stdout ahead of the
+// result, so it must not start with "{"; the repo's agents start theirs
+// with an emoji.
+func Logf(format string, args ...any) {
+	fmt.Printf(format+"\n", args...)
+}
+
+// ReadInput reads the PipelineInput on stdin when the agent runs as a
+// pipeline step. It returns nil when there is none: stdin is a terminal or
+// empty, as it is for agents run any other way.
+func ReadInput() (*PipelineInput, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice != 0 {
+		return nil, nil
+	}
+	raw, err := io.ReadAll(os.Stdin)
+	if err != nil || len(bytes.TrimSpace(raw)) == 0 {
+		return nil, err
+	}
+	var input PipelineInput
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return nil, fmt.Errorf("pipeline input: %w", err)
+	}
+	return &input, nil
+}
+
+// Usage is what some of an agent's work used: external API calls and LLM
+// tokens, and what they cost when the agent knows.
+type Usage struct {
+	APICalls     int
+	InputTokens  int
+	OutputTokens int
+	// Cost is nil when the agent leaves the orchestrator to price the run.
+	Cost *float64
+}
+
+var (
+	usageMu sync.Mutex
+	usage   Usage
+)
+
+// RecordUsage counts u toward the run's metrics, which the orchestrator
+// uses for cost reports and budgets. It is safe to call from several
+// goroutines.
+func RecordUsage(u Usage) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	usage.APICalls += u.APICalls
+	usage.InputTokens += u.InputTokens
+	usage.OutputTokens += u.OutputTokens
+	if u.Cost != nil {
+		cost := *u.Cost
+		if usage.Cost != nil {
+			cost += *usage.Cost
+		}
+		usage.Cost = &cost
+	}
+}
+
+// metrics are the run's metrics, or nil when it has none to report.
+func (s *Stream) metrics() *Metrics {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	m := Metrics{Items: s.sent, APICalls: usage.APICalls, Cost: usage.Cost}
+	if usage.InputTokens > 0 || usage.OutputTokens > 0 {
+		m.LLMTokens = &LLMTokens{Input: usage.InputTokens, Output: usage.OutputTokens}
+	}
+	if s.seq == 0 && m.APICalls == 0 && m.LLMTokens == nil && m.Cost == nil {
+		return nil
+	}
+	return &m
+}
+
+// Stream sends an agent's items to the MCP server as it finds them.
+type Stream struct {
+	ID        string
+	SessionID string
+	AgentType string
+	Target    string
+
+	client  *Client
+	seq     int
+	sent    int
+	stopped bool
+}
+
+// NewStream streams to env's MCP server, or nowhere when it has none. The
+// stream ID is the job's, or a random one when the agent runs on its own.
+func NewStream(env Env, agentType, target string) *Stream {
+	s := &Stream{ID: env.JobID, SessionID: env.SessionID, AgentType: agentType, Target: target}
+	if s.ID == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		s.ID = hex.EncodeToString(b)
+	}
+	if env.ServerURL != "" {
+		s.client = NewClient(env.ServerURL)
+		s.client.Token = env.Token
+		s.client.Tenant = env.Tenant
+		s.client.Traceparent = env.Traceparent
+		Logf("📡 Streaming context to %s", env.ServerURL)
+	}
+	return s
+}
+
+// Emit sends one batch. After a batch fails for good, streaming stops for
+// the rest of the run, and the final result still carries everything.
+func (s *Stream) Emit(ctx context.Context, field string, items ...any) {
+	if len(items) == 0 {
+		return
+	}
+	s.seq++
+	s.sent += len(items)
+	if s.client == nil || s.stopped {
+		return
+	}
+	batch := ItemBatch{StreamID: s.ID, SessionID: s.SessionID, AgentType: s.AgentType, Target: s.Target,
+		Field: field, Items: items, Seq: s.seq}
+	if err := s.client.SendItems(ctx, batch); err != nil {
+		Logf("⚠️ Not streaming context any more: %v", err)
+		s.stopped = true
+	}
+}
+
+// Close ends the stream with a status.
+func (s *Stream) Close(ctx context.Context, status string) {
+	if s.client == nil || s.stopped {
+		return
+	}
+	done := StreamDone{StreamID: s.ID, SessionID: s.SessionID, Status: status, Items: s.sent, Batches: s.seq}
+	if err := s.client.SendDone(ctx, done); err != nil {
+		Logf("⚠️ Could not end the context stream: %v", err)
+	}
+}
+
+// Run is an agent's main: it takes the target from the command line,
+// gathers context with streaming set up from the environment, prints the
+// result and exits with the status the orchestrator expects.
+func Run(agentType string, gather Gather) {
+	os.Exit(run(agentType, gather, os.Args[1:], EnvFromOS()))
+}
+
+func run(agentType string, gather Gather, args []string, env Env) int {
+	if len(args) != 1 || args[0] == "" {
+		fmt.Fprintf(os.Stderr, "❌ usage: %s TARGET\n", agentType)
+		return ExitUsage
+	}
+	target := args[0]
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	Logf("🔍 Gathering context for: %s", target)
+	stream := NewStream(env, agentType, target)
+	found, err := gather(ctx, target, func(field string, items ...any) { stream.Emit(ctx, field, items...) })
+	if err != nil {
+		stream.Close(ctx, StatusFailed)
+		fmt.Fprintf(os.Stderr, "❌ Could not gather context for %s: %v\n", target, err)
+		if errors.Is(err, ErrUsage) {
+			return ExitUsage
+		}
+		return ExitFailed
+	}
+	stream.Close(ctx, StatusSucceeded)
+
+	result := NewResult(agentType, target, found)
+	result.Metrics = stream.metrics()
+	output, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Could not encode the result: %v\n", err)
+		return ExitFailed
+	}
+	Logf("✅ Context gathered successfully!")
+	fmt.Println(string(output))
+	return ExitOK
+}
@@ -0,0 +1,125 @@
+package agentsdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client submits items, stream ends and results to the MCP server. Failed
+// requests are retried with exponential backoff when the server could not
+// be reached or answered 429 or 5xx; other answers are not retried.
+type Client struct {
+	URL string
+	// Token is sent as the bearer token, for an MCP server that enforces
+	// access control; empty sends none.
+	Token string
+	// Tenant is sent as X-Tenant-ID, for the MCP server to keep the items
+	// with the tenant's; empty sends none, which is the default tenant.
+	Tenant string
+	// Traceparent is sent as the W3C traceparent header, so the MCP server
+	// traces the requests under the job; empty sends none.
+	Traceparent string
+	// Retries is how many times a request is retried after the first try.
+	Retries int
+	// Backoff is the wait before the first retry, doubling for each one
+	// after, with up to half again added at random.
+	Backoff time.Duration
+	HTTP    *http.Client
+}
+
+// NewClient returns a client for the MCP server at url with three retries
+// starting at half a second.
+func NewClient(url string) *Client {
+	return &Client{
+		URL:     strings.TrimRight(url, "/"),
+		Retries: 3,
+		Backoff: 500 * time.Millisecond,
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// StatusError is an answer from the MCP server other than 2xx.
+type StatusError struct {
+	Status int
+	Detail string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("MCP server answered %d: %s", e.Status, e.Detail)
+}
+
+func (e *StatusError) retryable() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// SendItems posts a batch to /agents/items.
+func (c *Client) SendItems(ctx context.Context, batch ItemBatch) error {
+	return c.post(ctx, "/agents/items", batch)
+}
+
+// SendDone posts the end of a stream to /agents/done.
+func (c *Client) SendDone(ctx context.Context, done StreamDone) error {
+	return c.post(ctx, "/agents/done", done)
+}
+
+// SubmitResult posts a finished job to /agents/results. Agents the
+// orchestrator launches must not call it: the orchestrator reports their
+// jobs itself.
+func (c *Client) SubmitResult(ctx context.Context, job Job) error {
+	return c.post(ctx, "/agents/results", job)
+}
+
+func (c *Client) post(ctx context.Context, path string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	wait := c.Backoff
+	for attempt := 0; ; attempt++ {
+		err = c.try(ctx, path, body)
+		status, ok := err.(*StatusError)
+		if err == nil || (ok && !status.retryable()) || attempt >= c.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait + time.Duration(rand.Int64N(int64(wait/2)+1))):
+		}
+		wait *= 2
+	}
+}
+
+func (c *Client) try(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.Tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.Tenant)
+	}
+	if c.Traceparent != "" {
+		req.Header.Set("traceparent", c.Traceparent)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{Status: resp.StatusCode, Detail: strings.TrimSpace(string(detail))}
+	}
+	return nil
+}
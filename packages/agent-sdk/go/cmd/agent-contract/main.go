@@ -0,0 +1,18 @@
+// agent-contract writes the Go SDK's contract with the MCP server to
+// stdout, for the pipeline to verify against the MCP server.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	agentsdk "github.com/jayp41/dynamic-context-mcp-system/packages/agent-sdk/go"
+)
+
+func main() {
+	if err := agentsdk.WriteContract(context.Background(), os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "agent-contract:", err)
+		os.Exit(1)
+	}
+}
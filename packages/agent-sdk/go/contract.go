@@ -0,0 +1,128 @@
+package agentsdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+)
+
+// ContractConsumer names this SDK in the contracts it writes.
+const ContractConsumer = "agent-sdk-go"
+
+// A contract is what the SDK expects of the MCP server, in the shape of a
+// Pact file: each request its client makes, recorded as the client makes
+// it, and the answer the SDK relies on. A response body is matched by
+// type: every field it has must be there, of the same JSON type.
+type contract struct {
+	Consumer     contractParty         `json:"consumer"`
+	Provider     contractParty         `json:"provider"`
+	Interactions []contractInteraction `json:"interactions"`
+}
+
+type contractParty struct {
+	Name string `json:"name"`
+}
+
+type contractInteraction struct {
+	Description string           `json:"description"`
+	Request     contractRequest  `json:"request"`
+	Response    contractResponse `json:"response"`
+}
+
+type contractRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    any               `json:"body"`
+}
+
+type contractResponse struct {
+	Status int            `json:"status"`
+	Body   map[string]any `json:"body"`
+}
+
+// contractHeaders are the headers the MCP server reads from agents.
+var contractHeaders = []string{"Content-Type", "X-Tenant-ID", "traceparent"}
+
+// WriteContract records the requests the client makes for each thing an
+// agent sends the MCP server, and writes them to w as the SDK's contract
+// with it. The pipeline verifies the contract against the MCP server.
+func WriteContract(ctx context.Context, w io.Writer) error {
+	stream := "contract-" + ContractConsumer
+	node := map[string]any{"data": map[string]any{"type": "contract", "content": "A node an agent found"}}
+	result := NewResult("contract_agent", "contract-target", map[string]any{"nodes": []any{node}})
+	result.Metrics = &Metrics{Items: 1, APICalls: 1, LLMTokens: &LLMTokens{Input: 10, Output: 5}}
+
+	cases := []struct {
+		description string
+		status      int
+		body        map[string]any
+		send        func(*Client) error
+	}{
+		{"a batch of streamed nodes", http.StatusAccepted, map[string]any{"stream_id": stream, "seq": 1}, func(c *Client) error {
+			return c.SendItems(ctx, ItemBatch{StreamID: stream, SessionID: stream, AgentType: "contract_agent",
+				Target: "contract-target", Field: "nodes", Items: []any{node}, Seq: 1})
+		}},
+		{"a batch of nodes without their data", http.StatusUnprocessableEntity, map[string]any{"error": "", "violations": []any{}}, func(c *Client) error {
+			return c.SendItems(ctx, ItemBatch{StreamID: stream, SessionID: stream, AgentType: "contract_agent",
+				Target: "contract-target", Field: "nodes", Items: []any{map[string]any{"content": "A node without its data"}}, Seq: 2})
+		}},
+		{"the end of a stream", http.StatusOK, map[string]any{"stream_id": stream}, func(c *Client) error {
+			return c.SendDone(ctx, StreamDone{StreamID: stream, SessionID: stream, Status: StatusSucceeded, Items: 1, Batches: 1})
+		}},
+		{"a job that succeeded", http.StatusOK, map[string]any{"id": stream}, func(c *Client) error {
+			return c.SubmitResult(ctx, Job{ID: stream, AgentType: "contract_agent", Target: "contract-target",
+				Status: StatusSucceeded, Result: &result})
+		}},
+		{"a job that failed", http.StatusOK, map[string]any{"id": stream + "-failed"}, func(c *Client) error {
+			return c.SubmitResult(ctx, Job{ID: stream + "-failed", AgentType: "contract_agent", Target: "contract-target",
+				Status: StatusFailed, Error: "gathering failed"})
+		}},
+	}
+
+	// The recorder answers each request as the case expects the MCP server
+	// to, so the client goes on as it would
+	requests := make(chan contractRequest, 1)
+	answers := make(chan int, 1)
+	recorder := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		request := contractRequest{Method: r.Method, Path: r.URL.Path, Headers: map[string]string{}}
+		for _, name := range contractHeaders {
+			if value := r.Header.Get(name); value != "" {
+				request.Headers[name] = value
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request.Body); err != nil {
+			request.Body = nil
+		}
+		requests <- request
+		rw.WriteHeader(<-answers)
+	}))
+	defer recorder.Close()
+
+	client := NewClient(recorder.URL)
+	client.Retries = 0
+	client.Tenant = "contract"
+	client.Traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	c := contract{Consumer: contractParty{ContractConsumer}, Provider: contractParty{"mcp-server"}}
+	for _, tc := range cases {
+		answers <- tc.status
+		err := tc.send(client)
+		if tc.status < 300 && err != nil {
+			return fmt.Errorf("%s: %w", tc.description, err)
+		}
+		select {
+		case request := <-requests:
+			c.Interactions = append(c.Interactions, contractInteraction{tc.description, request, contractResponse{tc.status, tc.body}})
+		default:
+			return fmt.Errorf("%s: the client made no request", tc.description)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c)
+}
@@ -0,0 +1,56 @@
+// line_counter is an example agent: it counts the lines of each file under
+// a directory, streaming the counts as it goes.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	agentsdk "github.com/jayp41/dynamic-context-mcp-system/packages/agent-sdk/go"
+)
+
+const batchSize = 50
+
+func main() {
+	agentsdk.Run("line_counter", countLines)
+}
+
+func countLines(ctx context.Context, root string, emit agentsdk.Emit) (map[string]any, error) {
+	info, err := os.Stat(root)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", agentsdk.ErrUsage, root)
+	}
+
+	var batch []any
+	files, lines := 0, 0
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		n := bytes.Count(data, []byte("\n"))
+		rel, _ := filepath.Rel(root, path)
+		batch = append(batch, map[string]any{"path": rel, "lines": n})
+		files, lines = files+1, lines+n
+		if len(batch) == batchSize {
+			emit("files", batch...)
+			batch = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	emit("files", batch...)
+	return map[string]any{"files": files, "lines": lines}, nil
+}
@@ -0,0 +1,101 @@
+// Package agentsdk is for writing micro agents that plug into the agent
+// orchestrator and the MCP server: the JSON shapes they exchange, a client
+// that submits them with retries, and Run, which handles the command line,
+// streaming and output conventions so an agent only has to gather context.
+package agentsdk
+
+import "time"
+
+// ResultVersion is the metadata version agent results carry.
+const ResultVersion = "2.0"
+
+// Stream statuses, as sent in StreamDone.
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// ItemBatch is one batch of context items an agent found: the "agent_item"
+// event. Items of the same Field are appended to one another in the
+// stream's context, so a field holds a list. Seq counts batches from 1.
+type ItemBatch struct {
+	StreamID  string `json:"stream_id"`
+	SessionID string `json:"session_id,omitempty"`
+	AgentType string `json:"agent_type"`
+	Target    string `json:"target"`
+	Field     string `json:"field"`
+	Items     []any  `json:"items"`
+	Seq       int    `json:"seq"`
+}
+
+// StreamDone ends a stream: the "agent_done" event.
+type StreamDone struct {
+	StreamID  string `json:"stream_id"`
+	SessionID string `json:"session_id,omitempty"`
+	Status    string `json:"status"`
+	Items     int    `json:"items"`
+	Batches   int    `json:"batches"`
+}
+
+// Result is what an agent prints on stdout when it finishes, and what the
+// orchestrator stores as a job's result.
+type Result struct {
+	Timestamp string         `json:"timestamp"`
+	AgentType string         `json:"agent_type"`
+	Target    string         `json:"target"`
+	Context   map[string]any `json:"context"`
+	Metadata  map[string]any `json:"metadata"`
+	Metrics   *Metrics       `json:"metrics,omitempty"`
+}
+
+// Metrics say what a run produced and used, for the orchestrator's run
+// history and cost reports. Without them the orchestrator counts the
+// entries of the lists in the context as the run's items; without Cost it
+// prices the run from its API calls and tokens.
+type Metrics struct {
+	Items        int        `json:"items,omitempty"`
+	BytesFetched int64      `json:"bytes_fetched,omitempty"`
+	APICalls     int        `json:"api_calls,omitempty"`
+	LLMTokens    *LLMTokens `json:"llm_tokens,omitempty"`
+	Cost         *float64   `json:"cost,omitempty"`
+}
+
+// LLMTokens are the LLM tokens a run used.
+type LLMTokens struct {
+	Input  int `json:"input"`
+	Output int `json:"output"`
+}
+
+// NewResult stamps context with the time and the SDK's metadata.
+func NewResult(agentType, target string, context map[string]any) Result {
+	return Result{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		AgentType: agentType,
+		Target:    target,
+		Context:   context,
+		Metadata:  map[string]any{"source": "agent_sdk", "version": ResultVersion},
+	}
+}
+
+// PipelineInput is what a pipeline step reads on stdin: the run's target
+// and the results of the steps it needs, keyed by step name.
+type PipelineInput struct {
+	Pipeline string            `json:"pipeline"`
+	RunID    string            `json:"run_id"`
+	Step     string            `json:"step"`
+	Target   string            `json:"target"`
+	Inputs   map[string]Result `json:"inputs"`
+}
+
+// Job is a finished job as the orchestrator reports it to the MCP server,
+// for agents that run on their own and submit their results directly.
+// Results that name a session are stored in session memory.
+type Job struct {
+	ID        string  `json:"id"`
+	AgentType string  `json:"agent_type"`
+	Target    string  `json:"target"`
+	SessionID string  `json:"session_id,omitempty"`
+	Status    string  `json:"status"`
+	Result    *Result `json:"result,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
@@ -0,0 +1,33 @@
+#!/usr/bin/env python3
+"""An example agent: counts the lines of each file under a directory,
+streaming the counts as it goes."""
+import os
+
+from mcp_agent_sdk import UsageError, run
+
+BATCH_SIZE = 50
+
+
+def count_lines(root, emit):
+    if not os.path.isdir(root):
+        raise UsageError(f"{root} is not a directory")
+    batch, files, lines = [], 0, 0
+    for dirpath, _, filenames in os.walk(root):
+        for name in filenames:
+            path = os.path.join(dirpath, name)
+            try:
+                with open(path, "rb") as f:
+                    n = f.read().count(b"\n")
+            except OSError:
+                continue
+            batch.append({"path": os.path.relpath(path, root), "lines": n})
+            files, lines = files + 1, lines + n
+            if len(batch) == BATCH_SIZE:
+                emit("files", batch)
+                batch = []
+    emit("files", batch)
+    return {"files": files, "lines": lines}
+
+
+if __name__ == "__main__":
+    run("line_counter", count_lines)
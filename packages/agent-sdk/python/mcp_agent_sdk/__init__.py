@@ -0,0 +1,25 @@
+"""Write micro agents that plug into the agent orchestrator and MCP server.
+
+  from mcp_agent_sdk import run
+
+  def gather(target, emit):
+      emit("pages", [{"url": target}])
+      return {"pages": 1}
+
+  if __name__ == "__main__":
+      run("my_agent", gather)
+
+The schema module has the JSON shapes agents exchange, client submits them
+with retries, and agent has run, which handles the command line, streaming
+and output conventions. It needs nothing beyond the standard library.
+"""
+from .agent import (EXIT_FAILED, EXIT_OK, EXIT_USAGE, Stream, UsageError, flag_enabled, log, read_input,
+                    record_usage, run)
+from .client import Client, SubmissionError
+from .schema import RESULT_VERSION, AgentResult, ItemBatch, Job, StreamDone
+
+__all__ = [
+    "EXIT_FAILED", "EXIT_OK", "EXIT_USAGE", "RESULT_VERSION",
+    "AgentResult", "Client", "ItemBatch", "Job", "Stream", "StreamDone", "SubmissionError", "UsageError",
+    "flag_enabled", "log", "read_input", "record_usage", "run",
+]
@@ -0,0 +1,150 @@
+"""Runs an agent the way the orchestrator expects.
+
+Progress goes to stdout ahead of the result, which is everything from the
+first line starting with "{", so progress lines must not start with one;
+the repo's agents start theirs with an emoji. Errors go to stderr, whose
+tail the orchestrator reports, and the exit status is EXIT_OK, EXIT_FAILED,
+or EXIT_USAGE for a problem with how the agent was run rather than with
+its target.
+"""
+import json
+import os
+import sys
+import uuid
+
+from .client import Client, SubmissionError
+from .schema import STATUS_FAILED, STATUS_SUCCEEDED, AgentResult, ItemBatch, StreamDone
+
+EXIT_OK = 0
+EXIT_FAILED = 1
+EXIT_USAGE = 2
+
+# What the agent has recorded with record_usage during the run.
+_usage = {"api_calls": 0, "input_tokens": 0, "output_tokens": 0, "cost": None}
+
+
+class UsageError(Exception):
+    """Exits with EXIT_USAGE, as do ImportErrors for missing dependencies"""
+
+
+def read_input():
+    """The JSON object on stdin when the agent runs as a pipeline step:
+    {"pipeline", "run_id", "step", "target", "inputs"}, where inputs has the
+    results of the steps it needs, keyed by step name. None when there is
+    none: stdin is a terminal or empty, as it is for agents run any other
+    way."""
+    if sys.stdin is None or sys.stdin.isatty():
+        return None
+    raw = sys.stdin.read().strip()
+    return json.loads(raw) if raw else None
+
+
+def flag_enabled(name, env=None):
+    """Whether a feature flag is on for the job's tenant: AGENT_FLAGS, which
+    the orchestrator sets from the config service, names the flags that are"""
+    env = os.environ if env is None else env
+    return name in (env.get("AGENT_FLAGS") or "").split(",")
+
+
+def log(message):
+    """Prints a progress line"""
+    print(message, flush=True)
+
+
+def record_usage(api_calls=0, input_tokens=0, output_tokens=0, cost=None):
+    """Counts external API calls and LLM tokens toward the run's metrics,
+    which the orchestrator uses for cost reports and budgets. cost is what
+    the calls cost, when the agent knows; otherwise the orchestrator prices
+    the run from its counts."""
+    _usage["api_calls"] += api_calls
+    _usage["input_tokens"] += input_tokens
+    _usage["output_tokens"] += output_tokens
+    if cost is not None:
+        _usage["cost"] = (_usage["cost"] or 0) + cost
+
+
+def _metrics(stream):
+    metrics = {"items": stream.sent} if stream.seq else {}
+    if _usage["api_calls"]:
+        metrics["api_calls"] = _usage["api_calls"]
+    if _usage["input_tokens"] or _usage["output_tokens"]:
+        metrics["llm_tokens"] = {"input": _usage["input_tokens"], "output": _usage["output_tokens"]}
+    if _usage["cost"] is not None:
+        metrics["cost"] = _usage["cost"]
+    return metrics or None
+
+
+class Stream:
+    """Sends an agent's items to the MCP server as it finds them.
+
+    The stream is AGENT_JOB_ID when the orchestrator launched the agent, or a
+    random ID otherwise, and the items go to AGENT_SESSION_ID. Without
+    MCP_SERVER_URL nothing is sent; RBAC_TOKEN, when the orchestrator gives
+    one, is sent as the bearer token and AGENT_TENANT as X-Tenant-ID. After a batch fails for good, streaming
+    stops for the rest of the run; the final result still has everything.
+    """
+
+    def __init__(self, agent_type, target, env=None):
+        env = os.environ if env is None else env
+        self.stream_id = env.get("AGENT_JOB_ID") or uuid.uuid4().hex
+        self.session_id = env.get("AGENT_SESSION_ID") or None
+        self.agent_type, self.target = agent_type, target
+        self.seq, self.sent = 0, 0
+        url = env.get("MCP_SERVER_URL")
+        self.client = Client(url, token=env.get("RBAC_TOKEN"), tenant=env.get("AGENT_TENANT"),
+                             traceparent=env.get("TRACEPARENT")) if url else None
+        if self.client:
+            log(f"📡 Streaming context to {url}")
+
+    def emit(self, field, items):
+        items = list(items)
+        if not items:
+            return
+        self.seq += 1
+        self.sent += len(items)
+        if not self.client:
+            return
+        try:
+            self.client.send_items(ItemBatch(self.stream_id, self.agent_type, self.target, field, items,
+                                             self.seq, self.session_id))
+        except SubmissionError as e:
+            log(f"⚠️ Not streaming context any more: {e}")
+            self.client = None
+
+    def close(self, status):
+        if not self.client:
+            return
+        try:
+            self.client.send_done(StreamDone(self.stream_id, status, self.sent, self.seq, self.session_id))
+        except SubmissionError as e:
+            log(f"⚠️ Could not end the context stream: {e}")
+
+
+def run(agent_type, gather, argv=None):
+    """An agent's main: takes the target from the command line, calls
+    gather(target, emit) with streaming set up from the environment, prints
+    the result and exits with the status the orchestrator expects. gather
+    returns the context for the result as a dict."""
+    argv = sys.argv[1:] if argv is None else argv
+    if len(argv) != 1 or not argv[0]:
+        print(f"❌ usage: {agent_type} TARGET", file=sys.stderr)
+        sys.exit(EXIT_USAGE)
+    target = argv[0]
+
+    log(f"🔍 Gathering context for: {target}")
+    stream = Stream(agent_type, target)
+    try:
+        context = gather(target, stream.emit)
+    except (UsageError, ImportError) as e:
+        stream.close(STATUS_FAILED)
+        print(f"❌ {e}", file=sys.stderr)
+        sys.exit(EXIT_USAGE)
+    except Exception as e:
+        stream.close(STATUS_FAILED)
+        print(f"❌ Could not gather context for {target}: {e}", file=sys.stderr)
+        sys.exit(EXIT_FAILED)
+    stream.close(STATUS_SUCCEEDED)
+
+    log("✅ Context gathered successfully!")
+    print(json.dumps(AgentResult(agent_type, target, context, metrics=_metrics(stream)).to_dict(), indent=2))
+    sys.exit(EXIT_OK)
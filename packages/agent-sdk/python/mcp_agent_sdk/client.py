@@ -0,0 +1,72 @@
+"""Submits items, stream ends and results to the MCP server"""
+import json
+import random
+import time
+import urllib.error
+import urllib.request
+
+
+class SubmissionError(Exception):
+    """A request the MCP server refused, or one that failed on every retry"""
+
+    def __init__(self, message, status=None):
+        super().__init__(message)
+        self.status = status
+
+
+class Client:
+    """Posts to the MCP server, retrying with exponential backoff when it
+    could not be reached or answered 429 or 5xx. Other answers are not
+    retried. backoff is the wait before the first retry, doubling for each
+    one after, with up to half again added at random. token is sent as the
+    bearer token, for an MCP server that enforces access control, tenant
+    as X-Tenant-ID and traceparent as the W3C trace context header."""
+
+    def __init__(self, url, retries=3, backoff=0.5, timeout=10.0, token=None, tenant=None, traceparent=None):
+        self.url, self.token, self.tenant = url.rstrip("/"), token, tenant
+        self.traceparent = traceparent
+        self.retries, self.backoff, self.timeout = retries, backoff, timeout
+
+    def send_items(self, batch):
+        """Posts an ItemBatch to /agents/items"""
+        self._post("/agents/items", batch.to_dict())
+
+    def send_done(self, done):
+        """Posts a StreamDone to /agents/done"""
+        self._post("/agents/done", done.to_dict())
+
+    def submit_result(self, job):
+        """Posts a finished Job to /agents/results. Agents the orchestrator
+        launches must not call it: the orchestrator reports their jobs."""
+        self._post("/agents/results", job.to_dict())
+
+    def _post(self, path, body):
+        data = json.dumps(body).encode()
+        wait = self.backoff
+        for attempt in range(self.retries + 1):
+            try:
+                return self._try(path, data)
+            except SubmissionError as e:
+                retryable = e.status is None or e.status == 429 or e.status >= 500
+                if not retryable or attempt == self.retries:
+                    raise
+            time.sleep(wait + random.uniform(0, wait / 2))
+            wait *= 2
+
+    def _try(self, path, data):
+        headers = {"Content-Type": "application/json"}
+        if self.token:
+            headers["Authorization"] = f"Bearer {self.token}"
+        if self.tenant:
+            headers["X-Tenant-ID"] = self.tenant
+        if self.traceparent:
+            headers["traceparent"] = self.traceparent
+        request = urllib.request.Request(self.url + path, data=data, method="POST", headers=headers)
+        try:
+            with urllib.request.urlopen(request, timeout=self.timeout) as response:
+                return json.loads(response.read() or b"null")
+        except urllib.error.HTTPError as e:
+            detail = e.read(512).decode(errors="replace").strip()
+            raise SubmissionError(f"MCP server answered {e.code}: {detail}", e.code) from None
+        except (urllib.error.URLError, OSError) as e:
+            raise SubmissionError(f"MCP server unreachable: {e}") from None
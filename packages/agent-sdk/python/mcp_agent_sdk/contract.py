@@ -0,0 +1,104 @@
+"""Writes the SDK's contract with the MCP server, for the pipeline to verify.
+
+  python3 -m mcp_agent_sdk.contract > agent-sdk-python.json
+
+A contract is what the SDK expects of the MCP server, in the shape of a
+Pact file: each request its client makes, recorded as the client makes it,
+and the answer the SDK relies on. A response body is matched by type: every
+field it has must be there, of the same JSON type.
+"""
+import json
+import queue
+import sys
+import threading
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+from .client import Client, SubmissionError
+from .schema import STATUS_FAILED, STATUS_SUCCEEDED, AgentResult, ItemBatch, Job, StreamDone
+
+CONSUMER = "agent-sdk-python"
+
+# The headers the MCP server reads from agents
+HEADERS = ("Content-Type", "X-Tenant-ID", "traceparent")
+
+
+def _cases():
+    stream = "contract-" + CONSUMER
+    node = {"data": {"type": "contract", "content": "A node an agent found"}}
+    result = AgentResult("contract_agent", "contract-target", {"nodes": [node]},
+                         metrics={"items": 1, "api_calls": 1, "llm_tokens": {"input": 10, "output": 5}})
+
+    def batch(items, seq):
+        return ItemBatch(stream_id=stream, session_id=stream, agent_type="contract_agent", target="contract-target",
+                         field="nodes", items=items, seq=seq)
+
+    return [
+        ("a batch of streamed nodes", 202, {"stream_id": stream, "seq": 1},
+         lambda c: c.send_items(batch([node], 1))),
+        ("a batch of nodes without their data", 422, {"error": "", "violations": []},
+         lambda c: c.send_items(batch([{"content": "A node without its data"}], 2))),
+        ("the end of a stream", 200, {"stream_id": stream},
+         lambda c: c.send_done(StreamDone(stream_id=stream, session_id=stream, status=STATUS_SUCCEEDED,
+                                          items=1, batches=1))),
+        ("a job that succeeded", 200, {"id": stream},
+         lambda c: c.submit_result(Job(id=stream, agent_type="contract_agent", target="contract-target",
+                                       status=STATUS_SUCCEEDED, result=result))),
+        ("a job that failed", 200, {"id": stream + "-failed"},
+         lambda c: c.submit_result(Job(id=stream + "-failed", agent_type="contract_agent", target="contract-target",
+                                       status=STATUS_FAILED, error="gathering failed"))),
+    ]
+
+
+def write_contract(out):
+    """Records the requests the client makes for each thing an agent sends
+    the MCP server, and writes them to out as the SDK's contract with it"""
+    requests, answers = queue.Queue(), queue.Queue()
+
+    # The recorder answers each request as the case expects the MCP server
+    # to, so the client goes on as it would
+    class Recorder(BaseHTTPRequestHandler):
+        def do_POST(self):
+            raw = self.rfile.read(int(self.headers.get("Content-Length", 0)))
+            try:
+                body = json.loads(raw)
+            except ValueError:
+                body = None
+            requests.put({"method": "POST", "path": self.path,
+                          "headers": {name: self.headers[name] for name in HEADERS if self.headers.get(name)},
+                          "body": body})
+            self.send_response(answers.get())
+            self.send_header("Content-Length", "0")
+            self.end_headers()
+
+        def log_message(self, *args):
+            pass
+
+    recorder = ThreadingHTTPServer(("127.0.0.1", 0), Recorder)
+    threading.Thread(target=recorder.serve_forever, daemon=True).start()
+    try:
+        client = Client(f"http://127.0.0.1:{recorder.server_port}", retries=0, tenant="contract",
+                        traceparent="00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+        interactions = []
+        for description, status, body, send in _cases():
+            answers.put(status)
+            try:
+                send(client)
+            except SubmissionError:
+                if status < 300:
+                    raise
+            try:
+                request = requests.get_nowait()
+            except queue.Empty:
+                raise RuntimeError(f"{description}: the client made no request") from None
+            interactions.append({"description": description, "request": request,
+                                 "response": {"status": status, "body": body}})
+    finally:
+        recorder.shutdown()
+
+    json.dump({"consumer": {"name": CONSUMER}, "provider": {"name": "mcp-server"}, "interactions": interactions},
+              out, indent=2)
+    out.write("\n")
+
+
+if __name__ == "__main__":
+    write_contract(sys.stdout)
@@ -0,0 +1,78 @@
+"""The JSON shapes agents exchange with the orchestrator and MCP server"""
+from dataclasses import asdict, dataclass, field
+from datetime import datetime, timezone
+
+RESULT_VERSION = "2.0"
+
+STATUS_SUCCEEDED = "succeeded"
+STATUS_FAILED = "failed"
+
+
+def _without_none(d):
+    return {k: v for k, v in d.items() if v is not None}
+
+
+@dataclass
+class ItemBatch:
+    """One batch of context items: the "agent_item" event.
+
+    Items of the same field are appended to one another in the stream's
+    context, so a field holds a list. seq counts batches from 1.
+    """
+    stream_id: str
+    agent_type: str
+    target: str
+    field: str
+    items: list
+    seq: int
+    session_id: str | None = None
+
+    def to_dict(self):
+        return _without_none(asdict(self))
+
+
+@dataclass
+class StreamDone:
+    """The end of a stream: the "agent_done" event"""
+    stream_id: str
+    status: str
+    items: int
+    batches: int
+    session_id: str | None = None
+
+    def to_dict(self):
+        return _without_none(asdict(self))
+
+
+@dataclass
+class AgentResult:
+    """What an agent prints on stdout when it finishes, and what the
+    orchestrator stores as a job's result. metrics, {"items",
+    "bytes_fetched", "api_calls", "llm_tokens": {"input", "output"},
+    "cost"}, go to the orchestrator's run history and cost reports."""
+    agent_type: str
+    target: str
+    context: dict
+    timestamp: str = field(default_factory=lambda: datetime.now(timezone.utc).isoformat())
+    metadata: dict = field(default_factory=lambda: {"source": "agent_sdk", "version": RESULT_VERSION})
+    metrics: dict | None = None
+
+    def to_dict(self):
+        return _without_none(asdict(self))
+
+
+@dataclass
+class Job:
+    """A finished job as the orchestrator reports it, for agents that run on
+    their own and submit their results directly. Results that name a session
+    are stored in session memory."""
+    id: str
+    agent_type: str
+    target: str
+    status: str
+    result: AgentResult | None = None
+    session_id: str | None = None
+    error: str | None = None
+
+    def to_dict(self):
+        return _without_none(asdict(self))
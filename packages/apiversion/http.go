@@ -0,0 +1,73 @@
+package apiversion
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// exempt are the routes probes call, which stay unversioned without being
+// deprecated.
+func exempt(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	switch r.URL.Path {
+	case "/health", "/metrics", "/versions":
+		return true
+	}
+	return false
+}
+
+// Middleware serves /v<version>/... as the route without the prefix, so the
+// handlers behind it, access control among them, see the same paths either
+// way. A path without a version is served as the one in the API-Version
+// header or else as Current, the latter with Deprecation, Sunset and a Link
+// to its successor. Every response names the version it was served as in
+// API-Version, and a version that is not supported answers 406, in
+// FastAPI's error body like the services' other errors. GET /versions
+// describes them. Put it in front of the other middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, path, prefixed := Split(r.URL.Path)
+		if !prefixed && r.Method == http.MethodGet && r.URL.Path == "/versions" {
+			writeJSON(w, http.StatusOK, Describe())
+			return
+		}
+		deprecated := false
+		if !prefixed {
+			version = r.Header.Get(Header)
+			if version == "" {
+				version, deprecated = Current, !exempt(r)
+			}
+		}
+		if !IsSupported(version) {
+			w.Header().Set(Header, Current)
+			writeJSON(w, http.StatusNotAcceptable, map[string]any{
+				"detail":    (&UnsupportedError{Version: version}).Error(),
+				"supported": Supported,
+			})
+			return
+		}
+
+		w.Header().Set(Header, version)
+		if deprecated {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(UnversionedDeprecated.Unix(), 10))
+			w.Header().Set("Sunset", UnversionedSunset.Format(http.TimeFormat))
+			w.Header().Set("Link", "</v"+Current+r.URL.Path+`>; rel="successor-version"`)
+		}
+		if prefixed {
+			url := *r.URL
+			url.Path, url.RawPath = path, ""
+			r = r.WithContext(r.Context())
+			r.URL = &url
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
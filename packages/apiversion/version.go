@@ -0,0 +1,92 @@
+// Package apiversion versions the public APIs of the dynamic context
+// system: the MCP server, the knowledge graph and session memory serve the
+// same routes under /v1, negotiate a version for the paths without one,
+// and mark those as deprecated.
+package apiversion
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Header asks for an API version on a path without one, and answers the
+// version a response was served as.
+const Header = "API-Version"
+
+// Current is the version the services serve by default.
+const Current = "1"
+
+// Supported are the versions the services serve, oldest first.
+var Supported = []string{"1"}
+
+// Unversioned paths, the ones without a /v<version> prefix, are served as
+// Current but deprecated since UnversionedDeprecated, and may stop being
+// served after UnversionedSunset.
+var (
+	UnversionedDeprecated = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	UnversionedSunset     = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+var prefixPattern = regexp.MustCompile(`^/v([0-9]+)(/.*)?$`)
+
+// Split is a path's version and the path without it, or ok false for a
+// path without a version.
+func Split(path string) (version, rest string, ok bool) {
+	parts := prefixPattern.FindStringSubmatch(path)
+	if parts == nil {
+		return "", path, false
+	}
+	if parts[2] == "" {
+		return parts[1], "/", true
+	}
+	return parts[1], parts[2], true
+}
+
+// IsSupported is whether version is served.
+func IsSupported(version string) bool {
+	for _, v := range Supported {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedError is a request for a version that is not served.
+type UnsupportedError struct {
+	Version string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("API version %q is not supported; supported versions are %s", e.Version, strings.Join(Supported, ", "))
+}
+
+// Versions is what GET /versions answers: the versions served, and what
+// becomes of the paths without one.
+type Versions struct {
+	Current     string      `json:"current"`
+	Supported   []string    `json:"supported"`
+	Unversioned Unversioned `json:"unversioned"`
+}
+
+// Unversioned describes the paths without a version.
+type Unversioned struct {
+	ServedAs   string    `json:"served_as"`
+	Deprecated time.Time `json:"deprecated"`
+	Sunset     time.Time `json:"sunset"`
+}
+
+// Describe is the versions the services serve.
+func Describe() Versions {
+	return Versions{
+		Current:   Current,
+		Supported: Supported,
+		Unversioned: Unversioned{
+			ServedAs:   Current,
+			Deprecated: UnversionedDeprecated,
+			Sunset:     UnversionedSunset,
+		},
+	}
+}
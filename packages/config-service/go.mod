@@ -0,0 +1,12 @@
+module github.com/jayp41/dynamic-context-mcp-system/packages/config-service
+
+go 1.22
+
+require github.com/jayp41/dynamic-context-mcp-system/packages/logging v0.0.0
+
+require github.com/jayp41/dynamic-context-mcp-system/packages/tracing v0.0.0 // indirect
+
+replace (
+	github.com/jayp41/dynamic-context-mcp-system/packages/logging => ../logging
+	github.com/jayp41/dynamic-context-mcp-system/packages/tracing => ../tracing
+)
@@ -0,0 +1,132 @@
+// Command config-service serves the settings of every component of the
+// dynamic context system, such as thresholds, TTLs, provider keys and
+// feature flags, from one JSON file. Components pull their settings when
+// they start and wait on them for changes, which the service makes through
+// its API or picks up when the file changes. See README.md.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/logging"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("config-service: %v", err)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getenvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, value)
+	}
+	return n, nil
+}
+
+func run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := logging.FromEnv("config-service")
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		logger.Shutdown(shutdownCtx)
+	}()
+
+	interval, err := getenvInt("CONFIG_RELOAD_INTERVAL", 5)
+	if err != nil {
+		return err
+	}
+	store := newStore(os.Getenv("CONFIG_FILE"), getenv("CONFIG_SECRETS_DIR", "/run/secrets"))
+	if _, err := store.Load(); err != nil {
+		return fmt.Errorf("loading settings: %w", err)
+	}
+	go watch(ctx, store, time.Duration(interval)*time.Second)
+
+	s := &server{store: store, token: os.Getenv("CONFIG_TOKEN")}
+	httpServer := &http.Server{
+		Addr:              ":" + getenv("CONFIG_PORT", "8050"),
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		sections, _ := store.Sections()
+		log.Printf("config service listening on %s (%d components, %s)", httpServer.Addr, len(sections), logger)
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-ctx.Done():
+		// Long polls are held open for up to maxWait, so they are cut off
+		// rather than waited for
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+	}
+	return nil
+}
+
+// watch loads the file again on SIGHUP and, every interval unless it is
+// zero, when it was modified. A file that does not load is logged and the
+// settings stay as they were.
+func watch(ctx context.Context, store *Store, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			if !store.Modified() {
+				continue
+			}
+		}
+		changed, err := store.Load()
+		if err != nil {
+			log.Printf("keeping the settings as they were: %v", err)
+			continue
+		}
+		if len(changed) > 0 {
+			log.Printf("reloaded settings; %v changed", changed)
+		}
+	}
+}
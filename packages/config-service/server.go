@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxWait caps how long a GET /config/{component}?after= is held open.
+const maxWait = 60 * time.Second
+
+// server serves the store's settings over HTTP, and changes them.
+type server struct {
+	store *Store
+	// token, when set, must be sent as a bearer token to all but /health,
+	// since the settings hold provider keys.
+	token string
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", s.health)
+	mux.HandleFunc("GET /config", s.authorized(s.listSections))
+	mux.HandleFunc("GET /config/{component}", s.authorized(s.getSettings))
+	mux.HandleFunc("GET /config/{component}/section", s.authorized(s.getSection))
+	mux.HandleFunc("PUT /config/{component}", s.authorized(s.putSection))
+	mux.HandleFunc("PATCH /config/{component}", s.authorized(s.patchSection))
+	mux.HandleFunc("DELETE /config/{component}", s.authorized(s.deleteSection))
+	mux.HandleFunc("GET /flags/{component}", s.authorized(s.getFlags))
+	mux.HandleFunc("POST /reload", s.authorized(s.reload))
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]any{"detail": detail})
+}
+
+func (s *server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	if s.token == "" {
+		return next
+	}
+	want := []byte("Bearer " + s.token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *server) health(w http.ResponseWriter, r *http.Request) {
+	sections, version := s.store.Sections()
+	writeJSON(w, http.StatusOK, map[string]any{"status": "healthy", "components": len(sections), "version": version})
+}
+
+func (s *server) listSections(w http.ResponseWriter, r *http.Request) {
+	sections, version := s.store.Sections()
+	writeJSON(w, http.StatusOK, map[string]any{"version": version, "components": sections})
+}
+
+// getSettings serves a component's settings. With after, it waits, up to
+// wait seconds, until they are past that version, so a component learns of
+// a change as soon as it is made.
+func (s *server) getSettings(w http.ResponseWriter, r *http.Request) {
+	component := r.PathValue("component")
+	query := r.URL.Query()
+	if query.Get("after") == "" {
+		writeJSON(w, http.StatusOK, s.store.Settings(component))
+		return
+	}
+	after, err := strconv.ParseInt(query.Get("after"), 10, 64)
+	if err != nil || after < 0 {
+		writeError(w, http.StatusBadRequest, "after must be a version")
+		return
+	}
+	wait := 30 * time.Second
+	if raw := query.Get("wait"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 {
+			writeError(w, http.StatusBadRequest, "wait must be a number of seconds")
+			return
+		}
+		wait = min(time.Duration(seconds)*time.Second, maxWait)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+	writeJSON(w, http.StatusOK, s.store.Wait(ctx, component, after))
+}
+
+// getFlags serves whether each of a component's flags is on, for tenant
+// when it is given, so a flag can be checked without reading the rules.
+func (s *server) getFlags(w http.ResponseWriter, r *http.Request) {
+	tenant := r.URL.Query().Get("tenant")
+	if tenant != "" && !tenantPattern.MatchString(tenant) {
+		writeError(w, http.StatusBadRequest, "invalid tenant")
+		return
+	}
+	settings := s.store.Settings(r.PathValue("component"))
+	flags := make(map[string]bool, len(settings.Flags))
+	for name, flag := range settings.Flags {
+		flags[name] = flag.For(tenant)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"component": settings.Component, "version": settings.Version, "tenant": tenant, "flags": flags})
+}
+
+func (s *server) getSection(w http.ResponseWriter, r *http.Request) {
+	section, err := s.store.Section(r.PathValue("component"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, section)
+}
+
+func (s *server) putSection(w http.ResponseWriter, r *http.Request) {
+	var section Section
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&section); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid section: "+err.Error())
+		return
+	}
+	s.write(w, r, func(component string) ([]string, error) { return s.store.Put(component, &section) })
+}
+
+func (s *server) patchSection(w http.ResponseWriter, r *http.Request) {
+	var patch map[string]any
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid patch: "+err.Error())
+		return
+	}
+	s.write(w, r, func(component string) ([]string, error) { return s.store.Patch(component, patch) })
+}
+
+func (s *server) deleteSection(w http.ResponseWriter, r *http.Request) {
+	s.write(w, r, func(component string) ([]string, error) { return s.store.Put(component, nil) })
+}
+
+// write makes a change and answers with the component's settings after it.
+func (s *server) write(w http.ResponseWriter, r *http.Request, change func(string) ([]string, error)) {
+	component := r.PathValue("component")
+	changed, err := change(component)
+	switch {
+	case errors.Is(err, errUnknownComponent):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(changed) > 0 {
+		log.Printf("settings of %v changed", changed)
+	}
+	writeJSON(w, http.StatusOK, s.store.Settings(component))
+}
+
+// reload reads the file again, as SIGHUP does.
+func (s *server) reload(w http.ResponseWriter, r *http.Request) {
+	changed, err := s.store.Load()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	_, version := s.store.Sections()
+	writeJSON(w, http.StatusOK, map[string]any{"version": version, "changed": changed})
+}
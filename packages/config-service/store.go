@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shared is the section every component's environment starts from.
+const shared = "*"
+
+var (
+	componentPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	envKeyPattern    = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+	secretPattern    = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+	flagPattern      = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	// tenantPattern is the tenant IDs the other services accept.
+	tenantPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+	errUnknownComponent = errors.New("no such component")
+)
+
+// Section is one component's settings. Env holds the variables the
+// component already reads, such as ORCH_JOB_RETRIES, each a string, number,
+// boolean or {"secret": name}. Config is laid over the component's config
+// file, for those that have one. Flags turn features on and off, by name.
+type Section struct {
+	Env    map[string]any  `json:"env,omitempty"`
+	Config map[string]any  `json:"config,omitempty"`
+	Flags  map[string]Flag `json:"flags,omitempty"`
+}
+
+// Flag is a feature flag: whether the feature is on, and for which tenants
+// that is not so.
+type Flag struct {
+	Enabled     bool            `json:"enabled"`
+	Tenants     map[string]bool `json:"tenants,omitempty"`
+	Description string          `json:"description,omitempty"`
+}
+
+// For reports whether the flag is on for a tenant.
+func (f Flag) For(tenant string) bool {
+	if enabled, ok := f.Tenants[tenant]; ok {
+		return enabled
+	}
+	return f.Enabled
+}
+
+// Settings are what a component is served: its section's environment over
+// the shared one, with secrets resolved, its config, and its flags over the
+// shared ones. Version goes up whenever they change.
+type Settings struct {
+	Component string            `json:"component"`
+	Version   int64             `json:"version"`
+	Env       map[string]string `json:"env"`
+	Config    map[string]any    `json:"config"`
+	Flags     map[string]Flag   `json:"flags"`
+}
+
+// Store holds the settings of every component, loaded from a JSON file of
+// sections by component name, and tells those waiting on a component when
+// its settings change.
+type Store struct {
+	path       string
+	secretsDir string
+	// writes holds writers to one at a time, so none is lost between
+	// reading the sections and saving them.
+	writes sync.Mutex
+
+	mu       sync.Mutex
+	sections map[string]Section
+	resolved map[string]Section
+	version  int64
+	versions map[string]int64
+	modTime  time.Time
+	// changed is closed, and replaced, whenever anything changes.
+	changed chan struct{}
+}
+
+func newStore(path, secretsDir string) *Store {
+	return &Store{path: path, secretsDir: secretsDir, sections: map[string]Section{}, resolved: map[string]Section{},
+		versions: map[string]int64{}, changed: make(chan struct{})}
+}
+
+// Load reads the file again, keeping the settings as they were if it is
+// invalid. It reports the components whose settings changed.
+func (s *Store) Load() ([]string, error) {
+	if s.path == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Nothing is set until the first write creates the file
+		return s.apply(map[string]Section{}, time.Time{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var sections map[string]Section
+	if err = json.Unmarshal(data, &sections); err != nil {
+		err = fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+	var changed []string
+	if err == nil {
+		changed, err = s.apply(sections, info.ModTime())
+	}
+	if err != nil {
+		// So a bad file is reported once, not on every poll until it is
+		// fixed
+		s.mu.Lock()
+		s.modTime = info.ModTime()
+		s.mu.Unlock()
+	}
+	return changed, err
+}
+
+// Modified reports whether the file changed since it was last loaded.
+func (s *Store) Modified() bool {
+	if s.path == "" {
+		return false
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !info.ModTime().Equal(s.modTime)
+}
+
+// apply validates and resolves sections and makes them the store's, giving
+// every component whose settings changed the next version.
+func (s *Store) apply(sections map[string]Section, modTime time.Time) ([]string, error) {
+	if sections == nil {
+		sections = map[string]Section{}
+	}
+	resolved := map[string]Section{}
+	for name, section := range sections {
+		r, err := s.resolve(name, section)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = r
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := map[string]bool{}
+	for name := range s.resolved {
+		names[name] = true
+	}
+	for name := range resolved {
+		names[name] = true
+	}
+	changed := []string{}
+	for name := range names {
+		before, _ := json.Marshal(s.resolved[name])
+		after, _ := json.Marshal(resolved[name])
+		if !bytes.Equal(before, after) {
+			changed = append(changed, name)
+		}
+	}
+	s.sections, s.resolved, s.modTime = sections, resolved, modTime
+	if len(changed) > 0 {
+		s.version++
+		for _, name := range changed {
+			s.versions[name] = s.version
+		}
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// resolve checks a section and reads the secrets its environment names.
+func (s *Store) resolve(name string, section Section) (Section, error) {
+	if name != shared && !componentPattern.MatchString(name) {
+		return Section{}, fmt.Errorf("invalid component name %q", name)
+	}
+	if name == shared && len(section.Config) > 0 {
+		return Section{}, errors.New(`the shared section "*" takes only env and flags`)
+	}
+	env := map[string]any{}
+	for key, value := range section.Env {
+		if !envKeyPattern.MatchString(key) {
+			return Section{}, fmt.Errorf("%s: invalid variable name %q", name, key)
+		}
+		resolved, err := s.envValue(value)
+		if err != nil {
+			return Section{}, fmt.Errorf("%s: %s: %w", name, key, err)
+		}
+		env[key] = resolved
+	}
+	for key, flag := range section.Flags {
+		if !flagPattern.MatchString(key) {
+			return Section{}, fmt.Errorf("%s: invalid flag name %q", name, key)
+		}
+		for tenant := range flag.Tenants {
+			if !tenantPattern.MatchString(tenant) {
+				return Section{}, fmt.Errorf("%s: flag %s: invalid tenant %q", name, key, tenant)
+			}
+		}
+	}
+	return Section{Env: env, Config: section.Config, Flags: section.Flags}, nil
+}
+
+// envValue is the string a setting is served as.
+func (s *Store) envValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case map[string]any:
+		name, ok := v["secret"].(string)
+		if len(v) != 1 || !ok {
+			return "", errors.New(`must be a string, number, boolean or {"secret": name}`)
+		}
+		return s.secret(name)
+	}
+	return "", errors.New(`must be a string, number, boolean or {"secret": name}`)
+}
+
+// secret reads a secret from a file named for it in the secrets directory.
+func (s *Store) secret(name string) (string, error) {
+	if !secretPattern.MatchString(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	if s.secretsDir == "" {
+		return "", fmt.Errorf("secret %s: no secrets directory is set", name)
+	}
+	data, err := os.ReadFile(filepath.Join(s.secretsDir, name))
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Settings are a component's settings now. Any component has some: those
+// without a section get the shared environment.
+func (s *Store) Settings(component string) Settings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settings(component)
+}
+
+func (s *Store) settings(component string) Settings {
+	env := map[string]string{}
+	// A component's flag replaces the shared one of its name whole
+	flags := map[string]Flag{}
+	for _, name := range []string{shared, component} {
+		for key, value := range s.resolved[name].Env {
+			env[key] = value.(string)
+		}
+		for key, flag := range s.resolved[name].Flags {
+			flags[key] = flag
+		}
+	}
+	config := s.resolved[component].Config
+	if config == nil {
+		config = map[string]any{}
+	}
+	return Settings{Component: component, Version: max(s.versions[shared], s.versions[component]), Env: env, Config: config, Flags: flags}
+}
+
+// Wait returns a component's settings once their version is past after, or
+// as they are when ctx is done.
+func (s *Store) Wait(ctx context.Context, component string, after int64) Settings {
+	for {
+		s.mu.Lock()
+		settings, changed := s.settings(component), s.changed
+		s.mu.Unlock()
+		if settings.Version > after {
+			return settings
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return settings
+		}
+	}
+}
+
+// Sections are the sections as written, with secrets by name.
+func (s *Store) Sections() (map[string]Section, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sections, s.version
+}
+
+// Section is one component's section as written.
+func (s *Store) Section(component string) (Section, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	section, ok := s.sections[component]
+	if !ok {
+		return Section{}, fmt.Errorf("%w: %s", errUnknownComponent, component)
+	}
+	return section, nil
+}
+
+// Put replaces a component's section, nil removing it, and saves the file.
+func (s *Store) Put(component string, section *Section) ([]string, error) {
+	return s.update(func(sections map[string]Section) error {
+		if section == nil {
+			if _, ok := sections[component]; !ok {
+				return fmt.Errorf("%w: %s", errUnknownComponent, component)
+			}
+			delete(sections, component)
+			return nil
+		}
+		sections[component] = *section
+		return nil
+	})
+}
+
+// Patch merges patch into a component's section as a JSON merge patch
+// (RFC 7386): null removes a setting, and an object is merged into the one
+// it replaces.
+func (s *Store) Patch(component string, patch map[string]any) ([]string, error) {
+	return s.update(func(sections map[string]Section) error {
+		var current map[string]any
+		data, _ := json.Marshal(sections[component])
+		json.Unmarshal(data, &current)
+		data, _ = json.Marshal(mergePatch(current, patch))
+		var section Section
+		if err := json.Unmarshal(data, &section); err != nil {
+			return fmt.Errorf("invalid section: %w", err)
+		}
+		sections[component] = section
+		return nil
+	})
+}
+
+func (s *Store) update(change func(map[string]Section) error) ([]string, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	current, _ := s.Sections()
+	sections := make(map[string]Section, len(current)+1)
+	for name, section := range current {
+		sections[name] = section
+	}
+	if err := change(sections); err != nil {
+		return nil, err
+	}
+	// Checked before it is saved, so an invalid section never reaches the
+	// file
+	for name, section := range sections {
+		if _, err := s.resolve(name, section); err != nil {
+			return nil, err
+		}
+	}
+	modTime, err := s.save(sections)
+	if err != nil {
+		return nil, err
+	}
+	return s.apply(sections, modTime)
+}
+
+// save writes the sections to the file, replacing it whole.
+func (s *Store) save(sections map[string]Section) (time.Time, error) {
+	if s.path == "" {
+		return time.Time{}, nil
+	}
+	data, err := json.MarshalIndent(sections, "", "  ")
+	if err != nil {
+		return time.Time{}, err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return time.Time{}, err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func mergePatch(target, patch map[string]any) map[string]any {
+	if target == nil {
+		target = map[string]any{}
+	}
+	for key, value := range patch {
+		switch v := value.(type) {
+		case nil:
+			delete(target, key)
+		case map[string]any:
+			current, _ := target[key].(map[string]any)
+			target[key] = mergePatch(current, v)
+		default:
+			target[key] = value
+		}
+	}
+	return target
+}
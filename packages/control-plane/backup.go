@@ -0,0 +1,280 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var errInvalidBackup = errors.New("invalid backup")
+
+const (
+	backupFormat  = "dynamic-context-backup"
+	backupVersion = 1
+	manifestFile  = "manifest.json"
+)
+
+// backupFiles are the kinds of service that hold state, with the file each
+// one's backup goes to in the archive. The orchestrator's jobs are not
+// kept: what they found is in the graph and session memory.
+var backupFiles = map[string]string{
+	"memory": "memory.snapshot.json.gz",
+	"graph":  "graph.jsonl",
+	"mcp":    "tools.json",
+}
+
+// backupTypes are the content types each kind's backup is restored as.
+var backupTypes = map[string]string{
+	"memory": "application/gzip",
+	"graph":  "application/x-ndjson",
+	"mcp":    "application/json",
+}
+
+// Manifest describes a backup archive: what it was taken of and the
+// checksum of each part.
+type Manifest struct {
+	Format    string       `json:"format"`
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"created_at"`
+	Parts     []BackupPart `json:"parts"`
+}
+
+// BackupPart is one service's state in an archive.
+type BackupPart struct {
+	Service string `json:"service"`
+	Kind    string `json:"kind"`
+	File    string `json:"file"`
+	Bytes   int    `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// backupClient calls services' backup endpoints, with RBAC_TOKEN as its
+// bearer token when access is controlled.
+type backupClient struct {
+	client *http.Client
+	token  string
+}
+
+func newBackupClient() *backupClient {
+	return &backupClient{client: &http.Client{Timeout: 10 * time.Minute}, token: os.Getenv("RBAC_TOKEN")}
+}
+
+func (c *backupClient) do(ctx context.Context, method, url, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s answered HTTP %d: %s", method, url, resp.StatusCode, bytes.TrimSpace(data[:min(len(data), 512)]))
+	}
+	return data, nil
+}
+
+// statefulServices are the topology's services with state to back up, in
+// start order.
+func statefulServices(topology Topology) []Service {
+	var services []Service
+	for _, service := range topology.Services {
+		if _, ok := backupFiles[service.Kind]; ok {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// Backup takes every stateful service's backup at once and writes them to
+// one archive at path. The parts are requested together, so they are of
+// the same moment give or take the slowest service, and each is a
+// consistent copy of its service. The archive appears at path only once
+// every part is in it; a failed backup leaves nothing behind.
+func Backup(ctx context.Context, topology Topology, path string) (Manifest, error) {
+	services := statefulServices(topology)
+	if len(services) == 0 {
+		return Manifest{}, errors.New("the topology has no services to back up")
+	}
+	client := newBackupClient()
+	parts := make([][]byte, len(services))
+	errs := make([]error, len(services))
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			parts[i], errs[i] = client.do(ctx, http.MethodGet, service.URL+"/backup", "", nil)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return Manifest{}, err
+	}
+
+	manifest := Manifest{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now().UTC()}
+	for i, service := range services {
+		sum := sha256.Sum256(parts[i])
+		manifest.Parts = append(manifest.Parts, BackupPart{Service: service.Name, Kind: service.Kind,
+			File: backupFiles[service.Kind], Bytes: len(parts[i]), SHA256: hex.EncodeToString(sum[:])})
+	}
+	if err := writeArchive(path, manifest, parts); err != nil {
+		return Manifest{}, err
+	}
+	return manifest, nil
+}
+
+// writeArchive writes a gzipped tar of the manifest and parts next to path
+// and renames it into place.
+func writeArchive(path string, manifest Manifest, parts [][]byte) error {
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(manifestFile, encoded); err != nil {
+		return err
+	}
+	for i, part := range manifest.Parts {
+		if err := add(part.File, parts[i]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readArchive reads an archive and checks every part against the manifest.
+func readArchive(path string) (Manifest, map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return Manifest{}, nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Manifest{}, nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return Manifest{}, nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+		}
+		files[header.Name] = data
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(files[manifestFile], &manifest); err != nil {
+		return Manifest{}, nil, fmt.Errorf("%w: no readable %s: %v", errInvalidBackup, manifestFile, err)
+	}
+	if manifest.Format != backupFormat || manifest.Version != backupVersion {
+		return Manifest{}, nil, fmt.Errorf("%w: not a version %d %s", errInvalidBackup, backupVersion, backupFormat)
+	}
+	for _, part := range manifest.Parts {
+		data, ok := files[part.File]
+		if !ok {
+			return Manifest{}, nil, fmt.Errorf("%w: %s is missing", errInvalidBackup, part.File)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != part.SHA256 {
+			return Manifest{}, nil, fmt.Errorf("%w: %s does not match its checksum", errInvalidBackup, part.File)
+		}
+	}
+	return manifest, files, nil
+}
+
+// Restore re-hydrates a deployment from an archive, each part into the
+// topology's service of its kind. Nothing is restored until the whole
+// archive checks out and every service it restores to answers its health
+// check. Records go over those of the same name and leave others alone,
+// so a fresh deployment ends up as the one backed up.
+func Restore(ctx context.Context, topology Topology, path string) (map[string]json.RawMessage, error) {
+	manifest, files, err := readArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	services := map[string]Service{}
+	for _, service := range statefulServices(topology) {
+		services[service.Kind] = service
+	}
+	client := newBackupClient()
+	for _, part := range manifest.Parts {
+		service, ok := services[part.Kind]
+		if !ok {
+			return nil, fmt.Errorf("the topology has no %s service to restore %s to", part.Kind, part.File)
+		}
+		if _, err := client.do(ctx, http.MethodGet, service.URL+service.Health, "", nil); err != nil {
+			return nil, fmt.Errorf("%s is not ready: %w", service.Name, err)
+		}
+	}
+
+	results := map[string]json.RawMessage{}
+	for _, part := range manifest.Parts {
+		service := services[part.Kind]
+		result, err := client.do(ctx, http.MethodPost, service.URL+"/backup/restore", backupTypes[part.Kind], files[part.File])
+		if err != nil {
+			return results, fmt.Errorf("restoring %s: %w", service.Name, err)
+		}
+		results[service.Name] = json.RawMessage(bytes.TrimSpace(result))
+	}
+	return results, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errCanaryOver = errors.New("the canary is over")
+
+// Canary states.
+const (
+	// canaryWatching is a canary taking its share of invocations within its
+	// window, rolled back as soon as it breaks an SLO.
+	canaryWatching = "watching"
+	// canaryPassed is a canary that kept its SLOs through its window. It
+	// keeps its share until it is promoted or rolled back.
+	canaryPassed     = "passed"
+	canaryPromoted   = "promoted"
+	canaryRolledBack = "rolled-back"
+)
+
+// maxLatencySamples bounds the invocation latencies kept for the p95.
+const maxLatencySamples = 10000
+
+// TrafficStats is what one side of a canary has served.
+type TrafficStats struct {
+	Invocations int     `json:"invocations"`
+	Errors      int     `json:"errors"`
+	ErrorRate   float64 `json:"error_rate"`
+	P95MS       int64   `json:"p95_ms"`
+
+	latencies []time.Duration
+}
+
+func (t *TrafficStats) record(latency time.Duration, failed bool) {
+	t.Invocations++
+	if failed {
+		t.Errors++
+	}
+	t.ErrorRate = float64(t.Errors) / float64(t.Invocations)
+	if len(t.latencies) == maxLatencySamples {
+		t.latencies = t.latencies[1:]
+	}
+	t.latencies = append(t.latencies, latency)
+}
+
+// p95 works out P95MS from the latencies kept.
+func (t *TrafficStats) p95() {
+	if len(t.latencies) == 0 {
+		return
+	}
+	sorted := slices.Clone(t.latencies)
+	slices.Sort(sorted)
+	t.P95MS = sorted[(len(sorted)*95+99)/100-1].Milliseconds()
+}
+
+// CanaryStatus is how a canary is doing: its state, the share of
+// invocations it takes, and what each side has served.
+type CanaryStatus struct {
+	Service   string       `json:"service"`
+	URL       string       `json:"url"`
+	Proxy     string       `json:"proxy"`
+	State     string       `json:"state"`
+	Weight    float64      `json:"weight"`
+	StartedAt time.Time    `json:"started_at"`
+	EndsAt    time.Time    `json:"window_ends_at"`
+	EndedAt   *time.Time   `json:"ended_at,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+	SLO       Canary       `json:"slo"`
+	Stable    TrafficStats `json:"stable"`
+	Canary    TrafficStats `json:"canary"`
+}
+
+// canaryProxy stands in for the MCP server while it has a canary. Tool
+// invocations, POST /api/{service}, go to the canary by its weight and to
+// the stable release otherwise. Everything else, the agents' sockets
+// included, stays on the stable release, and tool registrations are copied
+// to the canary so both have the same tools.
+type canaryProxy struct {
+	service        string
+	config         Canary
+	stable, canary *httputil.ReverseProxy
+	client         *http.Client
+
+	mu     sync.Mutex
+	status CanaryStatus
+}
+
+func newCanaryProxy(service Service) (*canaryProxy, error) {
+	stableURL, err := url.Parse(service.URL)
+	if err != nil {
+		return nil, err
+	}
+	canaryURL, err := url.Parse(service.Canary.URL)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	p := &canaryProxy{
+		service: service.Name,
+		config:  *service.Canary,
+		stable:  httputil.NewSingleHostReverseProxy(stableURL),
+		canary:  httputil.NewSingleHostReverseProxy(canaryURL),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		status: CanaryStatus{Service: service.Name, URL: service.Canary.URL, Proxy: service.Canary.Proxy,
+			State: canaryWatching, Weight: service.Canary.Weight, StartedAt: now,
+			EndsAt: now.Add(time.Duration(service.Canary.WindowSeconds) * time.Second), SLO: *service.Canary},
+	}
+	for _, proxy := range []*httputil.ReverseProxy{p.stable, p.canary} {
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			writeError(w, http.StatusBadGateway, err.Error())
+		}
+	}
+	return p, nil
+}
+
+func (p *canaryProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/"):
+		p.invoke(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/tools/register",
+		r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/tools/"):
+		p.register(w, r)
+	default:
+		p.stable.ServeHTTP(w, r)
+	}
+}
+
+// invoke sends an invocation to one side by the canary's weight and
+// records how it went. Only a 5xx answer is a failure: a 4xx is the
+// caller's.
+func (p *canaryProxy) invoke(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	toCanary := rand.Float64()*100 < p.status.Weight
+	p.mu.Unlock()
+	proxy := p.stable
+	if toCanary {
+		proxy = p.canary
+	}
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	proxy.ServeHTTP(recorder, r)
+	latency := time.Since(start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !toCanary {
+		p.status.Stable.record(latency, recorder.status >= 500)
+		return
+	}
+	p.status.Canary.record(latency, recorder.status >= 500)
+	p.judge()
+}
+
+// judge rolls the canary back if it broke an SLO within its window, and
+// passes it once the window is over with enough invocations to go by. It
+// is called with mu held.
+func (p *canaryProxy) judge() {
+	if p.status.State != canaryWatching {
+		return
+	}
+	stats := &p.status.Canary
+	if stats.Invocations < p.config.MinRequests {
+		return
+	}
+	stats.p95()
+	switch {
+	case stats.ErrorRate > p.config.MaxErrorRate:
+		p.end(canaryRolledBack, fmt.Sprintf("%d of %d invocations failed, over the %.1f%% allowed",
+			stats.Errors, stats.Invocations, p.config.MaxErrorRate*100))
+	case stats.P95MS > int64(p.config.MaxP95MS):
+		p.end(canaryRolledBack, fmt.Sprintf("p95 latency of %dms over %d invocations, over the %dms allowed",
+			stats.P95MS, stats.Invocations, p.config.MaxP95MS))
+	case !time.Now().Before(p.status.EndsAt):
+		p.status.State = canaryPassed
+		log.Printf("canary of %s passed: %d invocations within its SLOs", p.service, stats.Invocations)
+	}
+}
+
+// end promotes the canary, sending it every invocation, or rolls it back,
+// sending it none. It is called with mu held.
+func (p *canaryProxy) end(state, reason string) {
+	now := time.Now().UTC()
+	p.status.State, p.status.Reason, p.status.EndedAt = state, reason, &now
+	p.status.Weight = 0
+	if state == canaryPromoted {
+		p.status.Weight = 100
+	}
+	log.Printf("canary of %s %s: %s", p.service, state, reason)
+}
+
+// Promote sends every invocation to the canary.
+func (p *canaryProxy) Promote() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status.State == canaryPromoted || p.status.State == canaryRolledBack {
+		return fmt.Errorf("%w: it was %s", errCanaryOver, p.status.State)
+	}
+	p.end(canaryPromoted, "promoted by hand")
+	return nil
+}
+
+// Rollback sends every invocation to the stable release.
+func (p *canaryProxy) Rollback() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status.State == canaryRolledBack {
+		return fmt.Errorf("%w: it was %s", errCanaryOver, p.status.State)
+	}
+	p.end(canaryRolledBack, "rolled back by hand")
+	return nil
+}
+
+// Status is how the canary is doing, judged again so a window that ran out
+// without traffic still shows.
+func (p *canaryProxy) Status() CanaryStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.judge()
+	p.status.Stable.p95()
+	p.status.Canary.p95()
+	status := p.status
+	status.Stable.latencies, status.Canary.latencies = nil, nil
+	return status
+}
+
+// register copies a tool registration or removal to the canary, unless it
+// was rolled back, and answers with the stable release's answer. A canary
+// that refuses it is logged, as its invocations of the tool will differ.
+func (p *canaryProxy) register(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p.mu.Lock()
+	state := p.status.State
+	p.mu.Unlock()
+	if state != canaryRolledBack {
+		req, err := http.NewRequestWithContext(r.Context(), r.Method, p.config.URL+r.URL.RequestURI(), bytes.NewReader(body))
+		if err == nil {
+			req.Header = r.Header.Clone()
+			var resp *http.Response
+			if resp, err = p.client.Do(req); err == nil {
+				resp.Body.Close()
+				if resp.StatusCode >= 300 {
+					err = fmt.Errorf("HTTP %d", resp.StatusCode)
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("canary of %s did not take %s %s: %v", p.service, r.Method, r.URL.Path, err)
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	p.stable.ServeHTTP(w, r)
+}
+
+// statusRecorder keeps the status code a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets the reverse proxy flush through the recorder.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
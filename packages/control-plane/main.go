@@ -0,0 +1,182 @@
+// Command control-plane runs a deployment of the dynamic context system: it
+// starts the MCP server, knowledge graph, session memory and orchestrator
+// of a topology in the order they need each other, tells each where the
+// others are, keeps them running and reports on all of them through one
+// status API. It also backs the services' state up to one archive and
+// restores it, and tries new releases of the MCP server on a share of
+// tool invocations. See README.md.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/logging"
+)
+
+func main() {
+	if len(os.Args) == 3 && os.Args[1] == "validate" {
+		topology, err := loadTopology(os.Args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "control-plane: %v\n", err)
+			os.Exit(1)
+		}
+		for _, service := range topology.Services {
+			fmt.Printf("%s (%s) at %s\n", service.Name, service.Kind, service.URL)
+		}
+		return
+	}
+	if len(os.Args) == 4 && (os.Args[1] == "backup" || os.Args[1] == "restore") {
+		if err := backupCommand(os.Args[1], os.Args[2], os.Args[3]); err != nil {
+			fmt.Fprintf(os.Stderr, "control-plane: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := run(); err != nil {
+		log.Fatalf("control-plane: %v", err)
+	}
+}
+
+// backupCommand backs the deployment a topology describes up to an
+// archive, or restores one to it.
+func backupCommand(command, topologyPath, archive string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	topology, err := loadTopology(topologyPath)
+	if err != nil {
+		return err
+	}
+	if command == "backup" {
+		manifest, err := Backup(ctx, topology, archive)
+		if err != nil {
+			return err
+		}
+		for _, part := range manifest.Parts {
+			fmt.Printf("%s (%s): %d bytes in %s\n", part.Service, part.Kind, part.Bytes, part.File)
+		}
+		fmt.Printf("backed up to %s\n", archive)
+		return nil
+	}
+	results, err := Restore(ctx, topology, archive)
+	for _, service := range topology.Services {
+		if result, ok := results[service.Name]; ok {
+			fmt.Printf("%s: %s\n", service.Name, result)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored from %s\n", archive)
+	return nil
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getenvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, value)
+	}
+	return n, nil
+}
+
+func run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := logging.FromEnv("control-plane")
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		logger.Shutdown(shutdownCtx)
+	}()
+
+	path := os.Getenv("CONTROL_TOPOLOGY")
+	if path == "" {
+		return errors.New("CONTROL_TOPOLOGY must name a topology file")
+	}
+	topology, err := loadTopology(path)
+	if err != nil {
+		return fmt.Errorf("loading topology: %w", err)
+	}
+	interval, err := getenvInt("CONTROL_CHECK_INTERVAL", 5)
+	if err != nil {
+		return err
+	}
+	stopTimeout, err := getenvInt("CONTROL_STOP_TIMEOUT", 10)
+	if err != nil {
+		return err
+	}
+	if interval == 0 {
+		return errors.New("CONTROL_CHECK_INTERVAL must be at least 1 second")
+	}
+
+	supervisor := newSupervisor(topology, time.Duration(interval)*time.Second, time.Duration(stopTimeout)*time.Second, os.Stdout)
+	s := &server{topology: topology, supervisor: supervisor, started: time.Now()}
+	httpServer := &http.Server{
+		Addr:              ":" + getenv("CONTROL_PORT", "8060"),
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	servers := []*http.Server{httpServer}
+	for _, service := range topology.Services {
+		if service.Canary == nil {
+			continue
+		}
+		if s.canary, err = newCanaryProxy(service); err != nil {
+			return fmt.Errorf("canary of %s: %w", service.Name, err)
+		}
+		addr, _ := canaryListenAddr(service.Canary.Proxy)
+		servers = append(servers, &http.Server{Addr: addr, Handler: s.canary, ReadHeaderTimeout: 10 * time.Second})
+	}
+
+	errs := make(chan error, len(servers))
+	log.Printf("control plane listening on %s (%d services, %s)", httpServer.Addr, len(topology.Services), logger)
+	if s.canary != nil {
+		status := s.canary.Status()
+		log.Printf("canary of %s at %s takes %v%% of invocations through %s", status.Service, status.URL, status.Weight, status.Proxy)
+	}
+	for _, server := range servers {
+		go func() {
+			errs <- server.ListenAndServe()
+		}()
+	}
+	// The status API is up before the services, so a slow start shows in it
+	supervisor.Start()
+
+	var serveErr error
+	select {
+	case err := <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr = err
+		}
+	case <-ctx.Done():
+	}
+	supervisor.Stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil && serveErr == nil {
+			serveErr = err
+		}
+	}
+	return serveErr
+}
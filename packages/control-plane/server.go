@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// server exposes the status of the deployment and its services over HTTP.
+type server struct {
+	topology   Topology
+	supervisor *Supervisor
+	canary     *canaryProxy
+	started    time.Time
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", s.health)
+	mux.HandleFunc("GET /status", s.status)
+	mux.HandleFunc("GET /healthz/system", s.systemHealth)
+	mux.HandleFunc("GET /services", s.listServices)
+	mux.HandleFunc("GET /services/{name}", s.getService)
+	mux.HandleFunc("POST /services/{name}/restart", s.restartService)
+	mux.HandleFunc("GET /discovery", s.discovery)
+	mux.HandleFunc("GET /canary", s.getCanary)
+	mux.HandleFunc("POST /canary/promote", s.endCanary)
+	mux.HandleFunc("POST /canary/rollback", s.endCanary)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]any{"detail": detail})
+}
+
+func (s *server) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "healthy", "services": len(s.topology.Services)})
+}
+
+// SystemStatus sums the deployment up from the services' last checks.
+type SystemStatus struct {
+	Status        string          `json:"status"`
+	UptimeSeconds int             `json:"uptime_seconds"`
+	States        map[string]int  `json:"states"`
+	Services      []ServiceStatus `json:"services"`
+}
+
+// summarize is the deployment's status given its services': healthy when
+// every service is, down when none is, and degraded in between.
+func summarize(statuses []ServiceStatus) (string, map[string]int) {
+	counts, healthy := map[string]int{}, 0
+	for _, status := range statuses {
+		counts[status.State]++
+		if status.State == stateHealthy {
+			healthy++
+		}
+	}
+	switch healthy {
+	case len(statuses):
+		return "healthy", counts
+	case 0:
+		return "down", counts
+	}
+	return "degraded", counts
+}
+
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	statuses := s.supervisor.Statuses()
+	system, counts := summarize(statuses)
+	writeJSON(w, http.StatusOK, SystemStatus{Status: system, UptimeSeconds: int(time.Since(s.started).Seconds()),
+		States: counts, Services: statuses})
+}
+
+// SystemHealth is the deployment's health as checked for one request, with
+// each service's check and the services that failed theirs.
+type SystemHealth struct {
+	Status       string          `json:"status"`
+	CheckedAt    time.Time       `json:"checked_at"`
+	DurationMS   int64           `json:"duration_ms"`
+	Unhealthy    []string        `json:"unhealthy"`
+	Dependencies []ServiceStatus `json:"dependencies"`
+}
+
+// systemHealth checks every service before answering, for load balancers
+// and Kubernetes probes, which go by the status code: 200 when every
+// service is healthy and 503 otherwise. With degraded=ok a deployment that
+// is only degraded answers 200 too, for a probe that should only fail once
+// nothing works.
+func (s *server) systemHealth(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	statuses := s.supervisor.CheckAll(r.Context())
+	system, _ := summarize(statuses)
+	unhealthy := []string{}
+	for _, status := range statuses {
+		if status.State != stateHealthy {
+			unhealthy = append(unhealthy, status.Name)
+		}
+	}
+	code := http.StatusServiceUnavailable
+	if system == "healthy" || (system == "degraded" && r.URL.Query().Get("degraded") == "ok") {
+		code = http.StatusOK
+	}
+	writeJSON(w, code, SystemHealth{Status: system, CheckedAt: start.UTC(),
+		DurationMS: time.Since(start).Milliseconds(), Unhealthy: unhealthy, Dependencies: statuses})
+}
+
+func (s *server) listServices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"services": s.supervisor.Statuses()})
+}
+
+func (s *server) getService(w http.ResponseWriter, r *http.Request) {
+	status, err := s.supervisor.Status(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *server) restartService(w http.ResponseWriter, r *http.Request) {
+	err := s.supervisor.Restart(r.PathValue("name"))
+	switch {
+	case errors.Is(err, errUnknownService):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		status, _ := s.supervisor.Status(r.PathValue("name"))
+		writeJSON(w, http.StatusAccepted, status)
+	}
+}
+
+// discovery is where each service is, by the variable the services find it
+// in, for clients outside the deployment.
+func (s *server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.topology.addresses())
+}
+
+func (s *server) getCanary(w http.ResponseWriter, r *http.Request) {
+	if s.canary == nil {
+		writeError(w, http.StatusNotFound, "the topology has no canary")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.canary.Status())
+}
+
+// endCanary promotes or rolls back the canary by hand, whatever its SLOs
+// say.
+func (s *server) endCanary(w http.ResponseWriter, r *http.Request) {
+	if s.canary == nil {
+		writeError(w, http.StatusNotFound, "the topology has no canary")
+		return
+	}
+	end := s.canary.Rollback
+	if strings.HasSuffix(r.URL.Path, "/promote") {
+		end = s.canary.Promote
+	}
+	if err := end(); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, s.canary.Status())
+}
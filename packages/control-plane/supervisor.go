@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	errUnknownService = errors.New("unknown service")
+	errNotManaged     = errors.New("service is not started by the control plane")
+)
+
+// Service states.
+const (
+	// stateWaiting is a service waiting for the ones it needs to be healthy.
+	stateWaiting = "waiting"
+	// stateStarting is a started service that has not yet passed a check.
+	stateStarting  = "starting"
+	stateHealthy   = "healthy"
+	stateUnhealthy = "unhealthy"
+	// stateRestarting is a service that exited and starts again after a
+	// backoff.
+	stateRestarting = "restarting"
+	stateExited     = "exited"
+	stateStopped    = "stopped"
+)
+
+// ServiceStatus is what the control plane knows of a service: its state,
+// its process when it runs one and its last health check, with the body
+// the service answered it with.
+type ServiceStatus struct {
+	Name      string         `json:"name"`
+	Kind      string         `json:"kind"`
+	URL       string         `json:"url"`
+	Managed   bool           `json:"managed"`
+	Needs     []string       `json:"needs,omitempty"`
+	State     string         `json:"state"`
+	PID       int            `json:"pid,omitempty"`
+	Restarts  int            `json:"restarts"`
+	StartedAt *time.Time     `json:"started_at,omitempty"`
+	CheckedAt *time.Time     `json:"checked_at,omitempty"`
+	LatencyMS int64          `json:"latency_ms,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Health    map[string]any `json:"health,omitempty"`
+}
+
+// process is a running service's child process.
+type process struct {
+	cmd *exec.Cmd
+	// restart is set when the process was stopped to be started again,
+	// whatever the service's restart policy.
+	restart bool
+}
+
+// Supervisor brings a topology up in order, keeps the services it starts
+// running and checks every service's health. Services are stopped in the
+// reverse of the order they started.
+type Supervisor struct {
+	topology    Topology
+	client      *http.Client
+	interval    time.Duration
+	stopTimeout time.Duration
+	logs        io.Writer
+
+	mu        sync.RWMutex
+	statuses  map[string]*ServiceStatus
+	processes map[string]*process
+	// stops ends each service's supervision, and done is closed once it has.
+	stops map[string]context.CancelFunc
+	done  map[string]chan struct{}
+	logMu sync.Mutex
+}
+
+func newSupervisor(topology Topology, interval, stopTimeout time.Duration, logs io.Writer) *Supervisor {
+	s := &Supervisor{
+		topology:    topology,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		interval:    interval,
+		stopTimeout: stopTimeout,
+		logs:        logs,
+		statuses:    map[string]*ServiceStatus{},
+		processes:   map[string]*process{},
+		stops:       map[string]context.CancelFunc{},
+		done:        map[string]chan struct{}{},
+	}
+	for _, service := range topology.Services {
+		s.statuses[service.Name] = &ServiceStatus{Name: service.Name, Kind: service.Kind, URL: service.URL,
+			Managed: service.managed(), Needs: service.Needs, State: stateWaiting}
+	}
+	return s
+}
+
+// Start supervises every service until Stop: each one is started, or for
+// one that runs elsewhere watched, once the services it needs are healthy.
+func (s *Supervisor) Start() {
+	for _, service := range s.topology.Services {
+		serviceCtx, stop := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		s.mu.Lock()
+		s.stops[service.Name], s.done[service.Name] = stop, done
+		s.mu.Unlock()
+		go func() {
+			defer close(done)
+			s.supervise(serviceCtx, service)
+		}()
+	}
+}
+
+// Stop stops the services, those that need others first, each within the
+// stop timeout.
+func (s *Supervisor) Stop() {
+	for i := len(s.topology.Services) - 1; i >= 0; i-- {
+		name := s.topology.Services[i].Name
+		s.mu.RLock()
+		stop, done := s.stops[name], s.done[name]
+		s.mu.RUnlock()
+		if stop == nil {
+			continue
+		}
+		stop()
+		<-done
+		log.Printf("stopped %s", name)
+	}
+}
+
+func (s *Supervisor) supervise(ctx context.Context, service Service) {
+	if !s.waitForNeeds(ctx, service) {
+		s.update(service.Name, func(status *ServiceStatus) { status.State, status.Error = stateStopped, "" })
+		return
+	}
+	if service.managed() {
+		go s.check(ctx, service)
+		s.run(ctx, service)
+		return
+	}
+	s.update(service.Name, func(status *ServiceStatus) { status.State = stateStarting })
+	s.check(ctx, service)
+	s.update(service.Name, func(status *ServiceStatus) { status.State = stateStopped })
+}
+
+// waitForNeeds waits until every service this one needs is healthy,
+// reporting false if ctx ended first.
+func (s *Supervisor) waitForNeeds(ctx context.Context, service Service) bool {
+	for {
+		var waiting []string
+		s.mu.RLock()
+		for _, need := range service.Needs {
+			if s.statuses[need].State != stateHealthy {
+				waiting = append(waiting, need)
+			}
+		}
+		s.mu.RUnlock()
+		if len(waiting) == 0 {
+			s.update(service.Name, func(status *ServiceStatus) { status.Error = "" })
+			return true
+		}
+		s.update(service.Name, func(status *ServiceStatus) {
+			status.Error = "waiting for " + strings.Join(waiting, ", ")
+		})
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(s.interval / 5):
+		}
+	}
+}
+
+// run starts a service's process and starts it again when it exits, as its
+// restart policy says, backing off while it keeps failing.
+func (s *Supervisor) run(ctx context.Context, service Service) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		restart, err := s.runOnce(ctx, service)
+		if ctx.Err() != nil {
+			s.update(service.Name, func(status *ServiceStatus) { status.State, status.PID = stateStopped, 0 })
+			return
+		}
+		message := "exited"
+		switch {
+		case restart:
+			message = "was stopped to restart"
+		case err != nil:
+			message = err.Error()
+		}
+		if !restart && (service.Restart == restartNever || (service.Restart == restartOnFailure && err == nil)) {
+			s.update(service.Name, func(status *ServiceStatus) {
+				status.State, status.PID, status.Error = stateExited, 0, message
+			})
+			log.Printf("%s %s; not restarting it", service.Name, message)
+			return
+		}
+		// A service that ran a while before failing starts afresh
+		if time.Since(started) > time.Minute || restart {
+			backoff = time.Second
+		}
+		s.update(service.Name, func(status *ServiceStatus) {
+			status.State, status.PID, status.Error = stateRestarting, 0, message
+			status.Restarts++
+		})
+		log.Printf("%s %s; restarting it in %s", service.Name, message, backoff)
+		select {
+		case <-ctx.Done():
+			s.update(service.Name, func(status *ServiceStatus) { status.State = stateStopped })
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// runOnce runs a service's process until it exits or ctx ends, reporting
+// whether it was stopped to be restarted.
+func (s *Supervisor) runOnce(ctx context.Context, service Service) (bool, error) {
+	cmd := exec.CommandContext(ctx, service.Command[0], service.Command[1:]...)
+	cmd.Dir = service.Dir
+	cmd.Env = os.Environ()
+	discovery := s.topology.discovery(service)
+	for _, name := range sortedKeys(discovery) {
+		cmd.Env = append(cmd.Env, name+"="+discovery[name])
+	}
+	for _, name := range sortedKeys(service.Env) {
+		cmd.Env = append(cmd.Env, name+"="+service.Env[name])
+	}
+	cmd.Stdout = &prefixWriter{mu: &s.logMu, out: s.logs, prefix: service.Name}
+	cmd.Stderr = cmd.Stdout
+	// The service and anything it starts stop together, gracefully first
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM) }
+	cmd.WaitDelay = s.stopTimeout
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	proc := &process{cmd: cmd}
+	s.mu.Lock()
+	s.processes[service.Name] = proc
+	s.mu.Unlock()
+	s.update(service.Name, func(status *ServiceStatus) {
+		status.State, status.PID, status.StartedAt = stateStarting, cmd.Process.Pid, &now
+	})
+	log.Printf("started %s (pid %d)", service.Name, cmd.Process.Pid)
+
+	err := cmd.Wait()
+	s.mu.Lock()
+	delete(s.processes, service.Name)
+	restart := proc.restart
+	s.mu.Unlock()
+	if err != nil && cmd.ProcessState != nil && cmd.ProcessState.ExitCode() >= 0 {
+		err = fmt.Errorf("exited with status %d", cmd.ProcessState.ExitCode())
+	}
+	return restart, err
+}
+
+// Restart stops a service's process so it starts again, whatever its
+// restart policy.
+func (s *Supervisor) Restart(name string) error {
+	service, ok := s.topology.service(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", errUnknownService, name)
+	}
+	if !service.managed() {
+		return fmt.Errorf("%w: %s", errNotManaged, name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	proc, ok := s.processes[name]
+	if !ok {
+		return fmt.Errorf("%s is not running", name)
+	}
+	proc.restart = true
+	return syscall.Kill(-proc.cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// check checks a service's health every interval until ctx ends.
+func (s *Supervisor) check(ctx context.Context, service Service) {
+	for {
+		s.checkOnce(ctx, service)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *Supervisor) checkOnce(ctx context.Context, service Service) {
+	s.mu.RLock()
+	_, running := s.processes[service.Name]
+	s.mu.RUnlock()
+	if service.managed() && !running {
+		return
+	}
+	start := time.Now()
+	health, err := s.probe(ctx, service.URL+service.Health)
+	if ctx.Err() != nil {
+		return
+	}
+	checked := time.Now().UTC()
+	s.update(service.Name, func(status *ServiceStatus) {
+		status.CheckedAt, status.LatencyMS, status.Health = &checked, time.Since(start).Milliseconds(), health
+		switch {
+		case err == nil:
+			status.State, status.Error = stateHealthy, ""
+		case status.State == stateStarting && service.managed():
+			// Still coming up
+			status.Error = err.Error()
+		default:
+			status.State, status.Error = stateUnhealthy, err.Error()
+		}
+	})
+}
+
+// CheckAll checks every service's health at once rather than waiting for
+// its next check, and reports their statuses after.
+func (s *Supervisor) CheckAll(ctx context.Context) []ServiceStatus {
+	var wg sync.WaitGroup
+	for _, service := range s.topology.Services {
+		s.mu.RLock()
+		state := s.statuses[service.Name].State
+		s.mu.RUnlock()
+		// One not yet started or since stopped has nothing to answer
+		if state == stateWaiting || state == stateStopped {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.checkOnce(ctx, service)
+		}()
+	}
+	wg.Wait()
+	return s.Statuses()
+}
+
+// probe asks a service's health endpoint, returning the JSON object it
+// answered with, if any.
+func (s *Supervisor) probe(ctx context.Context, url string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var health map[string]any
+	json.Unmarshal(body, &health)
+	if resp.StatusCode >= 300 {
+		return health, fmt.Errorf("health check answered HTTP %d", resp.StatusCode)
+	}
+	return health, nil
+}
+
+func (s *Supervisor) update(name string, change func(*ServiceStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(s.statuses[name])
+}
+
+// Status is one service's status.
+func (s *Supervisor) Status(name string) (ServiceStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status, ok := s.statuses[name]
+	if !ok {
+		return ServiceStatus{}, fmt.Errorf("%w: %s", errUnknownService, name)
+	}
+	return *status, nil
+}
+
+// Statuses lists every service's status in start order.
+func (s *Supervisor) Statuses() []ServiceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	statuses := make([]ServiceStatus, 0, len(s.topology.Services))
+	for _, service := range s.topology.Services {
+		statuses = append(statuses, *s.statuses[service.Name])
+	}
+	return statuses
+}
+
+// prefixWriter copies a service's output to the control plane's, each line
+// marked with the service's name.
+type prefixWriter struct {
+	mu      *sync.Mutex
+	out     io.Writer
+	prefix  string
+	partial []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		line, rest, found := bytes.Cut(w.partial, []byte("\n"))
+		if !found {
+			break
+		}
+		w.mu.Lock()
+		fmt.Fprintf(w.out, "[%s] %s\n", w.prefix, line)
+		w.mu.Unlock()
+		w.partial = rest
+	}
+	return len(p), nil
+}
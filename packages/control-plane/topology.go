@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var errInvalidTopology = errors.New("invalid topology")
+
+var serviceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// discoveryVars are the environment variables each kind of service is found
+// in by the services that need it. The MCP server reads SESSION_MEMORY_URL,
+// the orchestrator MCP_SERVER_URL, session memory and the agents
+// KNOWLEDGE_GRAPH_URL.
+var discoveryVars = map[string]string{
+	"mcp":          "MCP_SERVER_URL",
+	"graph":        "KNOWLEDGE_GRAPH_URL",
+	"memory":       "SESSION_MEMORY_URL",
+	"orchestrator": "ORCHESTRATOR_URL",
+}
+
+// Restart policies for services the control plane starts.
+const (
+	restartAlways    = "always"
+	restartOnFailure = "on-failure"
+	restartNever     = "never"
+)
+
+// Service is one component of a deployment. With a Command the control
+// plane runs it as a child process, restarting it by its Restart policy;
+// without one it runs elsewhere, as a container or on another host, and is
+// only watched. Either way it is checked at URL plus Health, and the
+// services that name it in Needs start once it is healthy and find its URL
+// in their environment.
+type Service struct {
+	Name    string            `json:"name"`
+	Kind    string            `json:"kind"`
+	URL     string            `json:"url"`
+	Command []string          `json:"command,omitempty"`
+	Dir     string            `json:"dir,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Needs   []string          `json:"needs,omitempty"`
+	Health  string            `json:"health,omitempty"`
+	Restart string            `json:"restart,omitempty"`
+	Canary  *Canary           `json:"canary,omitempty"`
+}
+
+// Canary is a new release of the MCP server tried on a share of tool
+// invocations before it takes them all. The control plane listens on
+// Proxy's port and splits invocations between the service's URL and the
+// canary's; the services that need the MCP server are pointed at Proxy.
+// Within the canary's first WindowSeconds, or until it has served
+// MinRequests invocations if that takes longer, it is rolled back as soon
+// as more than MaxErrorRate of its invocations fail or its p95 latency is
+// over MaxP95MS.
+type Canary struct {
+	URL           string  `json:"url"`
+	Proxy         string  `json:"proxy"`
+	Weight        float64 `json:"weight,omitempty"`
+	WindowSeconds int     `json:"window_seconds,omitempty"`
+	MinRequests   int     `json:"min_requests,omitempty"`
+	MaxErrorRate  float64 `json:"max_error_rate,omitempty"`
+	MaxP95MS      int     `json:"max_p95_ms,omitempty"`
+}
+
+func (s Service) managed() bool {
+	return len(s.Command) > 0
+}
+
+// Topology is the services of a deployment, in the order they start:
+// every service after the ones it needs.
+type Topology struct {
+	Services []Service `json:"services"`
+}
+
+// loadTopology reads and checks a topology file.
+func loadTopology(path string) (Topology, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Topology{}, err
+	}
+	return parseTopology(raw)
+}
+
+func parseTopology(raw []byte) (Topology, error) {
+	var topology Topology
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&topology); err != nil {
+		return Topology{}, fmt.Errorf("%w: %v", errInvalidTopology, err)
+	}
+	if len(topology.Services) == 0 {
+		return Topology{}, fmt.Errorf("%w: no services", errInvalidTopology)
+	}
+	byName, byKind := map[string]int{}, map[string]string{}
+	for i := range topology.Services {
+		service := &topology.Services[i]
+		if !serviceNamePattern.MatchString(service.Name) {
+			return Topology{}, fmt.Errorf("%w: service name %q must be lowercase letters, digits, _ and -", errInvalidTopology, service.Name)
+		}
+		if _, ok := byName[service.Name]; ok {
+			return Topology{}, fmt.Errorf("%w: two services are called %s", errInvalidTopology, service.Name)
+		}
+		byName[service.Name] = i
+		if _, ok := discoveryVars[service.Kind]; !ok {
+			return Topology{}, fmt.Errorf("%w: %s: kind %q is not mcp, graph, memory or orchestrator", errInvalidTopology, service.Name, service.Kind)
+		}
+		// Discovery goes by kind, so each kind names one service
+		if other, ok := byKind[service.Kind]; ok {
+			return Topology{}, fmt.Errorf("%w: %s and %s are both of kind %s", errInvalidTopology, other, service.Name, service.Kind)
+		}
+		byKind[service.Kind] = service.Name
+		if u, err := url.Parse(service.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Topology{}, fmt.Errorf("%w: %s: url %q is not an http(s) URL", errInvalidTopology, service.Name, service.URL)
+		}
+		service.URL = strings.TrimRight(service.URL, "/")
+		if service.Health == "" {
+			service.Health = "/health"
+		}
+		if !strings.HasPrefix(service.Health, "/") {
+			return Topology{}, fmt.Errorf("%w: %s: health %q is not a path", errInvalidTopology, service.Name, service.Health)
+		}
+		if service.Canary != nil {
+			if err := checkCanary(service); err != nil {
+				return Topology{}, err
+			}
+		}
+		switch service.Restart {
+		case "":
+			service.Restart = restartAlways
+		case restartAlways, restartOnFailure, restartNever:
+		default:
+			return Topology{}, fmt.Errorf("%w: %s: restart is always, on-failure or never, not %q", errInvalidTopology, service.Name, service.Restart)
+		}
+	}
+	for _, service := range topology.Services {
+		for _, need := range service.Needs {
+			if _, ok := byName[need]; !ok || need == service.Name {
+				return Topology{}, fmt.Errorf("%w: %s needs %q, which is not another service", errInvalidTopology, service.Name, need)
+			}
+		}
+	}
+	ordered, err := startOrder(topology.Services, byName)
+	if err != nil {
+		return Topology{}, err
+	}
+	topology.Services = ordered
+	return topology, nil
+}
+
+// checkCanary checks a service's canary and fills in its defaults: 5% of
+// invocations for ten minutes, judged on at least 20 of them, with up to 5%
+// failing and a p95 latency of up to two seconds.
+func checkCanary(service *Service) error {
+	canary := service.Canary
+	if service.Kind != "mcp" {
+		return fmt.Errorf("%w: %s: only the mcp service can have a canary", errInvalidTopology, service.Name)
+	}
+	for _, field := range [][2]string{{"url", canary.URL}, {"proxy", canary.Proxy}} {
+		if u, err := url.Parse(field[1]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: %s: canary %s %q is not an http(s) URL", errInvalidTopology, service.Name, field[0], field[1])
+		}
+	}
+	canary.URL, canary.Proxy = strings.TrimRight(canary.URL, "/"), strings.TrimRight(canary.Proxy, "/")
+	if _, err := canaryListenAddr(canary.Proxy); err != nil {
+		return fmt.Errorf("%w: %s: canary proxy %q has no port to listen on", errInvalidTopology, service.Name, canary.Proxy)
+	}
+	if canary.URL == service.URL || canary.Proxy == service.URL || canary.Proxy == canary.URL {
+		return fmt.Errorf("%w: %s: the service, its canary and the proxy need URLs of their own", errInvalidTopology, service.Name)
+	}
+	if canary.Weight == 0 {
+		canary.Weight = 5
+	}
+	if canary.WindowSeconds == 0 {
+		canary.WindowSeconds = 600
+	}
+	if canary.MinRequests == 0 {
+		canary.MinRequests = 20
+	}
+	if canary.MaxErrorRate == 0 {
+		canary.MaxErrorRate = 0.05
+	}
+	if canary.MaxP95MS == 0 {
+		canary.MaxP95MS = 2000
+	}
+	switch {
+	case canary.Weight < 0 || canary.Weight > 100:
+		return fmt.Errorf("%w: %s: canary weight is a percentage, not %v", errInvalidTopology, service.Name, canary.Weight)
+	case canary.WindowSeconds < 0 || canary.MinRequests < 0 || canary.MaxP95MS < 0:
+		return fmt.Errorf("%w: %s: canary window_seconds, min_requests and max_p95_ms cannot be negative", errInvalidTopology, service.Name)
+	case canary.MaxErrorRate < 0 || canary.MaxErrorRate > 1:
+		return fmt.Errorf("%w: %s: canary max_error_rate is a fraction, not %v", errInvalidTopology, service.Name, canary.MaxErrorRate)
+	}
+	return nil
+}
+
+// canaryListenAddr is the address the control plane listens on for a
+// canary proxy: any interface, on the proxy URL's port.
+func canaryListenAddr(proxy string) (string, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return "", err
+	}
+	_, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return "", err
+	}
+	if port == "" {
+		return "", errors.New("no port")
+	}
+	return ":" + port, nil
+}
+
+// startOrder sorts services so each comes after the ones it needs, keeping
+// the file's order otherwise, and refuses a cycle.
+func startOrder(services []Service, byName map[string]int) ([]Service, error) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	marks := make([]int, len(services))
+	var ordered []Service
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		switch marks[i] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("%w: services need each other: %s", errInvalidTopology, strings.Join(append(path, services[i].Name), " -> "))
+		}
+		marks[i] = visiting
+		for _, need := range services[i].Needs {
+			if err := visit(byName[need], append(path, services[i].Name)); err != nil {
+				return err
+			}
+		}
+		marks[i] = done
+		ordered = append(ordered, services[i])
+		return nil
+	}
+	for i := range services {
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// discovery is the environment that tells a service where the services it
+// needs are.
+func (t Topology) discovery(service Service) map[string]string {
+	env := map[string]string{}
+	for _, need := range service.Needs {
+		other, _ := t.service(need)
+		env[discoveryVars[other.Kind]] = other.endpoint()
+	}
+	return env
+}
+
+// addresses is where every service of the deployment is, by discovery
+// variable, for clients outside it.
+func (t Topology) addresses() map[string]string {
+	env := map[string]string{}
+	for _, service := range t.Services {
+		env[discoveryVars[service.Kind]] = service.endpoint()
+	}
+	return env
+}
+
+// endpoint is where other services reach a service: its canary proxy when
+// it has one, else its URL.
+func (s Service) endpoint() string {
+	if s.Canary != nil {
+		return s.Canary.Proxy
+	}
+	return s.URL
+}
+
+func (t Topology) service(name string) (Service, bool) {
+	for _, service := range t.Services {
+		if service.Name == name {
+			return service, true
+		}
+	}
+	return Service{}, false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
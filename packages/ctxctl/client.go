@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// client calls the HTTP APIs of a deployment's components, as the tenant
+// and with the token ctxctl was given.
+type client struct {
+	mcp          string
+	graph        string
+	orchestrator string
+	token        string
+	tenant       string
+	http         *http.Client
+}
+
+// do sends body, when there is one, as JSON and decodes the answer into v,
+// unless v is nil. An answer other than 2xx is an error with the
+// component's reason: detail from the Python and Go services, error from
+// the MCP server.
+func (c client) do(method, url string, body, v any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.tenant)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var reason struct {
+			Detail any    `json:"detail"`
+			Error  string `json:"error"`
+		}
+		if json.Unmarshal(respBody, &reason) == nil {
+			if detail, ok := reason.Detail.(string); ok && detail != "" {
+				return fmt.Errorf("%s: %s", resp.Status, detail)
+			}
+			if reason.Error != "" {
+				return fmt.Errorf("%s: %s", resp.Status, reason.Error)
+			}
+		}
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, v)
+}
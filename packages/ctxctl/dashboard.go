@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/events"
+)
+
+// dashboardRecent is how many finished jobs, nodes and tool invocations the
+// dashboard keeps on screen.
+const dashboardRecent = 8
+
+// dashboardSubjects are the events the dashboard follows.
+var dashboardSubjects = []string{events.SubjectJobs, events.SubjectNodes, events.SubjectInvalidations,
+	events.SubjectResults, events.SubjectTools}
+
+// dashboard is what the dashboard shows, built up from the events it sees
+// and from what it asks the components.
+type dashboard struct {
+	bus string
+
+	mu            sync.Mutex
+	running       map[string]events.JobStatus
+	finished      []events.JobStatus
+	nodes         []seenNode
+	nodeTimes     []time.Time
+	nodeCount     int
+	invalidations int
+	results       int
+	tools         []seenTool
+	toolCount     int
+	memory        memoryStats
+	memoryErr     string
+	busLog        string
+}
+
+type seenNode struct {
+	at     time.Time
+	source string
+	node   events.Node
+}
+
+type seenTool struct {
+	at         time.Time
+	invocation events.ToolInvocation
+}
+
+// memoryStats is what the dashboard reads of session memory's /stats.
+type memoryStats struct {
+	Backend        string `json:"backend"`
+	ActiveSessions int    `json:"active_sessions"`
+	PinnedSessions int    `json:"pinned_sessions"`
+	TotalKeys      int    `json:"total_keys"`
+	Tenants        map[string]struct {
+		Sessions int   `json:"sessions"`
+		Bytes    int64 `json:"bytes"`
+	} `json:"tenants"`
+	LargestSessions []struct {
+		SessionID string `json:"session_id"`
+		Tenant    string `json:"tenant"`
+		Bytes     int64  `json:"bytes"`
+	} `json:"largest_sessions"`
+}
+
+// Write takes the event bus client's log, whose last line the dashboard
+// shows rather than letting it scroll over the screen.
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.busLog = strings.TrimSpace(string(p))
+	return len(p), nil
+}
+
+func (d *dashboard) handle(event events.Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch event.Type {
+	case events.SubjectJobs:
+		var job events.JobStatus
+		if err := event.Decode(&job); err != nil {
+			return err
+		}
+		if job.Status == "running" || job.Status == "retrying" {
+			d.running[job.JobID] = job
+			return nil
+		}
+		delete(d.running, job.JobID)
+		d.finished = recent(d.finished, job)
+	case events.SubjectNodes:
+		var node events.Node
+		if err := event.Decode(&node); err != nil {
+			return err
+		}
+		d.nodeCount++
+		d.nodeTimes = append(d.nodeTimes, event.Time)
+		d.nodes = recent(d.nodes, seenNode{event.Time, event.Source, node})
+	case events.SubjectInvalidations:
+		d.invalidations++
+	case events.SubjectResults:
+		d.results++
+	case events.SubjectTools:
+		var invocation events.ToolInvocation
+		if err := event.Decode(&invocation); err != nil {
+			return err
+		}
+		d.toolCount++
+		d.tools = recent(d.tools, seenTool{event.Time, invocation})
+	}
+	return nil
+}
+
+// recent appends item to the newest first list, keeping dashboardRecent.
+func recent[T any](list []T, item T) []T {
+	list = append([]T{item}, list...)
+	return list[:min(len(list), dashboardRecent)]
+}
+
+// pollMemory reads session memory's sizes through the MCP server.
+func (d *dashboard) pollMemory(c client) {
+	var stats memoryStats
+	err := c.do(http.MethodGet, c.mcp+"/v1/memory/stats?top=3", nil, &stats)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		d.memoryErr = err.Error()
+		return
+	}
+	d.memory, d.memoryErr = stats, ""
+}
+
+// render writes the dashboard as it is now.
+func (d *dashboard) render(out io.Writer, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintf(out, "ctxctl dashboard  %s  %s\n", d.bus, now.Format("15:04:05"))
+	if d.busLog != "" {
+		fmt.Fprintf(out, "  %s\n", d.busLog)
+	}
+
+	running := make([]events.JobStatus, 0, len(d.running))
+	for _, job := range d.running {
+		running = append(running, job)
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].JobID < running[j].JobID })
+	fmt.Fprintf(out, "\nAGENTS  %d running\n", len(running))
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  JOB\tAGENT\tTARGET\tTENANT\tSTATUS\tATTEMPT")
+	for _, job := range append(running, d.finished...) {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%d\n", job.JobID, job.AgentType, shorten(job.Target, 40),
+			tenantName(job.Tenant), job.Status, job.Attempt)
+	}
+	w.Flush()
+
+	// The rate is over the last ten seconds
+	cutoff := now.Add(-10 * time.Second)
+	i := sort.Search(len(d.nodeTimes), func(i int) bool { return d.nodeTimes[i].After(cutoff) })
+	d.nodeTimes = d.nodeTimes[i:]
+	fmt.Fprintf(out, "\nCONTEXT INTO THE GRAPH  %d nodes (%.1f/s)  %d invalidations  %d results\n",
+		d.nodeCount, float64(len(d.nodeTimes))/10, d.invalidations, d.results)
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  TIME\tSOURCE\tTENANT\tTYPE\tCONTENT")
+	for _, seen := range d.nodes {
+		kind, _ := seen.node.Data["type"].(string)
+		content, _ := seen.node.Data["content"].(string)
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", seen.at.Local().Format("15:04:05"), seen.source,
+			tenantName(seen.node.Tenant), kind, shorten(content, 60))
+	}
+	w.Flush()
+
+	fmt.Fprintln(out, "\nSESSION MEMORY")
+	switch {
+	case d.memoryErr != "":
+		fmt.Fprintf(out, "  unavailable: %s\n", d.memoryErr)
+	case d.memory.Backend == "":
+		fmt.Fprintln(out, "  waiting for session memory")
+	default:
+		fmt.Fprintf(out, "  %s  %d sessions  %d pinned  %d keys\n", d.memory.Backend, d.memory.ActiveSessions,
+			d.memory.PinnedSessions, d.memory.TotalKeys)
+		tenants := make([]string, 0, len(d.memory.Tenants))
+		for tenant := range d.memory.Tenants {
+			tenants = append(tenants, tenant)
+		}
+		sort.Strings(tenants)
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  TENANT\tSESSIONS\tSIZE")
+		for _, tenant := range tenants {
+			totals := d.memory.Tenants[tenant]
+			fmt.Fprintf(w, "  %s\t%d\t%s\n", tenant, totals.Sessions, size(totals.Bytes))
+		}
+		for _, session := range d.memory.LargestSessions {
+			fmt.Fprintf(w, "  largest: %s\t%s\t%s\n", session.SessionID, tenantName(session.Tenant), size(session.Bytes))
+		}
+		w.Flush()
+	}
+
+	fmt.Fprintf(out, "\nTOOL INVOCATIONS  %d\n", d.toolCount)
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  TIME\tTOOL\tTENANT\tSTATUS\tMS")
+	for _, seen := range d.tools {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%d\t%.0f\n", seen.at.Local().Format("15:04:05"), seen.invocation.Tool,
+			tenantName(seen.invocation.Tenant), seen.invocation.Status, seen.invocation.DurationMS)
+	}
+	w.Flush()
+}
+
+func tenantName(tenant string) string {
+	if tenant == "" {
+		return "default"
+	}
+	return tenant
+}
+
+// size is bytes for people.
+func size(bytes int64) string {
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
+
+// dashboard follows the event bus and redraws the dashboard every
+// refresh until interrupted or, with --duration, until that has passed.
+// When out is not a terminal each frame is written after the last, and
+// the last is written once more on the way out.
+func (c client) dashboard(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("dashboard", flag.ContinueOnError)
+	refresh := flags.Duration("refresh", time.Second, "")
+	duration := flags.Duration("duration", 0, "")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 || *refresh <= 0 {
+		return fmt.Errorf("bad arguments\n%s", usage)
+	}
+	busURL := os.Getenv("CTXCTL_BUS_URL")
+	if busURL == "" {
+		return fmt.Errorf("the dashboard follows the event bus; set CTXCTL_BUS_URL, such as nats://bus:4222")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	d := &dashboard{bus: redact(busURL), running: map[string]events.JobStatus{}}
+	logFlags, logOutput := log.Flags(), log.Writer()
+	log.SetFlags(0)
+	log.SetOutput(d)
+	defer func() {
+		log.SetFlags(logFlags)
+		log.SetOutput(logOutput)
+	}()
+	for _, subject := range dashboardSubjects {
+		// No queue group, so the services subscribed in theirs still get
+		// every event too
+		go events.Subscribe(ctx, busURL, "ctxctl-dashboard", subject, "", d.handle)
+	}
+
+	// Jobs that were running before the dashboard started
+	for _, status := range []string{"running", "retrying"} {
+		var list struct {
+			Jobs []job `json:"jobs"`
+		}
+		if err := c.do(http.MethodGet, c.orchestrator+"/jobs?status="+status, nil, &list); err != nil {
+			log.Printf("orchestrator: %v", err)
+			break
+		}
+		for _, j := range list.Jobs {
+			d.running[j.ID] = events.JobStatus{JobID: j.ID, SessionID: j.SessionID, AgentType: j.AgentType,
+				Target: j.Target, Status: j.Status}
+		}
+	}
+
+	terminal := false
+	if info, err := os.Stdout.Stat(); err == nil && out == os.Stdout {
+		terminal = info.Mode()&os.ModeCharDevice != 0
+	}
+	frame := func() {
+		if terminal {
+			// Home and clear, then draw
+			fmt.Fprint(out, "\x1b[H\x1b[2J")
+		}
+		d.render(out, time.Now())
+		if !terminal {
+			fmt.Fprintln(out)
+		}
+	}
+
+	ticker := time.NewTicker(*refresh)
+	defer ticker.Stop()
+	lastPoll := time.Time{}
+	for {
+		if time.Since(lastPoll) >= 5*time.Second {
+			d.pollMemory(c)
+			lastPoll = time.Now()
+		}
+		frame()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// What happened since the last frame
+			d.pollMemory(c)
+			frame()
+			return nil
+		}
+	}
+}
+
+// redact hides the password in a bus URL.
+func redact(rawURL string) string {
+	if at := strings.LastIndex(rawURL, "@"); at >= 0 {
+		if scheme := strings.Index(rawURL, "://"); scheme >= 0 && scheme < at {
+			return rawURL[:scheme+3] + "…" + rawURL[at:]
+		}
+	}
+	return rawURL
+}
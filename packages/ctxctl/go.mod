@@ -0,0 +1,7 @@
+module github.com/jayp41/dynamic-context-mcp-system/packages/ctxctl
+
+go 1.22
+
+require github.com/jayp41/dynamic-context-mcp-system/packages/events v0.0.0
+
+replace github.com/jayp41/dynamic-context-mcp-system/packages/events => ../events
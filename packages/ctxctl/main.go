@@ -0,0 +1,318 @@
+// Command ctxctl talks to a deployed dynamic context system: it lists
+// sessions and dumps their context, searches the knowledge graph, runs
+// agents and registers tools, and shows what a deployment is doing on a
+// dashboard in the terminal. Unlike the Dagger pipeline, which builds and
+// tests the components, it only needs the URLs of running ones. See
+// README.md.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: ctxctl sessions [list] WORD... [--attr KEY=VALUE]... [--limit N]
+       ctxctl sessions show SESSION_ID
+       ctxctl search QUERY... [--mode hybrid|vector|keyword|graphiti] [--limit N]
+       ctxctl run AGENT_TYPE TARGET [--session ID] [--priority PRIORITY] [--wait] [--timeout DURATION]
+       ctxctl tools register NAME ENDPOINT [--config JSON]
+       ctxctl dashboard [--refresh DURATION] [--duration DURATION]
+
+Talks to the MCP server at CTXCTL_MCP_URL (default http://localhost:3000),
+which serves sessions and tools, the knowledge graph at CTXCTL_GRAPH_URL
+(default http://localhost:8080) and the orchestrator at CTXCTL_ORCH_URL
+(default http://localhost:8070). CTXCTL_TOKEN is sent as the bearer token
+and CTXCTL_TENANT as the tenant. The dashboard follows the event bus at
+CTXCTL_BUS_URL.`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "ctxctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// run runs a command against the deployment, writing what it finds to out.
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command\n%s", usage)
+	}
+	c := client{
+		mcp:          strings.TrimRight(getenv("CTXCTL_MCP_URL", "http://localhost:3000"), "/"),
+		graph:        strings.TrimRight(getenv("CTXCTL_GRAPH_URL", "http://localhost:8080"), "/"),
+		orchestrator: strings.TrimRight(getenv("CTXCTL_ORCH_URL", "http://localhost:8070"), "/"),
+		token:        os.Getenv("CTXCTL_TOKEN"),
+		tenant:       os.Getenv("CTXCTL_TENANT"),
+		http:         &http.Client{Timeout: 30 * time.Second},
+	}
+	switch args[0] {
+	case "sessions":
+		return c.sessions(args[1:], out)
+	case "search":
+		return c.search(args[1:], out)
+	case "run":
+		return c.run(args[1:], out)
+	case "tools":
+		return c.tools(args[1:], out)
+	case "dashboard":
+		return c.dashboard(args[1:], out)
+	case "help", "-h", "--help":
+		fmt.Fprintln(out, usage)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+}
+
+// parse parses flags wherever they are among the arguments, and returns
+// the arguments that are not flags.
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, fmt.Errorf("%v\n%s", err, usage)
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// repeated is a flag that may be given more than once.
+type repeated []string
+
+func (r *repeated) String() string { return strings.Join(*r, ",") }
+
+func (r *repeated) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+// sessions lists the sessions session memory finds for words or
+// attributes, or dumps one session's context, through the MCP server.
+func (c client) sessions(args []string, out io.Writer) error {
+	if len(args) > 0 && args[0] == "show" {
+		if len(args) != 2 {
+			return fmt.Errorf("bad arguments\n%s", usage)
+		}
+		var context json.RawMessage
+		if err := c.do(http.MethodGet, c.mcp+"/v1/memory/sessions/"+url.PathEscape(args[1]), nil, &context); err != nil {
+			return err
+		}
+		return writeIndented(out, context)
+	}
+	if len(args) > 0 && args[0] == "list" {
+		args = args[1:]
+	}
+	flags := flag.NewFlagSet("sessions list", flag.ContinueOnError)
+	var attrs repeated
+	flags.Var(&attrs, "attr", "")
+	limit := flags.Int("limit", 20, "")
+	words, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 && len(attrs) == 0 {
+		return fmt.Errorf("sessions are found by words or --attr KEY=VALUE; give at least one\n%s", usage)
+	}
+
+	query := url.Values{"q": {strings.Join(words, " ")}, "limit": {strconv.Itoa(*limit)}, "attr": attrs}
+	var found struct {
+		Results []struct {
+			SessionID string `json:"session_id"`
+			StoredAt  string `json:"stored_at"`
+			KeyPoints []any  `json:"key_points"`
+		} `json:"results"`
+	}
+	if err := c.do(http.MethodGet, c.mcp+"/v1/memory/sessions/search?"+query.Encode(), nil, &found); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tSTORED\tKEY POINTS")
+	for _, session := range found.Results {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", session.SessionID, session.StoredAt, len(session.KeyPoints))
+	}
+	return w.Flush()
+}
+
+// search runs a search of the knowledge graph.
+func (c client) search(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	mode := flags.String("mode", "hybrid", "")
+	limit := flags.Int("limit", 10, "")
+	words, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return fmt.Errorf("bad arguments\n%s", usage)
+	}
+
+	query := url.Values{"q": {strings.Join(words, " ")}, "mode": {*mode}, "limit": {strconv.Itoa(*limit)}}
+	var found struct {
+		Mode    string `json:"mode"`
+		Results []struct {
+			NodeID string   `json:"node_id"`
+			Score  *float64 `json:"score"`
+			BM25   *float64 `json:"bm25"`
+			Data   struct {
+				Type    string `json:"type"`
+				Content string `json:"content"`
+			} `json:"data"`
+		} `json:"results"`
+	}
+	if err := c.do(http.MethodGet, c.graph+"/v1/search?"+query.Encode(), nil, &found); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tTYPE\tSCORE\tCONTENT")
+	for _, node := range found.Results {
+		score := ""
+		if node.Score != nil {
+			score = strconv.FormatFloat(*node.Score, 'f', 4, 64)
+		} else if node.BM25 != nil {
+			score = strconv.FormatFloat(*node.BM25, 'f', 4, 64)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", node.NodeID, node.Data.Type, score, shorten(node.Data.Content, 80))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if found.Mode != "" && found.Mode != *mode {
+		fmt.Fprintf(out, "(searched as %s)\n", found.Mode)
+	}
+	return nil
+}
+
+// job is what ctxctl reads of an orchestrator job.
+type job struct {
+	ID        string `json:"id"`
+	AgentType string `json:"agent_type"`
+	Target    string `json:"target"`
+	SessionID string `json:"session_id,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Reported  bool   `json:"reported"`
+}
+
+// run submits a job to the orchestrator and, with --wait, waits for it to
+// finish and be reported, then dumps it.
+func (c client) run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	session := flags.String("session", "", "")
+	priority := flags.String("priority", "", "")
+	wait := flags.Bool("wait", false, "")
+	timeout := flags.Duration("timeout", 10*time.Minute, "")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("bad arguments\n%s", usage)
+	}
+
+	request := map[string]string{"agent_type": positional[0], "target": positional[1],
+		"session_id": *session, "priority": *priority, "tenant": c.tenant}
+	var submitted job
+	if err := c.do(http.MethodPost, c.orchestrator+"/jobs", request, &submitted); err != nil {
+		return err
+	}
+	if !*wait {
+		fmt.Fprintf(out, "job %s %s (%s on %s)\n", submitted.ID, submitted.Status, submitted.AgentType, submitted.Target)
+		return nil
+	}
+
+	deadline := time.Now().Add(*timeout)
+	for {
+		var current json.RawMessage
+		if err := c.do(http.MethodGet, c.orchestrator+"/jobs/"+url.PathEscape(submitted.ID), nil, &current); err != nil {
+			return err
+		}
+		var finished job
+		if err := json.Unmarshal(current, &finished); err != nil {
+			return err
+		}
+		switch {
+		case finished.Status == "failed":
+			writeIndented(out, current)
+			return fmt.Errorf("job %s failed: %s", finished.ID, finished.Error)
+		case finished.Reported:
+			return writeIndented(out, current)
+		case time.Now().After(deadline):
+			return fmt.Errorf("job %s is still %s after %s", finished.ID, finished.Status, *timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// tools registers a tool with the MCP server's gateway, for the tenant.
+func (c client) tools(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "register" {
+		return fmt.Errorf("bad arguments\n%s", usage)
+	}
+	flags := flag.NewFlagSet("tools register", flag.ContinueOnError)
+	config := flags.String("config", "", "")
+	positional, err := parse(flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("bad arguments\n%s", usage)
+	}
+
+	request := map[string]any{"name": positional[0], "endpoint": positional[1]}
+	if *config != "" {
+		var parsed map[string]any
+		if err := json.Unmarshal([]byte(*config), &parsed); err != nil {
+			return fmt.Errorf("--config is not a JSON object: %w", err)
+		}
+		request["config"] = parsed
+	}
+	var registered struct {
+		Name   string `json:"name"`
+		Tenant string `json:"tenant"`
+	}
+	if err := c.do(http.MethodPost, c.mcp+"/v1/tools/register", request, &registered); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "tool %s registered for tenant %s, invoked at %s/v1/api/%s\n", registered.Name, registered.Tenant, c.mcp, registered.Name)
+	return nil
+}
+
+func writeIndented(out io.Writer, raw []byte) error {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, raw, "", "  "); err != nil {
+		return err
+	}
+	indented.WriteByte('\n')
+	_, err := indented.WriteTo(out)
+	return err
+}
+
+// shorten cuts text to n runes on one line.
+func shorten(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,138 @@
+// Package events defines the events the components of the dynamic context
+// system exchange over the event bus, and a minimal NATS client to publish
+// and subscribe to them with, so that agents and the orchestrator hand
+// context to the knowledge graph and session memory without calling them.
+//
+// Every event is an Event envelope whose Type is the subject it was
+// published on and whose Data is one of the payloads below. The Python
+// services read and write the same JSON.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Subjects, one per payload type.
+const (
+	SubjectNodes         = "context.nodes"
+	SubjectInvalidations = "context.invalidations"
+	SubjectResults       = "context.results"
+	SubjectJobs          = "agents.jobs"
+	SubjectTools         = "tools.invocations"
+)
+
+// Payload is the data of an event, which is published on its subject.
+type Payload interface {
+	Subject() string
+}
+
+// Event is the envelope every event travels in. Traceparent is the W3C
+// trace context of what published it, as in CloudEvents' distributed
+// tracing extension, so a subscriber's work joins the same trace.
+type Event struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Source      string          `json:"source"`
+	Time        time.Time       `json:"time"`
+	Traceparent string          `json:"traceparent,omitempty"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// Node is a context node for the knowledge graph, in the shape of a POST
+// /nodes body. Graph names the graph it goes in, the default one when
+// empty. Subscribers add it the way the endpoint does, so nodes with the
+// same identity merge.
+type Node struct {
+	Data      map[string]any `json:"data"`
+	ValidFrom string         `json:"valid_from,omitempty"`
+	ValidTo   string         `json:"valid_to,omitempty"`
+	Graph     string         `json:"graph,omitempty"`
+	// Tenant owns Graph; empty is the default tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func (Node) Subject() string { return SubjectNodes }
+
+// Invalidation ends a node's validity At, or when it is handled if At is
+// empty, as POST /nodes/{node_id}/invalidate does: what it said no longer
+// holds, as when the issue it is of was closed.
+type Invalidation struct {
+	NodeID string `json:"node_id"`
+	At     string `json:"at,omitempty"`
+	Graph  string `json:"graph,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func (Invalidation) Subject() string { return SubjectInvalidations }
+
+// Result is a finished job: the context its agent gathered when Status is
+// succeeded or partial, for the session it names.
+type Result struct {
+	JobID      string         `json:"job_id"`
+	SessionID  string         `json:"session_id,omitempty"`
+	Tenant     string         `json:"tenant,omitempty"`
+	AgentType  string         `json:"agent_type"`
+	Target     string         `json:"target"`
+	Status     string         `json:"status"`
+	Context    map[string]any `json:"context,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	FinishedAt time.Time      `json:"finished_at"`
+}
+
+func (Result) Subject() string { return SubjectResults }
+
+// JobStatus is a job changing status: running as each attempt starts,
+// retrying between attempts, and succeeded or failed once it finishes. It
+// carries no context, for following what the agents are doing.
+type JobStatus struct {
+	JobID     string `json:"job_id"`
+	SessionID string `json:"session_id,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	AgentType string `json:"agent_type"`
+	Target    string `json:"target"`
+	Status    string `json:"status"`
+	Attempt   int    `json:"attempt"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (JobStatus) Subject() string { return SubjectJobs }
+
+// ToolInvocation is a call through the MCP server's gateway to a tool or
+// shared API: the status the gateway answered, and how long it took.
+type ToolInvocation struct {
+	Tool       string  `json:"tool"`
+	Tenant     string  `json:"tenant,omitempty"`
+	Status     int     `json:"status"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+func (ToolInvocation) Subject() string { return SubjectTools }
+
+// New wraps a payload in an event from source.
+func New(source string, payload Payload) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Event{}, err
+	}
+	return Event{ID: hex.EncodeToString(id), Type: payload.Subject(), Source: source, Time: time.Now().UTC(), Data: data}, nil
+}
+
+// Decode unmarshals the event's data into payload, which must be of the
+// event's type.
+func (e Event) Decode(payload Payload) error {
+	if e.Type != payload.Subject() {
+		return fmt.Errorf("event %s is a %s event, not %s", e.ID, e.Type, payload.Subject())
+	}
+	if err := json.Unmarshal(e.Data, payload); err != nil {
+		return fmt.Errorf("event %s: %w", e.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A backup is JSON Lines in the Python graph_backup format: for every graph
+// a "graph" record with the graph's stored ID and catalog entry as its
+// attrs, followed by the graph's nodes and edges in the graph_io format.
+
+var errInvalidBackup = errors.New("invalid backup")
+
+// BackupSummary is what a backup or restore covered.
+type BackupSummary struct {
+	Graphs  int `json:"graphs"`
+	Created int `json:"created"`
+	Nodes   int `json:"nodes"`
+	Edges   int `json:"edges"`
+}
+
+// Backup writes every graph to w, with every graph's lock held until all
+// are read so the graphs are of one moment.
+func (g *graphRegistry) Backup(ctx context.Context, w io.Writer) (BackupSummary, error) {
+	ids := g.IDs("")
+	graphs := make([]*Graph, 0, len(ids))
+	for _, id := range ids {
+		graph, err := g.Get(ctx, id)
+		if err != nil {
+			return BackupSummary{}, err
+		}
+		graphs = append(graphs, graph)
+	}
+	for _, graph := range graphs {
+		graph.kg.mu.Lock()
+		defer graph.kg.mu.Unlock()
+	}
+
+	summary := BackupSummary{Graphs: len(graphs)}
+	enc := json.NewEncoder(w)
+	for _, graph := range graphs {
+		nodes, err := graph.kg.store.Nodes(ctx)
+		if err != nil {
+			return BackupSummary{}, err
+		}
+		edges, err := graph.kg.store.Edges(ctx)
+		if err != nil {
+			return BackupSummary{}, err
+		}
+		info, err := g.catalogEntry(graph.ID)
+		if err != nil {
+			return BackupSummary{}, err
+		}
+		if err := enc.Encode(graphRecord{Kind: "graph", ID: graph.ID, Attrs: info}); err != nil {
+			return BackupSummary{}, err
+		}
+		if err := writeRecords(enc, nodes, edges); err != nil {
+			return BackupSummary{}, err
+		}
+		summary.Nodes += len(nodes)
+		summary.Edges += len(edges)
+	}
+	return summary, nil
+}
+
+// writeRecords writes nodes, by ID, then edges as graph_io records.
+func writeRecords(enc *json.Encoder, nodes map[string]Attrs, edges []Edge) error {
+	for _, id := range sortedIDs(nodes) {
+		if err := enc.Encode(graphRecord{Kind: "node", ID: id, Attrs: nodes[id]}); err != nil {
+			return err
+		}
+	}
+	for _, edge := range edges {
+		if err := enc.Encode(graphRecord{Kind: "edge", Source: edge.Source, Target: edge.Target, Attrs: edge.Attrs}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupSection is one graph of a backup.
+type backupSection struct {
+	id      string
+	info    Attrs
+	records []graphRecord
+}
+
+// Restore loads a backup, adding the graphs missing from the catalog and
+// merging nodes and edges into those of the same ID. The whole backup is
+// read before any graph is changed, so one that does not parse changes
+// nothing.
+func (g *graphRegistry) Restore(ctx context.Context, r io.Reader) (BackupSummary, error) {
+	var sections []*backupSection
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record graphRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return BackupSummary{}, fmt.Errorf("%w: line %d: %v", errInvalidBackup, line, err)
+		}
+		switch {
+		case record.Kind == "graph":
+			if !graphIDPattern.MatchString(record.ID) {
+				return BackupSummary{}, fmt.Errorf("%w: line %d: invalid graph ID %q", errInvalidBackup, line, record.ID)
+			}
+			sections = append(sections, &backupSection{id: record.ID, info: record.Attrs})
+		case record.Kind != "node" && record.Kind != "edge":
+			return BackupSummary{}, fmt.Errorf("%w: line %d: unknown record kind %q", errInvalidBackup, line, record.Kind)
+		case len(sections) == 0:
+			return BackupSummary{}, fmt.Errorf("%w: line %d: a node or edge before any graph", errInvalidBackup, line)
+		default:
+			section := sections[len(sections)-1]
+			section.records = append(section.records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return BackupSummary{}, fmt.Errorf("%w: %v", errInvalidBackup, err)
+	}
+
+	summary := BackupSummary{Graphs: len(sections)}
+	for _, section := range sections {
+		created, err := g.addToCatalog(section.id, section.info)
+		if err != nil {
+			return summary, err
+		}
+		if created {
+			summary.Created++
+		}
+		graph, err := g.Get(ctx, section.id)
+		if err != nil {
+			return summary, err
+		}
+		nodes, edges, err := graph.kg.Load(ctx, section.records)
+		summary.Nodes += nodes
+		summary.Edges += edges
+		if err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// Load merges graph_io records into the graph, nodes first so every edge's
+// endpoints have their attributes, and returns how many of each it loaded.
+// Embeddings go into the vector index, and the keyword index is rebuilt on
+// the next search.
+func (kg *KnowledgeGraph) Load(ctx context.Context, records []graphRecord) (nodes, edges int, err error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.load(ctx, records)
+}
+
+func (kg *KnowledgeGraph) load(ctx context.Context, records []graphRecord) (nodes, edges int, err error) {
+	defer func() { kg.keywords = newKeywordIndex() }()
+	for _, record := range records {
+		if record.Kind != "node" {
+			continue
+		}
+		if err := kg.store.AddNode(ctx, record.ID, record.Attrs); err != nil {
+			return nodes, edges, err
+		}
+		if embedding := floats(record.Attrs["embedding"]); len(embedding) > 0 {
+			if err := kg.index.Upsert(ctx, record.ID, embedding); err != nil {
+				return nodes, edges, err
+			}
+		}
+		nodes++
+	}
+	for _, record := range records {
+		if record.Kind != "edge" {
+			continue
+		}
+		if err := kg.store.AddEdge(ctx, record.Source, record.Target, record.Attrs); err != nil {
+			return nodes, edges, err
+		}
+		edges++
+	}
+	return nodes, edges, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/events"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/rbac"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/tracing"
+)
+
+// subscribeNodes adds the context nodes published on the event bus to their
+// tenant's graph, as POST /nodes would, and invalidates those invalidated on
+// it, until ctx is done. Nodes for a graph that does not exist, or for a
+// tenant over its quota, are dropped. Replicas of the service share the
+// events. Each event is handled in a consumer span, the child of the span
+// that published it.
+func (s *server) subscribeNodes(ctx context.Context, busURL string, tracer *tracing.Tracer) {
+	go events.Subscribe(ctx, busURL, "kg-service", events.SubjectInvalidations, "kg-service", consume(ctx, tracer, func(ctx context.Context, event events.Event) error {
+		var invalidation events.Invalidation
+		if err := event.Decode(&invalidation); err != nil {
+			return err
+		}
+		graph, err := s.graphs.TenantGraph(ctx, cmp.Or(invalidation.Tenant, rbac.DefaultTenant), cmp.Or(invalidation.Graph, defaultGraph))
+		if errors.Is(err, errGraphNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = graph.kg.InvalidateNode(ctx, invalidation.NodeID, invalidation.At)
+		if errors.Is(err, errNotFound) {
+			return nil
+		}
+		return err
+	}))
+	events.Subscribe(ctx, busURL, "kg-service", events.SubjectNodes, "kg-service", consume(ctx, tracer, func(ctx context.Context, event events.Event) error {
+		var node events.Node
+		if err := event.Decode(&node); err != nil {
+			return err
+		}
+		if node.Data == nil {
+			return errors.New("node has no data")
+		}
+		tenant := cmp.Or(node.Tenant, rbac.DefaultTenant)
+		graph, err := s.graphs.TenantGraph(ctx, tenant, cmp.Or(node.Graph, defaultGraph))
+		if errors.Is(err, errGraphNotFound) {
+			log.Printf("node from %s dropped: graph %s of tenant %s does not exist", event.Source, node.Graph, tenant)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		over, limit, err := s.overQuota(ctx, tenant)
+		if err != nil {
+			return err
+		}
+		if over {
+			log.Printf("node from %s dropped: tenant %s is over its quota of %d nodes", event.Source, tenant, limit)
+			return nil
+		}
+		data, err := json.Marshal(node.Data)
+		if err != nil {
+			return err
+		}
+		_, err = graph.kg.AddContextNode(ctx, data, node.ValidFrom, node.ValidTo)
+		var quarantined quarantinedError
+		if errors.As(err, &quarantined) {
+			log.Printf("node from %s of tenant %s quarantined as %s", event.Source, tenant, quarantined.ID)
+			return nil
+		}
+		return err
+	}))
+}
+
+// consume runs handle for each event in a consumer span.
+func consume(ctx context.Context, tracer *tracing.Tracer, handle func(context.Context, events.Event) error) func(events.Event) error {
+	return func(event events.Event) error {
+		parent, _ := tracing.ParseTraceparent(event.Traceparent)
+		ctx, span := tracer.Start(tracing.ContextWithSpanContext(ctx, parent), event.Type+" receive", tracing.KindConsumer)
+		defer span.End()
+		span.SetAttribute("messaging.message.id", event.ID)
+		span.SetAttribute("messaging.source", event.Source)
+		err := handle(ctx, event)
+		span.RecordError(err)
+		return err
+	}
+}
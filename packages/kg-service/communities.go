@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Community detection grouping related context into named clusters, as in
+// the Python graph_communities. Both methods run deterministically over
+// nodes in ID order where networkx shuffles with a fixed seed, so the two
+// services can draw a community's borders a little differently.
+
+var errUnknownCommunityMethod = errors.New("unknown community method")
+
+var communityTokenPattern = regexp.MustCompile(`[a-z][a-z0-9_-]{3,}`)
+
+var communityStopwords = map[string]bool{"with": true, "from": true, "that": true, "this": true, "have": true,
+	"will": true, "into": true, "uses": true, "used": true, "context": true, "dynamic": true, "true": true,
+	"false": true, "none": true, "null": true}
+
+// undirected collapses the directed graph into weighted undirected edges,
+// keeping the larger weight of an edge's two directions.
+func undirected(nodes map[string]Attrs, edges []Edge) map[string]map[string]float64 {
+	adjacency := make(map[string]map[string]float64, len(nodes))
+	for id := range nodes {
+		adjacency[id] = map[string]float64{}
+	}
+	for _, edge := range edges {
+		weight := number(edge.Attrs["weight"], 1.0)
+		if current, ok := adjacency[edge.Source][edge.Target]; ok {
+			weight = max(weight, current)
+		}
+		adjacency[edge.Source][edge.Target] = weight
+		adjacency[edge.Target][edge.Source] = weight
+	}
+	return adjacency
+}
+
+// detectCommunities partitions the graph, largest community first.
+func detectCommunities(nodes map[string]Attrs, edges []Edge, method string, resolution float64) ([][]string, error) {
+	ids := sortedIDs(nodes)
+	adjacency := undirected(nodes, edges)
+	var labels []int
+	switch method {
+	case "louvain":
+		labels = louvain(ids, adjacency, resolution)
+	case "label_propagation":
+		labels = labelPropagation(ids, adjacency)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownCommunityMethod, method)
+	}
+	groups := map[int][]string{}
+	var order []int
+	for i, id := range ids {
+		if _, ok := groups[labels[i]]; !ok {
+			order = append(order, labels[i])
+		}
+		groups[labels[i]] = append(groups[labels[i]], id)
+	}
+	communities := make([][]string, 0, len(order))
+	for _, label := range order {
+		communities = append(communities, groups[label])
+	}
+	sort.SliceStable(communities, func(i, j int) bool { return len(communities[i]) > len(communities[j]) })
+	return communities, nil
+}
+
+// louvain greedily moves each node to the neighbouring community that most
+// raises modularity, then merges communities into nodes and repeats, until
+// a level moves nothing or raises modularity by less than 1e-7. It returns
+// each node's community.
+func louvain(ids []string, adjacency map[string]map[string]float64, resolution float64) []int {
+	index := make(map[string]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+	// The level's graph: weights[i][j] between its nodes, a self-loop once
+	weights := make([]map[int]float64, len(ids))
+	for i, id := range ids {
+		weights[i] = map[int]float64{}
+		for neighbour, w := range adjacency[id] {
+			weights[i][index[neighbour]] = w
+		}
+	}
+	membership := make([]int, len(ids))
+	for i := range membership {
+		membership[i] = i
+	}
+
+	for {
+		degree := make([]float64, len(weights))
+		m := 0.0
+		for i, row := range weights {
+			for j, w := range row {
+				degree[i] += w
+				if i == j {
+					degree[i] += w
+				}
+			}
+			m += degree[i]
+		}
+		m /= 2
+		if m == 0 {
+			return membership
+		}
+
+		community := make([]int, len(weights))
+		total := make([]float64, len(weights))
+		for i := range weights {
+			community[i] = i
+			total[i] = degree[i]
+		}
+		before := modularity(weights, community, degree, m, resolution)
+		moved := false
+		for improved := true; improved; {
+			improved = false
+			for u, row := range weights {
+				toCommunity := map[int]float64{}
+				for v, w := range row {
+					if v != u {
+						toCommunity[community[v]] += w
+					}
+				}
+				current := community[u]
+				total[current] -= degree[u]
+				best := current
+				bestGain := toCommunity[current] - resolution*total[current]*degree[u]/(2*m)
+				candidates := make([]int, 0, len(toCommunity))
+				for c := range toCommunity {
+					candidates = append(candidates, c)
+				}
+				sort.Ints(candidates)
+				for _, c := range candidates {
+					if gain := toCommunity[c] - resolution*total[c]*degree[u]/(2*m); gain > bestGain {
+						best, bestGain = c, gain
+					}
+				}
+				total[best] += degree[u]
+				if best != current {
+					community[u] = best
+					improved, moved = true, true
+				}
+			}
+		}
+		if !moved || modularity(weights, community, degree, m, resolution)-before <= 1e-7 {
+			return membership
+		}
+
+		// Number the communities in order, then merge each into a node
+		renumber := map[int]int{}
+		for _, c := range community {
+			if _, ok := renumber[c]; !ok {
+				renumber[c] = len(renumber)
+			}
+		}
+		for i, level := range membership {
+			membership[i] = renumber[community[level]]
+		}
+		merged := make([]map[int]float64, len(renumber))
+		for i := range merged {
+			merged[i] = map[int]float64{}
+		}
+		for i, row := range weights {
+			for j, w := range row {
+				a, b := renumber[community[i]], renumber[community[j]]
+				if a == b && i != j {
+					// Each internal edge appears in both rows; keep it once
+					w /= 2
+				}
+				merged[a][b] += w
+			}
+		}
+		weights = merged
+	}
+}
+
+// modularity is the modularity of a partition at the given resolution.
+func modularity(weights []map[int]float64, community []int, degree []float64, m, resolution float64) float64 {
+	internal := map[int]float64{}
+	total := map[int]float64{}
+	for i, row := range weights {
+		total[community[i]] += degree[i]
+		for j, w := range row {
+			if community[i] != community[j] {
+				continue
+			}
+			if i == j {
+				internal[community[i]] += w
+			} else {
+				internal[community[i]] += w / 2
+			}
+		}
+	}
+	q := 0.0
+	for c, t := range total {
+		q += internal[c]/m - resolution*(t/(2*m))*(t/(2*m))
+	}
+	return q
+}
+
+// labelPropagation gives every node the label most of its neighbours have,
+// keeping its own on a tie if it can and else the smallest, until no label
+// changes. It returns each node's label.
+func labelPropagation(ids []string, adjacency map[string]map[string]float64) []int {
+	index := make(map[string]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+	labels := make([]int, len(ids))
+	for i := range labels {
+		labels[i] = i
+	}
+	for changed, rounds := true, 0; changed && rounds < 100; rounds++ {
+		changed = false
+		for i, id := range ids {
+			counts := map[int]int{}
+			for neighbour := range adjacency[id] {
+				if neighbour != id {
+					counts[labels[index[neighbour]]]++
+				}
+			}
+			if len(counts) == 0 {
+				continue
+			}
+			most := 0
+			for _, count := range counts {
+				most = max(most, count)
+			}
+			if counts[labels[i]] == most {
+				continue
+			}
+			best := -1
+			for label, count := range counts {
+				if count == most && (best < 0 || label < best) {
+					best = label
+				}
+			}
+			labels[i] = best
+			changed = true
+		}
+	}
+	return labels
+}
+
+// communityID matches the Python community_id, an md5 of the sorted members.
+func communityID(members []string) string {
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+	sum := md5.Sum([]byte(strings.Join(sorted, ",")))
+	return "community-" + hex.EncodeToString(sum[:])[:10]
+}
+
+// communityName names a cluster after its best-connected entity, else its
+// top keywords.
+func communityName(nodes map[string]Attrs, edges []Edge, members []string) string {
+	degree := map[string]int{}
+	for _, edge := range edges {
+		degree[edge.Source]++
+		degree[edge.Target]++
+	}
+	best := ""
+	for _, id := range members {
+		if t, ok := nodes[id]["node_type"].(string); !ok || t == "context" {
+			continue
+		}
+		if best == "" || degree[id] > degree[best] || (degree[id] == degree[best] && id > best) {
+			best = id
+		}
+	}
+	if best != "" {
+		if data, ok := nodes[best]["data"].(map[string]any); ok {
+			if name, ok := data["name"]; ok {
+				return pyStr(name)
+			}
+		}
+		return best
+	}
+
+	counts := map[string]int{}
+	var words []string
+	for _, id := range members {
+		encoded, err := json.Marshal(nodes[id]["data"])
+		if err != nil || nodes[id]["data"] == nil {
+			encoded = []byte("{}")
+		}
+		for _, word := range communityTokenPattern.FindAllString(strings.ToLower(contextText(encoded)), -1) {
+			if communityStopwords[word] {
+				continue
+			}
+			if counts[word] == 0 {
+				words = append(words, word)
+			}
+			counts[word]++
+		}
+	}
+	sort.SliceStable(words, func(i, j int) bool { return counts[words[i]] > counts[words[j]] })
+	if len(words) == 0 {
+		return "misc"
+	}
+	return strings.Join(words[:min(3, len(words))], " ")
+}
+
+// DetectCommunities clusters the visible nodes and records each one's
+// community on it; communities of one node are recorded as none.
+func (kg *KnowledgeGraph) DetectCommunities(ctx context.Context, method string, resolution float64) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, edges, err := kg.currentGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found, err := detectCommunities(nodes, edges, method, resolution)
+	if err != nil {
+		return nil, err
+	}
+	summaries := []map[string]any{}
+	for _, members := range found {
+		var id, name any
+		if len(members) >= 2 {
+			id, name = communityID(members), communityName(nodes, edges, members)
+			summaries = append(summaries, map[string]any{"community_id": id, "name": name, "size": len(members)})
+		}
+		for _, member := range members {
+			if nodes[member]["community_id"] == id {
+				continue
+			}
+			if err := kg.store.AddNode(ctx, member, Attrs{"community_id": id, "community_name": name}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return map[string]any{"method": method, "count": len(summaries), "communities": summaries}, nil
+}
+
+// Communities summarizes the communities recorded on nodes, largest first,
+// or those whose name contains name.
+func (kg *KnowledgeGraph) Communities(ctx context.Context, name string) ([]map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups := map[string]map[string]any{}
+	var order []string
+	for _, id := range sortedIDs(nodes) {
+		attrs := nodes[id]
+		cid, _ := attrs["community_id"].(string)
+		if cid == "" {
+			continue
+		}
+		group, ok := groups[cid]
+		if !ok {
+			group = map[string]any{"community_id": cid, "name": attrs["community_name"], "size": 0, "node_types": map[string]int{}}
+			groups[cid] = group
+			order = append(order, cid)
+		}
+		group["size"] = group["size"].(int) + 1
+		group["node_types"].(map[string]int)[nodeType(attrs)]++
+	}
+	found := []map[string]any{}
+	for _, cid := range order {
+		groupName, _ := groups[cid]["name"].(string)
+		if name == "" || strings.Contains(strings.ToLower(groupName), strings.ToLower(name)) {
+			found = append(found, groups[cid])
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i]["size"].(int) > found[j]["size"].(int) })
+	return found, nil
+}
+
+// Community lists a community's members; none when there is no such community.
+func (kg *KnowledgeGraph) Community(ctx context.Context, communityID string) ([]map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := []map[string]any{}
+	for _, id := range sortedIDs(nodes) {
+		attrs := nodes[id]
+		if attrs["community_id"] != communityID {
+			continue
+		}
+		data := attrs["data"]
+		if data == nil {
+			data = map[string]any{}
+		}
+		members = append(members, map[string]any{"node_id": id, "node_type": attrs["node_type"], "data": data})
+	}
+	return members, nil
+}
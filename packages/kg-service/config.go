@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// defaultConfig mirrors the parts of the Python DEFAULT_CONFIG that the Go
+// service acts on. Other sections of a shared KG_CONFIG file are passed
+// through to GET /config untouched.
+func defaultConfig() map[string]any {
+	return map[string]any{
+		"thresholds": map[string]any{
+			"search": 0.2,
+			"dedup":  0.95,
+		},
+		"relationship_types": map[string]any{
+			"semantic_similarity": map[string]any{"rule": "similarity", "min_similarity": 0.5, "max_per_node": 50,
+				"source_types": []any{"context"}, "target_types": []any{"context"}},
+			"mentions":     map[string]any{"rule": "entity"},
+			"references":   map[string]any{"rule": "field", "field": "references"},
+			"derived_from": map[string]any{"rule": "field", "field": "derived_from"},
+			"contradicts":  map[string]any{"rule": "manual"},
+		},
+		// Mutations between PageRank importance refreshes
+		"importance_refresh_every": 50,
+		// Decay policy; empty takes it from the KG_DECAY_* variables
+		"decay": map[string]any{},
+		// Graphiti episodes need the Python service; enabling them here is refused
+		"graphiti": map[string]any{"enabled": false, "group_id": "default", "mirror_context": false},
+		// Node-type schemas and edge constraints; see schema.go
+		"schema": map[string]any{"mode": "off", "allow_unknown_types": true, "node_types": map[string]any{}, "edges": map[string]any{}},
+		// Embedding model (null: KG_EMBEDDING_MODEL or the default). When it differs
+		// from the model existing nodes were embedded with, they are re-embedded in
+		// the background
+		"embedding": map[string]any{"model": nil, "batch_size": 64, "auto_reembed": true},
+		// Nodes each tenant's graphs may hold together, from quotas.tenants[<tenant>]
+		// or else quotas.default; null is unlimited. Over it, adding nodes gets 429
+		"quotas": map[string]any{"enabled": false, "default": map[string]any{"max_nodes": nil}, "tenants": map[string]any{}},
+	}
+}
+
+// relationshipType is one entry of relationship_types; see graph_config.py
+// for what each rule means.
+type relationshipType struct {
+	Name          string
+	Rule          string   `json:"rule"`
+	Field         string   `json:"field"`
+	Weight        *float64 `json:"weight"`
+	MinSimilarity *float64 `json:"min_similarity"`
+	MaxSimilarity *float64 `json:"max_similarity"`
+	MaxPerNode    *int     `json:"max_per_node"`
+	SourceTypes   []string `json:"source_types"`
+	TargetTypes   []string `json:"target_types"`
+}
+
+func (r relationshipType) allows(sourceType, targetType string) bool {
+	return (len(r.SourceTypes) == 0 || contains(r.SourceTypes, sourceType)) &&
+		(len(r.TargetTypes) == 0 || contains(r.TargetTypes, targetType))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func orDefault[T any](p *T, fallback T) T {
+	if p == nil {
+		return fallback
+	}
+	return *p
+}
+
+// quotas are the limits on what each tenant's graphs hold.
+type quotas struct {
+	Enabled bool                      `json:"enabled"`
+	Default map[string]any            `json:"default"`
+	Tenants map[string]map[string]any `json:"tenants"`
+}
+
+// maxNodes is how many nodes a tenant's graphs may hold together, from its
+// own limits or else the default ones; ok is false when that is unlimited.
+func (q quotas) maxNodes(tenant string) (limit int, ok bool) {
+	value := q.Default["max_nodes"]
+	if own, found := q.Tenants[tenant]["max_nodes"]; found {
+		value = own
+	}
+	n, ok := value.(float64)
+	return int(n), ok
+}
+
+// embeddingConfig is the embedding section: the model graphs are embedded
+// with, and how they are re-embedded when it changes.
+type embeddingConfig struct {
+	Model       *string `json:"model"`
+	BatchSize   int     `json:"batch_size"`
+	AutoReembed bool    `json:"auto_reembed"`
+}
+
+// Config is the loaded graph configuration.
+type Config struct {
+	Raw               map[string]any
+	SearchThreshold   float64
+	DedupThreshold    float64
+	RelationshipTypes map[string]relationshipType
+	// ImportanceRefreshEvery is how many nodes are added between importance
+	// refreshes.
+	ImportanceRefreshEvery int
+	Decay                  decayPolicy
+	Embedding              embeddingConfig
+	Schema                 schema
+	Quotas                 quotas
+}
+
+func deepMerge(base, override map[string]any) map[string]any {
+	merged := make(map[string]any, len(base))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		overrideMap, ok := v.(map[string]any)
+		baseMap, baseOK := merged[k].(map[string]any)
+		if ok && baseOK {
+			merged[k] = deepMerge(baseMap, overrideMap)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// loadConfig loads the config file (if any), then the config service's
+// overrides, over the defaults.
+func loadConfig(path string, overrides map[string]any) (*Config, error) {
+	raw := defaultConfig()
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			var file map[string]any
+			if err := json.Unmarshal(contents, &file); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", path, err)
+			}
+			raw = deepMerge(raw, file)
+		}
+	}
+	return parseConfig(deepMerge(raw, overrides))
+}
+
+func parseConfig(raw map[string]any) (*Config, error) {
+	var parsed struct {
+		Thresholds             map[string]float64          `json:"thresholds"`
+		RelationshipTypes      map[string]relationshipType `json:"relationship_types"`
+		ImportanceRefreshEvery int                         `json:"importance_refresh_every"`
+		Decay                  map[string]any              `json:"decay"`
+		Graphiti               struct {
+			Enabled bool `json:"enabled"`
+		} `json:"graphiti"`
+		Schema    schema          `json:"schema"`
+		Embedding embeddingConfig `json:"embedding"`
+		Quotas    quotas          `json:"quotas"`
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(encoded, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	for key, value := range parsed.Thresholds {
+		if value < 0 || value > 1 {
+			return nil, fmt.Errorf("threshold %q must be between 0 and 1", key)
+		}
+	}
+	for name, spec := range parsed.RelationshipTypes {
+		spec.Name = name
+		switch spec.Rule {
+		case "similarity":
+			low, high := orDefault(spec.MinSimilarity, 0.5), orDefault(spec.MaxSimilarity, 1.0)
+			if !(0 <= low && low <= high && high <= 1) {
+				return nil, fmt.Errorf("relationship type %q has an invalid similarity range", name)
+			}
+		case "field":
+			if spec.Field == "" {
+				return nil, fmt.Errorf("relationship type %q needs a field", name)
+			}
+		case "entity", "manual":
+		default:
+			return nil, fmt.Errorf("relationship type %q has unknown rule %q", name, spec.Rule)
+		}
+		parsed.RelationshipTypes[name] = spec
+	}
+	if parsed.Embedding.BatchSize < 1 {
+		return nil, fmt.Errorf("embedding batch_size must be a positive integer")
+	}
+	if err := parsed.Schema.validate(); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	// Refuse rather than silently ignore Graphiti, which only the Python
+	// service has
+	if parsed.Graphiti.Enabled {
+		return nil, fmt.Errorf("graphiti is not supported by the Go service")
+	}
+	decay, err := parseDecayPolicy(parsed.Decay)
+	if err != nil {
+		return nil, err
+	}
+	limits := map[string]map[string]any{"default": parsed.Quotas.Default}
+	for tenant, tenantLimits := range parsed.Quotas.Tenants {
+		limits[tenant] = tenantLimits
+	}
+	for tenant, tenantLimits := range limits {
+		for key, value := range tenantLimits {
+			if key != "max_nodes" {
+				return nil, fmt.Errorf("unknown quota limit %q for tenant %q", key, tenant)
+			}
+			if n, ok := value.(float64); value != nil && (!ok || n < 1 || n != math.Trunc(n)) {
+				return nil, fmt.Errorf("max_nodes for tenant %q must be a positive integer or null", tenant)
+			}
+		}
+	}
+
+	return &Config{
+		Raw:                    raw,
+		SearchThreshold:        parsed.Thresholds["search"],
+		DedupThreshold:         parsed.Thresholds["dedup"],
+		RelationshipTypes:      parsed.RelationshipTypes,
+		ImportanceRefreshEvery: parsed.ImportanceRefreshEvery,
+		Decay:                  decay,
+		Embedding:              parsed.Embedding,
+		Schema:                 parsed.Schema,
+		Quotas:                 parsed.Quotas,
+	}, nil
+}
+
+// ruleTypes returns the relationship types created by a rule, by name.
+func (c *Config) ruleTypes(rule string) []relationshipType {
+	var out []relationshipType
+	for _, spec := range c.RelationshipTypes {
+		if spec.Rule == rule {
+			out = append(out, spec)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Decay and pruning of nodes that are no longer accessed or re-confirmed,
+// as in the Python graph_decay. Each run recomputes a node's decay_weight
+// from the time since it was last confirmed or accessed, halving every
+// half-life. Nodes idle past prune_after are tombstoned: invalidated so they
+// drop out of current views, but kept for audit until tombstone_retention
+// expires and they are purged for good.
+
+const day = 24 * time.Hour
+
+// decayPolicy is the config's decay section, or else the KG_DECAY_*
+// variables.
+type decayPolicy struct {
+	HalfLifeDays           float64 `json:"half_life_days"`
+	PruneAfterDays         float64 `json:"prune_after_days"`
+	TombstoneRetentionDays float64 `json:"tombstone_retention_days"`
+	MinWeight              float64 `json:"min_weight"`
+	IntervalSeconds        float64 `json:"interval_seconds"`
+}
+
+func defaultDecayPolicy() decayPolicy {
+	return decayPolicy{HalfLifeDays: 30, PruneAfterDays: 90, TombstoneRetentionDays: 30, MinWeight: 0.05, IntervalSeconds: 3600}
+}
+
+// parseDecayPolicy reads the decay section; an empty one takes the policy
+// from the environment instead.
+func parseDecayPolicy(section map[string]any) (decayPolicy, error) {
+	policy := defaultDecayPolicy()
+	if len(section) == 0 {
+		for name, field := range map[string]*float64{
+			"KG_DECAY_HALF_LIFE_DAYS":     &policy.HalfLifeDays,
+			"KG_PRUNE_AFTER_DAYS":         &policy.PruneAfterDays,
+			"KG_TOMBSTONE_RETENTION_DAYS": &policy.TombstoneRetentionDays,
+			"KG_DECAY_INTERVAL_SECONDS":   &policy.IntervalSeconds,
+		} {
+			value := os.Getenv(name)
+			if value == "" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return policy, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			*field = parsed
+		}
+	} else {
+		encoded, err := json.Marshal(section)
+		if err != nil {
+			return policy, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(encoded))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&policy); err != nil {
+			return policy, fmt.Errorf("invalid decay policy: %w", err)
+		}
+	}
+	if policy.HalfLifeDays <= 0 || policy.IntervalSeconds <= 0 {
+		return policy, fmt.Errorf("decay half_life_days and interval_seconds must be positive")
+	}
+	return policy, nil
+}
+
+// decayMetrics are counters across a graph's decay runs.
+type decayMetrics struct {
+	Runs         int
+	Downweighted int
+	Tombstoned   int
+	Purged       int
+	LastRun      map[string]any
+}
+
+func (m *decayMetrics) record(report map[string]any) {
+	m.Runs++
+	m.Downweighted += report["downweighted"].(int)
+	m.Tombstoned += report["tombstoned"].(int)
+	m.Purged += report["purged"].(int)
+	m.LastRun = report
+}
+
+// lastSeen is when a node was last confirmed, accessed or recorded.
+func lastSeen(attrs Attrs) (time.Time, bool) {
+	var seen time.Time
+	found := false
+	for _, key := range []string{"confirmed_at", "last_accessed_at", "timestamp"} {
+		if t, ok := attrTime(attrs, key); ok && (!found || t.After(seen)) {
+			seen, found = t, true
+		}
+	}
+	return seen, found
+}
+
+// decay applies one decay pass to every node as of at (default: now) and
+// reports what it did. The caller holds kg.mu.
+func (kg *KnowledgeGraph) decay(ctx context.Context, at string) (map[string]any, error) {
+	atTime := time.Now().UTC()
+	if at != "" {
+		var err error
+		if atTime, err = parseTime(at); err != nil {
+			return nil, err
+		}
+	}
+	policy := kg.config.Decay
+	report := map[string]any{"at": isoformat(atTime), "scanned": 0, "downweighted": 0,
+		"tombstoned": 0, "purged": 0, "tombstones": []string{}}
+	count := func(key string) { report[key] = report[key].(int) + 1 }
+
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range sortedIDs(nodes) {
+		attrs := nodes[id]
+		count("scanned")
+
+		if tombstonedAt, ok := attrTime(attrs, "tombstoned_at"); ok {
+			if atTime.Sub(tombstonedAt) >= time.Duration(policy.TombstoneRetentionDays*float64(day)) {
+				if err := kg.store.RemoveNode(ctx, id); err != nil {
+					return nil, err
+				}
+				if err := kg.index.Delete(ctx, id); err != nil {
+					return nil, err
+				}
+				kg.keywords.remove(id)
+				count("purged")
+			}
+			continue
+		}
+
+		seen, ok := lastSeen(attrs)
+		if !ok {
+			continue
+		}
+		idleDays := max(atTime.Sub(seen).Seconds()/day.Seconds(), 0)
+
+		if idleDays >= policy.PruneAfterDays {
+			err := kg.store.AddNode(ctx, id, Attrs{
+				"tombstoned_at":    isoformat(atTime),
+				"invalidated_at":   isoformat(atTime),
+				"decay_weight":     0.0,
+				"tombstone_reason": fmt.Sprintf("idle for %.0f days", idleDays),
+			})
+			if err != nil {
+				return nil, err
+			}
+			count("tombstoned")
+			report["tombstones"] = append(report["tombstones"].([]string), id)
+			continue
+		}
+
+		weight := max(math.Pow(0.5, idleDays/policy.HalfLifeDays), policy.MinWeight)
+		if math.Abs(weight-number(attrs["decay_weight"], 1.0)) > 1e-3 {
+			if err := kg.store.AddNode(ctx, id, Attrs{"decay_weight": weight}); err != nil {
+				return nil, err
+			}
+			count("downweighted")
+		}
+	}
+	kg.decayMetrics.record(report)
+	return report, nil
+}
+
+// Decay downweights idle nodes and tombstones or purges long-idle ones.
+func (kg *KnowledgeGraph) Decay(ctx context.Context, at string) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.decay(ctx, at)
+}
+
+// tombstones are the tombstoned nodes. The caller holds kg.mu.
+func (kg *KnowledgeGraph) tombstones(ctx context.Context) ([]map[string]any, error) {
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := []map[string]any{}
+	for _, id := range sortedIDs(nodes) {
+		attrs := nodes[id]
+		if at, _ := attrs["tombstoned_at"].(string); at == "" {
+			continue
+		}
+		found = append(found, map[string]any{"node_id": id, "node_type": attrs["node_type"],
+			"tombstoned_at": attrs["tombstoned_at"], "reason": attrs["tombstone_reason"]})
+	}
+	return found, nil
+}
+
+// Tombstones lists the tombstoned nodes.
+func (kg *KnowledgeGraph) Tombstones(ctx context.Context) ([]map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.tombstones(ctx)
+}
+
+// DecayStats are the graph's decay counters and how many nodes are
+// tombstoned now.
+func (kg *KnowledgeGraph) DecayStats(ctx context.Context) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	tombstones, err := kg.tombstones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"runs":               kg.decayMetrics.Runs,
+		"downweighted_total": kg.decayMetrics.Downweighted,
+		"tombstoned_total":   kg.decayMetrics.Tombstoned,
+		"purged_total":       kg.decayMetrics.Purged,
+		"last_run":           kg.decayMetrics.LastRun,
+		"tombstoned_now":     len(tombstones),
+	}, nil
+}
+
+// decayGraphs runs a decay pass, then an importance refresh, over every
+// open graph each interval until ctx is done. A failed run is logged and
+// the next one tried.
+func (s *server) decayGraphs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, graph := range s.graphs.Loaded() {
+			report, err := graph.kg.decayAndRefresh(ctx)
+			if err != nil {
+				log.Printf("graph decay run failed for graph %s: %v", graph.ID, err)
+				continue
+			}
+			log.Printf("graph %s decayed: %d downweighted, %d tombstoned, %d purged",
+				graph.ID, report["downweighted"], report["tombstoned"], report["purged"])
+		}
+	}
+}
+
+// decayAndRefresh is a scheduled decay pass, after which importance is
+// refreshed.
+func (kg *KnowledgeGraph) decayAndRefresh(ctx context.Context) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	report, err := kg.decay(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	_, err = kg.refreshImportance(ctx)
+	return report, err
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// Deduplication of nodes already in the graph, as in the Python
+// graph_dedup: near-duplicates found by the vector index are folded into
+// the oldest of them.
+
+// dedupPair is a node to fold into another.
+type dedupPair struct {
+	keep, drop string
+	similarity float64
+}
+
+// duplicatePairs are the nodes scoring at least threshold against an older
+// node, or with its content hash, each paired with the oldest.
+func (kg *KnowledgeGraph) duplicatePairs(ctx context.Context, threshold float64) ([]dedupPair, error) {
+	all, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes := map[string]Attrs{}
+	embeddings := map[string][]float64{}
+	for id, attrs := range all {
+		if embedding := floats(attrs["embedding"]); len(embedding) > 0 {
+			nodes[id], embeddings[id] = attrs, embedding
+		}
+	}
+	ids := sortedIDs(nodes)
+	timestamp := func(id string) string { s, _ := nodes[id]["timestamp"].(string); return s }
+	sort.SliceStable(ids, func(i, j int) bool { return timestamp(ids[i]) < timestamp(ids[j]) })
+
+	var pairs []dedupPair
+	merged := map[string]bool{}
+	for _, id := range ids {
+		if merged[id] {
+			continue
+		}
+		hits, err := kg.index.Search(ctx, embeddings[id], 10, threshold)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range hits {
+			other, ok := nodes[hit.NodeID]
+			if hit.NodeID == id || merged[hit.NodeID] || !ok {
+				continue
+			}
+			if other["content_hash"] == nodes[id]["content_hash"] || hit.Score >= threshold {
+				merged[hit.NodeID] = true
+				pairs = append(pairs, dedupPair{keep: id, drop: hit.NodeID, similarity: hit.Score})
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// mergeNodes folds drop into keep: its edges are re-pointed at keep, keeping
+// the stronger weight, its provenance and aliases are added to keep's, and
+// it is deleted. It returns nil when either node is gone.
+func (kg *KnowledgeGraph) mergeNodes(ctx context.Context, pair dedupPair) (map[string]any, error) {
+	kept, err := kg.store.GetNode(ctx, pair.keep)
+	if err != nil {
+		return nil, err
+	}
+	dropped, err := kg.store.GetNode(ctx, pair.drop)
+	if err != nil || kept == nil || dropped == nil {
+		return nil, err
+	}
+
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, edge := range edges {
+		if edge.Source != pair.drop && edge.Target != pair.drop {
+			continue
+		}
+		source, target := edge.Source, edge.Target
+		if source == pair.drop {
+			source = pair.keep
+		}
+		if target == pair.drop {
+			target = pair.keep
+		}
+		if source == target {
+			continue
+		}
+		existing, err := kg.store.GetEdge(ctx, source, target)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil || number(existing["weight"], 0) < number(edge.Attrs["weight"], 0) {
+			if err := kg.store.AddEdge(ctx, source, target, edge.Attrs); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	provenance, _ := kept["provenance"].([]any)
+	droppedProvenance, _ := dropped["provenance"].([]any)
+	for _, entry := range droppedProvenance {
+		if entry, ok := entry.(map[string]any); ok {
+			entry = copyAttrs(entry)
+			entry["similarity"] = pair.similarity
+			provenance = append(provenance, entry)
+		}
+	}
+	aliases := stringList(kept["aliases"])
+	for _, alias := range append(stringList(dropped["aliases"]), pair.drop) {
+		if !contains(aliases, alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	if provenance == nil {
+		provenance = []any{}
+	}
+	err = kg.store.AddNode(ctx, pair.keep, Attrs{"provenance": provenance, "aliases": aliases, "merged_count": len(aliases)})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := kg.store.RemoveNode(ctx, pair.drop); err != nil {
+		return nil, err
+	}
+	if err := kg.index.Delete(ctx, pair.drop); err != nil {
+		return nil, err
+	}
+	kg.keywords.remove(pair.drop)
+	return map[string]any{"kept": pair.keep, "dropped": pair.drop, "similarity": pair.similarity}, nil
+}
+
+// Deduplicate merges the near-duplicate nodes already in the graph.
+func (kg *KnowledgeGraph) Deduplicate(ctx context.Context) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	pairs, err := kg.duplicatePairs(ctx, kg.config.DedupThreshold)
+	if err != nil {
+		return nil, err
+	}
+	merges := []map[string]any{}
+	for _, pair := range pairs {
+		merge, err := kg.mergeNodes(ctx, pair)
+		if err != nil {
+			return nil, err
+		}
+		if merge != nil {
+			merges = append(merges, merge)
+		}
+	}
+	return map[string]any{"merged": len(merges), "merges": merges}, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Diffs between two graph states, to audit what a batch of agents changed,
+// as in the Python graph_diff. A state is a snapshot ("snapshot:NAME"), the
+// graph as it was known at an ISO-8601 time, or the graph now ("current").
+// Weights are not versioned, so re-weighted edges only show up when at
+// least one side is a snapshot or "current".
+
+const diffTolerance = 1e-6
+
+// graphState is a graph's nodes and edges keyed by source and target.
+type graphState struct {
+	nodes map[string]Attrs
+	edges map[[2]string]Attrs
+}
+
+func stateFrom(nodes map[string]Attrs, edges []Edge) graphState {
+	state := graphState{nodes: nodes, edges: make(map[[2]string]Attrs, len(edges))}
+	for _, edge := range edges {
+		state.edges[[2]string{edge.Source, edge.Target}] = edge.Attrs
+	}
+	return state
+}
+
+// stateAt is what the graph knew at asOf, with the retractions recorded
+// after it undone.
+func stateAt(nodes map[string]Attrs, edges []Edge, asOf time.Time) graphState {
+	knownAt := func(attrs Attrs) bool {
+		tombstoned, ok := attrTime(attrs, "tombstoned_at")
+		return known(attrs, asOf) && (!ok || tombstoned.After(asOf))
+	}
+	asKnown := func(attrs Attrs) Attrs {
+		attrs = copyAttrs(attrs)
+		for _, field := range []string{"invalidated_at", "tombstoned_at"} {
+			if at, ok := attrTime(attrs, field); ok && at.After(asOf) {
+				attrs[field] = nil
+			}
+		}
+		return attrs
+	}
+	state := graphState{nodes: map[string]Attrs{}, edges: map[[2]string]Attrs{}}
+	for id, attrs := range nodes {
+		if knownAt(attrs) {
+			state.nodes[id] = asKnown(attrs)
+		}
+	}
+	for _, edge := range edges {
+		_, source := state.nodes[edge.Source]
+		_, target := state.nodes[edge.Target]
+		if source && target && knownAt(edge.Attrs) {
+			state.edges[[2]string{edge.Source, edge.Target}] = asKnown(edge.Attrs)
+		}
+	}
+	return state
+}
+
+// graphState resolves "snapshot:NAME", "current" or an ISO-8601 time to a
+// state of the graph. The caller holds kg.mu.
+func (kg *KnowledgeGraph) graphState(ctx context.Context, ref string) (graphState, error) {
+	if name, ok := strings.CutPrefix(ref, "snapshot:"); ok {
+		records, err := kg.loadSnapshot(name)
+		if err != nil {
+			return graphState{}, err
+		}
+		nodes, edges := recordGraph(records)
+		return stateFrom(nodes, edges), nil
+	}
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return graphState{}, err
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return graphState{}, err
+	}
+	if ref == "current" {
+		return stateFrom(nodes, edges), nil
+	}
+	asOf, err := parseTime(ref)
+	if err != nil {
+		return graphState{}, err
+	}
+	return stateAt(nodes, edges, asOf), nil
+}
+
+func describeNode(nodeID string, attrs Attrs) map[string]any {
+	return map[string]any{"node_id": nodeID, "node_type": nodeType(attrs), "label": nodeLabel(nodeID, attrs)}
+}
+
+func describeEdge(key [2]string, attrs Attrs) map[string]any {
+	return map[string]any{"source": key[0], "target": key[1],
+		"relationship_type": attrs["relationship_type"], "weight": attrs["weight"]}
+}
+
+// edgeKeys are the keys of edges, in order.
+func edgeKeys(edges map[[2]string]Attrs, keep func([2]string) bool) [][2]string {
+	keys := [][2]string{}
+	for key := range edges {
+		if keep(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// diffStates lists the nodes and edges added, removed, changed, re-weighted
+// or retyped from before to after.
+func diffStates(before, after graphState) map[string]any {
+	describeNodes := func(state graphState, other map[string]Attrs) []map[string]any {
+		described := []map[string]any{}
+		for _, id := range sortedIDs(state.nodes) {
+			if _, ok := other[id]; !ok {
+				described = append(described, describeNode(id, state.nodes[id]))
+			}
+		}
+		return described
+	}
+	describeEdges := func(state graphState, other map[[2]string]Attrs) []map[string]any {
+		described := []map[string]any{}
+		for _, key := range edgeKeys(state.edges, func(key [2]string) bool { _, ok := other[key]; return !ok }) {
+			described = append(described, describeEdge(key, state.edges[key]))
+		}
+		return described
+	}
+
+	changed := []map[string]any{}
+	for _, id := range sortedIDs(before.nodes) {
+		old := before.nodes[id]
+		updated, ok := after.nodes[id]
+		if !ok {
+			continue
+		}
+		fields := []string{}
+		for _, field := range []string{"data", "valid_from", "valid_to", "invalidated_at", "tombstoned_at"} {
+			if !pyEqual(old[field], updated[field]) {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) > 0 {
+			described := describeNode(id, updated)
+			described["fields"] = fields
+			changed = append(changed, described)
+		}
+	}
+
+	reweighted, retyped := []map[string]any{}, []map[string]any{}
+	for _, key := range edgeKeys(before.edges, func(key [2]string) bool { _, ok := after.edges[key]; return ok }) {
+		old, updated := before.edges[key], after.edges[key]
+		oldWeight, newWeight := pyNumber(old["weight"]), pyNumber(updated["weight"])
+		if math.Abs(newWeight-oldWeight) > diffTolerance {
+			described := describeEdge(key, updated)
+			described["previous_weight"] = old["weight"]
+			described["delta"] = newWeight - oldWeight
+			reweighted = append(reweighted, described)
+		}
+		if !pyEqual(old["relationship_type"], updated["relationship_type"]) {
+			described := describeEdge(key, updated)
+			described["previous_relationship_type"] = old["relationship_type"]
+			retyped = append(retyped, described)
+		}
+	}
+
+	nodes := map[string][]map[string]any{
+		"added":   describeNodes(after, before.nodes),
+		"removed": describeNodes(before, after.nodes),
+		"changed": changed,
+	}
+	edges := map[string][]map[string]any{
+		"added":      describeEdges(after, before.edges),
+		"removed":    describeEdges(before, after.edges),
+		"reweighted": reweighted,
+		"retyped":    retyped,
+	}
+	summary := map[string]int{}
+	for kind, changes := range map[string]map[string][]map[string]any{"nodes": nodes, "edges": edges} {
+		for change, items := range changes {
+			summary[kind+"_"+change] = len(items)
+		}
+	}
+	return map[string]any{"nodes": nodes, "edges": edges, "summary": summary}
+}
+
+// Diff reports what changed in the graph between two snapshots or points
+// in time.
+func (kg *KnowledgeGraph) Diff(ctx context.Context, before, after string) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	from, err := kg.graphState(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := kg.graphState(ctx, after)
+	if err != nil {
+		return nil, err
+	}
+	report := diffStates(from, to)
+	report["from"], report["to"] = before, after
+	return report, nil
+}
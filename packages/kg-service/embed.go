@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Embedder turns text into normalized embeddings. The Go service never runs
+// a model in-process: it either hashes tokens (no model at all) or calls an
+// embedding server.
+type Embedder interface {
+	Model() string
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+func normalize(v []float64) []float64 {
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	if norm == 0 {
+		return v
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+func embedOne(ctx context.Context, e Embedder, text string) ([]float64, error) {
+	vectors, err := e.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embedder returned %d vectors for 1 input", len(vectors))
+	}
+	return vectors[0], nil
+}
+
+// embedders are the embedders made so far, by the model asked for, so
+// graphs and re-embedding jobs share one per model.
+var embedders = struct {
+	sync.Mutex
+	byModel map[string]Embedder
+}{byModel: map[string]Embedder{}}
+
+// getEmbedder is the shared embedder for model, or for the configured one
+// when model is empty.
+func getEmbedder(model string) (Embedder, error) {
+	embedders.Lock()
+	defer embedders.Unlock()
+	if embedder, ok := embedders.byModel[model]; ok {
+		return embedder, nil
+	}
+	embedder, err := openEmbedder(model)
+	if err != nil {
+		return nil, err
+	}
+	embedders.byModel[model] = embedder
+	return embedder, nil
+}
+
+// hashEmbedder is a feature-hashing bag of tokens: crude, but free, fast and
+// deterministic. Good for tests and for keyword-heavy graphs.
+type hashEmbedder struct {
+	dimension int
+}
+
+func (h hashEmbedder) Model() string { return fmt.Sprintf("hashing-%d", h.dimension) }
+
+func (h hashEmbedder) Embed(_ context.Context, texts []string) ([][]float64, error) {
+	out := make([][]float64, len(texts))
+	for i, text := range texts {
+		v := make([]float64, h.dimension)
+		for _, token := range tokenize(text) {
+			f := fnv.New64a()
+			f.Write([]byte(token))
+			sum := f.Sum64()
+			sign := 1.0
+			if sum&(1<<63) != 0 {
+				sign = -1.0
+			}
+			v[sum%uint64(h.dimension)] += sign
+		}
+		out[i] = normalize(v)
+	}
+	return out, nil
+}
+
+// remoteEmbedder calls a text-embeddings-inference server ("tei") or an
+// OpenAI-compatible /v1/embeddings endpoint ("openai").
+type remoteEmbedder struct {
+	api    string
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+func newRemoteEmbedder(api, url, model, apiKey string) (*remoteEmbedder, error) {
+	if api != "tei" && api != "openai" {
+		return nil, fmt.Errorf("unknown embedding API: %s", api)
+	}
+	if url == "" {
+		return nil, fmt.Errorf("KG_EMBEDDING_URL is required for the %s embedding API", api)
+	}
+	return &remoteEmbedder{
+		api:    api,
+		url:    strings.TrimRight(url, "/"),
+		model:  model,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func (r *remoteEmbedder) Model() string { return r.model }
+
+func (r *remoteEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var path string
+	var body any
+	if r.api == "tei" {
+		path, body = "/embed", map[string]any{"inputs": texts, "normalize": true, "truncate": true}
+	} else {
+		path, body = "/v1/embeddings", map[string]any{"model": r.model, "input": texts}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding server returned HTTP %d", resp.StatusCode)
+	}
+
+	var vectors [][]float64
+	if r.api == "tei" {
+		if err := json.NewDecoder(resp.Body).Decode(&vectors); err != nil {
+			return nil, err
+		}
+	} else {
+		var parsed struct {
+			Data []struct {
+				Embedding []float64 `json:"embedding"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+			return nil, err
+		}
+		for _, d := range parsed.Data {
+			vectors = append(vectors, d.Embedding)
+		}
+	}
+	for _, v := range vectors {
+		normalize(v)
+	}
+	return vectors, nil
+}
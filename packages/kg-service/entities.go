@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Typed entity extraction, the rule-based half of the Python
+// entity_extraction: repository URLs, API URLs and endpoints, service names,
+// mentions and email addresses, plus the structured fields agents commonly
+// emit. The optional spaCy NER of the Python service has no Go counterpart.
+//
+// RE2 has no lookaround, so the repository pattern consumes the character
+// that ends the URL and the mention pattern the one before the @.
+var (
+	repoURLPattern  = regexp.MustCompile(`https?://(?:www\.)?(github\.com|gitlab\.com|bitbucket\.org)/([\w.-]+)/([\w.-]+?)(?:\.git)?(?:[/\s"'),]|$)`)
+	apiURLPattern   = regexp.MustCompile(`https?://[\w.-]+(?::\d+)?/(?:[\w.-]+/)*(?:api|v\d+)(?:/[\w.{}:-]+)*`)
+	endpointPattern = regexp.MustCompile(`\b(GET|POST|PUT|PATCH|DELETE)\s+(/[\w./{}:-]*)`)
+	servicePattern  = regexp.MustCompile(`(?i)\b([a-z][\w-]*?)[ -](?:service|svc|microservice)\b`)
+	mentionPattern  = regexp.MustCompile(`(?:^|[^\w.])@([A-Za-z][\w-]{1,38})\b`)
+	emailPattern    = regexp.MustCompile(`\b[\w.+-]+@[\w-]+\.[\w.-]+\b`)
+)
+
+// fieldTypes maps structured keys to the entity type their values name.
+var fieldTypes = map[string]string{
+	"repo": "repo", "repository": "repo",
+	"service": "service", "services": "service",
+	"author": "person", "assignee": "person", "owner": "person",
+	"api": "api", "endpoint": "api",
+}
+
+var serviceStopwords = map[string]bool{"the": true, "a": true, "an": true, "this": true, "that": true,
+	"web": true, "micro": true, "our": true, "each": true, "every": true}
+
+// Entity is a typed thing a context names.
+type Entity struct {
+	Type       string
+	Name       string
+	Confidence float64
+	Attributes map[string]any
+}
+
+// entityID matches the Python entity_id: the type and an md5 of the
+// lowercased name, so both services link the same entity node.
+func entityID(entityType, name string) string {
+	sum := md5.Sum([]byte(entityType + ":" + strings.ToLower(name)))
+	return entityType + "-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// ID is the entity's node ID.
+func (e Entity) ID() string { return entityID(e.Type, e.Name) }
+
+// extractEntities returns the entities a context names, each once with the
+// highest confidence it was found at, in the order first found.
+func extractEntities(data any, text string) []Entity {
+	var order []string
+	found := map[string]Entity{}
+	add := func(entity Entity) {
+		id := entity.ID()
+		current, ok := found[id]
+		if !ok {
+			order = append(order, id)
+		}
+		if !ok || current.Confidence < entity.Confidence {
+			found[id] = entity
+		}
+	}
+	if payload, ok := data.(map[string]any); ok {
+		for _, entity := range extractFields(payload) {
+			add(entity)
+		}
+	}
+	for _, entity := range extractRules(text) {
+		add(entity)
+	}
+	entities := make([]Entity, 0, len(order))
+	for _, id := range order {
+		entities = append(entities, found[id])
+	}
+	return entities
+}
+
+// extractFields takes entities from known keys, recursing into the objects
+// under other keys. Keys are read in order, since a decoded object has none.
+func extractFields(data map[string]any) []Entity {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var entities []Entity
+	for _, key := range keys {
+		value := data[key]
+		entityType, ok := fieldTypes[strings.ToLower(key)]
+		if !ok {
+			if nested, ok := value.(map[string]any); ok {
+				entities = append(entities, extractFields(nested)...)
+			}
+			continue
+		}
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		for _, item := range items {
+			if name, ok := item.(string); ok && strings.TrimSpace(name) != "" {
+				entities = append(entities, Entity{Type: entityType, Name: strings.TrimSpace(name), Confidence: 1.0,
+					Attributes: map[string]any{"source_field": key}})
+			}
+		}
+	}
+	return entities
+}
+
+func extractRules(text string) []Entity {
+	var entities []Entity
+	for _, m := range repoURLPattern.FindAllStringSubmatch(text, -1) {
+		host, owner, repo := m[1], m[2], m[3]
+		entities = append(entities, Entity{Type: "repo", Name: owner + "/" + repo, Confidence: 0.95,
+			Attributes: map[string]any{"url": "https://" + host + "/" + owner + "/" + repo}})
+	}
+	for _, url := range apiURLPattern.FindAllString(text, -1) {
+		entities = append(entities, Entity{Type: "api", Name: url, Confidence: 0.8, Attributes: map[string]any{"url": url}})
+	}
+	for _, m := range endpointPattern.FindAllStringSubmatch(text, -1) {
+		method, path := m[1], m[2]
+		entities = append(entities, Entity{Type: "api", Name: method + " " + path, Confidence: 0.85,
+			Attributes: map[string]any{"method": method, "path": path}})
+	}
+	for _, m := range servicePattern.FindAllStringSubmatch(text, -1) {
+		if name := strings.ToLower(m[1]); !serviceStopwords[name] {
+			entities = append(entities, Entity{Type: "service", Name: name, Confidence: 0.7, Attributes: map[string]any{}})
+		}
+	}
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		entities = append(entities, Entity{Type: "person", Name: m[1], Confidence: 0.6, Attributes: map[string]any{"handle": m[1]}})
+	}
+	for _, email := range emailPattern.FindAllString(text, -1) {
+		email = strings.ToLower(email)
+		entities = append(entities, Entity{Type: "person", Name: email, Confidence: 0.8, Attributes: map[string]any{"email": email}})
+	}
+	return entities
+}
+
+// linkEntities creates typed entity nodes for a context and links it to
+// them by the first entity relationship type; without one it does nothing.
+// The caller holds kg.mu.
+func (kg *KnowledgeGraph) linkEntities(ctx context.Context, nodeID string, data any, text, recordedAt string) error {
+	linkTypes := kg.config.ruleTypes("entity")
+	if len(linkTypes) == 0 {
+		return nil
+	}
+	for _, entity := range extractEntities(data, text) {
+		id := entity.ID()
+		existing, err := kg.store.GetNode(ctx, id)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			entityData := map[string]any{"name": entity.Name}
+			for k, v := range entity.Attributes {
+				entityData[k] = v
+			}
+			err = kg.store.AddNode(ctx, id, Attrs{
+				"data":           entityData,
+				"timestamp":      recordedAt,
+				"confirmed_at":   recordedAt,
+				"valid_from":     recordedAt,
+				"valid_to":       nil,
+				"invalidated_at": nil,
+				"node_type":      entity.Type,
+			})
+		} else {
+			err = kg.store.AddNode(ctx, id, Attrs{"confirmed_at": recordedAt})
+		}
+		if err != nil {
+			return err
+		}
+		err = kg.store.AddEdge(ctx, nodeID, id, Attrs{
+			"weight":            entity.Confidence,
+			"relationship_type": linkTypes[0].Name,
+			"timestamp":         recordedAt,
+			"valid_from":        recordedAt,
+			"valid_to":          nil,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entities lists the entity nodes, or those of one type, by ID.
+func (kg *KnowledgeGraph) Entities(ctx context.Context, entityType string) ([]map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entities := []map[string]any{}
+	for _, id := range sortedIDs(nodes) {
+		attrs := nodes[id]
+		t, ok := attrs["node_type"].(string)
+		if !ok || t == "context" || (entityType != "" && t != entityType) {
+			continue
+		}
+		entity := map[string]any{"node_id": id, "node_type": t}
+		if data, ok := attrs["data"].(map[string]any); ok {
+			for k, v := range data {
+				entity[k] = v
+			}
+		}
+		entities = append(entities, entity)
+	}
+	return entities, nil
+}
+
+// sortedIDs are the IDs of nodes in order.
+func sortedIDs(nodes map[string]Attrs) []string {
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,20 @@
+module github.com/jayp41/dynamic-context-mcp-system/packages/kg-service
+
+go 1.22
+
+require (
+	github.com/jayp41/dynamic-context-mcp-system/packages/apiversion v0.0.0
+	github.com/jayp41/dynamic-context-mcp-system/packages/events v0.0.0
+	github.com/jayp41/dynamic-context-mcp-system/packages/logging v0.0.0
+	github.com/jayp41/dynamic-context-mcp-system/packages/rbac v0.0.0
+	github.com/jayp41/dynamic-context-mcp-system/packages/tracing v0.0.0
+	github.com/lib/pq v1.10.9
+)
+
+replace (
+	github.com/jayp41/dynamic-context-mcp-system/packages/apiversion => ../apiversion
+	github.com/jayp41/dynamic-context-mcp-system/packages/events => ../events
+	github.com/jayp41/dynamic-context-mcp-system/packages/logging => ../logging
+	github.com/jayp41/dynamic-context-mcp-system/packages/rbac => ../rbac
+	github.com/jayp41/dynamic-context-mcp-system/packages/tracing => ../tracing
+)
@@ -0,0 +1,738 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errNotFound is returned for operations on nodes or edges that don't exist.
+	errNotFound = errors.New("not found")
+	// errUnknownRelationship is returned for edge types missing from the config.
+	errUnknownRelationship = errors.New("unknown relationship type")
+)
+
+// KnowledgeGraph is the Go port of the Python KnowledgeGraph: context
+// ingestion with near-duplicate merging and schema checks, configured
+// relationship rules, entity linking, bitemporal validity,
+// vector/keyword/hybrid search, importance and community analysis, pattern
+// queries, snapshots and diffs, and re-embedding. Graphiti is Python-only.
+type KnowledgeGraph struct {
+	mu    sync.Mutex
+	store Store
+	// embedder is the model stored vectors are made with, which serves until
+	// a re-embedding cuts over to targetEmbedder, the configured one.
+	embedder       Embedder
+	targetEmbedder Embedder
+	index          VectorIndex
+	keywords       *keywordIndex
+	config         *Config
+	// pendingMutations are the changes since importance was last refreshed.
+	pendingMutations int
+	decayMetrics     decayMetrics
+	// snapshotDir is where the graph's snapshots are kept.
+	snapshotDir string
+	quarantine  quarantine
+}
+
+// newKnowledgeGraph is the graph graphID, with its snapshots and quarantine
+// kept under the graph's own paths.
+func newKnowledgeGraph(graphID string, store Store, embedder, targetEmbedder Embedder, index VectorIndex, config *Config) *KnowledgeGraph {
+	return &KnowledgeGraph{store: store, embedder: embedder, targetEmbedder: targetEmbedder, index: index,
+		keywords: newKeywordIndex(), config: config, snapshotDir: snapshotDir(graphID),
+		quarantine: quarantine{quarantinePath(graphID)}}
+}
+
+// ApplyConfig takes thresholds and relationship rules from a loaded config.
+func (kg *KnowledgeGraph) ApplyConfig(config *Config) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	kg.config = config
+}
+
+// Config is the config the graph works by.
+func (kg *KnowledgeGraph) Config() *Config {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.config
+}
+
+// generateNodeID matches the Python generate_node_id: md5 of the payload
+// dumped with sorted keys.
+func generateNodeID(data any) string {
+	sum := md5.Sum([]byte(pyDumps(data)))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// contentHash hashes the normalized context text, ignoring key order and
+// whitespace.
+func contentHash(text string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func provenanceEntry(nodeID string, data any, hash string, similarity float64) map[string]any {
+	var source any
+	if payload, ok := data.(map[string]any); ok {
+		if metadata, ok := payload["metadata"].(map[string]any); ok {
+			source = metadata["source"]
+		}
+	}
+	return map[string]any{
+		"node_id":      nodeID,
+		"content_hash": hash,
+		"source":       source,
+		"ingested_at":  now(),
+		"similarity":   similarity,
+	}
+}
+
+func nodeType(attrs Attrs) string {
+	if t, ok := attrs["node_type"].(string); ok {
+		return t
+	}
+	return "context"
+}
+
+func number(v any, fallback float64) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func stringList(v any) []string {
+	items, _ := v.([]any)
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// embedLocked embeds text with the serving model before taking kg.mu, since
+// remote embedders are the slow part, and returns with kg.mu held unless it
+// fails. A re-embedding that cuts over meanwhile has the text embedded again.
+func (kg *KnowledgeGraph) embedLocked(ctx context.Context, text string) ([]float64, error) {
+	for {
+		kg.mu.Lock()
+		embedder := kg.embedder
+		kg.mu.Unlock()
+		embedding, err := embedOne(ctx, embedder, text)
+		if err != nil {
+			return nil, fmt.Errorf("embedding: %w", err)
+		}
+		kg.mu.Lock()
+		if kg.embedder == embedder {
+			return embedding, nil
+		}
+		kg.mu.Unlock()
+	}
+}
+
+// AddContextNode adds a JSON payload as a node, merging it into an existing
+// near-duplicate. It returns the ID of the node holding the context.
+func (kg *KnowledgeGraph) AddContextNode(ctx context.Context, raw json.RawMessage, validFrom, validTo string) (string, error) {
+	data, err := decodeJSON(raw)
+	if err != nil {
+		return "", err
+	}
+	text := contextText(raw)
+	embedding, err := kg.embedLocked(ctx, text)
+	if err != nil {
+		return "", err
+	}
+	defer kg.mu.Unlock()
+	return kg.addContextNode(ctx, data, text, embedding, validFrom, validTo)
+}
+
+// addContextNode adds a decoded payload and its text, embedded with the
+// serving model, once it passes the schema. The caller holds kg.mu.
+func (kg *KnowledgeGraph) addContextNode(ctx context.Context, data any, text string, embedding []float64, validFrom, validTo string) (string, error) {
+	if kg.config.Schema.enabled() {
+		if err := kg.checkSchema(data, validFrom, validTo); err != nil {
+			return "", err
+		}
+	}
+	return kg.insertContextNode(ctx, data, text, embedding, validFrom, validTo)
+}
+
+// insertContextNode adds a payload without validating it. The caller holds
+// kg.mu.
+func (kg *KnowledgeGraph) insertContextNode(ctx context.Context, data any, text string, embedding []float64, validFrom, validTo string) (string, error) {
+	nodeID := generateNodeID(data)
+	recordedAt := now()
+
+	existing, err := kg.store.GetNode(ctx, nodeID)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		// Identical payload ingested again; just re-confirm it
+		return nodeID, kg.store.AddNode(ctx, nodeID, Attrs{"confirmed_at": recordedAt, "decay_weight": 1.0})
+	}
+
+	hash := contentHash(text)
+	duplicate, similarity, err := kg.findDuplicate(ctx, hash, embedding)
+	if err != nil {
+		return "", err
+	}
+	if duplicate != "" {
+		return duplicate, kg.recordProvenance(ctx, duplicate, nodeID, data, hash, similarity)
+	}
+
+	var from any = recordedAt
+	if validFrom != "" {
+		from = validFrom
+	}
+	var to any
+	if validTo != "" {
+		to = validTo
+	}
+	err = kg.store.AddNode(ctx, nodeID, Attrs{
+		"data":            data,
+		"timestamp":       recordedAt,
+		"confirmed_at":    recordedAt,
+		"valid_from":      from,
+		"valid_to":        to,
+		"invalidated_at":  nil,
+		"node_type":       "context",
+		"content_hash":    hash,
+		"provenance":      []any{provenanceEntry(nodeID, data, hash, 1.0)},
+		"embedding":       embedding,
+		"embedding_model": kg.embedder.Model(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := kg.index.Upsert(ctx, nodeID, embedding); err != nil {
+		return "", err
+	}
+	if kg.keywords.built {
+		kg.keywords.add(nodeID, text)
+	}
+
+	if err := kg.createSemanticRelationships(ctx, nodeID, embedding, recordedAt); err != nil {
+		return "", err
+	}
+	if err := kg.createFieldRelationships(ctx, nodeID, data, recordedAt); err != nil {
+		return "", err
+	}
+	if err := kg.linkEntities(ctx, nodeID, data, text, recordedAt); err != nil {
+		return "", err
+	}
+	return nodeID, kg.noteMutation(ctx, 1)
+}
+
+func (kg *KnowledgeGraph) findDuplicate(ctx context.Context, hash string, embedding []float64) (string, float64, error) {
+	hits, err := kg.index.Search(ctx, embedding, 5, kg.config.SearchThreshold)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, hit := range hits {
+		attrs, err := kg.store.GetNode(ctx, hit.NodeID)
+		if err != nil {
+			return "", 0, err
+		}
+		if attrs == nil {
+			continue
+		}
+		if attrs["content_hash"] == hash || hit.Score >= kg.config.DedupThreshold {
+			return hit.NodeID, hit.Score, nil
+		}
+	}
+	return "", 0, nil
+}
+
+// recordProvenance notes that a duplicate ingestion was folded into nodeID.
+func (kg *KnowledgeGraph) recordProvenance(ctx context.Context, nodeID, duplicateID string, data any, hash string, similarity float64) error {
+	attrs, err := kg.store.GetNode(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	provenance, _ := attrs["provenance"].([]any)
+	provenance = append(provenance, provenanceEntry(duplicateID, data, hash, similarity))
+
+	aliases := stringList(attrs["aliases"])
+	if !contains(aliases, duplicateID) {
+		aliases = append(aliases, duplicateID)
+	}
+	sort.Strings(aliases)
+	return kg.store.AddNode(ctx, nodeID, Attrs{
+		"provenance":   provenance,
+		"aliases":      aliases,
+		"merged_count": len(aliases),
+		"confirmed_at": now(),
+		"decay_weight": 1.0,
+	})
+}
+
+func (kg *KnowledgeGraph) createSemanticRelationships(ctx context.Context, nodeID string, embedding []float64, validFrom string) error {
+	for _, spec := range kg.config.ruleTypes("similarity") {
+		low, high := orDefault(spec.MinSimilarity, 0.5), orDefault(spec.MaxSimilarity, 1.0)
+		hits, err := kg.index.Search(ctx, embedding, orDefault(spec.MaxPerNode, 50)+1, low)
+		if err != nil {
+			return err
+		}
+		for _, hit := range hits {
+			if hit.NodeID == nodeID || hit.Score > high {
+				continue
+			}
+			attrs, err := kg.store.GetNode(ctx, hit.NodeID)
+			if err != nil {
+				return err
+			}
+			if attrs == nil || !spec.allows("context", nodeType(attrs)) {
+				continue
+			}
+			err = kg.store.AddEdge(ctx, nodeID, hit.NodeID, Attrs{
+				"weight":            hit.Score,
+				"relationship_type": spec.Name,
+				"timestamp":         validFrom,
+				"valid_from":        validFrom,
+				"valid_to":          nil,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createFieldRelationships links to nodes the context names under configured
+// fields (e.g. references).
+func (kg *KnowledgeGraph) createFieldRelationships(ctx context.Context, nodeID string, data any, validFrom string) error {
+	payload, ok := data.(map[string]any)
+	if !ok {
+		return nil
+	}
+	for _, spec := range kg.config.ruleTypes("field") {
+		refs, ok := payload[spec.Field].([]any)
+		if !ok {
+			refs = []any{payload[spec.Field]}
+		}
+		for _, ref := range refs {
+			name, ok := ref.(string)
+			if !ok {
+				continue
+			}
+			target, attrs, err := kg.resolveReference(ctx, name)
+			if err != nil {
+				return err
+			}
+			if target == "" || target == nodeID || !spec.allows("context", nodeType(attrs)) {
+				continue
+			}
+			err = kg.store.AddEdge(ctx, nodeID, target, Attrs{
+				"weight":            orDefault(spec.Weight, 1.0),
+				"relationship_type": spec.Name,
+				"timestamp":         validFrom,
+				"valid_from":        validFrom,
+				"valid_to":          nil,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveReference resolves a node ID, merged-away alias, or content hash.
+func (kg *KnowledgeGraph) resolveReference(ctx context.Context, ref string) (string, Attrs, error) {
+	attrs, err := kg.store.GetNode(ctx, ref)
+	if err != nil || attrs != nil {
+		return ref, attrs, err
+	}
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	for id, attrs := range nodes {
+		if contains(stringList(attrs["aliases"]), ref) || attrs["content_hash"] == ref {
+			return id, attrs, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// ensureKeywords builds the keyword index on first use.
+func (kg *KnowledgeGraph) ensureKeywords(ctx context.Context) error {
+	if kg.keywords.built {
+		return nil
+	}
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	for id, attrs := range nodes {
+		encoded, err := json.Marshal(attrs["data"])
+		if err != nil {
+			return err
+		}
+		kg.keywords.add(id, contextText(encoded))
+	}
+	kg.keywords.built = true
+	return nil
+}
+
+// searchResult is one search hit as returned by the API.
+type searchResult map[string]any
+
+func resultFor(nodeID string, attrs Attrs, score float64) searchResult {
+	data := attrs["data"]
+	if data == nil {
+		data = map[string]any{}
+	}
+	return searchResult{
+		"node_id":    nodeID,
+		"score":      score * number(attrs["decay_weight"], 1.0),
+		"importance": number(attrs["importance"], 0.0),
+		"data":       data,
+		"valid_from": attrs["valid_from"],
+		"valid_to":   attrs["valid_to"],
+	}
+}
+
+// rankResults orders by score rounded to the given digits, with importance
+// breaking ties so hub knowledge surfaces above one-off mentions.
+func rankResults(results []searchResult, digits, limit int) []searchResult {
+	scale := math.Pow(10, float64(digits))
+	key := func(r searchResult) float64 { return math.Round(r["score"].(float64)*scale) / scale }
+	sort.SliceStable(results, func(i, j int) bool {
+		if ki, kj := key(results[i]), key(results[j]); ki != kj {
+			return ki > kj
+		}
+		return results[i]["importance"].(float64) > results[j]["importance"].(float64)
+	})
+	if len(results) > max(limit, 0) {
+		results = results[:max(limit, 0)]
+	}
+	return results
+}
+
+// SearchSemantic is vector search over nodes visible at the given times.
+func (kg *KnowledgeGraph) SearchSemantic(ctx context.Context, query string, limit int, asOf, validAt time.Time) ([]searchResult, error) {
+	embedding, err := kg.embedLocked(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer kg.mu.Unlock()
+
+	// Over-fetch since stale nodes are filtered out after the ANN lookup
+	hits, err := kg.index.Search(ctx, embedding, limit*3, kg.config.SearchThreshold)
+	if err != nil {
+		return nil, err
+	}
+	results := []searchResult{}
+	for _, hit := range hits {
+		attrs, err := kg.store.GetNode(ctx, hit.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		if attrs == nil || !visible(attrs, asOf, validAt) {
+			continue
+		}
+		result := resultFor(hit.NodeID, attrs, hit.Score)
+		result["similarity"] = hit.Score
+		results = append(results, result)
+	}
+	results = rankResults(results, 2, limit)
+	return results, kg.touch(ctx, results)
+}
+
+// SearchKeyword is BM25 search, good at exact identifiers and error codes.
+func (kg *KnowledgeGraph) SearchKeyword(ctx context.Context, query string, limit int) ([]searchResult, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	if err := kg.ensureKeywords(ctx); err != nil {
+		return nil, err
+	}
+	results := []searchResult{}
+	for _, hit := range kg.keywords.search(query, limit) {
+		node, err := kg.getNode(ctx, hit.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		result := searchResult{}
+		for k, v := range node {
+			result[k] = v
+		}
+		result["node_id"] = hit.NodeID
+		result["bm25"] = hit.Score
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// SearchHybrid fuses vector and keyword rankings with reciprocal rank fusion.
+func (kg *KnowledgeGraph) SearchHybrid(ctx context.Context, query string, limit int, asOf, validAt time.Time) ([]searchResult, error) {
+	embedding, err := kg.embedLocked(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer kg.mu.Unlock()
+
+	vectorHits, err := kg.index.Search(ctx, embedding, limit*3, kg.config.SearchThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if err := kg.ensureKeywords(ctx); err != nil {
+		return nil, err
+	}
+	keywordHits := kg.keywords.search(query, limit*3)
+
+	similarity := map[string]float64{}
+	for _, hit := range vectorHits {
+		similarity[hit.NodeID] = hit.Score
+	}
+	bm25 := map[string]float64{}
+	for _, hit := range keywordHits {
+		bm25[hit.NodeID] = hit.Score
+	}
+	optional := func(scores map[string]float64, id string) any {
+		if score, ok := scores[id]; ok {
+			return score
+		}
+		return nil
+	}
+
+	fused := reciprocalRankFusion(vectorHits, keywordHits)
+	ids := make([]string, 0, len(fused))
+	for id := range fused {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	results := []searchResult{}
+	for _, id := range ids {
+		attrs, err := kg.store.GetNode(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if attrs == nil || !visible(attrs, asOf, validAt) {
+			continue
+		}
+		result := resultFor(id, attrs, fused[id])
+		result["similarity"] = optional(similarity, id)
+		result["bm25"] = optional(bm25, id)
+		results = append(results, result)
+	}
+	results = rankResults(results, 4, limit)
+	return results, kg.touch(ctx, results)
+}
+
+// touch records read access so decay keeps frequently used nodes alive.
+func (kg *KnowledgeGraph) touch(ctx context.Context, results []searchResult) error {
+	accessedAt := now()
+	for _, result := range results {
+		err := kg.store.AddNode(ctx, result["node_id"].(string), Attrs{"last_accessed_at": accessedAt, "decay_weight": 1.0})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNode returns a node's attributes without its embeddings, or nil.
+func (kg *KnowledgeGraph) GetNode(ctx context.Context, nodeID string) (Attrs, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.getNode(ctx, nodeID)
+}
+
+func (kg *KnowledgeGraph) getNode(ctx context.Context, nodeID string) (Attrs, error) {
+	attrs, err := kg.store.GetNode(ctx, nodeID)
+	if err != nil || attrs == nil {
+		return nil, err
+	}
+	for _, field := range []string{"embedding", "embedding_next", "embedding_next_model"} {
+		delete(attrs, field)
+	}
+	attrs["node_id"] = nodeID
+	return attrs, kg.touch(ctx, []searchResult{{"node_id": nodeID}})
+}
+
+// AddEdge creates an explicit relationship between two existing nodes.
+func (kg *KnowledgeGraph) AddEdge(ctx context.Context, source, target, relationshipType string, weight float64, validFrom, validTo string, extra Attrs) error {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	if _, ok := kg.config.RelationshipTypes[relationshipType]; !ok {
+		return fmt.Errorf("%w: %s", errUnknownRelationship, relationshipType)
+	}
+	endpoints := make([]Attrs, 2)
+	for i, id := range []string{source, target} {
+		attrs, err := kg.store.GetNode(ctx, id)
+		if err != nil {
+			return err
+		}
+		if attrs == nil {
+			return fmt.Errorf("node %w: %s", errNotFound, id)
+		}
+		endpoints[i] = attrs
+	}
+	if kg.config.Schema.enabled() {
+		if found := kg.config.Schema.edgeErrors(relationshipType, endpoints[0], endpoints[1]); len(found) > 0 {
+			return schemaError{found}
+		}
+	}
+
+	recordedAt := now()
+	attrs := copyAttrs(extra)
+	attrs["weight"] = weight
+	attrs["relationship_type"] = relationshipType
+	attrs["timestamp"] = recordedAt
+	attrs["valid_from"] = recordedAt
+	if validFrom != "" {
+		attrs["valid_from"] = validFrom
+	}
+	attrs["valid_to"] = nil
+	if validTo != "" {
+		attrs["valid_to"] = validTo
+	}
+	return kg.store.AddEdge(ctx, source, target, attrs)
+}
+
+// InvalidateNode marks a node as no longer valid from at (default: now).
+func (kg *KnowledgeGraph) InvalidateNode(ctx context.Context, nodeID, at string) error {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	attrs, err := kg.store.GetNode(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	if attrs == nil {
+		return errNotFound
+	}
+	if at == "" {
+		at = now()
+	}
+	return kg.store.AddNode(ctx, nodeID, Attrs{"valid_to": at, "invalidated_at": now()})
+}
+
+// InvalidateEdge marks a relationship as no longer valid from at.
+func (kg *KnowledgeGraph) InvalidateEdge(ctx context.Context, source, target, at string) error {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	attrs, err := kg.store.GetEdge(ctx, source, target)
+	if err != nil {
+		return err
+	}
+	if attrs == nil {
+		return errNotFound
+	}
+	if at == "" {
+		at = now()
+	}
+	return kg.store.AddEdge(ctx, source, target, Attrs{"valid_to": at})
+}
+
+// Stats reports the same figures as the Python get_graph_stats.
+func (kg *KnowledgeGraph) Stats(ctx context.Context) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Weakly connected components by union-find
+	parent := make(map[string]string, len(nodes))
+	var find func(string) string
+	find = func(id string) string {
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+	for id := range nodes {
+		parent[id] = id
+	}
+	components := len(nodes)
+	for _, edge := range edges {
+		if a, b := find(edge.Source), find(edge.Target); a != b {
+			parent[a] = b
+			components--
+		}
+	}
+
+	n := float64(len(nodes))
+	density := 0.0
+	if n > 1 {
+		density = float64(len(edges)) / (n * (n - 1))
+	}
+	return map[string]any{
+		"nodes":           len(nodes),
+		"edges":           len(edges),
+		"density":         density,
+		"components":      components,
+		"embedding_model": kg.embedder.Model(),
+		"vector_index":    kg.index.Name(),
+	}, nil
+}
+
+// NumberOfNodes is how many nodes the graph has.
+func (kg *KnowledgeGraph) NumberOfNodes(ctx context.Context) (int, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.store.NumberOfNodes(ctx)
+}
+
+// Clear removes every node, and its vector, and returns how many there were.
+func (kg *KnowledgeGraph) Clear(ctx context.Context) (int, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.clear(ctx)
+}
+
+func (kg *KnowledgeGraph) clear(ctx context.Context) (int, error) {
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for id := range nodes {
+		if err := kg.store.RemoveNode(ctx, id); err != nil {
+			return 0, err
+		}
+		if err := kg.index.Delete(ctx, id); err != nil {
+			return 0, err
+		}
+	}
+	kg.keywords = newKeywordIndex()
+	return len(nodes), nil
+}
+
+// Close closes the graph's store and vector index.
+func (kg *KnowledgeGraph) Close() error {
+	var errs []error
+	if err := kg.store.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("closing the store: %w", err))
+	}
+	if err := kg.index.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("closing the %s index: %w", kg.index.Name(), err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Graph files in the Python graph_io formats. JSON Lines holds a
+// graphRecord per line, nodes first. GraphML only carries scalar
+// attributes, so every attribute value is JSON-encoded.
+
+var errUnsupportedGraphFormat = errors.New("unsupported graph format")
+
+// graphFormat resolves a file's format from its name, or the file
+// extension when it names none.
+func graphFormat(path, format string) (string, error) {
+	if format == "" {
+		format = "jsonl"
+		if strings.HasSuffix(path, ".graphml") {
+			format = "graphml"
+		}
+	}
+	if format != "jsonl" && format != "graphml" {
+		return "", fmt.Errorf("%w: %s", errUnsupportedGraphFormat, format)
+	}
+	return format, nil
+}
+
+// writeGraphFile writes nodes and edges to path, creating its directory.
+func writeGraphFile(path, format string, nodes map[string]Attrs, edges []Edge) error {
+	var body bytes.Buffer
+	var err error
+	if format == "graphml" {
+		err = writeGraphML(&body, nodes, edges)
+	} else {
+		err = writeRecords(json.NewEncoder(&body), nodes, edges)
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, body.Bytes())
+}
+
+// readGraphFile reads the records of a graph file.
+func readGraphFile(path, format string) ([]graphRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if format == "graphml" {
+		return readGraphML(f)
+	}
+
+	var records []graphRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record graphRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// recordGraph splits records into the nodes and edges they hold.
+func recordGraph(records []graphRecord) (map[string]Attrs, []Edge) {
+	nodes := map[string]Attrs{}
+	var edges []Edge
+	for _, record := range records {
+		if record.Kind == "node" {
+			nodes[record.ID] = record.Attrs
+		} else {
+			edges = append(edges, Edge{Source: record.Source, Target: record.Target, Attrs: record.Attrs})
+		}
+	}
+	return nodes, edges
+}
+
+const graphMLNamespace = "http://graphml.graphdrawing.org/xmlns"
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr,omitempty"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// writeGraphML writes nodes, by ID, and edges as a directed GraphML graph.
+func writeGraphML(w io.Writer, nodes map[string]Attrs, edges []Edge) error {
+	doc := graphMLDocument{Xmlns: graphMLNamespace, Graph: graphMLGraph{EdgeDefault: "directed"}}
+	keys := map[[2]string]string{}
+	encode := func(domain string, attrs Attrs) ([]graphMLData, error) {
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		data := make([]graphMLData, 0, len(names))
+		for _, name := range names {
+			key, ok := keys[[2]string{domain, name}]
+			if !ok {
+				key = fmt.Sprintf("d%d", len(keys))
+				keys[[2]string{domain, name}] = key
+				doc.Keys = append(doc.Keys, graphMLKey{ID: key, For: domain, Name: name, Type: "string"})
+			}
+			encoded, err := json.Marshal(attrs[name])
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, graphMLData{Key: key, Value: string(encoded)})
+		}
+		return data, nil
+	}
+	for _, id := range sortedIDs(nodes) {
+		data, err := encode("node", nodes[id])
+		if err != nil {
+			return err
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: id, Data: data})
+	}
+	for _, edge := range edges {
+		data, err := encode("edge", edge.Attrs)
+		if err != nil {
+			return err
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: edge.Source, Target: edge.Target, Data: data})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// readGraphML reads the records of a GraphML graph of JSON-encoded
+// attributes.
+func readGraphML(r io.Reader) ([]graphRecord, error) {
+	var doc graphMLDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, key := range doc.Keys {
+		names[key.ID] = key.Name
+	}
+	decode := func(data []graphMLData) (Attrs, error) {
+		attrs := Attrs{}
+		for _, item := range data {
+			name, ok := names[item.Key]
+			if !ok {
+				name = item.Key
+			}
+			var value any
+			if err := json.Unmarshal([]byte(item.Value), &value); err != nil {
+				return nil, fmt.Errorf("attribute %s: %w", name, err)
+			}
+			attrs[name] = value
+		}
+		return attrs, nil
+	}
+
+	var records []graphRecord
+	for _, node := range doc.Graph.Nodes {
+		attrs, err := decode(node.Data)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %w", node.ID, err)
+		}
+		records = append(records, graphRecord{Kind: "node", ID: node.ID, Attrs: attrs})
+	}
+	for _, edge := range doc.Graph.Edges {
+		attrs, err := decode(edge.Data)
+		if err != nil {
+			return nil, fmt.Errorf("edge %s->%s: %w", edge.Source, edge.Target, err)
+		}
+		records = append(records, graphRecord{Kind: "edge", Source: edge.Source, Target: edge.Target, Attrs: attrs})
+	}
+	return records, nil
+}
+
+// Export writes every node and edge of the graph to a JSON Lines or
+// GraphML file.
+func (kg *KnowledgeGraph) Export(ctx context.Context, path, format string) (map[string]any, error) {
+	format, err := graphFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeGraphFile(path, format, nodes, edges); err != nil {
+		return nil, err
+	}
+	return map[string]any{"path": path, "format": format, "nodes": len(nodes), "edges": len(edges)}, nil
+}
+
+// GraphML is the whole graph as a GraphML document.
+func (kg *KnowledgeGraph) GraphML(ctx context.Context) ([]byte, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if err := writeGraphML(&body, nodes, edges); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// Import merges the nodes and edges of a JSON Lines or GraphML file into
+// the graph, re-indexing their embeddings.
+func (kg *KnowledgeGraph) Import(ctx context.Context, path, format string) (map[string]any, error) {
+	format, err := graphFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+	records, err := readGraphFile(path, format)
+	if err != nil {
+		return nil, err
+	}
+	nodes, edges, err := kg.Load(ctx, records)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"path": path, "format": format, "nodes": nodes, "edges": edges}, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// PageRank importance scores for graph nodes, as in the Python
+// graph_importance. Each refresh warm-starts power iteration from the
+// stored scores, so after small batches of changes it converges in a
+// handful of iterations.
+
+const (
+	pagerankAlpha   = 0.85
+	pagerankTol     = 1e-6
+	pagerankMaxIter = 200
+)
+
+// currentGraph is the graph as visible now. The caller holds kg.mu.
+func (kg *KnowledgeGraph) currentGraph(ctx context.Context) (map[string]Attrs, []Edge, error) {
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	at := time.Now().UTC()
+	nodes, edges = filterGraph(nodes, edges, at, at)
+	return nodes, edges, nil
+}
+
+// pagerank ranks the nodes of an undirected weighted graph; start, when not
+// nil, is where power iteration starts from.
+func pagerank(ids []string, adjacency map[string]map[string]float64, start map[string]float64) (map[string]float64, error) {
+	n := float64(len(ids))
+	outWeight := make(map[string]float64, len(ids))
+	for _, id := range ids {
+		for _, w := range adjacency[id] {
+			outWeight[id] += w
+		}
+	}
+	scores := make(map[string]float64, len(ids))
+	total := 0.0
+	for _, id := range ids {
+		scores[id] = 1 / n
+		if start != nil {
+			scores[id] = start[id]
+		}
+		total += scores[id]
+	}
+	for _, id := range ids {
+		scores[id] /= total
+	}
+
+	for range pagerankMaxIter {
+		last := scores
+		scores = make(map[string]float64, len(ids))
+		dangling := 0.0
+		for _, id := range ids {
+			if outWeight[id] == 0 {
+				dangling += last[id]
+				continue
+			}
+			for neighbour, w := range adjacency[id] {
+				scores[neighbour] += pagerankAlpha * last[id] * w / outWeight[id]
+			}
+		}
+		spread := (pagerankAlpha*dangling + 1 - pagerankAlpha) / n
+		change := 0.0
+		for _, id := range ids {
+			scores[id] += spread
+			change += math.Abs(scores[id] - last[id])
+		}
+		if change < n*pagerankTol {
+			return scores, nil
+		}
+	}
+	return nil, fmt.Errorf("pagerank did not converge in %d iterations", pagerankMaxIter)
+}
+
+// refreshImportance recomputes PageRank over the visible graph, ranking on
+// both directions of each edge, and stores it normalized so the most
+// important node scores 1.0. The caller holds kg.mu.
+func (kg *KnowledgeGraph) refreshImportance(ctx context.Context) (map[string]any, error) {
+	kg.pendingMutations = 0
+	nodes, edges, err := kg.currentGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return map[string]any{"nodes": 0, "changed": 0}, nil
+	}
+
+	ids := sortedIDs(nodes)
+	previous := map[string]float64{}
+	for _, id := range ids {
+		if score, ok := nodes[id]["importance"]; ok && score != nil {
+			previous[id] = number(score, 0)
+		}
+	}
+	var start map[string]float64
+	if len(previous) > 0 {
+		start = make(map[string]float64, len(ids))
+		for _, id := range ids {
+			start[id] = previous[id]
+			if start[id] == 0 {
+				start[id] = 1 / float64(len(ids))
+			}
+		}
+	}
+	scores, err := pagerank(ids, undirected(nodes, edges), start)
+	if err != nil {
+		return nil, err
+	}
+
+	top := 0.0
+	for _, score := range scores {
+		top = max(top, score)
+	}
+	if top == 0 {
+		top = 1
+	}
+	changed := 0
+	for _, id := range ids {
+		normalized := scores[id] / top
+		if last, ok := previous[id]; ok && math.Abs(last-normalized) <= 1e-4 {
+			continue
+		}
+		if err := kg.store.AddNode(ctx, id, Attrs{"importance": normalized}); err != nil {
+			return nil, err
+		}
+		changed++
+	}
+	return map[string]any{"nodes": len(scores), "changed": changed}, nil
+}
+
+// RefreshImportance recomputes every node's importance now.
+func (kg *KnowledgeGraph) RefreshImportance(ctx context.Context) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.refreshImportance(ctx)
+}
+
+// noteMutation counts changes to the graph, refreshing importance once
+// importance_refresh_every have built up. The caller holds kg.mu.
+func (kg *KnowledgeGraph) noteMutation(ctx context.Context, count int) error {
+	kg.pendingMutations += count
+	if kg.pendingMutations < kg.config.ImportanceRefreshEvery {
+		return nil
+	}
+	_, err := kg.refreshImportance(ctx)
+	return err
+}
+
+// ImportantNodes are the most important nodes, or those of one type.
+func (kg *KnowledgeGraph) ImportantNodes(ctx context.Context, limit int, nodeType string) ([]map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ranked := []map[string]any{}
+	for _, id := range sortedIDs(nodes) {
+		attrs := nodes[id]
+		if nodeType != "" && attrs["node_type"] != nodeType {
+			continue
+		}
+		data := attrs["data"]
+		if data == nil {
+			data = map[string]any{}
+		}
+		ranked = append(ranked, map[string]any{"node_id": id, "node_type": attrs["node_type"],
+			"importance": number(attrs["importance"], 0), "data": data})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i]["importance"].(float64) > ranked[j]["importance"].(float64)
+	})
+	return ranked[:min(max(limit, 0), len(ranked))], nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Bulk NDJSON ingestion with a bounded worker pool, as in the Python
+// graph_ingest. Each line is a node request ({"data": {...}, "valid_from":
+// ...}) or a bare context object. Items are queued in fixed-size batches;
+// when the queue cannot take a whole request it is refused with
+// backpressureError so the caller retries later instead of the service
+// buffering without bound.
+
+// maxTrackedJobs is how many of the most recent ingest jobs are kept.
+const maxTrackedJobs = 100
+
+// backpressureError refuses an ingest request the queue has no room for.
+type backpressureError struct {
+	retryAfter int
+}
+
+func (e backpressureError) Error() string {
+	return fmt.Sprintf("ingest queue is full, retry after %ds", e.retryAfter)
+}
+
+// jobID is a random ID for a background job.
+func jobID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// ingestItem is a payload to add as a node.
+type ingestItem struct {
+	data               any
+	text               string
+	validFrom, validTo string
+}
+
+// parseItem parses an NDJSON line into a node request.
+func parseItem(line []byte) (ingestItem, error) {
+	record, err := decodeJSON(line)
+	if err != nil {
+		return ingestItem{}, err
+	}
+	object, ok := record.(map[string]any)
+	if !ok {
+		return ingestItem{}, errors.New("expected a JSON object")
+	}
+	data, ok := object["data"].(map[string]any)
+	if !ok {
+		return ingestItem{data: record, text: contextText(line)}, nil
+	}
+	var request struct {
+		Data      json.RawMessage `json:"data"`
+		ValidFrom *string         `json:"valid_from"`
+		ValidTo   *string         `json:"valid_to"`
+	}
+	if err := json.Unmarshal(line, &request); err != nil {
+		return ingestItem{}, err
+	}
+	return ingestItem{data: data, text: contextText(request.Data),
+		validFrom: orDefault(request.ValidFrom, ""), validTo: orDefault(request.ValidTo, "")}, nil
+}
+
+// parseNDJSON parses an NDJSON body into node requests, with an error for
+// each line it could not take.
+func parseNDJSON(body []byte) ([]ingestItem, []map[string]any) {
+	var items []ingestItem
+	lineErrors := []map[string]any{}
+	for number, line := range bytes.Split(body, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		item, err := parseItem(line)
+		if err != nil {
+			lineErrors = append(lineErrors, map[string]any{"line": number + 1, "error": err.Error()})
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, lineErrors
+}
+
+// ingestJob is the progress of one ingest request.
+type ingestJob struct {
+	JobID       string           `json:"job_id"`
+	State       string           `json:"state"`
+	Total       int              `json:"total"`
+	Processed   int              `json:"processed"`
+	Created     int              `json:"created"`
+	Merged      int              `json:"merged"`
+	Quarantined int              `json:"quarantined"`
+	Failed      int              `json:"failed"`
+	Rejected    int              `json:"rejected"`
+	Errors      []map[string]any `json:"errors"`
+	SubmittedAt string           `json:"submitted_at"`
+	FinishedAt  any              `json:"finished_at"`
+	// pending are the job's batches not processed yet.
+	pending int
+}
+
+type ingestBatch struct {
+	job   *ingestJob
+	items []ingestItem
+}
+
+// ingestPool embeds and adds queued batches on a fixed set of workers.
+// Embeddings are computed a batch at a time outside the graph lock; only
+// the graph writes are serialised.
+type ingestPool struct {
+	kg        *KnowledgeGraph
+	workers   int
+	batchSize int
+	queue     chan ingestBatch
+	stop      context.CancelFunc
+
+	mu   sync.Mutex
+	jobs map[string]*ingestJob
+	// order are the tracked job IDs, oldest first.
+	order []string
+}
+
+// newIngestPool starts the workers of a pool sized by KG_INGEST_WORKERS,
+// KG_INGEST_BATCH_SIZE and KG_INGEST_QUEUE_BATCHES.
+func newIngestPool(kg *KnowledgeGraph) (*ingestPool, error) {
+	sizes := map[string]int{"KG_INGEST_WORKERS": 2, "KG_INGEST_BATCH_SIZE": 64, "KG_INGEST_QUEUE_BATCHES": 64}
+	for name := range sizes {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			sizes[name] = n
+		}
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	p := &ingestPool{
+		kg:        kg,
+		workers:   sizes["KG_INGEST_WORKERS"],
+		batchSize: sizes["KG_INGEST_BATCH_SIZE"],
+		queue:     make(chan ingestBatch, sizes["KG_INGEST_QUEUE_BATCHES"]),
+		stop:      stop,
+		jobs:      map[string]*ingestJob{},
+	}
+	for range p.workers {
+		go p.loop(ctx)
+	}
+	return p, nil
+}
+
+// Close stops the workers after the batches they are on; the queued ones
+// are dropped.
+func (p *ingestPool) Close() {
+	p.stop()
+}
+
+// retryAfter roughly estimates the seconds the queue takes to drain: one
+// per queued batch per worker.
+func (p *ingestPool) retryAfter() int {
+	return max(1, len(p.queue)/p.workers)
+}
+
+// Submit queues items as one job, or refuses them with a backpressureError
+// if they do not fit.
+func (p *ingestPool) Submit(items []ingestItem, lineErrors []map[string]any) (ingestJob, error) {
+	var batches [][]ingestItem
+	for start := 0; start < len(items); start += p.batchSize {
+		batches = append(batches, items[start:min(start+p.batchSize, len(items))])
+	}
+	job := &ingestJob{JobID: jobID(), State: "running", Total: len(items), Rejected: len(lineErrors),
+		Errors: lineErrors, SubmittedAt: now(), pending: len(batches)}
+	if len(items) == 0 {
+		job.State, job.FinishedAt = "done", job.SubmittedAt
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Only Submit queues batches, so the room checked is still there
+	if cap(p.queue)-len(p.queue) < len(batches) {
+		return ingestJob{}, backpressureError{p.retryAfter()}
+	}
+	p.jobs[job.JobID] = job
+	p.order = append(p.order, job.JobID)
+	for len(p.order) > maxTrackedJobs {
+		delete(p.jobs, p.order[0])
+		p.order = p.order[1:]
+	}
+	for _, batch := range batches {
+		p.queue <- ingestBatch{job, batch}
+	}
+	return job.status(), nil
+}
+
+// status is a copy of the job with its first 20 errors. The caller holds
+// p.mu.
+func (job *ingestJob) status() ingestJob {
+	status := *job
+	status.Processed = job.Created + job.Merged + job.Quarantined + job.Failed
+	status.Errors = job.Errors[:min(len(job.Errors), 20)]
+	return status
+}
+
+// Job is the status of a tracked job.
+func (p *ingestPool) Job(id string) (ingestJob, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, ok := p.jobs[id]
+	if !ok {
+		return ingestJob{}, false
+	}
+	return job.status(), true
+}
+
+func (p *ingestPool) loop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case batch := <-p.queue:
+			p.process(ctx, batch)
+			p.mu.Lock()
+			batch.job.pending--
+			if batch.job.pending == 0 {
+				batch.job.State, batch.job.FinishedAt = "done", now()
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// process embeds a batch, then adds its items one at a time under the
+// graph lock.
+func (p *ingestPool) process(ctx context.Context, batch ingestBatch) {
+	job := batch.job
+	texts := make([]string, len(batch.items))
+	for i, item := range batch.items {
+		texts[i] = item.text
+	}
+	p.kg.mu.Lock()
+	embedder := p.kg.embedder
+	p.kg.mu.Unlock()
+	embeddings, err := embedder.Embed(ctx, texts)
+	if err == nil && len(embeddings) != len(texts) {
+		err = fmt.Errorf("embedder returned %d vectors for %d inputs", len(embeddings), len(texts))
+	}
+	if err != nil {
+		p.mu.Lock()
+		job.Failed += len(batch.items)
+		job.Errors = append(job.Errors, map[string]any{"error": "embedding: " + err.Error()})
+		p.mu.Unlock()
+		return
+	}
+
+	for i, item := range batch.items {
+		nodeID, err := p.kg.addEmbedded(ctx, item, embedder, embeddings[i])
+		p.mu.Lock()
+		switch {
+		case errors.As(err, new(quarantinedError)):
+			job.Quarantined++
+		case err != nil:
+			job.Failed++
+			job.Errors = append(job.Errors, map[string]any{"error": err.Error()})
+		case nodeID == generateNodeID(item.data):
+			job.Created++
+		default:
+			job.Merged++
+		}
+		p.mu.Unlock()
+	}
+}
+
+// addEmbedded adds an item embedded by embedder, embedding it again should
+// a re-embedding have cut over to another model since.
+func (kg *KnowledgeGraph) addEmbedded(ctx context.Context, item ingestItem, embedder Embedder, embedding []float64) (string, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	if kg.embedder != embedder {
+		var err error
+		if embedding, err = embedOne(ctx, kg.embedder, item.text); err != nil {
+			return "", fmt.Errorf("embedding: %w", err)
+		}
+	}
+	return kg.addContextNode(ctx, item.data, item.text, embedding, item.validFrom, item.validTo)
+}
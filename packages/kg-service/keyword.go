@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Tokenization matches the Python keyword index: identifiers are kept whole
+// (error codes, snake_case and dotted names) as well as split into parts.
+var identifierRE = regexp.MustCompile(`[A-Za-z0-9_][A-Za-z0-9_.:/-]*[A-Za-z0-9_]|[A-Za-z0-9_]`)
+
+func tokenize(text string) []string {
+	var tokens []string
+	for _, identifier := range identifierRE.FindAllString(text, -1) {
+		tokens = append(tokens, strings.ToLower(identifier))
+		parts := splitParts(identifier)
+		if len(parts) > 1 {
+			tokens = append(tokens, parts...)
+		}
+	}
+	return tokens
+}
+
+// splitParts is PART_RE.findall from the Python index,
+// [A-Za-z][a-z]+|[A-Z]+(?![a-z])|\d+, by hand since Go's regexp has no
+// lookahead: an upper-case run leaves its last letter to a following word,
+// so "HTTPServer" splits into http and server.
+func splitParts(identifier string) []string {
+	isLower := func(c byte) bool { return c >= 'a' && c <= 'z' }
+	isUpper := func(c byte) bool { return c >= 'A' && c <= 'Z' }
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	run := func(i int, in func(byte) bool) int {
+		for i < len(identifier) && in(identifier[i]) {
+			i++
+		}
+		return i
+	}
+
+	var parts []string
+	for i := 0; i < len(identifier); {
+		c := identifier[i]
+		switch {
+		case (isLower(c) || isUpper(c)) && i+1 < len(identifier) && isLower(identifier[i+1]):
+			end := run(i+1, isLower)
+			parts = append(parts, strings.ToLower(identifier[i:end]))
+			i = end
+		case isUpper(c):
+			end := run(i, isUpper)
+			if end < len(identifier) && isLower(identifier[end]) {
+				end--
+			}
+			parts = append(parts, strings.ToLower(identifier[i:end]))
+			i = end
+		case isDigit(c):
+			end := run(i, isDigit)
+			parts = append(parts, identifier[i:end])
+			i = end
+		default:
+			i++
+		}
+	}
+	return parts
+}
+
+// keywordIndex is a BM25 index over node text, built lazily on first search.
+type keywordIndex struct {
+	k1, b    float64
+	postings map[string]map[string]int
+	lengths  map[string]int
+	built    bool
+}
+
+func newKeywordIndex() *keywordIndex {
+	return &keywordIndex{k1: 1.5, b: 0.75, postings: map[string]map[string]int{}, lengths: map[string]int{}}
+}
+
+func (k *keywordIndex) add(nodeID, text string) {
+	k.remove(nodeID)
+	counts := map[string]int{}
+	total := 0
+	for _, token := range tokenize(text) {
+		counts[token]++
+		total++
+	}
+	for token, tf := range counts {
+		if k.postings[token] == nil {
+			k.postings[token] = map[string]int{}
+		}
+		k.postings[token][nodeID] = tf
+	}
+	k.lengths[nodeID] = total
+}
+
+func (k *keywordIndex) remove(nodeID string) {
+	if _, ok := k.lengths[nodeID]; !ok {
+		return
+	}
+	for token, posting := range k.postings {
+		delete(posting, nodeID)
+		if len(posting) == 0 {
+			delete(k.postings, token)
+		}
+	}
+	delete(k.lengths, nodeID)
+}
+
+func (k *keywordIndex) search(query string, limit int) []Hit {
+	if len(k.lengths) == 0 {
+		return nil
+	}
+	n := float64(len(k.lengths))
+	var totalLength int
+	for _, l := range k.lengths {
+		totalLength += l
+	}
+	avgLength := float64(totalLength) / n
+
+	scores := map[string]float64{}
+	seen := map[string]bool{}
+	for _, token := range tokenize(query) {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		posting := k.postings[token]
+		if len(posting) == 0 {
+			continue
+		}
+		df := float64(len(posting))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for nodeID, tf := range posting {
+			norm := float64(tf) + k.k1*(1-k.b+k.b*float64(k.lengths[nodeID])/avgLength)
+			scores[nodeID] += idf * float64(tf) * (k.k1 + 1) / norm
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for nodeID, score := range scores {
+		hits = append(hits, Hit{NodeID: nodeID, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > max(limit, 0) {
+		hits = hits[:max(limit, 0)]
+	}
+	return hits
+}
+
+// reciprocalRankFusion fuses ranked lists of node IDs.
+func reciprocalRankFusion(rankings ...[]Hit) map[string]float64 {
+	const k = 60.0
+	fused := map[string]float64{}
+	for _, ranking := range rankings {
+		for rank, hit := range ranking {
+			fused[hit.NodeID] += 1.0 / (k + float64(rank+1))
+		}
+	}
+	return fused
+}
@@ -0,0 +1,245 @@
+// Command kg-service is a Go implementation of the knowledge graph HTTP
+// service, for deployments that want a single static binary instead of a
+// Python runtime with sentence-transformers in the request path. It speaks
+// the same API and storage schema as kg_server.py; only Graphiti, which
+// needs the Python runtime, answers 501. See README.md.
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/apiversion"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/logging"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/rbac"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/tracing"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("kg-service: %v", err)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// openStore builds the storage backend selected by KG_BACKEND. Like the
+// Python store_from_env, a named graph gets its own file suffix or node label.
+func openStore(ctx context.Context, backend, graphID string) (Store, error) {
+	switch backend {
+	case "memory":
+		path := os.Getenv("KG_GRAPH_FILE")
+		if path != "" && graphID != "default" {
+			ext := filepath.Ext(path)
+			path = strings.TrimSuffix(path, ext) + "." + graphID + ext
+		}
+		return newMemoryStore(path)
+	case "neo4j":
+		label := "Context"
+		if graphID != "default" {
+			label += "_" + graphID
+		}
+		return newNeo4jStore(ctx,
+			getenv("NEO4J_HTTP_URL", "http://localhost:7474"),
+			os.Getenv("NEO4J_DATABASE"),
+			getenv("NEO4J_USER", "neo4j"),
+			os.Getenv("NEO4J_PASSWORD"),
+			label)
+	}
+	return nil, fmt.Errorf("unknown storage backend: %s", backend)
+}
+
+// openEmbedder builds the embedder for model, or for the one
+// KG_EMBEDDING_MODEL or KG_EMBEDDING_DIM names when model is empty.
+// hashing-<dimension> models need no server; the others are served by
+// KG_EMBEDDING_API.
+func openEmbedder(model string) (Embedder, error) {
+	api := getenv("KG_EMBEDDING_API", "hash")
+	if model == "" && api == "hash" {
+		dimension, err := strconv.Atoi(getenv("KG_EMBEDDING_DIM", "384"))
+		if err != nil || dimension <= 0 {
+			return nil, fmt.Errorf("invalid KG_EMBEDDING_DIM: %q", os.Getenv("KG_EMBEDDING_DIM"))
+		}
+		return hashEmbedder{dimension: dimension}, nil
+	}
+	served := getenv("KG_EMBEDDING_MODEL", "sentence-transformers/all-MiniLM-L6-v2")
+	model = cmp.Or(model, served)
+	if size, ok := strings.CutPrefix(model, "hashing-"); ok {
+		dimension, err := strconv.Atoi(size)
+		if err != nil || dimension <= 0 {
+			return nil, fmt.Errorf("invalid hashing model: %q", model)
+		}
+		return hashEmbedder{dimension: dimension}, nil
+	}
+	// A text-embeddings-inference server runs a single model
+	if api == "hash" || api == "tei" && model != served {
+		return nil, fmt.Errorf("the %s embedding API cannot embed with %s", api, model)
+	}
+	return newRemoteEmbedder(api, os.Getenv("KG_EMBEDDING_URL"), model, os.Getenv("OPENAI_API_KEY"))
+}
+
+func openIndex(ctx context.Context, kind, graphID string, store Store, embedder Embedder) (VectorIndex, error) {
+	switch kind {
+	case "scan":
+		return &scanIndex{store: store, attribute: "embedding"}, nil
+	case "qdrant":
+		// The collection is created on first use, sized by a probe embedding
+		probe, err := embedOne(ctx, embedder, "dimension probe")
+		if err != nil {
+			return nil, fmt.Errorf("probing embedding dimension: %w", err)
+		}
+		collection := getenv("QDRANT_COLLECTION", "context_nodes")
+		if graphID != "default" {
+			collection += "_" + graphID
+		}
+		return newQdrantIndex(ctx, getenv("QDRANT_URL", "http://localhost:6333"), collection, len(probe))
+	case "pgvector":
+		url := os.Getenv("PGVECTOR_URL")
+		if url == "" {
+			return nil, errors.New("PGVECTOR_URL is required for the pgvector index")
+		}
+		probe, err := embedOne(ctx, embedder, "dimension probe")
+		if err != nil {
+			return nil, fmt.Errorf("probing embedding dimension: %w", err)
+		}
+		table := getenv("PGVECTOR_TABLE", "context_nodes")
+		if graphID != "default" {
+			table += "_" + graphID
+		}
+		return newPgvectorIndex(ctx, url, table, len(probe))
+	}
+	return nil, fmt.Errorf("unknown vector index: %s", kind)
+}
+
+func run() (err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := logging.FromEnv("knowledge-graph")
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		logger.Shutdown(shutdownCtx)
+	}()
+
+	// Settings from the config service are in the environment before
+	// anything reads it
+	s := &server{configPath: os.Getenv("KG_CONFIG")}
+	if configURL := os.Getenv("CONFIG_URL"); configURL != "" {
+		s.remote, err = pullConfig(ctx, configURL, getenv("CONFIG_COMPONENT", "graph"), os.Getenv("CONFIG_TOKEN"))
+		if err != nil {
+			return err
+		}
+	}
+	if s.config, err = loadConfig(s.configPath, s.remote.Overrides()); err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	backend := getenv("KG_BACKEND", "memory")
+	indexKind := getenv("KG_VECTOR_INDEX", "scan")
+	// The configured model is the target; until stored vectors have been
+	// re-embedded, the model they were made with keeps serving
+	embedder, err := getEmbedder(orDefault(s.config.Embedding.Model, ""))
+	if err != nil {
+		return err
+	}
+	s.backend = backend
+	s.graphs, err = newGraphRegistry(getenv("KG_GRAPH_CATALOG", "/data/graphs.json"),
+		func(ctx context.Context, storedID string) (*KnowledgeGraph, error) {
+			store, err := openStore(ctx, backend, storedID)
+			if err != nil {
+				return nil, err
+			}
+			serving, err := servingEmbedder(ctx, store, embedder)
+			if err != nil {
+				store.Close()
+				return nil, err
+			}
+			index, err := openIndex(ctx, indexKind, storedID, store, serving)
+			if err != nil {
+				store.Close()
+				return nil, err
+			}
+			return newKnowledgeGraph(storedID, store, serving, embedder, index, s.Config()), nil
+		})
+	if err != nil {
+		return fmt.Errorf("loading the graph catalog: %w", err)
+	}
+	// The default graph is opened now, so a backend that is down stops the
+	// service from starting
+	if _, err := s.graphs.Get(ctx, defaultGraph); err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := s.graphs.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	authorizer, err := rbac.FromEnv("graph")
+	if err != nil {
+		return err
+	}
+
+	tracer := tracing.FromEnv("knowledge-graph")
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		tracer.Shutdown(shutdownCtx)
+	}()
+
+	// The schedule is the one the service started with, as in the Python service
+	go s.decayGraphs(ctx, time.Duration(s.Config().Decay.IntervalSeconds*float64(time.Second)))
+	if s.remote != nil {
+		go s.remote.Watch(ctx, s.reloadSettings)
+	}
+	if busURL := os.Getenv("EVENT_BUS_URL"); busURL != "" {
+		go s.subscribeNodes(ctx, busURL, tracer)
+	}
+	handler := rbac.Tenants(s.routes())
+	if authorizer != nil {
+		handler = authorizer.Middleware(handler)
+	}
+	handler = apiversion.Middleware(tracer.Middleware(handler))
+	httpServer := &http.Server{
+		Addr:              ":" + getenv("KG_PORT", "8080"),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("kg-service listening on %s (backend %s, index %s, embeddings %s, access control %t, %s, %s)",
+			httpServer.Addr, backend, indexKind, embedder.Model(), authorizer != nil, tracer, logger)
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// neo4jStore talks to Neo4j over its HTTP transaction API, so no driver is
+// needed. It uses the same schema as the Python Neo4jStore: (:Context
+// {node_id}) nodes joined by RELATES_TO, with every property JSON-encoded.
+type neo4jStore struct {
+	endpoint string
+	user     string
+	password string
+	label    string
+	client   *http.Client
+}
+
+func newNeo4jStore(ctx context.Context, baseURL, database, user, password, label string) (*neo4jStore, error) {
+	if !labelPattern.MatchString(label) {
+		return nil, fmt.Errorf("invalid node label: %s", label)
+	}
+	if database == "" {
+		database = "neo4j"
+	}
+	s := &neo4jStore{
+		endpoint: strings.TrimRight(baseURL, "/") + "/db/" + database + "/tx/commit",
+		user:     user,
+		password: password,
+		label:    label,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+	_, err := s.run(ctx, fmt.Sprintf(
+		"CREATE CONSTRAINT %s_node_id IF NOT EXISTS FOR (n:%s) REQUIRE n.node_id IS UNIQUE",
+		strings.ToLower(label), label), nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to neo4j: %w", err)
+	}
+	return s, nil
+}
+
+type neo4jStatement struct {
+	Statement  string         `json:"statement"`
+	Parameters map[string]any `json:"parameters,omitempty"`
+}
+
+type neo4jResponse struct {
+	Results []struct {
+		Columns []string `json:"columns"`
+		Data    []struct {
+			Row []json.RawMessage `json:"row"`
+		} `json:"data"`
+	} `json:"results"`
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// run executes one statement and returns its rows.
+func (s *neo4jStore) run(ctx context.Context, statement string, params map[string]any) ([][]json.RawMessage, error) {
+	body, err := json.Marshal(map[string]any{
+		"statements": []neo4jStatement{{Statement: statement, Parameters: params}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(s.user, s.password)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("neo4j returned HTTP %d", resp.StatusCode)
+	}
+
+	var parsed neo4jResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+	if len(parsed.Errors) > 0 {
+		return nil, fmt.Errorf("%s: %s", parsed.Errors[0].Code, parsed.Errors[0].Message)
+	}
+
+	var rows [][]json.RawMessage
+	for _, result := range parsed.Results {
+		for _, data := range result.Data {
+			rows = append(rows, data.Row)
+		}
+	}
+	return rows, nil
+}
+
+// Neo4j properties must be primitives, so values are stored JSON-encoded.
+func encodeProps(attrs Attrs) (map[string]any, error) {
+	props := make(map[string]any, len(attrs))
+	for key, value := range attrs {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		props[key] = string(encoded)
+	}
+	return props, nil
+}
+
+func decodeProps(raw json.RawMessage) (string, Attrs, error) {
+	var props map[string]any
+	if err := json.Unmarshal(raw, &props); err != nil {
+		return "", nil, err
+	}
+	nodeID, _ := props["node_id"].(string)
+	delete(props, "node_id")
+	attrs := make(Attrs, len(props))
+	for key, value := range props {
+		encoded, ok := value.(string)
+		if !ok {
+			attrs[key] = value
+			continue
+		}
+		var decoded any
+		if err := json.Unmarshal([]byte(encoded), &decoded); err != nil {
+			return "", nil, fmt.Errorf("property %s: %w", key, err)
+		}
+		attrs[key] = decoded
+	}
+	return nodeID, attrs, nil
+}
+
+func (s *neo4jStore) AddNode(ctx context.Context, id string, attrs Attrs) error {
+	props, err := encodeProps(attrs)
+	if err != nil {
+		return err
+	}
+	_, err = s.run(ctx, fmt.Sprintf("MERGE (n:%s {node_id: $node_id}) SET n += $props", s.label),
+		map[string]any{"node_id": id, "props": props})
+	return err
+}
+
+func (s *neo4jStore) GetNode(ctx context.Context, id string) (Attrs, error) {
+	rows, err := s.run(ctx, fmt.Sprintf("MATCH (n:%s {node_id: $node_id}) RETURN properties(n)", s.label),
+		map[string]any{"node_id": id})
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	_, attrs, err := decodeProps(rows[0][0])
+	return attrs, err
+}
+
+func (s *neo4jStore) Nodes(ctx context.Context) (map[string]Attrs, error) {
+	rows, err := s.run(ctx, fmt.Sprintf("MATCH (n:%s) RETURN properties(n)", s.label), nil)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]Attrs, len(rows))
+	for _, row := range rows {
+		id, attrs, err := decodeProps(row[0])
+		if err != nil {
+			return nil, err
+		}
+		nodes[id] = attrs
+	}
+	return nodes, nil
+}
+
+func (s *neo4jStore) NumberOfNodes(ctx context.Context) (int, error) {
+	rows, err := s.run(ctx, fmt.Sprintf("MATCH (n:%s) RETURN count(n)", s.label), nil)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = json.Unmarshal(rows[0][0], &count)
+	return count, err
+}
+
+func (s *neo4jStore) RemoveNode(ctx context.Context, id string) error {
+	_, err := s.run(ctx, fmt.Sprintf("MATCH (n:%s {node_id: $node_id}) DETACH DELETE n", s.label),
+		map[string]any{"node_id": id})
+	return err
+}
+
+func (s *neo4jStore) AddEdge(ctx context.Context, source, target string, attrs Attrs) error {
+	props, err := encodeProps(attrs)
+	if err != nil {
+		return err
+	}
+	_, err = s.run(ctx, fmt.Sprintf(
+		"MATCH (a:%[1]s {node_id: $source}), (b:%[1]s {node_id: $target}) "+
+			"MERGE (a)-[r:RELATES_TO]->(b) SET r += $props", s.label),
+		map[string]any{"source": source, "target": target, "props": props})
+	return err
+}
+
+func (s *neo4jStore) GetEdge(ctx context.Context, source, target string) (Attrs, error) {
+	rows, err := s.run(ctx, fmt.Sprintf(
+		"MATCH (:%[1]s {node_id: $source})-[r:RELATES_TO]->(:%[1]s {node_id: $target}) "+
+			"RETURN properties(r)", s.label),
+		map[string]any{"source": source, "target": target})
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	_, attrs, err := decodeProps(rows[0][0])
+	return attrs, err
+}
+
+func (s *neo4jStore) Edges(ctx context.Context) ([]Edge, error) {
+	rows, err := s.run(ctx, fmt.Sprintf(
+		"MATCH (a:%[1]s)-[r:RELATES_TO]->(b:%[1]s) RETURN a.node_id, b.node_id, properties(r)", s.label), nil)
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]Edge, 0, len(rows))
+	for _, row := range rows {
+		var edge Edge
+		if err := json.Unmarshal(row[0], &edge.Source); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(row[1], &edge.Target); err != nil {
+			return nil, err
+		}
+		if _, edge.Attrs, err = decodeProps(row[2]); err != nil {
+			return nil, err
+		}
+		edges = append(edges, edge)
+	}
+	return edges, nil
+}
+
+func (s *neo4jStore) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
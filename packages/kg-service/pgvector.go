@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+var tablePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// pgvectorIndex keeps node embeddings in a Postgres table with the pgvector
+// extension, one row per node, searched by cosine distance over an HNSW
+// index. The Python service has no such index, so a graph it shares must
+// use the scan or Qdrant index instead.
+type pgvectorIndex struct {
+	db    *sql.DB
+	table string
+}
+
+func newPgvectorIndex(ctx context.Context, url, table string, dimension int) (*pgvectorIndex, error) {
+	if !tablePattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid pgvector table: %s", table)
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	p := &pgvectorIndex{db: db, table: table}
+	if err := p.create(ctx, table, dimension); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to pgvector: %w", err)
+	}
+	return p, nil
+}
+
+// create makes the table and its HNSW index unless they exist.
+func (p *pgvectorIndex) create(ctx context.Context, table string, dimension int) error {
+	for _, statement := range []string{
+		"CREATE EXTENSION IF NOT EXISTS vector",
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (node_id text PRIMARY KEY, embedding vector(%d) NOT NULL)", table, dimension),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_embedding ON %[1]s USING hnsw (embedding vector_cosine_ops)", table),
+	} {
+		if _, err := p.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pgvectorIndex) Name() string { return "pgvector" }
+
+// vectorLiteral is pgvector's text form of a vector, [x,y,...].
+func vectorLiteral(vector []float64) string {
+	parts := make([]string, len(vector))
+	for i, x := range vector {
+		parts[i] = strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+func (p *pgvectorIndex) Upsert(ctx context.Context, nodeID string, vector []float64) error {
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (node_id, embedding) VALUES ($1, $2::vector) "+
+			"ON CONFLICT (node_id) DO UPDATE SET embedding = EXCLUDED.embedding", p.table),
+		nodeID, vectorLiteral(vector))
+	return err
+}
+
+func (p *pgvectorIndex) Delete(ctx context.Context, nodeID string) error {
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE node_id = $1", p.table), nodeID)
+	return err
+}
+
+func (p *pgvectorIndex) Search(ctx context.Context, vector []float64, limit int, threshold float64) ([]Hit, error) {
+	// <=> is cosine distance, so similarity is 1 minus it; ordering by the
+	// distance itself is what lets the HNSW index serve the query
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT node_id, 1 - (embedding <=> $1::vector) FROM %s "+
+			"WHERE 1 - (embedding <=> $1::vector) > $2 ORDER BY embedding <=> $1::vector LIMIT $3", p.table),
+		vectorLiteral(vector), threshold, max(limit, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hits []Hit
+	for rows.Next() {
+		var hit Hit
+		if err := rows.Scan(&hit.NodeID, &hit.Score); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+func (p *pgvectorIndex) Close() error {
+	return p.db.Close()
+}
+
+// Shadow is a table beside this one, on the same connection pool, which is
+// renamed over it at cutover.
+func (p *pgvectorIndex) Shadow(ctx context.Context, name string, dimension int) (VectorIndex, error) {
+	table := p.table + "__" + name
+	if !tablePattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid pgvector table: %s", table)
+	}
+	if err := p.create(ctx, table, dimension); err != nil {
+		return nil, err
+	}
+	return &pgvectorIndex{db: p.db, table: table}, nil
+}
+
+func (p *pgvectorIndex) Promote(ctx context.Context, shadow VectorIndex) (VectorIndex, error) {
+	next, ok := shadow.(*pgvectorIndex)
+	if !ok {
+		return nil, fmt.Errorf("cannot promote a %s index over a pgvector one", shadow.Name())
+	}
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, statement := range []string{
+		fmt.Sprintf("DROP TABLE %s", p.table),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", next.table, p.table),
+		fmt.Sprintf("ALTER INDEX %s_embedding RENAME TO %s_embedding", next.table, p.table),
+	} {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return nil, err
+		}
+	}
+	return p, tx.Commit()
+}
+
+func (p *pgvectorIndex) Drop(ctx context.Context) error {
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", p.table))
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// Erasure of what a session or user contributed, as in the Python
+// KnowledgeGraph.purge. Snapshots are not rewritten.
+
+// subjectField is a session_id or user_id a context node is matched on.
+type subjectField struct {
+	key, value string
+}
+
+// subjectNodes are the IDs of the context nodes whose data, or its
+// metadata, has any of the fields. The caller holds kg.mu.
+func (kg *KnowledgeGraph) subjectNodes(ctx context.Context, wanted []subjectField) ([]string, error) {
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	subject := func(data map[string]any, key string) any {
+		if value, ok := data[key]; ok {
+			return value
+		}
+		metadata, _ := data["metadata"].(map[string]any)
+		return metadata[key]
+	}
+	ids := []string{}
+	for _, id := range sortedIDs(nodes) {
+		data, ok := nodes[id]["data"].(map[string]any)
+		if !ok || nodeType(nodes[id]) != "context" {
+			continue
+		}
+		for _, field := range wanted {
+			if subject(data, field.key) == field.value {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// SubjectNodes are the IDs of the context nodes a session or user
+// contributed.
+func (kg *KnowledgeGraph) SubjectNodes(ctx context.Context, wanted []subjectField) ([]string, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.subjectNodes(ctx, wanted)
+}
+
+// Purge hard-deletes the context a session or user contributed, for
+// erasure requests, along with the entity nodes it leaves without any edge.
+func (kg *KnowledgeGraph) Purge(ctx context.Context, wanted []subjectField) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	doomed, err := kg.subjectNodes(ctx, wanted)
+	if err != nil {
+		return nil, err
+	}
+	isDoomed := map[string]bool{}
+	for _, id := range doomed {
+		isDoomed[id] = true
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	linked := map[string]bool{}
+	for _, edge := range edges {
+		if isDoomed[edge.Source] {
+			linked[edge.Target] = true
+		}
+	}
+	for _, id := range doomed {
+		if err := kg.store.RemoveNode(ctx, id); err != nil {
+			return nil, err
+		}
+		if err := kg.index.Delete(ctx, id); err != nil {
+			return nil, err
+		}
+		kg.keywords.remove(id)
+	}
+
+	if edges, err = kg.store.Edges(ctx); err != nil {
+		return nil, err
+	}
+	connected := map[string]bool{}
+	for _, edge := range edges {
+		connected[edge.Source], connected[edge.Target] = true, true
+	}
+	orphans := []string{}
+	for id := range linked {
+		if isDoomed[id] || connected[id] {
+			continue
+		}
+		attrs, err := kg.store.GetNode(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if attrs != nil && nodeType(attrs) != "context" {
+			orphans = append(orphans, id)
+		}
+	}
+	sort.Strings(orphans)
+	for _, id := range orphans {
+		if err := kg.store.RemoveNode(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	if len(doomed) > 0 {
+		if err := kg.noteMutation(ctx, len(doomed)+len(orphans)); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]any{"nodes_deleted": doomed, "entities_deleted": orphans}, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// The Python service derives node IDs and content hashes from Python's own
+// renderings of the payload. These helpers reproduce them so both services
+// agree on IDs for the same input and can share a Neo4j graph.
+
+// decodeJSON decodes without losing the literal form of numbers.
+func decodeJSON(raw []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// pyDumps renders v like Python's json.dumps(v, sort_keys=True).
+func pyDumps(v any) string {
+	var b strings.Builder
+	writePy(&b, v)
+	return b.String()
+}
+
+func writePy(b *strings.Builder, v any) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		if v {
+			b.WriteString("true")
+		} else {
+			b.WriteString("false")
+		}
+	case json.Number:
+		b.WriteString(v.String())
+	case float64:
+		b.WriteString(pyFloat(v))
+	case string:
+		writePyString(b, v)
+	case []any:
+		b.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writePy(b, item)
+		}
+		b.WriteByte(']')
+	case []float64, []string:
+		writePy(b, pyValue(v))
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writePyString(b, key)
+			b.WriteString(": ")
+			writePy(b, v[key])
+		}
+		b.WriteByte('}')
+	default:
+		fmt.Fprintf(b, "%v", v)
+	}
+}
+
+// writePyString escapes like json.dumps with ensure_ascii=True.
+func writePyString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r < 0x20 || r > 0x7e && r < 0x10000:
+			fmt.Fprintf(b, `\u%04x`, r)
+		case r >= 0x10000:
+			hi, lo := utf16.EncodeRune(r)
+			fmt.Fprintf(b, `\u%04x\u%04x`, hi, lo)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+}
+
+func pyFloat(f float64) string {
+	s := fmt.Sprintf("%v", f)
+	if !strings.ContainsAny(s, ".eEn") {
+		s += ".0"
+	}
+	return s
+}
+
+// pyStr renders a scalar like Python's str().
+func pyStr(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case json.Number:
+		return v.String()
+	case float64:
+		return pyFloat(v)
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// contextText flattens a payload into the text that gets embedded, joining
+// values in document order like the Python context_text.
+func contextText(raw []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	text, err := flattenValue(dec)
+	if err != nil {
+		return string(raw)
+	}
+	return text
+}
+
+func flattenValue(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return pyStr(tok), nil
+	}
+
+	var parts []string
+	for dec.More() {
+		if delim == '{' {
+			// Keys are skipped; only values carry context
+			if _, err := dec.Token(); err != nil {
+				return "", err
+			}
+		}
+		part, err := flattenValue(dec)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, part)
+	}
+	if _, err := dec.Token(); err != nil {
+		return "", err
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// pyTruthy reports whether v is true in a Python condition.
+func pyTruthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case json.Number, float64, int:
+		return pyNumber(v) != 0
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return true
+}
+
+// pyNumber is a decoded number as a float64, however it was decoded.
+func pyNumber(v any) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	}
+	return number(v, 0)
+}
+
+// pyValue normalizes a decoded value so that values Python would compare
+// equal are deeply equal: numbers become float64 and Attrs plain maps.
+func pyValue(v any) any {
+	switch v := v.(type) {
+	case json.Number, int:
+		return pyNumber(v)
+	case Attrs:
+		return pyValue(map[string]any(v))
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = pyValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = pyValue(item)
+		}
+		return out
+	case []string:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = item
+		}
+		return out
+	case []float64:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = item
+		}
+		return out
+	}
+	return v
+}
+
+// pyEqual compares decoded values like Python's ==.
+func pyEqual(a, b any) bool {
+	return reflect.DeepEqual(pyValue(a), pyValue(b))
+}
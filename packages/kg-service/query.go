@@ -0,0 +1,623 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Cypher-like pattern queries over the graph, as in the Python graph_query:
+//
+//	MATCH (a:type {data.key: "value"})-[:rel_type|other*1..2]->(b:type)
+//	WHERE b.data.url CONTAINS "github" AND a.timestamp >= "2024-01-01"
+//	RETURN b
+//	LIMIT 10
+//
+// Node labels match node_type; property maps and WHERE paths address node
+// attributes with dotted paths. Relationships may be written ->, <- or - for
+// either direction, and "*min..max" sets the hop range (default exactly one).
+
+var errQuerySyntax = errors.New("invalid query")
+
+const maxHops = 5
+
+var queryTokenPattern = regexp.MustCompile(`^\s*(?:` +
+	`(?P<string>"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*')` +
+	`|(?P<number>-?\d+(?:\.\d+)?)` +
+	`|(?P<arrow><-|->|\.\.)` +
+	`|(?P<op>>=|<=|!=|=|<|>)` +
+	`|(?P<name>[A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)*)` +
+	`|(?P<punct>[()\[\]{}:,*|-]))`)
+
+var queryKeywords = map[string]bool{"MATCH": true, "WHERE": true, "AND": true, "RETURN": true, "LIMIT": true,
+	"CONTAINS": true, "TRUE": true, "FALSE": true, "NULL": true}
+
+var queryEscapes = map[byte]string{'\\': `\`, '\'': `'`, '"': `"`, 'a': "\a", 'b': "\b", 'f': "\f",
+	'n': "\n", 'r': "\r", 't': "\t", 'v': "\v", '\n': ""}
+
+type queryToken struct {
+	kind, value string
+}
+
+type nodePattern struct {
+	variable, label string
+	props           map[string]any
+}
+
+type relPattern struct {
+	types            []string
+	direction        string
+	minHops, maxHops int
+}
+
+type queryCondition struct {
+	path, op string
+	value    any
+}
+
+type graphQuery struct {
+	start, end nodePattern
+	// rel is nil for a query of a single node.
+	rel        *relPattern
+	conditions []queryCondition
+	returns    []string
+	// limit is 0 when the query sets none.
+	limit int
+}
+
+func tokenizeQuery(text string) ([]queryToken, error) {
+	text = strings.TrimSpace(text)
+	names := queryTokenPattern.SubexpNames()
+	var tokens []queryToken
+	for pos := 0; pos < len(text); {
+		match := queryTokenPattern.FindStringSubmatchIndex(text[pos:])
+		if match == nil {
+			rest := []rune(text[pos:])
+			return nil, fmt.Errorf("%w: unexpected character at %d: %q", errQuerySyntax, pos, string(rest[:min(10, len(rest))]))
+		}
+		for i := 1; i < len(names); i++ {
+			if match[2*i] < 0 {
+				continue
+			}
+			token := queryToken{kind: names[i], value: text[pos+match[2*i] : pos+match[2*i+1]]}
+			if token.kind == "name" && queryKeywords[strings.ToUpper(token.value)] {
+				token = queryToken{kind: "keyword", value: strings.ToUpper(token.value)}
+			}
+			tokens = append(tokens, token)
+			break
+		}
+		pos += match[1]
+	}
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser; a syntax error panics with an
+// error wrapping errQuerySyntax, which parseQuery recovers.
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) fail(format string, args ...any) {
+	panic(fmt.Errorf("%w: "+format, append([]any{errQuerySyntax}, args...)...))
+}
+
+// peek reports whether the next token is of kind and, if value is set,
+// has that value.
+func (p *queryParser) peek(kind, value string) bool {
+	if p.pos >= len(p.tokens) {
+		return false
+	}
+	token := p.tokens[p.pos]
+	return token.kind == kind && (value == "" || token.value == value)
+}
+
+func (p *queryParser) take(kind, value string) string {
+	if !p.peek(kind, value) {
+		found := "end of query"
+		if p.pos < len(p.tokens) {
+			found = strconv.Quote(p.tokens[p.pos].value)
+		}
+		p.fail("expected %s, found %s", cmp.Or(value, kind), found)
+	}
+	p.pos++
+	return p.tokens[p.pos-1].value
+}
+
+func (p *queryParser) integer() int {
+	raw := p.take("number", "")
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		p.fail("expected an integer, found %q", raw)
+	}
+	return n
+}
+
+func (p *queryParser) literal() any {
+	switch {
+	case p.peek("string", ""):
+		raw := p.take("string", "")
+		value, err := unescapeQueryString(raw[1 : len(raw)-1])
+		if err != nil {
+			p.fail("%v", err)
+		}
+		return value
+	case p.peek("number", ""):
+		raw := p.take("number", "")
+		if strings.Contains(raw, ".") {
+			f, _ := strconv.ParseFloat(raw, 64)
+			return f
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			p.fail("number out of range: %s", raw)
+		}
+		return n
+	}
+	switch keyword := p.take("keyword", ""); keyword {
+	case "TRUE":
+		return true
+	case "FALSE":
+		return false
+	case "NULL":
+		return nil
+	default:
+		p.fail("expected a literal, found %s", keyword)
+		return nil
+	}
+}
+
+func (p *queryParser) node(defaultVariable string) nodePattern {
+	p.take("punct", "(")
+	pattern := nodePattern{variable: defaultVariable, props: map[string]any{}}
+	if p.peek("name", "") {
+		pattern.variable = p.take("name", "")
+	}
+	if p.peek("punct", ":") {
+		p.take("punct", ":")
+		pattern.label = p.take("name", "")
+	}
+	if p.peek("punct", "{") {
+		p.take("punct", "{")
+		for !p.peek("punct", "}") {
+			key := p.take("name", "")
+			p.take("punct", ":")
+			pattern.props[key] = p.literal()
+			if !p.peek("punct", "}") {
+				p.take("punct", ",")
+			}
+		}
+		p.take("punct", "}")
+	}
+	p.take("punct", ")")
+	return pattern
+}
+
+func (p *queryParser) rel() *relPattern {
+	incoming := p.peek("arrow", "<-")
+	if incoming {
+		p.take("arrow", "<-")
+	} else {
+		p.take("punct", "-")
+	}
+	p.take("punct", "[")
+	rel := &relPattern{minHops: 1, maxHops: 1}
+	if p.peek("punct", ":") {
+		p.take("punct", ":")
+		rel.types = append(rel.types, p.take("name", ""))
+		for p.peek("punct", "|") {
+			p.take("punct", "|")
+			rel.types = append(rel.types, p.take("name", ""))
+		}
+	}
+	if p.peek("punct", "*") {
+		p.take("punct", "*")
+		rel.minHops, rel.maxHops = 1, maxHops
+		if p.peek("number", "") {
+			rel.minHops = p.integer()
+			rel.maxHops = rel.minHops
+		}
+		if p.peek("arrow", "..") {
+			p.take("arrow", "..")
+			rel.maxHops = maxHops
+			if p.peek("number", "") {
+				rel.maxHops = p.integer()
+			}
+		}
+	}
+	p.take("punct", "]")
+	outgoing := p.peek("arrow", "->")
+	if outgoing {
+		p.take("arrow", "->")
+	} else {
+		p.take("punct", "-")
+	}
+	if incoming && outgoing {
+		p.fail("a relationship cannot point both ways")
+	}
+	if rel.minHops < 1 || rel.minHops > rel.maxHops || rel.maxHops > maxHops {
+		p.fail("hop range must be within 1..%d", maxHops)
+	}
+	switch {
+	case incoming:
+		rel.direction = "in"
+	case outgoing:
+		rel.direction = "out"
+	default:
+		rel.direction = "both"
+	}
+	return rel
+}
+
+func (p *queryParser) condition() queryCondition {
+	condition := queryCondition{path: p.take("name", "")}
+	if p.peek("keyword", "CONTAINS") {
+		condition.op = p.take("keyword", "CONTAINS")
+	} else {
+		condition.op = p.take("op", "")
+	}
+	condition.value = p.literal()
+	return condition
+}
+
+func (p *queryParser) parse() *graphQuery {
+	p.take("keyword", "MATCH")
+	q := &graphQuery{start: p.node("a")}
+	variables := []string{q.start.variable}
+	if p.peek("punct", "-") || p.peek("arrow", "<-") {
+		q.rel = p.rel()
+		q.end = p.node("b")
+		if q.end.variable == q.start.variable {
+			p.fail("start and end nodes need distinct variables")
+		}
+		variables = append(variables, q.end.variable)
+	}
+
+	if p.peek("keyword", "WHERE") {
+		p.take("keyword", "WHERE")
+		q.conditions = append(q.conditions, p.condition())
+		for p.peek("keyword", "AND") {
+			p.take("keyword", "AND")
+			q.conditions = append(q.conditions, p.condition())
+		}
+	}
+
+	q.returns = variables
+	if p.peek("keyword", "RETURN") {
+		p.take("keyword", "RETURN")
+		q.returns = []string{p.take("name", "")}
+		for p.peek("punct", ",") {
+			p.take("punct", ",")
+			q.returns = append(q.returns, p.take("name", ""))
+		}
+		var unknown []string
+		for _, variable := range q.returns {
+			if !contains(variables, variable) && !contains(unknown, variable) {
+				unknown = append(unknown, variable)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			p.fail("unknown variables in RETURN: %s", strings.Join(unknown, ", "))
+		}
+	}
+
+	if p.peek("keyword", "LIMIT") {
+		p.take("keyword", "LIMIT")
+		q.limit = p.integer()
+	}
+
+	if p.pos != len(p.tokens) {
+		p.fail("unexpected trailing input: %q", p.tokens[p.pos].value)
+	}
+	return q
+}
+
+// parseQuery parses a pattern query.
+func parseQuery(text string) (q *graphQuery, err error) {
+	tokens, err := tokenizeQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if syntaxErr, ok := r.(error); ok && errors.Is(syntaxErr, errQuerySyntax) {
+				q, err = nil, syntaxErr
+				return
+			}
+			panic(r)
+		}
+	}()
+	return (&queryParser{tokens: tokens}).parse(), nil
+}
+
+// unescapeQueryString decodes the backslash escapes of a string literal
+// like Python's unicode_escape codec.
+func unescapeQueryString(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			b.WriteRune(r)
+			i += size
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("string ends in a lone backslash")
+		}
+		escape := s[i+1]
+		i += 2
+		if decoded, ok := queryEscapes[escape]; ok {
+			b.WriteString(decoded)
+			continue
+		}
+		digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[escape]
+		switch {
+		case digits > 0:
+			if i+digits > len(s) {
+				return "", fmt.Errorf("truncated \\%c escape", escape)
+			}
+			code, err := strconv.ParseUint(s[i:i+digits], 16, 32)
+			if err != nil || code > utf8.MaxRune {
+				return "", fmt.Errorf("invalid \\%c escape: %q", escape, s[i:i+digits])
+			}
+			b.WriteRune(rune(code))
+			i += digits
+		case escape >= '0' && escape <= '7':
+			code := int(escape - '0')
+			for n := 1; n < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; n++ {
+				code = code*8 + int(s[i]-'0')
+				i++
+			}
+			b.WriteRune(rune(code))
+		default:
+			// Unknown escapes are kept as written
+			b.WriteByte('\\')
+			b.WriteByte(escape)
+		}
+	}
+	return b.String(), nil
+}
+
+// nodeView is a node as a query returns it: its attributes, without the
+// embedding, and its ID.
+func nodeView(nodeID string, attrs Attrs) map[string]any {
+	view := make(map[string]any, len(attrs))
+	for k, v := range attrs {
+		if k != "embedding" {
+			view[k] = v
+		}
+	}
+	view["node_id"] = nodeID
+	return view
+}
+
+// resolve follows a dotted path into nested objects, or is nil.
+func resolve(value any, path string) any {
+	for _, part := range strings.Split(path, ".") {
+		var object map[string]any
+		switch v := value.(type) {
+		case map[string]any:
+			object = v
+		case Attrs:
+			object = v
+		}
+		next, ok := object[part]
+		if !ok {
+			return nil
+		}
+		value = next
+	}
+	return value
+}
+
+// compare applies a WHERE operator; values of different types only compare
+// equal or not.
+func compare(actual any, op string, expected any) bool {
+	switch op {
+	case "=":
+		return pyEqual(actual, expected)
+	case "!=":
+		return !pyEqual(actual, expected)
+	case "CONTAINS":
+		switch actual := pyValue(actual).(type) {
+		case []any:
+			for _, item := range actual {
+				if pyEqual(item, expected) {
+					return true
+				}
+			}
+			return false
+		case string:
+			return strings.Contains(actual, pyStr(expected))
+		}
+		return false
+	}
+	var order int
+	switch a := pyValue(actual).(type) {
+	case float64:
+		b, ok := pyValue(expected).(float64)
+		if !ok {
+			return false
+		}
+		order = compareOrdered(a, b)
+	case string:
+		b, ok := expected.(string)
+		if !ok {
+			return false
+		}
+		order = strings.Compare(a, b)
+	default:
+		return false
+	}
+	switch op {
+	case "<":
+		return order < 0
+	case ">":
+		return order > 0
+	case "<=":
+		return order <= 0
+	default:
+		return order >= 0
+	}
+}
+
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (pattern nodePattern) matches(view map[string]any) bool {
+	if pattern.label != "" && view["node_type"] != pattern.label {
+		return false
+	}
+	for key, value := range pattern.props {
+		if !pyEqual(resolve(view, key), value) {
+			return false
+		}
+	}
+	return true
+}
+
+// traverse lists the nodes reachable from start within the relationship's
+// hop range, breadth first.
+func traverse(out, in map[string][]Edge, start string, rel *relPattern) []string {
+	neighbours := func(id string) []string {
+		var found []string
+		follow := func(edge Edge, other string) {
+			kind, _ := edge.Attrs["relationship_type"].(string)
+			if len(rel.types) == 0 || contains(rel.types, kind) {
+				found = append(found, other)
+			}
+		}
+		if rel.direction != "in" {
+			for _, edge := range out[id] {
+				follow(edge, edge.Target)
+			}
+		}
+		if rel.direction != "out" {
+			for _, edge := range in[id] {
+				follow(edge, edge.Source)
+			}
+		}
+		return found
+	}
+
+	type step struct {
+		id    string
+		depth int
+	}
+	var reached []string
+	seen := map[string]bool{start: true}
+	queue := []step{{start, 0}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current.depth == rel.maxHops {
+			continue
+		}
+		for _, other := range neighbours(current.id) {
+			if seen[other] {
+				continue
+			}
+			seen[other] = true
+			if current.depth+1 >= rel.minHops {
+				reached = append(reached, other)
+			}
+			queue = append(queue, step{other, current.depth + 1})
+		}
+	}
+	return reached
+}
+
+// execute runs the query over a graph and returns up to limit rows.
+func (q *graphQuery) execute(nodes map[string]Attrs, edges []Edge, limit int) ([]map[string]any, error) {
+	if q.limit != 0 {
+		limit = min(limit, q.limit)
+	}
+	out, in := map[string][]Edge{}, map[string][]Edge{}
+	for _, edge := range edges {
+		out[edge.Source] = append(out[edge.Source], edge)
+		in[edge.Target] = append(in[edge.Target], edge)
+	}
+
+	rows := []map[string]any{}
+	for _, id := range sortedIDs(nodes) {
+		start := nodeView(id, nodes[id])
+		if !q.start.matches(start) {
+			continue
+		}
+		var bindings []map[string]map[string]any
+		if q.rel == nil {
+			bindings = append(bindings, map[string]map[string]any{q.start.variable: start})
+		} else {
+			for _, other := range traverse(out, in, id, q.rel) {
+				if end := nodeView(other, nodes[other]); q.end.matches(end) {
+					bindings = append(bindings, map[string]map[string]any{q.start.variable: start, q.end.variable: end})
+				}
+			}
+		}
+
+	bindings:
+		for _, binding := range bindings {
+			for _, condition := range q.conditions {
+				variable, rest, _ := strings.Cut(condition.path, ".")
+				view, ok := binding[variable]
+				if !ok {
+					return nil, fmt.Errorf("%w: unknown variable in WHERE: %s", errQuerySyntax, variable)
+				}
+				var actual any = view
+				if rest != "" {
+					actual = resolve(view, rest)
+				}
+				if !compare(actual, condition.op, condition.value) {
+					continue bindings
+				}
+			}
+			row := make(map[string]any, len(q.returns))
+			for _, variable := range q.returns {
+				row[variable] = binding[variable]
+			}
+			rows = append(rows, row)
+			if len(rows) >= limit {
+				return rows, nil
+			}
+		}
+	}
+	return rows, nil
+}
+
+// Query runs a pattern query over the graph as visible at the given times.
+func (kg *KnowledgeGraph) Query(ctx context.Context, text string, limit int, asOf, validAt time.Time) ([]map[string]any, error) {
+	q, err := parseQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes, edges = filterGraph(nodes, edges, asOf, validAt)
+	return q.execute(nodes, edges, limit)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RDF (N-Triples) and JSON-LD export of the graph in the dcx ontology, as
+// in the Python graph_rdf. Node IRIs are {base}node/{id}; relationship IRIs
+// are {base}relationship/{source}/{target}, with the base from KG_RDF_BASE.
+
+var errUnsupportedRDFFormat = errors.New("unsupported RDF format")
+
+const (
+	dcxNS  = "https://github.com/jayp41/dynamic-context-mcp-system/ontology#"
+	rdfNS  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	rdfsNS = "http://www.w3.org/2000/01/rdf-schema#"
+	xsdNS  = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// rdfPrefixes are the prefixes JSON-LD terms are compacted with, in order.
+var rdfPrefixes = [][2]string{{"dcx", dcxNS}, {"rdf", rdfNS}, {"rdfs", rdfsNS}, {"xsd", xsdNS}}
+
+const ontologyTTL = `@prefix dcx: <` + dcxNS + `> .
+@prefix rdf: <` + rdfNS + `> .
+@prefix rdfs: <` + rdfsNS + `> .
+@prefix xsd: <` + xsdNS + `> .
+
+dcx:Context a rdfs:Class ; rdfs:comment "Context ingested by an agent or tool" .
+dcx:Entity a rdfs:Class ; rdfs:comment "An entity extracted from context; subclassed per entity type" .
+dcx:Relationship a rdfs:Class ; rdfs:comment "A typed, weighted, time-bounded edge between two nodes" .
+
+dcx:content a rdf:Property ; rdfs:range rdf:JSON ; rdfs:comment "The node payload as JSON" .
+dcx:contentHash a rdf:Property ; rdfs:range xsd:string ; rdfs:comment "Hash of the canonical payload, shared by duplicates" .
+dcx:importance a rdf:Property ; rdfs:range xsd:double ; rdfs:comment "Normalized PageRank score" .
+dcx:community a rdf:Property ; rdfs:range xsd:string ; rdfs:comment "Name of the detected community" .
+dcx:embeddingModel a rdf:Property ; rdfs:range xsd:string .
+dcx:embedding a rdf:Property ; rdfs:range rdf:JSON ; rdfs:comment "Embedding vector as a JSON array" .
+dcx:recordedAt a rdf:Property ; rdfs:range xsd:dateTime ; rdfs:comment "When the graph learned the fact" .
+dcx:validFrom a rdf:Property ; rdfs:range xsd:dateTime ; rdfs:comment "Start of real-world validity" .
+dcx:validTo a rdf:Property ; rdfs:range xsd:dateTime ; rdfs:comment "End of real-world validity" .
+dcx:invalidatedAt a rdf:Property ; rdfs:range xsd:dateTime ; rdfs:comment "When the fact was retracted" .
+dcx:source a rdf:Property ; rdfs:domain dcx:Relationship .
+dcx:target a rdf:Property ; rdfs:domain dcx:Relationship .
+dcx:relationshipType a rdf:Property ; rdfs:domain dcx:Relationship ; rdfs:range xsd:string .
+dcx:weight a rdf:Property ; rdfs:domain dcx:Relationship ; rdfs:range xsd:double .
+`
+
+// timeProperties map time attributes to their dcx properties, in order.
+var timeProperties = [][2]string{{"timestamp", "recordedAt"}, {"valid_from", "validFrom"},
+	{"valid_to", "validTo"}, {"invalidated_at", "invalidatedAt"}}
+
+// rdfObject is an IRI, or a literal of a datatype.
+type rdfObject struct {
+	iri      string
+	literal  any
+	datatype string
+}
+
+type triple struct {
+	subject, predicate string
+	object             rdfObject
+}
+
+// camel joins the parts of a snake_case or kebab-case name in camelCase,
+// or in UpperCamelCase if upper is set.
+func camel(name string, upper bool) string {
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(name, "-", "_"), "_") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		if upper {
+			return "Unknown"
+		}
+		return "unknown"
+	}
+	capitalize := func(s string) string {
+		runes := []rune(strings.ToLower(s))
+		return strings.ToUpper(string(runes[0])) + string(runes[1:])
+	}
+	head := strings.ToLower(parts[0])
+	if upper {
+		head = capitalize(parts[0])
+	}
+	for _, part := range parts[1:] {
+		head += capitalize(part)
+	}
+	return head
+}
+
+func nodeClass(attrs Attrs) string {
+	kind, ok := attrs["node_type"]
+	if !ok || kind == nil || kind == "context" {
+		return dcxNS + "Context"
+	}
+	return dcxNS + camel(pyStr(kind), true)
+}
+
+// triples maps the graph onto the dcx ontology: nodes by ID, then the
+// entity classes, then edges.
+func triples(nodes map[string]Attrs, edges []Edge, base string, includeEmbeddings bool) []triple {
+	var found []triple
+	add := func(subject, predicate string, object rdfObject) {
+		found = append(found, triple{subject, predicate, object})
+	}
+	addTimes := func(subject string, attrs Attrs) {
+		for _, property := range timeProperties {
+			if pyTruthy(attrs[property[0]]) {
+				add(subject, dcxNS+property[1], rdfObject{literal: attrs[property[0]], datatype: xsdNS + "dateTime"})
+			}
+		}
+	}
+
+	entityClasses := map[string]bool{}
+	for _, id := range sortedIDs(nodes) {
+		attrs := nodes[id]
+		subject := base + "node/" + id
+		class := nodeClass(attrs)
+		add(subject, rdfNS+"type", rdfObject{iri: class})
+		if class != dcxNS+"Context" {
+			entityClasses[class] = true
+		}
+		data, ok := attrs["data"]
+		if !ok {
+			data = map[string]any{}
+		}
+		add(subject, dcxNS+"content", rdfObject{literal: pyDumps(data), datatype: rdfNS + "JSON"})
+		addTimes(subject, attrs)
+		if pyTruthy(attrs["content_hash"]) {
+			add(subject, dcxNS+"contentHash", rdfObject{literal: attrs["content_hash"], datatype: xsdNS + "string"})
+		}
+		if attrs["importance"] != nil {
+			add(subject, dcxNS+"importance", rdfObject{literal: number(attrs["importance"], 0), datatype: xsdNS + "double"})
+		}
+		if pyTruthy(attrs["community_name"]) {
+			add(subject, dcxNS+"community", rdfObject{literal: attrs["community_name"], datatype: xsdNS + "string"})
+		}
+		if pyTruthy(attrs["embedding_model"]) {
+			add(subject, dcxNS+"embeddingModel", rdfObject{literal: attrs["embedding_model"], datatype: xsdNS + "string"})
+		}
+		if embedding := floats(attrs["embedding"]); includeEmbeddings && len(embedding) > 0 {
+			add(subject, dcxNS+"embedding", rdfObject{literal: pyDumps(embedding), datatype: rdfNS + "JSON"})
+		}
+	}
+
+	classes := make([]string, 0, len(entityClasses))
+	for class := range entityClasses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		add(class, rdfsNS+"subClassOf", rdfObject{iri: dcxNS + "Entity"})
+	}
+
+	for _, edge := range edges {
+		source, target := base+"node/"+edge.Source, base+"node/"+edge.Target
+		relationshipType := "related_to"
+		if kind, ok := edge.Attrs["relationship_type"]; ok {
+			relationshipType = pyStr(kind)
+		}
+		add(source, dcxNS+camel(relationshipType, false), rdfObject{iri: target})
+
+		subject := base + "relationship/" + edge.Source + "/" + edge.Target
+		add(subject, rdfNS+"type", rdfObject{iri: dcxNS + "Relationship"})
+		add(subject, dcxNS+"source", rdfObject{iri: source})
+		add(subject, dcxNS+"target", rdfObject{iri: target})
+		add(subject, dcxNS+"relationshipType", rdfObject{literal: relationshipType, datatype: xsdNS + "string"})
+		if edge.Attrs["weight"] != nil {
+			add(subject, dcxNS+"weight", rdfObject{literal: number(edge.Attrs["weight"], 0), datatype: xsdNS + "double"})
+		}
+		addTimes(subject, edge.Attrs)
+	}
+	return found
+}
+
+func toNTriples(found []triple) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	var b strings.Builder
+	for _, t := range found {
+		object := "<" + t.object.iri + ">"
+		if t.object.iri == "" {
+			object = fmt.Sprintf(`"%s"^^<%s>`, escape.Replace(pyStr(t.object.literal)), t.object.datatype)
+		}
+		fmt.Fprintf(&b, "<%s> <%s> %s .\n", t.subject, t.predicate, object)
+	}
+	if len(found) == 0 {
+		return "\n"
+	}
+	return b.String()
+}
+
+func compactIRI(value string) string {
+	for _, prefix := range rdfPrefixes {
+		if rest, ok := strings.CutPrefix(value, prefix[1]); ok {
+			return prefix[0] + ":" + rest
+		}
+	}
+	return value
+}
+
+// toJSONLD is a JSON-LD document with an object per subject in @graph.
+func toJSONLD(found []triple) (map[string]any, error) {
+	subjects := map[string]map[string]any{}
+	var order []string
+	for _, t := range found {
+		entry, ok := subjects[t.subject]
+		if !ok {
+			entry = map[string]any{"@id": compactIRI(t.subject)}
+			subjects[t.subject] = entry
+			order = append(order, t.subject)
+		}
+		if t.predicate == rdfNS+"type" {
+			types, _ := entry["@type"].([]any)
+			entry["@type"] = append(types, compactIRI(t.object.iri))
+			continue
+		}
+		var value map[string]any
+		switch {
+		case t.object.iri != "":
+			value = map[string]any{"@id": compactIRI(t.object.iri)}
+		case t.object.datatype == rdfNS+"JSON":
+			decoded, err := decodeJSON([]byte(t.object.literal.(string)))
+			if err != nil {
+				return nil, err
+			}
+			value = map[string]any{"@value": decoded, "@type": "@json"}
+		default:
+			value = map[string]any{"@value": t.object.literal, "@type": compactIRI(t.object.datatype)}
+		}
+		predicate := compactIRI(t.predicate)
+		values, _ := entry[predicate].([]any)
+		entry[predicate] = append(values, value)
+	}
+	context := map[string]string{}
+	for _, prefix := range rdfPrefixes {
+		context[prefix[0]] = prefix[1]
+	}
+	objects := make([]any, 0, len(order))
+	for _, subject := range order {
+		objects = append(objects, subjects[subject])
+	}
+	return map[string]any{"@context": context, "@graph": objects}, nil
+}
+
+// ExportRDF renders the whole graph as JSON-LD ("jsonld") or N-Triples
+// ("nt"), with embeddings only if includeEmbeddings is set.
+func (kg *KnowledgeGraph) ExportRDF(ctx context.Context, format string, includeEmbeddings bool) (string, error) {
+	if format != "jsonld" && format != "nt" {
+		return "", fmt.Errorf("%w: %s", errUnsupportedRDFFormat, format)
+	}
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return "", err
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	found := triples(nodes, edges, getenv("KG_RDF_BASE", "urn:dynamic-context:"), includeEmbeddings)
+	if format == "nt" {
+		return toNTriples(found), nil
+	}
+	document, err := toJSONLD(found)
+	if err != nil {
+		return "", err
+	}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(document); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(body.String(), "\n"), nil
+}
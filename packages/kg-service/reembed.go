@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Re-embedding the graph when the embedding model changes, as in the Python
+// graph_reembed. Vectors from different models are not comparable, so a
+// reembedJob embeds every node with the new model into a shadow index
+// (embedding_next on the node, plus a new Qdrant collection or pgvector
+// table) while the old model keeps serving ingestion and search. When the
+// shadow is complete, the job cuts over under the graph lock: shadow
+// vectors become the live ones, and the graph switches embedder and index
+// together.
+
+var (
+	errReembedRunning    = errors.New("re-embedding job is still running")
+	errReembedNotRunning = errors.New("no re-embedding job is running")
+	errNoReembed         = errors.New("no re-embedding job has run")
+)
+
+// embeddingModels counts the nodes with vectors from each embedding model,
+// under "" for nodes that name none, and lists the models in the order
+// they are first met.
+func embeddingModels(ctx context.Context, store Store) (map[string]int, []string, error) {
+	nodes, err := store.Nodes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts := map[string]int{}
+	var models []string
+	for _, id := range sortedIDs(nodes) {
+		if len(floats(nodes[id]["embedding"])) == 0 {
+			continue
+		}
+		model, _ := nodes[id]["embedding_model"].(string)
+		if _, ok := counts[model]; !ok {
+			models = append(models, model)
+		}
+		counts[model]++
+	}
+	return counts, models, nil
+}
+
+// servingEmbedder is the embedder for the model most stored vectors were
+// made with, or target when that is target's or none. A model the
+// embedding API cannot serve leaves target serving until re-embedding.
+func servingEmbedder(ctx context.Context, store Store, target Embedder) (Embedder, error) {
+	counts, models, err := embeddingModels(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	model := ""
+	for i, candidate := range models {
+		if i == 0 || counts[candidate] > counts[model] {
+			model = candidate
+		}
+	}
+	if model == "" || model == target.Model() {
+		return target, nil
+	}
+	embedder, err := getEmbedder(model)
+	if err != nil {
+		log.Printf("serving %s vectors with %s until they are re-embedded: %v", model, target.Model(), err)
+		return target, nil
+	}
+	return embedder, nil
+}
+
+// ReembedPending is whether stored vectors come from a model other than the
+// configured one.
+func (kg *KnowledgeGraph) ReembedPending(ctx context.Context) (bool, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	_, models, err := embeddingModels(ctx, kg.store)
+	if err != nil {
+		return false, err
+	}
+	for _, model := range models {
+		if model != "" && model != kg.targetEmbedder.Model() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+func modelSlug(model string) string {
+	return strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(model), "_"), "_")
+}
+
+// reembedJob re-embeds a graph's nodes with the configured model in the
+// background.
+type reembedJob struct {
+	ID        string
+	kg        *KnowledgeGraph
+	embedder  Embedder
+	batchSize int
+	fromModel string
+	toModel   string
+	// written are the nodes in the shadow index.
+	written  map[string]bool
+	cancel   context.CancelFunc
+	finished chan struct{}
+
+	mu         sync.Mutex
+	state      string
+	total      int
+	done       int
+	err        any
+	startedAt  any
+	finishedAt any
+}
+
+// reembedStatus is what GET /reembed reports.
+type reembedStatus struct {
+	JobID      string  `json:"job_id"`
+	State      string  `json:"state"`
+	FromModel  string  `json:"from_model"`
+	ToModel    string  `json:"to_model"`
+	Total      int     `json:"total"`
+	Done       int     `json:"done"`
+	Progress   float64 `json:"progress"`
+	Error      any     `json:"error"`
+	StartedAt  any     `json:"started_at"`
+	FinishedAt any     `json:"finished_at"`
+}
+
+// startReembed starts re-embedding kg with its configured model.
+func startReembed(kg *KnowledgeGraph) *reembedJob {
+	kg.mu.Lock()
+	j := &reembedJob{
+		ID:        jobID(),
+		kg:        kg,
+		embedder:  kg.targetEmbedder,
+		batchSize: kg.config.Embedding.BatchSize,
+		fromModel: kg.embedder.Model(),
+		toModel:   kg.targetEmbedder.Model(),
+		written:   map[string]bool{},
+		finished:  make(chan struct{}),
+		state:     "pending",
+	}
+	kg.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	go j.run(ctx)
+	return j
+}
+
+// Running is whether the job has yet to complete, fail or be cancelled.
+func (j *reembedJob) Running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state == "pending" || j.state == "running" || j.state == "cutover"
+}
+
+func (j *reembedJob) Status() reembedStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	progress := 1.0
+	if j.total > 0 {
+		progress = math.Round(float64(j.done)/float64(j.total)*1e4) / 1e4
+	}
+	return reembedStatus{JobID: j.ID, State: j.state, FromModel: j.fromModel, ToModel: j.toModel,
+		Total: j.total, Done: j.done, Progress: progress, Error: j.err,
+		StartedAt: j.startedAt, FinishedAt: j.finishedAt}
+}
+
+// Cancel stops the job and waits for it to discard its shadow index.
+func (j *reembedJob) Cancel() {
+	j.cancel()
+	<-j.finished
+}
+
+func (j *reembedJob) setState(state string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+}
+
+type pendingNode struct {
+	id, text string
+}
+
+// remaining are the nodes with a vector that are not in the shadow index
+// yet. The caller holds kg.mu.
+func (j *reembedJob) remaining(ctx context.Context) ([]pendingNode, error) {
+	nodes, err := j.kg.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var remaining []pendingNode
+	for _, id := range sortedIDs(nodes) {
+		if len(floats(nodes[id]["embedding"])) == 0 || j.written[id] {
+			continue
+		}
+		data, ok := nodes[id]["data"]
+		if !ok {
+			data = map[string]any{}
+		}
+		remaining = append(remaining, pendingNode{id, contextText([]byte(pyDumps(data)))})
+	}
+	return remaining, nil
+}
+
+func (j *reembedJob) embed(ctx context.Context, batch []pendingNode) ([][]float64, error) {
+	if len(batch) == 0 {
+		return nil, nil
+	}
+	texts := make([]string, len(batch))
+	for i, node := range batch {
+		texts[i] = node.text
+	}
+	vectors, err := j.embedder.Embed(ctx, texts)
+	if err == nil && len(vectors) != len(batch) {
+		err = fmt.Errorf("embedder returned %d vectors for %d inputs", len(vectors), len(batch))
+	}
+	return vectors, err
+}
+
+// write stores shadow vectors. The caller holds kg.mu.
+func (j *reembedJob) write(ctx context.Context, shadow VectorIndex, batch []pendingNode, vectors [][]float64) error {
+	for i, node := range batch {
+		attrs, err := j.kg.store.GetNode(ctx, node.id)
+		if err != nil {
+			return err
+		}
+		if attrs == nil {
+			continue // removed since the batch was read
+		}
+		if err := j.kg.store.AddNode(ctx, node.id, Attrs{"embedding_next": vectors[i], "embedding_next_model": j.toModel}); err != nil {
+			return err
+		}
+		if err := shadow.Upsert(ctx, node.id, vectors[i]); err != nil {
+			return err
+		}
+		j.written[node.id] = true
+		j.mu.Lock()
+		j.done++
+		j.mu.Unlock()
+	}
+	return nil
+}
+
+// pass embeds the nodes not in the shadow index yet a batch at a time, or,
+// with a batch or less left, embeds those under the lock and cuts over. It
+// reports whether it cut over.
+func (j *reembedJob) pass(ctx context.Context, shadow VectorIndex) (bool, error) {
+	j.kg.mu.Lock()
+	remaining, err := j.remaining(ctx)
+	if err != nil {
+		j.kg.mu.Unlock()
+		return false, err
+	}
+	j.mu.Lock()
+	j.total = j.done + len(remaining)
+	j.mu.Unlock()
+	if len(remaining) <= j.batchSize {
+		defer j.kg.mu.Unlock()
+		// The last batch, nodes ingested meanwhile included, is embedded
+		// under the lock so nothing slips in unembedded
+		vectors, err := j.embed(ctx, remaining)
+		if err != nil {
+			return false, err
+		}
+		if err := j.write(ctx, shadow, remaining, vectors); err != nil {
+			return false, err
+		}
+		j.setState("cutover")
+		return true, j.cutover(ctx, shadow)
+	}
+	j.kg.mu.Unlock()
+
+	for start := 0; start < len(remaining); start += j.batchSize {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		batch := remaining[start:min(start+j.batchSize, len(remaining))]
+		// Embedding is the slow part, so it runs outside the lock
+		vectors, err := j.embed(ctx, batch)
+		if err != nil {
+			return false, err
+		}
+		j.kg.mu.Lock()
+		err = j.write(ctx, shadow, batch, vectors)
+		j.kg.mu.Unlock()
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (j *reembedJob) run(ctx context.Context) {
+	defer close(j.finished)
+	j.mu.Lock()
+	j.state, j.startedAt = "running", now()
+	j.mu.Unlock()
+
+	var shadow VectorIndex
+	err := func() error {
+		probe, err := embedOne(ctx, j.embedder, "dimension probe")
+		if err != nil {
+			return fmt.Errorf("probing embedding dimension: %w", err)
+		}
+		j.kg.mu.Lock()
+		shadow, err = j.kg.index.Shadow(ctx, modelSlug(j.toModel)+"_"+j.ID[:8], len(probe))
+		j.kg.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		for {
+			if cutOver, err := j.pass(ctx, shadow); err != nil || cutOver {
+				return err
+			}
+		}
+	}()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finishedAt = now()
+	switch {
+	case err == nil:
+		j.state = "completed"
+		return
+	case ctx.Err() != nil:
+		j.state = "cancelled"
+	default:
+		j.state, j.err = "failed", err.Error()
+	}
+	if shadow != nil {
+		if err := shadow.Drop(context.Background()); err != nil {
+			log.Printf("could not drop the shadow index: %v", err)
+		}
+	}
+}
+
+// cutover makes the shadow vectors live. The caller holds kg.mu.
+func (j *reembedJob) cutover(ctx context.Context, shadow VectorIndex) error {
+	nodes, err := j.kg.store.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(nodes) {
+		if model, _ := nodes[id]["embedding_next_model"].(string); model != j.toModel {
+			continue
+		}
+		err := j.kg.store.AddNode(ctx, id, Attrs{"embedding": nodes[id]["embedding_next"],
+			"embedding_model": j.toModel, "embedding_next": nil, "embedding_next_model": nil})
+		if err != nil {
+			return err
+		}
+	}
+	index, err := j.kg.index.Promote(ctx, shadow)
+	if err != nil {
+		return err
+	}
+	j.kg.index, j.kg.embedder = index, j.embedder
+	return nil
+}
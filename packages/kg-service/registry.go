@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/rbac"
+)
+
+// Named graphs, so projects or tenants get isolated graphs in one service,
+// as in the Python graph_registry. Each graph has its own store (a separate
+// file, or its own Neo4j label) and its own Qdrant collection or pgvector
+// table. The catalog of graph IDs lives in the JSON file named by
+// KG_GRAPH_CATALOG; the "default" graph always exists.
+//
+// A tenant other than "default" has graphs of its own, stored as
+// "<tenant>__<graph>" with the tenant's hyphens as underscores, which no
+// other tenant's requests reach. Its "default" graph is created on first use.
+
+const (
+	defaultGraph    = "default"
+	tenantSeparator = "__"
+)
+
+var graphIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_]{0,62}$`)
+
+var (
+	errGraphNotFound = errors.New("graph not found")
+	errGraphExists   = errors.New("graph already exists")
+	errInvalidGraph  = errors.New("invalid graph")
+)
+
+// tenantGraphID is where a tenant's graph is stored. Tenant IDs have no
+// underscores, so the first separator splits it again.
+func tenantGraphID(tenant, graphID string) string {
+	if tenant == rbac.DefaultTenant {
+		return graphID
+	}
+	return strings.ReplaceAll(tenant, "-", "_") + tenantSeparator + graphID
+}
+
+// splitGraphID is the tenant and graph ID of a stored graph ID.
+func splitGraphID(storedID string) (tenant, graphID string) {
+	tenant, graphID, ok := strings.Cut(storedID, tenantSeparator)
+	if !ok {
+		return rbac.DefaultTenant, storedID
+	}
+	return strings.ReplaceAll(tenant, "_", "-"), graphID
+}
+
+// Graph is an open graph of the registry, with its ingest workers and
+// re-embedding job.
+type Graph struct {
+	// ID is where the graph is stored, which names it in backups.
+	ID     string
+	kg     *KnowledgeGraph
+	ingest *ingestPool
+
+	mu sync.Mutex
+	// reembed is the most recent re-embedding job.
+	reembed *reembedJob
+}
+
+// openGraph starts the workers of a graph just opened, and re-embeds it if
+// the config asks and its vectors are from another model.
+func openGraph(ctx context.Context, storedID string, kg *KnowledgeGraph) (*Graph, error) {
+	ingest, err := newIngestPool(kg)
+	if err != nil {
+		return nil, err
+	}
+	graph := &Graph{ID: storedID, kg: kg, ingest: ingest}
+	pending, err := kg.ReembedPending(ctx)
+	if err != nil {
+		ingest.Close()
+		return nil, err
+	}
+	if kg.Config().Embedding.AutoReembed && pending {
+		job, _ := graph.StartReembed()
+		log.Printf("re-embedding graph %s: %s -> %s", storedID, job.fromModel, job.toModel)
+	}
+	return graph, nil
+}
+
+// StartReembed re-embeds every node with the configured model in the
+// background, unless a job is already running.
+func (graph *Graph) StartReembed() (*reembedJob, error) {
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	if graph.reembed != nil && graph.reembed.Running() {
+		return nil, fmt.Errorf("%w: %s", errReembedRunning, graph.reembed.ID)
+	}
+	graph.reembed = startReembed(graph.kg)
+	return graph.reembed, nil
+}
+
+// Reembed is the most recent re-embedding job.
+func (graph *Graph) Reembed() (*reembedJob, error) {
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	if graph.reembed == nil {
+		return nil, errNoReembed
+	}
+	return graph.reembed, nil
+}
+
+// CancelReembed cancels the running re-embedding job.
+func (graph *Graph) CancelReembed() (*reembedJob, error) {
+	graph.mu.Lock()
+	job := graph.reembed
+	graph.mu.Unlock()
+	if job == nil || !job.Running() {
+		return nil, errReembedNotRunning
+	}
+	job.Cancel()
+	return job, nil
+}
+
+// Close stops the graph's workers and closes it.
+func (graph *Graph) Close() error {
+	if job, err := graph.CancelReembed(); err == nil {
+		log.Printf("cancelled re-embedding job %s of graph %s", job.ID, graph.ID)
+	}
+	graph.ingest.Close()
+	return graph.kg.Close()
+}
+
+type graphRegistry struct {
+	// open opens the graph stored under an ID.
+	open        func(ctx context.Context, storedID string) (*KnowledgeGraph, error)
+	catalogPath string
+
+	mu      sync.Mutex
+	catalog map[string]Attrs
+	graphs  map[string]*Graph
+}
+
+func newGraphRegistry(catalogPath string, open func(context.Context, string) (*KnowledgeGraph, error)) (*graphRegistry, error) {
+	g := &graphRegistry{open: open, catalogPath: catalogPath, catalog: map[string]Attrs{}, graphs: map[string]*Graph{}}
+	contents, err := os.ReadFile(catalogPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(contents, &g.catalog); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", catalogPath, err)
+		}
+	}
+	if _, ok := g.catalog[defaultGraph]; !ok {
+		g.catalog[defaultGraph] = Attrs{"created_at": nil, "description": "Default graph"}
+	}
+	return g, nil
+}
+
+// saveCatalog writes the catalog; the caller holds g.mu.
+func (g *graphRegistry) saveCatalog() error {
+	encoded, err := json.MarshalIndent(g.catalog, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(g.catalogPath, encoded)
+}
+
+// IDs are every stored graph ID, or a tenant's, in order.
+func (g *graphRegistry) IDs(tenant string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var ids []string
+	for id := range g.catalog {
+		if owner, _ := splitGraphID(id); tenant == "" || owner == tenant {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (g *graphRegistry) catalogEntry(storedID string) (Attrs, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	entry, ok := g.catalog[storedID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errGraphNotFound, storedID)
+	}
+	return copyAttrs(entry), nil
+}
+
+// Info is a graph's catalog entry, with its tenant and its own ID.
+func (g *graphRegistry) Info(storedID string) (map[string]any, error) {
+	entry, err := g.catalogEntry(storedID)
+	if err != nil {
+		return nil, err
+	}
+	tenant, graphID := splitGraphID(storedID)
+	info := map[string]any{"graph_id": graphID, "tenant": tenant}
+	for k, v := range entry {
+		info[k] = v
+	}
+	g.mu.Lock()
+	_, info["loaded"] = g.graphs[storedID]
+	g.mu.Unlock()
+	return info, nil
+}
+
+// Get is the open graph for a stored ID, opening it on first use.
+func (g *graphRegistry) Get(ctx context.Context, storedID string) (*Graph, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.catalog[storedID]; !ok {
+		return nil, fmt.Errorf("%w: %s", errGraphNotFound, storedID)
+	}
+	if graph, ok := g.graphs[storedID]; ok {
+		return graph, nil
+	}
+	kg, err := g.open(ctx, storedID)
+	if err != nil {
+		return nil, fmt.Errorf("opening graph %s: %w", storedID, err)
+	}
+	graph, err := openGraph(ctx, storedID, kg)
+	if err != nil {
+		kg.Close()
+		return nil, fmt.Errorf("opening graph %s: %w", storedID, err)
+	}
+	g.graphs[storedID] = graph
+	return graph, nil
+}
+
+// Loaded are the open graphs.
+func (g *graphRegistry) Loaded() []*Graph {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	graphs := make([]*Graph, 0, len(g.graphs))
+	for _, graph := range g.graphs {
+		graphs = append(graphs, graph)
+	}
+	sort.Slice(graphs, func(i, j int) bool { return graphs[i].ID < graphs[j].ID })
+	return graphs
+}
+
+// Create adds a tenant's graph; graphID is the tenant's own ID for it.
+func (g *graphRegistry) Create(ctx context.Context, graphID string, description any, tenant string) (*Graph, error) {
+	if !graphIDPattern.MatchString(graphID) || strings.Contains(graphID, tenantSeparator) {
+		return nil, fmt.Errorf("%w: graph IDs are 1-63 lowercase letters, digits or single underscores", errInvalidGraph)
+	}
+	storedID := tenantGraphID(tenant, graphID)
+	if !graphIDPattern.MatchString(storedID) {
+		return nil, fmt.Errorf("%w: graph ID is too long for tenant %s", errInvalidGraph, tenant)
+	}
+	g.mu.Lock()
+	if _, ok := g.catalog[storedID]; ok {
+		g.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", errGraphExists, storedID)
+	}
+	g.catalog[storedID] = Attrs{"created_at": now(), "description": description}
+	err := g.saveCatalog()
+	g.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return g.Get(ctx, storedID)
+}
+
+// TenantGraph is a tenant's graph; its default graph is created on first use.
+func (g *graphRegistry) TenantGraph(ctx context.Context, tenant, graphID string) (*Graph, error) {
+	graph, err := g.Get(ctx, tenantGraphID(tenant, graphID))
+	if !errors.Is(err, errGraphNotFound) || graphID != defaultGraph {
+		return graph, err
+	}
+	graph, err = g.Create(ctx, graphID, "Default graph of tenant "+tenant, tenant)
+	if errors.Is(err, errGraphExists) {
+		return g.Get(ctx, tenantGraphID(tenant, graphID))
+	}
+	return graph, err
+}
+
+// addToCatalog adds a graph restored from a backup unless it is in the
+// catalog, reporting whether it was added.
+func (g *graphRegistry) addToCatalog(storedID string, info Attrs) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.catalog[storedID]; ok {
+		return false, nil
+	}
+	if info == nil {
+		info = Attrs{}
+	}
+	g.catalog[storedID] = info
+	return true, g.saveCatalog()
+}
+
+// Drop deletes every node of a graph and removes it from the catalog.
+func (g *graphRegistry) Drop(ctx context.Context, storedID string) (map[string]any, error) {
+	if storedID == defaultGraph {
+		return nil, fmt.Errorf("%w: the default graph cannot be dropped", errInvalidGraph)
+	}
+	graph, err := g.Get(ctx, storedID)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := graph.kg.Clear(ctx)
+	if err != nil {
+		return nil, err
+	}
+	closeErr := graph.Close()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.graphs, storedID)
+	delete(g.catalog, storedID)
+	if err := g.saveCatalog(); err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+	_, graphID := splitGraphID(storedID)
+	return map[string]any{"graph_id": graphID, "removed_nodes": removed}, nil
+}
+
+// Close closes every open graph.
+func (g *graphRegistry) Close() error {
+	var errs []error
+	for _, graph := range g.Loaded() {
+		if err := graph.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing graph %s: %w", graph.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeFileAtomic writes data to a temporary file beside path, which then
+// replaces it, so a failed write leaves the last one.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := f.Chmod(0o644); err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
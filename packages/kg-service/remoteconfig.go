@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteConfig pulls the graph's settings from the config service at
+// CONFIG_URL, like the Python config_client: their env goes into the
+// service's environment, where everything that reads a setting already
+// looks, and their config is laid over the KG_CONFIG file. A variable the
+// service was started with wins over the config service's, so one
+// deployment can still be set apart.
+type RemoteConfig struct {
+	url       string
+	component string
+	token     string
+	client    *http.Client
+
+	mu      sync.Mutex
+	version int64
+	// managed are the variables set from the service, which it may change
+	// or take away again.
+	managed map[string]bool
+	config  map[string]any
+	flags   map[string]remoteFlag
+}
+
+type remoteSettings struct {
+	Version int64                 `json:"version"`
+	Env     map[string]string     `json:"env"`
+	Config  map[string]any        `json:"config"`
+	Flags   map[string]remoteFlag `json:"flags"`
+}
+
+// remoteFlag is a feature flag: whether it is on, and for which tenants
+// that is not so.
+type remoteFlag struct {
+	Enabled bool            `json:"enabled"`
+	Tenants map[string]bool `json:"tenants"`
+}
+
+// pullConfig takes the component's settings from the config service once,
+// before anything reads them.
+func pullConfig(ctx context.Context, rawURL, component, token string) (*RemoteConfig, error) {
+	c := &RemoteConfig{url: strings.TrimRight(rawURL, "/"), component: component, token: token,
+		client: &http.Client{}, managed: map[string]bool{}}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	settings, err := c.fetch(ctx, -1)
+	if err != nil {
+		return nil, fmt.Errorf("pulling settings from %s: %w", c.url, err)
+	}
+	c.apply(settings)
+	log.Printf("settings version %d from the config service (%d variables)", settings.Version, len(c.managed))
+	return c, nil
+}
+
+// fetch gets the settings, waiting for ones past after unless it is
+// negative.
+func (c *RemoteConfig) fetch(ctx context.Context, after int64) (remoteSettings, error) {
+	endpoint := c.url + "/config/" + url.PathEscape(c.component)
+	if after >= 0 {
+		endpoint += fmt.Sprintf("?after=%d&wait=30", after)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return remoteSettings{}, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return remoteSettings{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return remoteSettings{}, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	var settings remoteSettings
+	if err := json.NewDecoder(resp.Body).Decode(&settings); err != nil {
+		return remoteSettings{}, err
+	}
+	return settings, nil
+}
+
+// apply sets the service's variables, leaving alone those the service was
+// started with, and unsets those the config service no longer has. It
+// reports the variables it changed.
+func (c *RemoteConfig) apply(settings remoteSettings) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version = settings.Version
+	c.config = settings.Config
+	c.flags = settings.Flags
+	var changed []string
+	for key, value := range settings.Env {
+		current, set := os.LookupEnv(key)
+		if set && !c.managed[key] {
+			continue
+		}
+		c.managed[key] = true
+		if !set || current != value {
+			os.Setenv(key, value)
+			changed = append(changed, key)
+		}
+	}
+	for key := range c.managed {
+		if _, ok := settings.Env[key]; !ok {
+			os.Unsetenv(key)
+			delete(c.managed, key)
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Version is the version of the settings last taken from the service.
+func (c *RemoteConfig) Version() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// Overrides are the config the service lays over the config file; none
+// without a config service.
+func (c *RemoteConfig) Overrides() map[string]any {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config
+}
+
+// Enabled is whether a feature flag is on for a tenant: its tenant's
+// override, or else whether it is on. fallback is for a flag the service
+// does not have, or when there is no config service.
+func (c *RemoteConfig) Enabled(flag, tenant string, fallback bool) bool {
+	if c == nil {
+		return fallback
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rule, ok := c.flags[flag]
+	if !ok {
+		return fallback
+	}
+	if enabled, ok := rule.Tenants[tenant]; ok {
+		return enabled
+	}
+	return rule.Enabled
+}
+
+// Watch waits on the service for changes until ctx is done, applying each
+// new version and calling changed with the variables it changed; a version
+// may change only the config, so changed is called for every one. It tries
+// again a second after the service fails it, and up to 30 seconds after
+// several.
+func (c *RemoteConfig) Watch(ctx context.Context, changed func(keys []string)) {
+	wait := time.Second
+	for ctx.Err() == nil {
+		settings, err := c.fetch(ctx, c.Version())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("config service: retrying in %s: %v", wait, err)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			wait = min(2*wait, 30*time.Second)
+			continue
+		}
+		wait = time.Second
+		if settings.Version == c.Version() {
+			continue
+		}
+		keys := c.apply(settings)
+		log.Printf("settings version %d from the config service", settings.Version)
+		changed(keys)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Node-type schemas and edge constraints from the config's schema section,
+// as in the Python graph_schema. A context node's type is its data's
+// "type"; entity nodes use their node type, and edge type lists match
+// either. In quarantine mode nonconforming payloads are parked in a
+// per-graph JSON Lines file under KG_QUARANTINE_DIR for review instead of
+// being ingested.
+
+var errQuarantineNotFound = errors.New("quarantine entry not found")
+
+var schemaModes = []string{"off", "reject", "quarantine"}
+
+// propertyTypes are the types a node type's properties may be declared as.
+var propertyTypes = []string{"string", "number", "integer", "boolean", "array", "object"}
+
+type nodeTypeSpec struct {
+	Required   []string          `json:"required"`
+	Properties map[string]string `json:"properties"`
+}
+
+type edgeSpec struct {
+	SourceTypes []string `json:"source_types"`
+	TargetTypes []string `json:"target_types"`
+}
+
+// schema is the schema section of the config.
+type schema struct {
+	Mode              string                  `json:"mode"`
+	AllowUnknownTypes bool                    `json:"allow_unknown_types"`
+	NodeTypes         map[string]nodeTypeSpec `json:"node_types"`
+	Edges             map[string]edgeSpec     `json:"edges"`
+}
+
+func (s schema) validate() error {
+	if !contains(schemaModes, s.Mode) {
+		return fmt.Errorf("schema mode must be one of %s", strings.Join(schemaModes, ", "))
+	}
+	for name, spec := range s.NodeTypes {
+		for property, kind := range spec.Properties {
+			if !contains(propertyTypes, kind) {
+				return fmt.Errorf("node type %q property %q has unknown type %q", name, property, kind)
+			}
+		}
+	}
+	return nil
+}
+
+func (s schema) enabled() bool { return s.Mode != "off" }
+
+// schemaError lists how a payload or edge breaks the schema.
+type schemaError struct {
+	errors []string
+}
+
+func (e schemaError) Error() string { return strings.Join(e.errors, "; ") }
+
+// quarantinedError is a payload parked in the quarantine as entry ID.
+type quarantinedError struct {
+	schemaError
+	ID string
+}
+
+// pyTypeName is the Python type name of a decoded JSON value.
+func pyTypeName(value any) string {
+	switch value := value.(type) {
+	case string:
+		return "str"
+	case bool:
+		return "bool"
+	case json.Number:
+		if strings.ContainsAny(value.String(), ".eE") {
+			return "float"
+		}
+		return "int"
+	case float64:
+		return "float"
+	case int:
+		return "int"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "dict"
+	}
+	return "NoneType"
+}
+
+func hasType(value any, kind string) bool {
+	name := pyTypeName(value)
+	switch kind {
+	case "string":
+		return name == "str"
+	case "number":
+		return name == "int" || name == "float"
+	case "integer":
+		return name == "int"
+	case "boolean":
+		return name == "bool"
+	case "array":
+		return name == "list"
+	}
+	return name == "dict"
+}
+
+// nodeErrors are the ways a context payload breaks the schema.
+func (s schema) nodeErrors(data any) []string {
+	payload, ok := data.(map[string]any)
+	if !ok {
+		return []string{"Node data must be an object"}
+	}
+	typeName, _ := payload["type"].(string)
+	spec, ok := s.NodeTypes[typeName]
+	if !ok {
+		switch {
+		case s.AllowUnknownTypes:
+			return nil
+		case pyTruthy(payload["type"]):
+			return []string{"Unknown node type: " + pyStr(payload["type"])}
+		}
+		return []string{"Node data has no type"}
+	}
+	var found []string
+	for _, attr := range spec.Required {
+		if value := payload[attr]; value == nil || value == "" {
+			found = append(found, fmt.Sprintf("%s node is missing required attribute '%s'", typeName, attr))
+		}
+	}
+	properties := make([]string, 0, len(spec.Properties))
+	for property := range spec.Properties {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+	for _, property := range properties {
+		kind := spec.Properties[property]
+		if value := payload[property]; value != nil && !hasType(value, kind) {
+			found = append(found, fmt.Sprintf("%s attribute '%s' must be %s, got %s", typeName, property, kind, pyTypeName(value)))
+		}
+	}
+	return found
+}
+
+// nodeTypesOf are the type names a node answers to: its node type and, for
+// context, its data's type.
+func nodeTypesOf(attrs Attrs) []string {
+	types := []string{nodeType(attrs)}
+	if data, ok := attrs["data"].(map[string]any); ok && pyTruthy(data["type"]) && pyStr(data["type"]) != types[0] {
+		types = append(types, pyStr(data["type"]))
+	}
+	sort.Strings(types)
+	return types
+}
+
+// edgeErrors are the ways an explicit relationship breaks the edge
+// constraints.
+func (s schema) edgeErrors(relationshipType string, source, target Attrs) []string {
+	spec, ok := s.Edges[relationshipType]
+	if !ok {
+		return nil
+	}
+	var found []string
+	for _, end := range []struct {
+		role    string
+		attrs   Attrs
+		allowed []string
+	}{{"source", source, spec.SourceTypes}, {"target", target, spec.TargetTypes}} {
+		types := nodeTypesOf(end.attrs)
+		if len(end.allowed) == 0 || contains(end.allowed, types[0]) || len(types) > 1 && contains(end.allowed, types[1]) {
+			continue
+		}
+		found = append(found, fmt.Sprintf("%s %s must be one of %s, got %s", relationshipType, end.role,
+			strings.Join(end.allowed, ", "), strings.Join(types, ", ")))
+	}
+	return found
+}
+
+// quarantinePath is the quarantine file of a graph.
+func quarantinePath(graphID string) string {
+	return filepath.Join(getenv("KG_QUARANTINE_DIR", "/data/quarantine"), graphID+".jsonl")
+}
+
+// quarantineEntry is a payload parked for review.
+type quarantineEntry struct {
+	ID            string   `json:"id"`
+	QuarantinedAt string   `json:"quarantined_at"`
+	Errors        []string `json:"errors"`
+	Data          any      `json:"data"`
+	ValidFrom     any      `json:"valid_from"`
+	ValidTo       any      `json:"valid_to"`
+}
+
+// quarantine is the file of a graph's quarantined payloads, one JSON object
+// per line.
+type quarantine struct {
+	path string
+}
+
+func (q quarantine) Entries() ([]quarantineEntry, error) {
+	contents, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []quarantineEntry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entries := []quarantineEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		var entry quarantineEntry
+		if err := dec.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("%s: %w", q.path, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func (q quarantine) write(entries []quarantineEntry) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(q.path, body.Bytes())
+}
+
+// Add parks a payload and returns its entry ID.
+func (q quarantine) Add(data any, found []string, validFrom, validTo string) (string, error) {
+	entries, err := q.Entries()
+	if err != nil {
+		return "", err
+	}
+	entry := quarantineEntry{ID: jobID(), QuarantinedAt: now(), Errors: found, Data: data}
+	if validFrom != "" {
+		entry.ValidFrom = validFrom
+	}
+	if validTo != "" {
+		entry.ValidTo = validTo
+	}
+	return entry.ID, q.write(append(entries, entry))
+}
+
+// Get is the entry ID.
+func (q quarantine) Get(id string) (quarantineEntry, error) {
+	entries, err := q.Entries()
+	if err != nil {
+		return quarantineEntry{}, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return quarantineEntry{}, fmt.Errorf("%w: %s", errQuarantineNotFound, id)
+}
+
+// Pop removes the entry ID and returns it.
+func (q quarantine) Pop(id string) (quarantineEntry, error) {
+	entries, err := q.Entries()
+	if err != nil {
+		return quarantineEntry{}, err
+	}
+	for i, entry := range entries {
+		if entry.ID == id {
+			return entry, q.write(append(entries[:i:i], entries[i+1:]...))
+		}
+	}
+	return quarantineEntry{}, fmt.Errorf("%w: %s", errQuarantineNotFound, id)
+}
+
+// checkSchema refuses a payload that breaks the schema, or in quarantine
+// mode parks it. The caller holds kg.mu.
+func (kg *KnowledgeGraph) checkSchema(data any, validFrom, validTo string) error {
+	found := kg.config.Schema.nodeErrors(data)
+	if len(found) == 0 {
+		return nil
+	}
+	if kg.config.Schema.Mode == "quarantine" {
+		id, err := kg.quarantine.Add(data, found, validFrom, validTo)
+		if err != nil {
+			return err
+		}
+		return quarantinedError{schemaError{found}, id}
+	}
+	return schemaError{found}
+}
+
+// Quarantined are the graph's quarantined payloads.
+func (kg *KnowledgeGraph) Quarantined() ([]quarantineEntry, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.quarantine.Entries()
+}
+
+// ReleaseQuarantined ingests a quarantined payload once it conforms, or
+// unconditionally with force, and returns the node holding it.
+func (kg *KnowledgeGraph) ReleaseQuarantined(ctx context.Context, id string, force bool) (string, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	entry, err := kg.quarantine.Get(id)
+	if err != nil {
+		return "", err
+	}
+	if found := kg.config.Schema.nodeErrors(entry.Data); len(found) > 0 && !force {
+		return "", schemaError{found}
+	}
+	if _, err := kg.quarantine.Pop(id); err != nil {
+		return "", err
+	}
+	raw := []byte(pyDumps(entry.Data))
+	text := contextText(raw)
+	embedding, err := embedOne(ctx, kg.embedder, text)
+	if err != nil {
+		return "", fmt.Errorf("embedding: %w", err)
+	}
+	validFrom, _ := entry.ValidFrom.(string)
+	validTo, _ := entry.ValidTo.(string)
+	return kg.insertContextNode(ctx, entry.Data, text, embedding, validFrom, validTo)
+}
+
+// DiscardQuarantined drops a quarantined payload.
+func (kg *KnowledgeGraph) DiscardQuarantined(id string) error {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	_, err := kg.quarantine.Pop(id)
+	return err
+}
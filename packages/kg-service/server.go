@@ -0,0 +1,1129 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/rbac"
+)
+
+// server exposes the same routes and JSON shapes as the Python FastAPI
+// service.
+type server struct {
+	graphs  *graphRegistry
+	backend string
+	// remote is the config service, or nil without one.
+	remote     *RemoteConfig
+	configPath string
+
+	mu     sync.Mutex
+	config *Config
+}
+
+// Config is the service's config, which every graph works by.
+func (s *server) Config() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config
+}
+
+// reloadSettings takes up new settings from the config service: thresholds,
+// rules and quotas at once, the embedding model and backends once the
+// service restarts. A config
+// that does not validate is logged and the service keeps its own.
+func (s *server) reloadSettings(keys []string) {
+	config, err := loadConfig(s.configPath, s.remote.Overrides())
+	if err != nil {
+		log.Printf("keeping the graph config: %v", err)
+		return
+	}
+	s.mu.Lock()
+	if !reflect.DeepEqual(config.Raw["embedding"], s.config.Raw["embedding"]) {
+		log.Printf("the new embedding model is used once the service restarts")
+		config.Raw["embedding"], config.Embedding = s.config.Raw["embedding"], s.config.Embedding
+	}
+	s.config = config
+	s.mu.Unlock()
+	for _, graph := range s.graphs.Loaded() {
+		graph.kg.ApplyConfig(config)
+	}
+}
+
+// graphHandler serves a graph-scoped endpoint for the graph it is given.
+type graphHandler func(w http.ResponseWriter, r *http.Request, graph *Graph)
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", s.health)
+	mux.HandleFunc("GET /graphs", s.listGraphs)
+	mux.HandleFunc("POST /graphs", s.createGraph)
+	mux.HandleFunc("GET /graphs/{graph_id}", s.inGraph(s.graphInfo))
+	mux.HandleFunc("DELETE /graphs/{graph_id}", s.inGraph(s.dropGraph))
+	mux.HandleFunc("GET /backup", s.wholeService(s.backup))
+	mux.HandleFunc("POST /backup/restore", s.wholeService(s.restore))
+	mux.HandleFunc("GET /ui", s.viewer)
+	mux.HandleFunc("GET /export/ontology.ttl", s.ontology)
+
+	// Graph-scoped endpoints are served for the default graph at the root (or
+	// any graph via ?graph_id=) and for every named graph under /graphs/{graph_id}
+	for pattern, handle := range map[string]graphHandler{
+		"POST /nodes":                         s.withinQuota(s.addNode),
+		"GET /nodes":                          s.subjectNodes,
+		"GET /nodes/{node_id}":                s.getNode,
+		"POST /nodes/{node_id}/invalidate":    s.invalidateNode,
+		"POST /episodes":                      s.addEpisode,
+		"POST /edges":                         s.addEdge,
+		"POST /edges/invalidate":              s.invalidateEdge,
+		"GET /search":                         s.search,
+		"GET /stats":                          s.stats,
+		"GET /config":                         s.graphConfig,
+		"GET /entities":                       s.entities,
+		"POST /importance/refresh":            s.refreshImportance,
+		"GET /importance":                     s.importantNodes,
+		"POST /communities/detect":            s.detectCommunities,
+		"GET /communities":                    s.communities,
+		"GET /communities/{community_id}":     s.community,
+		"POST /decay/run":                     s.runDecay,
+		"GET /decay/stats":                    s.decayStats,
+		"GET /tombstones":                     s.tombstones,
+		"POST /dedup":                         s.dedup,
+		"POST /purge":                         s.purge,
+		"POST /query":                         s.query,
+		"GET /diff":                           s.diff,
+		"POST /snapshots":                     s.createSnapshot,
+		"GET /snapshots":                      s.snapshots,
+		"POST /snapshots/{name}/restore":      s.restoreSnapshot,
+		"DELETE /snapshots/{name}":            s.deleteSnapshot,
+		"GET /viz/graph":                      s.vizGraph,
+		"GET /export/dot":                     s.exportDOT,
+		"GET /export/rdf":                     s.exportRDF,
+		"GET /export/graphml":                 s.exportGraphML,
+		"POST /export":                        s.exportGraph,
+		"POST /import":                        s.withinQuota(s.importGraph),
+		"POST /ingest":                        s.withinQuota(s.ingest),
+		"GET /ingest/{job_id}":                s.ingestStatus,
+		"POST /reembed":                       s.startReembed,
+		"GET /reembed":                        s.reembedStatus,
+		"DELETE /reembed":                     s.cancelReembed,
+		"GET /quarantine":                     s.quarantined,
+		"POST /quarantine/{entry_id}/release": s.releaseQuarantined,
+		"DELETE /quarantine/{entry_id}":       s.discardQuarantined,
+	} {
+		method, path, _ := strings.Cut(pattern, " ")
+		mux.HandleFunc(pattern, s.inGraph(handle))
+		mux.HandleFunc(method+" /graphs/{graph_id}"+path, s.inGraph(handle))
+	}
+	return mux
+}
+
+// inGraph resolves the requesting tenant's graph named by the path or the
+// graph_id parameter, answering 404 when the tenant has no such graph.
+func (s *server) inGraph(handle graphHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		graphID := r.PathValue("graph_id")
+		if graphID == "" {
+			graphID = cmp.Or(r.URL.Query().Get("graph_id"), defaultGraph)
+		}
+		graph, err := s.graphs.TenantGraph(r.Context(), r.Header.Get(rbac.TenantHeader), graphID)
+		if errors.Is(err, errGraphNotFound) {
+			writeError(w, http.StatusNotFound, "Graph not found: "+graphID)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		handle(w, r, graph)
+	}
+}
+
+// overQuota reports whether a tenant's graphs hold as many nodes as its
+// quota allows, and the quota.
+func (s *server) overQuota(ctx context.Context, tenant string) (bool, int, error) {
+	quotas := s.Config().Quotas
+	if !quotas.Enabled {
+		return false, 0, nil
+	}
+	limit, ok := quotas.maxNodes(tenant)
+	if !ok {
+		return false, 0, nil
+	}
+	used := 0
+	for _, id := range s.graphs.IDs(tenant) {
+		graph, err := s.graphs.Get(ctx, id)
+		if err != nil {
+			return false, 0, err
+		}
+		nodes, err := graph.kg.NumberOfNodes(ctx)
+		if err != nil {
+			return false, 0, err
+		}
+		used += nodes
+	}
+	return used >= limit, limit, nil
+}
+
+// withinQuota answers 429 to a tenant over its quota instead of adding nodes.
+func (s *server) withinQuota(handle graphHandler) graphHandler {
+	return func(w http.ResponseWriter, r *http.Request, graph *Graph) {
+		tenant := r.Header.Get(rbac.TenantHeader)
+		over, limit, err := s.overQuota(r.Context(), tenant)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if over {
+			writeError(w, http.StatusTooManyRequests, fmt.Sprintf("Tenant '%s' is over its quota of %d nodes", tenant, limit))
+			return
+		}
+		handle(w, r, graph)
+	}
+}
+
+// wholeService refuses a token bound to one tenant, since a backup holds
+// every tenant's graphs.
+func (s *server) wholeService(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(rbac.TenantHeader) != rbac.DefaultTenant {
+			writeError(w, http.StatusForbidden, "Backups hold every tenant's data")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError uses FastAPI's error body, {"detail": ...}.
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]any{"detail": detail})
+}
+
+// decodeBody reads a JSON request body, answering 422 like FastAPI when it
+// is malformed or a required field is missing.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any, required ...string) bool {
+	var fields map[string]json.RawMessage
+	raw := json.NewDecoder(r.Body)
+	if err := raw.Decode(&fields); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid JSON body: "+err.Error())
+		return false
+	}
+	for _, name := range required {
+		if _, ok := fields[name]; !ok {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("field required: %s", name))
+			return false
+		}
+	}
+	encoded, _ := json.Marshal(fields)
+	if err := json.Unmarshal(encoded, v); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return false
+	}
+	return true
+}
+
+// intParam reads an integer query parameter, answering 422 like FastAPI
+// when it is not one.
+func intParam(w http.ResponseWriter, r *http.Request, name string, fallback int) (int, bool) {
+	params := r.URL.Query()
+	if !params.Has(name) {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(params.Get(name))
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, name+" must be an integer")
+		return 0, false
+	}
+	return n, true
+}
+
+// boolParam reads a boolean query parameter, answering 422 when it is not
+// one.
+func boolParam(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	params := r.URL.Query()
+	if !params.Has(name) {
+		return false, true
+	}
+	b, err := strconv.ParseBool(params.Get(name))
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, name+" must be a boolean")
+		return false, false
+	}
+	return b, true
+}
+
+// writeSchemaError answers 422 with the ways err broke the schema, if it is
+// a schemaError.
+func writeSchemaError(w http.ResponseWriter, err error) bool {
+	var invalid schemaError
+	if !errors.As(err, &invalid) {
+		return false
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"detail": map[string]any{"errors": invalid.errors}})
+	return true
+}
+
+func (s *server) health(w http.ResponseWriter, r *http.Request) {
+	var configVersion any
+	if s.remote != nil {
+		configVersion = s.remote.Version()
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "healthy", "backend": s.backend, "config_version": configVersion})
+}
+
+func (s *server) listGraphs(w http.ResponseWriter, r *http.Request) {
+	tenant := r.Header.Get(rbac.TenantHeader)
+	if _, err := s.graphs.TenantGraph(r.Context(), tenant, defaultGraph); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	listing := []map[string]any{}
+	for _, id := range s.graphs.IDs(tenant) {
+		graph, err := s.graphs.Get(r.Context(), id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		stats, err := graph.kg.Stats(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		info, err := s.graphs.Info(id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		info["nodes"], info["edges"] = stats["nodes"], stats["edges"]
+		listing = append(listing, info)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"graphs": listing})
+}
+
+func (s *server) createGraph(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		GraphID     string  `json:"graph_id"`
+		Description *string `json:"description"`
+	}
+	if !decodeBody(w, r, &request, "graph_id") {
+		return
+	}
+	var description any
+	if request.Description != nil {
+		description = *request.Description
+	}
+	graph, err := s.graphs.Create(r.Context(), request.GraphID, description, r.Header.Get(rbac.TenantHeader))
+	switch {
+	case errors.Is(err, errGraphExists):
+		writeError(w, http.StatusConflict, "Graph already exists: "+request.GraphID)
+		return
+	case errors.Is(err, errInvalidGraph):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	info, err := s.graphs.Info(graph.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (s *server) graphInfo(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	stats, err := graph.kg.Stats(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	info, err := s.graphs.Info(graph.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	info["stats"] = stats
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *server) dropGraph(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	dropped, err := s.graphs.Drop(r.Context(), graph.ID)
+	if errors.Is(err, errInvalidGraph) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, dropped)
+}
+
+func (s *server) addNode(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	var request struct {
+		Data      json.RawMessage `json:"data"`
+		ValidFrom string          `json:"valid_from"`
+		ValidTo   string          `json:"valid_to"`
+	}
+	if !decodeBody(w, r, &request, "data") {
+		return
+	}
+	if !strings.HasPrefix(strings.TrimSpace(string(request.Data)), "{") {
+		writeError(w, http.StatusUnprocessableEntity, "data must be an object")
+		return
+	}
+	nodeID, err := graph.kg.AddContextNode(r.Context(), request.Data, request.ValidFrom, request.ValidTo)
+	var quarantined quarantinedError
+	if errors.As(err, &quarantined) {
+		writeJSON(w, http.StatusAccepted, map[string]any{"quarantined": quarantined.ID, "errors": quarantined.errors})
+		return
+	} else if writeSchemaError(w, err) {
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data, _ := decodeJSON(request.Data)
+	writeJSON(w, http.StatusOK, map[string]any{"node_id": nodeID, "merged": nodeID != generateNodeID(data)})
+}
+
+func (s *server) quarantined(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	entries, err := graph.kg.Quarantined()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"quarantined": entries})
+}
+
+// releaseQuarantined ingests a quarantined payload, answering 422 while it
+// still breaks the schema unless force is set.
+func (s *server) releaseQuarantined(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	force, ok := boolParam(w, r, "force")
+	if !ok {
+		return
+	}
+	nodeID, err := graph.kg.ReleaseQuarantined(r.Context(), r.PathValue("entry_id"), force)
+	if errors.Is(err, errQuarantineNotFound) {
+		writeError(w, http.StatusNotFound, "Quarantine entry not found")
+		return
+	} else if writeSchemaError(w, err) {
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"node_id": nodeID})
+}
+
+func (s *server) discardQuarantined(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	entryID := r.PathValue("entry_id")
+	err := graph.kg.DiscardQuarantined(entryID)
+	if errors.Is(err, errQuarantineNotFound) {
+		writeError(w, http.StatusNotFound, "Quarantine entry not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"discarded": entryID})
+}
+
+// subjectFields are the session_id and user_id given, answering 400 when
+// neither is.
+func subjectFields(w http.ResponseWriter, sessionID, userID *string) ([]subjectField, bool) {
+	var wanted []subjectField
+	if sessionID != nil {
+		wanted = append(wanted, subjectField{"session_id", *sessionID})
+	}
+	if userID != nil {
+		wanted = append(wanted, subjectField{"user_id", *userID})
+	}
+	if len(wanted) == 0 {
+		writeError(w, http.StatusBadRequest, "A session_id or a user_id is required")
+		return nil, false
+	}
+	return wanted, true
+}
+
+func (s *server) subjectNodes(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	params := r.URL.Query()
+	var sessionID, userID *string
+	if session := params.Get("session_id"); params.Has("session_id") {
+		sessionID = &session
+	}
+	if user := params.Get("user_id"); params.Has("user_id") {
+		userID = &user
+	}
+	wanted, ok := subjectFields(w, sessionID, userID)
+	if !ok {
+		return
+	}
+	nodes, err := graph.kg.SubjectNodes(r.Context(), wanted)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"nodes": nodes})
+}
+
+func (s *server) purge(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	var request struct {
+		SessionID *string `json:"session_id"`
+		UserID    *string `json:"user_id"`
+	}
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	wanted, ok := subjectFields(w, request.SessionID, request.UserID)
+	if !ok {
+		return
+	}
+	purged, err := graph.kg.Purge(r.Context(), wanted)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, purged)
+}
+
+func (s *server) getNode(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	node, err := graph.kg.GetNode(r.Context(), r.PathValue("node_id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if node == nil {
+		writeError(w, http.StatusNotFound, "Node not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, node)
+}
+
+// addEpisode takes Graphiti episodes, which only the Python service can
+// ingest.
+func (s *server) addEpisode(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	var request struct {
+		Name              string  `json:"name"`
+		Body              any     `json:"body"`
+		Source            string  `json:"source"`
+		SourceDescription string  `json:"source_description"`
+		ReferenceTime     *string `json:"reference_time"`
+	}
+	if !decodeBody(w, r, &request, "name", "body") {
+		return
+	}
+	writeError(w, http.StatusNotImplemented, graphitiUnavailable)
+}
+
+func (s *server) addEdge(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	request := struct {
+		Source           string  `json:"source"`
+		Target           string  `json:"target"`
+		RelationshipType string  `json:"relationship_type"`
+		Weight           float64 `json:"weight"`
+		Attributes       Attrs   `json:"attributes"`
+		ValidFrom        string  `json:"valid_from"`
+		ValidTo          string  `json:"valid_to"`
+	}{Weight: 1.0}
+	if !decodeBody(w, r, &request, "source", "target", "relationship_type") {
+		return
+	}
+	err := graph.kg.AddEdge(r.Context(), request.Source, request.Target, request.RelationshipType,
+		request.Weight, request.ValidFrom, request.ValidTo, request.Attributes)
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, errUnknownRelationship):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case writeSchemaError(w, err):
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"source": request.Source, "target": request.Target,
+		"relationship_type": request.RelationshipType})
+}
+
+// validTo echoes the invalidation time the way the Python service does.
+func validTo(at string) string {
+	if at == "" {
+		return "now"
+	}
+	return at
+}
+
+func (s *server) invalidateNode(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	var request struct {
+		At string `json:"at"`
+	}
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	nodeID := r.PathValue("node_id")
+	err := graph.kg.InvalidateNode(r.Context(), nodeID, request.At)
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "Node not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"node_id": nodeID, "valid_to": validTo(request.At)})
+}
+
+func (s *server) invalidateEdge(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	var request struct {
+		Source string `json:"source"`
+		Target string `json:"target"`
+		At     string `json:"at"`
+	}
+	if !decodeBody(w, r, &request, "source", "target") {
+		return
+	}
+	err := graph.kg.InvalidateEdge(r.Context(), request.Source, request.Target, request.At)
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "Edge not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"source": request.Source, "target": request.Target,
+		"valid_to": validTo(request.At)})
+}
+
+var searchModes = []string{"hybrid", "vector", "keyword", "graphiti"}
+
+// graphitiUnavailable answers Graphiti requests: Graphiti needs the Python
+// service, and the Go one refuses configs that enable it.
+const graphitiUnavailable = "Graphiti is not enabled in the graph config"
+
+func (s *server) search(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	params := r.URL.Query()
+	query := params.Get("q")
+	if !params.Has("q") {
+		writeError(w, http.StatusUnprocessableEntity, "field required: q")
+		return
+	}
+	limit := 10
+	if params.Has("limit") {
+		n, err := strconv.Atoi(params.Get("limit"))
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "limit must be an integer")
+			return
+		}
+		if n < 1 {
+			writeError(w, http.StatusUnprocessableEntity, "limit must be at least 1")
+			return
+		}
+		limit = n
+	}
+	mode := params.Get("mode")
+	if mode == "" {
+		mode = "hybrid"
+	}
+	if !contains(searchModes, mode) {
+		writeError(w, http.StatusBadRequest, "mode must be one of "+strings.Join(searchModes, ", "))
+		return
+	}
+	// With the hybrid_search flag off for the tenant, hybrid searches are
+	// served as vector ones, which the answer's mode says
+	if mode == "hybrid" && !s.remote.Enabled("hybrid_search", r.Header.Get(rbac.TenantHeader), true) {
+		mode = "vector"
+	}
+	asOf, validAt, err := visibilityTimes(params.Get("as_of"), params.Get("valid_at"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var results []searchResult
+	switch mode {
+	case "hybrid":
+		results, err = graph.kg.SearchHybrid(r.Context(), query, limit, asOf, validAt)
+	case "vector":
+		results, err = graph.kg.SearchSemantic(r.Context(), query, limit, asOf, validAt)
+	case "graphiti":
+		writeError(w, http.StatusNotImplemented, graphitiUnavailable)
+		return
+	default:
+		results, err = graph.kg.SearchKeyword(r.Context(), query, limit)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var echoAsOf any
+	if params.Has("as_of") {
+		echoAsOf = params.Get("as_of")
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"query": query, "mode": mode, "as_of": echoAsOf, "results": results})
+}
+
+func (s *server) stats(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	stats, err := graph.kg.Stats(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *server) graphConfig(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	writeJSON(w, http.StatusOK, graph.kg.Config().Raw)
+}
+
+func (s *server) entities(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	entities, err := graph.kg.Entities(r.Context(), r.URL.Query().Get("type"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entities": entities})
+}
+
+func (s *server) refreshImportance(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	refreshed, err := graph.kg.RefreshImportance(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, refreshed)
+}
+
+func (s *server) importantNodes(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	limit, ok := intParam(w, r, "limit", 10)
+	if !ok {
+		return
+	}
+	nodes, err := graph.kg.ImportantNodes(r.Context(), limit, r.URL.Query().Get("node_type"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"nodes": nodes})
+}
+
+func (s *server) detectCommunities(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	request := struct {
+		Method     string  `json:"method"`
+		Resolution float64 `json:"resolution"`
+	}{Method: "louvain", Resolution: 1.0}
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	detected, err := graph.kg.DetectCommunities(r.Context(), request.Method, request.Resolution)
+	if errors.Is(err, errUnknownCommunityMethod) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, detected)
+}
+
+func (s *server) communities(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	communities, err := graph.kg.Communities(r.Context(), r.URL.Query().Get("name"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"communities": communities})
+}
+
+func (s *server) community(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	communityID := r.PathValue("community_id")
+	members, err := graph.kg.Community(r.Context(), communityID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(members) == 0 {
+		writeError(w, http.StatusNotFound, "Community not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"community_id": communityID, "members": members})
+}
+
+func (s *server) runDecay(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	var request struct {
+		At string `json:"at"`
+	}
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	if request.At != "" {
+		if _, err := parseTime(request.At); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	report, err := graph.kg.Decay(r.Context(), request.At)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s *server) decayStats(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	stats, err := graph.kg.DecayStats(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *server) tombstones(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	tombstones, err := graph.kg.Tombstones(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tombstones": tombstones})
+}
+
+func (s *server) dedup(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	merged, err := graph.kg.Deduplicate(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, merged)
+}
+
+func (s *server) query(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	request := struct {
+		Query   string `json:"query"`
+		Limit   int    `json:"limit"`
+		AsOf    string `json:"as_of"`
+		ValidAt string `json:"valid_at"`
+	}{Limit: 100}
+	if !decodeBody(w, r, &request, "query") {
+		return
+	}
+	asOf, validAt, err := visibilityTimes(request.AsOf, request.ValidAt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := graph.kg.Query(r.Context(), request.Query, request.Limit, asOf, validAt)
+	if errors.Is(err, errQuerySyntax) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"query": request.Query, "rows": rows})
+}
+
+// diff compares snapshot:NAME, an ISO-8601 time or "current" against another.
+func (s *server) diff(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	params := r.URL.Query()
+	if !params.Has("from") {
+		writeError(w, http.StatusUnprocessableEntity, "field required: from")
+		return
+	}
+	report, err := graph.kg.Diff(r.Context(), params.Get("from"), cmp.Or(params.Get("to"), "current"))
+	switch {
+	case errors.Is(err, errSnapshotNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errInvalidSnapshotName), errors.Is(err, errInvalidTimestamp):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func (s *server) createSnapshot(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	var request struct {
+		Name      string  `json:"name"`
+		Note      *string `json:"note"`
+		Overwrite bool    `json:"overwrite"`
+	}
+	if !decodeBody(w, r, &request, "name") {
+		return
+	}
+	meta, err := graph.kg.Snapshot(r.Context(), request.Name, request.Note, request.Overwrite)
+	switch {
+	case errors.Is(err, errSnapshotExists):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errInvalidSnapshotName):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, meta)
+	}
+}
+
+func (s *server) snapshots(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	snapshots, err := graph.kg.Snapshots()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"snapshots": snapshots})
+}
+
+// restoreSnapshot rolls the graph back to a snapshot; the body is optional.
+func (s *server) restoreSnapshot(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	request := struct {
+		Backup bool `json:"backup"`
+	}{Backup: true}
+	if r.ContentLength != 0 && !decodeBody(w, r, &request) {
+		return
+	}
+	report, err := graph.kg.RestoreSnapshot(r.Context(), r.PathValue("name"), request.Backup)
+	switch {
+	case errors.Is(err, errSnapshotNotFound):
+		writeError(w, http.StatusNotFound, "Snapshot not found")
+	case errors.Is(err, errInvalidSnapshotName):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func (s *server) deleteSnapshot(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	name := r.PathValue("name")
+	err := graph.kg.DeleteSnapshot(name)
+	switch {
+	case errors.Is(err, errSnapshotNotFound):
+		writeError(w, http.StatusNotFound, "Snapshot not found")
+	case errors.Is(err, errInvalidSnapshotName):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"deleted": name})
+	}
+}
+
+func (s *server) viewer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(viewerHTML)
+}
+
+// view is the graph view the node_type, since, until and limit parameters
+// select, or false once an error is answered.
+func (s *server) view(w http.ResponseWriter, r *http.Request, graph *Graph) (graphView, bool) {
+	params := r.URL.Query()
+	limit, ok := intParam(w, r, "limit", 500)
+	if !ok {
+		return graphView{}, false
+	}
+	var nodeTypes []string
+	for _, kind := range strings.Split(params.Get("node_type"), ",") {
+		if kind != "" {
+			nodeTypes = append(nodeTypes, kind)
+		}
+	}
+	view, err := graph.kg.View(r.Context(), nodeTypes, params.Get("since"), params.Get("until"), limit)
+	if errors.Is(err, errInvalidTimestamp) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return graphView{}, false
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return graphView{}, false
+	}
+	return view, true
+}
+
+func (s *server) vizGraph(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	if view, ok := s.view(w, r, graph); ok {
+		writeJSON(w, http.StatusOK, view)
+	}
+}
+
+func (s *server) exportDOT(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	if view, ok := s.view(w, r, graph); ok {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		io.WriteString(w, view.dot())
+	}
+}
+
+func (s *server) exportRDF(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	params := r.URL.Query()
+	format := cmp.Or(params.Get("format"), "jsonld")
+	includeEmbeddings, ok := boolParam(w, r, "include_embeddings")
+	if !ok {
+		return
+	}
+	document, err := graph.kg.ExportRDF(r.Context(), format, includeEmbeddings)
+	if errors.Is(err, errUnsupportedRDFFormat) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if format == "jsonld" {
+		w.Header().Set("Content-Type", "application/ld+json")
+	} else {
+		w.Header().Set("Content-Type", "application/n-triples")
+	}
+	io.WriteString(w, document)
+}
+
+func (s *server) ontology(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/turtle; charset=utf-8")
+	io.WriteString(w, ontologyTTL)
+}
+
+func (s *server) exportGraphML(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	document, err := graph.kg.GraphML(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="knowledge-graph.graphml"`)
+	w.Write(document)
+}
+
+// pathRequest names a graph file on the server and its format.
+type pathRequest struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+}
+
+func (s *server) exportGraph(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	var request pathRequest
+	if !decodeBody(w, r, &request, "path") {
+		return
+	}
+	summary, err := graph.kg.Export(r.Context(), request.Path, request.Format)
+	if errors.Is(err, errUnsupportedGraphFormat) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (s *server) importGraph(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	var request pathRequest
+	if !decodeBody(w, r, &request, "path") {
+		return
+	}
+	summary, err := graph.kg.Import(r.Context(), request.Path, request.Format)
+	switch {
+	case errors.Is(err, errUnsupportedGraphFormat):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+// ingest queues an NDJSON body of node requests, answering 429 when the
+// queue is full.
+func (s *server) ingest(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	job, err := graph.ingest.Submit(parseNDJSON(body))
+	var backpressure backpressureError
+	if errors.As(err, &backpressure) {
+		w.Header().Set("Retry-After", strconv.Itoa(backpressure.retryAfter))
+		writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *server) ingestStatus(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	job, ok := graph.ingest.Job(r.PathValue("job_id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "ingest job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *server) startReembed(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	job, err := graph.StartReembed()
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job.Status())
+}
+
+func (s *server) reembedStatus(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	job, err := graph.Reembed()
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, job.Status())
+}
+
+func (s *server) cancelReembed(w http.ResponseWriter, r *http.Request, graph *Graph) {
+	job, err := graph.CancelReembed()
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, job.Status())
+}
+
+func (s *server) backup(w http.ResponseWriter, r *http.Request) {
+	var body bytes.Buffer
+	summary, err := s.graphs.Backup(r.Context(), &body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Backup-Graphs", strconv.Itoa(summary.Graphs))
+	w.Header().Set("X-Backup-Nodes", strconv.Itoa(summary.Nodes))
+	w.Header().Set("X-Backup-Edges", strconv.Itoa(summary.Edges))
+	w.Write(body.Bytes())
+}
+
+func (s *server) restore(w http.ResponseWriter, r *http.Request) {
+	summary, err := s.graphs.Restore(r.Context(), r.Body)
+	if errors.Is(err, errInvalidBackup) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
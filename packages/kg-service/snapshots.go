@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Named snapshots for point-in-time rollback, as in the Python
+// graph_snapshots: a graph file of every node, embeddings included, and
+// edge, plus a small metadata file, kept under KG_SNAPSHOT_DIR.
+
+var (
+	errInvalidSnapshotName = errors.New("invalid snapshot name")
+	errSnapshotExists      = errors.New("snapshot already exists")
+	errSnapshotNotFound    = errors.New("snapshot not found")
+)
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// snapshotDir is where a graph's snapshots are kept; named graphs get a
+// directory of their own under the default graph's.
+func snapshotDir(graphID string) string {
+	base := getenv("KG_SNAPSHOT_DIR", "/data/snapshots")
+	if graphID == defaultGraph {
+		return base
+	}
+	return filepath.Join(base, "graphs", graphID)
+}
+
+// snapshotMeta is a snapshot's metadata file.
+type snapshotMeta struct {
+	Name      string  `json:"name"`
+	CreatedAt string  `json:"created_at"`
+	Nodes     int     `json:"nodes"`
+	Edges     int     `json:"edges"`
+	Note      *string `json:"note"`
+}
+
+// snapshotPaths are the graph and metadata files of the snapshot name.
+func (kg *KnowledgeGraph) snapshotPaths(name string) (string, string, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return "", "", fmt.Errorf("%w: %q", errInvalidSnapshotName, name)
+	}
+	base := filepath.Join(kg.snapshotDir, name)
+	return base + ".jsonl", base + ".json", nil
+}
+
+// snapshot captures every node and edge under name. The caller holds kg.mu.
+func (kg *KnowledgeGraph) snapshot(ctx context.Context, name string, note *string, overwrite bool) (snapshotMeta, error) {
+	graphPath, metaPath, err := kg.snapshotPaths(name)
+	if err != nil {
+		return snapshotMeta{}, err
+	}
+	if _, err := os.Stat(metaPath); err == nil && !overwrite {
+		return snapshotMeta{}, fmt.Errorf("%w: %s", errSnapshotExists, name)
+	}
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return snapshotMeta{}, err
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return snapshotMeta{}, err
+	}
+	if err := writeGraphFile(graphPath, "jsonl", nodes, edges); err != nil {
+		return snapshotMeta{}, err
+	}
+	meta := snapshotMeta{Name: name, CreatedAt: now(), Nodes: len(nodes), Edges: len(edges), Note: note}
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return snapshotMeta{}, err
+	}
+	return meta, writeFileAtomic(metaPath, encoded)
+}
+
+// Snapshot captures the whole graph as the snapshot name, replacing one of
+// that name only if overwrite is set.
+func (kg *KnowledgeGraph) Snapshot(ctx context.Context, name string, note *string, overwrite bool) (snapshotMeta, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	return kg.snapshot(ctx, name, note, overwrite)
+}
+
+// Snapshots lists the graph's snapshots, newest first.
+func (kg *KnowledgeGraph) Snapshots() ([]snapshotMeta, error) {
+	entries, err := os.ReadDir(kg.snapshotDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []snapshotMeta{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	snapshots := []snapshotMeta{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		encoded, err := os.ReadFile(filepath.Join(kg.snapshotDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var meta snapshotMeta
+		if err := json.Unmarshal(encoded, &meta); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		snapshots = append(snapshots, meta)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt > snapshots[j].CreatedAt })
+	return snapshots, nil
+}
+
+// loadSnapshot reads the records a snapshot captured.
+func (kg *KnowledgeGraph) loadSnapshot(name string) ([]graphRecord, error) {
+	graphPath, metaPath, err := kg.snapshotPaths(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(metaPath); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", errSnapshotNotFound, name)
+	}
+	return readGraphFile(graphPath, "jsonl")
+}
+
+// DeleteSnapshot removes the snapshot name.
+func (kg *KnowledgeGraph) DeleteSnapshot(name string) error {
+	graphPath, metaPath, err := kg.snapshotPaths(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(metaPath); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", errSnapshotNotFound, name)
+	}
+	for _, path := range []string{graphPath, metaPath} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreSnapshot replaces the graph with the snapshot name, so nothing
+// ingested after it survives. With backup set the current graph is first
+// saved as a pre-restore snapshot.
+func (kg *KnowledgeGraph) RestoreSnapshot(ctx context.Context, name string, backup bool) (map[string]any, error) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	records, err := kg.loadSnapshot(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var backupName any
+	if backup {
+		stamp := strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, now())[:14]
+		note := "Automatic backup before restoring " + name
+		meta, err := kg.snapshot(ctx, "pre-restore-"+stamp, &note, true)
+		if err != nil {
+			return nil, err
+		}
+		backupName = meta.Name
+	}
+
+	removed, err := kg.clear(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes, edges, err := kg.load(ctx, records)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"name": name, "removed": removed, "nodes": nodes, "edges": edges, "backup": backupName}, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Attrs are the attributes of a node or edge, as stored.
+type Attrs map[string]any
+
+// Edge is a directed relationship between two nodes.
+type Edge struct {
+	Source string
+	Target string
+	Attrs  Attrs
+}
+
+// Store is the graph storage backend. AddNode and AddEdge merge attrs into
+// any existing node or edge, like SET n += $props.
+type Store interface {
+	AddNode(ctx context.Context, id string, attrs Attrs) error
+	GetNode(ctx context.Context, id string) (Attrs, error)
+	Nodes(ctx context.Context) (map[string]Attrs, error)
+	NumberOfNodes(ctx context.Context) (int, error)
+	RemoveNode(ctx context.Context, id string) error
+	AddEdge(ctx context.Context, source, target string, attrs Attrs) error
+	GetEdge(ctx context.Context, source, target string) (Attrs, error)
+	Edges(ctx context.Context) ([]Edge, error)
+	Close() error
+}
+
+// memoryStore keeps the graph in memory, optionally loading it from a JSON
+// Lines file in the Python graph_io format and saving it there after each
+// write.
+type memoryStore struct {
+	mu    sync.RWMutex
+	path  string
+	nodes map[string]Attrs
+	edges map[[2]string]Attrs
+	order [][2]string
+}
+
+func newMemoryStore(path string) (*memoryStore, error) {
+	s := &memoryStore{path: path, nodes: map[string]Attrs{}, edges: map[[2]string]Attrs{}}
+	if path == "" {
+		return s, nil
+	}
+	if err := s.load(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading %s: %w", path, err)
+	}
+	return s, nil
+}
+
+type graphRecord struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id,omitempty"`
+	Source string `json:"source,omitempty"`
+	Target string `json:"target,omitempty"`
+	Attrs  Attrs  `json:"attrs"`
+}
+
+func (s *memoryStore) load() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record graphRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return err
+		}
+		if record.Kind == "node" {
+			s.nodes[record.ID] = record.Attrs
+		} else {
+			s.putEdge(record.Source, record.Target, record.Attrs)
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *memoryStore) putEdge(source, target string, attrs Attrs) {
+	key := [2]string{source, target}
+	if existing, ok := s.edges[key]; ok {
+		for k, v := range attrs {
+			existing[k] = v
+		}
+		return
+	}
+	s.edges[key] = attrs
+	s.order = append(s.order, key)
+}
+
+func copyAttrs(attrs Attrs) Attrs {
+	out := make(Attrs, len(attrs))
+	for k, v := range attrs {
+		out[k] = v
+	}
+	return out
+}
+
+func (s *memoryStore) AddNode(_ context.Context, id string, attrs Attrs) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, ok := s.nodes[id]
+	if !ok {
+		node = Attrs{}
+		s.nodes[id] = node
+	}
+	for k, v := range attrs {
+		node[k] = v
+	}
+	return s.save()
+}
+
+func (s *memoryStore) GetNode(_ context.Context, id string) (Attrs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	node, ok := s.nodes[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyAttrs(node), nil
+}
+
+func (s *memoryStore) Nodes(_ context.Context) (map[string]Attrs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]Attrs, len(s.nodes))
+	for id, attrs := range s.nodes {
+		out[id] = copyAttrs(attrs)
+	}
+	return out, nil
+}
+
+func (s *memoryStore) NumberOfNodes(_ context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.nodes), nil
+}
+
+func (s *memoryStore) RemoveNode(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nodes, id)
+	kept := s.order[:0]
+	for _, key := range s.order {
+		if key[0] == id || key[1] == id {
+			delete(s.edges, key)
+			continue
+		}
+		kept = append(kept, key)
+	}
+	s.order = kept
+	return s.save()
+}
+
+func (s *memoryStore) AddEdge(_ context.Context, source, target string, attrs Attrs) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like networkx, adding an edge creates missing endpoints
+	for _, id := range []string{source, target} {
+		if _, ok := s.nodes[id]; !ok {
+			s.nodes[id] = Attrs{}
+		}
+	}
+	s.putEdge(source, target, copyAttrs(attrs))
+	return s.save()
+}
+
+func (s *memoryStore) GetEdge(_ context.Context, source, target string) (Attrs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	edge, ok := s.edges[[2]string{source, target}]
+	if !ok {
+		return nil, nil
+	}
+	return copyAttrs(edge), nil
+}
+
+func (s *memoryStore) Edges(_ context.Context) ([]Edge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Edge, 0, len(s.order))
+	for _, key := range s.order {
+		out = append(out, Edge{Source: key[0], Target: key[1], Attrs: copyAttrs(s.edges[key])})
+	}
+	return out, nil
+}
+
+// Close saves the graph once more, in case its last save failed.
+func (s *memoryStore) Close() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.save()
+}
+
+// save writes the graph to a temporary file beside the graph file, which it
+// replaces once the graph is on disk, so a failed save leaves the last one.
+// The caller holds s.mu.
+func (s *memoryStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".graph-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	// Readable as the file os.Create made before it
+	if err := f.Chmod(0o644); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for id, attrs := range s.nodes {
+		if err := enc.Encode(graphRecord{Kind: "node", ID: id, Attrs: attrs}); err != nil {
+			return err
+		}
+	}
+	for _, key := range s.order {
+		if err := enc.Encode(graphRecord{Kind: "edge", Source: key[0], Target: key[1], Attrs: s.edges[key]}); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errInvalidTimestamp = errors.New("invalid timestamp")
+
+// Timestamps are stored as ISO-8601 strings in the same format as the
+// Python service, so either can read graphs the other wrote.
+const isoLayout = "2006-01-02T15:04:05.000000-07:00"
+
+func now() string {
+	return time.Now().UTC().Format(isoLayout)
+}
+
+var parseLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseTime parses an ISO-8601 timestamp, treating naive values as UTC.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range parseLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", errInvalidTimestamp, value)
+}
+
+// attrTime reads an optional timestamp attribute.
+func attrTime(attrs Attrs, key string) (time.Time, bool) {
+	value, ok := attrs[key].(string)
+	if !ok || value == "" {
+		return time.Time{}, false
+	}
+	t, err := parseTime(value)
+	return t, err == nil
+}
+
+// known reports whether the graph had recorded, and not yet retracted,
+// attrs at asOf.
+func known(attrs Attrs, asOf time.Time) bool {
+	if recorded, ok := attrTime(attrs, "timestamp"); ok && recorded.After(asOf) {
+		return false
+	}
+	if retracted, ok := attrTime(attrs, "invalidated_at"); ok && !retracted.After(asOf) {
+		return false
+	}
+	return true
+}
+
+// visible reports whether attrs were known at asOf and valid at validAt.
+func visible(attrs Attrs, asOf, validAt time.Time) bool {
+	if !known(attrs, asOf) {
+		return false
+	}
+	if from, ok := attrTime(attrs, "valid_from"); ok && from.After(validAt) {
+		return false
+	}
+	if to, ok := attrTime(attrs, "valid_to"); ok && !validAt.Before(to) {
+		return false
+	}
+	return true
+}
+
+// visibilityTimes resolves the as_of/valid_at query parameters; both
+// default to now, and valid_at defaults to as_of.
+func visibilityTimes(asOf, validAt string) (time.Time, time.Time, error) {
+	known := time.Now().UTC()
+	if asOf != "" {
+		t, err := parseTime(asOf)
+		if err != nil {
+			return known, known, err
+		}
+		known = t
+	}
+	valid := known
+	if validAt != "" {
+		t, err := parseTime(validAt)
+		if err != nil {
+			return known, valid, err
+		}
+		valid = t
+	}
+	return known, valid, nil
+}
+
+// filterGraph is the part of a graph visible at the given times: the
+// visible nodes, and the visible edges between them.
+func filterGraph(nodes map[string]Attrs, edges []Edge, asOf, validAt time.Time) (map[string]Attrs, []Edge) {
+	shown := make(map[string]Attrs, len(nodes))
+	for id, attrs := range nodes {
+		if visible(attrs, asOf, validAt) {
+			shown[id] = attrs
+		}
+	}
+	var shownEdges []Edge
+	for _, edge := range edges {
+		_, source := shown[edge.Source]
+		_, target := shown[edge.Target]
+		if source && target && visible(edge.Attrs, asOf, validAt) {
+			shownEdges = append(shownEdges, edge)
+		}
+	}
+	return shown, shownEdges
+}
+
+// isoformat renders t like Python's datetime.isoformat, with microseconds
+// only when it has some.
+func isoformat(t time.Time) string {
+	if t.Nanosecond()/1000 == 0 {
+		return t.Format("2006-01-02T15:04:05-07:00")
+	}
+	return t.Format(isoLayout)
+}
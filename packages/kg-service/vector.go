@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Hit is a nearest-neighbour match with its cosine similarity.
+type Hit struct {
+	NodeID string
+	Score  float64
+}
+
+// VectorIndex finds nodes by embedding similarity.
+type VectorIndex interface {
+	Name() string
+	Upsert(ctx context.Context, nodeID string, vector []float64) error
+	Delete(ctx context.Context, nodeID string) error
+	// Search returns hits scoring above threshold, best first.
+	Search(ctx context.Context, vector []float64, limit int, threshold float64) ([]Hit, error)
+	Close() error
+
+	// Re-embedding builds a shadow index next to the live one, then promotes
+	// it in a single cutover; see reembed.go.
+
+	// Shadow is an empty index for vectors of another model, alongside this one.
+	Shadow(ctx context.Context, name string, dimension int) (VectorIndex, error)
+	// Promote replaces this index with shadow and returns the index to use
+	// from now on.
+	Promote(ctx context.Context, shadow VectorIndex) (VectorIndex, error)
+	// Drop discards an abandoned shadow index.
+	Drop(ctx context.Context) error
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// floats converts a decoded JSON array into a vector.
+func floats(v any) []float64 {
+	switch v := v.(type) {
+	case []float64:
+		return v
+	case []any:
+		out := make([]float64, 0, len(v))
+		for _, x := range v {
+			switch x := x.(type) {
+			case float64:
+				out = append(out, x)
+			case json.Number:
+				f, _ := x.Float64()
+				out = append(out, f)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// scanIndex does exact search over the embeddings held on the store, in
+// the node attribute named.
+type scanIndex struct {
+	store     Store
+	attribute string
+}
+
+func (s *scanIndex) Name() string { return "scan" }
+
+func (s *scanIndex) Upsert(context.Context, string, []float64) error {
+	// Embeddings already live on the node attributes
+	return nil
+}
+
+func (s *scanIndex) Delete(context.Context, string) error { return nil }
+
+func (s *scanIndex) Close() error { return nil }
+
+func (s *scanIndex) Shadow(context.Context, string, int) (VectorIndex, error) {
+	// Shadow vectors are kept on the nodes under embedding_next
+	return &scanIndex{store: s.store, attribute: "embedding_next"}, nil
+}
+
+func (s *scanIndex) Promote(context.Context, VectorIndex) (VectorIndex, error) {
+	// The cutover moves embedding_next into embedding on every node
+	return &scanIndex{store: s.store, attribute: "embedding"}, nil
+}
+
+func (s *scanIndex) Drop(context.Context) error { return nil }
+
+func (s *scanIndex) Search(ctx context.Context, vector []float64, limit int, threshold float64) ([]Hit, error) {
+	nodes, err := s.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var hits []Hit
+	for id, attrs := range nodes {
+		embedding := floats(attrs[s.attribute])
+		if len(embedding) == 0 {
+			continue
+		}
+		if score := cosine(vector, embedding); score > threshold {
+			hits = append(hits, Hit{NodeID: id, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > max(limit, 0) {
+		hits = hits[:max(limit, 0)]
+	}
+	return hits, nil
+}
+
+// qdrantIndex uses Qdrant's REST API with the same point IDs and payload as
+// the Python QdrantIndex, so both services can share a collection.
+type qdrantIndex struct {
+	url        string
+	collection string
+	client     *http.Client
+}
+
+func newQdrantIndex(ctx context.Context, url, collection string, dimension int) (*qdrantIndex, error) {
+	q := &qdrantIndex{
+		url:        strings.TrimRight(url, "/"),
+		collection: collection,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+	status, err := q.call(ctx, http.MethodGet, "/collections/"+collection, nil, nil)
+	if err != nil && status != http.StatusNotFound {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		// After a re-embedding cutover the collection name is an alias
+		aliases, err := q.aliases(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := aliases[collection]; ok {
+			return q, nil
+		}
+		create := map[string]any{"vectors": map[string]any{"size": dimension, "distance": "Cosine"}}
+		if _, err := q.call(ctx, http.MethodPut, "/collections/"+collection, create, nil); err != nil {
+			return nil, fmt.Errorf("creating collection %s: %w", collection, err)
+		}
+	}
+	return q, nil
+}
+
+func (q *qdrantIndex) Name() string { return "qdrant" }
+
+func (q *qdrantIndex) call(ctx context.Context, method, path string, body, out any) (int, error) {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, q.url+path, &payload)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("qdrant %s %s returned HTTP %d", method, path, resp.StatusCode)
+	}
+	if out != nil {
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode, nil
+}
+
+// aliases are the collection each alias names, by alias.
+func (q *qdrantIndex) aliases(ctx context.Context) (map[string]string, error) {
+	var resp struct {
+		Result struct {
+			Aliases []struct {
+				Alias      string `json:"alias_name"`
+				Collection string `json:"collection_name"`
+			} `json:"aliases"`
+		} `json:"result"`
+	}
+	if _, err := q.call(ctx, http.MethodGet, "/aliases", nil, &resp); err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string, len(resp.Result.Aliases))
+	for _, alias := range resp.Result.Aliases {
+		aliases[alias.Alias] = alias.Collection
+	}
+	return aliases, nil
+}
+
+// pointID is uuid5(NAMESPACE_URL, "context-node:<id>"); Qdrant only
+// accepts integers or UUIDs as point IDs.
+func pointID(nodeID string) string {
+	namespace := []byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	h := sha1.New()
+	h.Write(namespace)
+	h.Write([]byte("context-node:" + nodeID))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func (q *qdrantIndex) Upsert(ctx context.Context, nodeID string, vector []float64) error {
+	points := map[string]any{"points": []map[string]any{{
+		"id":      pointID(nodeID),
+		"vector":  vector,
+		"payload": map[string]any{"node_id": nodeID},
+	}}}
+	_, err := q.call(ctx, http.MethodPut, "/collections/"+q.collection+"/points?wait=true", points, nil)
+	return err
+}
+
+func (q *qdrantIndex) Delete(ctx context.Context, nodeID string) error {
+	selector := map[string]any{"points": []string{pointID(nodeID)}}
+	_, err := q.call(ctx, http.MethodPost, "/collections/"+q.collection+"/points/delete?wait=true", selector, nil)
+	return err
+}
+
+func (q *qdrantIndex) Search(ctx context.Context, vector []float64, limit int, threshold float64) ([]Hit, error) {
+	query := map[string]any{
+		"vector":          vector,
+		"limit":           limit,
+		"score_threshold": threshold,
+		"with_payload":    true,
+	}
+	var resp struct {
+		Result []struct {
+			Score   float64 `json:"score"`
+			Payload struct {
+				NodeID string `json:"node_id"`
+			} `json:"payload"`
+		} `json:"result"`
+	}
+	if _, err := q.call(ctx, http.MethodPost, "/collections/"+q.collection+"/points/search", query, &resp); err != nil {
+		return nil, err
+	}
+	hits := make([]Hit, 0, len(resp.Result))
+	for _, r := range resp.Result {
+		hits = append(hits, Hit{NodeID: r.Payload.NodeID, Score: r.Score})
+	}
+	return hits, nil
+}
+
+func (q *qdrantIndex) Close() error {
+	q.client.CloseIdleConnections()
+	return nil
+}
+
+func (q *qdrantIndex) Shadow(ctx context.Context, name string, dimension int) (VectorIndex, error) {
+	// The graph's collection name becomes an alias of the shadow collection
+	// at cutover
+	return newQdrantIndex(ctx, q.url, q.collection+"__"+name, dimension)
+}
+
+func (q *qdrantIndex) Promote(ctx context.Context, shadow VectorIndex) (VectorIndex, error) {
+	next, ok := shadow.(*qdrantIndex)
+	if !ok {
+		return nil, fmt.Errorf("cannot promote a %s index over a qdrant one", shadow.Name())
+	}
+	aliases, err := q.aliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	previous, aliased := aliases[q.collection]
+	var actions []map[string]any
+	if aliased {
+		actions = append(actions, map[string]any{"delete_alias": map[string]any{"alias_name": q.collection}})
+	} else if _, err := q.call(ctx, http.MethodDelete, "/collections/"+q.collection, nil, nil); err != nil {
+		// A real collection has to go before its name can become an alias
+		return nil, err
+	}
+	actions = append(actions, map[string]any{"create_alias": map[string]any{
+		"collection_name": next.collection, "alias_name": q.collection}})
+	if _, err := q.call(ctx, http.MethodPost, "/collections/aliases", map[string]any{"actions": actions}, nil); err != nil {
+		return nil, err
+	}
+	if aliased && previous != next.collection {
+		if _, err := q.call(ctx, http.MethodDelete, "/collections/"+previous, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+	next.Close()
+	return q, nil
+}
+
+func (q *qdrantIndex) Drop(ctx context.Context) error {
+	_, err := q.call(ctx, http.MethodDelete, "/collections/"+q.collection, nil, nil)
+	return err
+}
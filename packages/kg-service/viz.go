@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Graph views for debugging, as in the Python graph_viz: filtered JSON for
+// the web viewer served at /ui, and Graphviz DOT.
+
+//go:embed static/viewer.html
+var viewerHTML []byte
+
+// graphView is the part of the graph the viewer draws.
+type graphView struct {
+	Nodes []viewNode `json:"nodes"`
+	Edges []viewEdge `json:"edges"`
+}
+
+type viewNode struct {
+	ID         string  `json:"id"`
+	Label      string  `json:"label"`
+	Type       string  `json:"type"`
+	Timestamp  any     `json:"timestamp"`
+	Importance float64 `json:"importance"`
+	Community  any     `json:"community"`
+	Stale      bool    `json:"stale"`
+}
+
+type viewEdge struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Type   any     `json:"type"`
+	Weight float64 `json:"weight"`
+}
+
+// nodeLabel is a short label for a node: its data's name, title or content,
+// else its ID, cut to 60 characters.
+func nodeLabel(nodeID string, attrs Attrs) string {
+	var label any = nodeID
+	if data, ok := attrs["data"].(map[string]any); ok {
+		for _, key := range []string{"name", "title", "content"} {
+			if pyTruthy(data[key]) {
+				label = data[key]
+				break
+			}
+		}
+	}
+	if text := []rune(pyStr(label)); len(text) > 60 {
+		return string(text[:57]) + "..."
+	}
+	return pyStr(label)
+}
+
+// View is the graph's nodes of the given types recorded between since and
+// until, each optional, keeping the limit most important, and the edges
+// between them.
+func (kg *KnowledgeGraph) View(ctx context.Context, nodeTypes []string, since, until string, limit int) (graphView, error) {
+	bound := func(value string) (time.Time, bool, error) {
+		if value == "" {
+			return time.Time{}, false, nil
+		}
+		t, err := parseTime(value)
+		return t, err == nil, err
+	}
+	from, hasFrom, err := bound(since)
+	if err != nil {
+		return graphView{}, err
+	}
+	to, hasTo, err := bound(until)
+	if err != nil {
+		return graphView{}, err
+	}
+
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	nodes, err := kg.store.Nodes(ctx)
+	if err != nil {
+		return graphView{}, err
+	}
+	edges, err := kg.store.Edges(ctx)
+	if err != nil {
+		return graphView{}, err
+	}
+
+	var ids []string
+	for _, id := range sortedIDs(nodes) {
+		attrs := nodes[id]
+		if kind, _ := attrs["node_type"].(string); len(nodeTypes) > 0 && !contains(nodeTypes, kind) {
+			continue
+		}
+		if recorded, ok := attrTime(attrs, "timestamp"); ok && (hasFrom && recorded.Before(from) || hasTo && recorded.After(to)) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	// Keep the most important nodes when the view has to be truncated
+	sort.SliceStable(ids, func(i, j int) bool {
+		return number(nodes[ids[i]]["importance"], 0) > number(nodes[ids[j]]["importance"], 0)
+	})
+	ids = ids[:min(max(limit, 0), len(ids))]
+
+	view := graphView{Nodes: []viewNode{}, Edges: []viewEdge{}}
+	kept := map[string]bool{}
+	for _, id := range ids {
+		attrs := nodes[id]
+		kept[id] = true
+		view.Nodes = append(view.Nodes, viewNode{
+			ID:         id,
+			Label:      nodeLabel(id, attrs),
+			Type:       nodeType(attrs),
+			Timestamp:  attrs["timestamp"],
+			Importance: number(attrs["importance"], 0),
+			Community:  attrs["community_name"],
+			Stale:      pyTruthy(attrs["tombstoned_at"]) || pyTruthy(attrs["invalidated_at"]),
+		})
+	}
+	for _, edge := range edges {
+		if kept[edge.Source] && kept[edge.Target] {
+			view.Edges = append(view.Edges, viewEdge{Source: edge.Source, Target: edge.Target,
+				Type: edge.Attrs["relationship_type"], Weight: number(edge.Attrs["weight"], 1.0)})
+		}
+	}
+	return view, nil
+}
+
+// dot renders the view as Graphviz DOT; stale nodes are dashed.
+func (view graphView) dot() string {
+	quote := func(value any) string { return pyDumps(pyStr(value)) }
+	lines := []string{"digraph knowledge_graph {", "  rankdir=LR;", "  node [shape=box, style=rounded];"}
+	for _, node := range view.Nodes {
+		style := ""
+		if node.Stale {
+			style = ", style=dashed"
+		}
+		lines = append(lines, fmt.Sprintf("  %s [label=%s, tooltip=%s%s];", quote(node.ID), quote(node.Label), quote(node.Type), style))
+	}
+	for _, edge := range view.Edges {
+		lines = append(lines, fmt.Sprintf("  %s -> %s [label=%s, weight=%.3f];",
+			quote(edge.Source), quote(edge.Target), quote(edge.Type), edge.Weight))
+	}
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,138 @@
+// Package logging is the structured logging the components of the dynamic
+// context system share: one JSON object per line, with the same fields
+// everywhere, so the logs of a request can be followed across services
+// the way its trace can.
+//
+// Every line has time, level, msg and service. A line logged with a
+// context also has the tenant and session_id the context was given, and
+// the trace_id and span_id of its current span (see packages/tracing).
+// Lines go to standard error and, when LOKI_URL is set, to Loki too. The
+// Python services and agents use logs.py, and the MCP server logging.js,
+// which write the same fields.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/tracing"
+)
+
+// Logger is a slog.Logger for one service, shipping its lines to Loki when
+// it was given a URL.
+type Logger struct {
+	*slog.Logger
+	service string
+	level   slog.Level
+	format  string
+	shipper *shipper
+}
+
+// FromEnv is the logger for service, which it also makes slog's default,
+// so what the log package writes takes the same form. LOG_LEVEL is the
+// least level written, debug, info, warn or error, and LOG_FORMAT json or,
+// for reading at a terminal, text. With LOKI_URL set, lines are pushed to
+// that Loki as well.
+func FromEnv(service string) *Logger {
+	level := slog.LevelInfo
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			level = slog.LevelInfo
+		}
+	}
+	format := "json"
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "text") {
+		format = "text"
+	}
+	logger := New(service, level, format, os.Getenv("LOKI_URL"))
+	slog.SetDefault(logger.Logger)
+	return logger
+}
+
+// New is a logger for service writing lines of level and above to
+// standard error in format, json or text, and pushing them to the Loki at
+// lokiURL unless it is empty.
+func New(service string, level slog.Level, format, lokiURL string) *Logger {
+	var w io.Writer = os.Stderr
+	var ship *shipper
+	if lokiURL != "" {
+		ship = newShipper(service, strings.TrimRight(lokiURL, "/")+"/loki/api/v1/push")
+		w = io.MultiWriter(os.Stderr, ship)
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var base slog.Handler
+	if format == "text" {
+		base = slog.NewTextHandler(w, options)
+	} else {
+		base = slog.NewJSONHandler(w, options)
+	}
+	base = base.WithAttrs([]slog.Attr{slog.String("service", service)})
+	return &Logger{Logger: slog.New(contextHandler{base}), service: service, level: level, format: format, shipper: ship}
+}
+
+// Shutdown pushes the lines still queued for Loki.
+func (l *Logger) Shutdown(ctx context.Context) {
+	if l.shipper != nil {
+		l.shipper.shutdown(ctx)
+	}
+}
+
+func (l *Logger) String() string {
+	if l.shipper == nil {
+		return fmt.Sprintf("logging %s at %s as %s", l.service, l.level, l.format)
+	}
+	return fmt.Sprintf("logging %s at %s as %s, shipped to %s", l.service, l.level, l.format, l.shipper.url)
+}
+
+type fieldsKey struct{}
+
+type fields struct {
+	tenant  string
+	session string
+}
+
+// WithTenant is ctx with the tenant its lines are logged for.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	f, _ := ctx.Value(fieldsKey{}).(fields)
+	f.tenant = tenant
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// WithSession is ctx with the session its lines are logged for.
+func WithSession(ctx context.Context, sessionID string) context.Context {
+	f, _ := ctx.Value(fieldsKey{}).(fields)
+	f.session = sessionID
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// contextHandler adds the common fields a record's context carries.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if f, ok := ctx.Value(fieldsKey{}).(fields); ok {
+		if f.tenant != "" {
+			r.AddAttrs(slog.String("tenant", f.tenant))
+		}
+		if f.session != "" {
+			r.AddAttrs(slog.String("session_id", f.session))
+		}
+	}
+	if sc := tracing.SpanContextFrom(ctx); sc.Valid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceIDString()), slog.String("span_id", sc.SpanIDString()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// batchSize lines are pushed at once, at the latest every flushInterval.
+	batchSize     = 1000
+	flushInterval = 2 * time.Second
+	// maxQueued lines wait for Loki; past that, new ones are dropped rather
+	// than held.
+	maxQueued = 10000
+)
+
+// entry is one line for Loki, with the level it is labeled by.
+type entry struct {
+	at    time.Time
+	level string
+	line  string
+}
+
+// shipper pushes lines to Loki from a goroutine of its own. It is an
+// io.Writer of whole lines, as slog's handlers write them. Loki that cannot
+// be reached costs those lines, never the service, and its errors go to
+// standard error rather than back through the logger.
+type shipper struct {
+	service string
+	url     string
+	client  *http.Client
+
+	mu      sync.Mutex
+	queued  []entry
+	dropped int
+
+	flush chan struct{}
+	stop  chan struct{}
+	once  sync.Once
+	done  chan struct{}
+}
+
+func newShipper(service, url string) *shipper {
+	s := &shipper{service: service, url: url, client: &http.Client{Timeout: 10 * time.Second},
+		flush: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+func (s *shipper) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	var fields struct {
+		Level string `json:"level"`
+	}
+	if json.Unmarshal(p, &fields) != nil {
+		// A text line: level=INFO
+		if _, rest, ok := strings.Cut(line, "level="); ok {
+			fields.Level, _, _ = strings.Cut(rest, " ")
+		}
+	}
+	s.mu.Lock()
+	if len(s.queued) >= maxQueued {
+		s.dropped++
+		s.mu.Unlock()
+		return len(p), nil
+	}
+	s.queued = append(s.queued, entry{at: time.Now(), level: strings.ToLower(fields.Level), line: line})
+	full := len(s.queued) >= batchSize
+	s.mu.Unlock()
+	if full {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func (s *shipper) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.send()
+		case <-s.flush:
+			s.send()
+		case <-s.stop:
+			s.send()
+			return
+		}
+	}
+}
+
+func (s *shipper) shutdown(ctx context.Context) {
+	s.once.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
+
+// send pushes everything queued, a batch at a time.
+func (s *shipper) send() {
+	for {
+		s.mu.Lock()
+		n := min(len(s.queued), batchSize)
+		batch := s.queued[:n:n]
+		s.queued = s.queued[n:]
+		dropped := s.dropped
+		s.dropped = 0
+		s.mu.Unlock()
+		if dropped > 0 {
+			fmt.Fprintf(os.Stderr, "logging: dropped %d lines while Loki was behind\n", dropped)
+		}
+		if n == 0 {
+			return
+		}
+		if err := s.push(batch); err != nil {
+			fmt.Fprintf(os.Stderr, "logging: could not push %d lines to Loki: %v\n", n, err)
+		}
+	}
+}
+
+// push sends lines in Loki's JSON push format, a stream per level labeled
+// with the service.
+func (s *shipper) push(batch []entry) error {
+	streams := map[string][][2]string{}
+	for _, e := range batch {
+		streams[e.level] = append(streams[e.level], [2]string{strconv.FormatInt(e.at.UnixNano(), 10), e.line})
+	}
+	request := struct {
+		Streams []map[string]any `json:"streams"`
+	}{}
+	for level, values := range streams {
+		labels := map[string]string{"service": s.service}
+		if level != "" {
+			labels["level"] = level
+		}
+		request.Streams = append(request.Streams, map[string]any{"stream": labels, "values": values})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
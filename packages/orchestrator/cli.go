@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const cliUsage = `usage: orchestrator deadletters [list]
+       orchestrator deadletters show JOB_ID
+       orchestrator deadletters requeue JOB_ID... | --all
+       orchestrator deadletters drop JOB_ID...
+       orchestrator runs [stats]
+       orchestrator runs list [AGENT_TYPE]
+       orchestrator costs [FROM [TO]]
+       orchestrator budgets
+       orchestrator manifests [list]
+       orchestrator manifests install REF | FILE
+       orchestrator manifests reload
+       orchestrator manifests validate FILE...
+       orchestrator gen agent NAME [--lang python|go] [--dir DIR] [--image IMAGE]
+       orchestrator dev [--data DIR]
+
+Talks to the orchestrator at ORCH_URL (default http://localhost:$ORCH_PORT),
+except for manifests validate and gen, which need none, and dev, which runs
+one along with stand-ins for the services it talks to.`
+
+// cliClient calls a running orchestrator's HTTP API.
+type cliClient struct {
+	url    string
+	client *http.Client
+}
+
+// runCLI runs a command against a running orchestrator, writing what it
+// finds to out.
+func runCLI(args []string, out io.Writer) error {
+	c := cliClient{
+		url:    strings.TrimRight(getenv("ORCH_URL", "http://localhost:"+getenv("ORCH_PORT", "8070")), "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	switch args[0] {
+	case "deadletters":
+	case "manifests":
+		return c.manifests(args[1:], out)
+	case "runs":
+		return c.runs(args[1:], out)
+	case "costs":
+		return c.costs(args[1:], out)
+	case "gen":
+		return generate(args[1:], out)
+	case "budgets":
+		if len(args) > 1 {
+			return fmt.Errorf("bad arguments\n%s", cliUsage)
+		}
+		return c.budgets(out)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], cliUsage)
+	}
+	command, ids := "list", args[1:]
+	if len(ids) > 0 {
+		command, ids = ids[0], ids[1:]
+	}
+	switch {
+	case command == "list" && len(ids) == 0:
+		return c.listDeadLetters(out)
+	case command == "show" && len(ids) == 1:
+		var letter DeadLetter
+		if err := c.do(http.MethodGet, "/deadletters/"+ids[0], &letter); err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(letter)
+	case command == "requeue" && len(ids) > 0:
+		if len(ids) == 1 && ids[0] == "--all" {
+			var list struct {
+				DeadLetters []DeadLetter `json:"dead_letters"`
+			}
+			if err := c.do(http.MethodGet, "/deadletters", &list); err != nil {
+				return err
+			}
+			ids = nil
+			for _, letter := range list.DeadLetters {
+				ids = append(ids, letter.JobID)
+			}
+		}
+		for _, id := range ids {
+			var job Job
+			if err := c.do(http.MethodPost, "/deadletters/"+id+"/requeue", &job); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s requeued as job %s\n", id, job.ID)
+		}
+		return nil
+	case command == "drop" && len(ids) > 0:
+		for _, id := range ids {
+			if err := c.do(http.MethodDelete, "/deadletters/"+id, nil); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s dropped\n", id)
+		}
+		return nil
+	}
+	return fmt.Errorf("bad arguments\n%s", cliUsage)
+}
+
+func (c cliClient) listDeadLetters(out io.Writer) error {
+	var list struct {
+		DeadLetters []DeadLetter `json:"dead_letters"`
+	}
+	if err := c.do(http.MethodGet, "/deadletters", &list); err != nil {
+		return err
+	}
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "JOB ID\tAGENT TYPE\tTARGET\tATTEMPTS\tFAILED AT\tERROR")
+	for _, letter := range list.DeadLetters {
+		message, _, _ := strings.Cut(letter.Error, "\n")
+		if len(message) > 60 {
+			message = message[:57] + "..."
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\t%s\n", letter.JobID, letter.AgentType, letter.Target,
+			letter.Attempts, letter.FailedAt.Format(time.RFC3339), message)
+	}
+	return table.Flush()
+}
+
+// runs shows which agent types are slow or flaky, or an agent type's last
+// runs.
+func (c cliClient) runs(args []string, out io.Writer) error {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	switch {
+	case len(args) == 0 || len(args) == 1 && args[0] == "stats":
+		var stats struct {
+			Agents []AgentRunStats `json:"agents"`
+		}
+		if err := c.do(http.MethodGet, "/runs/stats", &stats); err != nil {
+			return err
+		}
+		fmt.Fprintln(table, "AGENT TYPE\tRUNS\tFAILED\tRETRIED\tP50\tP95\tMAX\tITEMS\tBYTES")
+		for _, s := range stats.Agents {
+			fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%.1fs\t%.1fs\t%.1fs\t%d\t%d\n", s.AgentType, s.Runs, s.Failed, s.Retried,
+				s.P50Duration, s.P95Duration, s.MaxDuration, s.Items, s.BytesFetched)
+		}
+	case args[0] == "list" && len(args) <= 2:
+		path := "/runs"
+		if len(args) == 2 {
+			path += "?agent_type=" + url.QueryEscape(args[1])
+		}
+		var list struct {
+			Runs []AgentRun `json:"runs"`
+		}
+		if err := c.do(http.MethodGet, path, &list); err != nil {
+			return err
+		}
+		fmt.Fprintln(table, "JOB ID\tAGENT TYPE\tATTEMPT\tSTATUS\tDURATION\tITEMS\tBYTES\tSTARTED AT")
+		for _, run := range list.Runs {
+			fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%.1fs\t%d\t%d\t%s\n", run.JobID, run.AgentType, run.Attempt, run.Status,
+				run.Duration, run.Items, run.BytesFetched, run.StartedAt.Format(time.RFC3339))
+		}
+	default:
+		return fmt.Errorf("bad arguments\n%s", cliUsage)
+	}
+	return table.Flush()
+}
+
+// costs reports what each tenant's agent types used from one day to
+// another, YYYY-MM-DD, by default this month.
+func (c cliClient) costs(args []string, out io.Writer) error {
+	if len(args) > 2 {
+		return fmt.Errorf("bad arguments\n%s", cliUsage)
+	}
+	query := url.Values{}
+	for i, name := range []string{"from", "to"} {
+		if i < len(args) {
+			query.Set(name, args[i])
+		}
+	}
+	var report struct {
+		From  string     `json:"from"`
+		To    string     `json:"to"`
+		Costs []CostLine `json:"costs"`
+		Total Usage      `json:"total"`
+	}
+	if err := c.do(http.MethodGet, "/costs?"+query.Encode(), &report); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s to %s\n", report.From, report.To)
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TENANT\tAGENT TYPE\tRUNS\tAPI CALLS\tINPUT TOKENS\tOUTPUT TOKENS\tCOST")
+	for _, line := range report.Costs {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%d\t%d\t%.4f\n", line.Tenant, line.AgentType, line.Runs, line.APICalls,
+			line.InputTokens, line.OutputTokens, line.Cost)
+	}
+	t := report.Total
+	fmt.Fprintf(table, "TOTAL\t\t%d\t%d\t%d\t%d\t%.4f\n", t.Runs, t.APICalls, t.InputTokens, t.OutputTokens, t.Cost)
+	return table.Flush()
+}
+
+// budgets shows how much of each budget is spent and which are paused.
+func (c cliClient) budgets(out io.Writer) error {
+	var list struct {
+		Budgets []BudgetStatus `json:"budgets"`
+	}
+	if err := c.do(http.MethodGet, "/budgets", &list); err != nil {
+		return err
+	}
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tTENANT\tAGENT TYPE\tPERIOD\tCOST\tAPI CALLS\tTOKENS\tPAUSED\tRESETS AT")
+	limit := func(spent, most float64, format string) string {
+		if most == 0 {
+			return fmt.Sprintf(format, spent)
+		}
+		return fmt.Sprintf(format+"/"+format, spent, most)
+	}
+	for _, b := range list.Budgets {
+		paused := "no"
+		if b.Paused {
+			paused = "yes: " + b.Reason
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", b.Name, cmp.Or(b.Tenant, "*"), cmp.Or(b.AgentType, "*"), b.Period,
+			limit(b.Spent.Cost, b.MaxCost, "%.2f"), limit(float64(b.Spent.APICalls), float64(b.MaxAPICalls), "%.0f"),
+			limit(float64(b.Spent.tokens()), float64(b.MaxTokens), "%.0f"), paused, b.ResetsAt.Format(time.RFC3339))
+	}
+	return table.Flush()
+}
+
+// manifests lists, installs and reloads agent manifests. validate checks
+// manifest files offline, without an orchestrator.
+func (c cliClient) manifests(args []string, out io.Writer) error {
+	command, names := "list", args
+	if len(names) > 0 {
+		command, names = names[0], names[1:]
+	}
+	switch {
+	case command == "list" && len(names) == 0, command == "reload" && len(names) == 0:
+		var list struct {
+			Manifests []ManifestLoad `json:"manifests"`
+		}
+		var err error
+		if command == "list" {
+			err = c.do(http.MethodGet, "/manifests", &list)
+		} else {
+			err = c.send(http.MethodPost, "/manifests/reload", nil, &list)
+		}
+		if err != nil {
+			return err
+		}
+		table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "SOURCE\tAGENT TYPE\tVERSION\tLOADED AT\tERROR")
+		for _, load := range list.Manifests {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", load.Source, load.Name, load.Version,
+				load.LoadedAt.Format(time.RFC3339), load.Error)
+		}
+		return table.Flush()
+	case command == "install" && len(names) == 1:
+		body, err := os.ReadFile(names[0])
+		if errors.Is(err, os.ErrNotExist) {
+			body, err = json.Marshal(map[string]string{"ref": names[0]})
+		}
+		if err != nil {
+			return err
+		}
+		var agent AgentType
+		if err := c.send(http.MethodPost, "/manifests", body, &agent); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "agent type %s %s installed from %s\n", agent.Name, agent.Version, agent.Source)
+		return nil
+	case command == "validate" && len(names) > 0:
+		failed := 0
+		for _, name := range names {
+			raw, err := readManifestFile(name)
+			if err == nil {
+				_, err = parseManifest(raw)
+			}
+			if err != nil {
+				fmt.Fprintf(out, "%s: %v\n", name, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(out, "%s: ok\n", name)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d manifests are invalid", failed, len(names))
+		}
+		return nil
+	}
+	return fmt.Errorf("bad arguments\n%s", cliUsage)
+}
+
+// do sends a request and decodes the response into v, or returns the
+// orchestrator's error detail.
+func (c cliClient) do(method, path string, v any) error {
+	return c.send(method, path, nil, v)
+}
+
+// send is do with a JSON request body.
+func (c cliClient) send(method, path string, body []byte, v any) error {
+	req, err := http.NewRequest(method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var detail struct {
+			Detail string `json:"detail"`
+		}
+		if json.Unmarshal(respBody, &detail) == nil && detail.Detail != "" {
+			return fmt.Errorf("%s: %s", resp.Status, detail.Detail)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, v)
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	errBudgetExceeded = errors.New("budget exceeded")
+	errInvalidBudget  = errors.New("invalid budget")
+	errUnknownBudget  = errors.New("unknown budget")
+	errInvalidTenant  = errors.New("invalid tenant")
+)
+
+// defaultTenant is the tenant of jobs that do not name one, as in session
+// memory.
+const defaultTenant = "default"
+
+// tenantPattern is the tenant IDs the graph, session memory and the MCP
+// server accept, as in the rbac package.
+var tenantPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// checkTenant refuses a tenant the other services would not accept, before
+// a job runs for it.
+func checkTenant(tenant string) error {
+	if len(tenant) > 32 || !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("%w: %q is not 1-32 lowercase letters and digits, in words joined by single hyphens", errInvalidTenant, tenant)
+	}
+	return nil
+}
+
+// keepCostDays is how long the ledger keeps a day's usage: long enough for
+// a monthly budget and the year before it.
+const keepCostDays = 400
+
+// Prices turn what runs report into money, in whatever currency the
+// budgets use. A run that reports its own cost is not priced.
+type Prices struct {
+	APICall     float64 `json:"api_call"`
+	InputToken  float64 `json:"input_token"`
+	OutputToken float64 `json:"output_token"`
+}
+
+// Budget caps what a tenant's runs of an agent type may use in a day or a
+// calendar month, UTC. An empty Tenant or AgentType covers them all
+// together. Once any of its limits is reached, jobs it covers are refused
+// until the period ends or the budget is raised; zero leaves a limit off.
+type Budget struct {
+	Name        string  `json:"name"`
+	Tenant      string  `json:"tenant,omitempty"`
+	AgentType   string  `json:"agent_type,omitempty"`
+	Period      string  `json:"period"`
+	MaxCost     float64 `json:"max_cost,omitempty"`
+	MaxAPICalls int     `json:"max_api_calls,omitempty"`
+	MaxTokens   int     `json:"max_tokens,omitempty"`
+}
+
+func (b Budget) validate() error {
+	if !agentNamePattern.MatchString(b.Name) {
+		return fmt.Errorf("%w: name %q must be lowercase letters, digits, _ and -", errInvalidBudget, b.Name)
+	}
+	if b.Period != "day" && b.Period != "month" {
+		return fmt.Errorf("%w: %s: period must be day or month, got %q", errInvalidBudget, b.Name, b.Period)
+	}
+	if b.MaxCost < 0 || b.MaxAPICalls < 0 || b.MaxTokens < 0 {
+		return fmt.Errorf("%w: %s: limits cannot be negative", errInvalidBudget, b.Name)
+	}
+	if b.MaxCost == 0 && b.MaxAPICalls == 0 && b.MaxTokens == 0 {
+		return fmt.Errorf("%w: %s sets no limit", errInvalidBudget, b.Name)
+	}
+	return nil
+}
+
+func (b Budget) covers(tenant, agentType string) bool {
+	return (b.Tenant == "" || b.Tenant == tenant) && (b.AgentType == "" || b.AgentType == agentType)
+}
+
+// start is when the budget's current period began.
+func (b Budget) start(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	if b.Period == "month" {
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func (b Budget) resetsAt(now time.Time) time.Time {
+	if b.Period == "month" {
+		return b.start(now).AddDate(0, 1, 0)
+	}
+	return b.start(now).AddDate(0, 0, 1)
+}
+
+// exceeded names the first limit usage has reached, or is empty.
+func (b Budget) exceeded(usage Usage) string {
+	switch {
+	case b.MaxCost > 0 && usage.Cost >= b.MaxCost:
+		return fmt.Sprintf("cost %g of %g", round(usage.Cost), b.MaxCost)
+	case b.MaxAPICalls > 0 && usage.APICalls >= b.MaxAPICalls:
+		return fmt.Sprintf("%d of %d API calls", usage.APICalls, b.MaxAPICalls)
+	case b.MaxTokens > 0 && usage.tokens() >= b.MaxTokens:
+		return fmt.Sprintf("%d of %d LLM tokens", usage.tokens(), b.MaxTokens)
+	}
+	return ""
+}
+
+// Usage is what runs used: the external API calls they made, the LLM
+// tokens they spent and what that cost.
+type Usage struct {
+	Runs         int     `json:"runs"`
+	APICalls     int     `json:"api_calls"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+func (u *Usage) add(other Usage) {
+	u.Runs += other.Runs
+	u.APICalls += other.APICalls
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.Cost += other.Cost
+}
+
+func (u Usage) tokens() int {
+	return u.InputTokens + u.OutputTokens
+}
+
+// BudgetStatus is a budget with what its current period has used.
+type BudgetStatus struct {
+	Budget
+	Spent    Usage     `json:"spent"`
+	Paused   bool      `json:"paused"`
+	Reason   string    `json:"reason,omitempty"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// CostLine is the usage of one tenant's runs of one agent type.
+type CostLine struct {
+	Tenant    string `json:"tenant"`
+	AgentType string `json:"agent_type"`
+	Usage
+}
+
+// ledgerDay is one day's usage by a tenant's runs of an agent type, as the
+// ledger file keeps it.
+type ledgerDay struct {
+	Day       string `json:"day"`
+	Tenant    string `json:"tenant"`
+	AgentType string `json:"agent_type"`
+	Usage
+}
+
+type ledgerKey struct {
+	day, tenant, agentType string
+}
+
+// Costs keeps a ledger of usage by day, tenant and agent type, prices runs
+// and enforces budgets. With a path the ledger is kept in a JSON file
+// rewritten after every run, so budgets hold across a restart.
+type Costs struct {
+	path   string
+	prices Prices
+
+	mu      sync.Mutex
+	budgets map[string]Budget
+	ledger  map[ledgerKey]*Usage
+}
+
+// openCosts reads prices and budgets from config, {"prices", "budgets"},
+// and the ledger at path, where either is given and exists.
+func openCosts(config, path string) (*Costs, error) {
+	c := &Costs{path: path, budgets: make(map[string]Budget), ledger: make(map[ledgerKey]*Usage)}
+	if config != "" {
+		raw, err := os.ReadFile(config)
+		if err != nil {
+			return nil, err
+		}
+		var doc struct {
+			Prices  Prices   `json:"prices"`
+			Budgets []Budget `json:"budgets"`
+		}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", config, err)
+		}
+		if doc.Prices.APICall < 0 || doc.Prices.InputToken < 0 || doc.Prices.OutputToken < 0 {
+			return nil, fmt.Errorf("%s: prices cannot be negative", config)
+		}
+		c.prices = doc.Prices
+		for _, budget := range doc.Budgets {
+			if err := c.Put(budget); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if path == "" {
+		return c, nil
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var days []ledgerDay
+	if err := json.Unmarshal(raw, &days); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, day := range days {
+		usage := day.Usage
+		c.ledger[ledgerKey{day.Day, day.Tenant, day.AgentType}] = &usage
+	}
+	return c, nil
+}
+
+// Price fills in the cost of a run that did not report its own.
+func (c *Costs) Price(run *AgentRun) {
+	if run.Cost == 0 {
+		run.Cost = float64(run.APICalls)*c.prices.APICall + float64(run.InputTokens)*c.prices.InputToken +
+			float64(run.OutputTokens)*c.prices.OutputToken
+	}
+}
+
+// Record adds a run's usage to the ledger, and logs any budget it uses up.
+func (c *Costs) Record(run AgentRun) {
+	usage := Usage{Runs: 1, APICalls: run.APICalls, InputTokens: run.InputTokens, OutputTokens: run.OutputTokens, Cost: run.Cost}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	usedUp := make(map[string]bool)
+	for _, budget := range c.budgets {
+		usedUp[budget.Name] = budget.exceeded(c.spent(budget, run.FinishedAt)) != ""
+	}
+	key := ledgerKey{run.FinishedAt.UTC().Format(time.DateOnly), run.Tenant, run.AgentType}
+	day, ok := c.ledger[key]
+	if !ok {
+		day = &Usage{}
+		c.ledger[key] = day
+	}
+	day.add(usage)
+	for _, budget := range c.budgets {
+		if usedUp[budget.Name] || !budget.covers(run.Tenant, run.AgentType) {
+			continue
+		}
+		if reason := budget.exceeded(c.spent(budget, run.FinishedAt)); reason != "" {
+			log.Printf("budget %s is used up (%s); its jobs are refused until %s", budget.Name, reason,
+				budget.resetsAt(run.FinishedAt).Format(time.RFC3339))
+		}
+	}
+	if err := c.save(run.FinishedAt); err != nil {
+		log.Printf("saving cost ledger: %v", err)
+	}
+}
+
+// Check refuses a tenant's job for an agent type while a budget covering
+// it is used up.
+func (c *Costs) Check(tenant, agentType string) error {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, budget := range c.sortedBudgets() {
+		if !budget.covers(tenant, agentType) {
+			continue
+		}
+		if reason := budget.exceeded(c.spent(budget, now)); reason != "" {
+			return fmt.Errorf("%w: %s has used %s; %s for tenant %s is paused until %s", errBudgetExceeded,
+				budget.Name, reason, agentType, tenant, budget.resetsAt(now).Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// Put adds a budget, or replaces one of the same name.
+func (c *Costs) Put(budget Budget) error {
+	if err := budget.validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.budgets[budget.Name] = budget
+	return nil
+}
+
+func (c *Costs) Remove(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.budgets[name]
+	delete(c.budgets, name)
+	return ok
+}
+
+// Budgets returns the budgets by name, with what they have used.
+func (c *Costs) Budgets() []BudgetStatus {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	statuses := make([]BudgetStatus, 0, len(c.budgets))
+	for _, budget := range c.sortedBudgets() {
+		spent := c.spent(budget, now)
+		reason := budget.exceeded(spent)
+		statuses = append(statuses, BudgetStatus{Budget: budget, Spent: spent, Paused: reason != "", Reason: reason,
+			ResetsAt: budget.resetsAt(now)})
+	}
+	return statuses
+}
+
+// Report sums the ledger from one day to another, inclusive, by tenant and
+// agent type, most costly first, with the total.
+func (c *Costs) Report(from, to time.Time) ([]CostLine, Usage) {
+	first, last := from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly)
+	c.mu.Lock()
+	lines := make(map[[2]string]*CostLine)
+	var total Usage
+	for key, usage := range c.ledger {
+		if key.day < first || key.day > last {
+			continue
+		}
+		line, ok := lines[[2]string{key.tenant, key.agentType}]
+		if !ok {
+			line = &CostLine{Tenant: key.tenant, AgentType: key.agentType}
+			lines[[2]string{key.tenant, key.agentType}] = line
+		}
+		line.add(*usage)
+		total.add(*usage)
+	}
+	c.mu.Unlock()
+
+	report := make([]CostLine, 0, len(lines))
+	for _, line := range lines {
+		line.Cost = round(line.Cost)
+		report = append(report, *line)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Cost != report[j].Cost {
+			return report[i].Cost > report[j].Cost
+		}
+		return report[i].Tenant+"/"+report[i].AgentType < report[j].Tenant+"/"+report[j].AgentType
+	})
+	total.Cost = round(total.Cost)
+	return report, total
+}
+
+// spent sums what a budget covers in its current period; c.mu must be held.
+func (c *Costs) spent(budget Budget, now time.Time) Usage {
+	first := budget.start(now).Format(time.DateOnly)
+	var spent Usage
+	for key, usage := range c.ledger {
+		if key.day >= first && budget.covers(key.tenant, key.agentType) {
+			spent.add(*usage)
+		}
+	}
+	return spent
+}
+
+// sortedBudgets lists the budgets by name; c.mu must be held.
+func (c *Costs) sortedBudgets() []Budget {
+	budgets := make([]Budget, 0, len(c.budgets))
+	for _, budget := range c.budgets {
+		budgets = append(budgets, budget)
+	}
+	sort.Slice(budgets, func(i, j int) bool { return budgets[i].Name < budgets[j].Name })
+	return budgets
+}
+
+// save drops days past keepCostDays and writes the ledger to c.path through
+// a temporary file, like the dead letters; c.mu must be held.
+func (c *Costs) save(now time.Time) error {
+	oldest := now.UTC().AddDate(0, 0, -keepCostDays).Format(time.DateOnly)
+	days := make([]ledgerDay, 0, len(c.ledger))
+	for key, usage := range c.ledger {
+		if key.day < oldest {
+			delete(c.ledger, key)
+			continue
+		}
+		days = append(days, ledgerDay{Day: key.day, Tenant: key.tenant, AgentType: key.agentType, Usage: *usage})
+	}
+	if c.path == "" {
+		return nil
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Day+days[i].Tenant+days[i].AgentType < days[j].Day+days[j].Tenant+days[j].AgentType
+	})
+	raw, err := json.MarshalIndent(days, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errUnknownDeadLetter = errors.New("dead letter not found")
+
+const keepDeadLetters = 1000
+
+// DeadLetter is a job that failed on its last attempt, with what it was
+// asked to do and why each attempt failed, kept so it can be inspected and
+// requeued.
+type DeadLetter struct {
+	JobID     string `json:"job_id"`
+	AgentType string `json:"agent_type"`
+	Target    string `json:"target"`
+	SessionID string `json:"session_id,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	Priority  string `json:"priority,omitempty"`
+	// Input is what the job wrote to the agent's stdin, as for a pipeline
+	// step.
+	Input    json.RawMessage `json:"input,omitempty"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	Errors   []string        `json:"errors"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// DeadLetters keeps failed jobs in memory and, with a path, in a JSON file
+// rewritten on every change, so they outlast a restart. Past
+// keepDeadLetters the oldest are dropped.
+type DeadLetters struct {
+	path string
+
+	mu      sync.Mutex
+	letters map[string]DeadLetter
+}
+
+// openDeadLetters loads the dead letters at path, if it is given and exists.
+func openDeadLetters(path string) (*DeadLetters, error) {
+	d := &DeadLetters{path: path, letters: make(map[string]DeadLetter)}
+	if path == "" {
+		return d, nil
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var letters []DeadLetter
+	if err := json.Unmarshal(raw, &letters); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, letter := range letters {
+		d.letters[letter.JobID] = letter
+	}
+	return d, nil
+}
+
+func (d *DeadLetters) Add(letter DeadLetter) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.letters[letter.JobID] = letter
+	if len(d.letters) > keepDeadLetters {
+		oldest := d.sorted()
+		for _, letter := range oldest[keepDeadLetters:] {
+			delete(d.letters, letter.JobID)
+		}
+	}
+	return d.save()
+}
+
+func (d *DeadLetters) Get(jobID string) (DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	letter, ok := d.letters[jobID]
+	if !ok {
+		return DeadLetter{}, fmt.Errorf("%w: %s", errUnknownDeadLetter, jobID)
+	}
+	return letter, nil
+}
+
+// Remove drops a dead letter, returning it.
+func (d *DeadLetters) Remove(jobID string) (DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	letter, ok := d.letters[jobID]
+	if !ok {
+		return DeadLetter{}, fmt.Errorf("%w: %s", errUnknownDeadLetter, jobID)
+	}
+	delete(d.letters, jobID)
+	return letter, d.save()
+}
+
+// List returns dead letters newest first.
+func (d *DeadLetters) List() []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sorted()
+}
+
+// sorted lists the dead letters newest first; d.mu must be held.
+func (d *DeadLetters) sorted() []DeadLetter {
+	letters := make([]DeadLetter, 0, len(d.letters))
+	for _, letter := range d.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.After(letters[j].FailedAt) })
+	return letters
+}
+
+// save writes the dead letters to d.path through a temporary file, so a
+// crash mid-write leaves the last good copy; d.mu must be held.
+func (d *DeadLetters) save() error {
+	if d.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(d.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const devUsage = `usage: orchestrator dev [--data DIR]
+
+Runs the orchestrator with stand-ins for the MCP server, session memory and
+the knowledge graph in one process, on ORCH_PORT: the MCP server under /mcp,
+session memory under /memory and the knowledge graph under /graph. Agents
+run as child processes. What the stand-ins store is kept in DIR (default
+ORCH_DEV_DATA, or .orchestrator-dev).`
+
+// devStack is what dev mode serves beside the orchestrator.
+type devStack struct {
+	memory *devMemory
+	graph  *devGraph
+	mcp    *devMCP
+}
+
+// routes serves the stand-ins under their prefixes and the orchestrator's
+// API everywhere else.
+func (d *devStack) routes(orchestrator http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", orchestrator)
+	mux.Handle("/mcp/", http.StripPrefix("/mcp", d.mcp.routes()))
+	mux.Handle("/memory/", http.StripPrefix("/memory", d.memory.routes()))
+	mux.Handle("/graph/", http.StripPrefix("/graph", d.graph.routes()))
+	return mux
+}
+
+// runDev runs the orchestrator in dev mode, or with "agent" as the first
+// argument, the dev agent.
+func runDev(args []string) error {
+	if len(args) > 0 && args[0] == "agent" {
+		return runDevAgent(args[1:], os.Stdout)
+	}
+	data := getenv("ORCH_DEV_DATA", ".orchestrator-dev")
+	switch {
+	case len(args) == 2 && args[0] == "--data":
+		data = args[1]
+	case len(args) != 0:
+		return fmt.Errorf("bad arguments\n%s", devUsage)
+	}
+	if err := os.MkdirAll(data, 0o755); err != nil {
+		return err
+	}
+	data, err := filepath.Abs(data)
+	if err != nil {
+		return err
+	}
+	agents := filepath.Join(data, "agents.json")
+	if err := writeDevAgents(agents); err != nil {
+		return fmt.Errorf("writing the dev agents: %w", err)
+	}
+
+	// Everything the orchestrator and its agents would find in a
+	// deployment is here instead, unless it is set already
+	base := "http://localhost:" + getenv("ORCH_PORT", "8070")
+	for key, value := range map[string]string{
+		"ORCH_RUNTIME":        "exec",
+		"ORCH_AGENTS":         agents,
+		"ORCH_AGENT_STATE":    filepath.Join(data, "agent-state"),
+		"ORCH_ARTIFACTS":      filepath.Join(data, "artifacts"),
+		"ORCH_DEAD_LETTERS":   filepath.Join(data, "dead-letters.json"),
+		"ORCH_SECRETS_DIR":    filepath.Join(data, "secrets"),
+		"MCP_SERVER_URL":      base + "/mcp",
+		"SESSION_MEMORY_URL":  base + "/memory",
+		"KNOWLEDGE_GRAPH_URL": base + "/graph",
+	} {
+		if os.Getenv(key) == "" {
+			os.Setenv(key, value)
+		}
+	}
+
+	db, err := openDevDB(filepath.Join(data, "dev.db"))
+	if err != nil {
+		return fmt.Errorf("opening the dev database: %w", err)
+	}
+	defer db.Close()
+	memory, graph := newDevMemory(db), newDevGraph(db)
+	log.Printf("dev mode: data in %s, MCP server at %s, session memory at %s, knowledge graph at %s",
+		data, os.Getenv("MCP_SERVER_URL"), os.Getenv("SESSION_MEMORY_URL"), os.Getenv("KNOWLEDGE_GRAPH_URL"))
+	return run(&devStack{memory: memory, graph: graph, mcp: newDevMCP(memory)})
+}
+
+// loadJSON reads what saveJSON wrote; a missing file leaves v as it is.
+func loadJSON(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// saveJSON writes v through a temporary file, as DeadLetters.save does.
+func saveJSON(path string, v any) error {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeDevAgents writes the agent registry dev mode starts with:
+// context_gatherer is the dev agent, run by this binary. Agent types added
+// to the file, such as ones started with orchestrator gen agent, are kept
+// across runs, and the dev agent's command is pointed at this binary each
+// time, since go run builds it somewhere new. The exec runtime holds agents
+// to their memory limit as address space, of which Go reserves more than the
+// default 512m at start.
+func writeDevAgents(path string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	agents := []AgentType{{
+		Name:        "context_gatherer",
+		Description: "Describes a local directory or file (dev mode)",
+		Command:     []string{self, "dev", "agent"},
+		Limits:      Limits{Memory: "1g"},
+	}}
+	if err := loadJSON(path, &agents); err != nil {
+		return err
+	}
+	for i, agent := range agents {
+		if len(agent.Command) == 3 && agent.Command[1] == "dev" && agent.Command[2] == "agent" {
+			agents[i].Command = []string{self, "dev", "agent"}
+		}
+	}
+	return saveJSON(path, agents)
+}
+
+// devAgentMaxFiles caps how many files the dev agent lists.
+const devAgentMaxFiles = 1000
+
+// devAgentBatch is how many files the dev agent streams at a time.
+const devAgentBatch = 200
+
+type devFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// runDevAgent is the dev agent: it lists the files under the target, a
+// local path, streaming them to MCP_SERVER_URL as it goes, and prints a
+// result with the files, a count of them by extension and a graph node
+// describing the target. It skips hidden directories and node_modules.
+func runDevAgent(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: orchestrator dev agent TARGET")
+	}
+	target := args[0]
+	root, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(root); err != nil {
+		return &exitError{status: exitUsage, message: fmt.Sprintf("the dev agent reads local paths: %v", err)}
+	}
+	fmt.Fprintf(out, "🔍 Listing %s\n", root)
+
+	stream := newDevAgentStream(target)
+	files, batch := []devFile{}, []devFile(nil)
+	extensions := map[string]int{}
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			if path != root && (strings.HasPrefix(name, ".") || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(files) == devAgentMaxFiles {
+			return filepath.SkipAll
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		file := devFile{Path: filepath.ToSlash(cmp.Or(rel, name)), Size: info.Size()}
+		files = append(files, file)
+		extensions[cmp.Or(strings.ToLower(filepath.Ext(name)), "(none)")]++
+		if batch = append(batch, file); len(batch) == devAgentBatch {
+			stream.send(batch)
+			batch = nil
+		}
+		return nil
+	})
+	if err != nil {
+		stream.finish("failed")
+		return err
+	}
+	if len(batch) > 0 {
+		stream.send(batch)
+	}
+	stream.finish("succeeded")
+	fmt.Fprintf(out, "📁 Found %d files\n", len(files))
+
+	kinds := make([]string, 0, len(extensions))
+	for ext := range extensions {
+		kinds = append(kinds, fmt.Sprintf("%s (%d)", ext, extensions[ext]))
+	}
+	sort.Strings(kinds)
+	node := map[string]any{"data": map[string]any{
+		"type":     "directory",
+		"content":  fmt.Sprintf("%s holds %d files: %s", target, len(files), strings.Join(kinds, ", ")),
+		"metadata": map[string]any{"path": root},
+	}}
+	if graph := os.Getenv("KNOWLEDGE_GRAPH_URL"); graph != "" {
+		if id, err := addDevNode(strings.TrimRight(graph, "/"), node); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ Could not add the node to the knowledge graph: %v\n", err)
+		} else {
+			node["node_id"] = id
+		}
+	}
+	return json.NewEncoder(out).Encode(map[string]any{
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		"agent_type": "context_gatherer",
+		"target":     target,
+		"context":    map[string]any{"files": files, "extensions": extensions, "nodes": []any{node}},
+		"metadata":   map[string]any{"source": "dev", "version": "1"},
+		"metrics":    map[string]any{"items": len(files)},
+	})
+}
+
+// addDevNode adds a node to the knowledge graph at graphURL, returning the
+// ID the graph gave it.
+func addDevNode(graphURL string, node map[string]any) (string, error) {
+	body, _ := json.Marshal(node)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(graphURL+"/nodes", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	var added struct {
+		NodeID string `json:"node_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&added); err != nil {
+		return "", err
+	}
+	return added.NodeID, nil
+}
+
+// devAgentStream posts the dev agent's files to the MCP server as they are
+// found, as SDK agents do. Without MCP_SERVER_URL, or once a post fails, it
+// does nothing.
+type devAgentStream struct {
+	url, id, session, target string
+	seq, items               int
+	client                   *http.Client
+}
+
+func newDevAgentStream(target string) *devAgentStream {
+	s := &devAgentStream{id: os.Getenv("AGENT_JOB_ID"), session: os.Getenv("AGENT_SESSION_ID"), target: target,
+		client: &http.Client{Timeout: 10 * time.Second}}
+	if s.id != "" {
+		s.url = strings.TrimRight(os.Getenv("MCP_SERVER_URL"), "/")
+	}
+	return s
+}
+
+func (s *devAgentStream) post(path string, payload map[string]any) {
+	if s.url == "" {
+		return
+	}
+	if s.session != "" {
+		payload["session_id"] = s.session
+	}
+	body, _ := json.Marshal(payload)
+	resp, err := s.client.Post(s.url+path, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = errors.New(resp.Status)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ Not streaming any more: %v\n", err)
+		s.url = ""
+	}
+}
+
+func (s *devAgentStream) send(files []devFile) {
+	s.seq++
+	s.items += len(files)
+	s.post("/agents/items", map[string]any{"stream_id": s.id, "agent_type": "context_gatherer",
+		"target": s.target, "field": "files", "items": files, "seq": s.seq})
+}
+
+func (s *devAgentStream) finish(status string) {
+	s.post("/agents/done", map[string]any{"stream_id": s.id, "status": status, "items": s.items, "batches": s.seq})
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// The stand-ins dev mode serves in place of session memory, the knowledge
+// graph and the MCP server. Each speaks the subset of its service's API the
+// orchestrator and agents use. Session memory and the graph keep what they
+// store in one embedded SQLite database, so a restart finds it again.
+
+// devSchema is the dev database's: each session's current context and the
+// contexts it stored before it, and the graph's nodes.
+const devSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id      TEXT PRIMARY KEY,
+	context TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS session_history (
+	seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT NOT NULL,
+	context    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS session_history_session ON session_history (session_id, seq);
+CREATE TABLE IF NOT EXISTS nodes (
+	id          TEXT PRIMARY KEY,
+	data        TEXT NOT NULL,
+	valid_from  TEXT NOT NULL,
+	valid_to    TEXT,
+	recorded_at TEXT NOT NULL
+);`
+
+// openDevDB opens the dev database at path, creating it and its tables the
+// first time. It has one connection, so writes never find it busy.
+func openDevDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(devSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// devHistoryLimit is how many stored contexts of a session dev memory keeps.
+const devHistoryLimit = 50
+
+// devMemory is session memory: the context stored last for each session,
+// versioned the way the Python service versions it.
+type devMemory struct {
+	db *sql.DB
+}
+
+func newDevMemory(db *sql.DB) *devMemory {
+	return &devMemory{db: db}
+}
+
+func (m *devMemory) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", m.health)
+	mux.HandleFunc("GET /sessions", m.listSessions)
+	mux.HandleFunc("PUT /sessions/{session_id}", m.storeSession)
+	mux.HandleFunc("GET /sessions/{session_id}", m.getSession)
+	mux.HandleFunc("DELETE /sessions/{session_id}", m.deleteSession)
+	mux.HandleFunc("GET /sessions/{session_id}/history", m.sessionHistory)
+	return mux
+}
+
+func (m *devMemory) health(w http.ResponseWriter, r *http.Request) {
+	var sessions int
+	if err := m.db.QueryRowContext(r.Context(), "SELECT count(*) FROM sessions").Scan(&sessions); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "healthy", "store": "dev", "sessions": sessions})
+}
+
+func (m *devMemory) listSessions(w http.ResponseWriter, r *http.Request) {
+	rows, err := m.db.QueryContext(r.Context(), "SELECT id FROM sessions ORDER BY id")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": ids})
+}
+
+// errVersionConflict is a session write against a version other than the
+// current one.
+var errVersionConflict = errors.New("version conflict")
+
+// storeSession replaces a session's context. Like the Python service, a
+// write against a version other than the current one is refused with 409,
+// given as ?expected_version= or as the context's own version.
+func (m *devMemory) storeSession(w http.ResponseWriter, r *http.Request) {
+	var context map[string]any
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<20)).Decode(&context); err != nil || context == nil {
+		writeError(w, http.StatusUnprocessableEntity, "the body must be a JSON object")
+		return
+	}
+	expected := -1
+	if version, ok := context["version"].(float64); ok {
+		expected = int(version)
+	}
+	if raw := r.URL.Query().Get("expected_version"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "expected_version must be an integer")
+			return
+		}
+		expected = n
+	}
+	id := r.PathValue("session_id")
+	err := m.Store(id, context, expected)
+	switch {
+	case errors.Is(err, errVersionConflict):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"stored": id, "stored_at": context["stored_at"],
+		"version": context["version"], "duplicate": false, "merged": false})
+}
+
+// Store replaces a session's context with context, which it updates to
+// what was stored, unless expected is a version other than the session's.
+// A negative expected takes any.
+func (m *devMemory) Store(id string, context map[string]any, expected int) error {
+	delete(context, "version")
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var current struct {
+		Version float64 `json:"version"`
+	}
+	var raw string
+	err = tx.QueryRow("SELECT context FROM sessions WHERE id = ?", id).Scan(&raw)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal([]byte(raw), &current); err != nil {
+			return fmt.Errorf("session %s: %w", id, err)
+		}
+	}
+	version := int(current.Version)
+	if expected >= 0 && expected != version {
+		return fmt.Errorf("%w: session %s is at version %d, not %d", errVersionConflict, id, version, expected)
+	}
+	context["version"] = float64(version + 1)
+	context["stored_at"] = time.Now().Format("2006-01-02T15:04:05.000000")
+	stored, err := json.Marshal(context)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO sessions (id, context) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET context = excluded.context",
+		id, string(stored)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO session_history (session_id, context) VALUES (?, ?)", id, string(stored)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM session_history WHERE session_id = ? AND seq NOT IN
+		(SELECT seq FROM session_history WHERE session_id = ? ORDER BY seq DESC LIMIT ?)`, id, id, devHistoryLimit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *devMemory) getSession(w http.ResponseWriter, r *http.Request) {
+	var raw string
+	err := m.db.QueryRowContext(r.Context(), "SELECT context FROM sessions WHERE id = ?", r.PathValue("session_id")).Scan(&raw)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, "Session not found")
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(raw))
+}
+
+func (m *devMemory) deleteSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("session_id")
+	tx, err := m.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("DELETE FROM sessions WHERE id = ?", id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM session_history WHERE session_id = ?", id); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deleted": id})
+}
+
+func (m *devMemory) sessionHistory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("session_id")
+	rows, err := m.db.QueryContext(r.Context(), "SELECT context FROM session_history WHERE session_id = ? ORDER BY seq", id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	history := []json.RawMessage{}
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		history = append(history, json.RawMessage(raw))
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"session_id": id, "history": history})
+}
+
+// devNode is a knowledge graph node as the Python service serves it.
+type devNode struct {
+	ID         string         `json:"node_id"`
+	Data       map[string]any `json:"data"`
+	ValidFrom  string         `json:"valid_from"`
+	ValidTo    *string        `json:"valid_to"`
+	RecordedAt string         `json:"timestamp"`
+}
+
+// devGraph is the knowledge graph without embeddings or edges: nodes keyed
+// the way the Python service keys them, and a keyword search over them.
+type devGraph struct {
+	db *sql.DB
+}
+
+func newDevGraph(db *sql.DB) *devGraph {
+	return &devGraph{db: db}
+}
+
+func (g *devGraph) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", g.health)
+	mux.HandleFunc("POST /nodes", g.addNode)
+	mux.HandleFunc("GET /nodes/{node_id}", g.getNode)
+	mux.HandleFunc("POST /nodes/{node_id}/invalidate", g.invalidateNode)
+	mux.HandleFunc("GET /search", g.search)
+	mux.HandleFunc("GET /stats", g.stats)
+	return mux
+}
+
+// devNodeID is the first 12 hex digits of the MD5 of the data as JSON with
+// sorted keys, as the Python service derives node IDs. Go renders the JSON
+// without Python's spaces, so the IDs differ from the service's, but the
+// same data still gets the same node.
+func devNodeID(data map[string]any) string {
+	raw, _ := json.Marshal(data)
+	sum := md5.Sum(raw)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// nodes are the nodes the where clause, if any, selects.
+func (g *devGraph) nodes(ctx context.Context, where string, args ...any) ([]*devNode, error) {
+	query := "SELECT id, data, valid_from, valid_to, recorded_at FROM nodes"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := g.db.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var nodes []*devNode
+	for rows.Next() {
+		node := &devNode{}
+		var data string
+		if err := rows.Scan(&node.ID, &data, &node.ValidFrom, &node.ValidTo, &node.RecordedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &node.Data); err != nil {
+			return nil, fmt.Errorf("node %s: %w", node.ID, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+func (g *devGraph) health(w http.ResponseWriter, r *http.Request) {
+	var nodes int
+	if err := g.db.QueryRowContext(r.Context(), "SELECT count(*) FROM nodes").Scan(&nodes); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "healthy", "store": "dev", "nodes": nodes})
+}
+
+func (g *devGraph) stats(w http.ResponseWriter, r *http.Request) {
+	nodes, err := g.nodes(r.Context(), "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	types := map[string]int{}
+	for _, node := range nodes {
+		kind, _ := node.Data["type"].(string)
+		types[cmp.Or(kind, "unknown")]++
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"nodes": len(nodes), "edges": 0, "node_types": types})
+}
+
+func (g *devGraph) addNode(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Data      map[string]any `json:"data"`
+		ValidFrom string         `json:"valid_from"`
+		ValidTo   string         `json:"valid_to"`
+	}
+	if !decodeBody(w, r, &request, "data") {
+		return
+	}
+	if request.Data == nil {
+		writeError(w, http.StatusUnprocessableEntity, "data must be an object")
+		return
+	}
+	id := devNodeID(request.Data)
+	data, _ := json.Marshal(request.Data)
+	recordedAt := time.Now().UTC().Format(time.RFC3339)
+	var validTo *string
+	if request.ValidTo != "" {
+		validTo = &request.ValidTo
+	}
+	if _, err := g.db.ExecContext(r.Context(), `INSERT INTO nodes (id, data, valid_from, valid_to, recorded_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data, valid_from = excluded.valid_from,
+		valid_to = excluded.valid_to, recorded_at = excluded.recorded_at`,
+		id, string(data), cmp.Or(request.ValidFrom, recordedAt), validTo, recordedAt); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"node_id": id, "merged": false})
+}
+
+func (g *devGraph) getNode(w http.ResponseWriter, r *http.Request) {
+	nodes, err := g.nodes(r.Context(), "id = ?", r.PathValue("node_id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(nodes) == 0 {
+		writeError(w, http.StatusNotFound, "Node not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, nodes[0])
+}
+
+func (g *devGraph) invalidateNode(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		At string `json:"at"`
+	}
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	id := r.PathValue("node_id")
+	at := cmp.Or(request.At, time.Now().UTC().Format(time.RFC3339))
+	result, err := g.db.ExecContext(r.Context(), "UPDATE nodes SET valid_to = ? WHERE id = ?", at, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "Node not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"node_id": id, "valid_to": cmp.Or(request.At, "now")})
+}
+
+// search scores each node valid now by the share of the query's words its
+// data contains, whatever the mode, since dev mode has no embeddings.
+func (g *devGraph) search(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	if !params.Has("q") {
+		writeError(w, http.StatusUnprocessableEntity, "field required: q")
+		return
+	}
+	query := params.Get("q")
+	limit := 10
+	if params.Has("limit") {
+		n, err := strconv.Atoi(params.Get("limit"))
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "limit must be an integer")
+			return
+		}
+		if n < 1 {
+			writeError(w, http.StatusUnprocessableEntity, "limit must be at least 1")
+			return
+		}
+		limit = n
+	}
+	terms := strings.Fields(strings.ToLower(query))
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	nodes, err := g.nodes(r.Context(), "valid_to IS NULL OR valid_to > ?", now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	results := []map[string]any{}
+	for _, node := range nodes {
+		raw, _ := json.Marshal(node.Data)
+		text := strings.ToLower(string(raw))
+		matched := 0
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				matched++
+			}
+		}
+		if matched == 0 && len(terms) > 0 {
+			continue
+		}
+		score := 1.0
+		if len(terms) > 0 {
+			score = float64(matched) / float64(len(terms))
+		}
+		results = append(results, map[string]any{"node_id": node.ID, "score": score, "importance": 0.0,
+			"data": node.Data, "valid_from": node.ValidFrom, "valid_to": node.ValidTo})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if si, sj := results[i]["score"].(float64), results[j]["score"].(float64); si != sj {
+			return si > sj
+		}
+		return results[i]["node_id"].(string) < results[j]["node_id"].(string)
+	})
+	results = results[:min(len(results), limit)]
+	writeJSON(w, http.StatusOK, map[string]any{"query": query, "mode": cmp.Or(params.Get("mode"), "hybrid"),
+		"as_of": nil, "results": results})
+}
+
+// devStreamLimit is how many streams the MCP stand-in tracks.
+const devStreamLimit = 100
+
+type devStream struct {
+	SessionID string         `json:"session_id,omitempty"`
+	AgentType string         `json:"agent_type"`
+	Target    string         `json:"target"`
+	Items     int            `json:"items"`
+	Batches   int            `json:"batches"`
+	Status    string         `json:"status"`
+	context   map[string]any // what has streamed so far
+	done      bool           // the final result has arrived
+}
+
+// devMCP is the MCP server's agent endpoints: it stores finished jobs and
+// streamed items in dev memory, as the MCP server does in session memory,
+// and serves dev memory under /memory. It has no Socket.IO clients to
+// broadcast to.
+type devMCP struct {
+	memory *devMemory
+
+	mu      sync.Mutex
+	streams map[string]*devStream
+	order   []string
+}
+
+func newDevMCP(memory *devMemory) *devMCP {
+	return &devMCP{memory: memory, streams: map[string]*devStream{}}
+}
+
+func (m *devMCP) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", m.health)
+	mux.HandleFunc("POST /agents/results", m.result)
+	mux.HandleFunc("POST /agents/items", m.items)
+	mux.HandleFunc("POST /agents/done", m.done)
+	mux.HandleFunc("GET /agents/streams", m.listStreams)
+	mux.Handle("/memory/", http.StripPrefix("/memory", m.memory.routes()))
+	return mux
+}
+
+func (m *devMCP) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "healthy", "server": "dev"})
+}
+
+// decodeAgentOutput reads a body the agent output schema's definition must
+// accept, answering 422 with the violations when it does not.
+func decodeAgentOutput(w http.ResponseWriter, r *http.Request, definition string) (map[string]any, bool) {
+	var payload map[string]any
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<20)).Decode(&payload); err != nil || payload == nil {
+		writeError(w, http.StatusBadRequest, "the body must be a JSON object")
+		return nil, false
+	}
+	if err := agentOutput.Validate(definition, payload); err != nil {
+		var invalid *invalidOutputError
+		if errors.As(err, &invalid) {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error(), "violations": invalid.violations})
+		} else {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		}
+		return nil, false
+	}
+	return payload, true
+}
+
+// result takes a finished job. The final result replaces what the job
+// streamed, and is stored in its session when it succeeded.
+func (m *devMCP) result(w http.ResponseWriter, r *http.Request) {
+	var job struct {
+		ID        string         `json:"id"`
+		Kind      string         `json:"kind"`
+		Status    string         `json:"status"`
+		SessionID string         `json:"session_id"`
+		Result    map[string]any `json:"result"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<20)).Decode(&job); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if job.ID == "" || job.Status == "" {
+		writeError(w, http.StatusBadRequest, "Job id and status are required")
+		return
+	}
+	log.Printf("dev mcp: agent %s %s %s", cmp.Or(job.Kind, "job"), job.ID, job.Status)
+	m.mu.Lock()
+	if stream, ok := m.streams[job.ID]; ok {
+		stream.done, stream.context = true, nil
+	}
+	m.mu.Unlock()
+	if (job.Status == "succeeded" || job.Status == "partial") && job.SessionID != "" {
+		if job.Result == nil {
+			job.Result = map[string]any{}
+		}
+		m.remember(job.SessionID, job.Result)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"message": "Result received", "id": job.ID})
+}
+
+// items adds a batch to its stream's context, which is stored in the
+// stream's session after each batch until the final result arrives.
+func (m *devMCP) items(w http.ResponseWriter, r *http.Request) {
+	batch, ok := decodeAgentOutput(w, r, "item_batch")
+	if !ok {
+		return
+	}
+	id, _ := batch["stream_id"].(string)
+	field, _ := batch["field"].(string)
+	items, _ := batch["items"].([]any)
+	agentType, _ := batch["agent_type"].(string)
+	target, _ := batch["target"].(string)
+	sessionID, _ := batch["session_id"].(string)
+	seq, _ := batch["seq"].(float64)
+
+	m.mu.Lock()
+	stream, ok := m.streams[id]
+	if !ok {
+		stream = &devStream{SessionID: sessionID, AgentType: agentType, Target: target}
+		m.streams[id] = stream
+		m.order = append(m.order, id)
+		if len(m.order) > devStreamLimit {
+			delete(m.streams, m.order[0])
+			m.order = m.order[1:]
+		}
+	}
+	// A retried job streams again from its first batch
+	if stream.context == nil || seq == 1 && stream.Batches > 0 {
+		stream.context = map[string]any{"agent_type": agentType, "target": target, "streaming": true}
+		stream.Items, stream.Batches, stream.Status = 0, 0, "streaming"
+	}
+	var context map[string]any
+	if !stream.done {
+		existing, _ := stream.context[field].([]any)
+		stream.context[field] = append(existing, items...)
+		stream.Items += len(items)
+		stream.Batches++
+		if stream.SessionID != "" {
+			context = maps.Clone(stream.context)
+		}
+	}
+	m.mu.Unlock()
+	if context != nil {
+		m.remember(stream.SessionID, context)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"message": "Items received", "stream_id": id, "seq": batch["seq"]})
+}
+
+func (m *devMCP) done(w http.ResponseWriter, r *http.Request) {
+	payload, ok := decodeAgentOutput(w, r, "stream_done")
+	if !ok {
+		return
+	}
+	id, _ := payload["stream_id"].(string)
+	status, _ := payload["status"].(string)
+	m.mu.Lock()
+	if stream, ok := m.streams[id]; ok {
+		stream.Status = status
+		log.Printf("dev mcp: agent stream %s %s after %d items", id, status, stream.Items)
+	}
+	m.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"message": "Stream finished", "stream_id": id})
+}
+
+func (m *devMCP) listStreams(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	streams := make([]map[string]any, 0, len(m.order))
+	for _, id := range m.order {
+		stream := m.streams[id]
+		streams = append(streams, map[string]any{"stream_id": id, "session_id": stream.SessionID,
+			"agent_type": stream.AgentType, "target": stream.Target, "items": stream.Items,
+			"batches": stream.Batches, "status": stream.Status})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"streams": streams})
+}
+
+// remember stores context in a session, logging what it cannot, as the MCP
+// server does.
+func (m *devMCP) remember(sessionID string, context map[string]any) {
+	if err := m.memory.Store(sessionID, context, -1); err != nil {
+		log.Printf("dev mcp: could not store context in session memory: %v", err)
+	}
+}
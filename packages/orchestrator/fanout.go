@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/logging"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/tracing"
+)
+
+var (
+	errUnknownFanOut = errors.New("fan-out not found")
+	errInvalidFanOut = errors.New("invalid fan-out")
+)
+
+const (
+	// statusPartial is a fan-out where some targets failed and some did not.
+	statusPartial = "partial"
+
+	keepFanOuts = 100
+)
+
+// FanOut runs one agent type over many targets at once, such as analyzing
+// 50 repositories. Its jobs run beside the worker pool, at most Concurrency
+// at a time, and are listed in /jobs like any other.
+type FanOut struct {
+	ID          string         `json:"id"`
+	AgentType   string         `json:"agent_type"`
+	SessionID   string         `json:"session_id,omitempty"`
+	Tenant      string         `json:"tenant,omitempty"`
+	Concurrency int            `json:"concurrency"`
+	Status      string         `json:"status"`
+	Total       int            `json:"total"`
+	Succeeded   int            `json:"succeeded"`
+	Failed      int            `json:"failed"`
+	Pending     int            `json:"pending"`
+	Results     []FanOutResult `json:"results,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	FinishedAt  *time.Time     `json:"finished_at,omitempty"`
+}
+
+// FanOutResult is one target's outcome.
+type FanOutResult struct {
+	Target string         `json:"target"`
+	JobID  string         `json:"job_id"`
+	Status string         `json:"status"`
+	Result map[string]any `json:"result,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+type fanOutRecord struct {
+	FanOut
+	targets []string
+	jobIDs  []string
+	// trace is the span that started the fan-out, which its report is
+	// traced under.
+	trace tracing.SpanContext
+}
+
+// FanOuts starts fan-outs and aggregates their jobs. When every job has
+// finished, the aggregate is reported to the MCP server: the succeeded
+// targets' results keyed by target, stored under the fan-out's session,
+// and the failures alongside them.
+type FanOuts struct {
+	ctx            context.Context
+	jobs           *Scheduler
+	maxConcurrency int
+	maxTargets     int
+
+	mu      sync.Mutex
+	records map[string]*fanOutRecord
+}
+
+func newFanOuts(ctx context.Context, jobs *Scheduler, maxConcurrency, maxTargets int) *FanOuts {
+	return &FanOuts{
+		ctx:            ctx,
+		jobs:           jobs,
+		maxConcurrency: maxConcurrency,
+		maxTargets:     maxTargets,
+		records:        make(map[string]*fanOutRecord),
+	}
+}
+
+// Start records a job per distinct target and runs them in the background.
+// concurrency defaults to, and is capped at, the orchestrator's maximum.
+// The jobs are traced under the current span of ctx.
+func (f *FanOuts) Start(ctx context.Context, agentType string, targets []string, concurrency int, sessionID, tenant string) (FanOut, error) {
+	var distinct []string
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if target != "" && !seen[target] {
+			seen[target] = true
+			distinct = append(distinct, target)
+		}
+	}
+	switch {
+	case len(distinct) == 0:
+		return FanOut{}, fmt.Errorf("%w: no targets", errInvalidFanOut)
+	case len(distinct) > f.maxTargets:
+		return FanOut{}, fmt.Errorf("%w: %d targets, at most %d", errInvalidFanOut, len(distinct), f.maxTargets)
+	case concurrency < 0:
+		return FanOut{}, fmt.Errorf("%w: negative concurrency", errInvalidFanOut)
+	case concurrency == 0 || concurrency > f.maxConcurrency:
+		concurrency = f.maxConcurrency
+	}
+	if tenant == "" {
+		tenant = defaultTenant
+	}
+	if err := checkTenant(tenant); err != nil {
+		return FanOut{}, err
+	}
+	// Every target is checked up front, so a bad one queues none of them
+	agent, err := f.jobs.registry.Get(agentType)
+	if err != nil {
+		return FanOut{}, err
+	}
+	for _, target := range distinct {
+		if err := agent.checkTarget(target); err != nil {
+			return FanOut{}, fmt.Errorf("%w: %v", errInvalidFanOut, err)
+		}
+	}
+
+	record := &fanOutRecord{
+		FanOut: FanOut{
+			ID:          newJobID(),
+			AgentType:   agentType,
+			SessionID:   sessionID,
+			Tenant:      tenant,
+			Concurrency: concurrency,
+			CreatedAt:   time.Now().UTC(),
+		},
+		targets: distinct,
+		trace:   tracing.SpanContextFrom(ctx),
+	}
+	// The jobs carry no session, so the MCP server stores only the aggregate
+	for _, target := range distinct {
+		job, err := f.jobs.add(ctx, agentType, target, "", tenant, nil)
+		if err != nil {
+			return FanOut{}, err
+		}
+		record.jobIDs = append(record.jobIDs, job.ID)
+	}
+
+	f.mu.Lock()
+	f.records[record.ID] = record
+	f.prune()
+	f.mu.Unlock()
+
+	go f.run(record)
+	return f.view(record), nil
+}
+
+func (f *FanOuts) run(record *fanOutRecord) {
+	slots := make(chan struct{}, record.Concurrency)
+	var wg sync.WaitGroup
+	for _, id := range record.jobIDs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			f.jobs.run(f.ctx, id)
+		}()
+	}
+	wg.Wait()
+
+	f.mu.Lock()
+	now := time.Now().UTC()
+	record.FinishedAt = &now
+	f.mu.Unlock()
+
+	fanOut := f.view(record)
+	ctx := logging.WithSession(logging.WithTenant(tracing.ContextWithSpanContext(f.ctx, record.trace), fanOut.Tenant), fanOut.SessionID)
+	slog.InfoContext(ctx, "fan-out "+fanOut.Status, "fanout_id", fanOut.ID, "agent_type", fanOut.AgentType,
+		"targets", fanOut.Total, "succeeded", fanOut.Succeeded, "failed", fanOut.Failed)
+
+	results := make(map[string]any, fanOut.Succeeded)
+	for _, result := range fanOut.Results {
+		if result.Status == statusSucceeded {
+			results[result.Target] = result.Result
+		}
+	}
+	report := struct {
+		FanOut
+		Kind   string         `json:"kind"`
+		Result map[string]any `json:"result"`
+	}{fanOut, "fanout", results}
+	if err := f.jobs.reporter.Report(ctx, report); err != nil {
+		slog.ErrorContext(ctx, "reporting to MCP server", "fanout_id", fanOut.ID, "error", err.Error())
+	}
+}
+
+// view aggregates the record's jobs as they stand.
+func (f *FanOuts) view(record *fanOutRecord) FanOut {
+	f.mu.Lock()
+	fanOut := record.FanOut
+	f.mu.Unlock()
+
+	fanOut.Total = len(record.targets)
+	fanOut.Results = make([]FanOutResult, 0, fanOut.Total)
+	for i, id := range record.jobIDs {
+		result := FanOutResult{Target: record.targets[i], JobID: id, Status: statusFailed, Error: "job was dropped"}
+		if job, ok := f.jobs.Get(id); ok {
+			result.Status, result.Result, result.Error = job.Status, job.Result, job.Error
+		}
+		switch result.Status {
+		case statusSucceeded:
+			fanOut.Succeeded++
+		case statusFailed:
+			fanOut.Failed++
+		default:
+			fanOut.Pending++
+		}
+		fanOut.Results = append(fanOut.Results, result)
+	}
+
+	switch {
+	case fanOut.Pending > 0 || fanOut.FinishedAt == nil:
+		fanOut.Status = statusRunning
+	case fanOut.Failed == 0:
+		fanOut.Status = statusSucceeded
+	case fanOut.Succeeded == 0:
+		fanOut.Status = statusFailed
+	default:
+		fanOut.Status = statusPartial
+	}
+	return fanOut
+}
+
+func (f *FanOuts) Get(id string) (FanOut, error) {
+	f.mu.Lock()
+	record, ok := f.records[id]
+	f.mu.Unlock()
+	if !ok {
+		return FanOut{}, fmt.Errorf("%w: %s", errUnknownFanOut, id)
+	}
+	return f.view(record), nil
+}
+
+// List returns fan-outs newest first, without their per-target results.
+func (f *FanOuts) List() []FanOut {
+	f.mu.Lock()
+	records := make([]*fanOutRecord, 0, len(f.records))
+	for _, record := range f.records {
+		records = append(records, record)
+	}
+	f.mu.Unlock()
+
+	fanOuts := make([]FanOut, 0, len(records))
+	for _, record := range records {
+		fanOut := f.view(record)
+		fanOut.Results = nil
+		fanOuts = append(fanOuts, fanOut)
+	}
+	sort.Slice(fanOuts, func(i, j int) bool { return fanOuts[i].CreatedAt.After(fanOuts[j].CreatedAt) })
+	return fanOuts
+}
+
+// prune drops the oldest finished fan-outs past keepFanOuts; f.mu must be
+// held.
+func (f *FanOuts) prune() {
+	if len(f.records) <= keepFanOuts {
+		return
+	}
+	var finished []*fanOutRecord
+	for _, record := range f.records {
+		if record.FinishedAt != nil {
+			finished = append(finished, record)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+	for _, record := range finished[:min(len(finished), len(f.records)-keepFanOuts)] {
+		delete(f.records, record.ID)
+	}
+}
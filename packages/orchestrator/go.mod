@@ -0,0 +1,28 @@
+module github.com/jayp41/dynamic-context-mcp-system/packages/orchestrator
+
+go 1.22
+
+require (
+	github.com/jayp41/dynamic-context-mcp-system/packages/events v0.0.0
+	github.com/jayp41/dynamic-context-mcp-system/packages/logging v0.0.0
+	github.com/jayp41/dynamic-context-mcp-system/packages/tracing v0.0.0
+	modernc.org/sqlite v1.34.5
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+)
+
+replace github.com/jayp41/dynamic-context-mcp-system/packages/events => ../events
+
+replace github.com/jayp41/dynamic-context-mcp-system/packages/logging => ../logging
+
+replace github.com/jayp41/dynamic-context-mcp-system/packages/tracing => ../tracing
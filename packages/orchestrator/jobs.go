@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/logging"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/tracing"
+)
+
+var errQueueFull = errors.New("job queue is full")
+
+const (
+	statusQueued    = "queued"
+	statusRunning   = "running"
+	statusRetrying  = "retrying"
+	statusSucceeded = "succeeded"
+	statusFailed    = "failed"
+)
+
+// Job is one request to gather context about Target with an agent type,
+// on behalf of Tenant, whose budgets it counts against. Errors has each
+// failed attempt's error, oldest first. input, when set, is written to the
+// agent's stdin. Traceparent is the span that submitted the job, which its
+// run is traced under.
+type Job struct {
+	ID            string         `json:"id"`
+	AgentType     string         `json:"agent_type"`
+	Target        string         `json:"target"`
+	SessionID     string         `json:"session_id,omitempty"`
+	Tenant        string         `json:"tenant"`
+	Priority      string         `json:"priority,omitempty"`
+	Status        string         `json:"status"`
+	Limits        *Limits        `json:"limits,omitempty"`
+	Result        map[string]any `json:"result,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	Attempts      int            `json:"attempts"`
+	Errors        []string       `json:"errors,omitempty"`
+	NextAttemptAt *time.Time     `json:"next_attempt_at,omitempty"`
+	Reported      bool           `json:"reported"`
+	CreatedAt     time.Time      `json:"created_at"`
+	StartedAt     *time.Time     `json:"started_at,omitempty"`
+	FinishedAt    *time.Time     `json:"finished_at,omitempty"`
+	Traceparent   string         `json:"traceparent,omitempty"`
+
+	input []byte
+}
+
+// retryPolicy is how often a failed job is tried again, and how long it
+// waits first: backoff before the first retry, doubling for each one after,
+// up to maxBackoff.
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+const maxBackoff = 5 * time.Minute
+
+func (p retryPolicy) delay(attempt int) time.Duration {
+	delay := p.backoff
+	for range attempt - 1 {
+		if delay *= 2; delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+// retryable reports whether running the job again could help: not for an
+// agent type that is gone, one that exited saying it was run wrongly, or
+// one whose result broke the agent output schema, as it would again, nor
+// for one whose budget is used up.
+func retryable(err error) bool {
+	var exit *exitError
+	if errors.As(err, &exit) && exit.status == exitUsage {
+		return false
+	}
+	return !errors.Is(err, errUnknownAgent) && !errors.Is(err, errInvalidOutput) && !errors.Is(err, errBudgetExceeded)
+}
+
+// Scheduler queues jobs and runs them on a fixed pool of workers, highest
+// priority first. Jobs are kept in memory; finished ones past keepJobs are
+// dropped oldest first. A failed job is retried by the same worker after its
+// backoff, and one that fails on its last attempt is kept in the dead
+// letters. A result that does not match the agent output schema fails its
+// job and is quarantined. Each attempt is recorded in telemetry and costs,
+// and a job whose budget is used up is refused, or fails if it was already
+// queued. Each job is a span, with a span per attempt that the agent's own
+// spans are children of.
+type Scheduler struct {
+	registry    *Registry
+	runtime     Runtime
+	reporter    *Reporter
+	deadLetters *DeadLetters
+	quarantine  *Quarantine
+	telemetry   *Telemetry
+	costs       *Costs
+	tracer      *tracing.Tracer
+	keepJobs    int
+	// streamURL is where agents stream context as they find it; empty
+	// turns streaming off.
+	streamURL string
+	// flags are the feature flags agents are told of, from the config
+	// service; nil without one.
+	flags *RemoteConfig
+
+	queue *jobQueue
+	mu    sync.RWMutex
+	jobs  map[string]*Job
+	// limits and retry change with the orchestrator's settings, under mu.
+	limits Limits
+	retry  retryPolicy
+}
+
+func newScheduler(registry *Registry, runtime Runtime, reporter *Reporter, deadLetters *DeadLetters, telemetry *Telemetry, costs *Costs, tracer *tracing.Tracer, streamURL string, limits Limits, retry retryPolicy, queue *jobQueue) *Scheduler {
+	return &Scheduler{
+		registry:    registry,
+		runtime:     runtime,
+		reporter:    reporter,
+		deadLetters: deadLetters,
+		quarantine:  newQuarantine(),
+		telemetry:   telemetry,
+		costs:       costs,
+		tracer:      tracer,
+		limits:      limits,
+		retry:       retry,
+		keepJobs:    1000,
+		streamURL:   streamURL,
+		queue:       queue,
+		jobs:        make(map[string]*Job),
+	}
+}
+
+// Limits are the defaults for agent types without limits of their own.
+func (s *Scheduler) Limits() Limits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.limits
+}
+
+func (s *Scheduler) RetryPolicy() retryPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.retry
+}
+
+// SetPolicy changes the default limits and the retry policy for the
+// attempts that start after it.
+func (s *Scheduler) SetPolicy(limits Limits, retry retryPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits, s.retry = limits, retry
+}
+
+// Start runs workers until ctx is done.
+func (s *Scheduler) Start(ctx context.Context, workers int) {
+	for range workers {
+		go func() {
+			for {
+				id, ok := s.queue.Pop(ctx)
+				if !ok {
+					return
+				}
+				s.run(ctx, id)
+			}
+		}()
+	}
+}
+
+// Submit queues a job for a registered agent type, at normal priority
+// unless it names another. The job is traced under the current span of ctx.
+func (s *Scheduler) Submit(ctx context.Context, agentType, target, sessionID, tenant, priority string) (Job, error) {
+	return s.submit(ctx, agentType, target, sessionID, tenant, priority, nil)
+}
+
+func (s *Scheduler) submit(ctx context.Context, agentType, target, sessionID, tenant, priority string, input []byte) (Job, error) {
+	priority, err := parsePriority(priority, priorityNormal)
+	if err != nil {
+		return Job{}, err
+	}
+	job, err := s.add(ctx, agentType, target, sessionID, tenant, input)
+	if err != nil {
+		return Job{}, err
+	}
+	job = s.update(job.ID, func(job *Job) { job.Priority = priority })
+	if !s.queue.Push(job.ID, priority) {
+		s.mu.Lock()
+		delete(s.jobs, job.ID)
+		s.mu.Unlock()
+		return Job{}, errQueueFull
+	}
+	return job, nil
+}
+
+// add records a queued job without handing it to the workers, for callers
+// that run it themselves.
+func (s *Scheduler) add(ctx context.Context, agentType, target, sessionID, tenant string, input []byte) (Job, error) {
+	agent, err := s.registry.Get(agentType)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := agent.checkTarget(target); err != nil {
+		return Job{}, err
+	}
+	if tenant == "" {
+		tenant = defaultTenant
+	}
+	if err := checkTenant(tenant); err != nil {
+		return Job{}, err
+	}
+	if err := s.costs.Check(tenant, agentType); err != nil {
+		return Job{}, err
+	}
+	job := &Job{
+		ID:          newJobID(),
+		AgentType:   agentType,
+		Target:      target,
+		SessionID:   sessionID,
+		Tenant:      tenant,
+		Status:      statusQueued,
+		CreatedAt:   time.Now().UTC(),
+		Traceparent: tracing.SpanContextFrom(ctx).Traceparent(),
+		input:       input,
+	}
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.prune()
+	s.mu.Unlock()
+	return *job, nil
+}
+
+func (s *Scheduler) Get(id string) (Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List returns jobs newest first, optionally only those with a status.
+func (s *Scheduler) List(status string) []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if status == "" || job.Status == status {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Counts returns the number of jobs in each status.
+func (s *Scheduler) Counts() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := map[string]int{statusQueued: 0, statusRunning: 0, statusRetrying: 0, statusSucceeded: 0, statusFailed: 0}
+	for _, job := range s.jobs {
+		counts[job.Status]++
+	}
+	return counts
+}
+
+// prune drops the oldest finished jobs past keepJobs; s.mu must be held.
+func (s *Scheduler) prune() {
+	if len(s.jobs) <= s.keepJobs {
+		return
+	}
+	var finished []*Job
+	for _, job := range s.jobs {
+		if job.FinishedAt != nil {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+	for _, job := range finished[:min(len(finished), len(s.jobs)-s.keepJobs)] {
+		delete(s.jobs, job.ID)
+	}
+}
+
+func (s *Scheduler) update(id string, change func(job *Job)) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.jobs[id]
+	change(job)
+	return *job
+}
+
+// Requeue submits a dead letter's job again, as a new job, and drops the
+// dead letter.
+func (s *Scheduler) Requeue(ctx context.Context, jobID string) (Job, error) {
+	letter, err := s.deadLetters.Get(jobID)
+	if err != nil {
+		return Job{}, err
+	}
+	job, err := s.submit(ctx, letter.AgentType, letter.Target, letter.SessionID, letter.Tenant, letter.Priority, letter.Input)
+	if err != nil {
+		return Job{}, err
+	}
+	if _, err := s.deadLetters.Remove(jobID); err != nil {
+		log.Printf("dead letter %s: %v", jobID, err)
+	}
+	log.Printf("dead letter %s requeued as job %s", jobID, job.ID)
+	return job, nil
+}
+
+func (s *Scheduler) run(ctx context.Context, id string) {
+	queued, _ := s.Get(id)
+	parent, _ := tracing.ParseTraceparent(queued.Traceparent)
+	ctx, span := s.tracer.Start(tracing.ContextWithSpanContext(ctx, parent), "job "+queued.AgentType, tracing.KindInternal)
+	defer span.End()
+	span.SetAttribute("job.id", id)
+	span.SetAttribute("agent.type", queued.AgentType)
+	span.SetAttribute("tenant", queued.Tenant)
+	ctx = logging.WithSession(logging.WithTenant(ctx, queued.Tenant), queued.SessionID)
+
+	var result map[string]any
+	var err error
+	for attempt := 1; ; attempt++ {
+		job := s.update(id, func(job *Job) {
+			now := time.Now().UTC()
+			job.Status, job.Attempts, job.NextAttemptAt = statusRunning, attempt, nil
+			if job.StartedAt == nil {
+				job.StartedAt = &now
+			}
+		})
+		s.publishStatus(ctx, job)
+		startedAt := time.Now().UTC()
+		result, err = s.execute(ctx, id, job)
+		policy := s.RetryPolicy()
+		retry := err != nil && attempt <= policy.retries && retryable(err) && ctx.Err() == nil
+		s.record(job, attempt, startedAt, result, err, retry)
+		if !retry {
+			break
+		}
+
+		wait := policy.delay(attempt)
+		s.publishStatus(ctx, s.update(id, func(job *Job) {
+			next := time.Now().UTC().Add(wait)
+			job.Status, job.Error, job.NextAttemptAt = statusRetrying, err.Error(), &next
+			job.Errors = append(job.Errors, err.Error())
+		}))
+		slog.WarnContext(ctx, "job attempt failed, retrying", "job_id", id, "agent_type", job.AgentType, "target", job.Target,
+			"attempt", attempt, "retry_in", wait.String(), "error", err.Error())
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+
+	job := s.update(id, func(job *Job) {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		if err != nil {
+			job.Status, job.Error = statusFailed, err.Error()
+			job.Errors = append(job.Errors, err.Error())
+		} else {
+			job.Status, job.Result, job.Error = statusSucceeded, result, ""
+		}
+	})
+	if job.Status == statusFailed {
+		slog.ErrorContext(ctx, "job failed", "job_id", job.ID, "agent_type", job.AgentType, "target", job.Target,
+			"attempts", job.Attempts, "error", job.Error)
+	} else {
+		slog.InfoContext(ctx, "job succeeded", "job_id", job.ID, "agent_type", job.AgentType, "target", job.Target,
+			"attempts", job.Attempts)
+	}
+	span.SetAttribute("job.attempts", job.Attempts)
+	span.RecordError(err)
+
+	if job.Status == statusFailed {
+		letter := DeadLetter{JobID: job.ID, AgentType: job.AgentType, Target: job.Target, SessionID: job.SessionID,
+			Tenant: job.Tenant, Priority: job.Priority, Input: job.input, Error: job.Error, Attempts: job.Attempts, Errors: job.Errors, FailedAt: *job.FinishedAt}
+		if err := s.deadLetters.Add(letter); err != nil {
+			slog.ErrorContext(ctx, "saving dead letter", "job_id", job.ID, "error", err.Error())
+		}
+	}
+
+	if err := s.reporter.Publish(ctx, job); err != nil {
+		slog.ErrorContext(ctx, "publishing to the event bus", "job_id", job.ID, "error", err.Error())
+	}
+	if err := s.reporter.Report(ctx, job); err != nil {
+		slog.ErrorContext(ctx, "reporting to MCP server", "job_id", job.ID, "error", err.Error())
+		return
+	}
+	if s.reporter.Enabled() {
+		s.update(id, func(job *Job) { job.Reported = true })
+	}
+}
+
+// publishStatus puts the job's new status on the event bus. A job is not
+// held up by a bus it cannot reach.
+func (s *Scheduler) publishStatus(ctx context.Context, job Job) {
+	if err := s.reporter.PublishStatus(ctx, job); err != nil {
+		slog.WarnContext(ctx, "publishing job status to the event bus", "job_id", job.ID, "status", job.Status, "error", err.Error())
+	}
+}
+
+func (s *Scheduler) record(job Job, attempt int, startedAt time.Time, result map[string]any, err error, retried bool) {
+	if errors.Is(err, errBudgetExceeded) {
+		// The agent never ran
+		return
+	}
+	finishedAt := time.Now().UTC()
+	run := AgentRun{JobID: job.ID, AgentType: job.AgentType, Target: job.Target, Tenant: job.Tenant, Attempt: attempt,
+		Status: statusSucceeded, Duration: round(finishedAt.Sub(startedAt).Seconds()), Retried: retried,
+		StartedAt: startedAt, FinishedAt: finishedAt}
+	if err != nil {
+		run.Status, run.Error = statusFailed, err.Error()
+	} else {
+		run.readMetrics(result)
+		s.costs.Price(&run)
+	}
+	s.telemetry.Record(run)
+	s.costs.Record(run)
+}
+
+func (s *Scheduler) execute(ctx context.Context, id string, job Job) (result map[string]any, err error) {
+	ctx, span := s.tracer.Start(ctx, "run "+job.AgentType, tracing.KindInternal)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttribute("job.attempt", job.Attempts)
+
+	agent, err := s.registry.Get(job.AgentType)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.costs.Check(job.Tenant, job.AgentType); err != nil {
+		return nil, err
+	}
+	agent.Limits = s.Limits().with(agent.Limits)
+	s.update(id, func(job *Job) { job.Limits = &agent.Limits })
+
+	ctx, cancel := context.WithTimeout(ctx, agent.Limits.timeout())
+	defer cancel()
+
+	output, err := s.runtime.Run(ctx, s.withJobEnv(ctx, agent, job), job)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("agent was killed after its %s time limit", agent.Limits.timeout())
+	}
+	if err != nil {
+		return nil, err
+	}
+	result, err = parseResult(output)
+	if err != nil {
+		return nil, err
+	}
+	if err := agentOutput.Validate("result", result); err != nil {
+		var invalid *invalidOutputError
+		if errors.As(err, &invalid) {
+			s.quarantine.Add(Quarantined{JobID: job.ID, AgentType: job.AgentType, Target: job.Target, SessionID: job.SessionID,
+				Violations: invalid.violations, Payload: result, QuarantinedAt: time.Now().UTC()})
+			return nil, fmt.Errorf("%w; the result is quarantined at GET /quarantine/%s", err, job.ID)
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// withJobEnv tells the agent which job it runs, where to stream the
+// context it finds and which event bus to publish graph nodes on, unless
+// its own env says otherwise. The tenant it works for is always the job's,
+// as are the feature flags on for that tenant, and its spans are children
+// of the attempt's, the current span of ctx.
+func (s *Scheduler) withJobEnv(ctx context.Context, agent AgentType, job Job) AgentType {
+	env := map[string]string{"AGENT_JOB_ID": job.ID}
+	if job.SessionID != "" {
+		env["AGENT_SESSION_ID"] = job.SessionID
+	}
+	if s.streamURL != "" {
+		env["MCP_SERVER_URL"] = s.streamURL
+	}
+	if s.reporter.busURL != "" {
+		env["EVENT_BUS_URL"] = s.reporter.busURL
+	}
+	for name, value := range agent.Env {
+		env[name] = value
+	}
+	env["AGENT_TENANT"] = job.Tenant
+	env["AGENT_FLAGS"] = strings.Join(s.flags.Flags(job.Tenant), ",")
+	if traceparent := tracing.SpanContextFrom(ctx).Traceparent(); traceparent != "" {
+		env[tracing.EnvVar] = traceparent
+	}
+	agent.Env = env
+	return agent
+}
+
+// parseResult finds the JSON object an agent prints after its progress
+// lines: everything from the first line that starts with "{".
+func parseResult(output []byte) (map[string]any, error) {
+	start := 0
+	for start < len(output) && output[start] != '{' {
+		next := bytes.IndexByte(output[start:], '\n')
+		if next < 0 {
+			start = len(output)
+			break
+		}
+		start += next + 1
+	}
+	if start == len(output) {
+		return nil, errors.New("agent printed no JSON result")
+	}
+	var result map[string]any
+	if err := json.NewDecoder(bytes.NewReader(output[start:])).Decode(&result); err != nil {
+		return nil, fmt.Errorf("agent result: %w", err)
+	}
+	return result, nil
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
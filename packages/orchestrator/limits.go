@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minMemory is the smallest memory limit Docker accepts.
+const minMemory = 6 << 20
+
+var memoryPattern = regexp.MustCompile(`^(\d+)([bkmg]?)$`)
+
+// Limits bound what one agent run may use. An agent type's limits override
+// the orchestrator's defaults field by field; zero leaves the default.
+type Limits struct {
+	// CPUs is a number of cores, such as 0.5. Only containers are held to it.
+	CPUs float64 `json:"cpus,omitempty"`
+	// Memory is Docker's format: a number with an optional b, k, m or g.
+	Memory string `json:"memory,omitempty"`
+	// PIDs caps the processes and threads an agent container may start.
+	PIDs int `json:"pids,omitempty"`
+	// Timeout is the wall-clock limit in seconds, after which the agent is
+	// killed.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// parseMemory returns a memory limit in bytes.
+func parseMemory(memory string) (int64, error) {
+	match := memoryPattern.FindStringSubmatch(strings.ToLower(memory))
+	if match == nil {
+		return 0, fmt.Errorf("memory %q is not a number with an optional b, k, m or g", memory)
+	}
+	n, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("memory %q: %w", memory, err)
+	}
+	shift := map[string]uint{"": 0, "b": 0, "k": 10, "m": 20, "g": 30}[match[2]]
+	if n > (1<<62)>>shift {
+		return 0, fmt.Errorf("memory %q is too large", memory)
+	}
+	bytes := n << shift
+	if bytes < minMemory {
+		return 0, fmt.Errorf("memory %q is below the 6m minimum", memory)
+	}
+	return bytes, nil
+}
+
+func (l Limits) validate() error {
+	if l.CPUs < 0 || l.PIDs < 0 || l.Timeout < 0 {
+		return fmt.Errorf("cpus, pids and timeout cannot be negative")
+	}
+	if l.Memory != "" {
+		if _, err := parseMemory(l.Memory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// with returns l with the fields override sets.
+func (l Limits) with(override Limits) Limits {
+	if override.CPUs > 0 {
+		l.CPUs = override.CPUs
+	}
+	if override.Memory != "" {
+		l.Memory = override.Memory
+	}
+	if override.PIDs > 0 {
+		l.PIDs = override.PIDs
+	}
+	if override.Timeout > 0 {
+		l.Timeout = override.Timeout
+	}
+	return l
+}
+
+func (l Limits) timeout() time.Duration {
+	return time.Duration(l.Timeout) * time.Second
+}
+
+// memoryBytes is the memory limit in bytes, or 0 for none. Limits are
+// validated when set, so the error is not checked again.
+func (l Limits) memoryBytes() int64 {
+	if l.Memory == "" {
+		return 0
+	}
+	bytes, _ := parseMemory(l.Memory)
+	return bytes
+}
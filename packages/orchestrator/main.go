@@ -0,0 +1,265 @@
+// Command orchestrator keeps a registry of micro agent types, accepts
+// gather-context jobs over HTTP, on cron-style schedules or as the steps of
+// pipelines, launches the matching agent for each one and reports the
+// result to the MCP server. See README.md.
+//
+// With arguments it is instead a client for a running orchestrator; see
+// cli.go. "orchestrator dev" runs it with stand-ins for the services around
+// it, in one process; see dev.go.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/logging"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/tracing"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "dev" {
+		if err := runDev(os.Args[2:]); err != nil {
+			var exit *exitError
+			if errors.As(err, &exit) {
+				fmt.Fprintln(os.Stderr, exit.message)
+				os.Exit(exit.status)
+			}
+			log.Fatalf("orchestrator: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 {
+		if err := runCLI(os.Args[1:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "orchestrator: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := run(nil); err != nil {
+		log.Fatalf("orchestrator: %v", err)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getenvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, value)
+	}
+	return n, nil
+}
+
+// openRuntime builds the agent runtime selected by ORCH_RUNTIME.
+func openRuntime(kind string, secrets *SecretStore) (Runtime, error) {
+	switch kind {
+	case "container":
+		return containerRuntime{cli: getenv("ORCH_CONTAINER_CLI", "docker"), network: os.Getenv("ORCH_NETWORK"), secrets: secrets}, nil
+	case "exec":
+		return execRuntime{stateDir: getenv("ORCH_AGENT_STATE", filepath.Join(os.TempDir(), "orchestrator-state")), secrets: secrets}, nil
+	}
+	return nil, fmt.Errorf("unknown agent runtime: %s", kind)
+}
+
+// defaultLimits are the limits for agent types that do not set their own.
+func defaultLimits() (Limits, error) {
+	timeout, err := getenvInt("ORCH_JOB_TIMEOUT", 300)
+	if err != nil {
+		return Limits{}, err
+	}
+	if timeout <= 0 {
+		return Limits{}, fmt.Errorf("ORCH_JOB_TIMEOUT must be positive, got %d", timeout)
+	}
+	pids, err := getenvInt("ORCH_AGENT_PIDS", 256)
+	if err != nil {
+		return Limits{}, err
+	}
+	cpus, err := strconv.ParseFloat(getenv("ORCH_AGENT_CPUS", "1"), 64)
+	if err != nil || cpus <= 0 {
+		return Limits{}, fmt.Errorf("invalid ORCH_AGENT_CPUS: %q", os.Getenv("ORCH_AGENT_CPUS"))
+	}
+	limits := Limits{CPUs: cpus, Memory: getenv("ORCH_AGENT_MEMORY", "512m"), PIDs: pids, Timeout: timeout}
+	if err := limits.validate(); err != nil {
+		return Limits{}, fmt.Errorf("invalid ORCH_AGENT_MEMORY: %w", err)
+	}
+	return limits, nil
+}
+
+// retryPolicyFromEnv reads ORCH_JOB_RETRIES, where zero turns retries off,
+// and ORCH_RETRY_BACKOFF in seconds.
+func retryPolicyFromEnv() (retryPolicy, error) {
+	retries, err := strconv.Atoi(getenv("ORCH_JOB_RETRIES", "2"))
+	if err != nil || retries < 0 {
+		return retryPolicy{}, fmt.Errorf("invalid ORCH_JOB_RETRIES: %q", os.Getenv("ORCH_JOB_RETRIES"))
+	}
+	backoff, err := getenvInt("ORCH_RETRY_BACKOFF", 5)
+	if err != nil {
+		return retryPolicy{}, err
+	}
+	return retryPolicy{retries: retries, backoff: time.Duration(backoff) * time.Second}, nil
+}
+
+// run runs the orchestrator until it is stopped. In dev mode, dev is what it
+// serves beside its own API.
+func run(dev *devStack) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := logging.FromEnv("orchestrator")
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		logger.Shutdown(shutdownCtx)
+	}()
+
+	// Settings from the config service are in the environment before
+	// anything reads it
+	var remote *RemoteConfig
+	if configURL := os.Getenv("CONFIG_URL"); configURL != "" {
+		var err error
+		remote, err = pullConfig(ctx, configURL, getenv("CONFIG_COMPONENT", "orchestrator"), os.Getenv("CONFIG_TOKEN"))
+		if err != nil {
+			return err
+		}
+	}
+
+	registry, err := loadRegistry(os.Getenv("ORCH_AGENTS"))
+	if err != nil {
+		return fmt.Errorf("loading agent registry: %w", err)
+	}
+	puller := newOCIPuller(os.Getenv("ORCH_REGISTRY_USER"), os.Getenv("ORCH_REGISTRY_PASSWORD"),
+		getenv("ORCH_REGISTRY_PLAIN_HTTP", "false") == "true")
+	secrets := newSecretStore(getenv("ORCH_SECRETS_DIR", "/run/secrets"), registry)
+	manifests := newManifests(registry, secrets, os.Getenv("ORCH_MANIFESTS"), splitRefs(os.Getenv("ORCH_MANIFEST_REFS")), puller)
+	if err := manifests.Reload(ctx); err != nil {
+		return fmt.Errorf("loading agent manifests: %w", err)
+	}
+	runtime, err := openRuntime(getenv("ORCH_RUNTIME", "container"), secrets)
+	if err != nil {
+		return err
+	}
+	workers, err := getenvInt("ORCH_WORKERS", 2)
+	if err != nil {
+		return err
+	}
+	queueSize, err := getenvInt("ORCH_QUEUE_SIZE", 100)
+	if err != nil {
+		return err
+	}
+	aging, err := getenvInt("ORCH_PRIORITY_AGING", 60)
+	if err != nil {
+		return err
+	}
+	limits, err := defaultLimits()
+	if err != nil {
+		return err
+	}
+	retry, err := retryPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	deadLetters, err := openDeadLetters(os.Getenv("ORCH_DEAD_LETTERS"))
+	if err != nil {
+		return fmt.Errorf("loading dead letters: %w", err)
+	}
+	runHistory, err := getenvInt("ORCH_RUN_HISTORY", 5000)
+	if err != nil {
+		return err
+	}
+	costs, err := openCosts(os.Getenv("ORCH_COSTS"), os.Getenv("ORCH_COST_LEDGER"))
+	if err != nil {
+		return fmt.Errorf("loading costs: %w", err)
+	}
+	fanOutConcurrency, err := getenvInt("ORCH_FANOUT_CONCURRENCY", 8)
+	if err != nil {
+		return err
+	}
+	fanOutTargets, err := getenvInt("ORCH_FANOUT_MAX_TARGETS", 500)
+	if err != nil {
+		return err
+	}
+
+	tracer := tracing.FromEnv("orchestrator")
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		tracer.Shutdown(shutdownCtx)
+	}()
+	reporter := newReporter(os.Getenv("MCP_SERVER_URL"), os.Getenv("EVENT_BUS_URL"), tracer)
+	streamURL := ""
+	if getenv("ORCH_STREAM_RESULTS", "true") != "false" {
+		streamURL = reporter.url
+	}
+	telemetry := newTelemetry(runHistory)
+	scheduler := newScheduler(registry, runtime, reporter, deadLetters, telemetry, costs, tracer, streamURL, limits, retry, newJobQueue(queueSize, time.Duration(aging)*time.Second))
+	scheduler.flags = remote
+	scheduler.Start(ctx, workers)
+	if remote != nil {
+		go remote.Watch(ctx, func(keys []string) { reconfigure(scheduler, keys) })
+	}
+
+	schedules := newSchedules(scheduler, registry)
+	if err := schedules.load(os.Getenv("ORCH_SCHEDULES")); err != nil {
+		return fmt.Errorf("loading schedules: %w", err)
+	}
+	schedules.Start(ctx)
+
+	fanOuts := newFanOuts(ctx, scheduler, fanOutConcurrency, fanOutTargets)
+
+	artifacts := getenv("ORCH_ARTIFACTS", filepath.Join(os.TempDir(), "orchestrator-artifacts"))
+	pipelines := newPipelines(ctx, scheduler, registry, artifacts)
+	if err := pipelines.load(os.Getenv("ORCH_PIPELINES")); err != nil {
+		return fmt.Errorf("loading pipelines: %w", err)
+	}
+
+	s := &server{registry: registry, manifests: manifests, scheduler: scheduler, schedules: schedules, fanOuts: fanOuts, pipelines: pipelines, runtime: runtime, config: remote}
+	handler := s.routes()
+	if dev != nil {
+		handler = dev.routes(handler)
+	}
+	handler = tracer.Middleware(handler)
+	httpServer := &http.Server{
+		Addr:              ":" + getenv("ORCH_PORT", "8070"),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("orchestrator listening on %s (runtime %s, %d agent types, %d workers, %d schedules, %s, %s)",
+			httpServer.Addr, runtime.Name(), len(registry.List()), workers, len(schedules.List()), tracer, logger)
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
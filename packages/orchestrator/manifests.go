@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestVersion is the agent manifest format this orchestrator reads.
+const manifestVersion = 1
+
+// maxManifestSize bounds a manifest read from disk or pulled from a registry.
+const maxManifestSize = 1 << 20
+
+var errInvalidManifest = errors.New("invalid agent manifest")
+
+// Manifest describes a third-party agent: the image to run and how, the
+// JSON Schema its targets must match, the secrets it needs from the
+// orchestrator's environment and the resources it may use. Manifests are
+// read from ORCH_MANIFESTS, pulled from an OCI registry or posted to
+// /manifests, and each becomes an agent type.
+type Manifest struct {
+	ManifestVersion int    `json:"manifest_version"`
+	Name            string `json:"name"`
+	Version         string `json:"version,omitempty"`
+	Description     string `json:"description,omitempty"`
+	Homepage        string `json:"homepage,omitempty"`
+	Image           string `json:"image"`
+	// Entrypoint replaces the image's entrypoint; the job's target is still
+	// passed as the last argument.
+	Entrypoint []string          `json:"entrypoint,omitempty"`
+	Input      map[string]any    `json:"input,omitempty"`
+	Secrets    []ManifestSecret  `json:"secrets,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	State      bool              `json:"state,omitempty"`
+	Limits     Limits            `json:"limits,omitempty"`
+}
+
+// ManifestSecret is an environment variable the agent reads. A manifest
+// whose required secrets the orchestrator does not have is not loaded,
+// rather than failing every job later.
+type ManifestSecret struct {
+	Name string `json:"name"`
+	// From is the secret in the orchestrator's secret store the variable is
+	// bound to, when it is not called Name there too.
+	From        string `json:"from,omitempty"`
+	Description string `json:"description,omitempty"`
+	Optional    bool   `json:"optional,omitempty"`
+}
+
+// parseManifest decodes and checks a manifest without loading it, so it also
+// serves `orchestrator manifests validate`.
+func parseManifest(raw []byte) (Manifest, error) {
+	var m Manifest
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		return Manifest{}, fmt.Errorf("%w: %v", errInvalidManifest, err)
+	}
+	if m.ManifestVersion != manifestVersion {
+		return Manifest{}, fmt.Errorf("%w: manifest_version is %d, want %d", errInvalidManifest, m.ManifestVersion, manifestVersion)
+	}
+	if m.Image == "" {
+		return Manifest{}, fmt.Errorf("%w: %s has no image", errInvalidManifest, m.Name)
+	}
+	if err := m.agentType("").validate(); err != nil {
+		return Manifest{}, fmt.Errorf("%w: %v", errInvalidManifest, err)
+	}
+	return m, nil
+}
+
+func (m Manifest) agentType(source string) AgentType {
+	agent := AgentType{
+		Name:        m.Name,
+		Description: m.Description,
+		Version:     m.Version,
+		Source:      source,
+		Image:       m.Image,
+		Command:     m.Entrypoint,
+		Env:         m.Env,
+		State:       m.State,
+		Input:       m.Input,
+		Limits:      m.Limits,
+	}
+	for _, secret := range m.Secrets {
+		agent.Secrets = append(agent.Secrets, SecretBinding{Name: secret.Name, From: secret.From})
+	}
+	return agent
+}
+
+// missingSecrets lists the required secrets the secret store does not have.
+func (m Manifest) missingSecrets(store *SecretStore) []string {
+	var missing []string
+	for _, secret := range m.Secrets {
+		binding := SecretBinding{Name: secret.Name, From: secret.From}
+		if _, ok := store.Lookup(binding.source()); !ok && !secret.Optional {
+			missing = append(missing, binding.source())
+		}
+	}
+	return missing
+}
+
+// ManifestLoad is the outcome of loading one manifest: the agent type it
+// registered, or why it was not loaded.
+type ManifestLoad struct {
+	Source   string    `json:"source"`
+	Name     string    `json:"name,omitempty"`
+	Version  string    `json:"version,omitempty"`
+	Digest   string    `json:"digest,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// Manifests loads agent manifests into the registry: every *.json file in
+// dir and every OCI reference in refs, on start and on each Reload, plus
+// any installed through the API. A reload drops the agent types of
+// manifests that have gone from dir or refs.
+type Manifests struct {
+	registry *Registry
+	secrets  *SecretStore
+	dir      string
+	refs     []string
+	puller   *ociPuller
+
+	mu    sync.Mutex
+	loads map[string]ManifestLoad
+	// managed maps the sources dir and refs loaded to their agent types.
+	managed map[string]string
+}
+
+func newManifests(registry *Registry, secrets *SecretStore, dir string, refs []string, puller *ociPuller) *Manifests {
+	return &Manifests{
+		registry: registry,
+		secrets:  secrets,
+		dir:      dir,
+		refs:     refs,
+		puller:   puller,
+		loads:    make(map[string]ManifestLoad),
+		managed:  make(map[string]string),
+	}
+}
+
+// Reload loads the manifests in dir and refs again. A manifest that fails is
+// logged and listed with its error, and does not stop the others.
+func (m *Manifests) Reload(ctx context.Context) error {
+	var paths []string
+	if m.dir != "" {
+		var err error
+		if paths, err = filepath.Glob(filepath.Join(m.dir, "*.json")); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	previous := m.managed
+	m.managed = make(map[string]string)
+	m.mu.Unlock()
+
+	for _, path := range paths {
+		raw, err := readManifestFile(path)
+		if err != nil {
+			log.Printf("manifest %s: %v", path, err)
+			m.record(ManifestLoad{Source: path, Error: err.Error()})
+			continue
+		}
+		m.install(path, raw, "", true)
+	}
+	for _, ref := range m.refs {
+		m.Pull(ctx, ref, true)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for source, name := range previous {
+		if _, ok := m.managed[source]; ok {
+			continue
+		}
+		if agent, err := m.registry.Get(name); err == nil && agent.Source == source {
+			m.registry.Remove(name)
+			log.Printf("manifest %s no longer loads; removed agent type %s", source, name)
+		}
+		if !slices.Contains(paths, source) && !slices.Contains(m.refs, source) {
+			delete(m.loads, source)
+		}
+	}
+	return nil
+}
+
+// Pull fetches a manifest from an OCI registry and loads it.
+func (m *Manifests) Pull(ctx context.Context, ref string, managed bool) (AgentType, error) {
+	raw, digest, err := m.puller.Pull(ctx, ref)
+	if err != nil {
+		log.Printf("manifest %s: %v", ref, err)
+		m.record(ManifestLoad{Source: ref, Error: err.Error()})
+		return AgentType{}, err
+	}
+	return m.install(ref, raw, digest, managed)
+}
+
+// Install loads a manifest given in full, as posted to /manifests.
+func (m *Manifests) Install(source string, raw []byte) (AgentType, error) {
+	return m.install(source, raw, "", false)
+}
+
+func (m *Manifests) install(source string, raw []byte, digest string, managed bool) (AgentType, error) {
+	load := ManifestLoad{Source: source, Digest: digest}
+	agent, err := m.register(source, raw)
+	if err != nil {
+		load.Error = err.Error()
+		log.Printf("manifest %s: %v", source, err)
+	} else {
+		load.Name, load.Version = agent.Name, agent.Version
+		log.Printf("manifest %s: loaded agent type %s %s", source, agent.Name, agent.Version)
+	}
+	m.record(load)
+	if err == nil && managed {
+		m.mu.Lock()
+		m.managed[source] = agent.Name
+		m.mu.Unlock()
+	}
+	return agent, err
+}
+
+func (m *Manifests) register(source string, raw []byte) (AgentType, error) {
+	manifest, err := parseManifest(raw)
+	if err != nil {
+		return AgentType{}, err
+	}
+	if missing := manifest.missingSecrets(m.secrets); len(missing) > 0 {
+		return AgentType{}, fmt.Errorf("%w: %s needs secrets the orchestrator does not have: %s",
+			errInvalidManifest, manifest.Name, strings.Join(missing, ", "))
+	}
+	// A manifest may replace an agent type from another manifest, as when
+	// it moves, but not one the operator registered.
+	if existing, err := m.registry.Get(manifest.Name); err == nil && existing.Source == "" {
+		return AgentType{}, fmt.Errorf("%w: %s would replace an agent type not loaded from a manifest",
+			errInvalidManifest, manifest.Name)
+	}
+	agent := manifest.agentType(source)
+	if err := m.registry.Register(agent); err != nil {
+		return AgentType{}, err
+	}
+	return agent, nil
+}
+
+func (m *Manifests) record(load ManifestLoad) {
+	load.LoadedAt = time.Now().UTC()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loads[load.Source] = load
+}
+
+// List returns the outcome of loading each manifest, by source.
+func (m *Manifests) List() []ManifestLoad {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	loads := make([]ManifestLoad, 0, len(m.loads))
+	for _, load := range m.loads {
+		loads = append(loads, load)
+	}
+	sort.Slice(loads, func(i, j int) bool { return loads[i].Source < loads[j].Source })
+	return loads
+}
+
+func readManifestFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxManifestSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", errInvalidManifest, maxManifestSize)
+	}
+	return os.ReadFile(path)
+}
+
+// splitRefs splits ORCH_MANIFEST_REFS on commas.
+func splitRefs(value string) []string {
+	var refs []string
+	for _, ref := range strings.Split(value, ",") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// manifestMediaType is the media type of the layer that holds an agent
+// manifest in an OCI artifact, as pushed with
+// `oras push REF --artifact-type ... agent.json:application/vnd.dynamic-context.agent.manifest.v1+json`.
+const manifestMediaType = "application/vnd.dynamic-context.agent.manifest.v1+json"
+
+var bearerParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// ociPuller fetches agent manifests from OCI registries over the
+// distribution API: the image manifest for a reference, then the blob of
+// its agent manifest layer. It answers the bearer token challenge registries
+// such as Docker Hub and GHCR send, with username and password when given.
+type ociPuller struct {
+	client    *http.Client
+	username  string
+	password  string
+	plainHTTP bool
+}
+
+func newOCIPuller(username, password string, plainHTTP bool) *ociPuller {
+	return &ociPuller{client: &http.Client{Timeout: 30 * time.Second}, username: username, password: password, plainHTTP: plainHTTP}
+}
+
+// ociRef is a parsed reference such as ghcr.io/acme/agents/jira:1.2 or
+// acme/jira@sha256:....
+type ociRef struct {
+	registry   string
+	repository string
+	reference  string
+}
+
+func parseOCIRef(ref string) (ociRef, error) {
+	name, reference := ref, "latest"
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, reference = name[:i], name[i+1:]
+	}
+	registry, repository := "registry-1.docker.io", name
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		registry, repository = first, rest
+	} else if !ok {
+		repository = "library/" + name
+	}
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	if repository == "" || reference == "" || strings.ContainsAny(repository, " ") {
+		return ociRef{}, fmt.Errorf("invalid OCI reference %q", ref)
+	}
+	return ociRef{registry: registry, repository: repository, reference: reference}, nil
+}
+
+// Pull returns the agent manifest at ref and the digest of its layer.
+func (p *ociPuller) Pull(ctx context.Context, ref string) ([]byte, string, error) {
+	r, err := parseOCIRef(ref)
+	if err != nil {
+		return nil, "", err
+	}
+	var image struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+			Size      int64  `json:"size"`
+		} `json:"layers"`
+	}
+	raw, err := p.get(ctx, r, "/manifests/"+r.reference,
+		"application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.Unmarshal(raw, &image); err != nil {
+		return nil, "", fmt.Errorf("%s: image manifest: %w", ref, err)
+	}
+	for _, layer := range image.Layers {
+		if layer.MediaType != manifestMediaType {
+			continue
+		}
+		if layer.Size > maxManifestSize {
+			return nil, "", fmt.Errorf("%s: agent manifest is larger than %d bytes", ref, maxManifestSize)
+		}
+		blob, err := p.get(ctx, r, "/blobs/"+layer.Digest, "")
+		if err != nil {
+			return nil, "", err
+		}
+		sum := sha256.Sum256(blob)
+		if digest := "sha256:" + hex.EncodeToString(sum[:]); digest != layer.Digest {
+			return nil, "", fmt.Errorf("%s: agent manifest digest is %s, want %s", ref, digest, layer.Digest)
+		}
+		return blob, layer.Digest, nil
+	}
+	return nil, "", fmt.Errorf("%s has no %s layer", ref, manifestMediaType)
+}
+
+// get reads a path under the repository, answering one token challenge.
+func (p *ociPuller) get(ctx context.Context, r ociRef, path, accept string) ([]byte, error) {
+	scheme := "https"
+	if p.plainHTTP {
+		scheme = "http"
+	}
+	endpoint := fmt.Sprintf("%s://%s/v2/%s%s", scheme, r.registry, r.repository, path)
+	token := ""
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if p.username != "" {
+			req.SetBasicAuth(p.username, p.password)
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && strings.HasPrefix(challenge, "Bearer ") {
+			if token, err = p.token(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+		}
+		if len(body) > maxManifestSize {
+			return nil, fmt.Errorf("GET %s: larger than %d bytes", endpoint, maxManifestSize)
+		}
+		return body, nil
+	}
+}
+
+// token fetches a bearer token from the realm a challenge names.
+func (p *ociPuller) token(ctx context.Context, challenge string) (string, error) {
+	params := make(map[string]string)
+	for _, match := range bearerParamPattern.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("registry challenge has no realm: %s", challenge)
+	}
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if p.username != "" {
+		req.SetBasicAuth(p.username, p.password)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("registry token: %w", err)
+	}
+	if body.Token == "" {
+		body.Token = body.AccessToken
+	}
+	return body.Token, nil
+}
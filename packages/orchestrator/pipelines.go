@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/logging"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/tracing"
+)
+
+var (
+	errUnknownPipeline = errors.New("unknown pipeline")
+	errInvalidPipeline = errors.New("invalid pipeline")
+	errUnknownRun      = errors.New("pipeline run not found")
+	errNoArtifact      = errors.New("no artifact")
+)
+
+const (
+	statusPending = "pending"
+	statusSkipped = "skipped"
+
+	keepRuns = 100
+)
+
+// stepTemplate is a reference in a step's target, such as {{target}} or
+// {{crawl.context.largest_files.0.path}}.
+var stepTemplate = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// PipelineStep runs one agent type. Its target defaults to the run's, and
+// can take values from the results of the steps it needs with templates;
+// those results also go to the agent's stdin, as a JSON object:
+//
+//	{"pipeline": ..., "run_id": ..., "step": ..., "target": <the run's>, "inputs": {<need>: <result>}}
+type PipelineStep struct {
+	Name      string   `json:"name"`
+	AgentType string   `json:"agent_type"`
+	Target    string   `json:"target,omitempty"`
+	Needs     []string `json:"needs,omitempty"`
+}
+
+// Pipeline is a multi-step agent workflow, such as crawler → extractor →
+// summarizer: steps form a DAG through what they need, and a step runs once
+// everything it needs has succeeded.
+type Pipeline struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Steps       []PipelineStep `json:"steps"`
+}
+
+func (p Pipeline) validate(registry *Registry) error {
+	if !agentNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("%w: name %q must be lowercase letters, digits, _ and -", errInvalidPipeline, p.Name)
+	}
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("%w: %s has no steps", errInvalidPipeline, p.Name)
+	}
+	steps := make(map[string]PipelineStep, len(p.Steps))
+	for _, step := range p.Steps {
+		if !agentNamePattern.MatchString(step.Name) || step.Name == "target" {
+			return fmt.Errorf("%w: step name %q must be lowercase letters, digits, _ and -, and not target", errInvalidPipeline, step.Name)
+		}
+		if _, ok := steps[step.Name]; ok {
+			return fmt.Errorf("%w: two steps are named %s", errInvalidPipeline, step.Name)
+		}
+		if _, err := registry.Get(step.AgentType); err != nil {
+			return fmt.Errorf("step %s: %w", step.Name, err)
+		}
+		steps[step.Name] = step
+	}
+	for _, step := range p.Steps {
+		for _, need := range step.Needs {
+			if _, ok := steps[need]; !ok || need == step.Name {
+				return fmt.Errorf("%w: step %s needs %q, which is not another step", errInvalidPipeline, step.Name, need)
+			}
+		}
+		for _, match := range stepTemplate.FindAllStringSubmatch(step.Target, -1) {
+			source, _, _ := strings.Cut(match[1], ".")
+			if source != "target" && !contains(step.Needs, source) {
+				return fmt.Errorf("%w: step %s's target uses %s, which it does not need", errInvalidPipeline, step.Name, source)
+			}
+		}
+	}
+
+	// Depth-first search for a cycle: a step reached again while still on
+	// the path
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int, len(steps))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case onPath:
+			return fmt.Errorf("%w: steps depend on each other in a cycle through %s", errInvalidPipeline, name)
+		case done:
+			return nil
+		}
+		state[name] = onPath
+		for _, need := range steps[name].Needs {
+			if err := visit(need); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+	for _, step := range p.Steps {
+		if err := visit(step.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sinks are the steps no other step needs, whose results are the run's.
+func (p Pipeline) sinks() map[string]bool {
+	sinks := make(map[string]bool, len(p.Steps))
+	for _, step := range p.Steps {
+		sinks[step.Name] = true
+	}
+	for _, step := range p.Steps {
+		for _, need := range step.Needs {
+			delete(sinks, need)
+		}
+	}
+	return sinks
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// StepRun is one step of a pipeline run.
+type StepRun struct {
+	Name      string `json:"name"`
+	AgentType string `json:"agent_type"`
+	Status    string `json:"status"`
+	Target    string `json:"target,omitempty"`
+	JobID     string `json:"job_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Artifact is the file the step's result was saved to.
+	Artifact string `json:"artifact,omitempty"`
+}
+
+// PipelineRun is one execution of a pipeline on a target. Its status is
+// partial when some steps succeeded and others failed or were skipped.
+type PipelineRun struct {
+	ID         string     `json:"id"`
+	Pipeline   string     `json:"pipeline"`
+	Target     string     `json:"target"`
+	SessionID  string     `json:"session_id,omitempty"`
+	Tenant     string     `json:"tenant,omitempty"`
+	Status     string     `json:"status"`
+	Steps      []StepRun  `json:"steps"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	// trace is the span that started the run, which its steps and report
+	// are traced under.
+	trace tracing.SpanContext
+}
+
+// Pipelines keeps pipeline definitions and executes runs of them. Each
+// step's job runs beside the worker pool, like a fan-out's, with the usual
+// retries; steps whose needs are met run at once. Every succeeded step's
+// result is saved as <artifacts>/<run id>/<step>.json. When a run finishes,
+// the results of its last steps are reported to the MCP server, stored
+// under the run's session, keyed by step.
+type Pipelines struct {
+	ctx       context.Context
+	jobs      *Scheduler
+	registry  *Registry
+	artifacts string
+
+	mu        sync.Mutex
+	pipelines map[string]Pipeline
+	runs      map[string]*PipelineRun
+}
+
+func newPipelines(ctx context.Context, jobs *Scheduler, registry *Registry, artifacts string) *Pipelines {
+	return &Pipelines{
+		ctx:       ctx,
+		jobs:      jobs,
+		registry:  registry,
+		artifacts: artifacts,
+		pipelines: make(map[string]Pipeline),
+		runs:      make(map[string]*PipelineRun),
+	}
+}
+
+// load adds the JSON array of pipelines in path, if one is given.
+func (p *Pipelines) load(path string) error {
+	if path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var pipelines []Pipeline
+	if err := json.Unmarshal(raw, &pipelines); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, pipeline := range pipelines {
+		if err := p.Put(pipeline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put adds or replaces a pipeline. Runs already going keep the steps they
+// started with.
+func (p *Pipelines) Put(pipeline Pipeline) error {
+	if err := pipeline.validate(p.registry); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pipelines[pipeline.Name] = pipeline
+	return nil
+}
+
+func (p *Pipelines) Get(name string) (Pipeline, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pipeline, ok := p.pipelines[name]
+	if !ok {
+		return Pipeline{}, fmt.Errorf("%w: %s", errUnknownPipeline, name)
+	}
+	return pipeline, nil
+}
+
+func (p *Pipelines) Remove(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.pipelines[name]
+	delete(p.pipelines, name)
+	return ok
+}
+
+func (p *Pipelines) List() []Pipeline {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pipelines := make([]Pipeline, 0, len(p.pipelines))
+	for _, pipeline := range p.pipelines {
+		pipelines = append(pipelines, pipeline)
+	}
+	sort.Slice(pipelines, func(i, j int) bool { return pipelines[i].Name < pipelines[j].Name })
+	return pipelines
+}
+
+// Start runs a pipeline on target in the background, traced under the
+// current span of ctx.
+func (p *Pipelines) Start(ctx context.Context, name, target, sessionID, tenant string) (PipelineRun, error) {
+	pipeline, err := p.Get(name)
+	if err != nil {
+		return PipelineRun{}, err
+	}
+	if tenant == "" {
+		tenant = defaultTenant
+	}
+	if err := checkTenant(tenant); err != nil {
+		return PipelineRun{}, err
+	}
+	run := &PipelineRun{
+		ID:        newJobID(),
+		Pipeline:  pipeline.Name,
+		Target:    target,
+		SessionID: sessionID,
+		Tenant:    tenant,
+		Status:    statusRunning,
+		CreatedAt: time.Now().UTC(),
+		trace:     tracing.SpanContextFrom(ctx),
+	}
+	for _, step := range pipeline.Steps {
+		run.Steps = append(run.Steps, StepRun{Name: step.Name, AgentType: step.AgentType, Status: statusPending})
+	}
+
+	p.mu.Lock()
+	p.runs[run.ID] = run
+	p.prune()
+	view := p.view(run)
+	p.mu.Unlock()
+
+	go p.execute(pipeline, run)
+	return view, nil
+}
+
+// execute starts every step whose needs have succeeded, and skips those
+// with a need that failed or was skipped, until no step is left to run.
+func (p *Pipelines) execute(pipeline Pipeline, run *PipelineRun) {
+	results := make(map[string]map[string]any)
+	finished := make(chan int)
+	running := 0
+	for {
+		p.mu.Lock()
+		for changed := true; changed; {
+			changed = false
+			for i, step := range pipeline.Steps {
+				if run.Steps[i].Status != statusPending {
+					continue
+				}
+				ready := true
+				for _, need := range step.Needs {
+					switch status := p.stepStatus(run, need); status {
+					case statusFailed, statusSkipped:
+						run.Steps[i].Status, run.Steps[i].Error = statusSkipped, fmt.Sprintf("needs %s, which %s", need, status)
+						changed = true
+					case statusSucceeded:
+					default:
+						ready = false
+					}
+				}
+				if ready && run.Steps[i].Status == statusPending {
+					inputs := make(map[string]any, len(step.Needs))
+					for _, need := range step.Needs {
+						inputs[need] = results[need]
+					}
+					run.Steps[i].Status = statusRunning
+					running++
+					go func() {
+						p.runStep(pipeline, run, i, inputs)
+						finished <- i
+					}()
+				}
+			}
+		}
+		p.mu.Unlock()
+
+		if running == 0 {
+			break
+		}
+		i := <-finished
+		running--
+		p.mu.Lock()
+		if result, ok := p.stepResult(run, i); ok {
+			results[pipeline.Steps[i].Name] = result
+		}
+		p.mu.Unlock()
+	}
+
+	p.finish(pipeline, run, results)
+}
+
+// stepStatus is the status of the named step; p.mu must be held.
+func (p *Pipelines) stepStatus(run *PipelineRun, name string) string {
+	for _, step := range run.Steps {
+		if step.Name == name {
+			return step.Status
+		}
+	}
+	return ""
+}
+
+// stepResult is a succeeded step's result, from its job; p.mu must be held.
+func (p *Pipelines) stepResult(run *PipelineRun, i int) (map[string]any, bool) {
+	if run.Steps[i].Status != statusSucceeded {
+		return nil, false
+	}
+	job, ok := p.jobs.Get(run.Steps[i].JobID)
+	return job.Result, ok
+}
+
+// runStep runs one step's job to the end and saves its result.
+func (p *Pipelines) runStep(pipeline Pipeline, run *PipelineRun, i int, inputs map[string]any) {
+	step := pipeline.Steps[i]
+	fail := func(err error) {
+		p.mu.Lock()
+		run.Steps[i].Status, run.Steps[i].Error = statusFailed, err.Error()
+		p.mu.Unlock()
+	}
+
+	target, err := resolveTarget(step.Target, run.Target, inputs)
+	if err != nil {
+		fail(err)
+		return
+	}
+	input, err := json.Marshal(map[string]any{
+		"pipeline": pipeline.Name, "run_id": run.ID, "step": step.Name, "target": run.Target, "inputs": inputs,
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+	job, err := p.jobs.add(tracing.ContextWithSpanContext(p.ctx, run.trace), step.AgentType, target, "", run.Tenant, input)
+	if err != nil {
+		fail(err)
+		return
+	}
+	p.mu.Lock()
+	run.Steps[i].Target, run.Steps[i].JobID = target, job.ID
+	p.mu.Unlock()
+
+	p.jobs.run(p.ctx, job.ID)
+	job, ok := p.jobs.Get(job.ID)
+	if !ok {
+		fail(errors.New("job was dropped"))
+		return
+	}
+	if job.Status != statusSucceeded {
+		fail(errors.New(job.Error))
+		return
+	}
+
+	artifact, err := p.saveArtifact(run.ID, step.Name, job.Result)
+	if err != nil {
+		log.Printf("pipeline run %s: saving %s's artifact: %v", run.ID, step.Name, err)
+	}
+	p.mu.Lock()
+	run.Steps[i].Status, run.Steps[i].Artifact = statusSucceeded, artifact
+	p.mu.Unlock()
+}
+
+func (p *Pipelines) saveArtifact(runID, step string, result map[string]any) (string, error) {
+	dir := filepath.Join(p.artifacts, runID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	raw, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, step+".json")
+	return path, os.WriteFile(path, raw, 0o644)
+}
+
+// Artifact returns the saved result of a run's step.
+func (p *Pipelines) Artifact(runID, step string) ([]byte, error) {
+	run, err := p.Run(runID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range run.Steps {
+		if s.Name == step && s.Artifact != "" {
+			return os.ReadFile(s.Artifact)
+		}
+	}
+	return nil, fmt.Errorf("%w for step %q of run %s", errNoArtifact, step, runID)
+}
+
+func (p *Pipelines) finish(pipeline Pipeline, run *PipelineRun, results map[string]map[string]any) {
+	p.mu.Lock()
+	now := time.Now().UTC()
+	run.FinishedAt = &now
+	succeeded := 0
+	for _, step := range run.Steps {
+		if step.Status == statusSucceeded {
+			succeeded++
+		}
+	}
+	switch succeeded {
+	case len(run.Steps):
+		run.Status = statusSucceeded
+	case 0:
+		run.Status = statusFailed
+	default:
+		run.Status = statusPartial
+	}
+	view := p.view(run)
+	p.mu.Unlock()
+
+	ctx := logging.WithSession(logging.WithTenant(tracing.ContextWithSpanContext(p.ctx, run.trace), view.Tenant), view.SessionID)
+	slog.InfoContext(ctx, "pipeline run "+view.Status, "run_id", view.ID, "pipeline", view.Pipeline, "target", view.Target,
+		"steps", len(view.Steps), "succeeded", succeeded)
+
+	sinks := pipeline.sinks()
+	outputs := make(map[string]any, len(sinks))
+	for name, result := range results {
+		if sinks[name] {
+			outputs[name] = result
+		}
+	}
+	report := struct {
+		PipelineRun
+		Kind   string         `json:"kind"`
+		Result map[string]any `json:"result"`
+	}{view, "pipeline", outputs}
+	if err := p.jobs.reporter.Report(ctx, report); err != nil {
+		slog.ErrorContext(ctx, "reporting to MCP server", "run_id", view.ID, "error", err.Error())
+	}
+}
+
+// view copies a run; p.mu must be held.
+func (p *Pipelines) view(run *PipelineRun) PipelineRun {
+	view := *run
+	view.Steps = append([]StepRun(nil), run.Steps...)
+	return view
+}
+
+func (p *Pipelines) Run(id string) (PipelineRun, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	run, ok := p.runs[id]
+	if !ok {
+		return PipelineRun{}, fmt.Errorf("%w: %s", errUnknownRun, id)
+	}
+	return p.view(run), nil
+}
+
+// Runs returns a pipeline's runs newest first.
+func (p *Pipelines) Runs(name string) []PipelineRun {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	runs := make([]PipelineRun, 0)
+	for _, run := range p.runs {
+		if run.Pipeline == name {
+			runs = append(runs, p.view(run))
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.After(runs[j].CreatedAt) })
+	return runs
+}
+
+// prune drops the oldest finished runs past keepRuns, with their artifacts;
+// p.mu must be held.
+func (p *Pipelines) prune() {
+	if len(p.runs) <= keepRuns {
+		return
+	}
+	var finished []*PipelineRun
+	for _, run := range p.runs {
+		if run.FinishedAt != nil {
+			finished = append(finished, run)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+	for _, run := range finished[:min(len(finished), len(p.runs)-keepRuns)] {
+		delete(p.runs, run.ID)
+		if err := os.RemoveAll(filepath.Join(p.artifacts, run.ID)); err != nil {
+			log.Printf("pipeline run %s: removing artifacts: %v", run.ID, err)
+		}
+	}
+}
+
+// resolveTarget fills in a step's target template: {{target}} is the run's
+// target, and {{<step>.<path>}} a value from that step's result, with the
+// path's parts naming object keys or list indexes. An empty template is the
+// run's target.
+func resolveTarget(template, target string, inputs map[string]any) (string, error) {
+	if template == "" {
+		return target, nil
+	}
+	var err error
+	resolved := stepTemplate.ReplaceAllStringFunc(template, func(match string) string {
+		path := strings.Split(stepTemplate.FindStringSubmatch(match)[1], ".")
+		if path[0] == "target" && len(path) == 1 {
+			return target
+		}
+		var value any = inputs[path[0]]
+		for _, key := range path[1:] {
+			switch v := value.(type) {
+			case map[string]any:
+				value = v[key]
+			case []any:
+				n, convErr := strconv.Atoi(key)
+				if convErr != nil || n < 0 || n >= len(v) {
+					value = nil
+				} else {
+					value = v[n]
+				}
+			default:
+				value = nil
+			}
+		}
+		switch value.(type) {
+		case string, float64, bool:
+			return fmt.Sprint(value)
+		}
+		if err == nil {
+			err = fmt.Errorf("target %s: %s is not a string, number or boolean", template, match)
+		}
+		return ""
+	})
+	return resolved, err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errUnknownQuarantined = errors.New("quarantined result not found")
+
+const keepQuarantined = 200
+
+// Quarantined is an agent result that did not match the agent output
+// schema. It is kept with the violations found in it instead of reaching
+// the MCP server, session memory and the knowledge graph, so the agent can
+// be fixed from them.
+type Quarantined struct {
+	JobID         string         `json:"job_id"`
+	AgentType     string         `json:"agent_type"`
+	Target        string         `json:"target"`
+	SessionID     string         `json:"session_id,omitempty"`
+	Violations    []Violation    `json:"violations"`
+	Payload       map[string]any `json:"payload"`
+	QuarantinedAt time.Time      `json:"quarantined_at"`
+}
+
+// Quarantine keeps quarantined results in memory. Past keepQuarantined the
+// oldest are dropped.
+type Quarantine struct {
+	mu      sync.Mutex
+	results map[string]Quarantined
+}
+
+func newQuarantine() *Quarantine {
+	return &Quarantine{results: make(map[string]Quarantined)}
+}
+
+func (q *Quarantine) Add(result Quarantined) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.results[result.JobID] = result
+	if len(q.results) > keepQuarantined {
+		for _, old := range q.sorted()[keepQuarantined:] {
+			delete(q.results, old.JobID)
+		}
+	}
+}
+
+func (q *Quarantine) Get(jobID string) (Quarantined, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	result, ok := q.results[jobID]
+	if !ok {
+		return Quarantined{}, fmt.Errorf("%w: %s", errUnknownQuarantined, jobID)
+	}
+	return result, nil
+}
+
+// Remove drops a quarantined result, returning it.
+func (q *Quarantine) Remove(jobID string) (Quarantined, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	result, ok := q.results[jobID]
+	if !ok {
+		return Quarantined{}, fmt.Errorf("%w: %s", errUnknownQuarantined, jobID)
+	}
+	delete(q.results, jobID)
+	return result, nil
+}
+
+// List returns quarantined results newest first.
+func (q *Quarantine) List() []Quarantined {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.sorted()
+}
+
+// sorted lists the quarantined results newest first; q.mu must be held.
+func (q *Quarantine) sorted() []Quarantined {
+	results := make([]Quarantined, 0, len(q.results))
+	for _, result := range q.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].QuarantinedAt.After(results[j].QuarantinedAt) })
+	return results
+}
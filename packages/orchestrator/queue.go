@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var errInvalidPriority = errors.New("invalid priority")
+
+// Priorities, lowest first. Interactive jobs are for a user waiting on the
+// context; background ones are re-crawls nobody is waiting for, as
+// schedules run.
+const (
+	priorityBackground  = "background"
+	priorityNormal      = "normal"
+	priorityInteractive = "interactive"
+)
+
+var priorityLevels = map[string]int{priorityBackground: 0, priorityNormal: 1, priorityInteractive: 2}
+
+// parsePriority checks a priority, defaulting an empty one to fallback.
+func parsePriority(priority, fallback string) (string, error) {
+	if priority == "" {
+		return fallback, nil
+	}
+	if _, ok := priorityLevels[priority]; !ok {
+		return "", fmt.Errorf("%w: %q is not interactive, normal or background", errInvalidPriority, priority)
+	}
+	return priority, nil
+}
+
+type queuedJob struct {
+	id       string
+	priority string
+	queuedAt time.Time
+}
+
+// jobQueue hands the workers the job with the highest priority, and of
+// those the one queued first. So low priority work is not starved, a job
+// moves up a level for every aging it has waited.
+type jobQueue struct {
+	size int
+
+	mu    sync.Mutex
+	aging time.Duration
+	jobs  []queuedJob
+	// ready holds a token for each queued job, for workers to wait on.
+	ready chan struct{}
+}
+
+func newJobQueue(size int, aging time.Duration) *jobQueue {
+	return &jobQueue{size: size, aging: aging, ready: make(chan struct{}, size)}
+}
+
+// SetAging changes how long a job waits before it moves up a level.
+func (q *jobQueue) SetAging(aging time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.aging = aging
+}
+
+// Push queues a job, or reports false when the queue is full.
+func (q *jobQueue) Push(id, priority string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.jobs) >= q.size {
+		return false
+	}
+	q.jobs = append(q.jobs, queuedJob{id: id, priority: priority, queuedAt: time.Now()})
+	q.ready <- struct{}{}
+	return true
+}
+
+// Pop waits for a job and takes the one to run next.
+func (q *jobQueue) Pop(ctx context.Context) (string, bool) {
+	select {
+	case <-ctx.Done():
+		return "", false
+	case <-q.ready:
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now, next := time.Now(), 0
+	for i := range q.jobs {
+		if q.level(q.jobs[i], now) > q.level(q.jobs[next], now) {
+			next = i
+		}
+	}
+	id := q.jobs[next].id
+	q.jobs = append(q.jobs[:next], q.jobs[next+1:]...)
+	return id, true
+}
+
+// level is a queued job's priority level, raised for the time it has
+// waited.
+func (q *jobQueue) level(job queuedJob, now time.Time) int {
+	level := priorityLevels[job.priority]
+	if q.aging > 0 {
+		level += int(now.Sub(job.queuedAt) / q.aging)
+	}
+	return min(level, priorityLevels[priorityInteractive])
+}
+
+// Depth counts the queued jobs by the priority they were queued with.
+func (q *jobQueue) Depth() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	depth := map[string]int{priorityBackground: 0, priorityNormal: 0, priorityInteractive: 0}
+	for _, job := range q.jobs {
+		depth[job.priority]++
+	}
+	return depth
+}
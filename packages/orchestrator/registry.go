@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	errUnknownAgent  = errors.New("unknown agent type")
+	errInvalidAgent  = errors.New("invalid agent type")
+	errInvalidTarget = errors.New("invalid target")
+)
+
+var (
+	agentNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+	envNamePattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// AgentType is one kind of micro agent the orchestrator can launch. The
+// container runtime runs Image, with Command (when set) in place of the
+// image's entrypoint; the exec runtime runs Command directly. Either way the
+// job's target is passed as the last argument. Secrets binds variables the
+// agent gets, such as tokens, to secrets of the orchestrator's secret store;
+// unlike Env their values never appear in the registry or in the container
+// CLI's arguments, and no other agent type sees them. An agent type with State keeps a directory,
+// named by AGENT_STATE_DIR, from one run to the next, such as for the
+// cursors of an incremental sync. Input, when set, is a JSON Schema that
+// job targets must match. Agent types loaded from a manifest record where
+// it came from in Source, and the agent's Version.
+type AgentType struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Image       string            `json:"image,omitempty"`
+	Command     []string          `json:"command,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Secrets     []SecretBinding   `json:"secrets,omitempty"`
+	State       bool              `json:"state,omitempty"`
+	Input       map[string]any    `json:"input,omitempty"`
+	Limits
+}
+
+func (a AgentType) validate() error {
+	if !agentNamePattern.MatchString(a.Name) {
+		return fmt.Errorf("%w: name %q must be lowercase letters, digits, _ and -", errInvalidAgent, a.Name)
+	}
+	if a.Image == "" && len(a.Command) == 0 {
+		return fmt.Errorf("%w: %s needs an image or a command", errInvalidAgent, a.Name)
+	}
+	for _, binding := range a.Secrets {
+		if !envNamePattern.MatchString(binding.Name) {
+			return fmt.Errorf("%w: %s: secret %q is not an environment variable name", errInvalidAgent, a.Name, binding.Name)
+		}
+		if !secretKeyPattern.MatchString(binding.source()) {
+			return fmt.Errorf("%w: %s: secret %s is bound to %q, which is not a secret's name", errInvalidAgent, a.Name, binding.Name, binding.From)
+		}
+	}
+	if err := a.Limits.validate(); err != nil {
+		return fmt.Errorf("%w: %s: %v", errInvalidAgent, a.Name, err)
+	}
+	if _, err := a.inputSchema(); err != nil {
+		return fmt.Errorf("%w: %s: input: %v", errInvalidAgent, a.Name, err)
+	}
+	return nil
+}
+
+func (a AgentType) inputSchema() (*outputSchema, error) {
+	if a.Input == nil {
+		return nil, nil
+	}
+	return newSchema(map[string]map[string]any{"input": a.Input})
+}
+
+// checkTarget matches target against the agent type's Input schema.
+func (a AgentType) checkTarget(target string) error {
+	schema, err := a.inputSchema()
+	if schema == nil || err != nil {
+		return err
+	}
+	var invalid *invalidOutputError
+	if err := schema.Validate("input", target); errors.As(err, &invalid) {
+		return fmt.Errorf("%w for %s: %s", errInvalidTarget, a.Name, invalid.violations[0].Message)
+	}
+	return nil
+}
+
+// defaultAgents is the registry when ORCH_AGENTS names no file: the agent
+// types micro_agent.py implements. context_gatherer runs in the full agent
+// image and picks a type from the target; each of the others has a slim
+// image with only its own dependencies.
+func defaultAgents() []AgentType {
+	agents := []AgentType{{
+		Name:        "context_gatherer",
+		Description: "Picks an agent type from the target",
+		Image:       "micro-agent:latest",
+		Command:     []string{"python3", "/app/micro_agent.py"},
+	}}
+	for _, builtin := range []struct {
+		name, description string
+		secrets           []string
+		state             bool
+		limits            Limits
+	}{
+		{name: "web_scraper", description: "Title, headings, links and text of a web page"},
+		{name: "git_analyzer", description: "Commits, contributors, branches and file types of a git repository"},
+		{name: "filesystem_crawler", description: "Files, sizes, types and README of a directory"},
+		{name: "rest_poller", description: "Status, latency and body of a REST endpoint"},
+		{name: "github_repo", description: "README, manifests, structure, commits and open pull requests of a GitHub repository",
+			secrets: []string{"GITHUB_TOKEN"}},
+		{name: "docs_crawler", description: "Pages of a documentation site, crawled politely and split into chunks"},
+		{name: "db_introspector", description: "Tables, columns, keys, comments and row counts of a Postgres or MySQL database",
+			secrets: []string{"DB_PASSWORD"}},
+		{name: "chat_ingester", description: "Recent conversations in Slack or Discord channels, threaded and stripped of noise",
+			secrets: []string{"SLACK_BOT_TOKEN", "DISCORD_BOT_TOKEN"}},
+		{name: "issue_tracker", description: "Open issues of a Jira project, Linear team or GitHub repository, synced incrementally",
+			secrets: []string{"GITHUB_TOKEN", "JIRA_TOKEN", "JIRA_EMAIL", "LINEAR_API_KEY"}, state: true},
+		// Chromium needs more room than the default limits give
+		{name: "headless_browser", description: "Title, headings, links, text and screenshots of pages rendered with JavaScript, after a login",
+			secrets: []string{"BROWSER_USERNAME", "BROWSER_PASSWORD"}, limits: Limits{Memory: "1g", PIDs: 512}},
+	} {
+		agents = append(agents, AgentType{
+			Name:        builtin.name,
+			Description: builtin.description,
+			Image:       "micro-agent-" + strings.ReplaceAll(builtin.name, "_", "-") + ":latest",
+			Command:     []string{"python3", "/app/micro_agent.py", "--type", builtin.name},
+			Secrets:     bindSecrets(builtin.secrets),
+			State:       builtin.state,
+			Limits:      builtin.limits,
+		})
+	}
+	return agents
+}
+
+// bindSecrets binds each variable to the secret of the same name.
+func bindSecrets(names []string) []SecretBinding {
+	var bindings []SecretBinding
+	for _, name := range names {
+		bindings = append(bindings, SecretBinding{Name: name})
+	}
+	return bindings
+}
+
+// Registry tracks the agent types jobs can ask for.
+type Registry struct {
+	mu     sync.RWMutex
+	agents map[string]AgentType
+}
+
+// loadRegistry reads a JSON array of agent types, or uses defaultAgents
+// when path is empty.
+func loadRegistry(path string) (*Registry, error) {
+	agents := defaultAgents()
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		agents = nil
+		if err := json.Unmarshal(raw, &agents); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	r := &Registry{agents: make(map[string]AgentType, len(agents))}
+	for _, agent := range agents {
+		if err := r.Register(agent); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds an agent type, replacing one of the same name.
+func (r *Registry) Register(agent AgentType) error {
+	if err := agent.validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.agents[agent.Name] = agent
+	return nil
+}
+
+func (r *Registry) Get(name string) (AgentType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	agent, ok := r.agents[name]
+	if !ok {
+		return AgentType{}, fmt.Errorf("%w: %s", errUnknownAgent, name)
+	}
+	return agent, nil
+}
+
+// Remove deletes an agent type; jobs already queued for it still run.
+func (r *Registry) Remove(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.agents[name]
+	delete(r.agents, name)
+	return ok
+}
+
+// List returns the agent types sorted by name.
+func (r *Registry) List() []AgentType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	agents := make([]AgentType, 0, len(r.agents))
+	for _, agent := range r.agents {
+		agents = append(agents, agent)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+	return agents
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloadable are the settings that take effect as soon as they change.
+// Agents launched after a change see every setting as it is then; the
+// orchestrator's other settings are read once, when it starts.
+var reloadable = map[string]bool{
+	"ORCH_JOB_TIMEOUT": true, "ORCH_AGENT_CPUS": true, "ORCH_AGENT_MEMORY": true, "ORCH_AGENT_PIDS": true,
+	"ORCH_JOB_RETRIES": true, "ORCH_RETRY_BACKOFF": true, "ORCH_PRIORITY_AGING": true,
+}
+
+// RemoteConfig pulls the orchestrator's settings from the config service at
+// CONFIG_URL and keeps them in its environment, where everything that reads
+// a setting already looks. A variable the orchestrator was started with
+// wins over the service's, so one deployment can still be set apart.
+type RemoteConfig struct {
+	url       string
+	component string
+	token     string
+	client    *http.Client
+
+	mu      sync.Mutex
+	version int64
+	// managed are the variables set from the service, which it may change
+	// or take away again.
+	managed map[string]bool
+	flags   map[string]remoteFlag
+}
+
+type remoteSettings struct {
+	Version int64                 `json:"version"`
+	Env     map[string]string     `json:"env"`
+	Flags   map[string]remoteFlag `json:"flags"`
+}
+
+// remoteFlag is a feature flag: whether it is on, and for which tenants
+// that is not so.
+type remoteFlag struct {
+	Enabled bool            `json:"enabled"`
+	Tenants map[string]bool `json:"tenants"`
+}
+
+// pullConfig takes the component's settings from the config service once,
+// before anything reads them.
+func pullConfig(ctx context.Context, rawURL, component, token string) (*RemoteConfig, error) {
+	c := &RemoteConfig{url: strings.TrimRight(rawURL, "/"), component: component, token: token,
+		client: &http.Client{}, managed: map[string]bool{}}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	settings, err := c.fetch(ctx, -1)
+	if err != nil {
+		return nil, fmt.Errorf("pulling settings from %s: %w", c.url, err)
+	}
+	c.apply(settings)
+	log.Printf("settings version %d from the config service (%d variables)", settings.Version, len(c.managed))
+	return c, nil
+}
+
+// fetch gets the settings, waiting for ones past after unless it is
+// negative.
+func (c *RemoteConfig) fetch(ctx context.Context, after int64) (remoteSettings, error) {
+	endpoint := c.url + "/config/" + url.PathEscape(c.component)
+	if after >= 0 {
+		endpoint += fmt.Sprintf("?after=%d&wait=30", after)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return remoteSettings{}, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return remoteSettings{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return remoteSettings{}, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	var settings remoteSettings
+	if err := json.NewDecoder(resp.Body).Decode(&settings); err != nil {
+		return remoteSettings{}, err
+	}
+	return settings, nil
+}
+
+// apply sets the service's variables, leaving alone those the orchestrator
+// was started with, and unsets those the service no longer has. It reports
+// the variables it changed.
+func (c *RemoteConfig) apply(settings remoteSettings) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version = settings.Version
+	c.flags = settings.Flags
+	var changed []string
+	for key, value := range settings.Env {
+		current, set := os.LookupEnv(key)
+		if set && !c.managed[key] {
+			continue
+		}
+		c.managed[key] = true
+		if !set || current != value {
+			os.Setenv(key, value)
+			changed = append(changed, key)
+		}
+	}
+	for key := range c.managed {
+		if _, ok := settings.Env[key]; !ok {
+			os.Unsetenv(key)
+			delete(c.managed, key)
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Version is the version of the settings last taken from the service.
+func (c *RemoteConfig) Version() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// Flags are the feature flags on for a tenant, sorted; none without a
+// config service.
+func (c *RemoteConfig) Flags(tenant string) []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var on []string
+	for name, flag := range c.flags {
+		enabled, ok := flag.Tenants[tenant]
+		if !ok {
+			enabled = flag.Enabled
+		}
+		if enabled {
+			on = append(on, name)
+		}
+	}
+	sort.Strings(on)
+	return on
+}
+
+// Watch waits on the service for changes until ctx is done, applying each
+// and calling changed with the variables it changed. It tries again a
+// second after the service fails it, and up to 30 seconds after several.
+func (c *RemoteConfig) Watch(ctx context.Context, changed func(keys []string)) {
+	wait := time.Second
+	for ctx.Err() == nil {
+		settings, err := c.fetch(ctx, c.Version())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("config service: retrying in %s: %v", wait, err)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			wait = min(2*wait, 30*time.Second)
+			continue
+		}
+		wait = time.Second
+		if settings.Version == c.Version() {
+			continue
+		}
+		if keys := c.apply(settings); len(keys) > 0 {
+			log.Printf("settings version %d changed %s", settings.Version, strings.Join(keys, ", "))
+			changed(keys)
+		}
+	}
+}
+
+// reconfigure applies changed settings to the scheduler. Settings that do
+// not validate are logged and the scheduler keeps its own.
+func reconfigure(scheduler *Scheduler, keys []string) {
+	var restart []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, "ORCH_") && !reloadable[key] {
+			restart = append(restart, key)
+		}
+	}
+	if len(restart) > 0 {
+		log.Printf("changes to %s take effect once the orchestrator restarts", strings.Join(restart, ", "))
+	}
+	limits, err := defaultLimits()
+	if err != nil {
+		log.Printf("keeping the agent limits: %v", err)
+		limits = scheduler.Limits()
+	}
+	retry, err := retryPolicyFromEnv()
+	if err != nil {
+		log.Printf("keeping the retry policy: %v", err)
+		retry = scheduler.RetryPolicy()
+	}
+	scheduler.SetPolicy(limits, retry)
+	aging, err := getenvInt("ORCH_PRIORITY_AGING", 60)
+	if err != nil {
+		log.Printf("keeping the priority aging: %v", err)
+		return
+	}
+	scheduler.queue.SetAging(time.Duration(aging) * time.Second)
+}
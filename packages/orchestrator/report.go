@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/events"
+	"github.com/jayp41/dynamic-context-mcp-system/packages/tracing"
+)
+
+// Reporter posts finished jobs to the MCP server, which broadcasts them to
+// its socket clients and stores results that name a session in session
+// memory. With no MCP server URL reporting is off. With an event bus, each
+// finished job is also published on it as a result event, for session
+// memory to store.
+//
+// With RBAC_TOKEN set, it is sent as the bearer token. Reports and events
+// carry the trace of the job they are about.
+type Reporter struct {
+	url    string
+	token  string
+	client *http.Client
+	busURL string
+	bus    *events.Publisher
+}
+
+func newReporter(mcpURL, busURL string, tracer *tracing.Tracer) *Reporter {
+	r := &Reporter{url: strings.TrimRight(mcpURL, "/"), token: os.Getenv("RBAC_TOKEN"),
+		client: &http.Client{Timeout: 10 * time.Second, Transport: tracer.Transport(nil)}, busURL: busURL}
+	if busURL != "" {
+		r.bus = events.NewPublisher(busURL, "orchestrator")
+		r.bus.Traceparent = func(ctx context.Context) string { return tracing.SpanContextFrom(ctx).Traceparent() }
+	}
+	return r
+}
+
+func (r *Reporter) Enabled() bool { return r.url != "" }
+
+// reportAttempts is how many times a report is posted before it is given
+// up on: a second apart, then twice as long each time, so a result outlives
+// the MCP server or session memory behind it restarting.
+const reportAttempts = 5
+
+// Report posts a finished job, or a fan-out's aggregated results. A report
+// the MCP server could not be reached for, or answered with a 5xx, is
+// posted again.
+func (r *Reporter) Report(ctx context.Context, result any) error {
+	if !r.Enabled() {
+		return nil
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := r.post(ctx, body)
+		if err == nil || !retry || attempt == reportAttempts {
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		wait *= 2
+	}
+}
+
+// post posts a report once, reporting whether a failure is worth trying
+// again.
+func (r *Reporter) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url+"/agents/results", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode >= 500, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return false, nil
+}
+
+// Publish puts a finished job on the event bus, if there is one: its
+// result, and its last change of status.
+func (r *Reporter) Publish(ctx context.Context, job Job) error {
+	if r.bus == nil {
+		return nil
+	}
+	result := events.Result{JobID: job.ID, SessionID: job.SessionID, Tenant: job.Tenant, AgentType: job.AgentType,
+		Target: job.Target, Status: job.Status, Context: job.Result, Error: job.Error}
+	if job.FinishedAt != nil {
+		result.FinishedAt = *job.FinishedAt
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return r.bus.Publish(ctx, result, jobStatus(job))
+}
+
+// PublishStatus puts a job's change of status on the event bus, if there
+// is one.
+func (r *Reporter) PublishStatus(ctx context.Context, job Job) error {
+	if r.bus == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return r.bus.Publish(ctx, jobStatus(job))
+}
+
+func jobStatus(job Job) events.JobStatus {
+	return events.JobStatus{JobID: job.ID, SessionID: job.SessionID, Tenant: job.Tenant, AgentType: job.AgentType,
+		Target: job.Target, Status: job.Status, Attempt: job.Attempts, Error: job.Error}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Runtime launches an agent on a job's target and returns what it printed on
+// stdout. The agent's Limits are the ones to enforce, already merged with
+// the orchestrator's defaults; the wall-clock limit arrives as ctx's
+// deadline. The job's input, if any, goes to the agent's stdin.
+type Runtime interface {
+	Name() string
+	Run(ctx context.Context, agent AgentType, job Job) ([]byte, error)
+}
+
+// containerRuntime runs each agent as a throwaway container through a
+// Docker-compatible CLI (docker, podman, nerdctl). The state of an agent
+// type with State is a named volume, orch-state-<name>, which outlives the
+// containers. The agent's secrets reach the CLI through its environment,
+// which holds no other agent type's.
+type containerRuntime struct {
+	cli     string
+	network string
+	secrets *SecretStore
+}
+
+func (c containerRuntime) Name() string { return "container" }
+
+func (c containerRuntime) args(agent AgentType, job Job) ([]string, error) {
+	if agent.Image == "" {
+		return nil, fmt.Errorf("agent type %s has no image to run", agent.Name)
+	}
+	args := []string{"run", "--rm", "--name", containerName(job)}
+	if len(job.input) > 0 {
+		args = append(args, "--interactive")
+	}
+	if agent.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(agent.CPUs, 'f', -1, 64))
+	}
+	if agent.Memory != "" {
+		// An equal swap limit stops the agent from swapping past its memory
+		args = append(args, "--memory", agent.Memory, "--memory-swap", agent.Memory)
+	}
+	if agent.PIDs > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(agent.PIDs))
+	}
+	if c.network != "" {
+		args = append(args, "--network", c.network)
+	}
+	for _, name := range sortedKeys(agent.Env) {
+		args = append(args, "-e", name+"="+agent.Env[name])
+	}
+	for _, binding := range agent.Secrets {
+		// Without a value the CLI copies the variable from its environment
+		args = append(args, "-e", binding.Name)
+	}
+	if _, ok := c.secrets.Lookup(agentTokenSecret); ok {
+		args = append(args, "-e", "RBAC_TOKEN")
+	}
+	if agent.State {
+		args = append(args, "-v", "orch-state-"+agent.Name+":"+containerStateDir, "-e", "AGENT_STATE_DIR="+containerStateDir)
+	}
+	if len(agent.Command) > 0 {
+		args = append(args, "--entrypoint", agent.Command[0], agent.Image)
+		args = append(args, agent.Command[1:]...)
+	} else {
+		args = append(args, agent.Image)
+	}
+	return append(args, job.Target), nil
+}
+
+func (c containerRuntime) Run(ctx context.Context, agent AgentType, job Job) ([]byte, error) {
+	args, err := c.args(agent, job)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, c.cli, args...)
+	cmd.Env = c.secrets.Environ(agent)
+	cmd.Stdin = bytes.NewReader(job.input)
+	output, err := runCommand(cmd)
+	if ctx.Err() != nil {
+		// Killing the CLI leaves the container running, so remove it too
+		rm, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		exec.CommandContext(rm, c.cli, "rm", "-f", containerName(job)).Run()
+	}
+	return output, err
+}
+
+// containerStateDir is where agent containers find their state volume.
+const containerStateDir = "/var/lib/agent-state"
+
+func containerName(job Job) string {
+	return "orch-agent-" + job.ID
+}
+
+// execRuntime runs agent commands as child processes, for when the
+// orchestrator itself runs in an image that has the agents installed (as in
+// the Dagger pipeline). Memory is limited with ulimit and the wall-clock
+// limit kills the agent's whole process group; CPU and process limits need
+// a container, so they are not enforced here. Agents inherit the
+// orchestrator's environment less the secrets of other agent types. The
+// state of an agent type with State is a directory under stateDir.
+type execRuntime struct {
+	stateDir string
+	secrets  *SecretStore
+}
+
+func (execRuntime) Name() string { return "exec" }
+
+func (e execRuntime) Run(ctx context.Context, agent AgentType, job Job) ([]byte, error) {
+	if len(agent.Command) == 0 {
+		return nil, fmt.Errorf("agent type %s has no command to run", agent.Name)
+	}
+	args := append(append([]string{}, agent.Command...), job.Target)
+	if memory := agent.memoryBytes(); memory > 0 {
+		limit := fmt.Sprintf(`ulimit -v %d && exec "$@"`, memory>>10)
+		args = append([]string{"sh", "-c", limit, "sh"}, args...)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	// Children that outlive the group kill must not hold the job open
+	cmd.WaitDelay = 5 * time.Second
+	cmd.Stdin = bytes.NewReader(job.input)
+	cmd.Env = e.secrets.Environ(agent)
+	for _, name := range sortedKeys(agent.Env) {
+		cmd.Env = append(cmd.Env, name+"="+agent.Env[name])
+	}
+	if agent.State {
+		dir := filepath.Join(e.stateDir, agent.Name)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("agent state: %w", err)
+		}
+		cmd.Env = append(cmd.Env, "AGENT_STATE_DIR="+dir)
+	}
+	return runCommand(cmd)
+}
+
+// exitUsage is the status agents exit with when they were run wrongly, such
+// as for an agent type whose dependencies are missing, so running them again
+// cannot help.
+const exitUsage = 2
+
+// exitError is an agent that exited with a non-zero status.
+type exitError struct {
+	status  int
+	message string
+}
+
+func (e *exitError) Error() string { return e.message }
+
+// runCommand returns stdout, or an error carrying the tail of stderr.
+func runCommand(cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		message := strings.TrimSpace(stderr.String())
+		if len(message) > 2000 {
+			message = "..." + message[len(message)-2000:]
+		}
+		status := fmt.Sprintf("agent exited with status %d", exitErr.ExitCode())
+		if exitErr.ExitCode() == 137 {
+			// SIGKILL, which is how the kernel's OOM killer stops a container
+			status = "agent was killed (status 137), most likely for exceeding its memory limit"
+		}
+		if message != "" {
+			status += ": " + message
+		}
+		return stdout.Bytes(), &exitError{status: exitErr.ExitCode(), message: status}
+	}
+	return stdout.Bytes(), err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
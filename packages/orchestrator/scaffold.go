@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"embed"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Where generated agents get the agent SDK from.
+const (
+	goSDKModule = "github.com/jayp41/dynamic-context-mcp-system/packages/agent-sdk/go"
+	pythonSDK   = "https://github.com/jayp41/dynamic-context-mcp-system/archive/refs/heads/main.tar.gz#subdirectory=packages/agent-sdk/python"
+)
+
+// scaffold has the templates of a new agent: the files shared by every
+// language at the top, and each language's own under its name. A template
+// is written without its .tmpl suffix, which keeps go.mod and *.go
+// templates out of this module's build.
+//
+//go:embed scaffold
+var scaffold embed.FS
+
+// scaffoldAgent is what the templates are filled in with.
+type scaffoldAgent struct {
+	Name, Lang, Image string
+	GoSDK, PythonSDK  string
+}
+
+// generate runs `orchestrator gen agent NAME`, which needs no running
+// orchestrator: it writes a new agent's source, test, Dockerfile and
+// manifest to a directory of its own.
+func generate(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "agent" {
+		return fmt.Errorf("bad arguments\n%s", cliUsage)
+	}
+	flags := flag.NewFlagSet("gen agent", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	lang := flags.String("lang", "python", "")
+	dir := flags.String("dir", "", "")
+	image := flags.String("image", "", "")
+	// Flags may come before or after the name
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%v\n%s", err, cliUsage)
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("bad arguments\n%s", cliUsage)
+	}
+	name := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[1:]); err != nil || flags.NArg() > 0 {
+		return fmt.Errorf("bad arguments\n%s", cliUsage)
+	}
+	if !agentNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: name %q must be lowercase letters, digits, _ and -", errInvalidAgent, name)
+	}
+	if *lang != "python" && *lang != "go" {
+		return fmt.Errorf("--lang is python or go, not %q", *lang)
+	}
+	agent := scaffoldAgent{Name: name, Lang: *lang, Image: cmp.Or(*image, name+":0.1.0"),
+		GoSDK: goSDKModule, PythonSDK: pythonSDK}
+	*dir = cmp.Or(*dir, name)
+
+	files, err := agent.render()
+	if err != nil {
+		return err
+	}
+	if _, err := parseManifest(files["agent.json"]); err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(*dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", *dir)
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for file := range files {
+		names = append(names, file)
+	}
+	sort.Strings(names)
+	for _, file := range names {
+		if err := os.WriteFile(filepath.Join(*dir, file), files[file], 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "wrote %s\n", filepath.Join(*dir, file))
+	}
+	fmt.Fprintf(out, "\n%s is a %s agent; see %s for how to test, build and install it.\n",
+		name, *lang, filepath.Join(*dir, "README.md"))
+	return nil
+}
+
+// render fills in the templates shared by every language and those of the
+// agent's own, by the names of the files they become.
+func (a scaffoldAgent) render() (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, dir := range []string{"scaffold", path.Join("scaffold", a.Lang)} {
+		entries, err := fs.ReadDir(scaffold, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			tmpl, err := template.ParseFS(scaffold, path.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			var file bytes.Buffer
+			if err := tmpl.Execute(&file, a); err != nil {
+				return nil, err
+			}
+			files[strings.TrimSuffix(entry.Name(), ".tmpl")] = file.Bytes()
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	errUnknownSchedule = errors.New("unknown schedule")
+	errInvalidSchedule = errors.New("invalid schedule")
+	errStillRunning    = errors.New("previous run has not finished")
+)
+
+// Schedule runs an agent on a cadence, such as re-crawling docs nightly
+// ("0 2 * * *") or re-analyzing a repository through the working day
+// ("*/30 9-18 * * mon-fri").
+type Schedule struct {
+	Name      string `json:"name"`
+	Cron      string `json:"cron"`
+	AgentType string `json:"agent_type"`
+	Target    string `json:"target"`
+	SessionID string `json:"session_id,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	// Priority defaults to background: nobody waits on a scheduled run.
+	Priority string `json:"priority,omitempty"`
+	Paused   bool   `json:"paused,omitempty"`
+}
+
+// ScheduleStatus is a schedule with its last run and next run.
+type ScheduleStatus struct {
+	Schedule
+	NextRunAt     *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`
+	LastJobID     string     `json:"last_job_id,omitempty"`
+	LastStatus    string     `json:"last_status,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	Runs          int        `json:"runs"`
+	Skipped       int        `json:"skipped"`
+	LastSkippedAt *time.Time `json:"last_skipped_at,omitempty"`
+}
+
+type scheduleEntry struct {
+	spec   cronSpec
+	status ScheduleStatus
+}
+
+// Schedules submits jobs when their schedules come due. A schedule never
+// overlaps itself: if its last job is still queued or running when it comes
+// due again, that run is skipped and counted.
+type Schedules struct {
+	jobs     *Scheduler
+	registry *Registry
+
+	mu      sync.Mutex
+	entries map[string]*scheduleEntry
+}
+
+func newSchedules(jobs *Scheduler, registry *Registry) *Schedules {
+	return &Schedules{jobs: jobs, registry: registry, entries: make(map[string]*scheduleEntry)}
+}
+
+// load adds the JSON array of schedules in path, if one is given.
+func (s *Schedules) load(path string) error {
+	if path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var schedules []Schedule
+	if err := json.Unmarshal(raw, &schedules); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, schedule := range schedules {
+		if _, err := s.Put(schedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put adds a schedule, or replaces one of the same name keeping its run
+// history.
+func (s *Schedules) Put(schedule Schedule) (ScheduleStatus, error) {
+	if !agentNamePattern.MatchString(schedule.Name) {
+		return ScheduleStatus{}, fmt.Errorf("%w: name %q must be lowercase letters, digits, _ and -", errInvalidSchedule, schedule.Name)
+	}
+	if schedule.Target == "" {
+		return ScheduleStatus{}, fmt.Errorf("%w: %s has no target", errInvalidSchedule, schedule.Name)
+	}
+	spec, err := parseCron(schedule.Cron)
+	if err != nil {
+		return ScheduleStatus{}, fmt.Errorf("%w: %s: %v", errInvalidSchedule, schedule.Name, err)
+	}
+	if schedule.Priority, err = parsePriority(schedule.Priority, priorityBackground); err != nil {
+		return ScheduleStatus{}, fmt.Errorf("%w: %s: %v", errInvalidSchedule, schedule.Name, err)
+	}
+	if schedule.Tenant != "" {
+		if err := checkTenant(schedule.Tenant); err != nil {
+			return ScheduleStatus{}, fmt.Errorf("%w: %s: %v", errInvalidSchedule, schedule.Name, err)
+		}
+	}
+	if schedule.AgentType == "" {
+		schedule.AgentType = "context_gatherer"
+	}
+	agent, err := s.registry.Get(schedule.AgentType)
+	if err != nil {
+		return ScheduleStatus{}, err
+	}
+	if err := agent.checkTarget(schedule.Target); err != nil {
+		return ScheduleStatus{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[schedule.Name]
+	if !ok {
+		entry = &scheduleEntry{}
+		s.entries[schedule.Name] = entry
+	}
+	entry.spec = spec
+	entry.status.Schedule = schedule
+	entry.status.NextRunAt = nil
+	if !schedule.Paused {
+		entry.status.NextRunAt = nextRun(spec, time.Now())
+	}
+	return s.view(entry), nil
+}
+
+func nextRun(spec cronSpec, after time.Time) *time.Time {
+	next := spec.next(after)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+func (s *Schedules) Get(name string) (ScheduleStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[name]
+	if !ok {
+		return ScheduleStatus{}, fmt.Errorf("%w: %s", errUnknownSchedule, name)
+	}
+	return s.view(entry), nil
+}
+
+func (s *Schedules) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.entries[name]
+	delete(s.entries, name)
+	return ok
+}
+
+// List returns the schedules sorted by name.
+func (s *Schedules) List() []ScheduleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := make([]ScheduleStatus, 0, len(s.entries))
+	for _, entry := range s.entries {
+		schedules = append(schedules, s.view(entry))
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	return schedules
+}
+
+// view is the entry's status with the last job's current state; s.mu must
+// be held.
+func (s *Schedules) view(entry *scheduleEntry) ScheduleStatus {
+	if job, ok := s.jobs.Get(entry.status.LastJobID); ok {
+		entry.status.LastStatus, entry.status.LastError = job.Status, job.Error
+	}
+	return entry.status
+}
+
+// running reports whether the entry's last job has yet to finish; s.mu must
+// be held.
+func (s *Schedules) running(entry *scheduleEntry) bool {
+	job, ok := s.jobs.Get(entry.status.LastJobID)
+	return ok && (job.Status == statusQueued || job.Status == statusRunning || job.Status == statusRetrying)
+}
+
+// Trigger runs a schedule now, outside its cadence, unless its last run is
+// still going.
+func (s *Schedules) Trigger(name string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[name]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", errUnknownSchedule, name)
+	}
+	if s.running(entry) {
+		return Job{}, fmt.Errorf("%w: %s is still on job %s", errStillRunning, name, entry.status.LastJobID)
+	}
+	return s.submit(entry, time.Now().UTC())
+}
+
+// submit queues the entry's job; s.mu must be held.
+func (s *Schedules) submit(entry *scheduleEntry, now time.Time) (Job, error) {
+	schedule := entry.status.Schedule
+	job, err := s.jobs.Submit(context.Background(), schedule.AgentType, schedule.Target, schedule.SessionID, schedule.Tenant, schedule.Priority)
+	entry.status.LastRunAt = &now
+	if err != nil {
+		entry.status.LastJobID, entry.status.LastStatus, entry.status.LastError = "", statusFailed, err.Error()
+		return Job{}, err
+	}
+	entry.status.Runs++
+	entry.status.LastJobID, entry.status.LastStatus, entry.status.LastError = job.ID, job.Status, ""
+	return job, nil
+}
+
+// tick fires every schedule that is due at now.
+func (s *Schedules) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		next := entry.status.NextRunAt
+		if entry.status.Paused || next == nil || next.After(now) {
+			continue
+		}
+		entry.status.NextRunAt = nextRun(entry.spec, now)
+		name := entry.status.Name
+		if s.running(entry) {
+			skipped := now.UTC()
+			entry.status.Skipped++
+			entry.status.LastSkippedAt = &skipped
+			log.Printf("schedule %s: skipped, job %s has not finished", name, entry.status.LastJobID)
+			continue
+		}
+		if _, err := s.submit(entry, now.UTC()); err != nil {
+			log.Printf("schedule %s: %v", name, err)
+		}
+	}
+}
+
+// Start checks for due schedules every second until ctx is done.
+func (s *Schedules) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.tick(now)
+			}
+		}
+	}()
+}
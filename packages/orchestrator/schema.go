@@ -0,0 +1,343 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// agentOutputSchema is the JSON Schema of what agents print and stream; the
+// MCP server validates streamed batches against the same file.
+//
+//go:embed schema/agent-output.schema.json
+var agentOutputSchema []byte
+
+var errInvalidOutput = errors.New("agent output does not match the agent output schema")
+
+// maxViolations caps how many violations one validation reports.
+const maxViolations = 50
+
+// Violation is one way a payload breaks the schema: where, as a JSON
+// pointer into the payload, and what is wrong there.
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// invalidOutputError is a payload that failed validation. It wraps
+// errInvalidOutput.
+type invalidOutputError struct {
+	definition string
+	violations []Violation
+}
+
+func (e *invalidOutputError) Error() string {
+	message := fmt.Sprintf("agent %s does not match the agent output schema: %s", strings.ReplaceAll(e.definition, "_", " "), e.violations[0])
+	if len(e.violations) > 1 {
+		message += fmt.Sprintf(" (and %d more)", len(e.violations)-1)
+	}
+	return message
+}
+
+func (e *invalidOutputError) Unwrap() error { return errInvalidOutput }
+
+// outputSchema validates payloads against the definitions of a JSON Schema.
+// It knows the keywords agent-output.schema.json uses: type, required,
+// properties, items, enum, const, pattern, minLength, minItems, minimum,
+// $ref to a definition, and if/then.
+type outputSchema struct {
+	definitions map[string]map[string]any
+	patterns    map[string]*regexp.Regexp
+}
+
+var agentOutput = mustLoadSchema(agentOutputSchema)
+
+func mustLoadSchema(raw []byte) *outputSchema {
+	var doc struct {
+		Definitions map[string]map[string]any `json:"definitions"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		panic(fmt.Sprintf("agent output schema: %v", err))
+	}
+	s, err := newSchema(doc.Definitions)
+	if err != nil {
+		panic(fmt.Sprintf("agent output schema: %v", err))
+	}
+	return s
+}
+
+// newSchema compiles every pattern in definitions up front, so a bad one is
+// found when the schema is loaded rather than when a payload is checked.
+func newSchema(definitions map[string]map[string]any) (*outputSchema, error) {
+	s := &outputSchema{definitions: definitions, patterns: make(map[string]*regexp.Regexp)}
+	for _, definition := range definitions {
+		if err := s.compilePatterns(definition); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *outputSchema) compilePatterns(schema any) error {
+	switch schema := schema.(type) {
+	case map[string]any:
+		for key, value := range schema {
+			if pattern, ok := value.(string); ok && key == "pattern" {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return fmt.Errorf("pattern %q: %w", pattern, err)
+				}
+				s.patterns[pattern] = re
+			} else if err := s.compilePatterns(value); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, value := range schema {
+			if err := s.compilePatterns(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Definitions lists the payload shapes the schema defines.
+func (s *outputSchema) Definitions() []string {
+	names := make([]string, 0, len(s.definitions))
+	for name := range s.definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks value, as decoded by encoding/json, against a definition.
+// It returns an *invalidOutputError listing at most maxViolations
+// violations, or nil.
+func (s *outputSchema) Validate(definition string, value any) error {
+	schema, ok := s.definitions[definition]
+	if !ok {
+		return fmt.Errorf("the agent output schema has no definition %q", definition)
+	}
+	var violations []Violation
+	s.check(schema, value, "", &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	return &invalidOutputError{definition: definition, violations: violations}
+}
+
+func (s *outputSchema) check(schema map[string]any, value any, path string, violations *[]Violation) {
+	if len(*violations) >= maxViolations {
+		return
+	}
+	fail := func(format string, args ...any) {
+		message := fmt.Sprintf(format, args...)
+		if description, ok := schema["description"].(string); ok {
+			message += " (" + lowerFirst(description) + ")"
+		}
+		*violations = append(*violations, Violation{Path: path, Message: message})
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, ok := s.definitions[strings.TrimPrefix(ref, "#/definitions/")]
+		if !ok {
+			fail("the schema refers to %s, which it does not define", ref)
+			return
+		}
+		s.check(target, value, path, violations)
+		return
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !matchesType(types, value) {
+		fail("is %s, want %s", jsonType(value), strings.Join(types, " or "))
+		return
+	}
+	if want, ok := schema["const"]; ok && !jsonEqual(want, value) {
+		fail("is %s, want %s", quoted(value), quoted(want))
+	}
+	if options, ok := schema["enum"].([]any); ok && !containsJSON(options, value) {
+		want := make([]string, len(options))
+		for i, option := range options {
+			want[i] = quoted(option)
+		}
+		fail("is %s, want one of %s", quoted(value), strings.Join(want, ", "))
+	}
+
+	switch value := value.(type) {
+	case string:
+		if minimum, ok := schema["minLength"].(float64); ok && float64(len([]rune(value))) < minimum {
+			if minimum == 1 {
+				fail("is empty")
+			} else {
+				fail("is shorter than %v characters", minimum)
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !s.patterns[pattern].MatchString(value) {
+			fail("%q does not match %s", value, pattern)
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && value < minimum {
+			fail("is %v, want at least %v", value, minimum)
+		}
+	case []any:
+		if minimum, ok := schema["minItems"].(float64); ok && float64(len(value)) < minimum {
+			if minimum == 1 {
+				fail("is empty, want at least one item")
+			} else {
+				fail("has %d items, want at least %v", len(value), minimum)
+			}
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				s.check(items, item, fmt.Sprintf("%s/%d", path, i), violations)
+			}
+		}
+	case map[string]any:
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if name, ok := name.(string); ok && !hasField(value, name) {
+					*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf("missing required field %q", name)})
+				}
+			}
+		}
+		if properties, ok := schema["properties"].(map[string]any); ok {
+			for _, name := range sortedFields(properties) {
+				field, ok := value[name]
+				if property, isSchema := properties[name].(map[string]any); ok && isSchema {
+					s.check(property, field, path+"/"+escapePointer(name), violations)
+				}
+			}
+		}
+	}
+
+	if condition, ok := schema["if"].(map[string]any); ok {
+		var ignored []Violation
+		s.check(condition, value, path, &ignored)
+		if then, ok := schema["then"].(map[string]any); ok && len(ignored) == 0 {
+			s.check(then, value, path, violations)
+		}
+	}
+	if len(*violations) > maxViolations {
+		*violations = (*violations)[:maxViolations]
+	}
+}
+
+func schemaTypes(raw any) []string {
+	switch raw := raw.(type) {
+	case string:
+		return []string{raw}
+	case []any:
+		types := make([]string, 0, len(raw))
+		for _, t := range raw {
+			if t, ok := t.(string); ok {
+				types = append(types, t)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesType(types []string, value any) bool {
+	actual := jsonType(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON type of a decoded value, telling integers from
+// other numbers as JSON Schema does.
+func jsonType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func jsonEqual(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func containsJSON(options []any, value any) bool {
+	for _, option := range options {
+		if jsonEqual(option, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// quoted shows a value in a message: strings quoted, anything else by its
+// type, since whole objects would drown the message.
+func quoted(value any) string {
+	switch value := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", value)
+	case nil, bool, float64:
+		raw, _ := json.Marshal(value)
+		return string(raw)
+	}
+	return "an " + jsonType(value)
+}
+
+func hasField(m map[string]any, name string) bool {
+	_, ok := m[name]
+	return ok
+}
+
+func sortedFields(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer escapes a field name for a JSON pointer (RFC 6901).
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// lowerFirst lowers a description's first letter to go mid-sentence,
+// unless it starts an acronym such as POST.
+func lowerFirst(s string) string {
+	if len(s) < 2 || unicode.IsUpper(rune(s[1])) {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secretKeyPattern is what a secret may be called in the store: a file name
+// with nothing that leaves the store's directory.
+var secretKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// agentTokenSecret is the token agents call the MCP server, session memory
+// and the knowledge graph with, when they enforce access control. Agents
+// get it as RBAC_TOKEN; the orchestrator's own RBAC_TOKEN, which it reports
+// with, never reaches them.
+const agentTokenSecret = "ORCH_AGENT_TOKEN"
+
+// SecretBinding gives an agent the store's secret From as its environment
+// variable Name, so two agent types can each get their own GITHUB_TOKEN. An
+// agent type writes one as "NAME", for the secret of the same name, or as
+// {"name", "from"}.
+type SecretBinding struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+}
+
+func (b SecretBinding) source() string {
+	return cmp.Or(b.From, b.Name)
+}
+
+func (b *SecretBinding) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &b.Name); err == nil {
+		b.From = ""
+		return nil
+	}
+	type binding SecretBinding
+	return json.Unmarshal(data, (*binding)(b))
+}
+
+func (b SecretBinding) MarshalJSON() ([]byte, error) {
+	if b.source() == b.Name {
+		return json.Marshal(b.Name)
+	}
+	type binding SecretBinding
+	return json.Marshal(binding(b))
+}
+
+// SecretStore resolves agents' secrets: from a file named for the secret,
+// as is or in lowercase, in dir, where the pipeline or Docker mounts them,
+// or else from the orchestrator's environment. Each agent sees only the
+// secrets bound to its own type.
+type SecretStore struct {
+	dir      string
+	registry *Registry
+}
+
+func newSecretStore(dir string, registry *Registry) *SecretStore {
+	return &SecretStore{dir: dir, registry: registry}
+}
+
+// Lookup finds a secret by its name in the store.
+func (s *SecretStore) Lookup(key string) (string, bool) {
+	if s.dir != "" && secretKeyPattern.MatchString(key) {
+		for _, name := range []string{key, strings.ToLower(key)} {
+			if data, err := os.ReadFile(filepath.Join(s.dir, name)); err == nil {
+				return strings.TrimRight(string(data), "\r\n"), true
+			}
+		}
+	}
+	return os.LookupEnv(key)
+}
+
+// Resolve is an agent's secrets by the variables they are bound to, leaving
+// out those the store does not have.
+func (s *SecretStore) Resolve(agent AgentType) map[string]string {
+	values := map[string]string{}
+	for _, binding := range agent.Secrets {
+		if value, ok := s.Lookup(binding.source()); ok {
+			values[binding.Name] = value
+		}
+	}
+	return values
+}
+
+// Environ is the environment to launch an agent with: the orchestrator's
+// own, less every variable that is or holds a secret of any agent type, and
+// with the agent's own secrets and the agent token.
+func (s *SecretStore) Environ(agent AgentType) []string {
+	hidden := map[string]bool{"RBAC_TOKEN": true, agentTokenSecret: true}
+	for _, other := range s.registry.List() {
+		for _, binding := range other.Secrets {
+			hidden[binding.Name], hidden[binding.source()] = true, true
+		}
+	}
+	if entries, err := os.ReadDir(s.dir); s.dir != "" && err == nil {
+		for _, entry := range entries {
+			hidden[entry.Name()], hidden[strings.ToUpper(entry.Name())] = true, true
+		}
+	}
+	var env []string
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if !hidden[name] {
+			env = append(env, variable)
+		}
+	}
+	values := s.Resolve(agent)
+	if token, ok := s.Lookup(agentTokenSecret); ok {
+		values["RBAC_TOKEN"] = token
+	}
+	for _, name := range sortedKeys(values) {
+		env = append(env, name+"="+values[name])
+	}
+	return env
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// server exposes the agent registry and manifests, jobs, agent runs, costs
+// and budgets, dead letters, fan-outs,
+// pipelines and schedules over HTTP.
+type server struct {
+	registry  *Registry
+	manifests *Manifests
+	scheduler *Scheduler
+	schedules *Schedules
+	fanOuts   *FanOuts
+	pipelines *Pipelines
+	runtime   Runtime
+	// config is where the settings come from, nil without a config
+	// service.
+	config *RemoteConfig
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", s.health)
+	mux.HandleFunc("GET /agents", s.listAgents)
+	mux.HandleFunc("POST /agents", s.registerAgent)
+	mux.HandleFunc("GET /agents/{name}", s.getAgent)
+	mux.HandleFunc("DELETE /agents/{name}", s.removeAgent)
+	mux.HandleFunc("GET /manifests", s.listManifests)
+	mux.HandleFunc("POST /manifests", s.installManifest)
+	mux.HandleFunc("POST /manifests/reload", s.reloadManifests)
+	mux.HandleFunc("POST /jobs", s.submitJob)
+	mux.HandleFunc("GET /jobs", s.listJobs)
+	mux.HandleFunc("GET /jobs/{id}", s.getJob)
+	mux.HandleFunc("GET /runs", s.listRuns)
+	mux.HandleFunc("GET /runs/stats", s.runStats)
+	mux.HandleFunc("GET /metrics", s.metrics)
+	mux.HandleFunc("GET /costs", s.costReport)
+	mux.HandleFunc("GET /budgets", s.listBudgets)
+	mux.HandleFunc("POST /budgets", s.putBudget)
+	mux.HandleFunc("DELETE /budgets/{name}", s.removeBudget)
+	mux.HandleFunc("GET /deadletters", s.listDeadLetters)
+	mux.HandleFunc("GET /deadletters/{id}", s.getDeadLetter)
+	mux.HandleFunc("DELETE /deadletters/{id}", s.removeDeadLetter)
+	mux.HandleFunc("POST /deadletters/{id}/requeue", s.requeueDeadLetter)
+	mux.HandleFunc("GET /quarantine", s.listQuarantined)
+	mux.HandleFunc("GET /quarantine/{id}", s.getQuarantined)
+	mux.HandleFunc("DELETE /quarantine/{id}", s.removeQuarantined)
+	mux.HandleFunc("GET /schema", s.getSchema)
+	mux.HandleFunc("POST /schema/{definition}/validate", s.validateOutput)
+	mux.HandleFunc("POST /fanouts", s.startFanOut)
+	mux.HandleFunc("GET /fanouts", s.listFanOuts)
+	mux.HandleFunc("GET /fanouts/{id}", s.getFanOut)
+	mux.HandleFunc("GET /pipelines", s.listPipelines)
+	mux.HandleFunc("POST /pipelines", s.putPipeline)
+	mux.HandleFunc("GET /pipelines/{name}", s.getPipeline)
+	mux.HandleFunc("DELETE /pipelines/{name}", s.removePipeline)
+	mux.HandleFunc("POST /pipelines/{name}/runs", s.startPipelineRun)
+	mux.HandleFunc("GET /pipelines/{name}/runs", s.listPipelineRuns)
+	mux.HandleFunc("GET /pipeline-runs/{id}", s.getPipelineRun)
+	mux.HandleFunc("GET /pipeline-runs/{id}/artifacts/{step}", s.getArtifact)
+	mux.HandleFunc("GET /schedules", s.listSchedules)
+	mux.HandleFunc("POST /schedules", s.putSchedule)
+	mux.HandleFunc("GET /schedules/{name}", s.getSchedule)
+	mux.HandleFunc("DELETE /schedules/{name}", s.removeSchedule)
+	mux.HandleFunc("POST /schedules/{name}/run", s.runSchedule)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]any{"detail": detail})
+}
+
+// decodeBody reads a JSON request body, answering 422 when it is malformed
+// or a required field is missing.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any, required ...string) bool {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid JSON body: "+err.Error())
+		return false
+	}
+	for _, name := range required {
+		if _, ok := fields[name]; !ok {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("field required: %s", name))
+			return false
+		}
+	}
+	encoded, _ := json.Marshal(fields)
+	if err := json.Unmarshal(encoded, v); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *server) health(w http.ResponseWriter, r *http.Request) {
+	var configVersion any
+	if s.config != nil {
+		configVersion = s.config.Version()
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":         "healthy",
+		"runtime":        s.runtime.Name(),
+		"agents":         len(s.registry.List()),
+		"jobs":           s.scheduler.Counts(),
+		"dead_letters":   len(s.scheduler.deadLetters.List()),
+		"quarantined":    len(s.scheduler.quarantine.List()),
+		"pipelines":      len(s.pipelines.List()),
+		"schedules":      len(s.schedules.List()),
+		"reporting":      s.scheduler.reporter.Enabled(),
+		"event_bus":      s.scheduler.reporter.bus != nil,
+		"config_version": configVersion,
+	})
+}
+
+func (s *server) listAgents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"agents": s.registry.List()})
+}
+
+func (s *server) registerAgent(w http.ResponseWriter, r *http.Request) {
+	var agent AgentType
+	if !decodeBody(w, r, &agent, "name") {
+		return
+	}
+	if err := s.registry.Register(agent); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, agent)
+}
+
+func (s *server) getAgent(w http.ResponseWriter, r *http.Request) {
+	agent, err := s.registry.Get(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, agent)
+}
+
+func (s *server) removeAgent(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.registry.Remove(name) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s: %s", errUnknownAgent, name))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"removed": name})
+}
+
+func (s *server) listManifests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"manifests": s.manifests.List()})
+}
+
+// installManifest loads a manifest posted in full, or pulls the one at
+// {"ref": ...} from an OCI registry.
+func (s *server) installManifest(w http.ResponseWriter, r *http.Request) {
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil || len(raw) > maxManifestSize {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("manifest body must be JSON of at most %d bytes", maxManifestSize))
+		return
+	}
+	var pull struct {
+		Ref string `json:"ref"`
+	}
+	json.Unmarshal(raw, &pull)
+	var agent AgentType
+	if pull.Ref != "" {
+		if agent, err = s.manifests.Pull(r.Context(), pull.Ref, false); err != nil && !errors.Is(err, errInvalidManifest) {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+	} else {
+		agent, err = s.manifests.Install("api", raw)
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, agent)
+}
+
+func (s *server) reloadManifests(w http.ResponseWriter, r *http.Request) {
+	if err := s.manifests.Reload(r.Context()); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"manifests": s.manifests.List()})
+}
+
+func (s *server) submitJob(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		AgentType string `json:"agent_type"`
+		Target    string `json:"target"`
+		SessionID string `json:"session_id"`
+		Tenant    string `json:"tenant"`
+		Priority  string `json:"priority"`
+	}
+	if !decodeBody(w, r, &request, "target") {
+		return
+	}
+	if request.AgentType == "" {
+		request.AgentType = "context_gatherer"
+	}
+	job, err := s.scheduler.Submit(r.Context(), request.AgentType, request.Target, request.SessionID, request.Tenant, request.Priority)
+	switch {
+	case errors.Is(err, errUnknownAgent):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errInvalidTarget), errors.Is(err, errInvalidPriority), errors.Is(err, errInvalidTenant):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errBudgetExceeded):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, errQueueFull):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": s.scheduler.List(r.URL.Query().Get("status"))})
+}
+
+func (s *server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.scheduler.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *server) listRuns(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := parseLimit(query.Get("limit"), 100, s.scheduler.telemetry.keep)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	runs := s.scheduler.telemetry.List(query.Get("agent_type"), query.Get("status"), query.Get("job_id"), limit)
+	writeJSON(w, http.StatusOK, map[string]any{"runs": runs})
+}
+
+func (s *server) runStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"agents": s.scheduler.telemetry.Stats()})
+}
+
+// metrics answers Prometheus with the agent run totals, the jobs by status
+// and the queue by priority.
+func (s *server) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.scheduler.telemetry.WriteMetrics(w)
+	counts := s.scheduler.Counts()
+	fmt.Fprint(w, "# HELP orchestrator_jobs Jobs in memory by status\n# TYPE orchestrator_jobs gauge\n")
+	for _, status := range []string{statusQueued, statusRunning, statusRetrying, statusSucceeded, statusFailed} {
+		fmt.Fprintf(w, "orchestrator_jobs{status=%q} %d\n", status, counts[status])
+	}
+	depth := s.scheduler.queue.Depth()
+	fmt.Fprint(w, "# HELP orchestrator_queue_depth Jobs waiting for a worker by priority\n# TYPE orchestrator_queue_depth gauge\n")
+	for _, priority := range []string{priorityInteractive, priorityNormal, priorityBackground} {
+		fmt.Fprintf(w, "orchestrator_queue_depth{priority=%q} %d\n", priority, depth[priority])
+	}
+	fmt.Fprint(w, "# HELP orchestrator_dead_letters Jobs in the dead letters\n# TYPE orchestrator_dead_letters gauge\n")
+	fmt.Fprintf(w, "orchestrator_dead_letters %d\n", len(s.scheduler.deadLetters.List()))
+	fmt.Fprint(w, "# HELP orchestrator_quarantined Quarantined results\n# TYPE orchestrator_quarantined gauge\n")
+	fmt.Fprintf(w, "orchestrator_quarantined %d\n", len(s.scheduler.quarantine.List()))
+	fmt.Fprint(w, "# HELP orchestrator_budget_paused Whether a budget is used up and its jobs refused\n# TYPE orchestrator_budget_paused gauge\n")
+	for _, budget := range s.scheduler.costs.Budgets() {
+		paused := 0
+		if budget.Paused {
+			paused = 1
+		}
+		fmt.Fprintf(w, "orchestrator_budget_paused{budget=%q} %d\n", budget.Name, paused)
+	}
+}
+
+// costReport sums usage from one day to another, YYYY-MM-DD and inclusive;
+// by default from the start of the month to today.
+func (s *server) costReport(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	from, to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), now
+	for name, day := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("%s must be a date such as 2024-01-31, got %q", name, value))
+			return
+		}
+		*day = parsed
+	}
+	lines, total := s.scheduler.costs.Report(from, to)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from": from.Format(time.DateOnly), "to": to.Format(time.DateOnly), "costs": lines, "total": total,
+	})
+}
+
+func (s *server) listBudgets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"budgets": s.scheduler.costs.Budgets()})
+}
+
+func (s *server) putBudget(w http.ResponseWriter, r *http.Request) {
+	var budget Budget
+	if !decodeBody(w, r, &budget, "name", "period") {
+		return
+	}
+	if err := s.scheduler.costs.Put(budget); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, budget)
+}
+
+func (s *server) removeBudget(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.scheduler.costs.Remove(name) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s: %s", errUnknownBudget, name))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"removed": name})
+}
+
+func (s *server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"dead_letters": s.scheduler.deadLetters.List()})
+}
+
+func (s *server) getDeadLetter(w http.ResponseWriter, r *http.Request) {
+	letter, err := s.scheduler.deadLetters.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, letter)
+}
+
+func (s *server) removeDeadLetter(w http.ResponseWriter, r *http.Request) {
+	letter, err := s.scheduler.deadLetters.Remove(r.PathValue("id"))
+	switch {
+	case errors.Is(err, errUnknownDeadLetter):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"removed": letter.JobID})
+	}
+}
+
+func (s *server) requeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	job, err := s.scheduler.Requeue(r.Context(), r.PathValue("id"))
+	switch {
+	case errors.Is(err, errUnknownDeadLetter), errors.Is(err, errUnknownAgent):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errInvalidTarget), errors.Is(err, errInvalidTenant):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errBudgetExceeded):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, errQueueFull):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+func (s *server) listQuarantined(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"quarantined": s.scheduler.quarantine.List()})
+}
+
+func (s *server) getQuarantined(w http.ResponseWriter, r *http.Request) {
+	result, err := s.scheduler.quarantine.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *server) removeQuarantined(w http.ResponseWriter, r *http.Request) {
+	result, err := s.scheduler.quarantine.Remove(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"removed": result.JobID})
+}
+
+func (s *server) getSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(agentOutputSchema)
+}
+
+// validateOutput checks a payload against one of the schema's definitions,
+// for agent authors to try their output before running it for real.
+func (s *server) validateOutput(w http.ResponseWriter, r *http.Request) {
+	definition := r.PathValue("definition")
+	if !slices.Contains(agentOutput.Definitions(), definition) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no schema definition %q; the definitions are %s",
+			definition, strings.Join(agentOutput.Definitions(), ", ")))
+		return
+	}
+	var payload any
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid JSON body: "+err.Error())
+		return
+	}
+	var invalid *invalidOutputError
+	if err := agentOutput.Validate(definition, payload); errors.As(err, &invalid) {
+		writeJSON(w, http.StatusOK, map[string]any{"valid": false, "violations": invalid.violations})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"valid": true, "violations": []Violation{}})
+}
+
+func (s *server) startFanOut(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		AgentType   string   `json:"agent_type"`
+		Targets     []string `json:"targets"`
+		Concurrency int      `json:"concurrency"`
+		SessionID   string   `json:"session_id"`
+		Tenant      string   `json:"tenant"`
+	}
+	if !decodeBody(w, r, &request, "targets") {
+		return
+	}
+	if request.AgentType == "" {
+		request.AgentType = "context_gatherer"
+	}
+	fanOut, err := s.fanOuts.Start(r.Context(), request.AgentType, request.Targets, request.Concurrency, request.SessionID, request.Tenant)
+	switch {
+	case errors.Is(err, errUnknownAgent):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errInvalidFanOut), errors.Is(err, errInvalidTenant):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errBudgetExceeded):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusAccepted, fanOut)
+	}
+}
+
+func (s *server) listFanOuts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"fanouts": s.fanOuts.List()})
+}
+
+func (s *server) getFanOut(w http.ResponseWriter, r *http.Request) {
+	fanOut, err := s.fanOuts.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, fanOut)
+}
+
+func (s *server) listPipelines(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"pipelines": s.pipelines.List()})
+}
+
+func (s *server) putPipeline(w http.ResponseWriter, r *http.Request) {
+	var pipeline Pipeline
+	if !decodeBody(w, r, &pipeline, "name", "steps") {
+		return
+	}
+	err := s.pipelines.Put(pipeline)
+	switch {
+	case errors.Is(err, errUnknownAgent):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		writeJSON(w, http.StatusCreated, pipeline)
+	}
+}
+
+func (s *server) getPipeline(w http.ResponseWriter, r *http.Request) {
+	pipeline, err := s.pipelines.Get(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, pipeline)
+}
+
+func (s *server) removePipeline(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.pipelines.Remove(name) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s: %s", errUnknownPipeline, name))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"removed": name})
+}
+
+func (s *server) startPipelineRun(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Target    string `json:"target"`
+		SessionID string `json:"session_id"`
+		Tenant    string `json:"tenant"`
+	}
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	run, err := s.pipelines.Start(r.Context(), r.PathValue("name"), request.Target, request.SessionID, request.Tenant)
+	if errors.Is(err, errInvalidTenant) {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *server) listPipelineRuns(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, err := s.pipelines.Get(name); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"runs": s.pipelines.Runs(name)})
+}
+
+func (s *server) getPipelineRun(w http.ResponseWriter, r *http.Request) {
+	run, err := s.pipelines.Run(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// getArtifact serves a step's saved result as it is on disk.
+func (s *server) getArtifact(w http.ResponseWriter, r *http.Request) {
+	artifact, err := s.pipelines.Artifact(r.PathValue("id"), r.PathValue("step"))
+	switch {
+	case errors.Is(err, errUnknownRun), errors.Is(err, errNoArtifact), errors.Is(err, os.ErrNotExist):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(artifact)
+	}
+}
+
+func (s *server) listSchedules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"schedules": s.schedules.List()})
+}
+
+func (s *server) putSchedule(w http.ResponseWriter, r *http.Request) {
+	var schedule Schedule
+	if !decodeBody(w, r, &schedule, "name", "cron", "target") {
+		return
+	}
+	status, err := s.schedules.Put(schedule)
+	switch {
+	case errors.Is(err, errUnknownAgent):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		writeJSON(w, http.StatusCreated, status)
+	}
+}
+
+func (s *server) getSchedule(w http.ResponseWriter, r *http.Request) {
+	status, err := s.schedules.Get(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *server) removeSchedule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.schedules.Remove(name) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s: %s", errUnknownSchedule, name))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"removed": name})
+}
+
+func (s *server) runSchedule(w http.ResponseWriter, r *http.Request) {
+	job, err := s.schedules.Trigger(r.PathValue("name"))
+	switch {
+	case errors.Is(err, errUnknownSchedule), errors.Is(err, errUnknownAgent):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errStillRunning):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errInvalidTarget), errors.Is(err, errInvalidTenant):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errBudgetExceeded):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, errQueueFull):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the run duration
+// histogram.
+var durationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600}
+
+// AgentRun is one attempt at a job: how long the agent ran, what it produced
+// and used, and how it ended. Items, BytesFetched, APICalls, the LLM tokens
+// and Cost come from the result's metrics when the agent reports them;
+// without them Items counts the entries of the lists in its context, and
+// Cost is priced from the rest. Retried is set when the job was tried again
+// after this attempt.
+type AgentRun struct {
+	JobID        string    `json:"job_id"`
+	AgentType    string    `json:"agent_type"`
+	Target       string    `json:"target"`
+	Tenant       string    `json:"tenant"`
+	Attempt      int       `json:"attempt"`
+	Status       string    `json:"status"`
+	Duration     float64   `json:"duration_seconds"`
+	Items        int       `json:"items"`
+	BytesFetched int64     `json:"bytes_fetched"`
+	APICalls     int       `json:"api_calls"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	Cost         float64   `json:"cost"`
+	Error        string    `json:"error,omitempty"`
+	Retried      bool      `json:"retried,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+}
+
+// AgentRunStats sums up an agent type's runs in the history: how often they
+// fail or are retried, and how long they take.
+type AgentRunStats struct {
+	AgentType    string  `json:"agent_type"`
+	Runs         int     `json:"runs"`
+	Failed       int     `json:"failed"`
+	Retried      int     `json:"retried"`
+	FailureRate  float64 `json:"failure_rate"`
+	MeanDuration float64 `json:"mean_duration_seconds"`
+	P50Duration  float64 `json:"p50_duration_seconds"`
+	P95Duration  float64 `json:"p95_duration_seconds"`
+	MaxDuration  float64 `json:"max_duration_seconds"`
+	Items        int     `json:"items"`
+	BytesFetched int64   `json:"bytes_fetched"`
+	APICalls     int     `json:"api_calls"`
+	Tokens       int     `json:"llm_tokens"`
+	Cost         float64 `json:"cost"`
+	LastError    string  `json:"last_error,omitempty"`
+}
+
+// agentTotals are an agent type's counters since the orchestrator started,
+// which /metrics exposes; unlike the history they are never trimmed.
+type agentTotals struct {
+	runs         map[string]int
+	retries      int
+	items        int
+	bytesFetched int64
+	apiCalls     int
+	inputTokens  int
+	outputTokens int
+	cost         float64
+	buckets      []int
+	durationSum  float64
+}
+
+// Telemetry keeps the last keep agent runs in memory for the run history
+// API, and running totals per agent type for /metrics.
+type Telemetry struct {
+	keep int
+
+	mu     sync.Mutex
+	runs   []AgentRun
+	totals map[string]*agentTotals
+}
+
+func newTelemetry(keep int) *Telemetry {
+	return &Telemetry{keep: keep, totals: make(map[string]*agentTotals)}
+}
+
+// Record adds a finished run.
+func (t *Telemetry) Record(run AgentRun) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.runs = append(t.runs, run)
+	if len(t.runs) > t.keep {
+		t.runs = append(t.runs[:0:0], t.runs[len(t.runs)-t.keep:]...)
+	}
+
+	totals, ok := t.totals[run.AgentType]
+	if !ok {
+		totals = &agentTotals{runs: make(map[string]int), buckets: make([]int, len(durationBuckets))}
+		t.totals[run.AgentType] = totals
+	}
+	totals.runs[run.Status]++
+	if run.Retried {
+		totals.retries++
+	}
+	totals.items += run.Items
+	totals.bytesFetched += run.BytesFetched
+	totals.apiCalls += run.APICalls
+	totals.inputTokens += run.InputTokens
+	totals.outputTokens += run.OutputTokens
+	totals.cost += run.Cost
+	totals.durationSum += run.Duration
+	for i, bound := range durationBuckets {
+		if run.Duration <= bound {
+			totals.buckets[i]++
+		}
+	}
+}
+
+// List returns runs newest first, filtered by agent type, status and job
+// when they are given, at most limit of them.
+func (t *Telemetry) List(agentType, status, jobID string, limit int) []AgentRun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	runs := []AgentRun{}
+	for i := len(t.runs) - 1; i >= 0 && len(runs) < limit; i-- {
+		run := t.runs[i]
+		if (agentType == "" || run.AgentType == agentType) && (status == "" || run.Status == status) &&
+			(jobID == "" || run.JobID == jobID) {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// Stats sums up the runs in the history by agent type, slowest first.
+func (t *Telemetry) Stats() []AgentRunStats {
+	t.mu.Lock()
+	byType := make(map[string][]AgentRun)
+	for _, run := range t.runs {
+		byType[run.AgentType] = append(byType[run.AgentType], run)
+	}
+	t.mu.Unlock()
+
+	stats := make([]AgentRunStats, 0, len(byType))
+	for agentType, runs := range byType {
+		s := AgentRunStats{AgentType: agentType, Runs: len(runs)}
+		durations := make([]float64, len(runs))
+		total := 0.0
+		for i, run := range runs {
+			durations[i] = run.Duration
+			total += run.Duration
+			if run.Status == statusFailed {
+				s.Failed++
+				s.LastError = run.Error
+			}
+			if run.Retried {
+				s.Retried++
+			}
+			s.Items += run.Items
+			s.BytesFetched += run.BytesFetched
+			s.APICalls += run.APICalls
+			s.Tokens += run.InputTokens + run.OutputTokens
+			s.Cost += run.Cost
+		}
+		sort.Float64s(durations)
+		s.FailureRate = round(float64(s.Failed) / float64(s.Runs))
+		s.MeanDuration = round(total / float64(s.Runs))
+		s.P50Duration = percentile(durations, 0.5)
+		s.P95Duration = percentile(durations, 0.95)
+		s.MaxDuration = durations[len(durations)-1]
+		s.Cost = round(s.Cost)
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].P95Duration > stats[j].P95Duration })
+	return stats
+}
+
+// WriteMetrics writes the totals in the Prometheus text exposition format.
+func (t *Telemetry) WriteMetrics(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	agentTypes := make([]string, 0, len(t.totals))
+	for agentType := range t.totals {
+		agentTypes = append(agentTypes, agentType)
+	}
+	sort.Strings(agentTypes)
+
+	metric := func(name, kind, help string, samples func(agentType string, totals *agentTotals)) {
+		fmt.Fprintf(w, "# HELP orchestrator_%s %s\n# TYPE orchestrator_%s %s\n", name, help, name, kind)
+		for _, agentType := range agentTypes {
+			samples(agentType, t.totals[agentType])
+		}
+	}
+	metric("agent_runs_total", "counter", "Agent runs by how they ended", func(agentType string, totals *agentTotals) {
+		for _, status := range []string{statusSucceeded, statusFailed} {
+			fmt.Fprintf(w, "orchestrator_agent_runs_total{agent_type=%q,status=%q} %d\n", agentType, status, totals.runs[status])
+		}
+	})
+	metric("agent_retries_total", "counter", "Agent runs that failed and were tried again", func(agentType string, totals *agentTotals) {
+		fmt.Fprintf(w, "orchestrator_agent_retries_total{agent_type=%q} %d\n", agentType, totals.retries)
+	})
+	metric("agent_items_total", "counter", "Context items agents produced", func(agentType string, totals *agentTotals) {
+		fmt.Fprintf(w, "orchestrator_agent_items_total{agent_type=%q} %d\n", agentType, totals.items)
+	})
+	metric("agent_bytes_fetched_total", "counter", "Bytes agents read from the network", func(agentType string, totals *agentTotals) {
+		fmt.Fprintf(w, "orchestrator_agent_bytes_fetched_total{agent_type=%q} %d\n", agentType, totals.bytesFetched)
+	})
+	metric("agent_api_calls_total", "counter", "External API calls agents made", func(agentType string, totals *agentTotals) {
+		fmt.Fprintf(w, "orchestrator_agent_api_calls_total{agent_type=%q} %d\n", agentType, totals.apiCalls)
+	})
+	metric("agent_llm_tokens_total", "counter", "LLM tokens agents used", func(agentType string, totals *agentTotals) {
+		fmt.Fprintf(w, "orchestrator_agent_llm_tokens_total{agent_type=%q,kind=\"input\"} %d\n", agentType, totals.inputTokens)
+		fmt.Fprintf(w, "orchestrator_agent_llm_tokens_total{agent_type=%q,kind=\"output\"} %d\n", agentType, totals.outputTokens)
+	})
+	metric("agent_cost_total", "counter", "What agent runs cost", func(agentType string, totals *agentTotals) {
+		fmt.Fprintf(w, "orchestrator_agent_cost_total{agent_type=%q} %g\n", agentType, round(totals.cost))
+	})
+	metric("agent_run_duration_seconds", "histogram", "How long agent runs took", func(agentType string, totals *agentTotals) {
+		count := 0
+		for _, n := range totals.runs {
+			count += n
+		}
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(w, "orchestrator_agent_run_duration_seconds_bucket{agent_type=%q,le=\"%g\"} %d\n", agentType, bound, totals.buckets[i])
+		}
+		fmt.Fprintf(w, "orchestrator_agent_run_duration_seconds_bucket{agent_type=%q,le=\"+Inf\"} %d\n", agentType, count)
+		fmt.Fprintf(w, "orchestrator_agent_run_duration_seconds_sum{agent_type=%q} %g\n", agentType, totals.durationSum)
+		fmt.Fprintf(w, "orchestrator_agent_run_duration_seconds_count{agent_type=%q} %d\n", agentType, count)
+	})
+}
+
+// readMetrics fills in what a result says the run produced and used.
+// Without its own count, the items are the entries of the lists in the
+// context, and of the lists in objects one level down.
+func (run *AgentRun) readMetrics(result map[string]any) {
+	metrics, _ := result["metrics"].(map[string]any)
+	number := func(m map[string]any, name string) float64 {
+		n, _ := m[name].(float64)
+		return n
+	}
+	tokens, _ := metrics["llm_tokens"].(map[string]any)
+	run.BytesFetched = int64(number(metrics, "bytes_fetched"))
+	run.APICalls = int(number(metrics, "api_calls"))
+	run.InputTokens, run.OutputTokens = int(number(tokens, "input")), int(number(tokens, "output"))
+	run.Cost = number(metrics, "cost")
+	if n, ok := metrics["items"].(float64); ok {
+		run.Items = int(n)
+		return
+	}
+	context, _ := result["context"].(map[string]any)
+	for _, value := range context {
+		switch value := value.(type) {
+		case []any:
+			run.Items += len(value)
+		case map[string]any:
+			for _, nested := range value {
+				if list, ok := nested.([]any); ok {
+					run.Items += len(list)
+				}
+			}
+		}
+	}
+}
+
+// percentile picks the nearest-rank percentile of sorted durations.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+// parseLimit reads a limit query parameter, capped at most.
+func parseLimit(value string, fallback, most int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("limit must be a positive integer, got %q", value)
+	}
+	return min(n, most), nil
+}
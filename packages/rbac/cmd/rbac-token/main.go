@@ -0,0 +1,57 @@
+// Command rbac-token issues a token for the services of the dynamic context
+// system, signed with RBAC_SECRET:
+//
+//	RBAC_SECRET=… rbac-token -role agent -sub orchestrator -ttl 720h
+//	RBAC_SECRET=… rbac-token -role agent -sub team-a-agents -tenant team-a
+//
+// The role must be one the policy at RBAC_POLICY, or the default policy,
+// defines.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jayp41/dynamic-context-mcp-system/packages/rbac"
+)
+
+func main() {
+	role := flag.String("role", "", "the bearer's role, such as admin, operator, agent or read-only")
+	subject := flag.String("sub", "", "who or what the token is for")
+	tenant := flag.String("tenant", "", "the only tenant the token may act for; empty for any")
+	ttl := flag.Duration("ttl", 0, "how long the token lasts; 0 never expires")
+	flag.Parse()
+	log.SetFlags(0)
+
+	secret := os.Getenv("RBAC_SECRET")
+	if secret == "" {
+		log.Fatal("rbac-token: RBAC_SECRET is not set")
+	}
+	policy, err := rbac.ParsePolicy(rbac.DefaultPolicy)
+	if path := os.Getenv("RBAC_POLICY"); path != "" {
+		policy, err = rbac.LoadPolicy(path)
+	}
+	if err != nil {
+		log.Fatalf("rbac-token: %v", err)
+	}
+	if _, ok := policy.Roles[*role]; !ok {
+		log.Fatalf("rbac-token: the policy has no role %q", *role)
+	}
+
+	if *tenant != "" && !rbac.ValidTenant(*tenant) {
+		log.Fatalf("rbac-token: %v", rbac.ErrInvalidTenant)
+	}
+
+	claims := rbac.Claims{Subject: *subject, Role: *role, Tenant: *tenant}
+	if *ttl > 0 {
+		claims.Expires = time.Now().Add(*ttl).Unix()
+	}
+	token, err := rbac.Mint([]byte(secret), claims)
+	if err != nil {
+		log.Fatalf("rbac-token: %v", err)
+	}
+	fmt.Println(token)
+}
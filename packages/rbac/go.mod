@@ -0,0 +1,3 @@
+module github.com/jayp41/dynamic-context-mcp-system/packages/rbac
+
+go 1.22
@@ -0,0 +1,116 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Authorizer enforces a policy on the requests to one service.
+type Authorizer struct {
+	service string
+	secret  []byte
+	policy  *Policy
+	now     func() time.Time
+}
+
+// NewAuthorizer enforces policy on service, such as "graph", for tokens
+// signed with secret.
+func NewAuthorizer(service string, secret []byte, policy *Policy) *Authorizer {
+	return &Authorizer{service: service, secret: secret, policy: policy, now: time.Now}
+}
+
+// FromEnv is the authorizer RBAC_SECRET and RBAC_POLICY configure for
+// service, or nil with neither set, when access is not controlled. One
+// without the other is an error, so a service is never left open by a
+// missing file.
+func FromEnv(service string) (*Authorizer, error) {
+	secret, path := os.Getenv("RBAC_SECRET"), os.Getenv("RBAC_POLICY")
+	switch {
+	case secret == "" && path == "":
+		return nil, nil
+	case secret == "":
+		return nil, errors.New("RBAC_POLICY is set without RBAC_SECRET")
+	case path == "":
+		return nil, errors.New("RBAC_SECRET is set without RBAC_POLICY")
+	}
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading the RBAC policy: %w", err)
+	}
+	return NewAuthorizer(service, []byte(secret), policy), nil
+}
+
+// Error is a request the policy refuses, with the HTTP status to answer.
+type Error struct {
+	Status int
+	Err    error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Authorize checks a request's bearer token against the policy. A public
+// route needs none, and gets zero claims. A refused request's error is an
+// *Error, with the bearer's claims when the token is valid.
+func (a *Authorizer) Authorize(r *http.Request) (Claims, error) {
+	if a.policy.IsPublic(r.Method, r.URL.Path) {
+		return Claims{}, nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return Claims{}, &Error{http.StatusUnauthorized, ErrMissingToken}
+	}
+	claims, err := Verify(a.secret, token, a.now())
+	if err != nil {
+		return Claims{}, &Error{http.StatusUnauthorized, err}
+	}
+	action := a.policy.Action(a.service, r.Method, r.URL.Path)
+	if !a.policy.Allows(claims.Role, action) {
+		return claims, &Error{http.StatusForbidden, fmt.Errorf("role %s may not %s here", claims.Role, action)}
+	}
+	return claims, nil
+}
+
+type claimsKey struct{}
+
+// ClaimsFrom are the claims Middleware found on a request, if any.
+func ClaimsFrom(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// Middleware answers 401 or 403, with the same {"detail": ...} body as the
+// Python services, to requests the policy refuses. A valid token's refusals
+// are logged, with its subject and role.
+func (a *Authorizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := a.Authorize(r)
+		if err != nil {
+			var refused *Error
+			errors.As(err, &refused)
+			if refused.Status == http.StatusForbidden {
+				who := claims.Role
+				if claims.Subject != "" {
+					who = claims.Subject + " (" + claims.Role + ")"
+				}
+				log.Printf("rbac: refused %s %s to %s", r.Method, r.URL.Path, who)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if refused.Status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			w.WriteHeader(refused.Status)
+			json.NewEncoder(w).Encode(map[string]any{"detail": err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+	})
+}
@@ -0,0 +1,169 @@
+package rbac
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The actions a policy grants. A request is one of them: read for GET and
+// HEAD, delete for DELETE and write for the other methods, unless a rule
+// says otherwise.
+const (
+	ActionRead    = "read"
+	ActionWrite   = "write"
+	ActionDelete  = "delete"
+	ActionOperate = "operate"
+	ActionAdmin   = "admin"
+)
+
+var actions = []string{ActionRead, ActionWrite, ActionDelete, ActionOperate, ActionAdmin}
+
+// DefaultPolicy is policy.json: the four roles, and the rules for the
+// endpoints of the MCP server, the knowledge graph and session memory that
+// do more than their method says.
+//
+//go:embed policy.json
+var DefaultPolicy []byte
+
+// Policy is what each role may do.
+type Policy struct {
+	// Roles are the actions each role is granted; "*" is every action.
+	Roles map[string][]string `json:"roles"`
+	// Public are routes that need no token, such as "GET /health".
+	Public []string `json:"public"`
+	// Rules give routes an action other than their method's. The first
+	// that matches wins.
+	Rules []Rule `json:"rules"`
+
+	public []*route
+}
+
+// Rule is the action a route takes on a service, or on every service when
+// Service is empty.
+type Rule struct {
+	Service string `json:"service,omitempty"`
+	Route   string `json:"route"`
+	Action  string `json:"action"`
+
+	route *route
+}
+
+// route is a "METHOD /path" pattern. The method may be "*" for any; in the
+// path, "*" matches within one segment and "**" across any number.
+type route struct {
+	method string
+	path   *regexp.Regexp
+}
+
+func parseRoute(pattern string) (*route, error) {
+	method, path, ok := strings.Cut(strings.TrimSpace(pattern), " ")
+	path = strings.TrimSpace(path)
+	if !ok || method == "" || !strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "**") {
+		return nil, fmt.Errorf("route %q is not \"METHOD /path\"", pattern)
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(path); i++ {
+		switch {
+		case strings.HasPrefix(path[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case path[i] == '*':
+			expr.WriteString("[^/]*")
+		default:
+			expr.WriteString(regexp.QuoteMeta(path[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return &route{method: strings.ToUpper(method), path: regexp.MustCompile(expr.String())}, nil
+}
+
+func (r *route) matches(method, path string) bool {
+	return (r.method == "*" || r.method == method) && r.path.MatchString(path)
+}
+
+// ParsePolicy reads a policy and checks that it names only known actions.
+func ParsePolicy(raw []byte) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, err
+	}
+	if len(p.Roles) == 0 {
+		return nil, fmt.Errorf("the policy has no roles")
+	}
+	for role, granted := range p.Roles {
+		for _, action := range granted {
+			if action != "*" && !slices.Contains(actions, action) {
+				return nil, fmt.Errorf("role %s: unknown action %q", role, action)
+			}
+		}
+	}
+	for _, pattern := range p.Public {
+		r, err := parseRoute(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("public: %w", err)
+		}
+		p.public = append(p.public, r)
+	}
+	for i, rule := range p.Rules {
+		if !slices.Contains(actions, rule.Action) {
+			return nil, fmt.Errorf("rule %d: unknown action %q", i+1, rule.Action)
+		}
+		r, err := parseRoute(rule.Route)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		p.Rules[i].route = r
+	}
+	return &p, nil
+}
+
+// LoadPolicy reads a policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParsePolicy(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// IsPublic reports whether a request needs no token.
+func (p *Policy) IsPublic(method, path string) bool {
+	for _, r := range p.public {
+		if r.matches(method, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Action is what a request to a service does.
+func (p *Policy) Action(service, method, path string) string {
+	for _, rule := range p.Rules {
+		if (rule.Service == "" || rule.Service == service) && rule.route.matches(method, path) {
+			return rule.Action
+		}
+	}
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return ActionRead
+	case "DELETE":
+		return ActionDelete
+	}
+	return ActionWrite
+}
+
+// Allows reports whether a role is granted an action.
+func (p *Policy) Allows(role, action string) bool {
+	granted := p.Roles[role]
+	return slices.Contains(granted, "*") || slices.Contains(granted, action)
+}
@@ -0,0 +1,70 @@
+package rbac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// TenantHeader names the tenant a request is for. Each team a deployment
+// serves is a tenant, whose context, graphs and sessions the others never
+// see.
+const TenantHeader = "X-Tenant-ID"
+
+// DefaultTenant is the tenant of requests that name none, so a deployment
+// with one team needs no tenant IDs at all.
+const DefaultTenant = "default"
+
+// ErrInvalidTenant is a tenant ID that is not 1-32 lowercase letters and
+// digits in words joined by single hyphens, such as "team-a".
+var ErrInvalidTenant = errors.New("tenant IDs are 1-32 lowercase letters and digits, in words joined by single hyphens")
+
+var tenantPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ValidTenant reports whether a tenant ID is well formed. The form keeps
+// IDs safe in key names, graph labels and collection names.
+func ValidTenant(tenant string) bool {
+	return len(tenant) <= 32 && tenantPattern.MatchString(tenant)
+}
+
+// ResolveTenant is the tenant a request is for: its X-Tenant-ID, or else
+// the tenant its token is bound to, or else DefaultTenant. A token bound to
+// a tenant may not name another; one that is not, such as an operator's,
+// may act for any.
+func ResolveTenant(r *http.Request, claims Claims) (string, error) {
+	tenant := r.Header.Get(TenantHeader)
+	if claims.Tenant != "" {
+		if tenant != "" && tenant != claims.Tenant {
+			return "", &Error{http.StatusForbidden, fmt.Errorf("the token is for tenant %s", claims.Tenant)}
+		}
+		tenant = claims.Tenant
+	}
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	if !ValidTenant(tenant) {
+		return "", &Error{http.StatusBadRequest, ErrInvalidTenant}
+	}
+	return tenant, nil
+}
+
+// Tenants resolves each request's tenant, against the claims Middleware
+// found when access is controlled, and sets X-Tenant-ID to it for the
+// handlers. Requests for a tenant they may not act for get 400 or 403.
+func Tenants(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFrom(r.Context())
+		tenant, err := ResolveTenant(r, claims)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(err.(*Error).Status)
+			json.NewEncoder(w).Encode(map[string]any{"detail": err.Error()})
+			return
+		}
+		r = r.Clone(r.Context())
+		r.Header.Set(TenantHeader, tenant)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+// Package rbac is the access control the services of the dynamic context
+// system share: the roles a caller may have, the token that carries a role
+// and the policy file that says what each role may do, so the MCP server,
+// the knowledge graph and session memory enforce the same rules.
+//
+// A token is "v1.<claims>.<signature>": the claims as base64url JSON and an
+// HMAC-SHA256 of "v1.<claims>" under the secret the services share. The
+// Python services and the MCP server read and write the same tokens.
+package rbac
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The roles of the default policy.
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleAgent    = "agent"
+	RoleReadOnly = "read-only"
+)
+
+// tokenVersion prefixes every token, so the format can change.
+const tokenVersion = "v1"
+
+var (
+	ErrMissingToken = errors.New("a bearer token is required")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Claims are what a token says about its bearer.
+type Claims struct {
+	// Subject names the bearer, such as a user or a service, for logs.
+	Subject string `json:"sub"`
+	Role    string `json:"role"`
+	// Tenant binds the token to one tenant; empty may act for any.
+	Tenant string `json:"tenant,omitempty"`
+	// Expires is a Unix time; zero never expires.
+	Expires int64 `json:"exp,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return encoding.EncodeToString(mac.Sum(nil))
+}
+
+// Mint issues a token for claims.
+func Mint(secret []byte, claims Claims) (string, error) {
+	if claims.Role == "" {
+		return "", errors.New("a token needs a role")
+	}
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := tokenVersion + "." + encoding.EncodeToString(raw)
+	return payload + "." + sign(secret, payload), nil
+}
+
+// Verify checks a token's signature and expiry as of now and returns its
+// claims.
+func Verify(secret []byte, token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenVersion {
+		return Claims{}, ErrInvalidToken
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(sign(secret, payload)), []byte(parts[2])) {
+		return Claims{}, ErrInvalidToken
+	}
+	raw, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.Role == "" {
+		return Claims{}, ErrInvalidToken
+	}
+	if claims.Expires != 0 && now.Unix() >= claims.Expires {
+		return Claims{}, fmt.Errorf("%w: %s", ErrExpiredToken, time.Unix(claims.Expires, 0).UTC().Format(time.RFC3339))
+	}
+	return claims, nil
+}
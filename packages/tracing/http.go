@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Header carries trace context over HTTP and in Socket.IO handshakes.
+const Header = "traceparent"
+
+// EnvVar carries trace context to a process, such as an agent the
+// orchestrator launches.
+const EnvVar = "TRACEPARENT"
+
+// Inject puts the current span of ctx in h, if there is one.
+func Inject(ctx context.Context, h http.Header) {
+	if value := SpanContextFrom(ctx).Traceparent(); value != "" {
+		h.Set(Header, value)
+	}
+}
+
+// Extract is ctx with the span context in h as the remote parent, if h
+// has a valid one.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, ok := ParseTraceparent(h.Get(Header))
+	if !ok {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// FromProcessEnv is ctx with TRACEPARENT as the remote parent, for a
+// process started inside a trace.
+func FromProcessEnv(ctx context.Context) context.Context {
+	sc, ok := ParseTraceparent(os.Getenv(EnvVar))
+	if !ok {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// Middleware gives every request a server span, the child of its
+// traceparent, and leaves it as the current span of the request's context.
+// GET /health and GET /metrics are not traced, since probes would bury the
+// traces worth reading.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && (r.URL.Path == "/health" || r.URL.Path == "/metrics") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := t.Start(Extract(r.Context(), r.Header), r.Method+" "+r.URL.Path, KindServer)
+		defer span.End()
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		span.SetAttribute("http.response.status_code", recorder.status)
+		if recorder.status >= 500 {
+			span.Fail(strconv.Itoa(recorder.status) + " " + http.StatusText(recorder.status))
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming responses streaming.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Transport gives every request a client span, the child of the current
+// span of its context, and sends it on as the traceparent. A nil base is
+// http.DefaultTransport.
+func (t *Tracer) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{tracer: t, base: base}
+}
+
+type transport struct {
+	tracer *Tracer
+	base   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(r.Context(), r.Method+" "+r.URL.Host+r.URL.Path, KindClient)
+	defer span.End()
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.full", r.URL.Redacted())
+	// A RoundTripper must not change the request it is given
+	r = r.Clone(ctx)
+	Inject(ctx, r.Header)
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.Fail(resp.Status)
+	}
+	return resp, nil
+}
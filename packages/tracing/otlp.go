@@ -0,0 +1,217 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// batchSize spans are sent at once, at the latest every flushInterval.
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+	// maxQueued spans wait for the collector; past that, new ones are
+	// dropped rather than held.
+	maxQueued = 4096
+)
+
+// FromEnv is the tracer for service, which OTEL_SERVICE_NAME overrides.
+// Spans go to OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or to /v1/traces under
+// OTEL_EXPORTER_OTLP_ENDPOINT. With neither set nothing is exported, but
+// trace context still passes through, so an untraced service does not
+// break the traces of the others.
+func FromEnv(service string) *Tracer {
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		service = name
+	}
+	url := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if url == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		url = strings.TrimRight(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/") + "/v1/traces"
+	}
+	return New(service, url)
+}
+
+// New is a tracer for service exporting to the OTLP/HTTP traces endpoint
+// url, or exporting nothing when url is empty.
+func New(service, url string) *Tracer {
+	e := &exporter{service: service, url: url, client: &http.Client{Timeout: 10 * time.Second},
+		flush: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	if url != "" {
+		go e.run()
+	} else {
+		close(e.done)
+	}
+	return &Tracer{service: service, exporter: e}
+}
+
+// Shutdown sends the spans still queued.
+func (t *Tracer) Shutdown(ctx context.Context) {
+	t.exporter.shutdown(ctx)
+}
+
+type exporter struct {
+	service string
+	url     string
+	client  *http.Client
+
+	mu      sync.Mutex
+	queued  []*Span
+	dropped int
+
+	flush chan struct{}
+	stop  chan struct{}
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (e *exporter) add(span *Span) {
+	if e.url == "" {
+		return
+	}
+	e.mu.Lock()
+	if len(e.queued) >= maxQueued {
+		e.dropped++
+		e.mu.Unlock()
+		return
+	}
+	e.queued = append(e.queued, span)
+	full := len(e.queued) >= batchSize
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.send()
+		case <-e.flush:
+			e.send()
+		case <-e.stop:
+			e.send()
+			return
+		}
+	}
+}
+
+func (e *exporter) shutdown(ctx context.Context) {
+	e.once.Do(func() {
+		if e.url != "" {
+			close(e.stop)
+		}
+	})
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+	}
+}
+
+// send posts everything queued, a batch at a time. A collector that cannot
+// be reached costs those spans, not the service.
+func (e *exporter) send() {
+	for {
+		e.mu.Lock()
+		n := min(len(e.queued), batchSize)
+		batch := e.queued[:n:n]
+		e.queued = e.queued[n:]
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+		if dropped > 0 {
+			log.Printf("tracing: dropped %d spans while the collector was behind", dropped)
+		}
+		if n == 0 {
+			return
+		}
+		if err := e.post(batch); err != nil {
+			log.Printf("tracing: could not export %d spans: %v", n, err)
+		}
+	}
+}
+
+func (e *exporter) post(spans []*Span) error {
+	body, err := json.Marshal(otlpRequest(e.service, spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// otlpRequest is an ExportTraceServiceRequest in OTLP's JSON encoding,
+// where IDs are hex and 64-bit integers strings.
+func otlpRequest(service string, spans []*Span) map[string]any {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		s := map[string]any{
+			"traceId":           span.context.TraceIDString(),
+			"spanId":            span.context.SpanIDString(),
+			"name":              span.name,
+			"kind":              int(span.kind),
+			"startTimeUnixNano": strconv.FormatInt(span.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.endTime.UnixNano(), 10),
+			"attributes":        attributes(span.attrs),
+		}
+		if span.parent != [8]byte{} {
+			s["parentSpanId"] = SpanContext{SpanID: span.parent}.SpanIDString()
+		}
+		if span.failed {
+			s["status"] = map[string]any{"code": 2, "message": span.err}
+		}
+		span.mu.Unlock()
+		encoded = append(encoded, s)
+	}
+	return map[string]any{"resourceSpans": []any{map[string]any{
+		"resource":   map[string]any{"attributes": attributes(map[string]any{"service.name": service})},
+		"scopeSpans": []any{map[string]any{"scope": map[string]any{"name": "dynamic-context/tracing"}, "spans": encoded}},
+	}}}
+}
+
+func attributes(attrs map[string]any) []map[string]any {
+	encoded := make([]map[string]any, 0, len(attrs))
+	for key, value := range attrs {
+		var v map[string]any
+		switch value := value.(type) {
+		case string:
+			v = map[string]any{"stringValue": value}
+		case bool:
+			v = map[string]any{"boolValue": value}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = map[string]any{"doubleValue": value}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(value)}
+		}
+		encoded = append(encoded, map[string]any{"key": key, "value": v})
+	}
+	return encoded
+}
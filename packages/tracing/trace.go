@@ -0,0 +1,213 @@
+// Package tracing is the distributed tracing the components of the dynamic
+// context system share, so one "gather context" request can be followed
+// from the orchestrator through the agent it launches, the MCP server,
+// session memory and the knowledge graph.
+//
+// Spans are OpenTelemetry spans, exported as OTLP/HTTP JSON to the
+// collector OTEL_EXPORTER_OTLP_ENDPOINT names, and trace context travels
+// as a W3C traceparent: in HTTP and Socket.IO headers, in the envelope of
+// bus events and, to the agents the orchestrator launches, in TRACEPARENT.
+// The Python services and agents use tracing.py, and the MCP server
+// tracing.js, which speak the same formats.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// Valid reports whether the trace and span IDs are set, as the W3C spec
+// requires.
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+func (sc SpanContext) TraceIDString() string { return hex.EncodeToString(sc.TraceID[:]) }
+
+func (sc SpanContext) SpanIDString() string { return hex.EncodeToString(sc.SpanID[:]) }
+
+// Traceparent is the W3C header value, "00-<trace>-<span>-<flags>"; empty
+// for an invalid span context.
+func (sc SpanContext) Traceparent() string {
+	if !sc.Valid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceIDString() + "-" + sc.SpanIDString() + "-" + flags
+}
+
+// ParseTraceparent reads a W3C traceparent. Versions after 00 are read as
+// 00, as the spec asks.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	trace, err := hex.DecodeString(parts[1])
+	if err != nil || len(trace) != 16 {
+		return SpanContext{}, false
+	}
+	span, err := hex.DecodeString(parts[2])
+	if err != nil || len(span) != 8 {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return SpanContext{}, false
+	}
+	copy(sc.TraceID[:], trace)
+	copy(sc.SpanID[:], span)
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.Valid()
+}
+
+// Kind is what part a span plays, as in OTLP.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+	KindProducer Kind = 4
+	KindConsumer Kind = 5
+)
+
+// Span is one timed operation. Its methods are safe on a nil span, which
+// is what an unexported trace gets.
+type Span struct {
+	tracer  *Tracer
+	name    string
+	kind    Kind
+	context SpanContext
+	parent  [8]byte
+	start   time.Time
+
+	mu      sync.Mutex
+	attrs   map[string]any
+	err     string
+	failed  bool
+	ended   bool
+	endTime time.Time
+}
+
+// Context is the span's own span context, to propagate.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute records a string, bool, integer or float attribute.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// RecordError marks the span as failed, with err's message.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Fail(err.Error())
+}
+
+// Fail marks the span as failed.
+func (s *Span) Fail(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed, s.err = true, message
+}
+
+// End finishes the span and hands it to the exporter; only the first call
+// counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.endTime = true, time.Now()
+	s.mu.Unlock()
+	if s.context.Sampled {
+		s.tracer.exporter.add(s)
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpanContext carries a remote span context, such as one read
+// from a header, as the parent of the spans started from ctx.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.Valid() {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanContextFrom is the span context of the current span in ctx, local or
+// remote; invalid when there is none.
+func SpanContextFrom(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanKey{}).(SpanContext)
+	return sc
+}
+
+// Tracer starts spans for one service.
+type Tracer struct {
+	service  string
+	exporter *exporter
+}
+
+// Start begins a span, the child of the span in ctx or else the root of a
+// new trace, and returns ctx with it as the current span.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := SpanContextFrom(ctx)
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: true}
+	if parent.Valid() {
+		sc.Sampled = parent.Sampled
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+	span := &Span{tracer: t, name: name, kind: kind, context: sc, start: time.Now(), attrs: map[string]any{}}
+	if parent.Valid() {
+		span.parent = parent.SpanID
+	}
+	return context.WithValue(ctx, spanKey{}, sc), span
+}
+
+// Service is the service.name spans are exported under.
+func (t *Tracer) Service() string { return t.service }
+
+func (t *Tracer) String() string {
+	if t.exporter.url == "" {
+		return fmt.Sprintf("tracing %s, not exported", t.service)
+	}
+	return fmt.Sprintf("tracing %s to %s", t.service, t.exporter.url)
+}